@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/bench"
+	"github.com/bluguard/dnshield/internal/dns/client/pause"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/policytest"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/server"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	blockparser "github.com/bluguard/dnshield/internal/dns/util/blockParser"
+	"github.com/bluguard/dnshield/internal/dns/zonefile"
+)
+
+// runCommand runs the operational command given on the command line instead of starting the server
+func runCommand(conf configuration.ServerConf, args []string) {
+	switch args[0] {
+	case "test-domain":
+		testDomain(conf, args[1:])
+	case "export-log":
+		exportLog(conf, args[1:])
+	case "purge":
+		purge(conf, args[1:])
+	case "self-test":
+		selfTest(conf, args[1:])
+	case "export-zone":
+		exportZone(conf, args[1:])
+	case "maintenance":
+		setMaintenance(conf, args[1:])
+	case "offline":
+		setOffline(conf, args[1:])
+	case "pause":
+		pauseBlocking(conf, args[1:])
+	case "policy-test":
+		policyTest(conf, args[1:])
+	case "snapshots":
+		manageSnapshots(conf, args[1:])
+	case "flush-cache":
+		flushCache(conf, args[1:])
+	case "block":
+		editRule(conf, admin.BlockRule, args[1:])
+	case "allow":
+		editRule(conf, admin.AllowRule, args[1:])
+	case "query":
+		query(conf, args[1:], false)
+	case "dig":
+		query(conf, args[1:], true)
+	case "bench":
+		benchmark(conf, args[1:])
+	default:
+		log.Fatal("unknown command ", args[0])
+	}
+}
+
+// testDomain prints whether the names would be blocked by the configuration
+func testDomain(conf configuration.ServerConf, names []string) {
+	if len(names) == 0 {
+		log.Fatal("usage: dnshield test-domain <name>...")
+	}
+	for _, name := range names {
+		verdict := server.TestDomain(conf, name)
+		if !verdict.Blocked {
+			fmt.Println(verdict.Name, "is not blocked")
+			continue
+		}
+		fmt.Println(verdict.Name, "is blocked by", verdict.Rule)
+		for _, answer := range verdict.Answers {
+			fmt.Println("  ", answer)
+		}
+	}
+}
+
+// policyTest replays the scenarios of the file against the policy of the configuration, it exits with an error
+// when one of them fails, so the rules can be checked by a CI
+func policyTest(conf configuration.ServerConf, args []string) {
+	flags := flag.NewFlagSet("policy-test", flag.ExitOnError)
+	path := flags.String("file", "", "yaml list of the scenarios, with their client, qname, qtype, expect and answer")
+	_ = flags.Parse(args)
+
+	if *path == "" {
+		log.Fatal("usage: dnshield policy-test -file <scenarios.yaml>")
+	}
+	file, err := os.Open(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scenarios, err := policytest.Load(file)
+	_ = file.Close()
+	if err != nil {
+		log.Fatal("invalid scenarios: ", err)
+	}
+	results, err := server.PolicyTest(conf, scenarios)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Passed() {
+			fmt.Println("PASS", result)
+			continue
+		}
+		failed++
+		fmt.Println("FAIL", result)
+	}
+	fmt.Println(len(results)-failed, "of", len(results), "scenarios passed")
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// exportLog writes the entries of the query log matching the flags to the standard output
+func exportLog(conf configuration.ServerConf, args []string) {
+	flags := flag.NewFlagSet("export-log", flag.ExitOnError)
+	from := flags.String("from", "", "first time exported, RFC 3339")
+	to := flags.String("to", "", "time the export stops at, RFC 3339")
+	fields := flags.String("fields", "", "comma separated columns among "+fmt.Sprint(querylog.Fields)+", all when empty")
+	anonymize := flags.Bool("anonymize", false, "truncate the client addresses to their network")
+	format := flags.String("format", "csv", "output format, csv or parquet")
+	_ = flags.Parse(args)
+
+	if conf.QueryLog.Path == "" {
+		log.Fatal("the query log is not enabled in the configuration")
+	}
+	opts := querylog.ExportOptions{Anonymize: *anonymize}
+	var err error
+	if opts.Format, err = querylog.ParseFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+	if opts.From, err = parseTime(*from); err != nil {
+		log.Fatal("invalid -from: ", err)
+	}
+	if opts.To, err = parseTime(*to); err != nil {
+		log.Fatal("invalid -to: ", err)
+	}
+	if opts.Fields, err = querylog.ParseFields(*fields); err != nil {
+		log.Fatal(err)
+	}
+
+	count, err := querylog.ExportFiles(conf.QueryLog.Path, os.Stdout, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("exported", count, "entries")
+}
+
+// parseTime parses a RFC 3339 time, the empty string is the zero time
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// selfTest prints how well the upstream client of the configuration resists to the spoofed answers
+func selfTest(conf configuration.ServerConf, args []string) {
+	flags := flag.NewFlagSet("self-test", flag.ExitOnError)
+	questions := flags.Int("questions", 200, "number of questions asked to the fake upstream")
+	_ = flags.Parse(args)
+
+	report, err := server.SelfTest(conf, *questions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("queries received:", report.Queries)
+	fmt.Printf("id randomness: %.1f of %.1f bits\n", report.IDBits, report.MaxBits)
+	fmt.Printf("source port randomness: %.1f of %.1f bits\n", report.PortBits, report.MaxBits)
+	fmt.Println("case randomization:", report.CaseRandomized, "of", report.Queries, "queries")
+	fmt.Println("score:", report.Score, "/ 100")
+}
+
+// exportZone writes the local names as a zone file to the standard output, the ones of the running server when
+// it is reachable through its admin api, so the names it learned since its start are included
+func exportZone(conf configuration.ServerConf, args []string) {
+	flags := flag.NewFlagSet("export-zone", flag.ExitOnError)
+	origin := flags.String("origin", "", "zone exported with its SOA and NS records, all the names without SOA when empty")
+	_ = flags.Parse(args)
+
+	err := exportRunningZone(conf.Admin.Address, *origin)
+	if errors.Is(err, errNotRunning) {
+		err = zonefile.Write(os.Stdout, *origin, uint32(time.Now().Unix()), server.LocalRecords(conf))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// exportRunningZone copies the zone of the server listening on the admin address to the standard output
+func exportRunningZone(address, origin string) error {
+	if address == "" {
+		return errNotRunning
+	}
+	resp, err := http.Get("http://" + address + "/api/zone?origin=" + url.QueryEscape(origin))
+	if err != nil {
+		var opError *net.OpError
+		if errors.As(err, &opError) && opError.Op == "dial" {
+			return errNotRunning
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("export failed: " + resp.Status)
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+// setMaintenance starts or ends the maintenance of the running server through its admin api
+func setMaintenance(conf configuration.ServerConf, args []string) {
+	flags := flag.NewFlagSet("maintenance", flag.ExitOnError)
+	reason := flags.String("reason", "", "reason of the maintenance, given to the clients asking the status domain")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 || (flags.Arg(0) != "on" && flags.Arg(0) != "off") {
+		log.Fatal("usage: dnshield maintenance [-reason <text>] on|off")
+	}
+	if conf.Admin.Address == "" {
+		log.Fatal("the admin endpoint is not enabled in the configuration")
+	}
+	status, err := admin.SetMaintenance(conf.Admin.Address, flags.Arg(0) == "on", *reason)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !status.Enabled {
+		fmt.Println("maintenance ended")
+		return
+	}
+	fmt.Println("maintenance in progress since", status.Since.Format(time.RFC3339))
+}
+
+// setOffline switches the external resolution of the running server off or back on through its admin api
+func setOffline(conf configuration.ServerConf, args []string) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		log.Fatal("usage: dnshield offline on|off")
+	}
+	status, err := admin.SetOffline(adminAddress(conf), args[0] == "on")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !status.Enabled {
+		fmt.Println("external resolution enabled")
+		return
+	}
+	fmt.Println("external resolution disabled, the names out of the cache are answered", status.Answer)
+}
+
+// pauseBlocking suspends the blocking of the running server for some minutes, or resumes it, through its admin api
+func pauseBlocking(conf configuration.ServerConf, args []string) {
+	flags := flag.NewFlagSet("pause", flag.ExitOnError)
+	clientAddress := flags.String("client", "", "address of the client whose blocking is paused, every client when empty")
+	_ = flags.Parse(args)
+
+	const usage = "usage: dnshield pause [-client <address>] <minutes>|off"
+	if flags.NArg() != 1 {
+		log.Fatal(usage)
+	}
+	var pauses []pause.Pause
+	var err error
+	if flags.Arg(0) == "off" {
+		pauses, err = admin.ResumeBlocking(adminAddress(conf), *clientAddress)
+	} else {
+		minutes, convErr := strconv.Atoi(flags.Arg(0))
+		if convErr != nil || minutes <= 0 {
+			log.Fatal(usage)
+		}
+		pauses, err = admin.PauseBlocking(adminAddress(conf), *clientAddress, minutes)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(pauses) == 0 {
+		fmt.Println("blocking enabled")
+		return
+	}
+	for _, p := range pauses {
+		target := "every client"
+		if p.Client != "" {
+			target = p.Client
+		}
+		fmt.Println("blocking paused for", target, "until", p.Until.Local().Format(time.RFC3339))
+	}
+}
+
+// manageSnapshots lists the snapshots of the configuration of the running server, prints the changes from one to another
+// or rolls back to one of them through its admin api
+func manageSnapshots(conf configuration.ServerConf, args []string) {
+	const usage = "usage: dnshield snapshots [list | diff <from> [<to>] | rollback <id>]"
+	if conf.Admin.Address == "" {
+		log.Fatal("the admin endpoint is not enabled in the configuration")
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	ids := make([]int, 0, 2)
+	for _, arg := range args[1:] {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id <= 0 {
+			log.Fatal(usage)
+		}
+		ids = append(ids, id)
+	}
+	switch {
+	case args[0] == "list" && len(ids) == 0:
+		list, err := admin.ListSnapshots(conf.Admin.Address)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, snapshot := range list {
+			fmt.Println(snapshot.ID, snapshot.Time.Format(time.RFC3339), snapshot.Reason)
+		}
+	case args[0] == "diff" && (len(ids) == 1 || len(ids) == 2):
+		// the changes are printed up to the running configuration without second snapshot
+		ids = append(ids, 0)
+		changes, err := admin.DiffSnapshots(conf.Admin.Address, ids[0], ids[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, change := range changes {
+			from, _ := json.Marshal(change.From)
+			to, _ := json.Marshal(change.To)
+			fmt.Println(change.Path+":", string(from), "->", string(to))
+		}
+		fmt.Println(len(changes), "settings changed")
+	case args[0] == "rollback" && len(ids) == 1:
+		snapshot, err := admin.Rollback(conf.Admin.Address, ids[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("rolled back to the configuration of", snapshot.Time.Format(time.RFC3339), "("+snapshot.Reason+")")
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// checkConfig loads and validates the configuration file without creating it, then checks its lists can be read.
+// It exits with an error when the configuration is invalid or a list is unreachable
+func checkConfig(confFile string, args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	offline := flags.Bool("offline", false, "do not check the blocking and allow lists can be read")
+	timeout := flags.Duration("timeout", 10*time.Second, "time a list has to answer")
+	_ = flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		log.Fatal("usage: dnshield check-config [-offline] [-timeout <duration>] [<file>]")
+	}
+	if flags.NArg() == 1 {
+		confFile = flags.Arg(0)
+	}
+	conf, err := configuration.Load(confFile)
+	if err != nil {
+		fmt.Println(confFile+":", err)
+		os.Exit(1)
+	}
+	// a follower reads the lists from its primary
+	if !*offline && conf.Follow.Primary == "" {
+		failed := 0
+		for _, list := range conf.Lists() {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			if err := blockparser.Check(ctx, list); err != nil {
+				failed++
+				fmt.Println("unreachable list", list+":", err)
+			}
+			cancel()
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	}
+	fmt.Println(confFile, "is valid")
+}
+
+// flushCache empties the cache of the running server through its admin api
+func flushCache(conf configuration.ServerConf, args []string) {
+	if len(args) != 0 {
+		log.Fatal("usage: dnshield flush-cache")
+	}
+	flushed, err := admin.FlushCache(adminAddress(conf))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("flushed", flushed, "entries")
+}
+
+// editRule adds or removes a block or allow rule of the running server through its admin api
+func editRule(conf configuration.ServerConf, kind admin.RuleKind, args []string) {
+	if len(args) != 2 || (args[0] != "add" && args[0] != "remove") {
+		log.Fatal("usage: dnshield ", kind, " add|remove <pattern>")
+	}
+	rules, err := admin.EditRule(adminAddress(conf), admin.RuleEdit{Kind: kind, Pattern: args[1]}, args[0] == "add")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(len(rules.Block), "block rules,", len(rules.Allow), "allow rules")
+}
+
+// query asks the running server to resolve the name through its admin api, and prints the resolver which answered
+func query(conf configuration.ServerConf, args []string, verbose bool) {
+	if len(args) == 0 || len(args) > 2 {
+		log.Fatal("usage: dnshield query|dig <name> [<type>]")
+	}
+	t := dto.A
+	if len(args) == 2 {
+		var err error
+		if t, err = dto.ParseType(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	resolution, err := admin.Query(adminAddress(conf), args[0], t)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if verbose {
+		printTrace(resolution)
+	}
+	if resolution.Error != "" {
+		fmt.Println(args[0], t, "failed:", resolution.Error)
+		os.Exit(1)
+	}
+	fmt.Println(args[0], t, "answered by", resolution.Resolver)
+	for _, answer := range resolution.Answers {
+		fmt.Printf("  %s\t%d\t%s\t%s\n", answer.Name, answer.TTL, answer.Type, answer.Data)
+	}
+}
+
+// printTrace prints the steps of the resolution, indented by their depth, with their duration and their attributes
+func printTrace(resolution admin.Resolution) {
+	for _, step := range resolution.Trace {
+		line := strings.Repeat("  ", step.Depth) + step.Name + " " + step.Duration.Round(time.Microsecond).String()
+		keys := make([]string, 0, len(step.Attributes))
+		for key := range step.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			line += " " + key + "=" + step.Attributes[key]
+		}
+		if step.Error != "" {
+			line += " (" + step.Error + ")"
+		}
+		fmt.Println(line)
+	}
+	if resolution.Blocked {
+		rule := resolution.Rule
+		if rule == "" {
+			rule = "an unknown rule"
+		}
+		fmt.Println("blocked by", rule)
+	}
+	fmt.Println("resolved in", resolution.Duration.Round(time.Microsecond))
+}
+
+// adminAddress is the address of the admin api of the running server, the commands fail when it is not enabled
+func adminAddress(conf configuration.ServerConf) string {
+	if conf.Admin.Address == "" {
+		log.Fatal("the admin endpoint is not enabled in the configuration")
+	}
+	return conf.Admin.Address
+}
+
+// errNotRunning tells the server cannot be reached through its admin api
+var errNotRunning = errors.New("server not running")
+
+// purge removes the entries of a client from the query log, through the admin api of the running server when it is reachable
+// so its log is not rewritten behind its back
+func purge(conf configuration.ServerConf, args []string) {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	client := flags.String("client", "", "ip of the client whose entries are removed")
+	_ = flags.Parse(args)
+
+	ip := net.ParseIP(*client)
+	if ip == nil {
+		log.Fatal("usage: dnshield purge -client <ip>")
+	}
+	if conf.QueryLog.Path == "" {
+		log.Fatal("the query log is not enabled in the configuration")
+	}
+	removed, err := purgeRunning(conf.Admin.Address, ip.String())
+	if errors.Is(err, errNotRunning) {
+		removed, err = querylog.PurgeFiles(conf.QueryLog.Path, querylog.ClientMatcher(ip.String()))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("removed", removed, "entries of", ip)
+}
+
+// purgeRunning asks the server listening on the admin address to purge the entries of the client
+func purgeRunning(address, client string) (int, error) {
+	if address == "" {
+		return 0, errNotRunning
+	}
+	resp, err := http.Post("http://"+address+"/api/purge?client="+url.QueryEscape(client), "application/json", nil)
+	if err != nil {
+		var opError *net.OpError
+		if errors.As(err, &opError) && opError.Op == "dial" {
+			return 0, errNotRunning
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Removed int    `json:"removed"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("purge failed: " + result.Error)
+	}
+	return result.Removed, nil
+}
+
+// benchmark sends a load of synthetic queries to a server and prints the latencies of its responses and its errors
+func benchmark(conf configuration.ServerConf, args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	options := bench.Options{}
+	flags.StringVar(&options.Target, "target", conf.Endpoint.Address, "address of the server")
+	flags.IntVar(&options.QPS, "qps", 1000, "queries sent per second")
+	flags.DurationVar(&options.Duration, "duration", 10*time.Second, "duration of the load")
+	flags.IntVar(&options.Concurrency, "concurrency", 64, "queries waiting for their response at once")
+	flags.DurationVar(&options.Timeout, "timeout", 2*time.Second, "time a query waits for its response")
+	flags.Float64Var(&options.RandomShare, "random", 0.2, "share of the unique names, asked to the upstreams")
+	flags.Float64Var(&options.BlockedShare, "blocked", 0.2, "share of the blocked names")
+	flags.StringVar(&options.Domain, "domain", "example.com", "parent of the unique names")
+	blocked := flags.String("blocked-names", "", "comma separated blocked names, the names of the block rules when empty")
+	cached := flags.String("cached-names", "example.com,example.org,example.net", "comma separated names asked again and again")
+	_ = flags.Parse(args)
+
+	options.CachedNames = strings.Split(*cached, ",")
+	if *blocked != "" {
+		options.BlockedNames = strings.Split(*blocked, ",")
+	} else {
+		for _, rule := range conf.BlockRules {
+			// the wildcards block their subdomains, the regexps cannot be asked
+			if name := strings.TrimPrefix(rule, "*."); !strings.HasPrefix(name, "/") {
+				options.BlockedNames = append(options.BlockedNames, name)
+			}
+		}
+	}
+	if options.BlockedShare > 0 && len(options.BlockedNames) == 0 {
+		fmt.Println("no blocked name in the configuration, the blocked share is asked as cached names")
+		options.BlockedShare = 0
+	}
+
+	report, err := bench.Run(context.Background(), options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("sent %d queries in %s, %.0f queries per second\n", report.Sent, report.Duration.Round(time.Millisecond), report.QPS())
+	fmt.Printf("  random %d, blocked %d, cached %d\n", report.Kinds[bench.Random], report.Kinds[bench.Blocked], report.Kinds[bench.Cached])
+	fmt.Printf("answered %d, timeouts %d, error rate %.2f%%\n", report.Answered, report.Timeouts, 100*report.ErrorRate())
+	for code, count := range report.Errors {
+		fmt.Printf("  %s %d\n", code, count)
+	}
+	fmt.Println("latency p50", report.P50, "p90", report.P90, "p99", report.P99, "max", report.Max)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/server/gitsync"
+)
+
+const (
+	// defaultFollowInterval is the interval between two pulls of the configuration of the primary, or of the
+	// network policy, when none is configured
+	defaultFollowInterval = 5 * time.Minute
+	// gitTimeout bounds each pull and each push of the network policy
+	gitTimeout = time.Minute
+)
+
+// follower applies the configuration of the primary merged with the local one, each time one of them changes.
+// The network policy pulled from the git repository of the configuration replaces the one of both
+type follower struct {
+	lock    sync.Mutex
+	local   configuration.ServerConf
+	primary *configuration.ServerConf
+	policy  *configuration.NetworkPolicy
+	apply   func(configuration.ServerConf)
+}
+
+// setLocal applies a new local configuration, with the last configuration pulled from the primary
+func (f *follower) setLocal(conf configuration.ServerConf) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.local = conf
+	f.applyLocked()
+}
+
+// setPrimary applies the configuration pulled from the primary when it changed
+func (f *follower) setPrimary(conf configuration.ServerConf) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.primary != nil && reflect.DeepEqual(*f.primary, conf) {
+		return
+	}
+	slog.Info("applying the configuration of the primary", "primary", f.local.Follow.Primary)
+	f.primary = &conf
+	f.applyLocked()
+}
+
+// setPolicy applies the network policy pulled from the repository when it changed
+func (f *follower) setPolicy(policy configuration.NetworkPolicy) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.policy != nil && reflect.DeepEqual(*f.policy, policy) {
+		return
+	}
+	slog.Info("applying the network policy of the repository", "repository", f.local.GitSync.Repository)
+	f.policy = &policy
+	f.applyLocked()
+}
+
+func (f *follower) applyLocked() {
+	conf := f.local
+	if f.local.Follow.Primary != "" && f.primary != nil {
+		conf = f.local.Following(*f.primary)
+	}
+	if f.local.GitSync.Repository != "" && f.policy != nil {
+		conf = conf.WithNetworkPolicy(*f.policy)
+	}
+	f.apply(conf)
+}
+
+// follow pulls the configuration of the primary of the local configuration every interval,
+// the interval is the one of the configuration at startup
+func (f *follower) follow(interval time.Duration) {
+	if interval == 0 {
+		interval = defaultFollowInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		f.lock.Lock()
+		primary := f.local.Follow.Primary
+		f.lock.Unlock()
+		if primary == "" {
+			continue
+		}
+		conf, err := admin.FetchConfiguration(primary)
+		if err != nil {
+			slog.Warn("keeping the running configuration", "err", err)
+			continue
+		}
+		f.setPrimary(conf)
+	}
+}
+
+// sync pulls the network policy of the repository of the local configuration every interval, then pushes the rules
+// edited through the admin api, given by rules, when the configuration asks for it. The repository is the one of
+// the configuration at startup
+func (f *follower) sync(interval time.Duration, rules func() admin.Rules, forget func(admin.Rules)) {
+	if interval == 0 {
+		interval = defaultFollowInterval
+	}
+	f.lock.Lock()
+	conf := f.local.GitSync
+	f.lock.Unlock()
+	repository := gitsync.Repository{URL: conf.Repository, Branch: conf.Branch, Dir: conf.Dir}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		policy, err := f.pull(repository, conf.PolicyFile())
+		if err != nil {
+			slog.Warn("keeping the running network policy", "repository", conf.Repository, "err", err)
+			continue
+		}
+		f.setPolicy(policy)
+		if !conf.Push {
+			continue
+		}
+		running := rules()
+		if slices.Equal(running.Allow, policy.AllowDomains) && slices.Equal(running.Block, policy.BlockRules) {
+			continue
+		}
+		policy.AllowDomains, policy.BlockRules = running.Allow, running.Block
+		if err := push(repository, conf.PolicyFile(), policy); err != nil {
+			slog.Warn("cannot push the rules edited through the api", "repository", conf.Repository, "err", err)
+			continue
+		}
+		slog.Info("rules edited through the api pushed", "repository", conf.Repository)
+		f.setPolicy(policy)
+		forget(running)
+	}
+}
+
+// pull returns the network policy of the last commit of the repository, once checked with the local configuration
+func (f *follower) pull(repository gitsync.Repository, file string) (configuration.NetworkPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	if err := repository.Pull(ctx); err != nil {
+		return configuration.NetworkPolicy{}, err
+	}
+	data, err := repository.ReadFile(file)
+	if err != nil {
+		return configuration.NetworkPolicy{}, err
+	}
+	policy, err := configuration.ParseNetworkPolicy(data)
+	if err != nil {
+		return policy, err
+	}
+	f.lock.Lock()
+	local := f.local
+	f.lock.Unlock()
+	return policy, local.WithNetworkPolicy(policy).Validate()
+}
+
+// push commits the network policy to the repository
+func push(repository gitsync.Repository, file string, policy configuration.NetworkPolicy) error {
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	return repository.Push(ctx, file, append(data, '\n'), "Update the rules edited through the dnshield api")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+
+	"github.com/bluguard/dnshield/internal/dns/server"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+)
+
+// takeOver receives the sockets and the caches of the process running on the hand-off socket, nil when none runs
+func takeOver(path string) *handoff.Transfer {
+	if path == "" {
+		return nil
+	}
+	transfer, err := handoff.Receive(path)
+	if err != nil {
+		// the running process keeps its sockets, the endpoints of this one could not listen
+		log.Fatal("cannot take over the running process: ", err)
+	}
+	return transfer
+}
+
+// serveHandoff stops the previous process once the servers are started, then waits for the next process to hand
+// the sockets and the caches over to it
+func serveHandoff(path string, transfer *handoff.Transfer, s *server.Server, tenants map[string]*server.Server) {
+	if err := transfer.Ready(); err != nil {
+		log.Fatal("the previous process did not stop: ", err)
+	}
+	save := func() map[string][]byte {
+		caches := make(map[string][]byte, len(tenants)+1)
+		caches[""] = saveCache("", s)
+		for name, ts := range tenants {
+			caches[name] = saveCache(name, ts)
+		}
+		return caches
+	}
+	stop := func() {
+		s.Stop()
+		for _, ts := range tenants {
+			ts.Stop()
+		}
+	}
+	if err := handoff.Serve(path, save, stop); err != nil {
+		slog.Error("cannot serve the hand-off, the process must be stopped to be upgraded", "path", path, "err", err)
+	}
+}
+
+func saveCache(tenant string, s *server.Server) []byte {
+	buffer := &bytes.Buffer{}
+	count, err := s.SaveCache(buffer)
+	if err != nil {
+		slog.Error("cannot save the cache for the next process", "tenant", tenant, "err", err)
+		return nil
+	}
+	slog.Info("handing the cache over", "tenant", tenant, "entries", count)
+	return buffer.Bytes()
+}
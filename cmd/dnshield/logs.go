@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+// logOutput is the output of the logs, the file is kept open across the reloads while its path is the same
+type logOutput struct {
+	path string
+	file *os.File
+}
+
+// apply configures the logs of the configuration, they keep their output when the new one cannot be used
+func (o *logOutput) apply(conf configuration.ServerConf) {
+	path, file := o.path, o.file
+	w, err := o.open(conf.Log.Output)
+	if err == nil {
+		err = logging.Configure(w, conf.Log.Format, conf.Resources().LogLevel, conf.Log.Components)
+	}
+	if err != nil {
+		slog.Error("cannot configure the logs, keeping the current output", "output", conf.Log.Output, "err", err)
+		if o.file != nil && o.file != file {
+			_ = o.file.Close()
+		}
+		o.path, o.file = path, file
+		return
+	}
+	// the replaced file is closed once the loggers use the new output
+	if file != nil && file != o.file {
+		_ = file.Close()
+	}
+}
+
+// open returns the output of the path: stderr when empty, stdout or a file opened in append mode
+func (o *logOutput) open(path string) (io.Writer, error) {
+	switch path {
+	case "", "stderr":
+		o.path, o.file = "", nil
+		return os.Stderr, nil
+	case "stdout":
+		o.path, o.file = "", nil
+		return os.Stdout, nil
+	}
+	if o.file != nil && o.path == path {
+		return o.file, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	o.path, o.file = path, file
+	return file, nil
+}
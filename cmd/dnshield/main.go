@@ -1,15 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"runtime/pprof"
 	"runtime/trace"
+	"sync"
+	"time"
 
 	"github.com/bluguard/dnshield/internal/dns/server"
 	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
 )
 
 func main() {
@@ -18,8 +22,19 @@ func main() {
 	cpuprofile := flag.String("cpuprofile", "", "cpu profile file")
 	traceprofile := flag.String("traceprofile", "", "trace profile file")
 
-	confFile := flag.String("conf", "./conf", "configuration file, will be created if not exists")
+	confFile := flag.String("conf", "./conf", "configuration file, json, or yaml or toml by its extension, will be created if not exists. "+
+		"The "+configuration.EnvPrefix+"* variables of the environment override its settings, like "+configuration.EnvPrefix+"EXTERNAL_ENDPOINT")
+	watchInterval := flag.Duration("watch", 0, "interval between the checks of the configuration file for changes, 0 only reloads on SIGHUP")
+	handoffSocket := flag.String("handoff", "", "unix socket of the upgrades, a process started with the same socket takes the sockets and the cache over from the running one")
 	flag.Parse()
+	if flag.NArg() > 0 && flag.Arg(0) == "serve" {
+		// serve is the default command, its flags may follow it
+		_ = flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "check-config" {
+		checkConfig(*confFile, flag.Args()[1:])
+		return
+	}
 
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -45,22 +60,33 @@ func main() {
 		defer f.Close()
 	}
 
-	file, err := os.Open(*confFile)
+	conf, err := configuration.Load(*confFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			createDefault(confFile)
 			return
 		}
+		log.Fatal(err)
 	}
 
-	var conf configuration.ServerConf
-	_ = json.NewDecoder(file).Decode(&conf)
-
-	s := server.Server{}
+	if flag.NArg() > 0 && flag.Arg(0) == "service" {
+		manageService(*confFile, flag.Args()[1:])
+		return
+	}
+	if flag.NArg() > 0 {
+		runCommand(conf, flag.Args())
+		return
+	}
 
 	conf.Memdump = *memprofile
-
-	s.Start(conf).Wait()
+	opts := options{confFile: *confFile, watchInterval: *watchInterval, handoffSocket: *handoffSocket, memprofile: *memprofile}
+	if runsAsService() {
+		if err := runService(func(stop <-chan struct{}, started func()) { serve(conf, opts, stop, started) }); err != nil {
+			log.Fatal("cannot run the service: ", err)
+		}
+	} else {
+		serve(conf, opts, nil, nil)
+	}
 
 	if *cpuprofile != "" {
 		pprof.StopCPUProfile()
@@ -71,16 +97,108 @@ func main() {
 
 }
 
+// options are the flags of the server
+type options struct {
+	confFile      string
+	watchInterval time.Duration
+	handoffSocket string
+	memprofile    string
+}
+
+// serve runs the main server and the tenants until they are stopped by a signal, or once stop is closed.
+// started, when not nil, is called once the endpoints listen
+func serve(conf configuration.ServerConf, opts options, stop <-chan struct{}, started func()) {
+	logs := &logOutput{}
+	logs.apply(conf)
+
+	if count, err := handoff.Activate(); err != nil {
+		log.Fatal("cannot take the sockets passed by systemd: ", err)
+	} else if count > 0 {
+		slog.Info("started by systemd", "sockets", count)
+	}
+	transfer := takeOver(opts.handoffSocket)
+	handoff.SetListenAddresses(conf.ListenAddresses())
+	s := &server.Server{}
+	s.WarmCache(transfer.Cache(""))
+
+	wg, err := s.Start(conf)
+	wgs := []*sync.WaitGroup{wg}
+	errs := []error{err}
+	tenants := make(map[string]*server.Server, len(conf.Tenants))
+	for _, tenant := range conf.Tenants {
+		slog.Info("starting tenant", "tenant", tenant.Name)
+		ts := &server.Server{}
+		ts.WarmCache(transfer.Cache(tenant.Name))
+		tenants[tenant.Name] = ts
+		wg, err := ts.Start(tenant.ServerConf)
+		if err != nil {
+			err = errors.New("tenant " + tenant.Name + ": " + err.Error())
+		}
+		wgs = append(wgs, wg)
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err == nil {
+		// the endpoints listen, the privileged ports are no longer needed
+		errs = append(errs, dropPrivileges(conf))
+	}
+	if err := errors.Join(errs...); err != nil {
+		// a server missing an endpoint must not look healthy, its supervisor restarts it or reports the failure
+		slog.Error("cannot start the server", "err", err)
+		s.Stop()
+		for _, ts := range tenants {
+			ts.Stop()
+		}
+		os.Exit(1)
+	}
+	if started != nil {
+		started()
+	}
+
+	f := &follower{local: conf, apply: func(conf configuration.ServerConf) {
+		logs.apply(conf)
+		reload(s, tenants, conf)
+	}}
+	if conf.Follow.Primary != "" {
+		go f.follow(time.Duration(conf.Follow.Interval) * time.Second)
+	}
+	if conf.GitSync.Repository != "" {
+		interval := conf.GitSync.Interval
+		if interval == 0 {
+			interval = conf.Follow.Interval
+		}
+		go f.sync(time.Duration(interval)*time.Second, s.Rules, s.ForgetRuleEdits)
+	}
+	if opts.handoffSocket != "" {
+		go serveHandoff(opts.handoffSocket, transfer, s, tenants)
+	}
+	go watchConfiguration(opts.confFile, opts.watchInterval, func(conf configuration.ServerConf) {
+		conf.Memdump = opts.memprofile
+		f.setLocal(conf)
+	})
+	if stop != nil {
+		// the stop request of the service manager stops the servers like SIGTERM
+		go func() {
+			<-stop
+			s.Stop()
+			for _, ts := range tenants {
+				ts.Stop()
+			}
+		}()
+	}
+
+	for _, wg := range wgs {
+		wg.Wait()
+	}
+}
+
+// createDefault writes the default configuration in the format of the extension of the file
 func createDefault(confFile *string) {
-	log.Println("creating default configuration")
-	file, err := os.Create(*confFile)
+	slog.Info("creating default configuration", "path", *confFile)
+	data, err := configuration.Marshal(configuration.Default(), configuration.FormatOf(*confFile))
 	if err != nil {
 		panic(err)
 	}
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	err = encoder.Encode(configuration.Default())
-	if err != nil {
+	if err := os.WriteFile(*confFile, data, 0o644); err != nil {
 		panic(err)
 	}
 }
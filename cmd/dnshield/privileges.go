@@ -0,0 +1,84 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+)
+
+// dropPrivileges switches the process started as root to the user and the group of the configuration, once the
+// endpoints listen on their privileged ports. The state of the server, created as root while starting, is given to
+// them first. It does nothing without user or when the process is not root
+func dropPrivileges(conf configuration.ServerConf) error {
+	if conf.RunAs.User == "" {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		slog.Debug("not running as root, keeping the user", "uid", os.Geteuid())
+		return nil
+	}
+	u, err := user.Lookup(conf.RunAs.User)
+	if err != nil {
+		return err
+	}
+	gid := u.Gid
+	if conf.RunAs.Group != "" {
+		g, err := user.LookupGroup(conf.RunAs.Group)
+		if err != nil {
+			return err
+		}
+		gid = g.Gid
+	}
+	uidNumber, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return errors.New("invalid uid " + u.Uid + " of the user " + u.Username)
+	}
+	gidNumber, err := strconv.Atoi(gid)
+	if err != nil {
+		return errors.New("invalid gid " + gid)
+	}
+	if uidNumber == 0 {
+		return errors.New("the user " + u.Username + " is root")
+	}
+	for _, path := range conf.StatePaths() {
+		if err := chownTree(path, uidNumber, gidNumber); err != nil {
+			return errors.New("cannot give " + path + " to the user " + u.Username + ": " + err.Error())
+		}
+	}
+	// the group first, the user can no longer change it once switched
+	if err := syscall.Setgroups([]int{gidNumber}); err != nil {
+		return errors.New("cannot set the groups: " + err.Error())
+	}
+	if err := syscall.Setgid(gidNumber); err != nil {
+		return errors.New("cannot set the group: " + err.Error())
+	}
+	if err := syscall.Setuid(uidNumber); err != nil {
+		return errors.New("cannot set the user: " + err.Error())
+	}
+	slog.Info("dropped the privileges", "user", u.Username, "uid", uidNumber, "gid", gidNumber)
+	return nil
+}
+
+// chownTree gives the file or the directory at path and everything it holds to the user and the group, the symbolic
+// links themselves and not their targets. Nothing is done when path does not exist yet
+func chownTree(path string, uid, gid int) error {
+	err := filepath.WalkDir(path, func(name string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(name, uid, gid)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
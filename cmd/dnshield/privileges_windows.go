@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+)
+
+// dropPrivileges is not supported on windows, where the service account is chosen by the service manager
+func dropPrivileges(conf configuration.ServerConf) error {
+	if conf.RunAs.User != "" {
+		return errors.New("run_as is not supported on windows")
+	}
+	return nil
+}
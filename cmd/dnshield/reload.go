@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/server"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+)
+
+// watchConfiguration calls onChange with the new configuration when the process receives SIGHUP,
+// or when the modification time of the file changes if interval is positive
+func watchConfiguration(path string, interval time.Duration, onChange func(configuration.ServerConf)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	modTime := modificationTime(path)
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			slog.Info("SIGHUP received, reloading", "path", path)
+		case <-tick:
+			current := modificationTime(path)
+			if current.Equal(modTime) {
+				continue
+			}
+			modTime = current
+			slog.Info("configuration changed, reloading", "path", path)
+		}
+		conf, err := configuration.Load(path)
+		if err != nil {
+			slog.Warn("keeping the running configuration", "err", err)
+			continue
+		}
+		onChange(conf)
+	}
+}
+
+func modificationTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reload reconfigures the main server and the tenants with the same name,
+// adding or removing tenants requires a restart
+func reload(s *server.Server, tenants map[string]*server.Server, conf configuration.ServerConf) {
+	handoff.SetListenAddresses(conf.ListenAddresses())
+	if _, err := s.Reconfigure(conf); err != nil {
+		slog.Error("cannot apply the configuration", "err", err)
+		exitWithoutEndpoints(err, s, tenants)
+	}
+	found := make(map[string]bool, len(conf.Tenants))
+	for _, tenant := range conf.Tenants {
+		found[tenant.Name] = true
+		ts, ok := tenants[tenant.Name]
+		if !ok {
+			slog.Warn("tenant is new, restart to start it", "tenant", tenant.Name)
+			continue
+		}
+		slog.Info("reloading tenant", "tenant", tenant.Name)
+		if _, err := ts.Reconfigure(tenant.ServerConf); err != nil {
+			slog.Error("cannot apply the configuration", "tenant", tenant.Name, "err", err)
+			exitWithoutEndpoints(err, s, tenants)
+		}
+	}
+	for name := range tenants {
+		if !found[name] {
+			slog.Warn("tenant has been removed, restart to stop it", "tenant", name)
+		}
+	}
+}
+
+// exitWithoutEndpoints stops the servers and exits when a reload left a server without endpoints: as after a failed start,
+// a server missing its endpoints must not look healthy, its supervisor restarts it or reports the failure
+func exitWithoutEndpoints(err error, s *server.Server, tenants map[string]*server.Server) {
+	if !errors.Is(err, server.ErrNoEndpoints) {
+		return
+	}
+	s.Stop()
+	for _, ts := range tenants {
+		ts.Stop()
+	}
+	os.Exit(1)
+}
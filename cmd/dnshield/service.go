@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// serviceName is the name of the service registered in the service manager
+const serviceName = "dnshield"
+
+// manageService installs, uninstalls, starts or stops the service of the server, run with the configuration file
+func manageService(confFile string, args []string) {
+	if len(args) != 1 {
+		log.Fatal("usage: dnshield [-conf <file>] service install|uninstall|start|stop")
+	}
+	if err := serviceCommand(confFile, args[0]); err != nil {
+		log.Fatal("cannot ", args[0], " the service: ", err)
+	}
+	fmt.Println("service", serviceName, args[0], "done")
+}
+
+// serviceCommand runs the command of the service manager, the service is installed with the absolute path of the
+// executable and of the configuration file
+func serviceCommand(confFile, command string) error {
+	switch command {
+	case "install":
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		conf, err := filepath.Abs(confFile)
+		if err != nil {
+			return err
+		}
+		return installService(exe, conf)
+	case "uninstall":
+		return uninstallService()
+	case "start":
+		return startService()
+	case "stop":
+		return stopService()
+	default:
+		return errors.New("unknown service command " + command)
+	}
+}
@@ -0,0 +1,108 @@
+//go:build darwin
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+)
+
+const (
+	// launchdLabel is the label of the daemon, its plist is launchdPlist
+	launchdLabel = "com.bluguard.dnshield"
+	launchdPlist = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+	launchdLog   = "/var/log/dnshield.log"
+)
+
+// plist is the definition of the daemon, launchd restarts it when it fails, and stops it with SIGTERM
+var plist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": escapeXML}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{xml .Exe}}</string>
+		<string>-conf</string>
+		<string>{{xml .Conf}}</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{xml .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+</dict>
+</plist>
+`))
+
+// runsAsService is false on macos, launchd stops the daemon with SIGTERM like any process
+func runsAsService() bool {
+	return false
+}
+
+func runService(serve func(stop <-chan struct{}, started func())) error {
+	serve(nil, nil)
+	return nil
+}
+
+// installService writes the plist of the daemon started at boot with the configuration file, then loads it
+func installService(exe, conf string) error {
+	if _, err := os.Stat(launchdPlist); err == nil {
+		return errors.New("the daemon is already installed in " + launchdPlist)
+	}
+	file, err := os.OpenFile(launchdPlist, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	err = plist.Execute(file, map[string]string{"Label": launchdLabel, "Exe": exe, "Conf": conf, "Log": launchdLog})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(launchdPlist)
+		return err
+	}
+	return launchctl("load", "-w", launchdPlist)
+}
+
+// uninstallService unloads the daemon, which stops it, then removes its plist
+func uninstallService() error {
+	if err := launchctl("unload", "-w", launchdPlist); err != nil {
+		return err
+	}
+	return os.Remove(launchdPlist)
+}
+
+func startService() error {
+	return launchctl("start", launchdLabel)
+}
+
+func stopService() error {
+	return launchctl("stop", launchdLabel)
+}
+
+// launchctl runs launchctl with the arguments, the error holds its output
+func launchctl(args ...string) error {
+	output, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return errors.New("launchctl " + strings.Join(args, " ") + ": " + err.Error() + ": " + strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,42 @@
+//go:build darwin
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPlist(t *testing.T) {
+	var b strings.Builder
+	err := plist.Execute(&b, map[string]string{"Label": launchdLabel, "Exe": "/opt/dns & co/dnshield", "Conf": "/etc/<dnshield>.json", "Log": launchdLog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	decoder := xml.NewDecoder(strings.NewReader(b.String()))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid plist: %v\n%s", err, b.String())
+		}
+		if data, ok := token.(xml.CharData); ok && strings.TrimSpace(string(data)) != "" {
+			values = append(values, string(data))
+		}
+	}
+	for _, expected := range []string{"/opt/dns & co/dnshield", "-conf", "/etc/<dnshield>.json", launchdLabel} {
+		found := false
+		for _, value := range values {
+			found = found || value == expected
+		}
+		if !found {
+			t.Errorf("missing %s in the plist\n%s", expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !windows && !darwin
+
+package main
+
+import "errors"
+
+// errServiceNotSupported is returned where the service manager runs the unit files written by the administrator
+var errServiceNotSupported = errors.New("the service commands need windows or macos, write a unit of the service manager, like systemd, instead")
+
+// runsAsService is false, the service managers stop the process with SIGTERM
+func runsAsService() bool {
+	return false
+}
+
+func runService(serve func(stop <-chan struct{}, started func())) error {
+	serve(nil, nil)
+	return nil
+}
+
+func installService(_, _ string) error {
+	return errServiceNotSupported
+}
+
+func uninstallService() error {
+	return errServiceNotSupported
+}
+
+func startService() error {
+	return errServiceNotSupported
+}
+
+func stopService() error {
+	return errServiceNotSupported
+}
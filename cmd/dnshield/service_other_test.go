@@ -0,0 +1,29 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestServiceCommand_NotSupported(t *testing.T) {
+	for _, command := range []string{"install", "uninstall", "start", "stop"} {
+		if err := serviceCommand("dnshield.json", command); !errors.Is(err, errServiceNotSupported) {
+			t.Errorf("%s: expecting the service commands not to be supported, got %v", command, err)
+		}
+	}
+}
+
+func TestRunService(t *testing.T) {
+	served := false
+	err := runService(func(stop <-chan struct{}, started func()) {
+		if stop != nil || started != nil {
+			t.Errorf("expecting the process to be stopped by a signal")
+		}
+		served = true
+	})
+	if err != nil || !served {
+		t.Errorf("expecting the servers to run in the process, got %v", err)
+	}
+}
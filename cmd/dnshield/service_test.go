@@ -0,0 +1,9 @@
+package main
+
+import "testing"
+
+func TestServiceCommand_Unknown(t *testing.T) {
+	if err := serviceCommand("dnshield.json", "restart"); err == nil {
+		t.Errorf("expecting an error for an unknown command")
+	}
+}
@@ -0,0 +1,129 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// stopWait bounds the wait for the service to stop, the endpoints drain their queries meanwhile
+const stopWait = 30 * time.Second
+
+// runsAsService tells if the process has been started by the service control manager
+func runsAsService() bool {
+	res, err := svc.IsWindowsService()
+	return err == nil && res
+}
+
+// runService runs the servers until the service control manager asks the service to stop
+func runService(serve func(stop <-chan struct{}, started func())) error {
+	return svc.Run(serviceName, &handler{serve: serve})
+}
+
+// handler translates the requests of the service control manager
+type handler struct {
+	serve func(stop <-chan struct{}, started func())
+}
+
+// Execute implements svc.Handler, the service is running once the servers have started
+func (h *handler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	running := make(chan struct{})
+	var once sync.Once
+	go func() {
+		defer close(done)
+		h.serve(stop, func() { once.Do(func() { close(running) }) })
+	}()
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case <-running:
+			running = nil
+			status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(stop)
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// installService registers the service started automatically with the configuration file
+func installService(exe, conf string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return errors.New("the service " + serviceName + " is already installed")
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceName,
+		Description: "DNS server filtering the names of the blocking lists",
+		StartType:   mgr.StartAutomatic,
+	}, "-conf", conf)
+	if err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+// uninstallService removes the service, it keeps running until it is stopped
+func uninstallService() error {
+	return withService(func(s *mgr.Service) error {
+		return s.Delete()
+	})
+}
+
+func startService() error {
+	return withService(func(s *mgr.Service) error {
+		return s.Start()
+	})
+}
+
+// stopService asks the service to stop, then waits for it to be stopped
+func stopService() error {
+	return withService(func(s *mgr.Service) error {
+		status, err := s.Control(svc.Stop)
+		deadline := time.Now().Add(stopWait)
+		for err == nil && status.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return errors.New("the service did not stop in " + stopWait.String())
+			}
+			time.Sleep(300 * time.Millisecond)
+			status, err = s.Query()
+		}
+		return err
+	})
+}
+
+// withService calls f with the installed service
+func withService(f func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return errors.New("the service " + serviceName + " is not installed: " + err.Error())
+	}
+	defer s.Close()
+	return f(s)
+}
@@ -0,0 +1,48 @@
+//go:build windows
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+func TestHandler(t *testing.T) {
+	start := make(chan struct{})
+	h := &handler{serve: func(stop <-chan struct{}, started func()) {
+		<-start
+		started()
+		<-stop
+	}}
+	requests := make(chan svc.ChangeRequest)
+	status := make(chan svc.Status, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Execute(nil, requests, status)
+	}()
+
+	if s := <-status; s.State != svc.StartPending {
+		t.Fatalf("expecting the service to be starting, got %v", s.State)
+	}
+	select {
+	case s := <-status:
+		t.Fatalf("expecting the service to be starting until the servers have started, got %v", s.State)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(start)
+	if s := <-status; s.State != svc.Running || s.Accepts&svc.AcceptStop == 0 {
+		t.Fatalf("expecting the service to be running and accept to be stopped, got %v", s)
+	}
+	requests <- svc.ChangeRequest{Cmd: svc.Stop}
+	if s := <-status; s.State != svc.StopPending {
+		t.Errorf("expecting the service to be stopping, got %v", s.State)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("expecting the handler to return once the servers have stopped")
+	}
+}
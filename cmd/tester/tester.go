@@ -17,6 +17,7 @@ import (
 	"text/template"
 	"time"
 
+	dnsclient "github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/client/udp"
 )
 
@@ -117,7 +118,8 @@ func runner(ctx context.Context, wg *sync.WaitGroup, client *udp.UDPClient, doma
 			}
 			start := time.Now()
 			_, err := client.ResolveV4(domain)
-			if err != nil && !errors.Is(err, &udp.NoResponse{}) {
+			var noData *dnsclient.NoDataError
+			if err != nil && !errors.Is(err, &udp.NoResponse{}) && !errors.As(err, &noData) {
 				durChan <- time.Duration(-1)
 				continue
 			}
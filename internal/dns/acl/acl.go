@@ -0,0 +1,69 @@
+// Package acl restricts the clients allowed to query the dns endpoints to a list of subnets,
+// so a server reachable beyond the LAN does not answer the whole internet
+package acl
+
+import (
+	"errors"
+	"net"
+)
+
+// Action is the handling of the query of a client decided by the ACL
+type Action int
+
+const (
+	// Allow answers the query
+	Allow Action = iota
+	// Refuse answers REFUSED without resolving the query
+	Refuse
+	// Drop ignores the query
+	Drop
+)
+
+// String returns the name of the action, used by the metrics
+func (a Action) String() string {
+	switch a {
+	case Refuse:
+		return "refuse"
+	case Drop:
+		return "drop"
+	}
+	return "allow"
+}
+
+// ParseDenial returns the action applied to the clients not allowed from its name, refuse when empty
+func ParseDenial(name string) (Action, error) {
+	switch name {
+	case "", "refuse":
+		return Refuse, nil
+	case "drop":
+		return Drop, nil
+	}
+	return Allow, errors.New("unknown acl action " + name + ", expecting refuse or drop")
+}
+
+// ACL allows the queries of the clients of its subnets, it is safe for concurrent use.
+// A nil ACL allows every client
+type ACL struct {
+	allowed []*net.IPNet
+	denial  Action
+}
+
+// NewACL instantiates an ACL allowing the clients of the subnets, the queries of the others get the denial action
+func NewACL(allowed []*net.IPNet, denial Action) *ACL {
+	return &ACL{allowed: allowed, denial: denial}
+}
+
+// Check returns the action applied to the query of the client, a client of unknown address is denied
+func (a *ACL) Check(client net.IP) Action {
+	if a == nil {
+		return Allow
+	}
+	if client != nil {
+		for _, subnet := range a.allowed {
+			if subnet.Contains(client) {
+				return Allow
+			}
+		}
+	}
+	return a.denial
+}
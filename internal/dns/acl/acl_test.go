@@ -0,0 +1,50 @@
+package acl
+
+import (
+	"net"
+	"testing"
+)
+
+func TestACL_Check(t *testing.T) {
+	var allowed []*net.IPNet
+	for _, cidr := range []string{"192.168.1.0/24", "fd00::/8"} {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed = append(allowed, subnet)
+	}
+	a := NewACL(allowed, Drop)
+
+	tests := []struct {
+		name   string
+		client net.IP
+		want   Action
+	}{
+		{name: "allowed v4", client: net.ParseIP("192.168.1.20"), want: Allow},
+		{name: "allowed v6", client: net.ParseIP("fd00::20"), want: Allow},
+		{name: "other subnet", client: net.ParseIP("203.0.113.7"), want: Drop},
+		{name: "unknown address", client: nil, want: Drop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.Check(tt.client); got != tt.want {
+				t.Errorf("ACL.Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := (*ACL)(nil).Check(net.ParseIP("203.0.113.7")); got != Allow {
+		t.Errorf("expecting a nil ACL to allow every client, got %v", got)
+	}
+}
+
+func TestParseDenial(t *testing.T) {
+	for name, want := range map[string]Action{"": Refuse, "refuse": Refuse, "drop": Drop} {
+		if got, err := ParseDenial(name); err != nil || got != want {
+			t.Errorf("ParseDenial(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseDenial("allow"); err == nil {
+		t.Errorf("expecting an error for an unknown action")
+	}
+}
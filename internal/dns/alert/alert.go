@@ -0,0 +1,179 @@
+// Package alert watches the questions answered with SERVFAIL. A rate of failures rising over its threshold is an early
+// sign of an upstream or a connectivity problem, it is reported to a hook before the clients complain
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("alert")
+
+const (
+	// buckets count the failures of each second of the last minute
+	buckets        = 60
+	webhookTimeout = 10 * time.Second
+)
+
+// Event is an alert raised when the failures of the last minute reach the threshold, and cleared once they are under it
+type Event struct {
+	Time      time.Time `json:"time"`
+	Firing    bool      `json:"firing"`
+	Failures  uint64    `json:"failures_per_minute"`
+	Threshold uint64    `json:"threshold"`
+}
+
+// Hook receives the events of the monitor, it is called in a dedicated goroutine
+type Hook func(Event)
+
+// Monitor counts the failures of the last minute, it is safe for concurrent use.
+// The counting methods of a nil Monitor do nothing
+type Monitor struct {
+	clock   clock.Clock
+	lock    sync.Mutex
+	counts  [buckets]uint64
+	seconds [buckets]int64
+	// threshold is the rate raising an alert, zero when disabled
+	threshold uint64
+	hook      Hook
+	firing    bool
+}
+
+// NewMonitor instantiate a monitor without threshold
+func NewMonitor(c clock.Clock) *Monitor {
+	return &Monitor{clock: c}
+}
+
+// SetThreshold sets the failures per minute raising an alert, zero disables the alerts.
+// The events are logged and given to the hook when not nil
+func (m *Monitor) SetThreshold(threshold uint64, hook Hook) {
+	m.lock.Lock()
+	m.threshold, m.hook = threshold, hook
+	event, changed := m.check(m.clock.Now().Unix())
+	m.lock.Unlock()
+	if changed {
+		m.notify(event, hook)
+	}
+}
+
+// Failure counts a question answered with SERVFAIL
+func (m *Monitor) Failure() {
+	if m == nil {
+		return
+	}
+	now := m.clock.Now().Unix()
+	m.lock.Lock()
+	i := now % buckets
+	if m.seconds[i] != now {
+		m.seconds[i], m.counts[i] = now, 0
+	}
+	m.counts[i]++
+	event, changed := m.check(now)
+	hook := m.hook
+	m.lock.Unlock()
+	if changed {
+		m.notify(event, hook)
+	}
+}
+
+// Rate returns the failures of the last minute
+func (m *Monitor) Rate() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.sum(m.clock.Now().Unix())
+}
+
+// Firing tells if the rate is over the threshold
+func (m *Monitor) Firing() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.firing
+}
+
+// Watch checks the rate every interval until ctx is done, so the alert is cleared when no question fails anymore
+func (m *Monitor) Watch(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	ticker := m.clock.NewTicker(interval)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				m.refresh()
+			}
+		}
+	}()
+}
+
+func (m *Monitor) refresh() {
+	m.lock.Lock()
+	event, changed := m.check(m.clock.Now().Unix())
+	hook := m.hook
+	m.lock.Unlock()
+	if changed {
+		m.notify(event, hook)
+	}
+}
+
+// sum must be called with the lock held, it adds the failures of the buckets of the last minute
+func (m *Monitor) sum(now int64) uint64 {
+	var total uint64
+	for i := range m.counts {
+		if now-m.seconds[i] < buckets {
+			total += m.counts[i]
+		}
+	}
+	return total
+}
+
+// check must be called with the lock held, it returns the event when the alert is raised or cleared
+func (m *Monitor) check(now int64) (Event, bool) {
+	rate := m.sum(now)
+	firing := m.threshold > 0 && rate >= m.threshold
+	if firing == m.firing {
+		return Event{}, false
+	}
+	m.firing = firing
+	return Event{Time: time.Unix(now, 0), Firing: firing, Failures: rate, Threshold: m.threshold}, true
+}
+
+func (m *Monitor) notify(event Event, hook Hook) {
+	if event.Firing {
+		logger.Warn("the questions answered with SERVFAIL are over the threshold", "failures_per_minute", event.Failures, "threshold", event.Threshold)
+	} else {
+		logger.Info("the questions answered with SERVFAIL are back under the threshold", "failures_per_minute", event.Failures)
+	}
+	if hook != nil {
+		go hook(event)
+	}
+}
+
+// Webhook returns the hook posting the events as json to the url
+func Webhook(url string) Hook {
+	c := &http.Client{Timeout: webhookTimeout}
+	return func(event Event) {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		resp, err := c.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			logger.Warn("cannot post the alert", "url", url, "err", err)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			logger.Warn("the alert webhook refused the alert", "url", url, "status", resp.Status)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestMonitor_Threshold(t *testing.T) {
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	m := NewMonitor(c)
+	events := make(chan Event, 4)
+	m.SetThreshold(3, func(e Event) { events <- e })
+
+	m.Failure()
+	c.Advance(30 * time.Second)
+	m.Failure()
+	if m.Firing() {
+		t.Fatalf("2 failures should not raise the alert")
+	}
+	m.Failure()
+	e := <-events
+	if !e.Firing || e.Failures != 3 || e.Threshold != 3 {
+		t.Errorf("expecting the alert to be raised with 3 failures, got %+v", e)
+	}
+	m.Failure()
+	if rate := m.Rate(); rate != 4 {
+		t.Errorf("expecting 4 failures in the last minute, got %d", rate)
+	}
+
+	// the first failure leaves the window
+	c.Advance(31 * time.Second)
+	m.refresh()
+	if !m.Firing() {
+		t.Errorf("3 failures are still over the threshold")
+	}
+	c.Advance(time.Minute)
+	m.refresh()
+	e = <-events
+	if e.Firing || e.Failures != 0 {
+		t.Errorf("expecting the alert to be cleared, got %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+}
+
+func TestMonitor_Disabled(t *testing.T) {
+	m := NewMonitor(clock.NewFake(time.Unix(1700000000, 0)))
+	for i := 0; i < 100; i++ {
+		m.Failure()
+	}
+	if m.Firing() || m.Rate() != 100 {
+		t.Errorf("the failures should be counted without alert, rate %d", m.Rate())
+	}
+	var nilMonitor *Monitor
+	nilMonitor.Failure()
+}
+
+func TestWebhook(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("cannot decode the alert: %v", err)
+		}
+		received <- e
+	}))
+	defer server.Close()
+
+	Webhook(server.URL)(Event{Firing: true, Failures: 12, Threshold: 10})
+	if e := <-received; !e.Firing || e.Failures != 12 {
+		t.Errorf("unexpected alert %+v", e)
+	}
+}
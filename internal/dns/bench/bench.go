@@ -0,0 +1,273 @@
+// Package bench generates a load of synthetic queries against a dns server over udp, and measures the latencies
+// of its responses and its errors
+package bench
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// Kind is the kind of the names asked
+type Kind string
+
+const (
+	// Random names are unique, they miss the cache and are asked to the upstreams
+	Random Kind = "random"
+	// Blocked names are names of the block lists
+	Blocked Kind = "blocked"
+	// Cached names are a few names asked again and again, answered from the cache
+	Cached Kind = "cached"
+)
+
+// defaults of the options left empty
+const (
+	defaultConcurrency = 64
+	defaultTimeout     = 2 * time.Second
+	defaultDomain      = "example.com"
+)
+
+var defaultCachedNames = []string{"example.com", "example.org", "example.net"}
+
+// Options are the load sent to the server at Target
+type Options struct {
+	Target   string
+	QPS      int
+	Duration time.Duration
+	// Concurrency is the number of queries waiting for their response at once, 64 when 0, the rate is lower than QPS
+	// when they are all waiting. Timeout is the time a query waits for its response, 2s when 0
+	Concurrency int
+	Timeout     time.Duration
+	// RandomShare and BlockedShare are the shares of the random and of the blocked names, the other ones are cached names
+	RandomShare  float64
+	BlockedShare float64
+	// Domain is the parent of the random names, example.com when empty
+	Domain       string
+	BlockedNames []string
+	// CachedNames are the names asked again, example.com, example.org and example.net when empty
+	CachedNames []string
+}
+
+// check checks the options and fills the defaults
+func (o *Options) check() error {
+	if o.Target == "" {
+		return errors.New("the benchmark needs a target address")
+	}
+	if o.QPS <= 0 || o.Duration <= 0 {
+		return errors.New("the benchmark needs a positive rate and duration")
+	}
+	if o.RandomShare < 0 || o.BlockedShare < 0 || o.RandomShare+o.BlockedShare > 1 {
+		return errors.New("the shares of the random and blocked names must be between 0 and 1 in total")
+	}
+	if o.BlockedShare > 0 && len(o.BlockedNames) == 0 {
+		return errors.New("the benchmark needs blocked names to ask")
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.Domain == "" {
+		o.Domain = defaultDomain
+	}
+	if len(o.CachedNames) == 0 {
+		o.CachedNames = defaultCachedNames
+	}
+	return nil
+}
+
+// Report is the outcome of a benchmark, the latencies are the ones of the answered queries
+type Report struct {
+	Sent     int
+	Answered int
+	Timeouts int
+	// Errors counts the responses with an error code other than NXDOMAIN, and the queries which could not be sent
+	Errors map[string]int
+	// Kinds counts the queries sent by kind of name
+	Kinds    map[Kind]int
+	Duration time.Duration
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// QPS returns the rate of the queries actually sent
+func (r Report) QPS() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Sent) / r.Duration.Seconds()
+}
+
+// ErrorRate returns the share of the queries timed out or failed
+func (r Report) ErrorRate() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Sent-r.Answered) / float64(r.Sent)
+}
+
+// outcome is the outcome of a query, its latency when answered, the error otherwise
+type outcome struct {
+	latency time.Duration
+	err     string
+}
+
+// errTimeout is the error of the queries without response in time
+const errTimeout = "timeout"
+
+// Run sends the queries at the rate of the options until their duration is over or ctx is done, then waits for
+// the responses of the queries in flight
+func Run(ctx context.Context, options Options) (Report, error) {
+	if err := options.check(); err != nil {
+		return Report{}, err
+	}
+	jobs := make(chan Kind, options.Concurrency)
+	outcomes := make(chan outcome, options.Concurrency)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < options.Concurrency; i++ {
+		conn, err := net.Dial("udp", options.Target)
+		if err != nil {
+			close(jobs)
+			wg.Wait()
+			return Report{}, errors.New("cannot reach " + options.Target + ": " + err.Error())
+		}
+		wg.Add(1)
+		go worker(conn, options, rand.New(rand.NewSource(time.Now().UnixNano()+int64(i))), jobs, outcomes, wg)
+	}
+
+	report := Report{Errors: make(map[string]int), Kinds: make(map[Kind]int)}
+	latencies := make([]time.Duration, 0, int(float64(options.QPS)*options.Duration.Seconds()))
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for o := range outcomes {
+			switch o.err {
+			case "":
+				report.Answered++
+				latencies = append(latencies, o.latency)
+			case errTimeout:
+				report.Timeouts++
+			default:
+				report.Errors[o.err]++
+			}
+		}
+	}()
+
+	pick := rand.New(rand.NewSource(time.Now().UnixNano()))
+	interval := time.Second / time.Duration(options.QPS)
+	start := time.Now()
+	for i := 0; ctx.Err() == nil; i++ {
+		next := start.Add(time.Duration(i) * interval)
+		if next.Sub(start) >= options.Duration {
+			break
+		}
+		// a late query is sent at once, the rate catches up
+		time.Sleep(time.Until(next))
+		kind := pickKind(pick, options)
+		select {
+		case jobs <- kind:
+			report.Sent++
+			report.Kinds[kind]++
+		case <-ctx.Done():
+		}
+	}
+	report.Duration = time.Since(start)
+	close(jobs)
+	wg.Wait()
+	close(outcomes)
+	<-collected
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50, report.P90, report.P99 = percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99)
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+	return report, nil
+}
+
+// pickKind returns the kind of the next name according to the shares of the options
+func pickKind(r *rand.Rand, options Options) Kind {
+	draw := r.Float64()
+	switch {
+	case draw < options.RandomShare:
+		return Random
+	case draw < options.RandomShare+options.BlockedShare:
+		return Blocked
+	}
+	return Cached
+}
+
+// worker sends the queries of the jobs through its connection until the jobs are closed, then closes the connection
+func worker(conn net.Conn, options Options, r *rand.Rand, jobs <-chan Kind, outcomes chan<- outcome, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer conn.Close()
+	buffer := make([]byte, dto.BufferMaxLength)
+	for kind := range jobs {
+		var name string
+		switch kind {
+		case Random:
+			name = "bench" + strconv.FormatUint(r.Uint64(), 36) + "." + options.Domain
+		case Blocked:
+			name = options.BlockedNames[r.Intn(len(options.BlockedNames))]
+		default:
+			name = options.CachedNames[r.Intn(len(options.CachedNames))]
+		}
+		outcomes <- ask(conn, buffer, uint16(r.Uint32()), name, options.Timeout)
+	}
+}
+
+// ask sends the query of an A record of the name and waits for its response, the late responses of the previous
+// queries of the connection are skipped
+func ask(conn net.Conn, buffer []byte, id uint16, name string, timeout time.Duration) outcome {
+	query := dto.SerializeMessage(dto.Message{ID: id, Header: dto.STANDARD_QUERY, QuestionCount: 1, Question: []dto.Question{{Name: name, Type: dto.A, Class: dto.IN}}})
+	start := time.Now()
+	_ = conn.SetDeadline(start.Add(timeout))
+	if _, err := conn.Write(query); err != nil {
+		return outcome{err: "send"}
+	}
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return outcome{err: errTimeout}
+			}
+			// the port of the server is closed
+			return outcome{err: "receive"}
+		}
+		response, err := dto.ParseMessage(buffer[:n])
+		if err != nil || response.ID != id {
+			continue
+		}
+		switch rcode := response.Header & dto.RCODE_MASK; rcode {
+		case 0, dto.NAME_ERROR:
+			return outcome{latency: time.Since(start)}
+		case dto.FORMAT_ERROR:
+			return outcome{err: "FORMERR"}
+		case dto.SERVER_FAILURE:
+			return outcome{err: "SERVFAIL"}
+		case dto.REFUSED:
+			return outcome{err: "REFUSED"}
+		default:
+			return outcome{err: "RCODE" + strconv.Itoa(int(rcode))}
+		}
+	}
+}
+
+// percentile returns the p-th percentile of the sorted latencies, 0 without latency
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
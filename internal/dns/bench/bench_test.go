@@ -0,0 +1,118 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// serve answers the random names with NXDOMAIN, refuses the blocked ones and answers an address to the other ones
+func serve(conn *net.UDPConn) {
+	buffer := make([]byte, dto.BufferMaxLength)
+	for {
+		n, address, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		query, err := dto.ParseMessage(buffer[:n])
+		if err != nil {
+			continue
+		}
+		name := query.Question[0].Name
+		response := dto.EmptyResponse(*query, dto.STANDARD_RESPONSE)
+		switch {
+		case strings.HasPrefix(name, "bench"):
+			response.Header |= dto.NAME_ERROR
+		case name == "ads.test":
+			response.Header |= dto.REFUSED
+		default:
+			response.ResponseCount = 1
+			response.Response = []dto.Record{{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.IPv4(192, 0, 2, 1).To4()}}
+		}
+		_, _ = conn.WriteToUDP(dto.SerializeMessage(response), address)
+	}
+}
+
+func TestRun(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go serve(conn)
+
+	report, err := Run(context.Background(), Options{
+		Target:       conn.LocalAddr().String(),
+		QPS:          500,
+		Duration:     200 * time.Millisecond,
+		Concurrency:  4,
+		RandomShare:  0.5,
+		BlockedShare: 0.2,
+		BlockedNames: []string{"ads.test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sent < 90 || report.Sent > 100 {
+		t.Errorf("expecting about 100 queries sent, got %d", report.Sent)
+	}
+	if report.Kinds[Random] == 0 || report.Kinds[Blocked] == 0 || report.Kinds[Cached] == 0 {
+		t.Errorf("expecting every kind of name to be asked, got %v", report.Kinds)
+	}
+	if report.Answered+report.Errors["REFUSED"] != report.Sent || report.Errors["REFUSED"] != report.Kinds[Blocked] {
+		t.Errorf("expecting the blocked names to be refused and the other ones answered, got %+v", report)
+	}
+	if report.P50 <= 0 || report.P50 > report.P99 || report.P99 > report.Max {
+		t.Errorf("unexpected latencies %v %v %v", report.P50, report.P99, report.Max)
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	// nothing answers on the socket
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	report, err := Run(context.Background(), Options{Target: conn.LocalAddr().String(), QPS: 50, Duration: 100 * time.Millisecond, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sent == 0 || report.Timeouts != report.Sent || report.ErrorRate() != 1 {
+		t.Errorf("expecting every query to time out, got %+v", report)
+	}
+}
+
+func TestOptions_check(t *testing.T) {
+	for _, options := range []Options{
+		{QPS: 10, Duration: time.Second},
+		{Target: "127.0.0.1:53", Duration: time.Second},
+		{Target: "127.0.0.1:53", QPS: 10, Duration: time.Second, RandomShare: 0.8, BlockedShare: 0.5},
+		{Target: "127.0.0.1:53", QPS: 10, Duration: time.Second, BlockedShare: 0.5},
+	} {
+		if err := options.check(); err == nil {
+			t.Errorf("expecting an error for %+v", options)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+	if got := percentile(latencies, 50); got != 50*time.Millisecond {
+		t.Errorf("percentile(50) = %v", got)
+	}
+	if got := percentile(latencies, 99); got != 99*time.Millisecond {
+		t.Errorf("percentile(99) = %v", got)
+	}
+	if got := percentile(nil, 99); got != 0 {
+		t.Errorf("percentile of nothing = %v", got)
+	}
+}
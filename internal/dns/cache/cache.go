@@ -1,16 +1,72 @@
 package cache
 
 import (
+	"context"
+
 	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
 )
 
 type Feedable interface {
 	Feed(dto.Record)
 }
 
+// SourcedFeedable is a Feedable keeping track of where the records come from
+type SourcedFeedable interface {
+	FeedFrom(record dto.Record, source string)
+}
+
+// SetFeedable is a cache keeping all the records of a name and a type, the records of the set have the same name and type
+type SetFeedable interface {
+	FeedSet(records []dto.Record, source string)
+}
+
+// AliasFeedable is a SetFeedable keeping the names of the CNAME chain leading to the set, they are reported through
+// the context of the lookups it answers, like the upstream clients report them
+type AliasFeedable interface {
+	FeedAliased(records []dto.Record, source string, aliases []string)
+}
+
+// NegativeFeedable is a cache able to remember the names without records, nxdomain tells if the name does not exist at all
+type NegativeFeedable interface {
+	FeedNegative(name string, t dto.Type, nxdomain bool, ttl uint32)
+}
+
+// ValidatedFeedable is a cache keeping the DNSSEC outcome of the answers, positive or negative, it is shared through
+// the context of the lookups it answers so a cached answer is authenticated like the answer of the upstream
+type ValidatedFeedable interface {
+	FeedValidated(records []dto.Record, source string, aliases []string, outcome dnssec.Outcome)
+	FeedNegativeValidated(name string, t dto.Type, nxdomain bool, ttl uint32, outcome dnssec.Outcome)
+}
+
+// StaleResolver is a cache able to answer with its expired records when the upstream fails (rfc8767),
+// the aliases of the records are reported through ctx. The stale window may depend on the client group of req
+type StaleResolver interface {
+	ResolveStale(name string, t dto.Type) (dto.Record, error)
+	ResolveStaleSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error)
+}
+
+// Cache stores records keyed by name and type
 type Cache interface {
-	client.Client
+	client.TypedClient
 	Feedable
 	Clear()
 }
+
+// Entry is the description of a cached record
+type Entry struct {
+	Name   string   `json:"name"`
+	Type   dto.Type `json:"type"`
+	TTL    uint32   `json:"ttl"`
+	Data   string   `json:"data"`
+	Source string   `json:"source,omitempty"`
+}
+
+// Inspectable is a cache able to list its entries
+type Inspectable interface {
+	// Entries returns the page of the entries matching the name pattern and the type, sorted by name,
+	// with the total number of matching entries. An empty pattern or a zero type matches everything
+	Entries(pattern string, t dto.Type, offset, limit int) ([]Entry, int)
+}
@@ -0,0 +1,123 @@
+package memorycache
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+const (
+	meminfoPath = "/proc/meminfo"
+	// minimum hit rate under which a full cache is allowed to grow
+	targetHitRate = 0.9
+	// ratio of available system memory under which the cache shrinks
+	memoryPressure = 0.1
+	// the capacity changes of 1/tuneStep at every tuning
+	tuneStep = 4
+)
+
+// autoTune holds the bounds of the capacity and the counters snapshot of the previous tuning
+type autoTune struct {
+	enabled      bool
+	min          int64
+	max          int64
+	lastHits     uint64
+	lastMisses   uint64
+	lastEviction uint64
+}
+
+// SetAutoTune let the cache grow and shrink its capacity between min and max bytes, based on its hit rate and on
+// the system memory pressure. The capacity is tuned every delay until ctx is done, whether the gc runs or not
+func (c *MemoryCache) SetAutoTune(ctx context.Context, wg *sync.WaitGroup, min, max int64, delay time.Duration) {
+	c.tuneLock.Lock()
+	c.tuning = autoTune{enabled: true, min: min, max: max}
+	c.tuneLock.Unlock()
+
+	wg.Add(1)
+	go tuneScheduler(ctx, wg, c, c.clock.NewTicker(delay))
+}
+
+// tune adjusts the capacity according to the activity since the last call
+func (c *MemoryCache) tune(availableRatio float64, knownRatio bool) {
+	c.tuneLock.Lock()
+	defer c.tuneLock.Unlock()
+	if !c.tuning.enabled {
+		return
+	}
+	hits, misses, evictions := c.hits.Load(), c.misses.Load(), c.evictions.Load()
+	deltaHits, deltaMisses := hits-c.tuning.lastHits, misses-c.tuning.lastMisses
+	deltaEvictions := evictions - c.tuning.lastEviction
+	c.tuning.lastHits, c.tuning.lastMisses, c.tuning.lastEviction = hits, misses, evictions
+
+	total := c.capacity()
+	step := total / tuneStep
+	switch {
+	case knownRatio && availableRatio < memoryPressure:
+		c.resize(total, max(total-step, c.tuning.min))
+	case deltaEvictions > 0 && hitRate(deltaHits, deltaMisses) < targetHitRate:
+		c.resize(total, min(total+step, c.tuning.max))
+	}
+}
+
+// resize changes the capacity of the cache from total, it is shared between the shards
+func (c *MemoryCache) resize(total, capacity int64) {
+	if capacity == total {
+		return
+	}
+	logger.Info("resizing the cache", "from", total, "to", capacity)
+	c.share(capacity)
+}
+
+func tuneScheduler(ctx context.Context, wg *sync.WaitGroup, memoryCache *MemoryCache, ticker clock.Ticker) {
+	defer wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			memoryCache.tune(availableMemoryRatio())
+		}
+	}
+}
+
+func hitRate(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 1
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// availableMemoryRatio reads the ratio of available memory of the system, false is returned when it is unknown
+func availableMemoryRatio() (float64, bool) {
+	file, err := os.Open(meminfoPath)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	var total, available int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, _ = strconv.ParseInt(fields[1], 10, 64)
+		case "MemAvailable:":
+			available, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(available) / float64(total), true
+}
@@ -0,0 +1,75 @@
+package memorycache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestMemoryCache_tune(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	// the entries have names of 5 bytes and an address of 4 bytes
+	cost := overhead + 9
+	memCache := NewMemoryCache(ctx, wg, 4*cost, 1, true, time.Hour)
+	memCache.SetAutoTune(ctx, wg, 2*cost, 8*cost, time.Hour)
+
+	for i, name := range []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com"} {
+		memCache.Feed(dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: uint32(60 + i), Data: net.ParseIP("127.0.0.1")})
+	}
+	_, _ = memCache.ResolveV4("a.com") // evicted, miss
+
+	memCache.tune(0.5, true)
+	if capacity := memCache.capacity(); capacity != 5*cost {
+		t.Fatalf("a full cache with a low hit rate should grow, capacity is %d", capacity)
+	}
+
+	memCache.tune(0.5, true)
+	if capacity := memCache.capacity(); capacity != 5*cost {
+		t.Fatalf("the cache should not grow without evictions, capacity is %d", capacity)
+	}
+
+	for i := 0; i < 5; i++ {
+		memCache.tune(0.01, true)
+	}
+	if capacity := memCache.capacity(); capacity != 2*cost {
+		t.Fatalf("the cache should shrink to its minimum under memory pressure, capacity is %d", capacity)
+	}
+	if s := memCache.shards[0]; s.remainingMemory < 0 || len(s.deadlines.memory) > 2 {
+		t.Fatalf("the cache should have evicted entries, remaining %d, entries %d", s.remainingMemory, len(s.deadlines.memory))
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func TestMemoryCache_tuneWithoutGC(t *testing.T) {
+	if ratio, known := availableMemoryRatio(); known && ratio < memoryPressure {
+		t.Skip("the system memory pressure would shrink the cache")
+	}
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	cost := overhead + 9
+	// without base ttl the gc does not run, the tuning has its own schedule
+	memCache := NewMemoryCacheWithClock(ctx, wg, 4*cost, 0, false, time.Minute, clk)
+	memCache.SetAutoTune(ctx, wg, 2*cost, 8*cost, time.Minute)
+
+	for i, name := range []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com"} {
+		memCache.Feed(dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: uint32(60 + i), Data: net.ParseIP("127.0.0.1")})
+	}
+	_, _ = memCache.ResolveV4("a.com") // evicted, miss
+
+	clk.Advance(2 * time.Minute) // the second tick is delivered once the first one is handled
+	if capacity := memCache.capacity(); capacity != 5*cost {
+		t.Fatalf("a full cache with a low hit rate should grow without gc, capacity is %d", capacity)
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
@@ -6,9 +6,9 @@ import (
 )
 
 // deadline representation of a deadline
-type deadline struct { // estimate cost is 30 bytes
+type deadline struct {
 	expiry time.Time
-	key    uint32
+	key    key
 }
 
 // structure to storee deadlines
@@ -18,11 +18,6 @@ type deadlineFolder struct {
 
 // insert insert a deadline at tyhe right postion
 func (f *deadlineFolder) insert(d deadline) {
-	f.memory = append(f.memory, d)
-	l := len(f.memory)
-	if l < 2 {
-		return
-	}
 	pos, _ := slices.BinarySearchFunc(f.memory, d, func(e, t deadline) int {
 		delta := e.expiry.UnixMilli() - t.expiry.UnixMilli()
 		switch {
@@ -34,12 +29,7 @@ func (f *deadlineFolder) insert(d deadline) {
 			return 0
 		}
 	})
-
-	if pos == l-1 {
-		return
-	}
-
-	slices.Insert(f.memory, pos, d)
+	f.memory = slices.Insert(f.memory, pos, d)
 }
 
 // shiftRightFrom shift to the right from the given position
@@ -0,0 +1,22 @@
+package memorycache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadlineFolder_insert(t *testing.T) {
+	now := time.Now()
+	folder := &deadlineFolder{}
+	for _, offset := range []int{3, 1, 4, 1, 5, 2} {
+		folder.insert(deadline{expiry: now.Add(time.Duration(offset) * time.Second)})
+	}
+	if len(folder.memory) != 6 {
+		t.Fatalf("expecting 6 deadlines, got %d", len(folder.memory))
+	}
+	for i := 1; i < len(folder.memory); i++ {
+		if folder.memory[i].expiry.Before(folder.memory[i-1].expiry) {
+			t.Fatalf("the deadlines are not sorted at %d: %v", i, folder.memory)
+		}
+	}
+}
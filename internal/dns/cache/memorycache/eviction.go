@@ -0,0 +1,97 @@
+package memorycache
+
+import "errors"
+
+// Eviction is the policy choosing the entry removed when the cache is full
+type Eviction string
+
+const (
+	// EvictTTL removes the entry expiring first
+	EvictTTL Eviction = "ttl"
+	// EvictLRU removes the entry looked up the longest time ago
+	EvictLRU Eviction = "lru"
+	// EvictLFU removes the entry looked up the fewest times, the least recently used among them
+	EvictLFU Eviction = "lfu"
+)
+
+// evictionSamples is the number of entries compared by the lru and lfu policies, tracking the order of every entry
+// would take the write lock on every lookup. The policies are exact for the caches holding fewer entries
+const evictionSamples = 16
+
+// ParseEviction returns the policy of its name, ttl when empty
+func ParseEviction(name string) (Eviction, error) {
+	switch Eviction(name) {
+	case "", EvictTTL:
+		return EvictTTL, nil
+	case EvictLRU, EvictLFU:
+		return Eviction(name), nil
+	}
+	return "", errors.New("unknown cache eviction " + name + ", expecting ttl, lru or lfu")
+}
+
+// SetEviction sets the policy choosing the entry removed when the cache is full, ttl by default
+func (c *MemoryCache) SetEviction(policy Eviction) {
+	c.lockAll()
+	defer c.unlockAll()
+	c.eviction = policy
+}
+
+// evict must be called with the lock of the shard held, it removes an entry of the shard according to the policy
+// and tells if one was removed
+func (c *MemoryCache) evict(s *shard) bool {
+	switch c.eviction {
+	case EvictLRU, EvictLFU:
+		return c.freeLeastUsed(s)
+	default:
+		return c.freeNextDeadline(s)
+	}
+}
+
+// freeNextDeadline removes the entry expiring first, the deadlines of the entries evicted or replaced are skipped
+func (c *MemoryCache) freeNextDeadline(s *shard) bool {
+	processed := 0
+	defer func() { s.deadlines.shiftLeftOf(processed) }()
+	for _, d := range s.deadlines.memory {
+		processed++
+		if e, ok := s.memory[d.key]; ok && c.deadline(e).Equal(d.expiry) {
+			s.remove(d.key, e)
+			return true
+		}
+	}
+	return false
+}
+
+// freeLeastUsed removes the least used of a sample of the entries, an expired entry is removed first.
+// The deadline of the entry is left to the gc, which skips the entries no longer cached
+func (c *MemoryCache) freeLeastUsed(s *shard) bool {
+	now := c.clock.Now()
+	var victim key
+	var least entry
+	found, sampled := false, 0
+	for k, e := range s.memory {
+		if e.expired(now) {
+			victim, least, found = k, e, true
+			break
+		}
+		if !found || c.lessUsed(e, least) {
+			victim, least, found = k, e, true
+		}
+		if sampled++; sampled == evictionSamples {
+			break
+		}
+	}
+	if found {
+		s.remove(victim, least)
+	}
+	return found
+}
+
+// lessUsed tells if a is a better candidate for the eviction than b
+func (c *MemoryCache) lessUsed(a, b entry) bool {
+	if c.eviction == EvictLFU {
+		if hitsA, hitsB := a.usage.hits.Load(), b.usage.hits.Load(); hitsA != hitsB {
+			return hitsA < hitsB
+		}
+	}
+	return a.usage.used.Load() < b.usage.used.Load()
+}
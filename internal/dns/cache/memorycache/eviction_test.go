@@ -0,0 +1,73 @@
+package memorycache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestMemoryCache_evict(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Eviction
+		evicted string
+	}{
+		{name: "expiring first", policy: EvictTTL, evicted: "popular.com"},
+		{name: "least recently used", policy: EvictLRU, evicted: "early.com"},
+		{name: "least frequently used", policy: EvictLFU, evicted: "late.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancelfunc := context.WithCancel(context.Background())
+			wg := &sync.WaitGroup{}
+			clk := clock.NewFake(time.Now())
+			// room for the three first entries, their names and their addresses
+			memCache := NewMemoryCacheWithClock(ctx, wg, 3*overhead+28+12, 1, false, time.Hour, clk)
+			memCache.SetEviction(tt.policy)
+
+			feed := func(name string, ttl uint32) {
+				memCache.Feed(dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: ttl, Data: net.ParseIP("127.0.0.1")})
+			}
+			feed("popular.com", 60)
+			feed("late.com", 3600)
+			feed("early.com", 600)
+			// early.com is looked up twice first, popular.com three times then late.com once
+			for _, name := range []string{"early.com", "early.com", "popular.com", "popular.com", "popular.com", "late.com"} {
+				clk.Advance(time.Second)
+				if _, err := memCache.ResolveV4(name); err != nil {
+					t.Fatalf("unexpected miss of %s: %v", name, err)
+				}
+			}
+
+			feed("new.com", 60)
+			for _, name := range []string{"popular.com", "late.com", "early.com", "new.com"} {
+				_, err := memCache.ResolveV4(name)
+				if evicted := err != nil; evicted != (name == tt.evicted) {
+					t.Errorf("%s evicted = %v, expecting %s to be evicted", name, evicted, tt.evicted)
+				}
+			}
+			if _, _, evictions := memCache.Counters(); evictions != 1 {
+				t.Errorf("expecting one eviction, got %d", evictions)
+			}
+
+			cancelfunc()
+			wg.Wait()
+		})
+	}
+}
+
+func TestParseEviction(t *testing.T) {
+	for name, want := range map[string]Eviction{"": EvictTTL, "ttl": EvictTTL, "lru": EvictLRU, "lfu": EvictLFU} {
+		if got, err := ParseEviction(name); err != nil || got != want {
+			t.Errorf("ParseEviction(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseEviction("fifo"); err == nil {
+		t.Errorf("expecting an error for an unknown policy")
+	}
+}
@@ -0,0 +1,78 @@
+package memorycache
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/cache"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// Entries implements cache.Inspectable, the pattern is either a glob (*.example.com) or a part of the name
+func (c *MemoryCache) Entries(pattern string, t dto.Type, offset, limit int) ([]cache.Entry, int) {
+	now := c.clock.Now()
+	matching := make([]entry, 0)
+	for _, s := range c.shards {
+		s.lock.RLock()
+		for _, e := range s.memory {
+			if e.expiry.Before(now) || (t != 0 && e.t != t) || !matchName(pattern, e.name) {
+				continue
+			}
+			matching = append(matching, e)
+		}
+		s.lock.RUnlock()
+	}
+
+	slices.SortFunc(matching, func(a, b entry) int {
+		if res := strings.Compare(a.name, b.name); res != 0 {
+			return res
+		}
+		return int(a.t) - int(b.t)
+	})
+
+	total := len(matching)
+	start := min(max(offset, 0), total)
+	end := total
+	if limit > 0 {
+		end = min(start+limit, total)
+	}
+	res := make([]cache.Entry, 0, end-start)
+	for _, e := range matching[start:end] {
+		res = append(res, cache.Entry{
+			Name:   e.name,
+			Type:   e.t,
+			TTL:    uint32(e.expiry.Sub(now).Seconds()),
+			Data:   e.value(),
+			Source: e.source,
+		})
+	}
+	return res, total
+}
+
+func matchName(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, err := path.Match(pattern, name)
+		return err == nil && ok
+	}
+	return strings.Contains(name, pattern)
+}
+
+// value returns the presentation of the data of the entry
+func (e entry) value() string {
+	switch e.negative {
+	case nxdomain:
+		return "NXDOMAIN"
+	case nodata:
+		return "NODATA"
+	}
+	records := e.records(0)
+	values := make([]string, len(records))
+	for i, record := range records {
+		values[i] = record.Value()
+	}
+	return strings.Join(values, " ")
+}
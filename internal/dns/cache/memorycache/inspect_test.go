@@ -0,0 +1,54 @@
+package memorycache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestMemoryCache_Entries(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	memCache := NewMemoryCache(ctx, wg, 1000, 1, false, time.Hour)
+
+	memCache.FeedFrom(dto.Record{Name: "www.google.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.1")}, "External")
+	memCache.FeedFrom(dto.Record{Name: "www.google.com", Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: net.ParseIP("::2")}, "External")
+	memCache.FeedFrom(dto.Record{Name: "mail.google.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.3")}, "External")
+	memCache.FeedFrom(dto.Record{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.4")}, "External")
+
+	tests := []struct {
+		name      string
+		pattern   string
+		t         dto.Type
+		offset    int
+		limit     int
+		wantNames []string
+		wantTotal int
+	}{
+		{name: "all", wantNames: []string{"example.com", "mail.google.com", "www.google.com", "www.google.com"}, wantTotal: 4},
+		{name: "glob", pattern: "*.google.com", t: dto.A, wantNames: []string{"mail.google.com", "www.google.com"}, wantTotal: 2},
+		{name: "substring", pattern: "example", wantNames: []string{"example.com"}, wantTotal: 1},
+		{name: "page", offset: 1, limit: 2, wantNames: []string{"mail.google.com", "www.google.com"}, wantTotal: 4},
+		{name: "out of range", offset: 10, limit: 2, wantNames: []string{}, wantTotal: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, total := memCache.Entries(tt.pattern, tt.t, tt.offset, tt.limit)
+			if total != tt.wantTotal || len(entries) != len(tt.wantNames) {
+				t.Fatalf("got %d entries on %d, want %d on %d", len(entries), total, len(tt.wantNames), tt.wantTotal)
+			}
+			for i, e := range entries {
+				if e.Name != tt.wantNames[i] || e.Source != "External" || e.TTL == 0 {
+					t.Errorf("unexpected entry %v at %d", e, i)
+				}
+			}
+		})
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
@@ -0,0 +1,17 @@
+package memorycache
+
+// SetMinLifetime keeps the entries of the names matched by match at least lifetime seconds in the cache, their answers
+// keep the ttl of their records so the clients ask them again as usual. The negative entries are not kept longer.
+// It must be called before using the cache
+func (c *MemoryCache) SetMinLifetime(match func(name string) bool, lifetime uint32) {
+	c.lifetimeMatch = match
+	c.minLifetime = lifetime
+}
+
+// lifetime returns the number of seconds the entry of the name with records of ttl is kept
+func (c *MemoryCache) lifetime(name string, ttl uint32) uint32 {
+	if c.lifetimeMatch == nil || !c.lifetimeMatch(name) {
+		return ttl
+	}
+	return max(ttl, c.minLifetime)
+}
@@ -0,0 +1,43 @@
+package memorycache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestMemoryCache_SetMinLifetime(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancelfunc()
+		wg.Wait()
+	}()
+	clk := clock.NewFake(time.Now())
+	memCache := NewMemoryCacheWithClock(ctx, wg, 10000, 1, false, time.Hour, clk)
+	memCache.SetMinLifetime(func(name string) bool { return name == "captive.apple.com" }, 3600)
+	for _, name := range []string{"captive.apple.com", "example.com"} {
+		memCache.Feed(dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("192.0.2.1").To4()})
+	}
+	memCache.FeedNegative("captive.apple.com", dto.AAAA, false, 60)
+
+	clk.Advance(10 * time.Minute)
+	if record, err := memCache.ResolveV4("captive.apple.com"); err != nil || record.TTL != 60 {
+		t.Errorf("expecting the entry kept with the ttl of the record, got %v, %v", record, err)
+	}
+	if _, err := memCache.ResolveV4("example.com"); err == nil {
+		t.Errorf("expecting the other entries to expire with their records")
+	}
+	if _, err := memCache.ResolveV6("captive.apple.com"); err == nil {
+		t.Errorf("expecting the negative entries to expire as usual")
+	}
+	clk.Advance(time.Hour)
+	if _, err := memCache.ResolveV4("captive.apple.com"); err == nil {
+		t.Errorf("expecting the entry to expire after its lifetime")
+	}
+}
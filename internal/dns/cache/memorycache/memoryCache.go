@@ -3,216 +3,667 @@ package memorycache
 import (
 	"context"
 	"errors"
-	"hash/fnv"
-	"log"
+	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/bluguard/dnshield/internal/dns/cache"
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/tracing"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
 )
 
-// estimate cost of one entry is 50 bytes
-const cost int64 = 50
-const defaultTTL = 60
+var logger = logging.Component("memorycache")
 
+// overhead is the memory taken by an entry besides its name and its data: its key and its value in the map of its shard,
+// its deadline and its usage
+const overhead = int64(unsafe.Sizeof(key{}) + unsafe.Sizeof(entry{}) + unsafe.Sizeof(deadline{}) + unsafe.Sizeof(usage{}))
+
+// maximum ttl of the negative entries (rfc2308 section 5)
+const maxNegativeTTL = 10800
+
+// ttl of the expired records served when the upstream fails (rfc8767 section 4)
+const staleTTL = 30
+
+// the cache is split in up to maxShards shards of at least minShardSize bytes each, the small caches have
+// a single shard so their eviction stays exact
 const (
-	v4Suffix = "_v4"
-	v6Suffix = "_v6"
+	maxShards    = 16
+	minShardSize = 64 << 10
 )
 
 var _ cache.Cache = &MemoryCache{}
 
-// MemoryCache an in memory cache implementation
+var _ cache.Inspectable = &MemoryCache{}
+var _ cache.SourcedFeedable = &MemoryCache{}
+var _ cache.SetFeedable = &MemoryCache{}
+var _ cache.AliasFeedable = &MemoryCache{}
+var _ cache.NegativeFeedable = &MemoryCache{}
+var _ cache.StaleResolver = &MemoryCache{}
+var _ client.SetClient = &MemoryCache{}
+
+// negative tells why an entry has no data
+type negative uint8
+
+const (
+	positive negative = iota
+	nxdomain
+	nodata
+)
+
+// validation is the DNSSEC outcome of an entry, a byte instead of the name of the outcome so it fits in the entry
+type validation uint8
+
+// validations are the outcomes of the validations, unvalidated is the empty outcome
+var validations = []dnssec.Outcome{"", dnssec.Secure, dnssec.Insecure, dnssec.Indeterminate}
+
+// validationOf returns the validation of the outcome, unvalidated when unknown
+func validationOf(outcome dnssec.Outcome) validation {
+	for i, o := range validations {
+		if o == outcome {
+			return validation(i)
+		}
+	}
+	return 0
+}
+
+// outcome returns the DNSSEC outcome of the validation
+func (v validation) outcome() dnssec.Outcome {
+	return validations[v]
+}
+
+// key identifies an entry by its name and its type, the name shares its bytes with the one of the entry
+type key struct {
+	name string
+	t    dto.Type
+}
+
+// entry is a cached record with the information needed to inspect it
+type entry struct {
+	name   string
+	t      dto.Type
+	data   []byte // the addresses of the A and AAAA records one after the other, the raw data otherwise
+	expiry time.Time
+	source string
+	// aliases are the names of the CNAME chain leading to the records, nil without chain
+	aliases []string
+	// negative entries remember the name or the record does not exist
+	negative negative
+	// validation is the DNSSEC outcome of the answer, unvalidated when no upstream with a policy answered
+	validation validation
+	// ttl bounds the ttl of the answers when the entry outlives its records, 0 when it does not
+	ttl uint32
+	// lifetime is the number of seconds the entry was cached for, the metered groups are answered with it until their
+	// minimum ttl from the time it was cached
+	lifetime uint32
+	// usage counts the lookups answered by the entry, shared by its copies
+	usage *usage
+}
+
+// usage are the counters of the lookups answered by an entry
+type usage struct {
+	hits atomic.Uint32
+	// popularity counts the lookups of the clients outside of the metered groups, which make the entry worth a prefetch
+	popularity atomic.Uint32
+	// used is the time of the last lookup in unix nanoseconds
+	used atomic.Int64
+}
+
+// stored returns the time the entry was cached
+func (e entry) stored() time.Time {
+	return e.expiry.Add(-time.Duration(e.lifetime) * time.Second)
+}
+
+// expired tells if the entry is no longer valid at now
+func (e entry) expired(now time.Time) bool {
+	return !now.Before(e.expiry)
+}
+
+// size returns the number of bytes taken by the entry, the name is shared with its key
+func (e entry) size() int64 {
+	res := overhead + int64(len(e.name)+len(e.data))
+	for _, alias := range e.aliases {
+		res += int64(unsafe.Sizeof(alias)) + int64(len(alias))
+	}
+	return res
+}
+
+// remainingTTL returns the number of seconds from now to the expiry of the entry, rounded up so a valid entry
+// never has a null ttl
+func (e entry) remainingTTL(now time.Time) uint32 {
+	return uint32(max(math.Ceil(e.expiry.Sub(now).Seconds()), 0))
+}
+
+// answerTTL returns the ttl of the answers of the entry at now, the remaining ttl bounded by the one of its records
+func (e entry) answerTTL(now time.Time) uint32 {
+	ttl := e.remainingTTL(now)
+	if e.ttl > 0 {
+		return min(ttl, e.ttl)
+	}
+	return ttl
+}
+
+// record returns the first record stored in the entry
+func (e entry) record(ttl uint32) dto.Record {
+	return e.records(ttl)[0]
+}
+
+// records returns the records stored in the entry, the addresses in the order of the upstream
+func (e entry) records(ttl uint32) []dto.Record {
+	size := addressSize(e.t)
+	if size == 0 {
+		return []dto.Record{{Name: e.name, Type: e.t, Class: dto.IN, TTL: ttl, Raw: e.data}}
+	}
+	res := make([]dto.Record, 0, len(e.data)/size)
+	for offset := 0; offset+size <= len(e.data); offset += size {
+		res = append(res, dto.Record{Name: e.name, Type: e.t, Class: dto.IN, TTL: ttl, Data: net.IP(e.data[offset : offset+size])})
+	}
+	return res
+}
+
+// MemoryCache an in memory cache implementation, its entries are split in shards by hash of their key so the lookups
+// and the feeds of different names do not wait for each other
 type MemoryCache struct {
-	memory          map[uint32]net.IP
-	lock            *sync.RWMutex
+	shards       []*shard
+	baseTTL      uint32
+	forceBaseTTL bool
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+	// tuneLock guards the tuning, the capacity is shared between the shards
+	tuneLock sync.Mutex
+	tuning   autoTune
+	// eviction and staleWindow are read with the lock of a shard held, they are changed with the locks of every shard held.
+	// staleWindow is the duration the expired entries are kept to be served when the upstream fails
+	eviction    Eviction
+	staleWindow time.Duration
+	// metered are the metered client groups by name, meteredTTL and meteredStale the longest minimum ttl and stale window
+	// of the groups, the entries are kept for them. They are read and changed like staleWindow
+	metered      map[string]Metered
+	meteredTTL   time.Duration
+	meteredStale time.Duration
+	// lifetimeMatch tells the names kept at least minLifetime seconds, nil when the entries live as long as their records.
+	// They are set before using the cache
+	lifetimeMatch func(name string) bool
+	minLifetime   uint32
+	clock         clock.Clock
+}
+
+// shard is a segment of the cache with its own lock, deadlines and memory budget
+type shard struct {
+	memory          map[key]entry
+	lock            sync.RWMutex
 	deadlines       *deadlineFolder
 	remainingMemory int64
 	totalCapacity   int64
-	baseTTL         uint32
-	forceBaseTTL    bool
+}
+
+// remove deletes the entry of the key from the shard and gives its size back to the budget, the lock must be held
+func (s *shard) remove(k key, e entry) {
+	delete(s.memory, k)
+	s.remainingMemory += e.size()
 }
 
 // NewMemoryCache instantiate a new cache
 func NewMemoryCache(ctx context.Context, wg *sync.WaitGroup, size int64, baseTTL uint32, forceTTL bool, gcDelay time.Duration) *MemoryCache {
-	res := MemoryCache{
-		memory:          make(map[uint32]net.IP),
-		lock:            &sync.RWMutex{},
-		deadlines:       &deadlineFolder{memory: make([]deadline, 0, 50)},
-		remainingMemory: size,
-		totalCapacity:   size,
-		baseTTL:         baseTTL,
-		forceBaseTTL:    forceTTL,
+	return NewMemoryCacheWithClock(ctx, wg, size, baseTTL, forceTTL, gcDelay, clock.Real{})
+}
+
+// NewMemoryCacheWithClock instantiate a new cache computing the expiries and scheduling the gc with the given clock
+func NewMemoryCacheWithClock(ctx context.Context, wg *sync.WaitGroup, size int64, baseTTL uint32, forceTTL bool, gcDelay time.Duration, clk clock.Clock) *MemoryCache {
+	res := &MemoryCache{
+		shards:       make([]*shard, min(max(size/minShardSize, 1), maxShards)),
+		baseTTL:      baseTTL,
+		forceBaseTTL: forceTTL,
+		eviction:     EvictTTL,
+		clock:        clk,
+	}
+	for i := range res.shards {
+		res.shards[i] = &shard{memory: make(map[key]entry), deadlines: &deadlineFolder{memory: make([]deadline, 0, 50)}}
 	}
+	res.share(size)
 
-	wg.Add(1)
 	if baseTTL > 0 {
-		go gcScheduler(ctx, wg, &res, gcDelay)
-	} else {
-		wg.Done()
+		wg.Add(1)
+		go gcScheduler(ctx, wg, res, clk.NewTicker(gcDelay))
+	}
+
+	return res
+}
+
+// Counters returns the number of lookups answered and without entry, and the number of entries evicted from the full cache
+func (c *MemoryCache) Counters() (hits, misses, evictions uint64) {
+	return c.hits.Load(), c.misses.Load(), c.evictions.Load()
+}
+
+// Len returns the number of entries in the cache, including the expired ones not yet collected
+func (c *MemoryCache) Len() int {
+	res := 0
+	for _, s := range c.shards {
+		s.lock.RLock()
+		res += len(s.memory)
+		s.lock.RUnlock()
+	}
+	return res
+}
+
+// SetServeStale keeps the expired entries during window, to answer with them when the upstream fails
+func (c *MemoryCache) SetServeStale(window time.Duration) {
+	c.lockAll()
+	defer c.unlockAll()
+	c.staleWindow = window
+}
+
+// lockAll takes the locks of every shard, in order, to change the settings of the cache
+func (c *MemoryCache) lockAll() {
+	for _, s := range c.shards {
+		s.lock.Lock()
+	}
+}
+
+// unlockAll releases the locks taken by lockAll
+func (c *MemoryCache) unlockAll() {
+	for _, s := range c.shards {
+		s.lock.Unlock()
+	}
+}
+
+// shardOf returns the shard of the entries of the key, chosen by the 32 bits fnv-1a hash of the key
+func (c *MemoryCache) shardOf(k key) *shard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(k.name); i++ {
+		h ^= uint32(k.name[i])
+		h *= 16777619
+	}
+	h ^= uint32(k.t)
+	h *= 16777619
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+// share spreads the capacity between the shards, the entries over the capacity of a shard are evicted
+func (c *MemoryCache) share(capacity int64) {
+	for i, s := range c.shards {
+		part := capacity / int64(len(c.shards))
+		if i == 0 {
+			part += capacity % int64(len(c.shards))
+		}
+		s.lock.Lock()
+		s.remainingMemory += part - s.totalCapacity
+		s.totalCapacity = part
+		for s.remainingMemory < 0 && c.evict(s) {
+		}
+		s.lock.Unlock()
 	}
+}
+
+// capacity returns the capacity of the cache in bytes, the sum of the ones of its shards
+func (c *MemoryCache) capacity() int64 {
+	_, res := c.Usage()
+	return res
+}
 
-	return &res
+// Usage returns the number of bytes taken by the entries of the cache and its capacity in bytes
+func (c *MemoryCache) Usage() (used, capacity int64) {
+	for _, s := range c.shards {
+		s.lock.RLock()
+		used += s.totalCapacity - s.remainingMemory
+		capacity += s.totalCapacity
+		s.lock.RUnlock()
+	}
+	return used, capacity
 }
 
 // ResolveV4 implements cache.Cache
 func (c *MemoryCache) ResolveV4(name string) (dto.Record, error) {
-	ip, err := c.resolve(name + v4Suffix)
-	if err != nil {
-		return dto.Record{}, err
-	}
-	return dto.Record{
-		Name:  name,
-		Type:  dto.A,
-		Class: dto.IN,
-		TTL:   defaultTTL,
-		Data:  ip.To4(),
-	}, nil
+	return c.Resolve(name, dto.A)
 }
 
 // ResolveV6 implements cache.Cache
 func (c *MemoryCache) ResolveV6(name string) (dto.Record, error) {
-	ip, err := c.resolve(name + v6Suffix)
-	if err != nil {
-		return dto.Record{}, err
+	return c.Resolve(name, dto.AAAA)
+}
+
+// Resolve implements cache.Cache
+func (c *MemoryCache) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(context.Background(), request.Request{}, name, t))
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *MemoryCache) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient, the lookup is a span of the traced queries.
+// The aliases of the records are reported through ctx, like the upstream reported them
+func (c *MemoryCache) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	_, span := tracing.Start(ctx, "cache lookup")
+	records, aliases, outcome, hit, err := c.lookup(name, t, req.Group)
+	client.ReportAliases(ctx, aliases)
+	dnssec.Share(ctx, outcome)
+	span.SetBool("dns.cache.hit", hit)
+	if len(records) > 0 {
+		span.SetInt("dns.cache.ttl", int64(records[0].TTL))
 	}
-	return dto.Record{
-		Name:  name,
-		Type:  dto.AAAA,
-		Class: dto.IN,
-		TTL:   defaultTTL,
-		Data:  ip.To16(),
-	}, nil
+	span.End(nil)
+	return records, err
 }
 
-func (c *MemoryCache) resolve(name string) (net.IP, error) {
-	res := c.get(name)
-	if res == nil {
-		return nil, errors.New("no entry found for " + name)
+// lookup returns the records of the entry of the name and the type answered to the client group with their aliases,
+// or its negative answer, and the DNSSEC outcome of the answer. It tells if the entry was found
+func (c *MemoryCache) lookup(name string, t dto.Type, group string) ([]dto.Record, []string, dnssec.Outcome, bool, error) {
+	e, ok := c.get(keyOf(name, t), group)
+	if !ok {
+		return nil, nil, "", false, errors.New("no entry found for " + name + " " + t.String())
+	}
+	ttl := e.remainingTTL(c.clock.Now())
+	switch e.negative {
+	case nxdomain:
+		return nil, nil, e.validation.outcome(), true, &client.NameError{Name: name, TTL: ttl}
+	case nodata:
+		return nil, nil, e.validation.outcome(), true, &client.NoDataError{Name: name, Type: t, TTL: ttl}
 	}
-	return res, nil
+	// the clients cache the records until the expiry of the entry, like the upstream told them
+	records := e.records(e.answerTTL(c.clock.Now()))
+	for i := range records {
+		records[i].Name = name
+	}
+	return records, e.aliases, e.validation.outcome(), true, nil
 }
 
 // Feed implements cache.Cache
 func (c *MemoryCache) Feed(record dto.Record) {
-	if c.totalCapacity < cost {
-		return
+	c.FeedFrom(record, "")
+}
+
+// FeedFrom implements cache.SourcedFeedable
+func (c *MemoryCache) FeedFrom(record dto.Record, source string) {
+	c.FeedSet([]dto.Record{record}, source)
+}
+
+// FeedSet implements cache.SetFeedable, the set is cached with the smallest ttl of its records
+func (c *MemoryCache) FeedSet(records []dto.Record, source string) {
+	c.FeedAliased(records, source, nil)
+}
+
+// FeedAliased implements cache.AliasFeedable
+func (c *MemoryCache) FeedAliased(records []dto.Record, source string, aliases []string) {
+	c.FeedValidated(records, source, aliases, "")
+}
+
+// FeedValidated implements cache.ValidatedFeedable
+func (c *MemoryCache) FeedValidated(records []dto.Record, source string, aliases []string, outcome dnssec.Outcome) {
+	if e, ok := c.newEntry(records, source); ok {
+		e.aliases = aliases
+		e.validation = validationOf(outcome)
+		c.put(keyOf(e.name, e.t), e)
+	}
+}
+
+// newEntry returns the entry caching the set of records, false when the set is not cached
+func (c *MemoryCache) newEntry(records []dto.Record, source string) (entry, bool) {
+	if len(records) == 0 {
+		return entry{}, false
 	}
-	ttl := record.TTL
-	if record.TTL < c.baseTTL {
+	ttl := records[0].TTL
+	for _, record := range records[1:] {
+		ttl = min(ttl, record.TTL)
+	}
+	if ttl < c.baseTTL {
 		if !c.forceBaseTTL {
-			return
+			return entry{}, false
 		}
 		ttl = c.baseTTL // force to the minimum ttl
 	}
-	c.put(computeName(record.Name, record.Type), computeData(record.Data, record.Type), time.Duration(ttl)*time.Second)
+	data := computeData(records)
+	if len(data) == 0 {
+		return entry{}, false
+	}
+	e := entry{
+		name:   records[0].Name,
+		t:      records[0].Type,
+		data:   data,
+		source: source,
+	}
+	e.lifetime = c.lifetime(e.name, ttl)
+	if e.lifetime > ttl {
+		e.ttl = ttl
+	}
+	e.expiry = c.clock.Now().Add(time.Duration(e.lifetime) * time.Second)
+	return e, true
+}
+
+// ResolveStale implements cache.StaleResolver
+func (c *MemoryCache) ResolveStale(name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveStaleSet(context.Background(), request.Request{}, name, t))
+}
+
+// ResolveStaleSet implements cache.StaleResolver, the entries expired for longer than the stale window of the client group
+// are not answered
+func (c *MemoryCache) ResolveStaleSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	k := keyOf(name, t)
+	s := c.shardOf(k)
+	s.lock.RLock()
+	e, ok := s.memory[k]
+	window := c.staleWindowOf(req.Group)
+	s.lock.RUnlock()
+	now := c.clock.Now()
+	if !ok || e.negative != positive || window == 0 || !now.Before(e.expiry.Add(window)) {
+		return nil, errors.New("no stale entry found for " + name + " " + t.String())
+	}
+	ttl := uint32(staleTTL)
+	if !e.expired(now) {
+		ttl = e.answerTTL(now)
+	}
+	records := e.records(ttl)
+	for i := range records {
+		records[i].Name = name
+	}
+	client.ReportAliases(ctx, e.aliases)
+	dnssec.Share(ctx, e.validation.outcome())
+	return records, nil
+}
+
+// FeedNegative implements cache.NegativeFeedable, the entry is kept for the ttl of the SOA of the zone
+func (c *MemoryCache) FeedNegative(name string, t dto.Type, isNXDomain bool, ttl uint32) {
+	c.FeedNegativeValidated(name, t, isNXDomain, ttl, "")
+}
+
+// FeedNegativeValidated implements cache.ValidatedFeedable
+func (c *MemoryCache) FeedNegativeValidated(name string, t dto.Type, isNXDomain bool, ttl uint32, outcome dnssec.Outcome) {
+	if ttl == 0 {
+		return
+	}
+	e := entry{
+		name:       name,
+		t:          t,
+		expiry:     c.clock.Now().Add(time.Duration(min(ttl, maxNegativeTTL)) * time.Second),
+		negative:   nodata,
+		validation: validationOf(outcome),
+	}
+	if isNXDomain {
+		e.negative = nxdomain
+	}
+	c.put(keyOf(name, t), e)
 }
 
 // Clear implements cache.Cache
 func (c *MemoryCache) Clear() {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	for k := range c.memory {
-		delete(c.memory, k)
+	for _, s := range c.shards {
+		s.lock.Lock()
+		for k := range s.memory {
+			delete(s.memory, k)
+		}
+		s.deadlines.shiftLeftOf(len(s.deadlines.memory))
+		s.remainingMemory = s.totalCapacity
+		s.lock.Unlock()
 	}
-	c.deadlines.shiftLeftOf(len(c.deadlines.memory))
 }
 
-func (c *MemoryCache) put(key string, address net.IP, ttl time.Duration) {
-
-	c.lock.Lock()
-	defer c.lock.Unlock()
+func (c *MemoryCache) put(k key, e entry) {
+	c.store(k, e, false)
+}
 
-	if c.remainingMemory < cost {
-		log.Println("cache is full")
-		c.freeNextDeadline()
-	} else {
-		c.remainingMemory -= cost
+// store adds the entry to its shard, an entry of the same key is only replaced once expired unless refresh is set.
+// The entries are evicted until the new one fits the budget of the shard, nothing is stored by a shard too small for it
+func (c *MemoryCache) store(k key, e entry, refresh bool) {
+	s := c.shardOf(k)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	size := e.size()
+	if s.totalCapacity < size {
+		return
 	}
 
-	hkey := hash(key)
-	if _, ok := c.memory[hkey]; ok {
+	now := c.clock.Now()
+	if old, ok := s.memory[k]; ok {
+		if !old.expired(now) && !refresh {
+			return
+		}
+		// the previous entry is replaced, its deadline is skipped by the gc
+		s.remove(k, old)
+	}
+	for s.remainingMemory < size && c.evict(s) {
+		logger.Debug("cache is full, evicted an entry", "policy", c.eviction)
+		c.evictions.Add(1)
+	}
+	if s.remainingMemory < size {
 		return
 	}
-	c.memory[hkey] = address
-	c.deadlines.insert(deadline{expiry: time.Now().Add(ttl), key: hkey})
+
+	e.usage = &usage{}
+	e.usage.used.Store(now.UnixNano())
+	s.remainingMemory -= size
+	s.memory[k] = e
+	s.deadlines.insert(deadline{expiry: c.deadline(e), key: k})
 }
 
-func (c *MemoryCache) get(key string) net.IP {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	res, ok := c.memory[hash(key)]
-	if !ok {
-		return nil
+// deadline returns the time the entry is removed, after its expiry when the stale entries are served or the metered
+// groups answered for longer
+func (c *MemoryCache) deadline(e entry) time.Time {
+	if e.negative != positive {
+		return e.expiry
+	}
+	res := e.expiry.Add(max(c.staleWindow, c.meteredStale))
+	if kept := e.stored().Add(c.meteredTTL); kept.After(res) {
+		return kept
 	}
 	return res
 }
 
+// get returns the entry of the key valid for the client group, with the expiry of the group
+func (c *MemoryCache) get(k key, group string) (entry, bool) {
+	s := c.shardOf(k)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	now := c.clock.Now()
+	res, ok := s.memory[k]
+	if ok {
+		res.expiry = c.expiryOf(res, group)
+	}
+	if !ok || res.expired(now) {
+		c.misses.Add(1)
+		return entry{}, false
+	}
+	c.hits.Add(1)
+	res.usage.hits.Add(1)
+	if _, metered := c.metered[group]; !metered {
+		res.usage.popularity.Add(1)
+	}
+	res.usage.used.Store(now.UnixNano())
+	return res, true
+}
+
+// gc collects the expired entries one shard after the other, the other shards answer meanwhile
 func (c *MemoryCache) gc() {
-	c.lock.Lock()
 	start := time.Now()
-	log.Println("trigger gc")
-	defer c.lock.Unlock()
+	logger.Debug("collecting the expired entries")
 	count := 0
-	now := time.Now()
-	for _, d := range c.deadlines.memory {
+	for _, s := range c.shards {
+		count += c.collect(s)
+	}
+	logger.Debug("collected the expired entries", "entries", count, "duration", time.Since(start))
+}
+
+// collect removes the entries of the shard past their deadline, it returns the number of removed entries
+func (c *MemoryCache) collect(s *shard) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	processed := 0
+	count := 0
+	now := c.clock.Now()
+	for _, d := range s.deadlines.memory {
 		if !d.expiry.Before(now) {
 			// the list of deadlines is sorted, no need to range over all elements
 			break
 		}
 
+		processed++
+		e, ok := s.memory[d.key]
+		if !ok || c.deadline(e).After(now) {
+			continue // the entry has been evicted or replaced since this deadline
+		}
 		count++
-		delete(c.memory, d.key)
+		s.remove(d.key, e)
 	}
-	i := count
-	c.deadlines.shiftLeftOf(i)
-	log.Println("GC cleared", count, "entries in", time.Since(start))
-	c.remainingMemory += cost * int64(count)
+	s.deadlines.shiftLeftOf(processed)
+	return count
 }
 
-func (c *MemoryCache) freeNextDeadline() {
-	delete(c.memory, c.deadlines.memory[0].key)
-	c.deadlines.shiftLeftOf(1)
+// keyOf returns the key of the entry of the name and the type
+func keyOf(name string, t dto.Type) key {
+	return key{name: name, t: t}
 }
 
-func hash(s string) uint32 {
-	h := fnv.New32a()
-	_, _ = h.Write([]byte(s))
-	return h.Sum32()
-}
-func computeName(s string, t dto.Type) string {
+// addressSize returns the size of the addresses of the type, 0 for the types which are not addresses
+func addressSize(t dto.Type) int {
 	switch t {
 	case dto.A:
-		return s + v4Suffix
+		return net.IPv4len
 	case dto.AAAA:
-		return s + v6Suffix
+		return net.IPv6len
 	default:
-		return s + v4Suffix
+		return 0
 	}
 }
 
-func computeData(iP net.IP, t dto.Type) net.IP {
-	switch t {
+// computeData returns the data of the entry of the set, the addresses one after the other or the raw data of the first record
+func computeData(records []dto.Record) []byte {
+	switch records[0].Type {
 	case dto.A:
-		return iP.To4()
+		var res []byte
+		for _, record := range records {
+			res = append(res, record.Data.To4()...)
+		}
+		return res
 	case dto.AAAA:
-		return iP.To16()
+		var res []byte
+		for _, record := range records {
+			res = append(res, record.Data.To16()...)
+		}
+		return res
 	default:
-		return nil
+		return records[0].Raw
 	}
 }
 
-func gcScheduler(ctx context.Context, wg *sync.WaitGroup, memoryCache *MemoryCache, gcDelay time.Duration) {
+func gcScheduler(ctx context.Context, wg *sync.WaitGroup, memoryCache *MemoryCache, ticker clock.Ticker) {
 	defer wg.Done()
-	ticker := time.NewTicker(gcDelay)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			memoryCache.gc()
 		}
 	}
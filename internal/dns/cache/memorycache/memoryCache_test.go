@@ -2,8 +2,10 @@ package memorycache
 
 import (
 	"context"
+	"errors"
 	"net"
 	"reflect"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -11,6 +13,8 @@ import (
 	"github.com/bluguard/dnshield/internal/dns/cache"
 	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
 )
 
 func TestMemoryCache(t *testing.T) {
@@ -27,7 +31,6 @@ func TestMemoryCache(t *testing.T) {
 
 	feedable.Feed(wantv6)
 	feedable.Feed(wantv4)
-	wantv4.TTL = 60
 
 	res, err := cl.ResolveV4("google.com")
 	if err != nil {
@@ -62,3 +65,313 @@ func TestMemoryCache(t *testing.T) {
 	cancelfunc()
 	wg.Wait()
 }
+
+func TestMemoryCacheTypes(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	memCache := NewMemoryCache(ctx, wg, 1000, 1, false, time.Second*1)
+
+	records := []dto.Record{
+		{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("127.0.0.1").To4()},
+		{Name: "example.com", Type: dto.MX, Class: dto.IN, TTL: 60, Raw: []byte{0, 10, 4, 'm', 'a', 'i', 'l', 0}},
+		{Name: "example.com", Type: dto.TXT, Class: dto.IN, TTL: 60, Raw: []byte{2, 'o', 'k'}},
+		{Name: "www.example.com", Type: dto.CNAME, Class: dto.IN, TTL: 60, Raw: []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}},
+	}
+	for _, record := range records {
+		memCache.Feed(record)
+	}
+	for _, want := range records {
+		res, err := memCache.Resolve(want.Name, want.Type)
+		if err != nil {
+			t.Fatalf("error resolving %s %s: %v", want.Name, want.Type, err)
+		}
+		if !reflect.DeepEqual(res, want) {
+			t.Errorf("error resolving %s %s, want %v got %v", want.Name, want.Type, want, res)
+		}
+	}
+
+	if _, err := memCache.Resolve("example.com", dto.SRV); err == nil {
+		t.Errorf("expecting no SRV entry")
+	}
+	if _, err := memCache.Resolve("www.example.com", dto.A); err == nil {
+		t.Errorf("the CNAME should not answer A questions")
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func TestMemoryCacheServeStale(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	memCache := NewMemoryCacheWithClock(ctx, wg, 1000, 1, false, time.Second, clk)
+	memCache.SetServeStale(5 * time.Second)
+
+	record := dto.Record{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 1, Data: net.ParseIP("10.0.0.1").To4()}
+	memCache.Feed(record)
+	clk.Advance(3 * time.Second)
+
+	if _, err := memCache.ResolveV4("example.com"); err == nil {
+		t.Fatalf("the expired entry should not be resolved")
+	}
+	stale, err := memCache.ResolveStale("example.com", dto.A)
+	if err != nil {
+		t.Fatalf("the expired entry should be kept by the gc: %v", err)
+	}
+	if stale.TTL != staleTTL || !stale.Data.Equal(record.Data) {
+		t.Errorf("unexpected stale record %v", stale)
+	}
+
+	// a fresh answer replaces the stale entry
+	record.Data = net.ParseIP("10.0.0.2").To4()
+	record.TTL = 60
+	memCache.Feed(record)
+	res, err := memCache.ResolveV4("example.com")
+	if err != nil || !res.Data.Equal(record.Data) {
+		t.Errorf("expecting the fresh record, got %v, %v", res, err)
+	}
+	clk.Advance(5 * time.Second) // the deadline of the stale entry must not remove the fresh one
+	if _, err := memCache.ResolveV4("example.com"); err != nil {
+		t.Errorf("the fresh record has been removed by the gc")
+	}
+
+	// past the window, the gc removes the stale entry
+	clk.Advance(57 * time.Second)
+	if _, err := memCache.ResolveStale("example.com", dto.A); err != nil {
+		t.Errorf("the entry should still be served stale: %v", err)
+	}
+	clk.Advance(5 * time.Second)
+	if memCache.Len() != 0 {
+		t.Errorf("the gc should have removed the stale entry")
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func TestMemoryCacheCollisions(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	memCache := NewMemoryCache(ctx, wg, 10*overhead, 1, false, time.Hour)
+
+	// the pairs of names have the same 32 bits fnv-1a hash
+	memCache.Feed(dto.Record{Name: "altarage", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.3").To4()})
+	records := []dto.Record{
+		{Name: "costarring", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.1").To4()},
+		{Name: "liquid", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.2").To4()},
+		{Name: "declinate", Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: net.ParseIP("2001:db8::1").To16()},
+		{Name: "macallums", Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: net.ParseIP("2001:db8::2").To16()},
+	}
+	for _, record := range records {
+		memCache.Feed(record)
+	}
+	for _, want := range records {
+		res, err := memCache.Resolve(want.Name, want.Type)
+		if err != nil {
+			t.Fatalf("error resolving %s: %v", want.Name, err)
+		}
+		if !res.Data.Equal(want.Data) {
+			t.Errorf("%s resolved to %v, want %v", want.Name, res.Data, want.Data)
+		}
+	}
+	if _, err := memCache.ResolveV4("zinke"); err == nil {
+		t.Errorf("zinke collides with altarage and was never cached")
+	}
+	// the names and the addresses of the five entries take 86 bytes
+	if used, _ := memCache.Usage(); memCache.Len() != len(records)+1 || used != 5*overhead+86 {
+		t.Errorf("expecting %d entries accounted, got %d entries and %d used bytes", len(records)+1, memCache.Len(), used)
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func TestMemoryCacheRemainingTTL(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	memCache := NewMemoryCacheWithClock(ctx, wg, 1000, 1, false, time.Hour, clk)
+
+	memCache.Feed(dto.Record{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 300, Data: net.ParseIP("10.0.0.1").To4()})
+	memCache.FeedNegative("missing.example.com", dto.A, true, 120)
+	clk.Advance(100*time.Second + 500*time.Millisecond)
+
+	res, err := memCache.ResolveV4("example.com")
+	if err != nil || res.TTL != 200 {
+		t.Errorf("expecting the ttl remaining until the expiry, rounded up, got %v, %v", res, err)
+	}
+	var nameError *client.NameError
+	if _, err := memCache.ResolveV4("missing.example.com"); !errors.As(err, &nameError) || nameError.TTL != 20 {
+		t.Errorf("expecting the ttl remaining of the negative entry, got %v", err)
+	}
+	clk.Advance(199 * time.Second)
+	if res, err := memCache.ResolveV4("example.com"); err != nil || res.TTL != 1 {
+		t.Errorf("expecting a valid entry to never have a null ttl, got %v, %v", res, err)
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func TestMemoryCacheSet(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	memCache := NewMemoryCacheWithClock(ctx, wg, 1000, 1, false, time.Hour, clk)
+
+	set := []dto.Record{
+		{Name: "service.com", Type: dto.AAAA, Class: dto.IN, TTL: 300, Data: net.ParseIP("2001:db8::1")},
+		{Name: "service.com", Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: net.ParseIP("2001:db8::2")},
+		{Name: "service.com", Type: dto.AAAA, Class: dto.IN, TTL: 300, Data: net.ParseIP("2001:db8::3")},
+	}
+	memCache.FeedSet(set, "External")
+
+	res, err := memCache.ResolveSet(context.Background(), request.Request{}, "service.com", dto.AAAA)
+	if err != nil || len(res) != len(set) {
+		t.Fatalf("expecting the whole set, got %v, %v", res, err)
+	}
+	for i, record := range res {
+		if !record.Data.Equal(set[i].Data) || record.TTL != 60 || record.Name != "service.com" {
+			t.Errorf("record %d = %v, want %v with the smallest ttl of the set", i, record, set[i].Data)
+		}
+	}
+	if first, err := memCache.ResolveV6("service.com"); err != nil || !first.Data.Equal(set[0].Data) {
+		t.Errorf("expecting the first address of the set, got %v, %v", first, err)
+	}
+	entries, _ := memCache.Entries("service.com", dto.AAAA, 0, 10)
+	if len(entries) != 1 || entries[0].Data != "2001:db8::1 2001:db8::2 2001:db8::3" {
+		t.Errorf("expecting one entry listing the set, got %v", entries)
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func TestMemoryCache_shards(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	size := int64(2*maxShards*minShardSize + 7)
+	memCache := NewMemoryCache(ctx, wg, size, 1, false, time.Hour)
+	if len(memCache.shards) != maxShards || memCache.capacity() != size {
+		t.Fatalf("expecting %d shards sharing %d bytes, got %d shards and %d bytes", maxShards, size, len(memCache.shards), memCache.capacity())
+	}
+	if small := NewMemoryCache(ctx, wg, 10*overhead, 1, false, time.Hour); len(small.shards) != 1 {
+		t.Errorf("expecting a single shard for a small cache, got %d", len(small.shards))
+	}
+
+	// the shards are fed and read concurrently
+	const names = 2000
+	feeders := &sync.WaitGroup{}
+	for worker := 0; worker < 4; worker++ {
+		feeders.Add(1)
+		go func(worker int) {
+			defer feeders.Done()
+			for i := worker; i < names; i += 4 {
+				name := "host" + strconv.Itoa(i) + ".example.com"
+				memCache.Feed(dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.IPv4(192, 0, 2, byte(i)).To4()})
+				_, _ = memCache.ResolveV4("host" + strconv.Itoa(i/2) + ".example.com")
+			}
+		}(worker)
+	}
+	feeders.Wait()
+	if memCache.Len() != names {
+		t.Errorf("expecting %d entries, got %d", names, memCache.Len())
+	}
+	for _, s := range memCache.shards {
+		if len(s.memory) == 0 || len(s.memory) != len(s.deadlines.memory) {
+			t.Errorf("expecting the entries to be spread over the shards, got %d entries and %d deadlines", len(s.memory), len(s.deadlines.memory))
+		}
+	}
+	if entries, total := memCache.Entries("host1*", dto.A, 0, 0); total != 1111 || len(entries) != total {
+		t.Errorf("expecting the entries of every shard to be listed, got %d", total)
+	}
+	memCache.Clear()
+	if memCache.Len() != 0 || memCache.shards[3].remainingMemory != memCache.shards[3].totalCapacity {
+		t.Errorf("expecting every shard to be emptied")
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func TestMemoryCache_usage(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	v4 := entry{name: "host.example.com", t: dto.A, data: make([]byte, net.IPv4len)}
+	v6 := entry{name: "host.example.com", t: dto.AAAA, data: make([]byte, 2*net.IPv6len)}
+	if v6.size()-v4.size() != 2*net.IPv6len-net.IPv4len {
+		t.Fatalf("expecting the addresses to be accounted, got %d bytes for A and %d for AAAA", v4.size(), v6.size())
+	}
+	capacity := 3 * v6.size()
+	memCache := NewMemoryCache(ctx, wg, capacity, 1, false, time.Hour)
+
+	for i := 0; i < 20; i++ {
+		name := "host" + strconv.Itoa(i%10) + ".example.com"
+		memCache.FeedSet([]dto.Record{
+			{Name: name, Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: net.ParseIP("2001:db8::1")},
+			{Name: name, Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: net.ParseIP("2001:db8::2")},
+		}, "")
+		memCache.Feed(dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("192.0.2.1")})
+		if used, total := memCache.Usage(); used > total || total != capacity {
+			t.Fatalf("expecting the budget of %d bytes to be enforced, %d bytes used", capacity, used)
+		}
+	}
+	var want int64
+	for _, s := range memCache.shards {
+		for _, e := range s.memory {
+			want += e.size()
+		}
+	}
+	if used, _ := memCache.Usage(); used != want || memCache.Len() != 3 {
+		t.Errorf("expecting %d bytes used by 3 entries, got %d bytes and %d entries", want, used, memCache.Len())
+	}
+	if _, _, evictions := memCache.Counters(); evictions == 0 {
+		t.Errorf("expecting the full cache to evict entries")
+	}
+
+	memCache.Clear()
+	if used, _ := memCache.Usage(); used != 0 {
+		t.Errorf("expecting an empty cache to use no memory, got %d bytes", used)
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func BenchmarkMemoryCache(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewMemoryCache(ctx, &sync.WaitGroup{}, 100000, 60, false, time.Minute)
+	names := make([]string, 1000)
+	for i := range names {
+		names[i] = "host" + strconv.Itoa(i) + ".example.com"
+		c.Feed(dto.Record{Name: names[i], Type: dto.A, Class: dto.IN, TTL: 300, Data: net.IPv4(192, 0, 2, byte(i)).To4()})
+	}
+	b.Run("hit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = c.ResolveSet(ctx, request.Request{}, names[i%len(names)], dto.A)
+		}
+	})
+	b.Run("miss", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = c.ResolveSet(ctx, request.Request{}, names[i%len(names)], dto.AAAA)
+		}
+	})
+	b.Run("feed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.Feed(dto.Record{Name: names[i%len(names)], Type: dto.AAAA, Class: dto.IN, TTL: 300, Data: net.IPv6loopback})
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				if i%10 == 0 {
+					c.Feed(dto.Record{Name: names[i%len(names)], Type: dto.AAAA, Class: dto.IN, TTL: 300, Data: net.IPv6loopback})
+					continue
+				}
+				_, _ = c.ResolveSet(ctx, request.Request{}, names[i%len(names)], dto.A)
+			}
+		})
+	})
+}
@@ -0,0 +1,42 @@
+package memorycache
+
+import "time"
+
+// Metered are the settings of a client group on a metered link: its positive answers are kept for at least MinTTL
+// from the time they were cached, and served stale for ServeStale after their expiry when the upstream fails
+type Metered struct {
+	MinTTL     time.Duration
+	ServeStale time.Duration
+}
+
+// SetMetered sets the metered client groups by name, the entries are kept long enough for the group keeping them
+// the longest
+func (c *MemoryCache) SetMetered(groups map[string]Metered) {
+	c.lockAll()
+	defer c.unlockAll()
+	c.metered = groups
+	c.meteredTTL, c.meteredStale = 0, 0
+	for _, group := range groups {
+		c.meteredTTL = max(c.meteredTTL, group.MinTTL)
+		c.meteredStale = max(c.meteredStale, group.ServeStale)
+	}
+}
+
+// expiryOf must be called with the lock of the shard of the entry held, it returns the expiry of the entry for the
+// client group, pushed to the minimum ttl of a metered group for the positive entries
+func (c *MemoryCache) expiryOf(e entry, group string) time.Time {
+	metered, ok := c.metered[group]
+	if !ok || e.negative != positive {
+		return e.expiry
+	}
+	if kept := e.stored().Add(metered.MinTTL); kept.After(e.expiry) {
+		return kept
+	}
+	return e.expiry
+}
+
+// staleWindowOf must be called with the lock of a shard held, it returns the stale window of the client group,
+// the longest of the cache and of the group when metered
+func (c *MemoryCache) staleWindowOf(group string) time.Duration {
+	return max(c.staleWindow, c.metered[group].ServeStale)
+}
@@ -0,0 +1,77 @@
+package memorycache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestMemoryCacheMetered(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	memCache := NewMemoryCacheWithClock(ctx, wg, 1000, 1, false, time.Second, clk)
+	memCache.SetMetered(map[string]Metered{"iot": {MinTTL: 10 * time.Second, ServeStale: 20 * time.Second}})
+	iot, other := request.Request{Group: "iot"}, request.Request{Group: "kids"}
+
+	memCache.Feed(dto.Record{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 1, Data: net.ParseIP("10.0.0.1").To4()})
+	if _, err := memCache.ResolveSet(ctx, iot, "example.com", dto.A); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clk.Advance(3 * time.Second)
+	if _, err := memCache.ResolveSet(ctx, other, "example.com", dto.A); err == nil {
+		t.Errorf("the entry should be expired for the other groups")
+	}
+	records, err := memCache.ResolveSet(ctx, iot, "example.com", dto.A)
+	if err != nil || len(records) != 1 || records[0].TTL != 7 {
+		t.Errorf("the metered group should be answered until the minimum ttl, got %v, %v", records, err)
+	}
+	if _, err := memCache.ResolveStaleSet(ctx, other, "example.com", dto.A); err == nil {
+		t.Errorf("the other groups do not serve stale entries")
+	}
+
+	clk.Advance(9 * time.Second)
+	if _, err := memCache.ResolveSet(ctx, iot, "example.com", dto.A); err == nil {
+		t.Errorf("the entry should be expired past the minimum ttl")
+	}
+	if _, err := memCache.ResolveStaleSet(ctx, iot, "example.com", dto.A); err != nil {
+		t.Errorf("the metered group should be served the stale entry: %v", err)
+	}
+	clk.Advance(10 * time.Second)
+	if _, err := memCache.ResolveStaleSet(ctx, iot, "example.com", dto.A); err == nil {
+		t.Errorf("the entry expired past the stale window of the group should not be served")
+	}
+	clk.Advance(2 * time.Second)
+	if memCache.Len() != 0 {
+		t.Errorf("the gc should have removed the entry")
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
+
+func TestMemoryCacheMeteredPopularity(t *testing.T) {
+	ctx, cancelfunc := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	memCache := NewMemoryCache(ctx, wg, 1000, 1, false, time.Hour)
+	memCache.SetMetered(map[string]Metered{"iot": {MinTTL: time.Minute}})
+
+	memCache.Feed(dto.Record{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.1").To4()})
+	for i := 0; i < 3; i++ {
+		_, _ = memCache.ResolveSet(ctx, request.Request{Group: "iot"}, "example.com", dto.A)
+	}
+	_, _ = memCache.ResolveSet(ctx, request.Request{}, "example.com", dto.A)
+	e, _ := memCache.get(keyOf("example.com", dto.A), "")
+	if hits, popularity := e.usage.hits.Load(), e.usage.popularity.Load(); hits != 5 || popularity != 2 {
+		t.Errorf("expecting 5 hits and a popularity of 2, got %d and %d", hits, popularity)
+	}
+
+	cancelfunc()
+	wg.Wait()
+}
@@ -0,0 +1,100 @@
+package memorycache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+// minPrefetchInterval is the minimum interval between two lookups of the entries to prefetch
+const minPrefetchInterval = time.Second
+
+// prefetch is an entry to resolve again before its expiry
+type prefetch struct {
+	name   string
+	t      dto.Type
+	source string
+}
+
+// StartPrefetch resolves again with upstream the entries answered by source hit at least threshold times
+// by the clients outside of the metered groups once they expire in less than lead, so the popular names never miss the cache. The entries of the other sources,
+// as the forwarded zones, are never asked to upstream and expire as usual.
+// The refreshed entries count their hits from zero until ctx is done
+func StartPrefetch(ctx context.Context, wg *sync.WaitGroup, c *MemoryCache, upstream client.Client, source string, threshold uint32, lead time.Duration, clk clock.Clock) {
+	wg.Add(1)
+	go prefetchScheduler(ctx, wg, c, upstream, source, threshold, lead, clk.NewTicker(max(lead/2, minPrefetchInterval)))
+}
+
+func prefetchScheduler(ctx context.Context, wg *sync.WaitGroup, c *MemoryCache, upstream client.Client, source string, threshold uint32, lead time.Duration, ticker clock.Ticker) {
+	defer wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if count := c.prefetch(upstream, c.popular(source, threshold, lead)); count > 0 {
+				logger.Debug("prefetched popular cache entries", "entries", count)
+			}
+		}
+	}
+}
+
+// popular returns the positive entries of source hit at least threshold times outside of the metered groups expiring in
+// less than lead
+func (c *MemoryCache) popular(source string, threshold uint32, lead time.Duration) []prefetch {
+	var res []prefetch
+	for _, s := range c.shards {
+		res = append(res, c.popularOf(s, source, threshold, lead)...)
+	}
+	return res
+}
+
+// popularOf returns the popular entries of source in the shard
+func (c *MemoryCache) popularOf(s *shard, source string, threshold uint32, lead time.Duration) []prefetch {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	now := c.clock.Now()
+	limit := now.Add(lead + max(c.staleWindow, c.meteredStale) + c.meteredTTL)
+	var res []prefetch
+	for _, d := range s.deadlines.memory {
+		if d.expiry.After(limit) {
+			// the list of deadlines is sorted, no need to range over all elements
+			break
+		}
+		e, ok := s.memory[d.key]
+		if !ok || !c.deadline(e).Equal(d.expiry) || e.negative != positive || e.expired(now) || e.usage.popularity.Load() < threshold || e.source != source {
+			continue // evicted, replaced since this deadline, not worth a query, or answered by another source
+		}
+		res = append(res, prefetch{name: e.name, t: e.t, source: e.source})
+	}
+	return res
+}
+
+// prefetch resolves the entries with upstream and replaces them, it returns the number of refreshed entries
+func (c *MemoryCache) prefetch(upstream client.Client, entries []prefetch) int {
+	count := 0
+	for _, p := range entries {
+		ctx, status := dnssec.WithStatus(context.Background())
+		ctx, aliases := client.WithAliases(ctx)
+		records, err := client.ResolveSet(ctx, upstream, request.Request{}, p.name, p.t)
+		if err != nil {
+			continue // the entry expires as usual
+		}
+		for i := range records {
+			records[i].Name = p.name
+		}
+		if e, ok := c.newEntry(records, p.source); ok {
+			e.aliases, e.validation = aliases.Names(), validationOf(status.Outcome())
+			c.store(keyOf(p.name, p.t), e, true)
+			count++
+		}
+	}
+	return count
+}
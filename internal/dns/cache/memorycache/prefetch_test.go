@@ -0,0 +1,53 @@
+package memorycache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestStartPrefetch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	upstream := &inmemoryclient.InMemoryClient{}
+	_ = upstream.Add("popular.com", "10.0.0.2")
+	_ = upstream.Add("rare.com", "10.0.0.4")
+	_ = upstream.Add("nas.corp", "10.0.0.6")
+
+	c := NewMemoryCacheWithClock(ctx, wg, 1000, 1, false, time.Hour, clk)
+	c.FeedFrom(dto.Record{Name: "popular.com", Type: dto.A, Class: dto.IN, TTL: 30, Data: net.ParseIP("10.0.0.1").To4()}, "External")
+	c.FeedFrom(dto.Record{Name: "rare.com", Type: dto.A, Class: dto.IN, TTL: 30, Data: net.ParseIP("10.0.0.3").To4()}, "External")
+	c.FeedFrom(dto.Record{Name: "nas.corp", Type: dto.A, Class: dto.IN, TTL: 30, Data: net.ParseIP("10.0.0.5").To4()}, "Forward")
+	for i := 0; i < 3; i++ {
+		_, _ = c.Resolve("popular.com", dto.A)
+		_, _ = c.Resolve("nas.corp", dto.A)
+	}
+	_, _ = c.Resolve("rare.com", dto.A)
+
+	StartPrefetch(ctx, wg, c, upstream, "External", 2, 10*time.Second, clk)
+	clk.Advance(35 * time.Second)
+
+	record, err := c.Resolve("popular.com", dto.A)
+	if err != nil || !record.Data.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("the popular entry should be prefetched, got %v, %v", record, err)
+	}
+	if entries, _ := c.Entries("popular.com", dto.A, 0, 10); len(entries) != 1 || entries[0].Source != "External" {
+		t.Errorf("the source of the prefetched entry should be kept, got %v", entries)
+	}
+	if _, err := c.Resolve("rare.com", dto.A); err == nil {
+		t.Errorf("the entry under the threshold should expire")
+	}
+	if _, err := c.Resolve("nas.corp", dto.A); err == nil {
+		t.Errorf("the entry of another source should not be asked to the upstream")
+	}
+
+	cancel()
+	wg.Wait()
+}
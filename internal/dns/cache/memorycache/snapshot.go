@@ -0,0 +1,140 @@
+package memorycache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+// snapshotEntry is an entry of the cache saved to disk, one json line per entry
+type snapshotEntry struct {
+	Name     string         `json:"name"`
+	Type     dto.Type       `json:"type"`
+	Data     []byte         `json:"data,omitempty"`
+	Expiry   time.Time      `json:"expiry"`
+	Source   string         `json:"source,omitempty"`
+	Aliases  []string       `json:"aliases,omitempty"`
+	Negative negative       `json:"negative,omitempty"`
+	Outcome  dnssec.Outcome `json:"outcome,omitempty"`
+	TTL      uint32         `json:"ttl,omitempty"`
+	Lifetime uint32         `json:"lifetime,omitempty"`
+}
+
+// Save writes the entries of the cache to w, including the expired ones still served stale
+func (c *MemoryCache) Save(w io.Writer) (int, error) {
+	entries := make([]snapshotEntry, 0, c.Len())
+	for _, s := range c.shards {
+		s.lock.RLock()
+		for _, e := range s.memory {
+			entries = append(entries, snapshotEntry{Name: e.name, Type: e.t, Data: e.data, Expiry: e.expiry, Source: e.source, Aliases: e.aliases, Negative: e.negative, Outcome: e.validation.outcome(), TTL: e.ttl, Lifetime: e.lifetime})
+		}
+		s.lock.RUnlock()
+	}
+
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	for _, e := range entries {
+		if err := encoder.Encode(e); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), writer.Flush()
+}
+
+// Load feeds the cache with the entries saved by Save, the entries past their deadline are skipped.
+// It returns the number of loaded entries
+func (c *MemoryCache) Load(r io.Reader) (int, error) {
+	count := 0
+	now := c.clock.Now()
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var s snapshotEntry
+		err := decoder.Decode(&s)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		e := entry{name: s.Name, t: s.Type, data: s.Data, expiry: s.Expiry, source: s.Source, aliases: s.Aliases, negative: s.Negative, validation: validationOf(s.Outcome), ttl: s.TTL, lifetime: s.Lifetime}
+		k := keyOf(e.name, e.t)
+		shard := c.shardOf(k)
+		shard.lock.RLock()
+		skipped := !c.deadline(e).After(now) || shard.totalCapacity < e.size()
+		shard.lock.RUnlock()
+		if skipped {
+			continue
+		}
+		c.put(k, e)
+		count++
+	}
+}
+
+// SaveFile saves the cache to the file at path, replaced once the snapshot is complete
+func (c *MemoryCache) SaveFile(path string) (int, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), ".snapshot")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name()) // no effect once renamed
+	count, err := c.Save(file)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	return count, os.Rename(file.Name(), path)
+}
+
+// LoadFile loads the snapshot at path, a missing file loads nothing
+func (c *MemoryCache) LoadFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return c.Load(file)
+}
+
+// StartSnapshots saves the cache to path every interval and once ctx is done, so it can be loaded by the next start
+func StartSnapshots(ctx context.Context, wg *sync.WaitGroup, c *MemoryCache, path string, interval time.Duration, clk clock.Clock) {
+	wg.Add(1)
+	go snapshotScheduler(ctx, wg, c, path, clk.NewTicker(interval))
+}
+
+func snapshotScheduler(ctx context.Context, wg *sync.WaitGroup, c *MemoryCache, path string, ticker clock.Ticker) {
+	defer wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			saveSnapshot(c, path)
+			return
+		case <-ticker.C():
+			saveSnapshot(c, path)
+		}
+	}
+}
+
+func saveSnapshot(c *MemoryCache, path string) {
+	count, err := c.SaveFile(path)
+	if err != nil {
+		logger.Error("cannot save the cache", "path", path, "err", err)
+		return
+	}
+	logger.Info("saved the cache", "path", path, "entries", count)
+}
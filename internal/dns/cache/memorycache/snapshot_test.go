@@ -0,0 +1,82 @@
+package memorycache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestMemoryCache_Snapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	saved := NewMemoryCacheWithClock(ctx, wg, 1000, 1, false, time.Minute, clk)
+	saved.FeedFrom(dto.Record{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 300, Data: net.ParseIP("10.0.0.1").To4()}, "External")
+	saved.Feed(dto.Record{Name: "example.com", Type: dto.TXT, Class: dto.IN, TTL: 300, Raw: []byte{2, 'o', 'k'}})
+	saved.Feed(dto.Record{Name: "short.com", Type: dto.A, Class: dto.IN, TTL: 10, Data: net.ParseIP("10.0.0.2").To4()})
+	saved.FeedNegative("nxdomain.com", dto.A, true, 300)
+
+	buffer := &bytes.Buffer{}
+	if count, err := saved.Save(buffer); err != nil || count != 4 {
+		t.Fatalf("MemoryCache.Save() = %d, %v, want 4 entries", count, err)
+	}
+
+	clk.Advance(time.Minute)
+	loaded := NewMemoryCacheWithClock(ctx, wg, 1000, 1, false, time.Hour, clk)
+	if count, err := loaded.Load(buffer); err != nil || count != 3 {
+		t.Fatalf("MemoryCache.Load() = %d, %v, want the 3 entries not expired", count, err)
+	}
+	record, err := loaded.Resolve("example.com", dto.A)
+	if err != nil || !record.Data.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("unexpected A record %v, %v", record, err)
+	}
+	if record, err := loaded.Resolve("example.com", dto.TXT); err != nil || !bytes.Equal(record.Raw, []byte{2, 'o', 'k'}) {
+		t.Errorf("unexpected TXT record %v, %v", record, err)
+	}
+	if _, err := loaded.Resolve("short.com", dto.A); err == nil {
+		t.Errorf("the expired entry should not be loaded")
+	}
+	var nameError *client.NameError
+	if _, err := loaded.Resolve("nxdomain.com", dto.A); !errors.As(err, &nameError) || nameError.TTL != 240 {
+		t.Errorf("expecting the negative entry with its remaining ttl, got %v", err)
+	}
+	if entries, _ := loaded.Entries("example.com", dto.A, 0, 10); len(entries) != 1 || entries[0].Source != "External" {
+		t.Errorf("the source of the entry should be kept, got %v", entries)
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestStartSnapshots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	c := NewMemoryCacheWithClock(ctx, wg, 1000, 1, false, time.Hour, clk)
+	StartSnapshots(ctx, wg, c, path, time.Minute, clk)
+
+	c.Feed(dto.Record{Name: "example.com", Type: dto.A, Class: dto.IN, TTL: 300, Data: net.ParseIP("10.0.0.1").To4()})
+	clk.Advance(time.Minute)
+	c.Feed(dto.Record{Name: "other.com", Type: dto.A, Class: dto.IN, TTL: 300, Data: net.ParseIP("10.0.0.2").To4()})
+	// the last snapshot is taken once ctx is done
+	cancel()
+	wg.Wait()
+
+	restarted := NewMemoryCacheWithClock(context.Background(), &sync.WaitGroup{}, 1000, 0, false, time.Hour, clk)
+	if count, err := restarted.LoadFile(path); err != nil || count != 2 {
+		t.Errorf("MemoryCache.LoadFile() = %d, %v, want 2 entries", count, err)
+	}
+	if count, err := restarted.LoadFile(path + ".missing"); err != nil || count != 0 {
+		t.Errorf("a missing snapshot should load nothing, got %d, %v", count, err)
+	}
+}
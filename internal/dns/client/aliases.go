@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+type aliasesKey struct{}
+
+// Aliases collects the names of the CNAME chains leading to the answers of the upstreams, through the context of the query
+type Aliases struct {
+	lock  sync.Mutex
+	names []string
+}
+
+// WithAliases returns the context collecting the names of the CNAME chains answered to a question
+func WithAliases(ctx context.Context) (context.Context, *Aliases) {
+	a := &Aliases{}
+	return context.WithValue(ctx, aliasesKey{}, a), a
+}
+
+// Names returns the lower case names of the CNAME chains, of all the upstreams which answered when several are raced
+func (a *Aliases) Names() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.names
+}
+
+// ReportAliases records the targets of the CNAME records of an answer, the upstream clients call it for every response
+func ReportAliases(ctx context.Context, names []string) {
+	a, _ := ctx.Value(aliasesKey{}).(*Aliases)
+	if a == nil || len(names) == 0 {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, name := range names {
+		a.names = append(a.names, strings.ToLower(strings.TrimSuffix(name, ".")))
+	}
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("audit")
+
+// maxPendingAudits bounds the number of comparisons running at the same time, extra samples are skipped
+const maxPendingAudits = 16
+
+var _ client.TypedClient = &AuditClient{}
+var _ client.RequestClient = &AuditClient{}
+var _ client.SetClient = &AuditClient{}
+
+// AuditClient answers with its primary client and, for a sample of the questions, asks the same
+// question to a second upstream in background and logs the answers that diverge.
+// A divergence does not prove anything by itself (CDNs legitimately answer different addresses),
+// but a high divergence rate or an NXDOMAIN from only one side is worth looking at
+type AuditClient struct {
+	primary   client.Client
+	reference client.Client
+	rate      float64
+	stats     *stats.Stats
+	slots     chan struct{}
+	pending   sync.WaitGroup
+}
+
+// NewAuditClient instantiate a new AuditClient comparing the given rate (0 to 1) of the answers of primary with reference
+func NewAuditClient(primary, reference client.Client, rate float64, s *stats.Stats) *AuditClient {
+	return &AuditClient{
+		primary:   primary,
+		reference: reference,
+		rate:      rate,
+		stats:     s,
+		slots:     make(chan struct{}, maxPendingAudits),
+	}
+}
+
+// ResolveV4 implements client.Client
+func (c *AuditClient) ResolveV4(name string) (dto.Record, error) {
+	record, err := c.primary.ResolveV4(name)
+	c.sample(name, dto.A, record, err, c.reference.ResolveV4)
+	return record, err
+}
+
+// ResolveV6 implements client.Client
+func (c *AuditClient) ResolveV6(name string) (dto.Record, error) {
+	record, err := c.primary.ResolveV6(name)
+	c.sample(name, dto.AAAA, record, err, c.reference.ResolveV6)
+	return record, err
+}
+
+// Resolve implements client.TypedClient
+func (c *AuditClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	record, err := client.Resolve(c.primary, name, t)
+	c.sample(name, t, record, err, func(name string) (dto.Record, error) {
+		return client.Resolve(c.reference, name, t)
+	})
+	return record, err
+}
+
+// ResolveRequest implements client.RequestClient, the request is only given to the primary client
+func (c *AuditClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient, only the first records of the answers are compared
+func (c *AuditClient) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	records, err := client.ResolveSet(ctx, c.primary, req, name, t)
+	record, _ := client.First(records, err)
+	c.sample(name, t, record, err, func(name string) (dto.Record, error) {
+		return client.Resolve(c.reference, name, t)
+	})
+	return records, err
+}
+
+func (c *AuditClient) sample(name string, t dto.Type, record dto.Record, err error, reference func(string) (dto.Record, error)) {
+	if rand.Float64() >= c.rate {
+		return
+	}
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		return // too many audits in flight
+	}
+	c.pending.Add(1)
+	go func() {
+		defer func() {
+			<-c.slots
+			c.pending.Done()
+		}()
+		expected, referenceErr := reference(name)
+		divergent := diverge(record, err, expected, referenceErr)
+		c.stats.Audit(divergent)
+		if divergent {
+			logger.Warn("divergent answers", "name", name, "type", t, "primary", describe(record, err), "reference", describe(expected, referenceErr))
+		}
+	}()
+}
+
+// diverge tells if two answers to the same question disagree, transient errors on either side are not considered
+func diverge(record dto.Record, err error, expected dto.Record, expectedErr error) bool {
+	nxdomain, expectedNXDomain := isNameError(err), isNameError(expectedErr)
+	if nxdomain || expectedNXDomain {
+		return nxdomain != expectedNXDomain
+	}
+	if err != nil || expectedErr != nil {
+		return false
+	}
+	return record.Value() != expected.Value()
+}
+
+func isNameError(err error) bool {
+	var nameError *client.NameError
+	return errors.As(err, &nameError)
+}
+
+func describe(record dto.Record, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return record.Value()
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+)
+
+var _ client.Client = &mockClient{}
+
+type mockClient struct {
+	answers map[string]string
+}
+
+// ResolveV4 implements client.Client
+func (m *mockClient) ResolveV4(name string) (dto.Record, error) {
+	address, ok := m.answers[name]
+	if !ok {
+		return dto.Record{}, &client.NameError{Name: name}
+	}
+	if address == "" {
+		return dto.Record{}, errors.New("timeout")
+	}
+	return dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP(address).To4()}, nil
+}
+
+// ResolveV6 implements client.Client
+func (m *mockClient) ResolveV6(name string) (dto.Record, error) {
+	return dto.Record{}, errors.New("unsupported")
+}
+
+func TestAuditClient(t *testing.T) {
+	primary := &mockClient{answers: map[string]string{
+		"same.lan":       "10.0.0.1",
+		"lying.lan":      "10.0.0.2",
+		"hidden.lan":     "10.0.0.3",
+		"unreliable.lan": "",
+	}}
+	reference := &mockClient{answers: map[string]string{
+		"same.lan":       "10.0.0.1",
+		"lying.lan":      "10.0.0.20",
+		"censored.lan":   "10.0.0.4",
+		"unreliable.lan": "10.0.0.5",
+	}}
+	s := stats.NewStats()
+	c := NewAuditClient(primary, reference, 1, s)
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "same.lan"},
+		{name: "lying.lan"},
+		{name: "hidden.lan"},
+		{name: "censored.lan", wantErr: true},
+		{name: "unreliable.lan", wantErr: true},
+		{name: "nxdomain.lan", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := c.ResolveV4(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveV4(%s) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	c.pending.Wait()
+
+	snapshot := s.Snapshot()
+	if snapshot.Audits != uint64(len(tests)) {
+		t.Errorf("expecting %d audits, got %d", len(tests), snapshot.Audits)
+	}
+	// lying, hidden and censored diverge, the transient error and the nxdomain on both sides do not
+	if snapshot.Divergent != 3 {
+		t.Errorf("expecting 3 divergent answers, got %d", snapshot.Divergent)
+	}
+	if rate := snapshot.DivergenceRate(); rate != 0.5 {
+		t.Errorf("expecting a divergence rate of 0.5, got %f", rate)
+	}
+}
+
+func TestAuditClientDisabled(t *testing.T) {
+	s := stats.NewStats()
+	c := NewAuditClient(&mockClient{}, &mockClient{}, 0, s)
+	_, _ = c.ResolveV4("nxdomain.lan")
+	c.pending.Wait()
+	if audits := s.Snapshot().Audits; audits != 0 {
+		t.Errorf("expecting no audit, got %d", audits)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	mx := dto.Record{Name: "lan", Type: dto.MX, Class: dto.IN, TTL: 60, Raw: []byte{0, 10, 4, 'm', 'a', 'i', 'l', 3, 'l', 'a', 'n', 0}}
+	if got := describe(mx, nil); got != "10 mail.lan" {
+		t.Errorf("describe() = %s, want the presentation of the MX record", got)
+	}
+	if got := describe(dto.Record{}, errors.New("timeout")); got != "timeout" {
+		t.Errorf("describe() = %s, want the error", got)
+	}
+}
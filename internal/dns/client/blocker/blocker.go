@@ -1,8 +1,13 @@
 package blocker
 
 import (
-	"errors"
+	"context"
 	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
@@ -12,52 +17,274 @@ var _ client.Client = &Blocker{}
 
 var (
 	v4Block = net.ParseIP("0.0.0.0").To4()
-	v6Block = net.ParseIP("::1").To16()
+	v6Block = net.ParseIP("::").To16()
 )
 
-const defaultTTl uint32 = 600
+// ttl of the blocked answers when none is configured, short so an unblocked name is soon resolved again by the clients
+const defaultTTl uint32 = 10
 
-type Blocker map[string]struct{}
+// Blocker is a client answering the blocking response for the names of its lists, it is safe for concurrent use
+type Blocker struct {
+	lock    sync.RWMutex
+	domains names // name -> index of the source in sources
+	// structure holds the names of the blockers refreshing the lists
+	structure Structure
+	// wildcards is the number of domains starting with "*.", blocking the subdomains of the name like the rules
+	wildcards int
+	sources   []string
+	// hits counts the questions blocked by each source, ruleHits the ones blocked by the rules
+	hits     []*atomic.Uint64
+	ruleHits atomic.Uint64
+	// allowed are the names never blocked, a "*." prefix allows all the subdomains of the name
+	allowed map[string]struct{}
+	// rules are the names blocked by the configuration, with the same "*." prefix, regexps are matched against all the names
+	rules   map[string]struct{}
+	regexps []*regexp.Regexp
+	// response is the answer to the blocked questions, it is kept by Replace
+	response Response
+	blocked  atomic.Uint64
+}
+
+// NewBlocker instantiate an empty blocker, size is the expected number of names
+func NewBlocker(size int) *Blocker {
+	return NewBlockerWithStructure(size, StructureCompact)
+}
+
+// NewBlockerWithStructure instantiate an empty blocker holding the names of its lists in the given structure
+func NewBlockerWithStructure(size int, structure Structure) *Blocker {
+	return &Blocker{
+		domains:   newNames(structure, size),
+		structure: structure,
+		allowed:   make(map[string]struct{}),
+		rules:     make(map[string]struct{}),
+	}
+}
 
 // ResolveV4 implements client.Client
 func (b *Blocker) ResolveV4(name string) (dto.Record, error) {
-	if b.contains(name) {
-		return dto.Record{
-			Name:  name,
-			Type:  dto.A,
-			Class: dto.IN,
-			TTL:   defaultTTl,
-			Data:  v4Block,
-		}, nil
-	}
-	return dto.Record{}, errors.New("not blocking")
+	return b.resolve(name, dto.A)
 }
 
 // ResolveV6 implements client.Client
 func (b *Blocker) ResolveV6(name string) (dto.Record, error) {
-	if b.contains(name) {
-		return dto.Record{
-			Name:  name,
-			Type:  dto.AAAA,
-			Class: dto.IN,
-			TTL:   defaultTTl,
-			Data:  v6Block,
-		}, nil
+	return b.resolve(name, dto.AAAA)
+}
+
+func (b *Blocker) resolve(name string, t dto.Type) (dto.Record, error) {
+	b.lock.RLock()
+	source, hits, blocked := b.match(name)
+	response := b.response
+	b.lock.RUnlock()
+	if !blocked {
+		return dto.Record{}, client.NotFound("not blocking")
+	}
+	b.blocked.Add(1)
+	hits.Add(1)
+	logger.Debug("blocked", "name", name, "list", source)
+	return response.Answer(name, t)
+}
+
+// SetResponse sets the answer to the blocked questions
+func (b *Blocker) SetResponse(r Response) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.response = r
+}
+
+// Response returns the answer to the blocked questions
+func (b *Blocker) Response() Response {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.response
+}
+
+// Blocked returns the number of questions answered by the blocker
+func (b *Blocker) Blocked() uint64 {
+	return b.blocked.Load()
+}
+
+// Len returns the number of blocked names
+func (b *Blocker) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.domains.len()
+}
+
+// Memory returns the number of bytes allocated for the names of the lists
+func (b *Blocker) Memory() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.domains.memory()
+}
+
+// BlockedRecord returns the record answered for a blocked name
+func BlockedRecord(name string, t dto.Type) dto.Record {
+	data := v4Block
+	if t == dto.AAAA {
+		data = v6Block
+	}
+	return dto.Record{
+		Name:  name,
+		Type:  t,
+		Class: dto.IN,
+		TTL:   defaultTTl,
+		Data:  data,
+	}
+}
+
+// Match returns the source which blocks the given name
+func (b *Blocker) Match(name string) (string, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	source, _, ok := b.match(name)
+	return source, ok
+}
+
+// match returns the source of the list or the rule blocking the name with the counter of its hits, the lock must be held
+func (b *Blocker) match(name string) (string, *atomic.Uint64, bool) {
+	source, ok := "", false
+	var hits *atomic.Uint64
+	if index, found := b.domains.get(name); found {
+		source, hits, ok = b.sources[index], b.hits[index], true
+	} else if index, found := b.matchWildcard(name); found {
+		source, hits, ok = b.sources[index], b.hits[index], true
+	} else if rule, found := b.matchRule(name); found {
+		source, hits, ok = ruleSource+rule, &b.ruleHits, true
+	}
+	if !ok || b.isAllowed(name) {
+		return "", nil, false
+	}
+	return source, hits, true
+}
+
+// matchWildcard returns the index of the source of the domain blocking a parent of the name with a "*." prefix,
+// the lock must be held
+func (b *Blocker) matchWildcard(name string) (uint16, bool) {
+	if b.wildcards == 0 {
+		return 0, false
+	}
+	for _, parent, found := strings.Cut(name, "."); found; _, parent, found = strings.Cut(parent, ".") {
+		if index, ok := b.domains.get("*." + parent); ok {
+			return index, true
+		}
 	}
-	return dto.Record{}, errors.New("not blocking")
+	return 0, false
+}
+
+// Allow prevents the names matching the pattern from being blocked, the pattern is a name or *.name for its subdomains
+func (b *Blocker) Allow(pattern string) {
+	pattern = normalizePattern(pattern)
+	if pattern == "" || pattern == "*" {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.allowed[pattern] = struct{}{}
+}
+
+// Disallow removes an allowed pattern, the names matching it are blocked again by the lists and the rules
+func (b *Blocker) Disallow(pattern string) {
+	pattern = normalizePattern(pattern)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.allowed, pattern)
+}
+
+// Allowed tells if the name matches an allowed pattern, whether it is blocked or not
+func (b *Blocker) Allowed(name string) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.isAllowed(name)
 }
 
-func (b Blocker) contains(name string) bool {
-	_, ok := b[name]
+// isAllowed tells if the name matches an allowed pattern, the lock must be held
+func (b *Blocker) isAllowed(name string) bool {
+	if len(b.allowed) == 0 {
+		return false
+	}
+	_, ok := matchPattern(b.allowed, strings.ToLower(name))
 	return ok
 }
 
-func (b Blocker) add(name string) {
-	b[name] = struct{}{}
+// Names returns the names blocked by the list source, the ones already blocked by a previous list are not repeated
+func (b *Blocker) Names(source string) []string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	index := -1
+	for i, s := range b.sources {
+		if s == source {
+			index = i
+			break
+		}
+	}
+	res := make([]string, 0)
+	b.domains.each(func(name string, i uint16) {
+		if int(i) == index {
+			res = append(res, name)
+		}
+	})
+	sort.Strings(res)
+	return res
 }
 
-func (b *Blocker) Init(i Initializer) {
-	i(b.add)
+// List is a blocking list with the number of names it blocks and the number of questions it blocked
+type List struct {
+	Source string `json:"source"`
+	Names  int    `json:"names"`
+	Hits   uint64 `json:"hits"`
+}
+
+// Lists returns the lists of the blocker in the order they were loaded, followed by the rules.
+// The names already blocked by a previous list are counted for that list only
+func (b *Blocker) Lists() []List {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	res := make([]List, 0, len(b.sources)+1)
+	for i, source := range b.sources {
+		res = append(res, List{Source: source, Hits: b.hits[i].Load()})
+	}
+	b.domains.each(func(_ string, i uint16) {
+		res[i].Names++
+	})
+	return append(res, List{Source: RulesList, Names: len(b.rules) + len(b.regexps), Hits: b.ruleHits.Load()})
+}
+
+// AllowedPatterns returns the patterns of the names never blocked
+func (b *Blocker) AllowedPatterns() []string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	res := make([]string, 0, len(b.allowed))
+	for pattern := range b.allowed {
+		res = append(res, pattern)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Init feeds the blocker with the names given by the initializer, source identifies the list they come from.
+// The names are no longer added once ctx is done, it returns the error of the initializer or the one of ctx
+func (b *Blocker) Init(ctx context.Context, source string, i Initializer) error {
+	b.lock.Lock()
+	index := uint16(len(b.sources))
+	b.sources = append(b.sources, source)
+	b.hits = append(b.hits, &atomic.Uint64{})
+	b.lock.Unlock()
+
+	err := i(ctx, func(name string) {
+		if ctx.Err() != nil {
+			return // the blocker is no longer used
+		}
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.domains.add(name, index) && strings.HasPrefix(name, "*.") {
+			b.wildcards++
+		}
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
 }
 
-type Initializer func(func(string))
+// Initializer gives the names of a list to add until ctx is done, it returns an error when the list cannot be read entirely
+type Initializer func(ctx context.Context, add func(string)) error
@@ -0,0 +1,487 @@
+package blocker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func feed(names ...string) Initializer {
+	return func(_ context.Context, add func(string)) error {
+		for _, name := range names {
+			add(name)
+		}
+		return nil
+	}
+}
+
+func TestBlocker(t *testing.T) {
+	b := NewBlocker(10)
+	_ = b.Init(context.Background(), "list1", feed("ads.com", "tracker.com"))
+	_ = b.Init(context.Background(), "list2", feed("tracker.com", "malware.com", "*.adnet.com"))
+
+	tests := []struct {
+		name       string
+		domain     string
+		wantSource string
+		wantOk     bool
+	}{
+		{name: "first list", domain: "ads.com", wantSource: "list1", wantOk: true},
+		{name: "both lists", domain: "tracker.com", wantSource: "list1", wantOk: true},
+		{name: "second list", domain: "malware.com", wantSource: "list2", wantOk: true},
+		{name: "not blocked", domain: "google.com", wantSource: "", wantOk: false},
+		{name: "wildcard", domain: "eu.cdn.adnet.com", wantSource: "list2", wantOk: true},
+		{name: "parent of wildcard", domain: "adnet.com", wantSource: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, ok := b.Match(tt.domain)
+			if source != tt.wantSource || ok != tt.wantOk {
+				t.Errorf("Blocker.Match() = %v %v, want %v %v", source, ok, tt.wantSource, tt.wantOk)
+			}
+			record, err := b.ResolveV4(tt.domain)
+			if (err == nil) != tt.wantOk {
+				t.Errorf("Blocker.ResolveV4() error = %v, want blocked %v", err, tt.wantOk)
+			}
+			if tt.wantOk && !reflect.DeepEqual(record, BlockedRecord(tt.domain, dto.A)) {
+				t.Errorf("Blocker.ResolveV4() = %v, want %v", record, BlockedRecord(tt.domain, dto.A))
+			}
+		})
+	}
+}
+
+func TestBlocker_Refresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+
+	b := NewBlockerWithStructure(10, StructureMap)
+	_ = b.Init(context.Background(), "list", feed("ads.com"))
+	lists := [][]string{{"tracker.com"}, {"malware.com"}}
+	release := make(chan struct{})
+	loads := 0
+	StartRefresh(ctx, wg, b, time.Hour, clk, func(ctx context.Context, next *Blocker) {
+		if next.structure != StructureMap {
+			t.Errorf("the refresh should keep the structure of the blocker, got %s", next.structure)
+		}
+		<-release
+		_ = next.Init(ctx, "list", feed(lists[loads]...))
+		loads++
+	})
+
+	clk.Advance(61 * time.Minute)
+	if _, ok := b.Match("ads.com"); !ok {
+		t.Fatalf("the lists should be kept during the refresh")
+	}
+	release <- struct{}{}
+	// the second tick is delivered once the first refresh is done
+	clk.Advance(time.Hour)
+	if _, ok := b.Match("tracker.com"); !ok {
+		t.Errorf("the first refresh should have been applied")
+	}
+	if _, ok := b.Match("ads.com"); ok {
+		t.Errorf("the refresh should remove the names no longer listed")
+	}
+
+	// a refresh interrupted by the cancellation is dropped
+	cancel()
+	release <- struct{}{}
+	wg.Wait()
+	if _, ok := b.Match("tracker.com"); !ok || b.Len() != 1 {
+		t.Errorf("expecting the lists of the first refresh, got %d names", b.Len())
+	}
+}
+
+func TestBlocker_ShareLists(t *testing.T) {
+	previous := NewBlocker(10)
+	_ = previous.Init(context.Background(), "list", feed("ads.com", "tracker.com"))
+	previous.Allow("tracker.com")
+
+	b := NewBlocker(10)
+	_ = b.AddRule("malware.com")
+	b.ShareLists(previous)
+	if source, ok := b.Match("ads.com"); !ok || source != "list" {
+		t.Errorf("expecting the names of the previous lists to be blocked, got %s %v", source, ok)
+	}
+	if _, ok := b.Match("tracker.com"); !ok {
+		t.Errorf("expecting the allowed names of the previous blocker to be dropped")
+	}
+	if _, ok := b.Match("malware.com"); !ok {
+		t.Errorf("expecting the rules to be kept")
+	}
+
+	next := NewBlocker(10)
+	_ = next.Init(context.Background(), "list", feed("tracker.com"))
+	b.Replace(next)
+	if _, ok := b.Match("ads.com"); ok || previous.Len() != 2 {
+		t.Errorf("expecting the loaded lists to replace the previous ones, which are left as they are")
+	}
+}
+
+func TestBlocker_InitOrKeep(t *testing.T) {
+	previous := NewBlocker(10)
+	_ = previous.Init(context.Background(), "list1", feed("ads.com"))
+	_ = previous.Init(context.Background(), "list2", feed("tracker.com", "*.adnet.com"))
+
+	next := NewBlocker(10)
+	if err := next.InitOrKeep(context.Background(), "list1", feed("malware.com"), previous); err != nil {
+		t.Fatal(err)
+	}
+	failing := func(_ context.Context, add func(string)) error {
+		add("metrics.com")
+		return errors.New("connection reset")
+	}
+	if err := next.InitOrKeep(context.Background(), "list2", failing, previous); err == nil {
+		t.Errorf("expecting the error of the list")
+	}
+	for name, want := range map[string]string{"malware.com": "list1", "metrics.com": "list2", "tracker.com": "list2", "cdn.adnet.com": "list2", "ads.com": ""} {
+		if source, _ := next.Match(name); source != want {
+			t.Errorf("Match(%s) = %s, want %s", name, source, want)
+		}
+	}
+}
+
+func TestBlocker_Allow(t *testing.T) {
+	b := NewBlocker(10)
+	_ = b.Init(context.Background(), "list", feed("ads.com", "ads.example.com", "img.cdn.example.com", "cdn.example.com", "tracker.com"))
+	b.Allow("ads.example.com")
+	b.Allow("*.cdn.example.com.")
+	b.Allow("Tracker.com")
+
+	tests := []struct {
+		domain      string
+		wantBlocked bool
+	}{
+		{domain: "ads.com", wantBlocked: true},
+		{domain: "ads.example.com", wantBlocked: false},
+		{domain: "img.cdn.example.com", wantBlocked: false},
+		{domain: "cdn.example.com", wantBlocked: true},
+		{domain: "tracker.com", wantBlocked: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if _, ok := b.Match(tt.domain); ok != tt.wantBlocked {
+				t.Errorf("Blocker.Match() = %v, want %v", ok, tt.wantBlocked)
+			}
+			if _, err := b.ResolveV4(tt.domain); (err == nil) != tt.wantBlocked {
+				t.Errorf("Blocker.ResolveV4() error = %v, want blocked %v", err, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestBlocker_Rules(t *testing.T) {
+	b := NewBlocker(10)
+	_ = b.Init(context.Background(), "list", feed("ads.com"))
+	for _, rule := range []string{"*.doubleclick.net", "Tracker.com.", `/^ad[0-9]+\./`, `/^ad[0-9]+\./`} {
+		if err := b.AddRule(rule); err != nil {
+			t.Fatalf("AddRule(%q) error = %v", rule, err)
+		}
+	}
+	b.Allow("ad2.example.com")
+
+	tests := []struct {
+		domain     string
+		wantSource string
+		wantOk     bool
+	}{
+		{domain: "ads.com", wantSource: "list", wantOk: true},
+		{domain: "stats.g.doubleclick.net", wantSource: "rule *.doubleclick.net", wantOk: true},
+		{domain: "doubleclick.net", wantOk: false},
+		{domain: "tracker.com", wantSource: "rule tracker.com", wantOk: true},
+		{domain: "ad1.example.com", wantSource: `rule /^ad[0-9]+\./`, wantOk: true},
+		{domain: "ad2.example.com", wantOk: false},
+		{domain: "bad1.example.com", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			source, ok := b.Match(tt.domain)
+			if source != tt.wantSource || ok != tt.wantOk {
+				t.Errorf("Blocker.Match() = %v %v, want %v %v", source, ok, tt.wantSource, tt.wantOk)
+			}
+			if _, err := b.ResolveV4(tt.domain); (err == nil) != tt.wantOk {
+				t.Errorf("Blocker.ResolveV4() error = %v, want blocked %v", err, tt.wantOk)
+			}
+		})
+	}
+	if len(b.regexps) != 1 {
+		t.Errorf("the same regexp should be compiled once, got %d", len(b.regexps))
+	}
+	if err := b.AddRule("/(/"); err == nil {
+		t.Errorf("expecting an error for an invalid regexp")
+	}
+}
+
+func TestBlocker_RemoveRule(t *testing.T) {
+	b := NewBlocker(10)
+	_ = b.Init(context.Background(), "list", feed("ads.com"))
+	for _, rule := range []string{"ads.com", "tracker.com", `/^ad[0-9]+\./`} {
+		if err := b.AddRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Allow("*.ads.com")
+	b.RemoveRule("Tracker.com.")
+	b.RemoveRule(`/^ad[0-9]+\./`)
+	b.RemoveRule("ads.com")
+	b.Disallow("*.ads.com")
+
+	for domain, wantBlocked := range map[string]bool{"tracker.com": false, "ad1.example.com": false, "ads.com": true} {
+		if _, ok := b.Match(domain); ok != wantBlocked {
+			t.Errorf("Blocker.Match(%s) = %v, want %v", domain, ok, wantBlocked)
+		}
+	}
+	if got := b.AllowedPatterns(); len(got) != 0 {
+		t.Errorf("expecting no allowed pattern, got %v", got)
+	}
+}
+
+func TestBlocker_Response(t *testing.T) {
+	sinkhole, err := ParseResponse("sinkhole", "10.0.0.80", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		response Response
+		t        dto.Type
+		wantData string
+		wantErr  error
+	}{
+		{name: "null v4", t: dto.A, wantData: "0.0.0.0"},
+		{name: "null v6", response: Response{Mode: NullMode}, t: dto.AAAA, wantData: "::"},
+		{name: "nxdomain", response: Response{Mode: NXDomainMode}, t: dto.A, wantErr: &client.NameError{}},
+		{name: "refused", response: Response{Mode: RefusedMode}, t: dto.AAAA, wantErr: &client.RefusedError{}},
+		{name: "sinkhole v4", response: sinkhole, t: dto.A, wantData: "10.0.0.80"},
+		{name: "sinkhole without v6", response: sinkhole, t: dto.AAAA, wantErr: &client.NoDataError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBlocker(10)
+			_ = b.Init(context.Background(), "list", feed("ads.com"))
+			b.SetResponse(tt.response)
+			record, err := client.Resolve(b, "ads.com", tt.t)
+			if tt.wantErr != nil {
+				if target := reflect.New(reflect.TypeOf(tt.wantErr)); !errors.As(err, target.Interface()) || !errors.Is(err, client.ErrBlocked) {
+					t.Errorf("Blocker.Resolve() error = %v, want a blocked %T", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || record.Type != tt.t || record.Data.String() != tt.wantData {
+				t.Errorf("Blocker.Resolve() = %v, %v, want %s", record, err, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestResponse_TTL(t *testing.T) {
+	if record, _ := (Response{}).Answer("ads.com", dto.A); record.TTL != defaultTTl {
+		t.Errorf("Response.Answer() ttl = %d, want the default %d", record.TTL, defaultTTl)
+	}
+	if record, _ := (Response{TTL: 60}).Answer("ads.com", dto.A); record.TTL != 60 {
+		t.Errorf("Response.Answer() ttl = %d, want 60", record.TTL)
+	}
+	var nameError *client.NameError
+	if _, err := (Response{Mode: NXDomainMode, TTL: 30}).Answer("ads.com", dto.A); !errors.As(err, &nameError) || nameError.TTL != 30 {
+		t.Errorf("Response.Answer() = %v, want NXDOMAIN with ttl 30", err)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		mode, v4, v6 string
+		wantErr      bool
+	}{
+		{mode: ""},
+		{mode: "nxdomain"},
+		{mode: "sinkhole", v6: "2001:db8::80"},
+		{mode: "sinkhole", wantErr: true},
+		{mode: "sinkhole", v4: "2001:db8::80", wantErr: true},
+		{mode: "sinkhole", v6: "10.0.0.80", wantErr: true},
+		{mode: "servfail", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseResponse(tt.mode, tt.v4, tt.v6); (err != nil) != tt.wantErr {
+			t.Errorf("ParseResponse(%q, %q, %q) error = %v, wantErr %v", tt.mode, tt.v4, tt.v6, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBlocker_Names(t *testing.T) {
+	b := NewBlocker(10)
+	_ = b.Init(context.Background(), "list1", feed("tracker.com", "ads.com"))
+	_ = b.Init(context.Background(), "list2", feed("tracker.com", "malware.com"))
+	b.Allow("*.cdn.com")
+
+	if got := b.Names("list1"); !reflect.DeepEqual(got, []string{"ads.com", "tracker.com"}) {
+		t.Errorf("Blocker.Names(list1) = %v", got)
+	}
+	if got := b.Names("list2"); !reflect.DeepEqual(got, []string{"malware.com"}) {
+		t.Errorf("Blocker.Names(list2) = %v, the names of the first list should not be repeated", got)
+	}
+	if got := b.Names("unknown"); len(got) != 0 {
+		t.Errorf("Blocker.Names(unknown) = %v", got)
+	}
+	if got := b.AllowedPatterns(); !reflect.DeepEqual(got, []string{"*.cdn.com"}) {
+		t.Errorf("Blocker.AllowedPatterns() = %v", got)
+	}
+}
+
+func TestBlocker_Lists(t *testing.T) {
+	b := NewBlocker(10)
+	_ = b.Init(context.Background(), "list1", feed("tracker.com", "ads.com"))
+	_ = b.Init(context.Background(), "list2", feed("tracker.com", "malware.com", "*.adnet.com"))
+	_ = b.AddRule("/^banner[0-9]+\\./")
+	b.Allow("ads.com")
+
+	for _, name := range []string{"tracker.com", "tracker.com", "malware.com", "img.adnet.com", "banner1.example.com", "ads.com", "example.com"} {
+		_, _ = b.ResolveV4(name)
+	}
+	want := []List{
+		{Source: "list1", Names: 2, Hits: 2},
+		{Source: "list2", Names: 2, Hits: 2},
+		{Source: RulesList, Names: 1, Hits: 1},
+	}
+	if got := b.Lists(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Blocker.Lists() = %v, want %v", got, want)
+	}
+
+	// the hits of the lists still loaded are kept by a refresh
+	next := NewBlocker(10)
+	_ = next.Init(context.Background(), "list2", feed("malware.com"))
+	_ = next.Init(context.Background(), "list3", feed("tracker.com"))
+	b.Replace(next)
+	_, _ = b.ResolveV4("tracker.com")
+	want = []List{
+		{Source: "list2", Names: 1, Hits: 2},
+		{Source: "list3", Names: 1, Hits: 1},
+		{Source: RulesList, Hits: 1},
+	}
+	if got := b.Lists(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Blocker.Lists() after a refresh = %v, want %v", got, want)
+	}
+}
+
+func TestBlocker_InitCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewBlocker(10)
+	err := b.Init(ctx, "list", func(_ context.Context, add func(string)) error {
+		add("ads.com")
+		cancel() // reconfigured during the download
+		add("tracker.com")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Init() error = %v, want %v", err, context.Canceled)
+	}
+	if _, ok := b.Match("tracker.com"); ok || b.Len() != 1 {
+		t.Errorf("expecting the names given once cancelled to be dropped, got %d names", b.Len())
+	}
+}
+
+func TestNameSet(t *testing.T) {
+	for _, structure := range []Structure{StructureCompact, StructureMap} {
+		t.Run(string(structure), func(t *testing.T) {
+			testNames(t, newNames(structure, 0))
+		})
+	}
+	set := newNameSet(0)
+	for i := 0; i < 10000; i++ {
+		set.add("host"+strconv.Itoa(i)+".ads.example.com", 0)
+	}
+	if perName := set.memory() / set.len(); perName > 64 {
+		t.Errorf("expecting a compact set, got %d bytes per name", perName)
+	}
+}
+
+func testNames(t *testing.T, set names) {
+	for i := 0; i < 10000; i++ {
+		if !set.add("host"+strconv.Itoa(i)+".ads.example.com", uint16(i%3)) {
+			t.Fatalf("expecting host%d to be added", i)
+		}
+	}
+	if set.add("host42.ads.example.com", 2) {
+		t.Errorf("a name must be added once")
+	}
+	if set.add(strings.Repeat("a", maxNameLength+1), 0) {
+		t.Errorf("a name longer than %d bytes must not be added", maxNameLength)
+	}
+	if set.len() != 10000 {
+		t.Errorf("expecting 10000 names, got %d", set.len())
+	}
+	if source, ok := set.get("host42.ads.example.com"); !ok || source != 0 {
+		t.Errorf("expecting the first source for host42, got %d, %v", source, ok)
+	}
+	if _, ok := set.get("host10000.ads.example.com"); ok {
+		t.Errorf("unexpected name")
+	}
+	count := 0
+	set.each(func(name string, source uint16) {
+		if index, ok := set.get(name); !ok || index != source {
+			t.Errorf("unexpected source %d of %s", source, name)
+		}
+		count++
+	})
+	if count != set.len() {
+		t.Errorf("expecting %d names, got %d", set.len(), count)
+	}
+}
+
+func TestCloaking(t *testing.T) {
+	b := NewBlocker(10)
+	if err := b.Init(context.Background(), "trackers", feed("tracker.example.net")); err != nil {
+		t.Fatal(err)
+	}
+	b.SetResponse(Response{Mode: NXDomainMode})
+	c := NewCloaking(b)
+	question := dto.Question{Name: "metrics.example.com", Type: dto.A, Class: dto.IN}
+
+	if _, cloaked, err := c.Cloak(request.Request{}, question, []string{"cdn.example.net"}); cloaked || err != nil {
+		t.Errorf("expecting the answer to be kept, got %v, %v", cloaked, err)
+	}
+	_, cloaked, err := c.Cloak(request.Request{}, question, []string{"cdn.example.net", "tracker.example.net"})
+	var nameError *client.NameError
+	if !cloaked || !errors.As(err, &nameError) || !errors.Is(err, client.ErrBlocked) || nameError.Name != "metrics.example.com" {
+		t.Errorf("expecting the blocking response, got %v, %v", cloaked, err)
+	}
+	if b.Blocked() != 1 {
+		t.Errorf("expecting 1 blocked answer, got %d", b.Blocked())
+	}
+	paused := net.ParseIP("192.168.1.5")
+	c.SetPaused(func(ip net.IP) bool { return ip.Equal(paused) })
+	if _, cloaked, _ := c.Cloak(request.Request{Client: paused}, question, []string{"tracker.example.net"}); cloaked {
+		t.Error("the answers of a paused client must not be blocked")
+	}
+	if _, cloaked, _ := c.Cloak(request.Request{Client: net.ParseIP("192.168.1.6")}, question, []string{"tracker.example.net"}); !cloaked {
+		t.Error("the answers of the other clients must still be blocked")
+	}
+	b.Allow("tracker.example.net")
+	if _, cloaked, _ := c.Cloak(request.Request{}, question, []string{"tracker.example.net"}); cloaked {
+		t.Error("an allowed alias must not be blocked")
+	}
+}
+
+func BenchmarkBlocker_Match(b *testing.B) {
+	names := make([]string, 0, 100000)
+	for i := 0; i < cap(names); i++ {
+		names = append(names, "ads"+strconv.Itoa(i)+".example.com")
+	}
+	blocker := NewBlocker(len(names))
+	_ = blocker.Init(context.Background(), "list", feed(append(names, "*.adnet.com")...))
+	questions := []string{"ads42.example.com", "cdn.tracker.adnet.com", "www.google.com"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		blocker.Match(questions[i%len(questions)])
+	}
+}
@@ -0,0 +1,62 @@
+package blocker
+
+import (
+	"net"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+// Matcher tells if a name is blocked for the client of a request, with the blocker and the source blocking it
+type Matcher interface {
+	MatchRequest(req request.Request, name string) (*Blocker, string, bool)
+}
+
+var _ Matcher = &Blocker{}
+
+// MatchRequest implements Matcher, the blocker applies to every client
+func (b *Blocker) MatchRequest(_ request.Request, name string) (*Blocker, string, bool) {
+	source, blocked := b.Match(name)
+	return b, source, blocked
+}
+
+// Cloaking blocks the answers whose CNAME chain goes through a name blocked for the client, the trackers hiding
+// behind a CNAME of the domain of the site. The chains are the ones reported by the upstream clients and kept by
+// the cache, they are checked for every request, so an answer cached for a client is checked again for the next ones
+type Cloaking struct {
+	matcher Matcher
+	// paused tells if the blocking is paused for a client, nil when it is never paused
+	paused func(client net.IP) bool
+}
+
+// NewCloaking returns the check of the CNAME chains against the names blocked for the clients by matcher
+func NewCloaking(matcher Matcher) *Cloaking {
+	return &Cloaking{matcher: matcher}
+}
+
+// SetPaused sets the function telling if the blocking is paused for a client, its answers are no longer checked meanwhile
+func (c *Cloaking) SetPaused(paused func(client net.IP) bool) {
+	c.paused = paused
+}
+
+// Cloak returns the blocking response to the question when one of the aliases of its answer is blocked for the client
+// of the request, false when the answer is kept
+func (c *Cloaking) Cloak(req request.Request, question dto.Question, aliases []string) ([]dto.Record, bool, error) {
+	if len(aliases) == 0 || (c.paused != nil && c.paused(req.Client)) {
+		return nil, false, nil
+	}
+	for _, alias := range aliases {
+		b, source, blocked := c.matcher.MatchRequest(req, alias)
+		if !blocked {
+			continue
+		}
+		logger.Debug("blocking the answer through a blocked alias", "name", question.Name, "alias", alias, "source", source)
+		b.blocked.Add(1)
+		record, err := b.Response().Answer(question.Name, question.Type)
+		if err != nil {
+			return nil, true, err
+		}
+		return []dto.Record{record}, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,214 @@
+package blocker
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+)
+
+// Structure is the structure holding the names of the lists
+type Structure string
+
+const (
+	// StructureCompact interns the names in an arena, about 8 bytes per name on top of the name itself
+	StructureCompact Structure = "compact"
+	// StructureMap keeps the names in a map, about 5 times the memory of the compact one for faster loads and lookups
+	StructureMap Structure = "map"
+)
+
+// mapEntry is the memory taken by a name of the map structure on top of the name itself
+const mapEntry = 40
+
+// ParseStructure returns the structure of its name, compact when empty
+func ParseStructure(name string) (Structure, error) {
+	switch Structure(name) {
+	case "", StructureCompact:
+		return StructureCompact, nil
+	case StructureMap:
+		return StructureMap, nil
+	}
+	return "", errors.New("unknown blocking list structure " + name + ", expecting compact or map")
+}
+
+// names is a set of names with the index of the list they come from, it is not safe for concurrent use
+type names interface {
+	len() int
+	// memory returns the number of bytes allocated for the names
+	memory() int
+	get(name string) (uint16, bool)
+	// add returns false when the name is already in the set or too long to be a name
+	add(name string, source uint16) bool
+	each(f func(name string, source uint16))
+}
+
+// newNames returns an empty set of the structure allocated for size names
+func newNames(structure Structure, size int) names {
+	if structure == StructureMap {
+		return newMapSet(size)
+	}
+	return newNameSet(size)
+}
+
+const (
+	// entryHeader is the size of the header of an entry of the arena: the index of its source then the length of its name
+	entryHeader = 3
+	// averageEntry is the expected size of an entry, the arena is allocated for it before loading the lists
+	averageEntry = 24
+	// maxNameLength is the longest name of the set, a valid name is never longer
+	maxNameLength = 255
+	minSlots      = 8
+)
+
+// nameSet is a compact set of names with the index of the list they come from, it replaces a map of strings which
+// costs several tens of bytes per name on top of the name: the names are interned one after the other in a single
+// arena and indexed by an open addressing table of their offsets, about 8 bytes per name on top of the name itself.
+// It is not safe for concurrent use
+type nameSet struct {
+	// arena holds the entries: the index of the source on 2 bytes, the length of the name on 1 byte then the name
+	arena []byte
+	// slots are the offsets of the entries in the arena plus one, 0 for a free slot, their number is a power of two
+	slots []uint32
+	count int
+	seed  maphash.Seed
+}
+
+// newNameSet returns an empty set allocated for size names
+func newNameSet(size int) *nameSet {
+	slots := minSlots
+	for slots*3 < size*4 {
+		slots *= 2
+	}
+	return &nameSet{
+		arena: make([]byte, 0, size*averageEntry),
+		slots: make([]uint32, slots),
+		seed:  maphash.MakeSeed(),
+	}
+}
+
+// len returns the number of names
+func (s *nameSet) len() int {
+	return s.count
+}
+
+// memory returns the number of bytes allocated for the names
+func (s *nameSet) memory() int {
+	return cap(s.arena) + 4*cap(s.slots)
+}
+
+// get returns the index of the source of the name
+func (s *nameSet) get(name string) (uint16, bool) {
+	slot, found := s.find(name)
+	if !found {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(s.arena[s.slots[slot]-1:]), true
+}
+
+// add adds the name with the index of its source, it returns false when the name is already in the set
+// or too long to be a name
+func (s *nameSet) add(name string, source uint16) bool {
+	if len(name) > maxNameLength {
+		return false
+	}
+	slot, found := s.find(name)
+	if found {
+		return false
+	}
+	if uint64(len(s.arena)) >= 1<<32-1-entryHeader-maxNameLength {
+		return false // the offsets would overflow, beyond the memory of the devices the blocker runs on
+	}
+	s.slots[slot] = uint32(len(s.arena)) + 1
+	s.arena = binary.BigEndian.AppendUint16(s.arena, source)
+	s.arena = append(append(s.arena, byte(len(name))), name...)
+	s.count++
+	if s.count*4 > len(s.slots)*3 {
+		s.grow()
+	}
+	return true
+}
+
+// each calls f for each name of the set with the index of its source
+func (s *nameSet) each(f func(name string, source uint16)) {
+	for offset := 0; offset < len(s.arena); offset += entryHeader + int(s.arena[offset+2]) {
+		f(string(s.name(offset)), binary.BigEndian.Uint16(s.arena[offset:]))
+	}
+}
+
+// find returns the slot of the name, or the free slot where to add it when it is not in the set
+func (s *nameSet) find(name string) (int, bool) {
+	mask := len(s.slots) - 1
+	for slot := int(maphash.String(s.seed, name)) & mask; ; slot = (slot + 1) & mask {
+		offset := s.slots[slot]
+		if offset == 0 {
+			return slot, false
+		}
+		if string(s.name(int(offset-1))) == name { // compared without copying the name
+			return slot, true
+		}
+	}
+}
+
+// name returns the name of the entry at offset in the arena
+func (s *nameSet) name(offset int) []byte {
+	length := int(s.arena[offset+2])
+	return s.arena[offset+entryHeader : offset+entryHeader+length]
+}
+
+// grow doubles the number of slots and indexes the entries again
+func (s *nameSet) grow() {
+	slots := make([]uint32, 2*len(s.slots))
+	mask := len(slots) - 1
+	for _, offset := range s.slots {
+		if offset == 0 {
+			continue
+		}
+		slot := int(maphash.Bytes(s.seed, s.name(int(offset-1)))) & mask
+		for slots[slot] != 0 {
+			slot = (slot + 1) & mask
+		}
+		slots[slot] = offset
+	}
+	s.slots = slots
+}
+
+// mapSet is the set of names held in a map
+type mapSet struct {
+	names map[string]uint16
+	// bytes is the length of the names
+	bytes int
+}
+
+func newMapSet(size int) *mapSet {
+	return &mapSet{names: make(map[string]uint16, size)}
+}
+
+func (s *mapSet) len() int {
+	return len(s.names)
+}
+
+func (s *mapSet) memory() int {
+	return s.bytes + mapEntry*len(s.names)
+}
+
+func (s *mapSet) get(name string) (uint16, bool) {
+	source, ok := s.names[name]
+	return source, ok
+}
+
+func (s *mapSet) add(name string, source uint16) bool {
+	if len(name) > maxNameLength {
+		return false
+	}
+	if _, found := s.names[name]; found {
+		return false
+	}
+	s.names[name] = source
+	s.bytes += len(name)
+	return true
+}
+
+func (s *mapSet) each(f func(name string, source uint16)) {
+	for name, source := range s.names {
+		f(name, source)
+	}
+}
@@ -0,0 +1,100 @@
+package blocker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("blocker")
+
+// Replace swaps the names of the blocker with the ones of next at once, next must not be used afterwards.
+// The hits of the lists still loaded are kept
+func (b *Blocker) Replace(next *Blocker) {
+	next.lock.RLock()
+	domains, wildcards, sources, hits, allowed := next.domains, next.wildcards, next.sources, next.hits, next.allowed
+	rules, regexps := next.rules, next.regexps
+	next.lock.RUnlock()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	previous := make(map[string]*atomic.Uint64, len(b.sources))
+	for i, source := range b.sources {
+		previous[source] = b.hits[i]
+	}
+	for i, source := range sources {
+		if counter, ok := previous[source]; ok {
+			hits[i] = counter
+		}
+	}
+	b.domains, b.wildcards, b.sources, b.hits, b.allowed = domains, wildcards, sources, hits, allowed
+	b.rules, b.regexps = rules, regexps
+}
+
+// ShareLists makes the blocker block the names of the lists of previous until they are replaced, its rules and its
+// allowed names are kept. The lists of previous must be loaded, they are no longer changed
+func (b *Blocker) ShareLists(previous *Blocker) {
+	previous.lock.RLock()
+	domains, wildcards, sources, hits := previous.domains, previous.wildcards, previous.sources, previous.hits
+	previous.lock.RUnlock()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.domains, b.wildcards, b.sources, b.hits = domains, wildcards, sources, hits
+}
+
+// InitOrKeep feeds the blocker with the names of the list source like Init, when the initializer fails the names
+// previous blocked for the source are added too, so a list which cannot be downloaded keeps blocking its names.
+// previous is nil for the first load of the lists
+func (b *Blocker) InitOrKeep(ctx context.Context, source string, i Initializer, previous *Blocker) error {
+	err := b.Init(ctx, source, i)
+	if err == nil || previous == nil || ctx.Err() != nil {
+		return err
+	}
+	names := previous.Names(source)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	index := len(b.sources) - 1
+	for index > 0 && b.sources[index] != source {
+		index--
+	}
+	for _, name := range names {
+		if b.domains.add(name, uint16(index)) && strings.HasPrefix(name, "*.") {
+			b.wildcards++
+		}
+	}
+	logger.Warn("keeping the previous names of the list", "source", source, "names", len(names))
+	return err
+}
+
+// StartRefresh reloads the lists of the blocker with load every interval until ctx is done, load stops once ctx is done.
+// The lists are loaded in a new blocker replacing the names of b once complete, so b never answers with a partial set
+func StartRefresh(ctx context.Context, wg *sync.WaitGroup, b *Blocker, interval time.Duration, clk clock.Clock, load func(context.Context, *Blocker)) {
+	wg.Add(1)
+	go refreshScheduler(ctx, wg, b, clk.NewTicker(interval), load)
+}
+
+func refreshScheduler(ctx context.Context, wg *sync.WaitGroup, b *Blocker, ticker clock.Ticker, load func(context.Context, *Blocker)) {
+	defer wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			logger.Info("refreshing the blocking lists")
+			next := NewBlockerWithStructure(b.Len(), b.structure)
+			load(ctx, next)
+			if ctx.Err() != nil {
+				return // reconfigured during the download, the blocker is no longer used
+			}
+			b.Replace(next)
+			logger.Info("blocking lists refreshed", "names", b.Len())
+		}
+	}
+}
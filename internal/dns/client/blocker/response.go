@@ -0,0 +1,104 @@
+package blocker
+
+import (
+	"errors"
+	"net"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// Mode is the way the blocked questions are answered
+type Mode string
+
+const (
+	// NullMode answers 0.0.0.0 and ::
+	NullMode Mode = "null"
+	// NXDomainMode answers the name does not exist
+	NXDomainMode Mode = "nxdomain"
+	// RefusedMode refuses to answer
+	RefusedMode Mode = "refused"
+	// SinkholeMode answers the addresses of a sinkhole, NODATA for a family without address
+	SinkholeMode Mode = "sinkhole"
+)
+
+// Response is the answer to the blocked questions, the zero value is the NullMode
+type Response struct {
+	Mode Mode
+	// V4 and V6 are the addresses of the sinkhole
+	V4 net.IP
+	V6 net.IP
+	// TTL is the ttl of the answers, a short default when zero
+	TTL uint32
+}
+
+// ParseResponse returns the response of the mode, the addresses are only used by the SinkholeMode
+func ParseResponse(mode, v4, v6 string) (Response, error) {
+	res := Response{Mode: Mode(mode)}
+	switch res.Mode {
+	case "", NullMode, NXDomainMode, RefusedMode:
+		return res, nil
+	case SinkholeMode:
+	default:
+		return res, errors.New("unknown blocking mode " + mode + ", expecting null, nxdomain, refused or sinkhole")
+	}
+	if v4 != "" {
+		if res.V4 = net.ParseIP(v4).To4(); res.V4 == nil {
+			return res, errors.New("invalid sinkhole ipv4 " + v4)
+		}
+	}
+	if v6 != "" {
+		ip := net.ParseIP(v6)
+		if ip == nil || ip.To4() != nil {
+			return res, errors.New("invalid sinkhole ipv6 " + v6)
+		}
+		res.V6 = ip.To16()
+	}
+	if res.V4 == nil && res.V6 == nil {
+		return res, errors.New("the sinkhole mode needs an address")
+	}
+	return res, nil
+}
+
+// Answer returns the answer to a blocked question, the negative answers are a *client.NameError,
+// a *client.NoDataError or a *client.RefusedError matching client.ErrBlocked
+func (r Response) Answer(name string, t dto.Type) (dto.Record, error) {
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = defaultTTl
+	}
+	record := BlockedRecord(name, t)
+	record.TTL = ttl
+	switch r.Mode {
+	case NXDomainMode:
+		return dto.Record{}, client.Blocked(&client.NameError{Name: name, TTL: ttl})
+	case RefusedMode:
+		return dto.Record{}, client.Blocked(&client.RefusedError{Name: name})
+	case SinkholeMode:
+		data := r.V4
+		if t == dto.AAAA {
+			data = r.V6
+		}
+		if data == nil {
+			return dto.Record{}, client.Blocked(&client.NoDataError{Name: name, Type: t, TTL: ttl})
+		}
+		record.Data = data
+	}
+	return record, nil
+}
+
+// Describe returns the answer to a blocked question for the humans
+func (r Response) Describe(name string, t dto.Type) string {
+	record, err := r.Answer(name, t)
+	var nameError *client.NameError
+	var noDataError *client.NoDataError
+	switch {
+	case err == nil:
+		return t.String() + " " + record.Data.String()
+	case errors.As(err, &nameError):
+		return t.String() + " NXDOMAIN"
+	case errors.As(err, &noDataError):
+		return t.String() + " NODATA"
+	}
+	return t.String() + " REFUSED"
+}
@@ -0,0 +1,103 @@
+package blocker
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ruleSource is the prefix of the source of the names blocked by a rule
+const ruleSource = "rule "
+
+// RulesList is the source of the list gathering the rules in the activity of the lists
+const RulesList = "rules"
+
+// AddRule blocks the names matching the rule: a name, *.name for its subdomains or /regexp/ matched against the names
+func (b *Blocker) AddRule(rule string) error {
+	rule = strings.TrimSpace(rule)
+	if isRegexpRule(rule) {
+		re, err := regexp.Compile(rule[1 : len(rule)-1])
+		if err != nil {
+			return errors.New("invalid rule " + rule + ": " + err.Error())
+		}
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for _, known := range b.regexps {
+			if known.String() == re.String() {
+				return nil
+			}
+		}
+		b.regexps = append(b.regexps, re)
+		return nil
+	}
+	pattern := normalizePattern(rule)
+	if pattern == "" || pattern == "*" {
+		return errors.New("invalid rule " + rule)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.rules[pattern] = struct{}{}
+	return nil
+}
+
+// RemoveRule stops blocking the names matching the rule, the names of the lists are still blocked
+func (b *Blocker) RemoveRule(rule string) {
+	rule = strings.TrimSpace(rule)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !isRegexpRule(rule) {
+		delete(b.rules, normalizePattern(rule))
+		return
+	}
+	for i, known := range b.regexps {
+		if known.String() == rule[1:len(rule)-1] {
+			// copied, the previous slice may still be held by a blocker replaced with this one
+			b.regexps = append(b.regexps[:i:i], b.regexps[i+1:]...)
+			return
+		}
+	}
+}
+
+// CheckRule tells if the rule can be added to a blocker
+func CheckRule(rule string) error {
+	return NewBlocker(0).AddRule(rule)
+}
+
+func isRegexpRule(rule string) bool {
+	return len(rule) > 2 && strings.HasPrefix(rule, "/") && strings.HasSuffix(rule, "/")
+}
+
+func normalizePattern(pattern string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pattern), "."))
+}
+
+// matchRule returns the rule blocking the name, the lock must be held
+func (b *Blocker) matchRule(name string) (string, bool) {
+	if len(b.rules) == 0 && len(b.regexps) == 0 {
+		return "", false
+	}
+	name = strings.ToLower(name)
+	if pattern, ok := matchPattern(b.rules, name); ok {
+		return pattern, true
+	}
+	for _, re := range b.regexps {
+		if re.MatchString(name) {
+			return "/" + re.String() + "/", true
+		}
+	}
+	return "", false
+}
+
+// matchPattern returns the pattern of the set matching the lower case name, either the name or *. followed by one of its parents.
+// The lookup costs one access to the set per label of the name, whatever the number of patterns
+func matchPattern(patterns map[string]struct{}, name string) (string, bool) {
+	if _, ok := patterns[name]; ok {
+		return name, true
+	}
+	for _, parent, found := strings.Cut(name, "."); found; _, parent, found = strings.Cut(parent, ".") {
+		if _, ok := patterns["*."+parent]; ok {
+			return "*." + parent, true
+		}
+	}
+	return "", false
+}
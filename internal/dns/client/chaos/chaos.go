@@ -0,0 +1,152 @@
+// Package chaos injects failures in front of the upstream clients: latency, lost queries and SERVFAIL answers,
+// to validate the timeouts, the failover and the serve-stale under realistic failure conditions
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.TypedClient = &Client{}
+var _ client.RequestClient = &Client{}
+var _ client.SetClient = &Client{}
+
+// lossTimeout is the duration a lost query waits before failing, like an upstream client waiting for its answer
+const lossTimeout = 2 * time.Second
+
+// Rule injects failures in the questions about the names matching Pattern, *.name for the subdomains of name
+// and * for all the names, asked to Upstream or to all the upstreams when empty
+type Rule struct {
+	Pattern  string `json:"pattern"`
+	Upstream string `json:"upstream,omitempty"`
+	// Latency is the delay added to the questions, in milliseconds
+	Latency uint32 `json:"latency,omitempty"`
+	// Loss is the probability from 0 to 1 of a question to be lost
+	Loss float64 `json:"loss,omitempty"`
+	// ServFail is the probability from 0 to 1 of a question to fail with SERVFAIL
+	ServFail float64 `json:"servfail,omitempty"`
+}
+
+// Check returns an error when the rule cannot be applied
+func (r Rule) Check() error {
+	pattern := strings.TrimPrefix(r.Pattern, "*.")
+	if r.Pattern == "" || (r.Pattern != "*" && (pattern == "" || strings.Contains(pattern, "*"))) {
+		return errors.New("invalid chaos pattern " + r.Pattern + ", expecting name, *.name or *")
+	}
+	if r.Loss < 0 || r.Loss > 1 || r.ServFail < 0 || r.ServFail > 1 {
+		return errors.New("chaos probabilities of " + r.Pattern + " must be between 0 and 1")
+	}
+	return nil
+}
+
+// matches tells if the rule applies to the lower case name asked to the upstream
+func (r Rule) matches(upstream, name string) bool {
+	if r.Upstream != "" && r.Upstream != upstream {
+		return false
+	}
+	pattern := strings.ToLower(strings.TrimSuffix(r.Pattern, "."))
+	if pattern == "*" || pattern == name {
+		return true
+	}
+	parent, ok := strings.CutPrefix(pattern, "*.")
+	return ok && strings.HasSuffix(name, "."+parent)
+}
+
+// Injector holds the rules shared by the clients it wraps, they can be replaced while the clients are running
+type Injector struct {
+	rules  atomic.Pointer[[]Rule]
+	random func() float64
+	sleep  func(time.Duration)
+}
+
+// NewInjector instantiates an injector applying the rules
+func NewInjector(rules []Rule) *Injector {
+	res := &Injector{random: rand.Float64, sleep: time.Sleep}
+	res.SetRules(rules)
+	return res
+}
+
+// Rules returns the rules in use
+func (i *Injector) Rules() []Rule {
+	return *i.rules.Load()
+}
+
+// SetRules replaces the rules, the first rule matching a question applies
+func (i *Injector) SetRules(rules []Rule) {
+	rules = append([]Rule{}, rules...)
+	i.rules.Store(&rules)
+}
+
+// Wrap returns the client injecting the failures of the rules of the upstream named name, a nil injector returns the client
+func (i *Injector) Wrap(name string, c client.Client) client.Client {
+	if i == nil {
+		return c
+	}
+	return &Client{injector: i, name: name, delegate: c}
+}
+
+// rule returns the first rule matching the question asked to the upstream
+func (i *Injector) rule(upstream, name string) (Rule, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, r := range i.Rules() {
+		if r.matches(upstream, name) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Client is an upstream client failing according to the rules of its injector
+type Client struct {
+	injector *Injector
+	name     string
+	delegate client.Client
+}
+
+// ResolveV4 implements client.Client
+func (c *Client) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *Client) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient
+func (c *Client) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *Client) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+// The question is delayed, lost or failed with SERVFAIL by the matching rule before being asked to the upstream
+func (c *Client) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	r, ok := c.injector.rule(c.name, name)
+	if !ok {
+		return client.ResolveSet(ctx, c.delegate, req, name, t)
+	}
+	if r.Latency > 0 {
+		c.injector.sleep(time.Duration(r.Latency) * time.Millisecond)
+	}
+	if r.Loss > 0 && c.injector.random() < r.Loss {
+		c.injector.sleep(lossTimeout)
+		return nil, client.Timeout(errors.New("chaos: query " + name + " " + t.String() + " to " + c.name + " lost after " + lossTimeout.String()))
+	}
+	if r.ServFail > 0 && c.injector.random() < r.ServFail {
+		return nil, errors.New("chaos: " + c.name + " answered SERVFAIL for " + name + " " + t.String())
+	}
+	return client.ResolveSet(ctx, c.delegate, req, name, t)
+}
@@ -0,0 +1,105 @@
+package chaos
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+)
+
+// newTestInjector returns an injector rolling random and recording its sleeps instead of sleeping
+func newTestInjector(rules []Rule, random float64, slept *time.Duration) *Injector {
+	i := NewInjector(rules)
+	i.random = func() float64 { return random }
+	i.sleep = func(d time.Duration) { *slept += d }
+	return i
+}
+
+func TestClient_ResolveRequest(t *testing.T) {
+	upstream := &inmemoryclient.InMemoryClient{}
+	for _, name := range []string{"example.com", "www.slow.lan", "flaky.lan", "lost.lan"} {
+		if err := upstream.Add(name, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := []Rule{
+		{Pattern: "*.Slow.lan.", Latency: 300},
+		{Pattern: "flaky.lan", ServFail: 0.5},
+		{Pattern: "lost.lan", Latency: 100, Loss: 0.5},
+		{Pattern: "*", Upstream: "10.0.0.53:53", ServFail: 1},
+	}
+	tests := []struct {
+		name      string
+		upstream  string
+		question  string
+		random    float64
+		wantErr   bool
+		wantSlept time.Duration
+	}{
+		{name: "no rule", upstream: "10.0.0.1:53", question: "example.com"},
+		{name: "latency", upstream: "10.0.0.1:53", question: "www.slow.lan", wantSlept: 300 * time.Millisecond},
+		{name: "servfail", upstream: "10.0.0.1:53", question: "flaky.lan", random: 0.2, wantErr: true},
+		{name: "servfail not drawn", upstream: "10.0.0.1:53", question: "flaky.lan", random: 0.7},
+		{name: "lost", upstream: "10.0.0.1:53", question: "lost.lan", random: 0.2, wantErr: true, wantSlept: 100*time.Millisecond + lossTimeout},
+		{name: "not lost", upstream: "10.0.0.1:53", question: "lost.lan", random: 0.7, wantSlept: 100 * time.Millisecond},
+		{name: "failing upstream", upstream: "10.0.0.53:53", question: "example.com", random: 0.9, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var slept time.Duration
+			c := newTestInjector(rules, tt.random, &slept).Wrap(tt.upstream, upstream)
+			record, err := c.ResolveV4(tt.question)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !record.Data.Equal(net.ParseIP("10.0.0.1")) {
+				t.Errorf("expecting the answer of the upstream, got %v", record)
+			}
+			if slept != tt.wantSlept {
+				t.Errorf("slept %v, want %v", slept, tt.wantSlept)
+			}
+		})
+	}
+}
+
+func TestInjector_SetRules(t *testing.T) {
+	upstream := &inmemoryclient.InMemoryClient{}
+	if err := upstream.Add("example.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	var slept time.Duration
+	i := newTestInjector(nil, 0, &slept)
+	c := i.Wrap("10.0.0.1:53", upstream)
+	if _, err := c.ResolveV4("example.com"); err != nil {
+		t.Fatalf("expecting no failure without rule, got %v", err)
+	}
+	i.SetRules([]Rule{{Pattern: "example.com", ServFail: 1}})
+	if _, err := c.ResolveV4("example.com"); err == nil {
+		t.Fatalf("expecting the new rules to apply to the running clients")
+	}
+	if (*Injector)(nil).Wrap("10.0.0.1:53", upstream) != upstream {
+		t.Errorf("expecting a nil injector to return the client")
+	}
+}
+
+func TestRule_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{name: "all", rule: Rule{Pattern: "*", Latency: 100}},
+		{name: "subdomains", rule: Rule{Pattern: "*.example.com", Loss: 0.1, ServFail: 1}},
+		{name: "empty", rule: Rule{}, wantErr: true},
+		{name: "inner wildcard", rule: Rule{Pattern: "a.*.com"}, wantErr: true},
+		{name: "probability", rule: Rule{Pattern: "example.com", Loss: 1.5}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Check(); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,7 +1,13 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
 )
 
 type Client interface {
@@ -9,7 +15,190 @@ type Client interface {
 	ResolveV6(name string) (dto.Record, error)
 }
 
+// TypedClient is a client able to resolve records of any type
+type TypedClient interface {
+	Client
+	Resolve(name string, t dto.Type) (dto.Record, error)
+}
+
+// Resolve asks the client for a record of the given type, only A and AAAA are supported by the clients which are not a TypedClient
+func Resolve(c Client, name string, t dto.Type) (dto.Record, error) {
+	if typed, ok := c.(TypedClient); ok {
+		return typed.Resolve(name, t)
+	}
+	switch t {
+	case dto.A:
+		return c.ResolveV4(name)
+	case dto.AAAA:
+		return c.ResolveV6(name)
+	}
+	return dto.Record{}, NotFound("unsupported type " + t.String())
+}
+
+// RequestClient is a client whose answers depend on the metadata of the request, like the address of the client,
+// or which stops resolving once ctx is done, like the clients of the upstreams
+type RequestClient interface {
+	Client
+	ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error)
+}
+
+// ResolveRequest asks the client for a record of the given type with the context and the metadata of the request,
+// when the client uses them
+func ResolveRequest(ctx context.Context, c Client, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	if requestClient, ok := c.(RequestClient); ok {
+		return requestClient.ResolveRequest(ctx, req, name, t)
+	}
+	return Resolve(c, name, t)
+}
+
+// SetClient is a client able to answer all the records of a name and a type, like the addresses of a load balanced service.
+// Its ResolveRequest answers the first record of the set
+type SetClient interface {
+	RequestClient
+	ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error)
+}
+
+// ResolveSet asks the client for all the records of the given type, a set of one record for the clients which are not a SetClient
+func ResolveSet(ctx context.Context, c Client, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	if setClient, ok := c.(SetClient); ok {
+		return setClient.ResolveSet(ctx, req, name, t)
+	}
+	record, err := ResolveRequest(ctx, c, req, name, t)
+	if err != nil {
+		return nil, err
+	}
+	return []dto.Record{record}, nil
+}
+
+// First returns the first record of a set with the error answering it, the SetClient answer their single record with it
+func First(records []dto.Record, err error) (dto.Record, error) {
+	if err != nil || len(records) == 0 {
+		return dto.Record{}, err
+	}
+	return records[0], nil
+}
+
+// Deadline returns the time an upstream is waited for, the deadline of ctx when it comes before timeout
+func Deadline(ctx context.Context, timeout time.Duration) time.Time {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
+
 type ReversableClient interface {
 	Client
 	ReverseResolve(ip string)
 }
+
+var _ error = &NameError{}
+
+// NameError is returned by a client when the upstream reports the name does not exist (NXDOMAIN)
+type NameError struct {
+	Name string
+	// TTL is the duration the negative answer can be cached, taken from the SOA of the zone
+	TTL uint32
+}
+
+// Error implements error.
+func (e *NameError) Error() string {
+	return e.Name + " does not exist"
+}
+
+var _ error = &NoDataError{}
+
+// NoDataError is returned by a client when the name exists without records of the asked type (NODATA)
+type NoDataError struct {
+	Name string
+	Type dto.Type
+	// TTL is the duration the negative answer can be cached, taken from the SOA of the zone
+	TTL uint32
+}
+
+// Error implements error.
+func (e *NoDataError) Error() string {
+	return e.Name + " has no " + e.Type.String() + " record"
+}
+
+var _ error = &RefusedError{}
+
+// RefusedError is returned by a client declining to answer the name (REFUSED)
+type RefusedError struct {
+	Name string
+}
+
+// Error implements error.
+func (e *RefusedError) Error() string {
+	return e.Name + " is refused"
+}
+
+var _ error = &ServerFailError{}
+
+// ServerFailError is returned when a name cannot be answered and must not be asked to another source (SERVFAIL)
+type ServerFailError struct {
+	Name string
+	Err  error
+}
+
+// Error implements error.
+func (e *ServerFailError) Error() string {
+	return "cannot resolve " + e.Name + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause of the failure
+func (e *ServerFailError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// ErrNotFound is matched by the errors of a client without record for the question, the following sources are asked
+	ErrNotFound = errors.New("not found")
+	// ErrBlocked is matched by the negative answers of a client blocking the question
+	ErrBlocked = errors.New("blocked")
+	// ErrTimeout is matched by the errors of an upstream which did not answer in time
+	ErrTimeout = errors.New("timeout")
+)
+
+var _ error = &kindError{}
+
+// kindError is an error of one of the kinds ErrNotFound, ErrBlocked or ErrTimeout with the message of its cause
+type kindError struct {
+	kind error
+	err  error
+}
+
+// Error implements error.
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the kind and the cause of the error
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// NotFound returns the error of a client without record for the question
+func NotFound(message string) error {
+	return &kindError{kind: ErrNotFound, err: errors.New(message)}
+}
+
+// Blocked returns the negative answer err of a client blocking the question, it is still matched by errors.As
+func Blocked(err error) error {
+	return &kindError{kind: ErrBlocked, err: err}
+}
+
+// Timeout returns the failure err of an upstream which did not answer in time
+func Timeout(err error) error {
+	return &kindError{kind: ErrTimeout, err: err}
+}
+
+// WrapTimeout returns err as an ErrTimeout when it is the expiry of a deadline, of a connection or of a context,
+// err otherwise
+func WrapTimeout(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return Timeout(err)
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+// Package coalesce shares the answer of the upstream between the queries asking the same question at the same time,
+// like the clients of a network all asking a popular name once its records expire, so the question is asked once
+package coalesce
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.TypedClient = &Client{}
+var _ client.RequestClient = &Client{}
+var _ client.SetClient = &Client{}
+
+// Client asks its delegate once for the identical questions asked concurrently, it is safe for concurrent use
+type Client struct {
+	delegate client.Client
+	lock     sync.Mutex
+	calls    map[string]*call
+}
+
+// call is a question being asked to the delegate, its answer is set once done is closed
+type call struct {
+	done    chan struct{}
+	records []dto.Record
+	err     error
+	// outcome and aliases are what the delegate reported through the context of the question
+	outcome dnssec.Outcome
+	aliases []string
+}
+
+// NewClient returns the client coalescing the questions asked to delegate
+func NewClient(delegate client.Client) *Client {
+	return &Client{delegate: delegate, calls: make(map[string]*call)}
+}
+
+// ResolveV4 implements client.Client
+func (c *Client) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *Client) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient
+func (c *Client) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *Client) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient, the question is asked to the delegate unless an identical one, with the same
+// name, type and forwarded options, is being asked. The question goes on when the query which asked it is cancelled,
+// for the other ones waiting for its answer
+func (c *Client) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	k := key(name, t, req.Options)
+	c.lock.Lock()
+	current, ok := c.calls[k]
+	if !ok {
+		current = &call{done: make(chan struct{})}
+		c.calls[k] = current
+		go c.ask(ctx, req, name, t, k, current)
+	}
+	c.lock.Unlock()
+	select {
+	case <-current.done:
+	case <-ctx.Done():
+		return nil, client.WrapTimeout(ctx.Err())
+	}
+	dnssec.Share(ctx, current.outcome)
+	client.ReportAliases(ctx, current.aliases)
+	if current.records == nil {
+		return nil, current.err
+	}
+	// every query gets its own records, the chain renames them for its question
+	return append([]dto.Record(nil), current.records...), current.err
+}
+
+// ask asks the question of the call to the delegate, with the values of the context of the first query but without its
+// cancellation, and collects what the delegate reports for the queries sharing its answer
+func (c *Client) ask(ctx context.Context, req request.Request, name string, t dto.Type, k string, current *call) {
+	shared, status := dnssec.WithStatus(context.WithoutCancel(ctx))
+	shared, aliases := client.WithAliases(shared)
+	current.records, current.err = client.ResolveSet(shared, c.delegate, req, name, t)
+	current.outcome, current.aliases = status.Outcome(), aliases.Names()
+	c.lock.Lock()
+	delete(c.calls, k)
+	c.lock.Unlock()
+	close(current.done)
+}
+
+// key returns the key of a question, the options like the client subnet may change the answer of the upstream
+func key(name string, t dto.Type, options []dto.Option) string {
+	var res strings.Builder
+	res.WriteString(strings.ToLower(strings.TrimSuffix(name, ".")))
+	res.WriteByte('/')
+	res.WriteString(strconv.Itoa(int(t)))
+	for _, option := range options {
+		res.WriteByte('/')
+		res.WriteString(strconv.Itoa(int(option.Code)))
+		res.WriteByte(':')
+		res.WriteString(hex.EncodeToString(option.Data))
+	}
+	return res.String()
+}
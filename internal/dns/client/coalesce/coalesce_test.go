@@ -0,0 +1,116 @@
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+// slowClient answers every question once released, with the CNAME chain of its answer
+type slowClient struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (s *slowClient) ResolveV4(name string) (dto.Record, error) {
+	return client.First(s.ResolveSet(context.Background(), request.Request{}, name, dto.A))
+}
+
+func (s *slowClient) ResolveV6(name string) (dto.Record, error) {
+	return client.First(s.ResolveSet(context.Background(), request.Request{}, name, dto.AAAA))
+}
+
+func (s *slowClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(s.ResolveSet(ctx, req, name, t))
+}
+
+func (s *slowClient) ResolveSet(ctx context.Context, _ request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	s.calls.Add(1)
+	<-s.release
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	client.ReportAliases(ctx, []string{"cdn.example.net"})
+	return []dto.Record{{Name: name, Type: t, Class: dto.IN, TTL: 60, Data: net.IPv4(192, 0, 2, 1).To4()}}, nil
+}
+
+func TestClient(t *testing.T) {
+	delegate := &slowClient{release: make(chan struct{})}
+	c := NewClient(delegate)
+
+	const queries = 10
+	wg := sync.WaitGroup{}
+	errs := make(chan error, queries)
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, aliases := client.WithAliases(context.Background())
+			records, err := c.ResolveSet(ctx, request.Request{}, "Example.com", dto.A)
+			switch {
+			case err != nil:
+				errs <- err
+			case len(records) != 1 || len(aliases.Names()) != 1:
+				errs <- errors.New("expecting the shared answer and its aliases")
+			default:
+				// the records of a query are its own
+				records[0].Name = "renamed"
+			}
+		}()
+	}
+
+	// a query cancelled while waiting does not cancel the question of the other ones
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := c.ResolveSet(ctx, request.Request{}, "example.com.", dto.A)
+		cancelled <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("expecting the cancelled query to end with its context, got %v", err)
+	}
+
+	close(delegate.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if calls := delegate.calls.Load(); calls != 1 {
+		t.Errorf("expecting the question to be asked once, got %d", calls)
+	}
+
+	// once answered, the question is asked again
+	if _, err := c.ResolveV4("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ResolveV6("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := delegate.calls.Load(); calls != 3 {
+		t.Errorf("expecting the following questions to be asked, got %d calls", calls)
+	}
+}
+
+func TestKey(t *testing.T) {
+	subnet := []dto.Option{{Code: 8, Data: []byte{0, 1, 24, 0, 192, 168, 1}}}
+	if key("Example.com.", dto.A, nil) != key("example.com", dto.A, nil) {
+		t.Error("expecting the names to be compared regardless of their case and final dot")
+	}
+	if key("example.com", dto.A, nil) == key("example.com", dto.AAAA, nil) {
+		t.Error("expecting the types to be told apart")
+	}
+	if key("example.com", dto.A, nil) == key("example.com", dto.A, subnet) {
+		t.Error("expecting the forwarded options to be told apart")
+	}
+}
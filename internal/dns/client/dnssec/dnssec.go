@@ -0,0 +1,189 @@
+// Package dnssec applies the DNSSEC policy of the upstreams: the answers of the trusted validators are authenticated
+// by their AD bit, the ones of the other upstreams need a local validation. The validation is costly on the small
+// devices, trusting a validating upstream over an encrypted transport spares it
+package dnssec
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.TypedClient = &Client{}
+var _ client.RequestClient = &Client{}
+var _ client.SetClient = &Client{}
+
+// Policy is the handling of the DNSSEC status of the answers of an upstream
+type Policy string
+
+const (
+	// Ignore never authenticates the answers of the upstream
+	Ignore Policy = "ignore"
+	// Trust authenticates the answers the upstream flags with the AD bit, it must validate them
+	Trust Policy = "trust"
+	// Validate ignores the AD bit of the upstream, the answers must be validated locally.
+	// Their outcome is indeterminate as long as the server does not validate
+	Validate Policy = "validate"
+)
+
+// ParsePolicy returns the policy of its name, ignore when empty
+func ParsePolicy(name string) (Policy, error) {
+	switch Policy(name) {
+	case "", Ignore:
+		return Ignore, nil
+	case Trust, Validate:
+		return Policy(name), nil
+	}
+	return "", errors.New("unknown DNSSEC policy " + name + ", expecting ignore, trust or validate")
+}
+
+// Outcome is the DNSSEC status of an answer (rfc4035 section 4.3)
+type Outcome string
+
+const (
+	// Secure answers are authenticated, the clients asking for it receive them with the AD bit
+	Secure Outcome = "secure"
+	// Insecure answers are not signed, or not validated by the trusted upstream
+	Insecure Outcome = "insecure"
+	// Indeterminate answers have not been validated
+	Indeterminate Outcome = "indeterminate"
+)
+
+type statusKey struct{}
+
+// Status collects the DNSSEC status of the answer to a question through the context of the query
+type Status struct {
+	lock sync.Mutex
+	// requested asks the upstream to tell if it authenticated its answer
+	requested     bool
+	authenticated bool
+	outcome       Outcome
+}
+
+// WithStatus returns the context collecting the status of the answer to a question
+func WithStatus(ctx context.Context) (context.Context, *Status) {
+	s := &Status{}
+	return context.WithValue(ctx, statusKey{}, s), s
+}
+
+// Outcome returns the outcome of the answer, empty when no upstream with a policy answered.
+// The last answer wins when several upstreams are raced
+func (s *Status) Outcome() Outcome {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.outcome
+}
+
+func (s *Status) setOutcome(outcome Outcome) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.outcome = outcome
+}
+
+func (s *Status) isAuthenticated() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.authenticated
+}
+
+func fromContext(ctx context.Context) *Status {
+	s, _ := ctx.Value(statusKey{}).(*Status)
+	return s
+}
+
+// QueryHeader returns the header of the query to the upstream, with the AD bit when its policy trusts it (rfc6840 section 5.7)
+func QueryHeader(ctx context.Context, header uint16) uint16 {
+	if s := fromContext(ctx); s != nil && s.requested {
+		return header | dto.AUTHENTIC_DATA
+	}
+	return header
+}
+
+// Report records whether the upstream authenticated its answer, the upstream clients call it for every response
+func Report(ctx context.Context, authenticated bool) {
+	if s := fromContext(ctx); s != nil {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.authenticated = authenticated
+	}
+}
+
+// Share records the outcome of an answer shared with the query, asked by another query with the same question
+func Share(ctx context.Context, outcome Outcome) {
+	if s := fromContext(ctx); s != nil && outcome != "" {
+		s.setOutcome(outcome)
+	}
+}
+
+// Client applies the policy of its upstream to the status of the answers
+type Client struct {
+	policy   Policy
+	delegate client.Client
+}
+
+// Wrap returns the client applying the policy to the answers of the upstream, the upstream itself when ignored
+func Wrap(policy Policy, c client.Client) client.Client {
+	if policy == Ignore || policy == "" {
+		return c
+	}
+	return &Client{policy: policy, delegate: c}
+}
+
+// ResolveV4 implements client.Client
+func (c *Client) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *Client) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient
+func (c *Client) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *Client) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+// The outcome of the answer, positive or negative, is given to the status of the query
+func (c *Client) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	parent := fromContext(ctx)
+	if parent == nil {
+		return client.ResolveSet(ctx, c.delegate, req, name, t)
+	}
+	// every upstream reports to its own status, the raced upstreams do not mix their answers
+	ctx, status := WithStatus(ctx)
+	status.requested = c.policy == Trust
+	records, err := client.ResolveSet(ctx, c.delegate, req, name, t)
+	if err == nil || isNegative(err) {
+		parent.setOutcome(c.outcome(status))
+	}
+	return records, err
+}
+
+func (c *Client) outcome(status *Status) Outcome {
+	switch {
+	case c.policy == Validate:
+		return Indeterminate
+	case status.isAuthenticated():
+		return Secure
+	default:
+		return Insecure
+	}
+}
+
+// isNegative tells if the error is an answer of the upstream telling the name or the record does not exist
+func isNegative(err error) bool {
+	var nameError *client.NameError
+	var noDataError *client.NoDataError
+	return errors.As(err, &nameError) || errors.As(err, &noDataError)
+}
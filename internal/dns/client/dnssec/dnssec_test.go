@@ -0,0 +1,91 @@
+package dnssec
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.RequestClient = &upstreamMock{}
+
+// upstreamMock answers like an upstream client, with the AD bit when authenticated
+type upstreamMock struct {
+	authenticated bool
+	exists        bool
+	header        uint16
+}
+
+// ResolveV4 implements client.Client
+func (c *upstreamMock) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *upstreamMock) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *upstreamMock) ResolveRequest(ctx context.Context, _ request.Request, name string, t dto.Type) (dto.Record, error) {
+	c.header = QueryHeader(ctx, dto.STANDARD_QUERY)
+	Report(ctx, c.authenticated)
+	if !c.exists {
+		return dto.Record{}, &client.NameError{Name: name}
+	}
+	return dto.Record{Name: name, Type: t, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.1").To4()}, nil
+}
+
+func TestClient_ResolveRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        Policy
+		authenticated bool
+		exists        bool
+		want          Outcome
+		wantQueryAD   bool
+	}{
+		{name: "trusted validator", policy: Trust, authenticated: true, exists: true, want: Secure, wantQueryAD: true},
+		{name: "trusted validator without AD", policy: Trust, exists: true, want: Insecure, wantQueryAD: true},
+		{name: "authenticated denial", policy: Trust, authenticated: true, want: Secure, wantQueryAD: true},
+		{name: "local validation", policy: Validate, authenticated: true, exists: true, want: Indeterminate},
+		{name: "ignored", policy: Ignore, authenticated: true, exists: true, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &upstreamMock{authenticated: tt.authenticated, exists: tt.exists}
+			ctx, status := WithStatus(context.Background())
+			_, _ = client.ResolveRequest(ctx, Wrap(tt.policy, upstream), request.Request{}, "example.com", dto.A)
+			if got := status.Outcome(); got != tt.want {
+				t.Errorf("Outcome() = %q, want %q", got, tt.want)
+			}
+			if gotQueryAD := upstream.header&dto.AUTHENTIC_DATA != 0; gotQueryAD != tt.wantQueryAD {
+				t.Errorf("AD bit of the query = %v, want %v", gotQueryAD, tt.wantQueryAD)
+			}
+		})
+	}
+}
+
+func TestClient_ResolveWithoutStatus(t *testing.T) {
+	upstream := &upstreamMock{authenticated: true, exists: true}
+	if _, err := Wrap(Trust, upstream).ResolveV4("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if upstream.header != dto.STANDARD_QUERY {
+		t.Errorf("expecting a plain query without status to collect the answer, got header %#x", upstream.header)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for name, want := range map[string]Policy{"": Ignore, "ignore": Ignore, "trust": Trust, "validate": Validate} {
+		if got, err := ParsePolicy(name); err != nil || got != want {
+			t.Errorf("ParsePolicy(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParsePolicy("strict"); err == nil {
+		t.Errorf("expecting an error for an unknown policy")
+	}
+}
@@ -15,6 +15,23 @@ type Message struct {
 	CD       bool       `json:"CD,omitempty"`
 	Question []Question `json:"Question,omitempty"`
 	Answer   []Answer   `json:"Answer,omitempty"`
+	// Authority holds the SOA of the zone of the negative answers
+	Authority []Answer `json:"Authority,omitempty"`
+}
+
+// negativeTTL returns the duration the negative answer can be cached
+func (m Message) negativeTTL() uint32 {
+	for _, answer := range m.Authority {
+		if answer.Type != uint16(dto.SOA) {
+			continue
+		}
+		record, err := answer.ToRecord()
+		if err != nil {
+			return 0
+		}
+		return dto.SOAMinimum(record)
+	}
+	return 0
 }
 
 type Question struct {
@@ -29,15 +46,20 @@ type Answer struct {
 	Data string `json:"data,omitempty"`
 }
 
-func (a Answer) ToRecord() dto.Record {
-	ip := parseIp(a.Data)
-	return dto.Record{
+func (a Answer) ToRecord() (dto.Record, error) {
+	record := dto.Record{
 		Name:  a.Name,
 		Type:  dto.Type(a.Type),
 		Class: dto.IN,
 		TTL:   a.Ttl,
-		Data:  ip,
 	}
+	if record.Type == dto.A || record.Type == dto.AAAA {
+		record.Data = parseIp(a.Data)
+		return record, nil
+	}
+	var err error
+	record.Raw, err = dto.ParseRData(record.Type, a.Data)
+	return record, err
 }
 
 func parseIp(addr string) net.IP {
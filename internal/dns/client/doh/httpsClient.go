@@ -1,90 +1,287 @@
 package doh
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"log"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	json "github.com/goccy/go-json"
-	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
 
 	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/client/family"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
 )
 
-var _ client.Client = &DOHClient{}
+var logger = logging.Component("doh")
 
-// DOHClient Dns Pver Http clien, resolve request by requesting it to an http server
+const (
+	// DefaultTimeout bounds each request, DefaultRetries is the number of times a failed request is sent again
+	DefaultTimeout = 5 * time.Second
+	DefaultRetries = 0
+	// DefaultConnections is the number of connections opened to the server at most, DefaultIdleTimeout the time an idle
+	// connection is kept open for the next queries, so they do not pay the tcp and tls handshakes
+	DefaultConnections = 100
+	DefaultIdleTimeout = 90 * time.Second
+	// keepAlive is the interval of the tcp keep-alive probes of the connections, a dead path is noticed while they are idle
+	keepAlive = 30 * time.Second
+	// pingInterval is the time without frame after which an HTTP/2 connection is checked with a ping, closed without
+	// answer after pingTimeout, so the queries are not sent over a dead connection
+	pingInterval = 30 * time.Second
+	pingTimeout  = 15 * time.Second
+	// maxResponse bounds the body read from the server
+	maxResponse = 64 * 1024
+)
+
+var _ client.TypedClient = &DOHClient{}
+var _ client.RequestClient = &DOHClient{}
+var _ client.SetClient = &DOHClient{}
+
+// DOHClient Dns Pver Http clien, resolve request by requesting it to an http server.
+// The connections to the server are kept open and reused by the following requests, over HTTP/2 when the server
+// supports it: the requests share a connection, checked with pings while it is idle
 type DOHClient struct {
-	endpoint   string
-	httpClient *fasthttp.Client
+	endpoint string
+	// url is the parsed endpoint, err is returned by every query when it is invalid
+	url       *url.URL
+	err       error
+	transport *http.Transport
+	// timeout bounds each request, which is sent again retries times when it fails or the server has an error
+	timeout time.Duration
+	retries int
+	// dials and requests count the connections opened and the requests sent, open the connections not closed yet,
+	// see PoolStats
+	dials    atomic.Uint64
+	requests atomic.Uint64
+	open     atomic.Int64
+	// family is the address family the server is reached over, the system chooses by default
+	family family.Family
 }
 
-// NewDOHClient instantiate a new DOHClient
+// NewDOHClient instantiate a new DOHClient, the queries fail when the endpoint is not an http or https url
 func NewDOHClient(endpoint string) *DOHClient {
-	// t := http.DefaultTransport.(*http.Transport).Clone()
-	// t.MaxIdleConns = 100
-	// t.MaxConnsPerHost = 100
-	// t.MaxIdleConnsPerHost = 100
-
-	httpClient := &fasthttp.Client{
-		MaxConnsPerHost: 100,
+	res := &DOHClient{
+		endpoint: endpoint,
+		timeout:  DefaultTimeout,
+		retries:  DefaultRetries,
+	}
+	u, err := url.Parse(endpoint)
+	switch {
+	case err != nil:
+		res.err = errors.New("invalid DOH endpoint " + endpoint + ": " + err.Error())
+	case (u.Scheme != "https" && u.Scheme != "http") || u.Host == "":
+		res.err = errors.New("invalid DOH endpoint " + endpoint + ", expecting an http or https url")
+	}
+	res.url = u
+	dialer := &net.Dialer{Timeout: DefaultTimeout, KeepAlive: keepAlive}
+	res.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			res.dials.Add(1)
+			conn, err := res.family.Dial(ctx, dialer, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			res.open.Add(1)
+			return &trackedConn{Conn: conn, open: &res.open}, nil
+		},
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        DefaultConnections,
+		MaxIdleConnsPerHost: DefaultConnections,
+		MaxConnsPerHost:     DefaultConnections,
+		IdleConnTimeout:     DefaultIdleTimeout,
+		TLSHandshakeTimeout: DefaultTimeout,
 	}
-	// &http.Client{
-	// 	Timeout:   10 * time.Second,
-	// 	Transport: t,
-	// }
+	if h2, err := http2.ConfigureTransports(res.transport); err == nil {
+		h2.ReadIdleTimeout, h2.PingTimeout = pingInterval, pingTimeout
+	} else {
+		logger.Warn("cannot configure HTTP/2, the server is asked over HTTP/1.1", "endpoint", endpoint, "err", err)
+	}
+	return res
+}
+
+// trackedConn decrements the count of the open connections once closed
+type trackedConn struct {
+	net.Conn
+	open   *atomic.Int64
+	closed sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closed.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+// SetTimeout sets the time a request is waited for and the number of times it is sent again when it fails,
+// it must be called before using the client
+func (c *DOHClient) SetTimeout(timeout time.Duration, retries int) {
+	c.timeout, c.retries = timeout, retries
+}
 
-	return &DOHClient{
-		endpoint:   endpoint,
-		httpClient: httpClient,
+// SetFamily sets the address family the server is reached over, it must be called before using the client
+func (c *DOHClient) SetFamily(f family.Family) {
+	c.family = f
+}
+
+// SetPool sets the number of connections opened to the server at most and the time an idle one is kept open,
+// it must be called before using the client
+func (c *DOHClient) SetPool(connections int, idleTimeout time.Duration) {
+	if connections > 0 {
+		c.transport.MaxConnsPerHost, c.transport.MaxIdleConnsPerHost, c.transport.MaxIdleConns = connections, connections, connections
+	}
+	if idleTimeout > 0 {
+		c.transport.IdleConnTimeout = idleTimeout
 	}
 }
 
+// Prewarm opens a connection to the server with a query of the root servers, so the first queries of the clients
+// do not wait for the handshakes. It returns once the server answered or the timeout of the client is over,
+// the error tells the server cannot be reached
+func (c *DOHClient) Prewarm() error {
+	_, err := c.get(context.Background(), ".", dto.NS)
+	return err
+}
+
+// PoolStats implements metrics.PoolCounters
+func (c *DOHClient) PoolStats() (int, uint64, uint64) {
+	return int(c.open.Load()), c.dials.Load(), c.requests.Load()
+}
+
 // ResolveV4 implements client.Client
 func (c *DOHClient) ResolveV4(name string) (dto.Record, error) {
-	return c.resolve(name, dto.A)
+	return client.First(c.resolve(context.Background(), name, dto.A))
 }
 
 // ResolveV6 implements client.Client
 func (c *DOHClient) ResolveV6(name string) (dto.Record, error) {
-	return c.resolve(name, dto.AAAA)
+	return client.First(c.resolve(context.Background(), name, dto.AAAA))
 }
 
-func (c *DOHClient) resolve(name string, t dto.Type) (dto.Record, error) {
-	req := fasthttp.AcquireRequest()
-	resp := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
+// Resolve implements client.TypedClient
+func (c *DOHClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.resolve(context.Background(), name, t))
+}
 
-	req.SetRequestURI(c.endpoint + "?name=" + name + "&type=" + strconv.Itoa(int(t)))
-	req.Header.Add("accept", "application/dns-json")
-	req.Header.SetMethod("GET")
+// ResolveRequest implements client.RequestClient, the requests end at the deadline of ctx.
+// The json api has no EDNS options to forward
+func (c *DOHClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *DOHClient) ResolveSet(ctx context.Context, _ request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	return c.resolve(ctx, name, t)
+}
 
-	c.httpClient.Do(req, resp)
+func (c *DOHClient) resolve(ctx context.Context, name string, t dto.Type) ([]dto.Record, error) {
+	body, err := c.get(ctx, name, t)
+	if err != nil {
+		return nil, client.WrapTimeout(err)
+	}
 
 	var message Message
-	err := json.NewDecoder(bytes.NewReader(resp.Body())).Decode(&message)
+	err = json.Unmarshal(body, &message)
 
 	if err != nil {
-		return dto.Record{}, err
+		return nil, err
+	}
+	dnssec.Report(ctx, message.AD)
+	if message.Status == int(dto.NAME_ERROR) {
+		return nil, &client.NameError{Name: name, TTL: message.negativeTTL()}
 	}
 	if message.Status > 0 {
-		return dto.Record{}, errors.New("status is " + strconv.Itoa(message.Status))
+		return nil, errors.New("status is " + strconv.Itoa(message.Status))
 	}
 	if len(message.Answer) < 1 {
-		return dto.Record{}, errors.New("no answer in response")
+		return nil, &client.NoDataError{Name: name, Type: t, TTL: message.negativeTTL()}
 	}
-	if message.Answer[0].Type == 5 {
-		record, err := c.resolve(message.Answer[0].Data, t)
-		record.Name = name // Keep the Answer consistent with the initial Question
-		return record, err
+	var records []dto.Record
+	var aliases []string
+	for _, answer := range message.Answer {
+		if answer.Type == uint16(dto.CNAME) {
+			aliases = append(aliases, answer.Data)
+		}
+		if answer.Type == uint16(t) {
+			record, err := answer.ToRecord()
+			if err != nil {
+				return nil, err
+			}
+			record.Name = name // the answer may be at the end of a CNAME chain
+			records = append(records, record)
+		}
 	}
-	if message.Answer[0].Type != uint16(dto.A) && message.Answer[0].Type != uint16(dto.AAAA) {
-		log.Println("receive message of type", message.Answer[0].Type)
-		return dto.Record{}, errors.New("answer with unknown type in response")
+	client.ReportAliases(ctx, aliases)
+	if len(records) > 0 {
+		return records, nil
 	}
+	if message.Answer[0].Type == uint16(dto.CNAME) {
+		records, err := c.resolve(ctx, message.Answer[0].Data, t)
+		for i := range records {
+			records[i].Name = name // Keep the Answer consistent with the initial Question
+		}
+		return records, err
+	}
+	logger.Debug("answer with unexpected type", "name", name, "type", message.Answer[0].Type)
+	return nil, client.NotFound("answer with unexpected type in response")
+}
+
+// get asks the question to the json api of the server and returns the body of its answer
+func (c *DOHClient) get(ctx context.Context, name string, t dto.Type) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	u := *c.url
+	query := u.Query()
+	query.Set("name", name)
+	query.Set("type", strconv.Itoa(int(t)))
+	u.RawQuery = query.Encode()
+	return c.do(ctx, u.String())
+}
 
-	return message.Answer[0].ToRecord(), nil
+// do sends the request until the server answers without error, each attempt ends at the timeout or at the deadline of ctx
+func (c *DOHClient) do(ctx context.Context, target string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.requests.Add(1)
+		body, err := c.attempt(ctx, target)
+		if err == nil || attempt >= c.retries {
+			return body, err
+		}
+		logger.Debug("request failed, sending it again", "endpoint", c.endpoint, "attempt", attempt+1, "err", err)
+	}
+}
+
+// attempt sends the request once and reads the body of the answer, until the timeout or the deadline of ctx
+func (c *DOHClient) attempt(ctx context.Context, target string) ([]byte, error) {
+	ctx, cancel := context.WithDeadline(ctx, client.Deadline(ctx, c.timeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, errors.New("server error " + strconv.Itoa(resp.StatusCode))
+	}
+	return body, nil
 }
@@ -1,10 +1,16 @@
 package doh
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
 )
 
 func TestDOHClient_ResolveV4(t *testing.T) {
@@ -80,3 +86,95 @@ func TestDOHClient_ResolveV6(t *testing.T) {
 		})
 	}
 }
+
+func TestDOHClient_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		retries      int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "answered", failures: 0, retries: 1, wantRequests: 1},
+		{name: "retried", failures: 1, retries: 1, wantRequests: 2},
+		{name: "failing", failures: 2, retries: 1, wantErr: true, wantRequests: 2},
+		{name: "without retry", failures: 1, retries: 0, wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := &atomic.Int32{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				_, _ = w.Write([]byte(`{"Status":0,"Answer":[{"name":"example.com.","type":1,"TTL":60,"data":"127.0.0.1"}]}`))
+			}))
+			defer server.Close()
+			c := NewDOHClient(server.URL)
+			c.SetTimeout(time.Second, tt.retries)
+			_, err := c.ResolveV4("example.com")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DOHClient.ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("expecting %d requests, the server received %d", tt.wantRequests, got)
+			}
+		})
+	}
+}
+
+func TestDOHClient_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	c := NewDOHClient(server.URL)
+	c.SetTimeout(time.Minute, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.ResolveRequest(ctx, request.Request{}, "example.com", dto.A); err == nil {
+		t.Errorf("expecting an error from a hung upstream")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expecting the request to end at the deadline of its context, took %v", elapsed)
+	}
+}
+
+func TestDOHClient_Pool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"Status":0,"Answer":[{"name":"example.com.","type":1,"TTL":60,"data":"127.0.0.1"}]}`))
+	}))
+	defer server.Close()
+	c := NewDOHClient(server.URL)
+	c.SetPool(4, time.Minute)
+	if err := c.Prewarm(); err != nil {
+		t.Fatal(err)
+	}
+	if open, dials, _ := c.PoolStats(); open != 1 || dials != 1 {
+		t.Fatalf("expecting the connection opened by the prewarm, got %d open after %d dials", open, dials)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.ResolveV4("example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if open, dials, requests := c.PoolStats(); open != 1 || dials != 1 || requests != 4 {
+		t.Errorf("expecting the requests to reuse the connection, got %d open after %d dials for %d requests", open, dials, requests)
+	}
+}
+
+func TestDOHClient_InvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"://dns.lan", "dns.lan/dns-query", "ftp://dns.lan/dns-query"} {
+		c := NewDOHClient(endpoint)
+		if _, err := c.ResolveRequest(context.Background(), request.Request{}, "example.com", dto.A); err == nil {
+			t.Errorf("expecting an error from the endpoint %s", endpoint)
+		}
+		if _, dials, _ := c.PoolStats(); dials != 0 {
+			t.Errorf("expecting no connection to the endpoint %s, got %d", endpoint, dials)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package doq
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/client/family"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	queryTimeout = 10 * time.Second
+	// idleTimeout is the duration the connection is kept open without query
+	idleTimeout = 30 * time.Second
+	// number of tls sessions kept for resumption
+	sessionCacheSize = 8
+	// alpn is the application protocol of DoQ (rfc9250 section 4.1.1)
+	alpn = "doq"
+	// requestCancelled is the error code of an abandoned stream (rfc9250 section 4.3)
+	requestCancelled = 0x3
+)
+
+var _ client.TypedClient = &DOQClient{}
+var _ client.RequestClient = &DOQClient{}
+var _ client.SetClient = &DOQClient{}
+
+// DOQClient Dns Over Quic client, resolve request by forwarding them to a DoQ server (rfc9250).
+// The questions are asked on streams of a single connection, so a slow answer does not delay the others,
+// and the resumed connections send their first query in 0-RTT
+type DOQClient struct {
+	address   string
+	tlsConfig *tls.Config
+	lock      sync.Mutex
+	conn      quic.EarlyConnection
+	// family is the address family the server is reached over, the system chooses by default
+	family family.Family
+}
+
+// NewDOQClient instantiate a new DOQClient for the given address (host:port), the certificate
+// of the server is verified against serverName, or the host of the address when empty
+func NewDOQClient(address, serverName string) *DOQClient {
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(address)
+	}
+	return &DOQClient{
+		address: address,
+		tlsConfig: &tls.Config{
+			ServerName:         serverName,
+			MinVersion:         tls.VersionTLS13,
+			NextProtos:         []string{alpn},
+			ClientSessionCache: tls.NewLRUClientSessionCache(sessionCacheSize),
+		},
+	}
+}
+
+// SetFamily sets the address family the server is reached over, it must be called before using the client
+func (c *DOQClient) SetFamily(f family.Family) {
+	c.family = f
+}
+
+// ResolveV4 implements client.Client
+func (c *DOQClient) ResolveV4(name string) (dto.Record, error) {
+	return client.First(c.resolve(context.Background(), dto.Question{Name: name, Type: dto.A, Class: dto.IN}, nil))
+}
+
+// ResolveV6 implements client.Client
+func (c *DOQClient) ResolveV6(name string) (dto.Record, error) {
+	return client.First(c.resolve(context.Background(), dto.Question{Name: name, Type: dto.AAAA, Class: dto.IN}, nil))
+}
+
+// Resolve implements client.TypedClient
+func (c *DOQClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.resolve(context.Background(), dto.Question{Name: name, Type: t, Class: dto.IN}, nil))
+}
+
+// ResolveRequest implements client.RequestClient, the EDNS options of the request are forwarded
+// and the stream of the query is cancelled once ctx is done
+func (c *DOQClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *DOQClient) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	return c.resolve(ctx, dto.Question{Name: name, Type: t, Class: dto.IN}, req.Options)
+}
+
+func (c *DOQClient) resolve(ctx context.Context, question dto.Question, options []dto.Option) ([]dto.Record, error) {
+	question.Name = strings.TrimRight(question.Name, ".")
+
+	// the id is always 0, the stream identifies the query (rfc9250 section 4.2.1)
+	message := dto.Message{
+		Header:        dnssec.QueryHeader(ctx, dto.STANDARD_QUERY),
+		QuestionCount: 1,
+		Question:      []dto.Question{question},
+	}
+	dto.SetOptions(&message, options)
+
+	response, err := c.exchange(ctx, message)
+	if err != nil {
+		return nil, client.WrapTimeout(err)
+	}
+
+	dnssec.Report(ctx, response.Header&dto.AUTHENTIC_DATA != 0)
+	if response.Header&dto.RCODE_MASK == dto.NAME_ERROR {
+		return nil, &client.NameError{Name: question.Name, TTL: dto.NegativeTTL(response)}
+	}
+	client.ReportAliases(ctx, dto.Aliases(response))
+	records := dto.FindAnswers(response, question.Type)
+	if len(records) == 0 && len(response.Response) == 0 {
+		return nil, &client.NoDataError{Name: question.Name, Type: question.Type, TTL: dto.NegativeTTL(response)}
+	}
+	if len(records) == 0 {
+		return nil, client.NotFound("no answer in response")
+	}
+	for i := range records {
+		records[i].Name = question.Name // the answer may be at the end of a CNAME chain
+	}
+	return records, nil
+}
+
+// exchange sends the message on a new stream of the connection, a closed connection is replaced by a new one
+func (c *DOQClient) exchange(ctx context.Context, message dto.Message) (*dto.Message, error) {
+	payload := dto.SerializeMessage(message)
+
+	conn, reused, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+	response, err := exchangeOn(ctx, conn, payload)
+	if err != nil && reused && conn.Context().Err() != nil && ctx.Err() == nil {
+		// the connection has been closed by the server while idle
+		if conn, _, err = c.getConn(); err != nil {
+			return nil, err
+		}
+		response, err = exchangeOn(ctx, conn, payload)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return response, err
+}
+
+func exchangeOn(ctx context.Context, conn quic.EarlyConnection, payload []byte) (*dto.Message, error) {
+	stream, err := conn.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	_ = stream.SetDeadline(client.Deadline(ctx, queryTimeout))
+	stop := context.AfterFunc(ctx, func() { _ = stream.SetDeadline(time.Now()) })
+	defer stop()
+	if err := framing.Write(stream, payload); err != nil {
+		stream.CancelRead(requestCancelled)
+		return nil, err
+	}
+	// closing the sending side tells the server the query is complete (rfc9250 section 4.2)
+	_ = stream.Close()
+	data, err := framing.Read(stream)
+	if err != nil {
+		stream.CancelRead(requestCancelled)
+		return nil, err
+	}
+	response, err := dto.ParseMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	if response.ID != 0 {
+		return nil, errors.New("id mismatch")
+	}
+	return response, nil
+}
+
+// getConn returns the open connection, or a new one when there is none, reused tells which one it is
+func (c *DOQClient) getConn() (conn quic.EarlyConnection, reused bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn != nil && c.conn.Context().Err() == nil {
+		return c.conn, true, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err = c.dial(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	c.conn = conn
+	return conn, false, nil
+}
+
+// dial connects to the server over the networks of its family, the preferred family is given up after half of the
+// dial timeout when the other one can be tried
+func (c *DOQClient) dial(ctx context.Context) (quic.EarlyConnection, error) {
+	networks := c.family.Networks("udp")
+	var firstErr error
+	for i, network := range networks {
+		addr, err := net.ResolveUDPAddr(network, c.address)
+		if err == nil {
+			attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+			if i < len(networks)-1 {
+				attemptCtx, cancel = context.WithTimeout(ctx, dialTimeout/2)
+			}
+			var conn quic.EarlyConnection
+			conn, err = quic.DialAddrEarly(attemptCtx, addr.String(), c.tlsConfig, &quic.Config{MaxIdleTimeout: idleTimeout})
+			cancel()
+			if err == nil {
+				return conn, nil
+			}
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
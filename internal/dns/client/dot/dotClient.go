@@ -0,0 +1,215 @@
+package dot
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/client/family"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	queryTimeout = 10 * time.Second
+	// maximum number of idle connections kept open to the upstream
+	maxIdleConns = 8
+	// number of tls sessions kept for resumption
+	sessionCacheSize = 8
+)
+
+var _ client.TypedClient = &DOTClient{}
+var _ client.RequestClient = &DOTClient{}
+var _ client.SetClient = &DOTClient{}
+
+// DOTClient Dns Over Tls client, resolve request by forwarding them to a DoT server (rfc7858).
+// The connections are pooled and the tls sessions resumed
+type DOTClient struct {
+	address   string
+	tlsConfig *tls.Config
+	idle      chan *tls.Conn
+	id        atomic.Uint32
+	// family is the address family the server is reached over, the system chooses by default
+	family family.Family
+}
+
+// NewDOTClient instantiate a new DOTClient for the given address (host:port), the certificate
+// of the server is verified against serverName, or the host of the address when empty
+func NewDOTClient(address, serverName string) *DOTClient {
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(address)
+	}
+	return &DOTClient{
+		address: address,
+		tlsConfig: &tls.Config{
+			ServerName:         serverName,
+			MinVersion:         tls.VersionTLS12,
+			ClientSessionCache: tls.NewLRUClientSessionCache(sessionCacheSize),
+		},
+		idle: make(chan *tls.Conn, maxIdleConns),
+	}
+}
+
+// SetFamily sets the address family the server is reached over, it must be called before using the client
+func (c *DOTClient) SetFamily(f family.Family) {
+	c.family = f
+}
+
+// ResolveV4 implements client.Client
+func (c *DOTClient) ResolveV4(name string) (dto.Record, error) {
+	return client.First(c.resolve(context.Background(), dto.Question{Name: name, Type: dto.A, Class: dto.IN}, nil))
+}
+
+// ResolveV6 implements client.Client
+func (c *DOTClient) ResolveV6(name string) (dto.Record, error) {
+	return client.First(c.resolve(context.Background(), dto.Question{Name: name, Type: dto.AAAA, Class: dto.IN}, nil))
+}
+
+// Resolve implements client.TypedClient
+func (c *DOTClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.resolve(context.Background(), dto.Question{Name: name, Type: t, Class: dto.IN}, nil))
+}
+
+// ResolveRequest implements client.RequestClient, the EDNS options of the request are forwarded
+// and the exchange is abandoned once ctx is done
+func (c *DOTClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *DOTClient) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	return c.resolve(ctx, dto.Question{Name: name, Type: t, Class: dto.IN}, req.Options)
+}
+
+func (c *DOTClient) resolve(ctx context.Context, question dto.Question, options []dto.Option) ([]dto.Record, error) {
+	question.Name = strings.TrimRight(question.Name, ".")
+
+	message := dto.Message{
+		ID:            uint16(c.id.Add(1)),
+		Header:        dnssec.QueryHeader(ctx, dto.STANDARD_QUERY),
+		QuestionCount: 1,
+		Question:      []dto.Question{question},
+	}
+	dto.SetOptions(&message, options)
+
+	response, err := c.exchange(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	dnssec.Report(ctx, response.Header&dto.AUTHENTIC_DATA != 0)
+	if response.Header&dto.RCODE_MASK == dto.NAME_ERROR {
+		return nil, &client.NameError{Name: question.Name, TTL: dto.NegativeTTL(response)}
+	}
+	client.ReportAliases(ctx, dto.Aliases(response))
+	records := dto.FindAnswers(response, question.Type)
+	if len(records) == 0 && len(response.Response) == 0 {
+		return nil, &client.NoDataError{Name: question.Name, Type: question.Type, TTL: dto.NegativeTTL(response)}
+	}
+	if len(records) == 0 {
+		return nil, client.NotFound("no answer in response")
+	}
+	for i := range records {
+		records[i].Name = question.Name // the answer may be at the end of a CNAME chain
+	}
+	return records, nil
+}
+
+// exchange sends the message on a pooled connection, a stale pooled connection is replaced by a new one
+func (c *DOTClient) exchange(ctx context.Context, message dto.Message) (*dto.Message, error) {
+	payload := dto.SerializeMessage(message)
+
+	conn, pooled, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+	response, err := exchangeOn(ctx, conn, payload, message.ID)
+	if err != nil && pooled && ctx.Err() == nil {
+		_ = conn.Close()
+		if conn, err = c.dial(); err != nil {
+			return nil, err
+		}
+		response, err = exchangeOn(ctx, conn, payload, message.ID)
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, client.WrapTimeout(ctx.Err())
+		}
+		return nil, client.WrapTimeout(err)
+	}
+	c.recycleConn(conn)
+	return response, nil
+}
+
+// exchangeOn fails when ctx is done during the exchange, the deadline of the connection may then be set
+// at any time so it must not be reused
+func exchangeOn(ctx context.Context, conn *tls.Conn, payload []byte, id uint16) (*dto.Message, error) {
+	_ = conn.SetDeadline(client.Deadline(ctx, queryTimeout))
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	response, err := exchangeMessage(conn, payload, id)
+	if !stop() && err == nil {
+		return nil, ctx.Err()
+	}
+	return response, err
+}
+
+func exchangeMessage(conn *tls.Conn, payload []byte, id uint16) (*dto.Message, error) {
+	if err := framing.Write(conn, payload); err != nil {
+		return nil, err
+	}
+	data, err := framing.Read(conn)
+	if err != nil {
+		return nil, err
+	}
+	response, err := dto.ParseMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	if response.ID != id {
+		return nil, errors.New("id mismatch")
+	}
+	return response, nil
+}
+
+// getConn returns an idle connection, or a new one when there is none, pooled tells which one it is
+func (c *DOTClient) getConn() (conn *tls.Conn, pooled bool, err error) {
+	select {
+	case conn := <-c.idle:
+		return conn, true, nil
+	default:
+		conn, err := c.dial()
+		return conn, false, err
+	}
+}
+
+func (c *DOTClient) recycleConn(conn *tls.Conn) {
+	select {
+	case c.idle <- conn:
+	default:
+		_ = conn.Close() // enough idle connections
+	}
+}
+
+func (c *DOTClient) dial() (*tls.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	raw, err := c.family.Dial(ctx, &net.Dialer{}, "tcp", c.address)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, c.tlsConfig)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
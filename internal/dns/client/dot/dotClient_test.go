@@ -0,0 +1,128 @@
+package dot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
+)
+
+const serverName = "dns.test"
+
+// selfSigned generates a certificate for serverName and the pool trusting it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// serve answers 127.0.0.1 to every question named localhost and NXDOMAIN to the others
+func serve(listener net.Listener, accepted *atomic.Int32) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted.Add(1)
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				data, err := framing.Read(conn)
+				if err != nil {
+					return
+				}
+				query, err := dto.ParseMessage(data)
+				if err != nil {
+					return
+				}
+				response := dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: query.Question}
+				if query.Question[0].Name == "localhost" {
+					response.ResponseCount = 1
+					response.Response = []dto.Record{{Name: "localhost", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("127.0.0.1").To4()}}
+				} else {
+					response.Header |= dto.NAME_ERROR
+				}
+				if err := framing.Write(conn, dto.SerializeMessage(response)); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestDOTClient_ResolveV4(t *testing.T) {
+	cert, pool := selfSigned(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := &atomic.Int32{}
+	go serve(listener, accepted)
+
+	c := NewDOTClient(listener.Addr().String(), serverName)
+	c.tlsConfig.RootCAs = pool
+
+	for i := 0; i < 3; i++ {
+		record, err := c.ResolveV4("localhost")
+		if err != nil {
+			t.Fatalf("DOTClient.ResolveV4() error = %v", err)
+		}
+		if record.Data.String() != "127.0.0.1" {
+			t.Fatalf("DOTClient.ResolveV4() = %v, want 127.0.0.1", record)
+		}
+	}
+	if accepted.Load() != 1 {
+		t.Errorf("expecting the connection to be reused, got %d connections", accepted.Load())
+	}
+
+	_, err = c.ResolveV4("unknown")
+	if _, ok := err.(*client.NameError); !ok {
+		t.Errorf("DOTClient.ResolveV4() error = %v, want a NameError", err)
+	}
+}
+
+func TestDOTClient_untrusted(t *testing.T) {
+	cert, _ := selfSigned(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serve(listener, &atomic.Int32{})
+
+	c := NewDOTClient(listener.Addr().String(), serverName)
+	if _, err := c.ResolveV4("localhost"); err == nil {
+		t.Fatal("expecting an error for an untrusted certificate")
+	}
+}
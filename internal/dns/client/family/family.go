@@ -0,0 +1,132 @@
+package family
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Family is the address family an upstream is reached over, independently of the type of the records asked
+type Family string
+
+const (
+	// Any lets the system choose, the dual stack hosts are dialed with the happy eyeballs of the system
+	Any Family = ""
+	// IPv4 and IPv6 only dial the addresses of their family
+	IPv4 Family = "ipv4"
+	IPv6 Family = "ipv6"
+	// PreferIPv4 and PreferIPv6 dial the addresses of their family first, and the other one when it is unreachable
+	PreferIPv4 Family = "prefer-ipv4"
+	PreferIPv6 Family = "prefer-ipv6"
+)
+
+// FallbackDelay is the time the preferred family is given to connect before the other one is dialed too (rfc8305 section 5)
+const FallbackDelay = 300 * time.Millisecond
+
+// Parse returns the family of its name, Any when empty
+func Parse(name string) (Family, error) {
+	switch Family(name) {
+	case Any, IPv4, IPv6, PreferIPv4, PreferIPv6:
+		return Family(name), nil
+	}
+	return "", errors.New("unknown address family " + name + ", expecting ipv4, ipv6, prefer-ipv4 or prefer-ipv6")
+}
+
+// Networks returns the networks dialed for the family, in the order of preference
+func (f Family) Networks(network string) []string {
+	switch f {
+	case IPv4:
+		return []string{network + "4"}
+	case IPv6:
+		return []string{network + "6"}
+	case PreferIPv4:
+		return []string{network + "4", network + "6"}
+	case PreferIPv6:
+		return []string{network + "6", network + "4"}
+	}
+	return []string{network}
+}
+
+// Dial connects to the address over network, udp or tcp, with the addresses of the family.
+// A preferred family is dialed first, the other one is dialed when it fails, or when it has not connected after
+// FallbackDelay, and the first connection wins. Dialing udp does not reach the upstream, it falls back when the
+// preferred family has no address or no route to it
+func (f Family) Dial(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	networks := f.Networks(network)
+	if len(networks) == 1 {
+		return dialer.DialContext(ctx, networks[0], address)
+	}
+	return race(ctx, dialer, networks[0], networks[1], address)
+}
+
+type dialed struct {
+	conn     net.Conn
+	err      error
+	fallback bool
+}
+
+// race dials the address over primary, then over fallback when primary fails or is slow, the first connection is returned
+func race(ctx context.Context, dialer *net.Dialer, primary, fallback, address string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialed, 2)
+	dial := func(network string, isFallback bool) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		results <- dialed{conn: conn, err: err, fallback: isFallback}
+	}
+	go dial(primary, false)
+	timer := time.NewTimer(FallbackDelay)
+	defer timer.Stop()
+
+	pending, fallbackStarted := 1, false
+	var primaryErr, fallbackErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go dial(fallback, true)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// the loser is closed when it connects after the winner
+				go drain(results, pending)
+				return res.conn, nil
+			}
+			if res.fallback {
+				fallbackErr = res.err
+			} else {
+				primaryErr = res.err
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go dial(fallback, true)
+			}
+			if pending == 0 {
+				return nil, firstError(primaryErr, fallbackErr)
+			}
+		}
+	}
+}
+
+// drain closes the connections of the pending dials
+func drain(results chan dialed, pending int) {
+	for ; pending > 0; pending-- {
+		if res := <-results; res.err == nil {
+			_ = res.conn.Close()
+		}
+	}
+}
+
+// firstError returns the error of the preferred family, unless it has no address and the other one has a real failure
+func firstError(primary, fallback error) error {
+	var addrErr *net.AddrError
+	if errors.As(primary, &addrErr) && fallback != nil {
+		return fallback
+	}
+	return primary
+}
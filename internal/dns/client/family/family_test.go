@@ -0,0 +1,78 @@
+package family
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, name := range []string{"", "ipv4", "ipv6", "prefer-ipv4", "prefer-ipv6"} {
+		if got, err := Parse(name); err != nil || got != Family(name) {
+			t.Errorf("Parse(%q) = %v, %v", name, got, err)
+		}
+	}
+	if _, err := Parse("inet6"); err == nil {
+		t.Error("expecting an error for an unknown family")
+	}
+}
+
+func TestFamily_Dial(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	address := listener.Addr().String()
+
+	tests := []struct {
+		family  Family
+		wantErr bool
+	}{
+		{Any, false},
+		{IPv4, false},
+		{IPv6, true},
+		{PreferIPv4, false},
+		// the preferred family has no address, the IPv4 one is dialed
+		{PreferIPv6, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.family), func(t *testing.T) {
+			conn, err := tt.family.Dial(context.Background(), &net.Dialer{}, "tcp", address)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer conn.Close()
+				if conn.RemoteAddr().String() != address {
+					t.Errorf("expecting a connection to %s, got %s", address, conn.RemoteAddr())
+				}
+			}
+		})
+	}
+}
+
+func TestFamily_DialFailure(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	// the error of the preferred family without address is not the one reported
+	_, err = PreferIPv6.Dial(context.Background(), &net.Dialer{}, "tcp", address)
+	var addrErr *net.AddrError
+	if err == nil || errors.As(err, &addrErr) {
+		t.Errorf("expecting the refused connection of the IPv4 address, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Package groups applies more blocking lists and rules to the clients of some networks, like the devices of the
+// children, on top of the ones applied to every client
+package groups
+
+import (
+	"context"
+	"net"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.RequestClient = &Groups{}
+var _ blocker.Matcher = &Groups{}
+
+// Network is a subnet of clients. Zone restricts a link-local subnet to the clients reaching the server through
+// an interface, the same link-local address may be used by distinct devices on several links
+type Network struct {
+	Subnet *net.IPNet
+	Zone   string
+}
+
+// contains tells if the client is in the network, an ipv4 client may be given as an ipv4-mapped ipv6 address
+func (n Network) contains(ip net.IP, zone string) bool {
+	return n.Subnet.Contains(ip) && (n.Zone == "" || n.Zone == zone)
+}
+
+// Group is a set of clients with their own lists and rules
+type Group struct {
+	Name     string
+	Networks []Network
+	// Blocker holds the lists and the rules of the group, its allowed names are never blocked for the group
+	Blocker *blocker.Blocker
+}
+
+// Groups blocks the names of the blocker of the group of the client, then the ones of the base blocker
+// unless the group allows them. The clients outside of the groups only see the base blocker
+type Groups struct {
+	base   *blocker.Blocker
+	groups []Group
+}
+
+// NewGroups instantiates the client blocking with base and the blockers of the groups
+func NewGroups(base *blocker.Blocker, groups []Group) *Groups {
+	return &Groups{base: base, groups: groups}
+}
+
+// Match returns the group of the client, the one of its most specific network when several groups contain it.
+// A network restricted to the zone of the client is more specific than the same network without zone
+func (g *Groups) Match(ip net.IP, zone string) (*Group, bool) {
+	if ip == nil {
+		return nil, false
+	}
+	var res *Group
+	best := -1
+	for i := range g.groups {
+		if specificity := Specificity(g.groups[i].Networks, ip, zone); specificity > best {
+			res, best = &g.groups[i], specificity
+		}
+	}
+	return res, res != nil
+}
+
+// Specificity returns how specifically the networks contain the client, -1 when none of them contains it.
+// A longer prefix is more specific, and a network restricted to the zone of the client more than the same network without zone
+func Specificity(networks []Network, ip net.IP, zone string) int {
+	best := -1
+	for _, network := range networks {
+		ones, _ := network.Subnet.Mask.Size()
+		specificity := 2 * ones
+		if network.Zone != "" {
+			specificity++
+		}
+		if network.contains(ip, zone) && specificity > best {
+			best = specificity
+		}
+	}
+	return best
+}
+
+// Blocker returns the blocker of the group, nil when there is no such group
+func (g *Groups) Blocker(name string) *blocker.Blocker {
+	if g == nil {
+		return nil
+	}
+	for _, group := range g.groups {
+		if group.Name == name {
+			return group.Blocker
+		}
+	}
+	return nil
+}
+
+// MatchGroup returns the name of the group of the client, empty outside of the groups
+func (g *Groups) MatchGroup(ip net.IP, zone string) string {
+	if group, ok := g.Match(ip, zone); ok {
+		return group.Name
+	}
+	return ""
+}
+
+// group returns the group of the client of the request, the one resolved by the chain when it is known
+func (g *Groups) group(req request.Request) (*Group, bool) {
+	if req.Group == "" {
+		return g.Match(req.Client, req.Zone)
+	}
+	for i := range g.groups {
+		if g.groups[i].Name == req.Group {
+			return &g.groups[i], true
+		}
+	}
+	return nil, false
+}
+
+// ResolveRequest implements client.RequestClient
+func (g *Groups) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	group, ok := g.group(req)
+	if !ok {
+		return client.Resolve(g.base, name, t)
+	}
+	if _, blocked := group.Blocker.Match(name); blocked {
+		return client.Resolve(group.Blocker, name, t)
+	}
+	if group.Blocker.Allowed(name) {
+		return dto.Record{}, client.NotFound(name + " is allowed for the group " + group.Name)
+	}
+	return client.Resolve(g.base, name, t)
+}
+
+// MatchRequest implements blocker.Matcher, a name is blocked for the client like its questions are
+func (g *Groups) MatchRequest(req request.Request, name string) (*blocker.Blocker, string, bool) {
+	if group, ok := g.group(req); ok {
+		if source, blocked := group.Blocker.Match(name); blocked {
+			return group.Blocker, source, true
+		}
+		if group.Blocker.Allowed(name) {
+			return nil, "", false
+		}
+	}
+	return g.base.MatchRequest(req, name)
+}
+
+// ResolveV4 implements client.Client, without client only the base blocker is used
+func (g *Groups) ResolveV4(name string) (dto.Record, error) {
+	return g.base.ResolveV4(name)
+}
+
+// ResolveV6 implements client.Client, without client only the base blocker is used
+func (g *Groups) ResolveV6(name string) (dto.Record, error) {
+	return g.base.ResolveV6(name)
+}
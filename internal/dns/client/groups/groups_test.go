@@ -0,0 +1,86 @@
+package groups
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+func network(t *testing.T, cidr, zone string) Network {
+	_, res, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Network{Subnet: res, Zone: zone}
+}
+
+func TestGroups_ResolveRequest(t *testing.T) {
+	base := blocker.NewBlocker(10)
+	_ = base.Init(context.Background(), "ads", func(_ context.Context, add func(string)) error { add("ads.com"); add("video.com"); return nil })
+	kids := blocker.NewBlocker(10)
+	_ = kids.Init(context.Background(), "adult", func(_ context.Context, add func(string)) error { add("adult.com"); return nil })
+	guests := blocker.NewBlocker(10)
+	guests.Allow("video.com")
+	g := NewGroups(base, []Group{
+		{Name: "kids", Networks: []Network{network(t, "192.168.1.0/28", ""), network(t, "fe80::/64", "wlan0")}, Blocker: kids},
+		{Name: "guests", Networks: []Network{network(t, "192.168.0.0/16", ""), network(t, "fc00::/7", ""), network(t, "fe80::/64", "")}, Blocker: guests},
+	})
+
+	tests := []struct {
+		client      string
+		name        string
+		wantBlocked bool
+	}{
+		{client: "192.168.1.5", name: "adult.com", wantBlocked: true},
+		{client: "192.168.1.5", name: "ads.com", wantBlocked: true},
+		{client: "192.168.2.5", name: "adult.com", wantBlocked: false},
+		{client: "192.168.2.5", name: "video.com", wantBlocked: false},
+		{client: "192.168.2.5", name: "ads.com", wantBlocked: true},
+		{client: "10.0.0.5", name: "video.com", wantBlocked: true},
+		{client: "10.0.0.5", name: "adult.com", wantBlocked: false},
+		{name: "adult.com", wantBlocked: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.client+" "+tt.name, func(t *testing.T) {
+			req := request.Request{Client: net.ParseIP(tt.client)}
+			_, err := g.ResolveRequest(context.Background(), req, tt.name, dto.A)
+			if (err == nil) != tt.wantBlocked {
+				t.Errorf("Groups.ResolveRequest() error = %v, want blocked %v", err, tt.wantBlocked)
+			}
+			// the aliases of the answers are blocked for the client like its questions
+			if _, _, blocked := g.MatchRequest(req, tt.name); blocked != tt.wantBlocked {
+				t.Errorf("Groups.MatchRequest() = %v, want blocked %v", blocked, tt.wantBlocked)
+			}
+		})
+	}
+	for _, tt := range []struct {
+		client, zone, want string
+	}{
+		{client: "192.168.1.5", want: "kids"},
+		{client: "::ffff:192.168.1.5", want: "kids"},
+		{client: "fd12:3456::1", want: "guests"},
+		{client: "fe80::1", zone: "wlan0", want: "kids"},
+		{client: "fe80::1", zone: "eth0", want: "guests"},
+		{client: "2001:db8::1"},
+	} {
+		group, ok := g.Match(net.ParseIP(tt.client), tt.zone)
+		if ok != (tt.want != "") || (ok && group.Name != tt.want) {
+			t.Errorf("Groups.Match(%s%%%s) = %v, want %s", tt.client, tt.zone, group, tt.want)
+		}
+		if got := g.MatchGroup(net.ParseIP(tt.client), tt.zone); got != tt.want {
+			t.Errorf("Groups.MatchGroup(%s%%%s) = %s, want %s", tt.client, tt.zone, got, tt.want)
+		}
+	}
+	if g.Blocker("kids") != kids || g.Blocker("adults") != nil {
+		t.Errorf("Groups.Blocker() should return the blocker of the named group only")
+	}
+	// the group resolved by the chain is the one of the request
+	req := request.Request{Client: net.ParseIP("192.168.2.5"), Group: "kids"}
+	if _, err := g.ResolveRequest(context.Background(), req, "adult.com", dto.A); err != nil {
+		t.Errorf("expecting adult.com to be blocked for the group of the request, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package healthcheck answers the names of the health checks from the server itself, without asking any upstream,
+// so the monitoring can tell a server which is down from an upstream which is down
+package healthcheck
+
+import (
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ client.TypedClient = &HealthClient{}
+
+// Check is a name always answered while the server runs: its address to the questions of its family and its text to TXT.
+// The answers have a null ttl so the monitoring always reaches the server
+type Check struct {
+	Name    string
+	Address net.IP
+	Text    string
+}
+
+// HealthClient answers the checks and leaves the other names to the next clients
+type HealthClient struct {
+	checks map[string]Check
+}
+
+// NewHealthClient instantiates the client of the checks
+func NewHealthClient(checks []Check) *HealthClient {
+	res := &HealthClient{checks: make(map[string]Check, len(checks))}
+	for _, check := range checks {
+		res.checks[normalize(check.Name)] = check
+	}
+	return res
+}
+
+// DefaultText is the text of the checks without text, ok followed by the version of the server
+func DefaultText() string {
+	return "ok " + Version()
+}
+
+// Version returns the version of the module the server has been built from, (devel) for a local build
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+	return info.Main.Version
+}
+
+// Resolve implements client.TypedClient
+func (c *HealthClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	check, ok := c.checks[normalize(name)]
+	if !ok {
+		return dto.Record{}, client.NotFound(name + " is not a health check")
+	}
+	record := dto.Record{Name: name, Type: t, Class: dto.IN}
+	switch {
+	case t == dto.A && check.Address.To4() != nil:
+		record.Data = check.Address.To4()
+	case t == dto.AAAA && check.Address.To4() == nil:
+		record.Data = check.Address.To16()
+	case t == dto.TXT:
+		raw, err := dto.ParseRData(dto.TXT, strconv.Quote(check.Text))
+		if err != nil {
+			return dto.Record{}, err
+		}
+		record.Raw = raw
+	default:
+		return dto.Record{}, &client.NoDataError{Name: name, Type: t}
+	}
+	return record, nil
+}
+
+// ResolveV4 implements client.Client
+func (c *HealthClient) ResolveV4(name string) (dto.Record, error) {
+	return c.Resolve(name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *HealthClient) ResolveV6(name string) (dto.Record, error) {
+	return c.Resolve(name, dto.AAAA)
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
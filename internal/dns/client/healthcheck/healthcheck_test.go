@@ -0,0 +1,57 @@
+package healthcheck
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestHealthClient_Resolve(t *testing.T) {
+	c := NewHealthClient([]Check{
+		{Name: "dnshield.check", Address: net.ParseIP("127.0.0.1"), Text: "ok v1.2.0"},
+		{Name: "v6.dnshield.check.", Address: net.ParseIP("::1"), Text: "ok"},
+	})
+	text, err := dto.ParseRData(dto.TXT, `"ok v1.2.0"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		t          dto.Type
+		wantData   net.IP
+		wantRaw    []byte
+		wantNoData bool
+		wantErr    bool
+	}{
+		{name: "dnshield.check", t: dto.A, wantData: net.ParseIP("127.0.0.1").To4()},
+		{name: "DNShield.Check.", t: dto.TXT, wantRaw: text},
+		{name: "dnshield.check", t: dto.AAAA, wantNoData: true},
+		{name: "v6.dnshield.check", t: dto.AAAA, wantData: net.ParseIP("::1")},
+		{name: "v6.dnshield.check", t: dto.A, wantNoData: true},
+		{name: "dnshield.check", t: dto.MX, wantNoData: true},
+		{name: "example.com", t: dto.A, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.t.String(), func(t *testing.T) {
+			got, err := c.Resolve(tt.name, tt.t)
+			var noData *client.NoDataError
+			if errors.As(err, &noData) != tt.wantNoData || (err != nil && !tt.wantNoData) != tt.wantErr {
+				t.Fatalf("HealthClient.Resolve() error = %v, want no data %v, want error %v", err, tt.wantNoData, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.TTL != 0 || got.Name != tt.name {
+				t.Errorf("expecting an answer to %s without ttl, got %v", tt.name, got)
+			}
+			if !got.Data.Equal(tt.wantData) || !bytes.Equal(got.Raw, tt.wantRaw) {
+				t.Errorf("HealthClient.Resolve() = %v, want %v %v", got, tt.wantData, tt.wantRaw)
+			}
+		})
+	}
+}
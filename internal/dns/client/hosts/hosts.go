@@ -0,0 +1,215 @@
+// Package hosts answers the local names of a file in the /etc/hosts format, extended with records of the other types,
+// reloaded when the file changes so the LAN names can be managed outside the configuration:
+//
+//	# address followed by the names, as in /etc/hosts
+//	192.168.1.20 printer.lan printer
+//	# name, type and data, as in a zone file
+//	nas.lan CNAME storage.lan
+//	lan MX 10 mail.lan
+//	lan TXT "v=spf1 -all"
+package hosts
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("hosts")
+
+var _ client.TypedClient = &Hosts{}
+var _ client.SetClient = &Hosts{}
+
+// Parse reads the records of a file in the hosts format, the lines in error are skipped and reported together
+func Parse(r io.Reader) (*inmemoryclient.InMemoryClient, error) {
+	res := &inmemoryclient.InMemoryClient{}
+	var errs []error
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		if err := parseLine(res, stripComment(scanner.Text())); err != nil {
+			errs = append(errs, errors.New("line "+strconv.Itoa(n)+": "+err.Error()))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return res, errors.Join(errs...)
+}
+
+func parseLine(c *inmemoryclient.InMemoryClient, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	if len(fields) < 2 {
+		return errors.New("expecting an address and its names, or a name, a type and its data: " + line)
+	}
+	if net.ParseIP(fields[0]) != nil {
+		for _, name := range fields[1:] {
+			if err := c.Add(name, fields[0]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	name := strings.TrimSuffix(fields[0], ".")
+	t, err := dto.ParseType(fields[1])
+	if err != nil {
+		return err
+	}
+	// the data keeps its spacing, the strings of a TXT record may contain spaces
+	data := after(line, 2)
+	if t == dto.A || t == dto.AAAA {
+		return c.Add(name, data)
+	}
+	raw, err := dto.ParseRData(t, data)
+	if err != nil {
+		return err
+	}
+	return c.AddRecord(dto.Record{Name: name, Type: t, Raw: raw})
+}
+
+// after returns the line following its first n fields
+func after(line string, n int) string {
+	for i := 0; i < n; i++ {
+		line = strings.TrimLeft(line, " \t")
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			return ""
+		}
+		line = line[end:]
+	}
+	return strings.TrimSpace(line)
+}
+
+// stripComment removes the comment ending the line, a # inside quotes is kept
+func stripComment(line string) string {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"' && (i == 0 || line[i-1] != '\\'):
+			quoted = !quoted
+		case r == '#' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Load reads the records of the file at path
+func Load(path string) (*inmemoryclient.InMemoryClient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Hosts answers the records of a file, it is safe for concurrent use
+type Hosts struct {
+	path    string
+	records atomic.Pointer[inmemoryclient.InMemoryClient]
+	// modified is the modification time of the loaded file, only used by the reload
+	modified time.Time
+}
+
+// NewHosts loads the records of the file at path, the valid lines are answered even when an error is returned
+func NewHosts(path string) (*Hosts, error) {
+	res := &Hosts{path: path}
+	res.records.Store(&inmemoryclient.InMemoryClient{})
+	_, err := res.Reload()
+	return res, err
+}
+
+// Reload reads the file again when it changed since the last load and returns whether it did.
+// An unreadable file keeps the records of the last load
+func (h *Hosts) Reload() (bool, error) {
+	info, err := os.Stat(h.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(h.modified) {
+		return false, nil
+	}
+	records, err := Load(h.path)
+	if records == nil {
+		return false, err
+	}
+	h.modified = info.ModTime()
+	h.records.Store(records)
+	return true, err
+}
+
+// Resolve implements client.TypedClient
+func (h *Hosts) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return h.records.Load().Resolve(name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (h *Hosts) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return h.records.Load().ResolveRequest(ctx, req, name, t)
+}
+
+// ResolveSet implements client.SetClient, all the records of the name and the type in the file
+func (h *Hosts) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	return h.records.Load().ResolveSet(ctx, req, name, t)
+}
+
+// ResolveV4 implements client.Client
+func (h *Hosts) ResolveV4(name string) (dto.Record, error) {
+	return h.records.Load().ResolveV4(name)
+}
+
+// ResolveV6 implements client.Client
+func (h *Hosts) ResolveV6(name string) (dto.Record, error) {
+	return h.records.Load().ResolveV6(name)
+}
+
+// Records returns the records of the last load, sorted by name and type, none for a nil Hosts
+func (h *Hosts) Records() []dto.Record {
+	if h == nil {
+		return nil
+	}
+	return h.records.Load().Records()
+}
+
+// StartReload checks the file of h for changes every interval until ctx is done
+func StartReload(ctx context.Context, wg *sync.WaitGroup, h *Hosts, interval time.Duration, clk clock.Clock) {
+	wg.Add(1)
+	go reloadScheduler(ctx, wg, h, clk.NewTicker(interval))
+}
+
+func reloadScheduler(ctx context.Context, wg *sync.WaitGroup, h *Hosts, ticker clock.Ticker) {
+	defer wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			reloaded, err := h.Reload()
+			if err != nil {
+				logger.Warn("error reloading the hosts file", "path", h.path, "err", err)
+			}
+			if reloaded {
+				logger.Info("hosts file reloaded", "path", h.path, "records", len(h.Records()))
+			}
+		}
+	}
+}
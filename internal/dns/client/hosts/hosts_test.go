@@ -0,0 +1,124 @@
+package hosts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+const file = `# local names
+192.168.1.20 printer.lan printer   # the printer of the office
+fd00::20     printer.lan
+nas.lan      CNAME storage.lan
+storage.lan  A 192.168.1.30
+lan          MX 10 mail.lan
+lan          TXT "v=spf1 -all" "# not a comment"
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		t     dto.Type
+		value string
+	}{
+		{name: "printer.lan", t: dto.A, value: "192.168.1.20"},
+		{name: "printer", t: dto.A, value: "192.168.1.20"},
+		{name: "printer.lan", t: dto.AAAA, value: "fd00::20"},
+		{name: "nas.lan", t: dto.CNAME, value: "storage.lan"},
+		{name: "nas.lan", t: dto.A, value: "192.168.1.30"},
+		{name: "lan", t: dto.MX, value: "10 mail.lan"},
+		{name: "lan", t: dto.TXT, value: `"v=spf1 -all" "# not a comment"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.t.String(), func(t *testing.T) {
+			got, err := c.Resolve(tt.name, tt.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.name || got.Value() != tt.value {
+				t.Errorf("Resolve() = %s %s, want %s %s", got.Name, got.Value(), tt.name, tt.value)
+			}
+		})
+	}
+}
+
+func TestHosts_ResolveSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(file+"lan TXT \"google-site-verification=1\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := h.ResolveSet(context.Background(), request.Request{}, "lan", dto.TXT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].Value() != `"google-site-verification=1"` {
+		t.Errorf("expecting both TXT records of lan, got %v", records)
+	}
+	records, err = h.ResolveSet(context.Background(), request.Request{}, "nas.lan", dto.A)
+	if err != nil || len(records) != 1 || records[0].Name != "nas.lan" || records[0].Data.String() != "192.168.1.30" {
+		t.Errorf("expecting the address of the target of nas.lan, got %v, %v", records, err)
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	c, err := Parse(strings.NewReader("192.168.1.20 printer.lan\nbroken\nnas.lan CNAME\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expecting the errors of the lines 2 and 3, got %v", err)
+	}
+	if _, err := c.ResolveV4("printer.lan"); err != nil {
+		t.Errorf("expecting the valid lines to be kept, got %v", err)
+	}
+}
+
+func TestHosts_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("192.168.1.20 printer.lan\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := h.Reload(); reloaded || err != nil {
+		t.Errorf("expecting an unchanged file not to be reloaded, got %v, %v", reloaded, err)
+	}
+
+	if err := os.WriteFile(path, []byte("192.168.1.21 printer.lan\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the modification time may not change within the resolution of the file system
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := h.Reload(); !reloaded || err != nil {
+		t.Fatalf("expecting the changed file to be reloaded, got %v, %v", reloaded, err)
+	}
+	if got, _ := h.ResolveV4("printer.lan"); got.Data.String() != "192.168.1.21" {
+		t.Errorf("expecting the new address, got %v", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Reload(); err == nil {
+		t.Errorf("expecting an error for a removed file")
+	}
+	if got, _ := h.ResolveV4("printer.lan"); got.Data.String() != "192.168.1.21" {
+		t.Errorf("expecting the records to be kept, got %v", got)
+	}
+}
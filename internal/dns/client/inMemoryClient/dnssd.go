@@ -0,0 +1,94 @@
+package inmemoryclient
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// servicesName is the name enumerating the service types of a domain (rfc6763 section 9)
+const servicesName = "_services._dns-sd._udp."
+
+// Service is a local service published with DNS-SD (rfc6763), discovered as Instance.Type.Domain
+type Service struct {
+	// Instance is the user friendly name of the service, e.g. "Office Printer"
+	Instance string
+	// Type is the service and its protocol, e.g. "_ipp._tcp"
+	Type   string
+	Domain string
+	// Host is the name of the target serving the service on Port
+	Host string
+	Port uint16
+	// TXT are the key=value attributes of the service
+	TXT []string
+}
+
+// Name returns the name of the service instance
+func (s Service) Name() string {
+	return s.Instance + "." + s.serviceName()
+}
+
+func (s Service) serviceName() string {
+	return s.Type + "." + strings.TrimSuffix(s.Domain, ".")
+}
+
+// Check returns an error when the service cannot be published
+func (s Service) Check() error {
+	if s.Instance == "" || len(s.Instance) > 63 || strings.Contains(s.Instance, ".") {
+		return errors.New("bad service instance " + s.Instance + ", expecting up to 63 characters without dot")
+	}
+	labels := strings.Split(s.Type, ".")
+	if len(labels) != 2 || !strings.HasPrefix(labels[0], "_") || (labels[1] != "_tcp" && labels[1] != "_udp") {
+		return errors.New("bad service type " + s.Type + ", expecting _service._tcp or _service._udp")
+	}
+	if s.Domain == "" || s.Host == "" || s.Port == 0 {
+		return errors.New("service " + s.Instance + " needs a domain, a host and a port")
+	}
+	return nil
+}
+
+// AddService publishes the service: the PTR record of its type pointing to the instance,
+// the SRV and TXT records of the instance and the PTR record enumerating its type in the domain
+func (c *InMemoryClient) AddService(s Service) error {
+	if err := s.Check(); err != nil {
+		return err
+	}
+	service, err := dto.ParseRData(dto.PTR, s.serviceName())
+	if err != nil {
+		return err
+	}
+	// the instance label may contain spaces, it is encoded in front of the service name
+	instance := append([]byte{byte(len(s.Instance))}, s.Instance...)
+	instance = append(instance, service...)
+	srv, err := dto.ParseRData(dto.SRV, "0 0 "+strconv.Itoa(int(s.Port))+" "+s.Host)
+	if err != nil {
+		return err
+	}
+	// a service without attributes has a single empty string (rfc6763 section 6.1)
+	quoted := []string{`""`}
+	if len(s.TXT) > 0 {
+		quoted = quoted[:0]
+		for _, attribute := range s.TXT {
+			quoted = append(quoted, strconv.Quote(attribute))
+		}
+	}
+	txt, err := dto.ParseRData(dto.TXT, strings.Join(quoted, " "))
+	if err != nil {
+		return err
+	}
+
+	records := []dto.Record{
+		{Name: s.serviceName(), Type: dto.PTR, Raw: instance},
+		{Name: s.Name(), Type: dto.SRV, Raw: srv},
+		{Name: s.Name(), Type: dto.TXT, Raw: txt},
+		{Name: servicesName + strings.TrimSuffix(s.Domain, "."), Type: dto.PTR, Raw: service},
+	}
+	for _, record := range records {
+		if err := c.AddRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
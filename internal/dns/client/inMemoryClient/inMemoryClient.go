@@ -1,26 +1,112 @@
 package inmemoryclient
 
 import (
+	"bytes"
 	"errors"
 	"net"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
 )
 
-var _ client.Client = &InMemoryClient{}
+var _ client.TypedClient = &InMemoryClient{}
+
+// maxAliases bounds the CNAME records followed to resolve an address, to stop on loops
+const maxAliases = 8
 
 //Concurrent safe client, storing data in memory
 type InMemoryClient struct {
 	v4Store sync.Map
 	v6Store sync.Map
+	// records are the records of the other types, by name and type
+	lock    sync.RWMutex
+	records map[recordKey][]dto.Record
+	// views are the records answered to the clients of a group only, by group
+	views map[string]*InMemoryClient
+}
+
+type recordKey struct {
+	name string
+	t    dto.Type
+}
+
+// Resolve implements client.TypedClient
+func (c *InMemoryClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.resolveSet(name, t))
+}
+
+// resolveSet returns all the records of type t of name, a name has a single address of each family
+func (c *InMemoryClient) resolveSet(name string, t dto.Type) ([]dto.Record, error) {
+	switch t {
+	case dto.A, dto.AAAA:
+		load := c.loadV4
+		if t == dto.AAAA {
+			load = c.loadV6
+		}
+		record, err := c.follow(name, load)
+		if err != nil {
+			return nil, err
+		}
+		return []dto.Record{record}, nil
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	records := c.records[recordKey{name: name, t: t}]
+	if len(records) == 0 {
+		return nil, client.NotFound(name + " not found for " + t.String())
+	}
+	return slices.Clone(records), nil
 }
 
+// ResolveV4 implements client.Client, the local CNAME records are followed and the address is returned for name
 func (c *InMemoryClient) ResolveV4(name string) (dto.Record, error) {
+	return c.follow(name, c.loadV4)
+}
+
+// ResolveV6 implements client.Client, the local CNAME records are followed and the address is returned for name
+func (c *InMemoryClient) ResolveV6(name string) (dto.Record, error) {
+	return c.follow(name, c.loadV6)
+}
+
+// follow loads the address of name, or of the target of its CNAME record
+func (c *InMemoryClient) follow(name string, load func(string) (dto.Record, error)) (dto.Record, error) {
+	target := name
+	for i := 0; ; i++ {
+		record, err := load(target)
+		if err == nil {
+			record.Name = name
+			return record, nil
+		}
+		alias, ok := c.alias(target)
+		if !ok || i == maxAliases {
+			if target != name {
+				err = client.NotFound(name + " is an alias, " + err.Error())
+			}
+			return dto.Record{}, err
+		}
+		target = alias
+	}
+}
+
+// alias returns the target of the CNAME record of name
+func (c *InMemoryClient) alias(name string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	records := c.records[recordKey{name: name, t: dto.CNAME}]
+	if len(records) == 0 {
+		return "", false
+	}
+	return strings.TrimSuffix(records[0].Value(), "."), true
+}
+
+func (c *InMemoryClient) loadV4(name string) (dto.Record, error) {
 	ip, ok := c.v4Store.Load(name)
 	if !ok {
-		return dto.Record{}, errors.New(name + " not found for v4")
+		return dto.Record{}, client.NotFound(name + " not found for v4")
 	}
 	return dto.Record{
 		Name:  name,
@@ -30,10 +116,10 @@ func (c *InMemoryClient) ResolveV4(name string) (dto.Record, error) {
 		Data:  ip.(net.IP),
 	}, nil
 }
-func (c *InMemoryClient) ResolveV6(name string) (dto.Record, error) {
+func (c *InMemoryClient) loadV6(name string) (dto.Record, error) {
 	ip, ok := c.v6Store.Load(name)
 	if !ok {
-		return dto.Record{}, errors.New(name + " not found for v6")
+		return dto.Record{}, client.NotFound(name + " not found for v6")
 	}
 	return dto.Record{
 		Name:  name,
@@ -44,17 +130,123 @@ func (c *InMemoryClient) ResolveV6(name string) (dto.Record, error) {
 	}, nil
 }
 
+// Add adds the address of name, with the PTR record of the address pointing to name unless the address already has one
 func (c *InMemoryClient) Add(name, address string) error {
 	ip := net.ParseIP(address)
 	if !(c.tryAddV4(name, ip) || c.tryAddV6(name, ip)) {
 		return errors.New("unknown address format for " + ip.String())
 	}
+	return c.addReverse(ip, name, false)
+}
+
+// AddReverse adds the PTR record of the address pointing to name, answered before the ones added by Add
+func (c *InMemoryClient) AddReverse(address, name string) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return errors.New("unknown address format for " + address)
+	}
+	return c.addReverse(ip, name, true)
+}
+
+func (c *InMemoryClient) addReverse(ip net.IP, name string, first bool) error {
+	raw, err := dto.ParseRData(dto.PTR, name)
+	if err != nil {
+		return err
+	}
+	record := dto.Record{Name: dto.ReverseName(ip), Type: dto.PTR, Class: dto.IN, TTL: 200, Raw: raw}
+	key := recordKey{name: record.Name, t: dto.PTR}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.records == nil {
+		c.records = make(map[recordKey][]dto.Record)
+	}
+	switch {
+	case first:
+		c.records[key] = append([]dto.Record{record}, c.records[key]...)
+	case len(c.records[key]) == 0:
+		c.records[key] = []dto.Record{record}
+	}
 	return nil
 }
 
+// removeReverse removes the PTR record of the address pointing to name, once the address of name changed
+func (c *InMemoryClient) removeReverse(ip net.IP, name string) {
+	raw, err := dto.ParseRData(dto.PTR, name)
+	if err != nil {
+		return
+	}
+	key := recordKey{name: dto.ReverseName(ip), t: dto.PTR}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	records := c.records[key][:0:0]
+	for _, record := range c.records[key] {
+		if !bytes.Equal(record.Raw, raw) {
+			records = append(records, record)
+		}
+	}
+	if len(records) == 0 {
+		delete(c.records, key)
+		return
+	}
+	c.records[key] = records
+}
+
+// AddRecord adds a record of a type other than A and AAAA, its data is in Raw
+func (c *InMemoryClient) AddRecord(record dto.Record) error {
+	if record.Type == dto.A || record.Type == dto.AAAA {
+		return errors.New("use Add for the " + record.Type.String() + " record of " + record.Name)
+	}
+	if record.TTL == 0 {
+		record.TTL = 200
+	}
+	record.Class = dto.IN
+	key := recordKey{name: record.Name, t: record.Type}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, existing := range c.records[key] {
+		if bytes.Equal(existing.Raw, record.Raw) {
+			return nil
+		}
+	}
+	if c.records == nil {
+		c.records = make(map[recordKey][]dto.Record)
+	}
+	c.records[key] = append(c.records[key], record)
+	return nil
+}
+
+// Records returns all the records of the client, sorted by name and type
+func (c *InMemoryClient) Records() []dto.Record {
+	var res []dto.Record
+	c.v4Store.Range(func(name, _ any) bool {
+		record, _ := c.loadV4(name.(string))
+		res = append(res, record)
+		return true
+	})
+	c.v6Store.Range(func(name, _ any) bool {
+		record, _ := c.loadV6(name.(string))
+		res = append(res, record)
+		return true
+	})
+	c.lock.RLock()
+	for _, records := range c.records {
+		res = append(res, records...)
+	}
+	c.lock.RUnlock()
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].Type < res[j].Type
+	})
+	return res
+}
+
 func (c *InMemoryClient) tryAddV6(name string, ip net.IP) bool {
 	if v6 := ip.To16(); v6 != nil {
-		c.v6Store.Store(name, v6)
+		if previous, ok := c.v6Store.Swap(name, v6); ok && !previous.(net.IP).Equal(v6) {
+			c.removeReverse(previous.(net.IP), name)
+		}
 		return true
 	}
 	return false
@@ -62,7 +254,9 @@ func (c *InMemoryClient) tryAddV6(name string, ip net.IP) bool {
 
 func (c *InMemoryClient) tryAddV4(name string, ip net.IP) bool {
 	if v4 := ip.To4(); v4 != nil {
-		c.v4Store.Store(name, v4)
+		if previous, ok := c.v4Store.Swap(name, v4); ok && !previous.(net.IP).Equal(v4) {
+			c.removeReverse(previous.(net.IP), name)
+		}
 		return true
 	}
 	return false
@@ -1,12 +1,14 @@
 package inmemoryclient
 
 import (
+	"context"
 	"net"
 	"os"
 	"reflect"
 	"testing"
 
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
 )
 
 var c *InMemoryClient
@@ -105,3 +107,158 @@ func TestInMemoryClient_ResolveV6(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryClient_AddService(t *testing.T) {
+	c := &InMemoryClient{}
+	printer := Service{Instance: "Office Printer", Type: "_ipp._tcp", Domain: "home.lan", Host: "printer.home.lan", Port: 631, TXT: []string{"txtvers=1", "rp=ipp/print"}}
+	if err := c.AddService(printer); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddService(Service{Instance: "NAS", Type: "_smb._tcp", Domain: "home.lan", Host: "nas.home.lan", Port: 445}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		t     dto.Type
+		value string
+	}{
+		{name: "_ipp._tcp.home.lan", t: dto.PTR, value: "Office Printer._ipp._tcp.home.lan"},
+		{name: "Office Printer._ipp._tcp.home.lan", t: dto.SRV, value: "0 0 631 printer.home.lan"},
+		{name: "Office Printer._ipp._tcp.home.lan", t: dto.TXT, value: `"txtvers=1" "rp=ipp/print"`},
+		{name: "NAS._smb._tcp.home.lan", t: dto.TXT, value: `""`},
+		{name: "_services._dns-sd._udp.home.lan", t: dto.PTR, value: "_ipp._tcp.home.lan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.t.String(), func(t *testing.T) {
+			got, err := c.Resolve(tt.name, tt.t)
+			if err != nil {
+				t.Fatalf("InMemoryClient.Resolve() error = %v", err)
+			}
+			if got.Value() != tt.value {
+				t.Errorf("InMemoryClient.Resolve() = %s, want %s", got.Value(), tt.value)
+			}
+		})
+	}
+
+	if _, err := c.Resolve("_http._tcp.home.lan", dto.PTR); err == nil {
+		t.Errorf("expecting an error for an unknown service type")
+	}
+	for _, bad := range []Service{
+		{Instance: "a.b", Type: "_ipp._tcp", Domain: "home.lan", Host: "printer", Port: 631},
+		{Instance: "printer", Type: "ipp", Domain: "home.lan", Host: "printer", Port: 631},
+		{Instance: "printer", Type: "_ipp._tcp", Domain: "home.lan", Host: "printer"},
+	} {
+		if err := c.AddService(bad); err == nil {
+			t.Errorf("expecting an error for %v", bad)
+		}
+	}
+}
+
+func TestInMemoryClient_Records(t *testing.T) {
+	c := &InMemoryClient{}
+	_ = c.Add("nas.lan", "192.168.1.10")
+	_ = c.Add("nas.lan", "fd00::10")
+	_ = c.Add("b.lan", "192.168.1.11")
+	if err := c.AddRecord(dto.Record{Name: "nas.lan", Type: dto.TXT, Raw: []byte("\x02ok")}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range c.Records() {
+		got = append(got, r.Name+" "+r.Type.String()+" "+r.Value())
+	}
+	want := []string{
+		"0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa PTR nas.lan",
+		"10.1.168.192.in-addr.arpa PTR nas.lan",
+		"11.1.168.192.in-addr.arpa PTR b.lan",
+		"b.lan A 192.168.1.11", "nas.lan A 192.168.1.10", `nas.lan TXT "ok"`, "nas.lan AAAA fd00::10",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InMemoryClient.Records() = %v, want %v", got, want)
+	}
+}
+
+func TestInMemoryClient_reverse(t *testing.T) {
+	c := &InMemoryClient{}
+	_ = c.Add("printer.lan", "192.168.1.20")
+	_ = c.Add("printer", "192.168.1.20")
+	_ = c.Add("nas.lan", "192.168.1.30")
+	_ = c.Add("nas.lan", "192.168.1.31") // renumbered
+	if err := c.AddReverse("192.168.1.1", "router.lan"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Add("gateway.lan", "192.168.1.1")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "20.1.168.192.in-addr.arpa", want: "printer.lan"},
+		{name: "31.1.168.192.in-addr.arpa", want: "nas.lan"},
+		{name: "1.1.168.192.in-addr.arpa", want: "router.lan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Resolve(tt.name, dto.PTR)
+			if err != nil || got.Value() != tt.want {
+				t.Errorf("InMemoryClient.Resolve() = %v, %v, want %v", got.Value(), err, tt.want)
+			}
+		})
+	}
+	if _, err := c.Resolve("30.1.168.192.in-addr.arpa", dto.PTR); err == nil {
+		t.Errorf("expecting the reverse of the previous address to be removed")
+	}
+	if err := c.AddReverse("printer", "printer.lan"); err == nil {
+		t.Errorf("expecting an error for an invalid address")
+	}
+}
+
+func TestInMemoryClient_alias(t *testing.T) {
+	c := &InMemoryClient{}
+	c.Add("storage.lan", "192.168.1.30")
+	for name, target := range map[string]string{"nas.lan": "storage.lan", "a.lan": "b.lan", "b.lan": "a.lan"} {
+		raw, err := dto.ParseRData(dto.CNAME, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddRecord(dto.Record{Name: name, Type: dto.CNAME, Raw: raw}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := c.ResolveV4("nas.lan")
+	if err != nil || got.Name != "nas.lan" || got.Data.String() != "192.168.1.30" {
+		t.Errorf("expecting nas.lan -> 192.168.1.30, got %v, %v", got, err)
+	}
+	if _, err := c.ResolveV4("a.lan"); err == nil {
+		t.Errorf("expecting an error for a loop of aliases")
+	}
+}
+
+func TestInMemoryClient_views(t *testing.T) {
+	c := &InMemoryClient{}
+	c.Add("api.example.com", "203.0.113.10")
+	c.Add("nas.lan", "192.168.1.30")
+	c.AddView("devs").Add("api.example.com", "10.0.0.10")
+	c.AddView("lead").Add("api.example.com", "10.0.0.20")
+
+	tests := []struct {
+		name  string
+		group string
+		want  string
+	}{
+		{"api.example.com", "", "203.0.113.10"},
+		{"api.example.com", "guests", "203.0.113.10"},
+		{"api.example.com", "devs", "10.0.0.10"},
+		{"api.example.com", "lead", "10.0.0.20"},
+		{"nas.lan", "devs", "192.168.1.30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" from "+tt.group, func(t *testing.T) {
+			req := request.Request{Client: net.ParseIP("192.168.1.20"), Group: tt.group}
+			got, err := c.ResolveRequest(context.Background(), req, tt.name, dto.A)
+			if err != nil || got.Data.String() != tt.want {
+				t.Errorf("expecting %s, got %v, %v", tt.want, got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package inmemoryclient
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.SetClient = &InMemoryClient{}
+
+// AddView returns the client holding the records answered to the clients of the group before the ones of c,
+// like a staging address for the laptops of the developers. The group of a client is the one of its request
+func (c *InMemoryClient) AddView(group string) *InMemoryClient {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.views == nil {
+		c.views = make(map[string]*InMemoryClient)
+	}
+	res, ok := c.views[group]
+	if !ok {
+		res = &InMemoryClient{}
+		c.views[group] = res
+	}
+	return res
+}
+
+// ResolveRequest implements client.RequestClient, the records of the view of the client hide the ones of every client
+func (c *InMemoryClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient, the set of the view of the client hides the one of every client
+func (c *InMemoryClient) ResolveSet(_ context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	if v, ok := c.view(req.Group); ok {
+		records, err := v.resolveSet(name, t)
+		if !errors.Is(err, client.ErrNotFound) {
+			return records, err
+		}
+	}
+	return c.resolveSet(name, t)
+}
+
+// view returns the view of the group
+func (c *InMemoryClient) view(group string) (*InMemoryClient, bool) {
+	if group == "" {
+		return nil, false
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res, ok := c.views[group]
+	return res, ok
+}
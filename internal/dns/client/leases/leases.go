@@ -0,0 +1,245 @@
+// Package leases answers the hostnames of the DHCP leases with their addresses, and the addresses with the PTR records
+// of the hostnames, like dnsmasq does for the hosts of its DHCP server. The leases are read from the lease files of
+// dnsmasq, ISC dhcpd or Kea, or from a lease API answering them in json, and read again as they change or expire
+package leases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("leases")
+
+var _ client.TypedClient = &Leases{}
+
+// fetchTimeout is the time a lease API has to answer
+const fetchTimeout = 10 * time.Second
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// Leases answers the hostnames of the leases of its sources, it is safe for concurrent use
+type Leases struct {
+	sources []string
+	format  Format
+	domain  string
+	clock   clock.Clock
+	records atomic.Pointer[inmemoryclient.InMemoryClient]
+	// modified are the modification times of the loaded files, expires is the first expiry of the loaded leases,
+	// only used by the reload
+	modified map[string]time.Time
+	expires  time.Time
+}
+
+// NewLeases reads the leases of the sources, files in the format or urls of a lease API, the hostnames are answered
+// as they are and qualified by the domain when not empty. The leases read are answered even when an error is returned
+func NewLeases(sources []string, format Format, domain string, clk clock.Clock) (*Leases, error) {
+	res := &Leases{sources: sources, format: format, domain: strings.ToLower(strings.Trim(domain, ".")), clock: clk, modified: make(map[string]time.Time)}
+	res.records.Store(&inmemoryclient.InMemoryClient{})
+	_, err := res.Reload(context.Background())
+	return res, err
+}
+
+// Reload reads the leases again when a file changed, a lease expired or a source is an url, and returns whether it did.
+// An unreadable source keeps its leases out of the records until it can be read
+func (l *Leases) Reload(ctx context.Context) (bool, error) {
+	now := l.clock.Now()
+	changed := !l.expires.IsZero() && !now.Before(l.expires)
+	modified := make(map[string]time.Time, len(l.sources))
+	for _, source := range l.sources {
+		if isURL(source) {
+			changed = true
+			continue
+		}
+		info, err := os.Stat(source)
+		if err != nil {
+			// the file may be created once the DHCP server gives its first lease
+			changed = changed || !l.modified[source].IsZero()
+			continue
+		}
+		modified[source] = info.ModTime()
+		changed = changed || !info.ModTime().Equal(l.modified[source])
+	}
+	if !changed && len(l.modified) > 0 {
+		return false, nil
+	}
+	var errs []error
+	var leases []Lease
+	for _, source := range l.sources {
+		read, err := l.read(ctx, source)
+		if err != nil {
+			errs = append(errs, errors.New(source+": "+err.Error()))
+		}
+		leases = append(leases, read...)
+	}
+	records, expires := l.build(leases, now)
+	l.modified, l.expires = modified, expires
+	l.records.Store(records)
+	return true, errors.Join(errs...)
+}
+
+// read returns the leases of the source
+func (l *Leases) read(ctx context.Context, source string) ([]Lease, error) {
+	if isURL(source) {
+		return fetch(ctx, source)
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file, l.format)
+}
+
+// build returns the records of the leases not yet expired at now, and the first expiry of these leases
+func (l *Leases) build(leases []Lease, now time.Time) (*inmemoryclient.InMemoryClient, time.Time) {
+	res := &inmemoryclient.InMemoryClient{}
+	var expires time.Time
+	for _, lease := range leases {
+		if !lease.Expires.IsZero() && !now.Before(lease.Expires) {
+			continue
+		}
+		names, ok := l.names(lease.Hostname)
+		if !ok {
+			logger.Debug("skipping the lease of an invalid hostname", "hostname", lease.Hostname, "address", lease.Address)
+			continue
+		}
+		// the PTR record of the address points to the first name, the qualified one
+		for _, name := range names {
+			if err := res.Add(name, lease.Address.String()); err != nil {
+				logger.Debug("skipping the lease", "hostname", lease.Hostname, "err", err)
+			}
+		}
+		if !lease.Expires.IsZero() && (expires.IsZero() || lease.Expires.Before(expires)) {
+			expires = lease.Expires
+		}
+	}
+	return res, expires
+}
+
+// names returns the names of the hostname: a single label qualified by the domain then as it is, a name under the domain
+// as it is. It tells if the hostname is a valid name, a client cannot publish a name outside of the domain, as the
+// fully qualified names kept by Kea
+func (l *Leases) names(hostname string) ([]string, bool) {
+	host := strings.ToLower(strings.Trim(hostname, "."))
+	underDomain := l.domain != "" && strings.HasSuffix(host, "."+l.domain)
+	if underDomain {
+		host = strings.TrimSuffix(host, "."+l.domain)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !validLabel(label) {
+			return nil, false
+		}
+	}
+	switch {
+	case strings.Contains(host, "."):
+		if !underDomain {
+			return nil, false
+		}
+		return []string{host + "." + l.domain}, true
+	case l.domain == "":
+		return []string{host}, true
+	}
+	return []string{host + "." + l.domain, host}, true
+}
+
+// validLabel tells if the label is a hostname label (rfc952 and rfc1123 section 2.1)
+func validLabel(label string) bool {
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// fetch returns the leases answered by the lease API at url, a json array of the leases
+func fetch(ctx context.Context, url string) ([]Lease, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status " + res.Status)
+	}
+	var leases []Lease
+	if err := json.NewDecoder(io.LimitReader(res.Body, 16<<20)).Decode(&leases); err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// Resolve implements client.TypedClient
+func (l *Leases) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return l.records.Load().Resolve(name, t)
+}
+
+// ResolveV4 implements client.Client
+func (l *Leases) ResolveV4(name string) (dto.Record, error) {
+	return l.records.Load().ResolveV4(name)
+}
+
+// ResolveV6 implements client.Client
+func (l *Leases) ResolveV6(name string) (dto.Record, error) {
+	return l.records.Load().ResolveV6(name)
+}
+
+// Records returns the records of the last read, sorted by name and type, none for a nil Leases
+func (l *Leases) Records() []dto.Record {
+	if l == nil {
+		return nil
+	}
+	return l.records.Load().Records()
+}
+
+// StartReload checks the sources of l for changes every interval until ctx is done
+func StartReload(ctx context.Context, wg *sync.WaitGroup, l *Leases, interval time.Duration) {
+	wg.Add(1)
+	go reloadScheduler(ctx, wg, l, l.clock.NewTicker(interval))
+}
+
+func reloadScheduler(ctx context.Context, wg *sync.WaitGroup, l *Leases, ticker clock.Ticker) {
+	defer wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			reloaded, err := l.Reload(ctx)
+			if err != nil {
+				logger.Warn("error reading the leases", "err", err)
+			}
+			if reloaded {
+				logger.Debug("leases read", "records", len(l.Records()))
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package leases
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+const dnsmasqFile = `1700003600 aa:bb:cc:dd:ee:01 192.168.1.20 printer 01:aa:bb:cc:dd:ee:01
+0 aa:bb:cc:dd:ee:02 192.168.1.21 nas *
+1700003600 aa:bb:cc:dd:ee:03 192.168.1.22 * *
+duid 00:01:00:01:2c:7a:00:00:aa:bb:cc:dd:ee:01
+1700003600 1234 fd00::20 printer 00:01:00:01
+`
+
+const iscFile = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.1.30 {
+  starts 2 2023/11/14 22:00:00;
+  ends 3 2023/11/15 10:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:04;
+  client-hostname "laptop";
+}
+lease 192.168.1.31 {
+  ends never;
+  binding state active;
+  client-hostname "tv";
+}
+lease 192.168.1.32 {
+  ends 3 2023/11/15 10:00:00;
+  binding state free;
+  client-hostname "phone";
+}
+lease 192.168.1.30 {
+  ends 3 2023/11/15 12:00:00;
+  binding state active;
+  client-hostname "laptop";
+}
+`
+
+const keaFile = `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context
+192.168.1.40,aa:bb:cc:dd:ee:05,,3600,1700003600,1,0,0,camera.lan.,0,
+192.168.1.41,aa:bb:cc:dd:ee:06,,3600,1700003600,1,0,0,doorbell,1,
+192.168.1.42,aa:bb:cc:dd:ee:07,,3600,1700003600,1,0,0,,0,
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		want  []string
+		until time.Time
+	}{
+		{"dnsmasq", dnsmasqFile, []string{"printer 192.168.1.20", "nas 192.168.1.21", "printer fd00::20"}, time.Unix(1700003600, 0)},
+		{"isc", iscFile, []string{"laptop 192.168.1.30", "tv 192.168.1.31"}, time.Date(2023, 11, 15, 12, 0, 0, 0, time.UTC)},
+		{"kea", keaFile, []string{"camera.lan 192.168.1.40"}, time.Unix(1700003600, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leases, err := Parse(strings.NewReader(tt.data), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, lease := range leases {
+				got = append(got, lease.Hostname+" "+lease.Address.String())
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+			if !leases[0].Expires.Equal(tt.until) {
+				t.Errorf("expecting the first lease to expire at %s, got %s", tt.until, leases[0].Expires)
+			}
+		})
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	leases, err := Parse(strings.NewReader("1700003600 aa:bb 192.168.1.20 printer *\nbroken\n"), Dnsmasq)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expecting the error of the line 2, got %v", err)
+	}
+	if len(leases) != 1 {
+		t.Errorf("expecting the valid lines to be kept, got %v", leases)
+	}
+	if _, err := Parse(strings.NewReader("address,expire\n"), Kea); err == nil {
+		t.Error("expecting an error without hostname column")
+	}
+}
+
+func TestLeases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(path, []byte(dnsmasqFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	l, err := NewLeases([]string{path}, "", "lan.", clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		t     dto.Type
+		value string
+	}{
+		{"printer.lan", dto.A, "192.168.1.20"},
+		{"printer", dto.A, "192.168.1.20"},
+		{"printer.lan", dto.AAAA, "fd00::20"},
+		{"nas.lan", dto.A, "192.168.1.21"},
+		{"20.1.168.192.in-addr.arpa", dto.PTR, "printer.lan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.t.String(), func(t *testing.T) {
+			got, err := l.Resolve(tt.name, tt.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Value() != tt.value {
+				t.Errorf("Resolve() = %s, want %s", got.Value(), tt.value)
+			}
+		})
+	}
+
+	if reloaded, err := l.Reload(context.Background()); reloaded || err != nil {
+		t.Errorf("expecting no reload before a change, got %v, %v", reloaded, err)
+	}
+	// the printer lease expires, the one of the nas never does
+	clk.Advance(time.Hour)
+	if reloaded, err := l.Reload(context.Background()); !reloaded || err != nil {
+		t.Fatalf("expecting a reload once a lease expired, got %v, %v", reloaded, err)
+	}
+	if _, err := l.ResolveV4("printer.lan"); err == nil {
+		t.Error("expecting the expired lease to be removed")
+	}
+	if _, err := l.ResolveV4("nas"); err != nil {
+		t.Errorf("expecting the infinite lease to be kept, got %v", err)
+	}
+}
+
+func TestLeases_api(t *testing.T) {
+	leases := []Lease{{Hostname: "Thermostat", Address: net.ParseIP("192.168.1.50")}, {Hostname: "bad name", Address: net.ParseIP("192.168.1.51")}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(leases)
+	}))
+	defer server.Close()
+	l, err := NewLeases([]string{server.URL}, "", "", clock.Real{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, err := l.ResolveV4("thermostat"); err != nil || !record.Data.Equal(net.ParseIP("192.168.1.50")) {
+		t.Errorf("expecting the address of the lease of the api, got %v, %v", record, err)
+	}
+	if records := l.Records(); len(records) != 2 {
+		t.Errorf("expecting the A and PTR records of the valid hostname only, got %v", records)
+	}
+}
+
+func TestLeases_names(t *testing.T) {
+	l := &Leases{domain: "lan"}
+	tests := []struct {
+		hostname string
+		want     []string
+	}{
+		{hostname: "Laptop", want: []string{"laptop.lan", "laptop"}},
+		{hostname: "laptop.lan.", want: []string{"laptop.lan", "laptop"}},
+		{hostname: "tv.kids.lan", want: []string{"tv.kids.lan"}},
+		// the fully qualified name of a client outside of the domain is not published
+		{hostname: "www.bank.com"},
+		{hostname: "laptop.corp.example"},
+		{hostname: "bad_name"},
+	}
+	for _, tt := range tests {
+		got, ok := l.names(tt.hostname)
+		if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("names(%s) = %v, %v, want %v", tt.hostname, got, ok, tt.want)
+		}
+	}
+	if got, ok := (&Leases{}).names("www.bank.com"); ok {
+		t.Errorf("expecting a dotted name to be rejected without domain, got %v", got)
+	}
+}
@@ -0,0 +1,205 @@
+package leases
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the format of a lease file
+type Format string
+
+const (
+	// Dnsmasq is the format of the lease file of dnsmasq: expiry, mac, address, hostname and client id on each line
+	Dnsmasq Format = "dnsmasq"
+	// ISC is the format of the dhcpd.leases file of the ISC DHCP server, made of lease blocks
+	ISC Format = "isc"
+	// Kea is the format of the csv files of the memfile backend of Kea, with a header naming the columns
+	Kea Format = "kea"
+)
+
+// ParseFormat returns the format of its name, empty when the format is guessed from the content of the file
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case "", Dnsmasq, ISC, Kea:
+		return f, nil
+	}
+	return "", errors.New("unknown lease format " + name + ", expecting dnsmasq, isc or kea")
+}
+
+// Lease is the address leased to a host, the hostname is the one the host sent, without domain.
+// A zero Expires never expires
+type Lease struct {
+	Hostname string    `json:"hostname"`
+	Address  net.IP    `json:"address"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Parse reads the leases of a file in the format, guessed from its content when empty. The leases without hostname
+// are skipped, the expired ones are kept
+func Parse(r io.Reader, format Format) ([]Lease, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = guess(data)
+	}
+	switch format {
+	case ISC:
+		return parseISC(data)
+	case Kea:
+		return parseKea(data)
+	}
+	return parseDnsmasq(data)
+}
+
+// guess returns the format of the content of a file
+func guess(data []byte) Format {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("address,")):
+		return Kea
+	case bytes.Contains(data, []byte("lease ")) && bytes.Contains(data, []byte("{")):
+		return ISC
+	}
+	return Dnsmasq
+}
+
+// parseDnsmasq reads the lines expiry mac address hostname client-id, and expiry iaid address hostname duid for IPv6.
+// The hostname is * when unknown, the expiry is 0 for an infinite lease
+func parseDnsmasq(data []byte) ([]Lease, error) {
+	var res []Lease
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			errs = append(errs, errors.New("line "+strconv.Itoa(n)+": expecting the expiry, the mac, the address and the hostname"))
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		ip := net.ParseIP(fields[2])
+		if err != nil || ip == nil {
+			errs = append(errs, errors.New("line "+strconv.Itoa(n)+": invalid lease "+scanner.Text()))
+			continue
+		}
+		if fields[3] == "*" {
+			continue
+		}
+		lease := Lease{Hostname: fields[3], Address: ip}
+		if expiry != 0 {
+			lease.Expires = time.Unix(expiry, 0)
+		}
+		res = append(res, lease)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return res, errors.Join(errs...)
+}
+
+// parseISC reads the lease blocks of the IPv4 addresses, a later block of an address replaces the previous ones.
+// Only the active bindings are kept
+func parseISC(data []byte) ([]Lease, error) {
+	byAddress := make(map[string]int)
+	var res []Lease
+	var current *Lease
+	active := true
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "lease" && len(fields) == 3 && fields[2] == "{":
+			ip := net.ParseIP(fields[1])
+			if ip == nil {
+				return res, errors.New("line " + strconv.Itoa(n) + ": invalid lease address " + fields[1])
+			}
+			current, active = &Lease{Address: ip}, true
+		case current == nil:
+			// the blocks of the IPv6 leases and the other statements
+		case fields[0] == "}":
+			if active && current.Hostname != "" {
+				if i, ok := byAddress[current.Address.String()]; ok {
+					res[i] = *current
+				} else {
+					byAddress[current.Address.String()] = len(res)
+					res = append(res, *current)
+				}
+			}
+			current = nil
+		case fields[0] == "ends" && len(fields) == 4:
+			// ends weekday date time, in UTC
+			ends, err := time.Parse("2006/01/02 15:04:05", fields[2]+" "+fields[3])
+			if err != nil {
+				return res, errors.New("line " + strconv.Itoa(n) + ": invalid lease end " + line)
+			}
+			current.Expires = ends
+		case fields[0] == "binding" && len(fields) == 3 && fields[1] == "state":
+			active = fields[2] == "active"
+		case fields[0] == "client-hostname" && len(fields) == 2:
+			current.Hostname = strings.Trim(fields[1], `"`)
+		}
+	}
+	return res, scanner.Err()
+}
+
+// parseKea reads the csv file, its columns are found by the names of the header. The leases in another state than
+// the default one, declined or reclaimed, are skipped
+func parseKea(data []byte) ([]Lease, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	for _, name := range []string{"address", "expire", "hostname"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.New("the kea lease file has no " + name + " column")
+		}
+	}
+	column := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	var res []Lease
+	var errs []error
+	for n, row := range rows[1:] {
+		hostname := column(row, "hostname")
+		if hostname == "" || (column(row, "state") != "" && column(row, "state") != "0") {
+			continue
+		}
+		ip := net.ParseIP(column(row, "address"))
+		expire, err := strconv.ParseInt(column(row, "expire"), 10, 64)
+		if ip == nil || err != nil {
+			errs = append(errs, errors.New("line "+strconv.Itoa(n+2)+": invalid lease of "+hostname))
+			continue
+		}
+		// the hostname is the fqdn sent by the host or generated by kea
+		res = append(res, Lease{Hostname: strings.TrimSuffix(hostname, "."), Address: ip, Expires: time.Unix(expire, 0)})
+	}
+	return res, errors.Join(errs...)
+}
@@ -0,0 +1,287 @@
+// Package mdns resolves the names of the devices of the local network which only advertise them with multicast DNS,
+// with one-shot queries sent from an ephemeral port so the devices answer with unicast responses (rfc6762 section 5.1),
+// or through a unicast bridge like the DNS server of avahi or bonjour
+package mdns
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/udp"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("mdns")
+
+var _ client.TypedClient = &Client{}
+var _ client.RequestClient = &Client{}
+var _ client.SetClient = &Client{}
+
+const (
+	// DefaultTimeout is the time the devices have to answer, the devices usually answer within a few milliseconds
+	DefaultTimeout = 1 * time.Second
+	// Domain is the domain of the names advertised with multicast DNS (rfc6762 section 3)
+	Domain = "local"
+	// negativeTTL is the time a name no device answered is cached, so the clients asking again do not flood the network
+	negativeTTL = 5
+	// cacheFlush is the bit of the class of the records telling they replace the cached ones (rfc6762 section 10.2)
+	cacheFlush = 0x8000
+)
+
+var (
+	groupV4 = net.IPv4(224, 0, 0, 251)
+	groupV6 = net.ParseIP("ff02::fb")
+)
+
+// Client asks the questions to the devices of the local network, the names of its suffixes being asked as names of .local
+type Client struct {
+	suffixes []string
+	timeout  time.Duration
+	// bridge answers the questions instead of the multicast groups when not nil
+	bridge client.Client
+	// sources are the local addresses the queries are sent from, each one to the group of its family.
+	// A client without source nor bridge answers every name as not existing
+	sources []source
+}
+
+// source is a local address, nil for any address of the family of the group, and the group the queries are sent to
+type source struct {
+	local *net.UDPAddr
+	group *net.UDPAddr
+}
+
+// NewClient returns the client sending the queries to the multicast groups of the interface, in IPv4 and in IPv6,
+// or to the IPv4 group through the default route when the interface is empty
+func NewClient(iface string, suffixes []string) (*Client, error) {
+	c := &Client{suffixes: normalizeAll(suffixes), timeout: DefaultTimeout}
+	if iface == "" {
+		c.sources = []source{{group: &net.UDPAddr{IP: groupV4, Port: 5353}}}
+		return c, nil
+	}
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := i.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 bool
+	for _, address := range addresses {
+		ip, _, err := net.ParseCIDR(address.String())
+		switch {
+		case err != nil:
+		case ip.To4() != nil && !v4:
+			// the multicast queries leave through the interface of their source address
+			c.sources = append(c.sources, source{local: &net.UDPAddr{IP: ip}, group: &net.UDPAddr{IP: groupV4, Port: 5353}})
+			v4 = true
+		case ip.To4() == nil && ip.IsLinkLocalUnicast() && !v6:
+			c.sources = append(c.sources, source{local: &net.UDPAddr{IP: ip, Zone: iface}, group: &net.UDPAddr{IP: groupV6, Port: 5353, Zone: iface}})
+			v6 = true
+		}
+	}
+	if len(c.sources) == 0 {
+		return nil, errors.New("the interface " + iface + " has no address to send the multicast queries from")
+	}
+	return c, nil
+}
+
+// NewBridgeClient returns the client asking the questions to the unicast DNS server at address, answering the names
+// of .local like the DNS server of avahi or bonjour
+func NewBridgeClient(address string, suffixes []string) *Client {
+	return &Client{suffixes: normalizeAll(suffixes), timeout: DefaultTimeout, bridge: udp.NewUDPClient(address)}
+}
+
+// NewUnavailableClient returns the client of the suffixes when the multicast queries cannot be sent, it answers every
+// name as not existing so the names of the local network are not asked to the upstream
+func NewUnavailableClient(suffixes []string) *Client {
+	return &Client{suffixes: normalizeAll(suffixes), timeout: DefaultTimeout}
+}
+
+// SetTimeout sets the time the devices have to answer, it must be called before using the client
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+	if bridge, ok := c.bridge.(*udp.UDPClient); ok {
+		bridge.SetTimeout(timeout, udp.DefaultRetries)
+	}
+}
+
+// ResolveV4 implements client.Client
+func (c *Client) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *Client) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient
+func (c *Client) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *Client) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient, the records answer the name asked even when it has been asked as a name of .local.
+// A name no device answers does not exist
+func (c *Client) ResolveSet(ctx context.Context, _ request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	local := c.localName(name)
+	var records []dto.Record
+	var err error
+	switch {
+	case c.bridge == nil && len(c.sources) == 0:
+		return nil, &client.NameError{Name: name, TTL: negativeTTL}
+	case c.bridge != nil:
+		// the options of the client are meant for the upstream, not for the bridge
+		records, err = client.ResolveSet(ctx, c.bridge, request.Request{}, local, t)
+	default:
+		records, err = c.ask(ctx, local, t)
+	}
+	for i := range records {
+		records[i].Name = name
+	}
+	return records, err
+}
+
+// localName returns the name of .local of a name of one of the suffixes, the other names as they are
+func (c *Client) localName(name string) string {
+	name = normalize(name)
+	for _, suffix := range c.suffixes {
+		if name == suffix {
+			return Domain
+		}
+		if strings.HasSuffix(name, "."+suffix) {
+			return strings.TrimSuffix(name, suffix) + Domain
+		}
+	}
+	return name
+}
+
+// ask sends the query to the groups of the sources and returns the records of the first device answering
+func (c *Client) ask(ctx context.Context, name string, t dto.Type) ([]dto.Record, error) {
+	id := uint16(rand.Uint32())
+	// the recursion is not desired from the devices (rfc6762 section 18.6)
+	query := dto.SerializeMessage(dto.Message{
+		ID:            id,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: name, Type: t, Class: dto.IN}},
+	})
+	deadline := client.Deadline(ctx, c.timeout)
+	responses := make(chan *dto.Message, len(c.sources))
+	var sendErr error
+	sent := 0
+	for _, s := range c.sources {
+		conn, err := c.send(s, query, deadline)
+		if err != nil {
+			logger.Debug("cannot send the query", "group", s.group, "err", err)
+			sendErr = err
+			continue
+		}
+		defer conn.Close()
+		sent++
+		go receive(conn, responses)
+	}
+	if sent == 0 {
+		return nil, errors.New("cannot send the mdns query: " + sendErr.Error())
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, client.WrapTimeout(ctx.Err())
+		case <-timer.C:
+			return nil, &client.NameError{Name: name, TTL: negativeTTL}
+		case response := <-responses:
+			records, exists := answers(response, name, t)
+			if len(records) > 0 {
+				return records, nil
+			}
+			if exists {
+				return nil, &client.NoDataError{Name: name, Type: t, TTL: negativeTTL}
+			}
+		}
+	}
+}
+
+// send sends the query to the group of the source from an ephemeral port, the returned connection receives the answers
+func (c *Client) send(s source, query []byte, deadline time.Time) (*net.UDPConn, error) {
+	network := "udp4"
+	if s.group.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, s.local)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(deadline)
+	if _, err := conn.WriteTo(query, s.group); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// receive sends the responses read from the connection, until it is closed or its deadline is reached
+func receive(conn *net.UDPConn, responses chan<- *dto.Message) {
+	buffer := make([]byte, dto.BufferMaxLength)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return
+		}
+		response, err := dto.ParseMessage(buffer[:n])
+		if err != nil || response.Header&0x8000 == 0 {
+			continue
+		}
+		select {
+		case responses <- response:
+		default:
+			// an answer has already been chosen or is waiting for it
+		}
+	}
+}
+
+// answers returns the records of the response of the type for the name, their class without the cache flush bit.
+// It tells if the response has records of other types for the name, the name then has no record of the type
+func answers(response *dto.Message, name string, t dto.Type) ([]dto.Record, bool) {
+	var res []dto.Record
+	exists := false
+	// the devices may give the answers in the additional section of a response to another question
+	for _, section := range [][]dto.Record{response.Response, response.Additional} {
+		for _, record := range section {
+			if normalize(record.Name) != name {
+				continue
+			}
+			exists = true
+			if record.Type == t {
+				record.Class &^= cacheFlush
+				res = append(res, record)
+			}
+		}
+	}
+	return res, exists
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.Trim(name, "."))
+}
+
+func normalizeAll(names []string) []string {
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		res = append(res, normalize(name))
+	}
+	return res
+}
@@ -0,0 +1,111 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+// startDevice answers the queries about printer.local with its IPv4 address, like a device advertising its name,
+// and ignores the other ones
+func startDevice(t *testing.T) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buffer := make([]byte, dto.BufferMaxLength)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			query, err := dto.ParseMessage(buffer[:n])
+			if err != nil || query.Question[0].Name != "printer.local" {
+				continue
+			}
+			record := dto.Record{Name: "printer.local", Type: dto.A, Class: dto.IN | cacheFlush, TTL: 120, Data: net.IPv4(192, 168, 1, 20).To4()}
+			response := dto.Message{ID: query.ID, Header: 0x8400, QuestionCount: 1, Question: query.Question}
+			if query.Question[0].Type == dto.A {
+				response.ResponseCount, response.Response = 1, []dto.Record{record}
+			} else {
+				// the device tells the name exists without record of the type
+				response.AdditionalCount, response.Additional = 1, []dto.Record{record}
+			}
+			_, _ = conn.WriteToUDP(dto.SerializeMessage(response), from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestClient(t *testing.T) {
+	c := &Client{
+		suffixes: normalizeAll([]string{"lan."}),
+		timeout:  200 * time.Millisecond,
+		sources:  []source{{group: startDevice(t)}},
+	}
+	records, err := c.ResolveSet(context.Background(), request.Request{}, "Printer.lan", dto.A)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Name != "Printer.lan" || records[0].Class != dto.IN || !records[0].Data.Equal(net.IPv4(192, 168, 1, 20)) {
+		t.Errorf("expecting the address of the printer for the name asked, got %+v", records)
+	}
+
+	var noData *client.NoDataError
+	if _, err := c.ResolveV6("printer.local"); !errors.As(err, &noData) {
+		t.Errorf("expecting no data for the missing type of an existing name, got %v", err)
+	}
+
+	var nameError *client.NameError
+	start := time.Now()
+	if _, err := c.ResolveV4("scanner.local"); !errors.As(err, &nameError) {
+		t.Errorf("expecting a name error when no device answers, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < c.timeout {
+		t.Errorf("expecting the answers to be waited for %s, got %s", c.timeout, elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.ResolveSet(ctx, request.Request{}, "scanner.local", dto.A); !errors.Is(err, context.Canceled) {
+		t.Errorf("expecting the error of the context once the query is cancelled, got %v", err)
+	}
+}
+
+func TestClient_localName(t *testing.T) {
+	c := &Client{suffixes: normalizeAll([]string{"lan", "home.arpa"})}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"printer.local.", "printer.local"},
+		{"printer.lan", "printer.local"},
+		{"nas.office.home.arpa", "nas.office.local"},
+		{"plan", "plan"},
+		{"example.com", "example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.localName(tt.name); got != tt.want {
+				t.Errorf("localName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnavailableClient(t *testing.T) {
+	c := NewUnavailableClient([]string{"lan"})
+	_, err := c.ResolveSet(context.Background(), request.Request{}, "printer.lan", dto.A)
+	var nameError *client.NameError
+	if !errors.As(err, &nameError) || nameError.Name != "printer.lan" {
+		t.Errorf("expecting printer.lan not to exist, got %v", err)
+	}
+}
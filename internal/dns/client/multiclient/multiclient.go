@@ -0,0 +1,202 @@
+package multiclient
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("multiclient")
+
+var _ client.TypedClient = &MultiClient{}
+var _ client.RequestClient = &MultiClient{}
+var _ client.SetClient = &MultiClient{}
+
+const (
+	// maxFailures is the number of consecutive failures putting an upstream down
+	maxFailures = 3
+	// downDuration is the duration an upstream is skipped once down
+	downDuration = 30 * time.Second
+)
+
+// Strategy is the way the questions are spread over the upstreams
+type Strategy string
+
+const (
+	// Failover asks the upstreams in order until one answers
+	Failover Strategy = "failover"
+	// RoundRobin starts with the next upstream at every question, then fails over to the following ones
+	RoundRobin Strategy = "round-robin"
+	// Race asks all the upstreams at once and takes the first answer
+	Race Strategy = "race"
+)
+
+// ParseStrategy returns the strategy of its name, failover when empty
+func ParseStrategy(name string) (Strategy, error) {
+	switch Strategy(name) {
+	case "", Failover:
+		return Failover, nil
+	case RoundRobin, Race:
+		return Strategy(name), nil
+	}
+	return "", errors.New("unknown upstream strategy " + name + ", expecting failover, round-robin or race")
+}
+
+// upstream is a client with its health
+type upstream struct {
+	name     string
+	client   client.Client
+	failures atomic.Int32
+	// downUntil is the time in unix nanoseconds the upstream is skipped until
+	downUntil atomic.Int64
+}
+
+// MultiClient spreads the questions over several upstreams according to its strategy,
+// the upstreams failing maxFailures times in a row are skipped for downDuration while others are up
+type MultiClient struct {
+	strategy  Strategy
+	upstreams []*upstream
+	next      atomic.Uint32
+	clock     clock.Clock
+}
+
+// NewMultiClient instantiate a MultiClient without upstream, the upstreams must be added before using it
+func NewMultiClient(strategy Strategy) *MultiClient {
+	return &MultiClient{strategy: strategy, clock: clock.Real{}}
+}
+
+// Add adds an upstream, the failover strategy asks them in the order they are added
+func (m *MultiClient) Add(name string, c client.Client) {
+	m.upstreams = append(m.upstreams, &upstream{name: name, client: c})
+}
+
+// ResolveV4 implements client.Client
+func (m *MultiClient) ResolveV4(name string) (dto.Record, error) {
+	return m.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (m *MultiClient) ResolveV6(name string) (dto.Record, error) {
+	return m.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient
+func (m *MultiClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return m.ResolveRequest(context.Background(), request.Request{}, name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (m *MultiClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(m.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (m *MultiClient) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	if len(m.upstreams) == 0 {
+		return nil, errors.New("no upstream to resolve " + name)
+	}
+	upstreams := m.order()
+	if m.strategy == Race {
+		return m.race(ctx, upstreams, req, name, t)
+	}
+	var err error
+	for _, u := range upstreams {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var records []dto.Record
+		if records, err = m.ask(ctx, u, req, name, t); err == nil || isAnswer(err) {
+			return records, err
+		}
+	}
+	return nil, err
+}
+
+// race asks the question to all the upstreams and returns the first answer, or the last failure.
+// The questions still asked to the slowest upstreams are cancelled once the race is over
+func (m *MultiClient) race(ctx context.Context, upstreams []*upstream, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		records []dto.Record
+		err     error
+	}
+	// buffered so the slowest upstreams do not block once the race is over
+	results := make(chan result, len(upstreams))
+	for _, u := range upstreams {
+		go func(u *upstream) {
+			records, err := m.ask(ctx, u, req, name, t)
+			results <- result{records: records, err: err}
+		}(u)
+	}
+	var err error
+	for range upstreams {
+		r := <-results
+		if r.err == nil || isAnswer(r.err) {
+			return r.records, r.err
+		}
+		err = r.err
+	}
+	return nil, err
+}
+
+// ask asks the question to the upstream and tracks its health, a question cancelled by ctx is not a failure of the upstream
+func (m *MultiClient) ask(ctx context.Context, u *upstream, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	records, err := client.ResolveSet(ctx, u.client, req, name, t)
+	if err == nil || isAnswer(err) {
+		u.failures.Store(0)
+		return records, err
+	}
+	if ctx.Err() != nil {
+		return records, err
+	}
+	if u.failures.Add(1) == maxFailures {
+		logger.Warn("upstream is down", "upstream", u.name, "duration", downDuration, "failures", maxFailures, "err", err)
+		u.downUntil.Store(m.clock.Now().Add(downDuration).UnixNano())
+	}
+	return records, err
+}
+
+// order returns the upstreams to ask, the ones up in the order of the strategy followed by the ones down,
+// so a question is still answered when all of them are down
+func (m *MultiClient) order() []*upstream {
+	start := 0
+	if m.strategy == RoundRobin {
+		start = int(m.next.Add(1)-1) % len(m.upstreams)
+	}
+	now := m.clock.Now().UnixNano()
+	up := make([]*upstream, 0, len(m.upstreams))
+	var down []*upstream
+	for i := range m.upstreams {
+		u := m.upstreams[(start+i)%len(m.upstreams)]
+		if now < u.downUntil.Load() {
+			down = append(down, u)
+			continue
+		}
+		if u.failures.Load() >= maxFailures {
+			// back from its down period, one more failure puts it down again
+			u.failures.Store(maxFailures - 1)
+		}
+		up = append(up, u)
+	}
+	if m.strategy == Race && len(up) > 0 {
+		return up
+	}
+	return append(up, down...)
+}
+
+// isAnswer tells if the error is an answer of the upstream, a negative answer, an answer without record or a refusal,
+// which is not a failure. Only the transport errors and the failures of the upstream count against its health
+func isAnswer(err error) bool {
+	var nameError *client.NameError
+	var noDataError *client.NoDataError
+	var refusedError *client.RefusedError
+	return errors.As(err, &nameError) || errors.As(err, &noDataError) || errors.As(err, &refusedError) || errors.Is(err, client.ErrNotFound)
+}
@@ -0,0 +1,161 @@
+package multiclient
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+// fakeClient answers its address after delay, or fails, or has no record
+type fakeClient struct {
+	address string
+	delay   time.Duration
+	failing atomic.Bool
+	empty   atomic.Bool
+	calls   atomic.Int32
+}
+
+func (c *fakeClient) ResolveV4(name string) (dto.Record, error) {
+	c.calls.Add(1)
+	time.Sleep(c.delay)
+	if c.failing.Load() {
+		return dto.Record{}, errors.New("timeout")
+	}
+	if c.empty.Load() {
+		return dto.Record{}, client.NotFound("no record for " + name)
+	}
+	if c.address == "" {
+		return dto.Record{}, &client.NameError{Name: name}
+	}
+	return dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP(c.address)}, nil
+}
+
+func (c *fakeClient) ResolveV6(name string) (dto.Record, error) {
+	return dto.Record{}, errors.New("not implemented")
+}
+
+func newMultiClient(strategy Strategy, clients ...*fakeClient) *MultiClient {
+	m := NewMultiClient(strategy)
+	for _, c := range clients {
+		m.Add(c.address, c)
+	}
+	return m
+}
+
+func resolve(t *testing.T, m *MultiClient) string {
+	t.Helper()
+	record, err := m.ResolveV4("example.com")
+	if err != nil {
+		t.Fatalf("MultiClient.ResolveV4() error = %v", err)
+	}
+	return record.Data.String()
+}
+
+func TestMultiClient_Failover(t *testing.T) {
+	primary, secondary := &fakeClient{address: "10.0.0.1"}, &fakeClient{address: "10.0.0.2"}
+	m := newMultiClient(Failover, primary, secondary)
+	clk := clock.NewFake(time.Now())
+	m.clock = clk
+
+	if got := resolve(t, m); got != "10.0.0.1" {
+		t.Errorf("expecting the primary, got %s", got)
+	}
+	primary.failing.Store(true)
+	for i := 0; i < maxFailures; i++ {
+		if got := resolve(t, m); got != "10.0.0.2" {
+			t.Errorf("expecting the secondary, got %s", got)
+		}
+	}
+	calls := primary.calls.Load()
+	resolve(t, m)
+	if primary.calls.Load() != calls {
+		t.Errorf("the primary down should not be asked")
+	}
+
+	primary.failing.Store(false)
+	clk.Advance(downDuration)
+	if got := resolve(t, m); got != "10.0.0.1" {
+		t.Errorf("expecting the primary back after its down period, got %s", got)
+	}
+}
+
+func TestMultiClient_NotFound(t *testing.T) {
+	primary, secondary := &fakeClient{address: "10.0.0.1"}, &fakeClient{address: "10.0.0.2"}
+	m := newMultiClient(Failover, primary, secondary)
+	primary.empty.Store(true)
+	for i := 0; i < maxFailures; i++ {
+		if _, err := m.ResolveV4("example.com"); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("expecting the answer without record of the primary, got %v", err)
+		}
+	}
+	primary.empty.Store(false)
+	if got := resolve(t, m); got != "10.0.0.1" {
+		t.Errorf("an answer without record should not put the primary down, got %s", got)
+	}
+}
+
+func TestMultiClient_AllDown(t *testing.T) {
+	primary, secondary := &fakeClient{address: "10.0.0.1"}, &fakeClient{address: "10.0.0.2"}
+	primary.failing.Store(true)
+	secondary.failing.Store(true)
+	m := newMultiClient(Failover, primary, secondary)
+	for i := 0; i < maxFailures; i++ {
+		if _, err := m.ResolveV4("example.com"); err == nil {
+			t.Fatal("expecting an error when all the upstreams fail")
+		}
+	}
+	secondary.failing.Store(false)
+	if got := resolve(t, m); got != "10.0.0.2" {
+		t.Errorf("the upstreams down should still be asked when no upstream is up, got %s", got)
+	}
+}
+
+func TestMultiClient_RoundRobin(t *testing.T) {
+	first, second := &fakeClient{address: "10.0.0.1"}, &fakeClient{address: "10.0.0.2"}
+	m := newMultiClient(RoundRobin, first, second)
+	got := []string{resolve(t, m), resolve(t, m), resolve(t, m)}
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("MultiClient.ResolveV4() = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestMultiClient_Race(t *testing.T) {
+	slow, fast := &fakeClient{address: "10.0.0.1", delay: 200 * time.Millisecond}, &fakeClient{address: "10.0.0.2"}
+	m := newMultiClient(Race, slow, fast)
+	if got := resolve(t, m); got != "10.0.0.2" {
+		t.Errorf("expecting the fastest answer, got %s", got)
+	}
+
+	fast.failing.Store(true)
+	if got := resolve(t, m); got != "10.0.0.1" {
+		t.Errorf("expecting the answer of the upstream not failing, got %s", got)
+	}
+
+	nxdomain := &fakeClient{}
+	m = newMultiClient(Race, slow, nxdomain)
+	var nameError *client.NameError
+	if _, err := m.ResolveV4("example.com"); !errors.As(err, &nameError) {
+		t.Errorf("a negative answer should win the race, got %v", err)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, name := range []string{"", "failover", "round-robin", "race"} {
+		if _, err := ParseStrategy(name); err != nil {
+			t.Errorf("ParseStrategy(%q) error = %v", name, err)
+		}
+	}
+	if _, err := ParseStrategy("random"); err == nil {
+		t.Errorf("expecting an error for an unknown strategy")
+	}
+}
@@ -0,0 +1,82 @@
+// Package noise tells the connectivity checks, the time servers and the telemetry of the devices, the cache keeps
+// their answers for a long time as these names are asked over and over by the devices whatever the ttl of their records
+package noise
+
+import (
+	"errors"
+	"strings"
+)
+
+// Domains are the well-known names asked by the devices on their own: the captive portal and connectivity checks,
+// the time servers and the telemetry, *.name for the subdomains of name
+var Domains = []string{
+	// captive portal and connectivity checks
+	"connectivitycheck.gstatic.com",
+	"connectivitycheck.android.com",
+	"clients3.google.com",
+	"captive.apple.com",
+	"www.msftconnecttest.com",
+	"ipv6.msftconnecttest.com",
+	"www.msftncsi.com",
+	"dns.msftncsi.com",
+	"detectportal.firefox.com",
+	"nmcheck.gnome.org",
+	"connectivity-check.ubuntu.com",
+	"network-test.debian.org",
+	"conncheck.opensuse.org",
+	// time servers
+	"pool.ntp.org",
+	"*.pool.ntp.org",
+	"time.apple.com",
+	"time.windows.com",
+	"time.google.com",
+	"ntp.ubuntu.com",
+	// telemetry
+	"v10.events.data.microsoft.com",
+	"settings-win.data.microsoft.com",
+	"metrics.icloud.com",
+	"device-metrics-us.amazon.com",
+	"device-metrics-us-2.amazon.com",
+}
+
+// Matcher tells the names of the noise domains
+type Matcher struct {
+	// patterns are the lower case domains, *.name for the subdomains of name
+	patterns map[string]struct{}
+}
+
+// NewMatcher returns the matcher of the domains, *.name for the subdomains of name
+func NewMatcher(domains []string) *Matcher {
+	res := &Matcher{patterns: make(map[string]struct{}, len(domains))}
+	for _, domain := range domains {
+		res.patterns[normalize(domain)] = struct{}{}
+	}
+	return res
+}
+
+// CheckDomain checks domain is a name or *.name
+func CheckDomain(domain string) error {
+	pattern := normalize(domain)
+	if pattern == "" || pattern == "*" || strings.Contains(strings.TrimPrefix(pattern, "*."), "*") || strings.ContainsAny(pattern, ":/ ") {
+		return errors.New("invalid noise domain " + domain + ", expecting a name or *.name")
+	}
+	return nil
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+// Match tells if the name is a noise domain, the name itself or one of its parents with a wildcard
+func (m *Matcher) Match(name string) bool {
+	name = normalize(name)
+	if _, ok := m.patterns[name]; ok {
+		return true
+	}
+	for _, parent, found := strings.Cut(name, "."); found; _, parent, found = strings.Cut(parent, ".") {
+		if _, ok := m.patterns["*."+parent]; ok {
+			return true
+		}
+	}
+	return false
+}
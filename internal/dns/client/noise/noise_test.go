@@ -0,0 +1,39 @@
+package noise
+
+import (
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	m := NewMatcher(Domains)
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"captive.apple.com", true},
+		{"2.debian.pool.ntp.org.", true},
+		{"Pool.NTP.org", true},
+		{"example.com", false},
+		{"apple.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Match(tt.name); got != tt.want {
+				t.Errorf("Matcher.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	for _, domain := range Domains {
+		if err := CheckDomain(domain); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	for _, invalid := range []string{"", "*", "ads.*.example.com", "http://example.com"} {
+		if err := CheckDomain(invalid); err == nil {
+			t.Errorf("expecting an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package nxcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+var _ client.TypedClient = &NXCache{}
+var _ client.RequestClient = &NXCache{}
+var _ client.SetClient = &NXCache{}
+
+// NXCache remembers the recent NXDOMAIN answers of its delegate, keyed by the full question name,
+// so the same non existing name is not forwarded upstream more than once per ttl
+type NXCache struct {
+	delegate   client.Client
+	ttl        time.Duration
+	maxEntries int
+	lock       sync.RWMutex
+	entries    map[string]time.Time
+	clock      clock.Clock
+}
+
+// NewNXCache instantiate a new NXCache in front of the given client, the expired entries are purged every gcDelay
+func NewNXCache(ctx context.Context, wg *sync.WaitGroup, delegate client.Client, ttl time.Duration, maxEntries int, gcDelay time.Duration) *NXCache {
+	return NewNXCacheWithClock(ctx, wg, delegate, ttl, maxEntries, gcDelay, clock.Real{})
+}
+
+// NewNXCacheWithClock instantiate a new NXCache computing the expiries and scheduling the gc with the given clock
+func NewNXCacheWithClock(ctx context.Context, wg *sync.WaitGroup, delegate client.Client, ttl time.Duration, maxEntries int, gcDelay time.Duration, clk clock.Clock) *NXCache {
+	res := &NXCache{
+		delegate:   delegate,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		lock:       sync.RWMutex{},
+		entries:    make(map[string]time.Time),
+		clock:      clk,
+	}
+
+	wg.Add(1)
+	go gcScheduler(ctx, wg, res, clk.NewTicker(gcDelay))
+
+	return res
+}
+
+// ResolveV4 implements client.Client
+func (c *NXCache) ResolveV4(name string) (dto.Record, error) {
+	return c.resolve(name, c.delegate.ResolveV4)
+}
+
+// ResolveV6 implements client.Client
+func (c *NXCache) ResolveV6(name string) (dto.Record, error) {
+	return c.resolve(name, c.delegate.ResolveV6)
+}
+
+// Resolve implements client.TypedClient
+func (c *NXCache) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return c.resolve(name, func(name string) (dto.Record, error) {
+		return client.Resolve(c.delegate, name, t)
+	})
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *NXCache) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *NXCache) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	if c.contains(name) {
+		return nil, &client.NameError{Name: name}
+	}
+	records, err := client.ResolveSet(ctx, c.delegate, req, name, t)
+	c.remember(name, err)
+	return records, err
+}
+
+func (c *NXCache) resolve(name string, delegate func(string) (dto.Record, error)) (dto.Record, error) {
+	if c.contains(name) {
+		return dto.Record{}, &client.NameError{Name: name}
+	}
+	record, err := delegate(name)
+	c.remember(name, err)
+	return record, err
+}
+
+// remember keeps the name when err tells it does not exist
+func (c *NXCache) remember(name string, err error) {
+	var nameError *client.NameError
+	if errors.As(err, &nameError) {
+		c.put(name)
+	}
+}
+
+func (c *NXCache) contains(name string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	expiry, ok := c.entries[name]
+	return ok && c.clock.Now().Before(expiry)
+}
+
+func (c *NXCache) put(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		return // the cache is full, wait for the gc to free some places
+	}
+	c.entries[name] = c.clock.Now().Add(c.ttl)
+}
+
+func (c *NXCache) gc() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.clock.Now()
+	for name, expiry := range c.entries {
+		if expiry.Before(now) {
+			delete(c.entries, name)
+		}
+	}
+}
+
+// gcScheduler purges the cache on each tick, the ticker is created by the caller so no tick of a fake clock is missed
+func gcScheduler(ctx context.Context, wg *sync.WaitGroup, c *NXCache, ticker clock.Ticker) {
+	defer wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			c.gc()
+		}
+	}
+}
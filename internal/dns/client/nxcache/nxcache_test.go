@@ -0,0 +1,100 @@
+package nxcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+var _ client.Client = &mockClient{}
+
+type mockClient struct {
+	calls int
+}
+
+// ResolveV4 implements client.Client
+func (m *mockClient) ResolveV4(name string) (dto.Record, error) {
+	m.calls++
+	if name == "localhost" {
+		return dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 200, Data: net.ParseIP("127.0.0.1").To4()}, nil
+	}
+	return dto.Record{}, &client.NameError{Name: name}
+}
+
+// ResolveV6 implements client.Client
+func (m *mockClient) ResolveV6(name string) (dto.Record, error) {
+	m.calls++
+	return dto.Record{}, errors.New("unsupported")
+}
+
+func TestNXCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	mock := &mockClient{}
+	clk := clock.NewFake(time.Now())
+	c := NewNXCacheWithClock(ctx, wg, mock, 200*time.Millisecond, 10, 50*time.Millisecond, clk)
+
+	for i := 0; i < 5; i++ {
+		_, err := c.ResolveV4("nxdomain.lan")
+		var nameError *client.NameError
+		if !errors.As(err, &nameError) {
+			t.Fatalf("expecting a NameError, got %v", err)
+		}
+	}
+	if mock.calls != 1 {
+		t.Fatalf("expecting 1 upstream call, got %d", mock.calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ResolveV4("localhost"); err != nil {
+			t.Fatalf("error resolving localhost %v", err)
+		}
+		if _, err := c.ResolveV6("localhost"); err == nil {
+			t.Fatalf("expecting an error resolving localhost in v6")
+		}
+	}
+	if mock.calls != 5 {
+		t.Fatalf("only NXDOMAIN answers should be cached, got %d upstream calls", mock.calls)
+	}
+
+	clk.Advance(300 * time.Millisecond)
+
+	_, _ = c.ResolveV4("nxdomain.lan")
+	if mock.calls != 6 {
+		t.Fatalf("expired entry should be forwarded upstream, got %d upstream calls", mock.calls)
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestNXCache_GC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	clk := clock.NewFake(time.Now())
+	c := NewNXCacheWithClock(ctx, wg, &mockClient{}, time.Minute, 10, time.Second, clk)
+
+	_, _ = c.ResolveV4("nxdomain.lan")
+	clk.Advance(30 * time.Second)
+	_, _ = c.ResolveV4("other.lan")
+	// the last tick is delivered once the gc of the previous one is done
+	clk.Advance(40 * time.Second)
+
+	c.lock.RLock()
+	_, expired := c.entries["nxdomain.lan"]
+	_, valid := c.entries["other.lan"]
+	c.lock.RUnlock()
+	if expired || !valid {
+		t.Errorf("the gc should only remove the expired entries, got %v", c.entries)
+	}
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,149 @@
+// Package offline answers the questions the local sources cannot answer when the external resolution is not allowed,
+// so the server stays authoritative for its local names without ever asking an upstream. The external resolution
+// can also be switched off while the server runs, for the captive or air-gapped networks
+package offline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.TypedClient = &OfflineClient{}
+var _ client.SetClient = &SwitchedClient{}
+
+// ErrOffline is the failure of the questions the server cannot answer without the external resolution
+var ErrOffline = errors.New("the external resolution is disabled")
+
+// Answer is the answer to the questions about the names which are not local
+type Answer int
+
+const (
+	// Refused answers REFUSED, the clients can ask another server
+	Refused Answer = iota
+	// NXDomain answers the name does not exist
+	NXDomain
+	// ServFail answers SERVFAIL, the clients retry later
+	ServFail
+	// Stale answers the expired records of the cache, which keeps them, and SERVFAIL without record
+	Stale
+)
+
+var answerNames = []string{Refused: "refused", NXDomain: "nxdomain", ServFail: "servfail", Stale: "stale"}
+
+// String returns the name of the answer
+func (a Answer) String() string {
+	return answerNames[a]
+}
+
+// Error returns the error answering the question about the name
+func (a Answer) Error(name string) error {
+	switch a {
+	case Refused:
+		return &client.RefusedError{Name: name}
+	case NXDomain:
+		// not cached by the clients, the name may be added to the local sources
+		return &client.NameError{Name: name}
+	}
+	return ErrOffline
+}
+
+// ParseAnswer returns the answer of its name, refused when empty
+func ParseAnswer(name string) (Answer, error) {
+	if name == "" {
+		return Refused, nil
+	}
+	for answer, answerName := range answerNames {
+		if name == answerName {
+			return Answer(answer), nil
+		}
+	}
+	return Refused, errors.New("unknown offline answer " + name + ", expecting refused, nxdomain, servfail or stale")
+}
+
+// Switch turns the external resolution off and on while the server runs, it is safe for concurrent use.
+// A nil Switch is never offline
+type Switch struct {
+	offline atomic.Bool
+}
+
+// NewSwitch instantiates a switch, offline when enabled
+func NewSwitch(enabled bool) *Switch {
+	res := &Switch{}
+	res.offline.Store(enabled)
+	return res
+}
+
+// Enabled tells whether the external resolution is off
+func (s *Switch) Enabled() bool {
+	return s != nil && s.offline.Load()
+}
+
+// Set turns the external resolution off when enabled, on otherwise
+func (s *Switch) Set(enabled bool) {
+	s.offline.Store(enabled)
+}
+
+// SwitchedClient fails with ErrOffline without asking its delegate while its switch is offline, like the prefetch
+// of the cache
+type SwitchedClient struct {
+	delegate client.Client
+	offline  *Switch
+}
+
+// NewSwitchedClient instantiates a client asking delegate while the switch is online
+func NewSwitchedClient(delegate client.Client, s *Switch) *SwitchedClient {
+	return &SwitchedClient{delegate: delegate, offline: s}
+}
+
+// ResolveV4 implements client.Client
+func (c *SwitchedClient) ResolveV4(name string) (dto.Record, error) {
+	return client.First(c.ResolveSet(context.Background(), request.Request{}, name, dto.A))
+}
+
+// ResolveV6 implements client.Client
+func (c *SwitchedClient) ResolveV6(name string) (dto.Record, error) {
+	return client.First(c.ResolveSet(context.Background(), request.Request{}, name, dto.AAAA))
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *SwitchedClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *SwitchedClient) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	if c.offline.Enabled() {
+		return nil, ErrOffline
+	}
+	return client.ResolveSet(ctx, c.delegate, req, name, t)
+}
+
+// OfflineClient never resolves a name, it answers the negative answer of its configuration
+type OfflineClient struct {
+	answer Answer
+}
+
+// NewOfflineClient instantiates a client answering answer to every question
+func NewOfflineClient(answer Answer) *OfflineClient {
+	return &OfflineClient{answer: answer}
+}
+
+// Resolve implements client.TypedClient
+func (c *OfflineClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return dto.Record{}, c.answer.Error(name)
+}
+
+// ResolveV4 implements client.Client
+func (c *OfflineClient) ResolveV4(name string) (dto.Record, error) {
+	return c.Resolve(name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *OfflineClient) ResolveV6(name string) (dto.Record, error) {
+	return c.Resolve(name, dto.AAAA)
+}
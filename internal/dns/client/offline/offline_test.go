@@ -0,0 +1,47 @@
+package offline
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestOfflineClient_Resolve(t *testing.T) {
+	var refused *client.RefusedError
+	if _, err := NewOfflineClient(Refused).ResolveV4("example.com"); !errors.As(err, &refused) {
+		t.Errorf("expecting a refusal, got %v", err)
+	}
+	var nameError *client.NameError
+	if _, err := NewOfflineClient(NXDomain).Resolve("example.com", dto.MX); !errors.As(err, &nameError) {
+		t.Errorf("expecting a name error, got %v", err)
+	}
+}
+
+func TestSwitchedClient(t *testing.T) {
+	s := NewSwitch(false)
+	c := NewSwitchedClient(NewOfflineClient(NXDomain), s)
+	var nameError *client.NameError
+	if _, err := c.ResolveV4("example.com"); !errors.As(err, &nameError) {
+		t.Errorf("expecting the answer of the delegate, got %v", err)
+	}
+	s.Set(true)
+	if _, err := c.ResolveV6("example.com"); err != ErrOffline {
+		t.Errorf("expecting %v while offline, got %v", ErrOffline, err)
+	}
+	if (*Switch)(nil).Enabled() {
+		t.Errorf("a nil switch is never offline")
+	}
+}
+
+func TestParseAnswer(t *testing.T) {
+	for name, want := range map[string]Answer{"": Refused, "refused": Refused, "nxdomain": NXDomain, "servfail": ServFail, "stale": Stale} {
+		if got, err := ParseAnswer(name); err != nil || got != want {
+			t.Errorf("ParseAnswer(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseAnswer("drop"); err == nil {
+		t.Errorf("expecting an error for an unknown answer")
+	}
+}
@@ -0,0 +1,140 @@
+package override
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.TypedClient = &Overrides{}
+var _ client.SetClient = &Overrides{}
+
+// defaultTTL is the ttl of the answers of the groups without ttl
+const defaultTTL = 200
+
+// Group is a response group: a fixed set of addresses answered for its domains instead of the upstream answers
+type Group struct {
+	Name string
+	TTL  uint32
+	v4   []net.IP
+	v6   []net.IP
+	// patterns are the lower case domains, *.name for the subdomains of name
+	patterns []string
+}
+
+// NewGroup returns the group answering the addresses for the domains, *.name for the subdomains of name.
+// A domain has no record of the family without address in the group, so the upstream cannot leak it
+func NewGroup(name string, domains, addresses []string, ttl uint32) (Group, error) {
+	if len(domains) == 0 || len(addresses) == 0 {
+		return Group{}, errors.New("response group " + name + " needs domains and addresses")
+	}
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	g := Group{Name: name, TTL: ttl}
+	for _, domain := range domains {
+		pattern := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if pattern == "" || pattern == "*" || strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+			return Group{}, errors.New("invalid domain " + domain + " in response group " + name)
+		}
+		g.patterns = append(g.patterns, pattern)
+	}
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		switch {
+		case ip == nil:
+			return Group{}, errors.New("invalid address " + address + " in response group " + name)
+		case ip.To4() != nil:
+			g.v4 = append(g.v4, ip.To4())
+		default:
+			g.v6 = append(g.v6, ip)
+		}
+	}
+	return g, nil
+}
+
+// Overrides answers the questions about the domains of its groups, the other questions fail so the next resolvers answer them
+type Overrides struct {
+	groups map[string]*Group
+}
+
+// NewOverrides instantiates Overrides without group, the groups must be added before using it
+func NewOverrides() *Overrides {
+	return &Overrides{groups: make(map[string]*Group)}
+}
+
+// Add adds the group, its domains are overridden by the last group added with them
+func (o *Overrides) Add(g Group) {
+	for _, pattern := range g.patterns {
+		o.groups[pattern] = &g
+	}
+}
+
+// ResolveV4 implements client.Client
+func (o *Overrides) ResolveV4(name string) (dto.Record, error) {
+	return o.Resolve(name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (o *Overrides) ResolveV6(name string) (dto.Record, error) {
+	return o.Resolve(name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient, the first address of the group is answered
+func (o *Overrides) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.First(o.resolveSet(name, t))
+}
+
+// ResolveRequest implements client.RequestClient
+func (o *Overrides) ResolveRequest(_ context.Context, _ request.Request, name string, t dto.Type) (dto.Record, error) {
+	return o.Resolve(name, t)
+}
+
+// ResolveSet implements client.SetClient
+// The A and AAAA questions about a domain of a group are answered with all its addresses, or NODATA without address of the family
+func (o *Overrides) ResolveSet(_ context.Context, _ request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	return o.resolveSet(name, t)
+}
+
+func (o *Overrides) resolveSet(name string, t dto.Type) ([]dto.Record, error) {
+	g := o.match(strings.ToLower(strings.TrimSuffix(name, ".")))
+	if g == nil || (t != dto.A && t != dto.AAAA) {
+		return nil, client.NotFound(name + " is not overridden for " + t.String())
+	}
+	addresses := g.v4
+	if t == dto.AAAA {
+		addresses = g.v6
+	}
+	if len(addresses) == 0 {
+		return nil, &client.NoDataError{Name: name, Type: t, TTL: g.TTL}
+	}
+	records := make([]dto.Record, 0, len(addresses))
+	for _, address := range addresses {
+		records = append(records, dto.Record{
+			Name:  name,
+			Type:  t,
+			Class: dto.IN,
+			TTL:   g.TTL,
+			Data:  address,
+		})
+	}
+	return records, nil
+}
+
+// match returns the group of the lower case name, the name itself first then the closest parent with a wildcard
+func (o *Overrides) match(name string) *Group {
+	if g, ok := o.groups[name]; ok {
+		return g
+	}
+	for _, parent, found := strings.Cut(name, "."); found; _, parent, found = strings.Cut(parent, ".") {
+		if g, ok := o.groups["*."+parent]; ok {
+			return g
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package override
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+func TestOverrides_Resolve(t *testing.T) {
+	o := NewOverrides()
+	lan, err := NewGroup("lan", []string{"nas.example.com", "*.home.example.com."}, []string{"192.168.1.10", "fd00::10"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Add(lan)
+	v4Only, err := NewGroup("v4 only", []string{"camera.home.example.com"}, []string{"192.168.1.20"}, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Add(v4Only)
+
+	tests := []struct {
+		name       string
+		t          dto.Type
+		want       string
+		wantTTL    uint32
+		wantNoData bool
+		wantErr    bool
+	}{
+		{name: "nas.example.com", t: dto.A, want: "192.168.1.10", wantTTL: defaultTTL},
+		{name: "NAS.example.com.", t: dto.AAAA, want: "fd00::10", wantTTL: defaultTTL},
+		{name: "printer.home.example.com", t: dto.A, want: "192.168.1.10", wantTTL: defaultTTL},
+		{name: "camera.home.example.com", t: dto.A, want: "192.168.1.20", wantTTL: 60},
+		{name: "camera.home.example.com", t: dto.AAAA, wantNoData: true, wantErr: true},
+		{name: "home.example.com", t: dto.A, wantErr: true},
+		{name: "www.example.com", t: dto.A, wantErr: true},
+		{name: "nas.example.com", t: dto.MX, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.t.String(), func(t *testing.T) {
+			record, err := o.Resolve(tt.name, tt.t)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Overrides.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var noData *client.NoDataError
+			if errors.As(err, &noData) != tt.wantNoData {
+				t.Errorf("Overrides.Resolve() error = %v, want NODATA %v", err, tt.wantNoData)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !record.Data.Equal(net.ParseIP(tt.want)) || record.TTL != tt.wantTTL {
+				t.Errorf("Overrides.Resolve() = %v ttl %d, want %s ttl %d", record.Data, record.TTL, tt.want, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestOverrides_ResolveSet(t *testing.T) {
+	o := NewOverrides()
+	pool, err := NewGroup("pool", []string{"pool.example.com"}, []string{"192.168.1.10", "192.168.1.11", "fd00::10"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Add(pool)
+	records, err := o.ResolveSet(context.Background(), request.Request{}, "pool.example.com", dto.A)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !records[0].Data.Equal(net.ParseIP("192.168.1.10")) || !records[1].Data.Equal(net.ParseIP("192.168.1.11")) {
+		t.Errorf("expecting both IPv4 addresses of the group, got %v", records)
+	}
+	records, err = o.ResolveSet(context.Background(), request.Request{}, "pool.example.com", dto.AAAA)
+	if err != nil || len(records) != 1 {
+		t.Errorf("expecting the IPv6 address of the group, got %v, %v", records, err)
+	}
+}
+
+func TestNewGroup(t *testing.T) {
+	tests := []struct {
+		name      string
+		domains   []string
+		addresses []string
+	}{
+		{name: "no domain", addresses: []string{"10.0.0.1"}},
+		{name: "no address", domains: []string{"nas.lan"}},
+		{name: "bad address", domains: []string{"nas.lan"}, addresses: []string{"nas"}},
+		{name: "bad wildcard", domains: []string{"*"}, addresses: []string{"10.0.0.1"}},
+		{name: "inner wildcard", domains: []string{"a.*.lan"}, addresses: []string{"10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewGroup(tt.name, tt.domains, tt.addresses, 0); err == nil {
+				t.Errorf("NewGroup() expecting an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Package pause suspends the blocking for a few minutes, for every client or for one of them, to find out whether a blocked
+// name breaks a site. The blocking resumes by itself at the end of the pause
+package pause
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+var _ client.RequestClient = &Client{}
+
+// MaxDuration is the longest pause, a forgotten pause must not leave the network unprotected
+const MaxDuration = 24 * time.Hour
+
+// Pause is a suspension of the blocking
+type Pause struct {
+	// Client is the address of the paused client, empty when every client is paused
+	Client string    `json:"client,omitempty"`
+	Until  time.Time `json:"until"`
+}
+
+// Switch holds the pauses of the blocking, it is safe for concurrent use. A nil Switch never pauses the blocking
+type Switch struct {
+	lock sync.RWMutex
+	// pauses are the ends of the pauses by client, the empty client pauses every client
+	pauses map[string]time.Time
+	clock  clock.Clock
+}
+
+// NewSwitch instantiates a switch without pause, the ends of the pauses are computed with c
+func NewSwitch(c clock.Clock) *Switch {
+	return &Switch{pauses: make(map[string]time.Time), clock: c}
+}
+
+// Pause suspends the blocking for the client during d, for every client when client is empty.
+// A pause in progress is replaced
+func (s *Switch) Pause(clientAddress string, d time.Duration) (Pause, error) {
+	if d <= 0 || d > MaxDuration {
+		return Pause{}, errors.New("invalid duration of the pause " + d.String() + ", expecting at most " + MaxDuration.String())
+	}
+	key, err := keyOf(clientAddress)
+	if err != nil {
+		return Pause{}, err
+	}
+	now := s.clock.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.prune(now)
+	s.pauses[key] = now.Add(d)
+	return Pause{Client: key, Until: s.pauses[key]}, nil
+}
+
+// Resume ends the pause of the client, of every client when client is empty, it tells if the pause was in progress.
+// The pauses of the clients are independent of the one of every client
+func (s *Switch) Resume(clientAddress string) (bool, error) {
+	key, err := keyOf(clientAddress)
+	if err != nil {
+		return false, err
+	}
+	now := s.clock.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.prune(now)
+	_, ok := s.pauses[key]
+	delete(s.pauses, key)
+	return ok, nil
+}
+
+// Pauses returns the pauses in progress, the one of every client first then the clients in the order of their addresses
+func (s *Switch) Pauses() []Pause {
+	if s == nil {
+		return nil
+	}
+	now := s.clock.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.prune(now)
+	res := make([]Pause, 0, len(s.pauses))
+	for key, until := range s.pauses {
+		res = append(res, Pause{Client: key, Until: until})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Client < res[j].Client })
+	return res
+}
+
+// Paused tells if the blocking is paused for the client, only the pause of every client applies to an unknown client
+func (s *Switch) Paused(clientAddress net.IP) bool {
+	if s == nil {
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.pauses) == 0 {
+		return false
+	}
+	now := s.clock.Now()
+	if until, ok := s.pauses[""]; ok && now.Before(until) {
+		return true
+	}
+	if clientAddress == nil {
+		return false
+	}
+	until, ok := s.pauses[clientAddress.String()]
+	return ok && now.Before(until)
+}
+
+// prune removes the pauses over at now, the lock must be held
+func (s *Switch) prune(now time.Time) {
+	for key, until := range s.pauses {
+		if !now.Before(until) {
+			delete(s.pauses, key)
+		}
+	}
+}
+
+// keyOf returns the key of the pauses of the client, the normalized address or empty for every client
+func keyOf(clientAddress string) (string, error) {
+	if clientAddress == "" {
+		return "", nil
+	}
+	ip := net.ParseIP(clientAddress)
+	if ip == nil {
+		return "", errors.New("invalid client address " + clientAddress)
+	}
+	return ip.String(), nil
+}
+
+// Client asks the questions to its delegate, the blocking client, unless the blocking is paused for the client of the question
+type Client struct {
+	delegate client.Client
+	pause    *Switch
+}
+
+// NewClient returns the client skipping delegate while the blocking is paused by s
+func NewClient(delegate client.Client, s *Switch) *Client {
+	return &Client{delegate: delegate, pause: s}
+}
+
+// ResolveV4 implements client.Client, without client only the pause of every client applies
+func (c *Client) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client, without client only the pause of every client applies
+func (c *Client) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *Client) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	if c.pause.Paused(req.Client) {
+		return dto.Record{}, client.NotFound("blocking paused")
+	}
+	return client.ResolveRequest(ctx, c.delegate, req, name, t)
+}
@@ -0,0 +1,82 @@
+package pause
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestSwitch(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))
+	s := NewSwitch(clk)
+	laptop, phone := net.ParseIP("192.168.1.20"), net.ParseIP("192.168.1.21")
+
+	if _, err := s.Pause("laptop", time.Minute); err == nil {
+		t.Errorf("expecting an error for an invalid address")
+	}
+	if _, err := s.Pause("", 2*MaxDuration); err == nil {
+		t.Errorf("expecting an error for a pause longer than %v", MaxDuration)
+	}
+	pause, err := s.Pause("192.168.1.20", 10*time.Minute)
+	if err != nil || pause.Client != "192.168.1.20" || !pause.Until.Equal(clk.Now().Add(10*time.Minute)) {
+		t.Fatalf("Pause() = %+v, %v", pause, err)
+	}
+	if !s.Paused(laptop) || s.Paused(phone) || s.Paused(nil) {
+		t.Errorf("expecting only the blocking of the laptop to be paused")
+	}
+
+	clk.Advance(5 * time.Minute)
+	_, _ = s.Pause("", time.Minute)
+	if !s.Paused(phone) || !s.Paused(nil) {
+		t.Errorf("expecting the blocking of every client to be paused")
+	}
+	if pauses := s.Pauses(); len(pauses) != 2 || pauses[0].Client != "" || pauses[1].Client != "192.168.1.20" {
+		t.Errorf("Pauses() = %+v", pauses)
+	}
+
+	clk.Advance(time.Minute)
+	if s.Paused(phone) || !s.Paused(laptop) {
+		t.Errorf("expecting the blocking of every client to resume at the end of the pause")
+	}
+	if resumed, err := s.Resume("192.168.1.20"); !resumed || err != nil || s.Paused(laptop) {
+		t.Errorf("expecting the pause of the laptop to end, got %v, %v", resumed, err)
+	}
+	if pauses := s.Pauses(); len(pauses) != 0 {
+		t.Errorf("expecting no pause left, got %+v", pauses)
+	}
+	if (*Switch)(nil).Paused(laptop) {
+		t.Errorf("expecting a nil switch never to pause the blocking")
+	}
+}
+
+func TestClient(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	b := blocker.NewBlocker(1)
+	_ = b.AddRule("ads.com")
+	s := NewSwitch(clk)
+	c := NewClient(b, s)
+	req := request.Request{Client: net.ParseIP("192.168.1.20")}
+
+	if _, err := c.ResolveRequest(context.Background(), req, "ads.com", dto.A); err != nil {
+		t.Fatalf("expecting ads.com to be blocked, got %v", err)
+	}
+	_, _ = s.Pause("192.168.1.20", 15*time.Minute)
+	if _, err := c.ResolveRequest(context.Background(), req, "ads.com", dto.A); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expecting the question to be left to the next resolvers during the pause, got %v", err)
+	}
+	if _, err := c.ResolveV4("ads.com"); err != nil {
+		t.Errorf("expecting the other clients to be blocked, got %v", err)
+	}
+	clk.Advance(15 * time.Minute)
+	if _, err := c.ResolveRequest(context.Background(), req, "ads.com", dto.A); err != nil {
+		t.Errorf("expecting the blocking to resume after the pause, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package recursive
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
+)
+
+const (
+	defaultPort  = "53"
+	queryTimeout = 2 * time.Second
+	// maximum number of referrals followed to answer a question
+	maxReferrals = 16
+	// maximum nesting of the resolutions of CNAME targets and of nameservers without glue
+	maxDepth = 8
+	// maximum number of delegations remembered
+	maxZones = 10000
+	// header of an iterative query, recursion is not desired
+	iterativeQuery uint16 = 0x0000
+)
+
+var _ client.TypedClient = &RecursiveClient{}
+var _ client.SetClient = &RecursiveClient{}
+
+// delegation is the set of nameservers of a zone
+type delegation struct {
+	servers []string
+	expiry  time.Time
+}
+
+// RecursiveClient resolves the questions itself, starting from the root servers and following the referrals
+// down to the authoritative servers, without trusting any upstream resolver.
+// The delegations are cached for the ttl of their NS records, the answers are cached by the chain
+type RecursiveClient struct {
+	// zone is the zone served by the hints, the root for a full recursion
+	zone    string
+	hints   []string // addresses (host:port) of the servers of the zone
+	port    string   // port of the nameservers found in the referrals
+	lock    sync.RWMutex
+	zones   map[string]delegation
+	priming atomic.Bool
+	clock   clock.Clock
+}
+
+// NewRecursiveClient instantiate a new RecursiveClient using the root hints
+func NewRecursiveClient() *RecursiveClient {
+	return newRecursiveClient("", rootServers, defaultPort)
+}
+
+func newRecursiveClient(zone string, hints []string, port string) *RecursiveClient {
+	addresses := make([]string, 0, len(hints))
+	for _, hint := range hints {
+		if _, _, err := net.SplitHostPort(hint); err != nil {
+			hint = net.JoinHostPort(hint, port)
+		}
+		addresses = append(addresses, hint)
+	}
+	return &RecursiveClient{
+		zone:  normalize(zone),
+		hints: addresses,
+		port:  port,
+		zones: make(map[string]delegation),
+		clock: clock.Real{},
+	}
+}
+
+// ResolveV4 implements client.Client
+func (c *RecursiveClient) ResolveV4(name string) (dto.Record, error) {
+	return c.Resolve(name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *RecursiveClient) ResolveV6(name string) (dto.Record, error) {
+	return c.Resolve(name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient
+func (c *RecursiveClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(context.Background(), request.Request{}, name, t))
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *RecursiveClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient, the records at the end of the CNAME chain are answered for name
+func (c *RecursiveClient) ResolveSet(_ context.Context, _ request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	records, err := c.resolve(normalize(name), t, 0)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.Record, len(records))
+	for i, record := range records {
+		record.Name = name
+		res[i] = record
+	}
+	return res, nil
+}
+
+// resolve returns the records of type t of name, the ones at the end of its CNAME chain
+func (c *RecursiveClient) resolve(name string, t dto.Type, depth int) ([]dto.Record, error) {
+	if depth > maxDepth {
+		return nil, errors.New("too many indirections resolving " + name)
+	}
+	zone, servers := c.closestDelegation(name)
+	servers = c.primedDelegation(zone, servers)
+	for i := 0; i < maxReferrals; i++ {
+		response, err := c.query(servers, dto.Question{Name: name, Type: t, Class: dto.IN})
+		if err != nil {
+			return nil, err
+		}
+		switch response.Header & dto.RCODE_MASK {
+		case 0:
+		case dto.NAME_ERROR:
+			return nil, &client.NameError{Name: name, TTL: dto.NegativeTTL(response)}
+		default:
+			return nil, errors.New("server failure resolving " + name)
+		}
+		records, owner := answers(response, name, t, zone)
+		if len(records) > 0 {
+			return records, nil
+		}
+		if owner != name {
+			// the end of the chain is out of the response, or out of the zone of the servers, it is asked to its own servers
+			return c.resolve(owner, t, depth+1)
+		}
+		next, nextServers, err := c.referral(response, name, t, zone, depth)
+		if err != nil {
+			return nil, err
+		}
+		zone, servers = next, nextServers
+	}
+	return nil, errors.New("too many referrals resolving " + name)
+}
+
+// answers returns the records of type t of the response owned by name, or by the targets of its CNAME chain, with
+// the last owner of the chain. Against the cache poisoning, the records of other owners are ignored, and the chain
+// is only followed in the response while it stays in the zone of the servers asked
+func answers(response *dto.Message, name string, t dto.Type, zone string) ([]dto.Record, string) {
+	owner := name
+	for i := 0; i <= maxDepth; i++ {
+		var res []dto.Record
+		alias := ""
+		for _, record := range response.Response {
+			if normalize(record.Name) != owner {
+				continue
+			}
+			switch record.Type {
+			case t:
+				res = append(res, record)
+			case dto.CNAME:
+				alias = normalize(record.Value())
+			}
+		}
+		if len(res) > 0 || alias == "" {
+			return res, owner
+		}
+		owner = alias
+		if !inZone(owner, zone) {
+			return nil, owner
+		}
+	}
+	return nil, owner
+}
+
+// referral reads the delegation to a zone closer to name than the current zone, and remembers it
+// a response which is neither an answer nor a referral means the name has no record of the type
+func (c *RecursiveClient) referral(response *dto.Message, name string, t dto.Type, zone string, depth int) (string, []string, error) {
+	next := ""
+	ttl := uint32(0)
+	targets := make([]string, 0, 4)
+	for _, record := range response.Authority {
+		owner := normalize(record.Name)
+		if record.Type != dto.NS || len(owner) <= len(zone) || !inZone(owner, zone) || !inZone(name, owner) {
+			continue
+		}
+		if next != "" && owner != next {
+			continue
+		}
+		next = owner
+		if ttl == 0 || record.TTL < ttl {
+			ttl = record.TTL
+		}
+		targets = append(targets, normalize(record.Value()))
+	}
+	if next == "" {
+		return "", nil, &client.NoDataError{Name: name, Type: t, TTL: dto.NegativeTTL(response)}
+	}
+
+	servers := make([]string, 0, len(targets))
+	for _, record := range response.Additional {
+		// the glue out of the delegated zone is not the authority of the servers asked, it is dropped
+		owner := normalize(record.Name)
+		if record.Type == dto.A && inZone(owner, next) && slices.Contains(targets, owner) {
+			servers = append(servers, net.JoinHostPort(record.Data.String(), c.port))
+		}
+	}
+	if len(servers) == 0 {
+		// no glue, the addresses of the nameservers are resolved separately
+		for _, target := range targets {
+			if records, err := c.resolve(target, dto.A, depth+1); err == nil {
+				servers = append(servers, net.JoinHostPort(records[0].Data.String(), c.port))
+				break
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return "", nil, errors.New("no reachable nameserver for " + next)
+	}
+	c.remember(next, servers, ttl)
+	return next, servers, nil
+}
+
+// closestDelegation returns the known zone the closest to name with its nameservers, the zone of the hints at worst
+func (c *RecursiveClient) closestDelegation(name string) (string, []string) {
+	now := c.clock.Now()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for zone := name; zone != "" && inZone(zone, c.zone); {
+		if d, ok := c.zones[zone]; ok && now.Before(d.expiry) {
+			return zone, d.servers
+		}
+		_, parent, _ := strings.Cut(zone, ".")
+		zone = parent
+	}
+	return c.zone, c.hints
+}
+
+func (c *RecursiveClient) remember(zone string, servers []string, ttl uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.clock.Now()
+	if len(c.zones) >= maxZones {
+		for k, d := range c.zones {
+			if now.After(d.expiry) {
+				delete(c.zones, k)
+			}
+		}
+		if len(c.zones) >= maxZones {
+			return
+		}
+	}
+	c.zones[zone] = delegation{servers: servers, expiry: now.Add(time.Duration(ttl) * time.Second)}
+}
+
+// query asks the question to the servers, starting at a random one, until one of them answers.
+// Against the spoofed responses, every query has a random id and is sent from a fresh socket, on a random port
+// chosen by the system, and only the responses echoing the id and the question are accepted
+func (c *RecursiveClient) query(servers []string, question dto.Question) (*dto.Message, error) {
+	message := dto.Message{
+		ID:            randomID(),
+		Header:        iterativeQuery,
+		QuestionCount: 1,
+		Question:      []dto.Question{question},
+	}
+	payload := dto.SerializeMessage(message)
+
+	var err error
+	start := rand.Intn(len(servers))
+	for i := range servers {
+		var response *dto.Message
+		if response, err = exchange(servers[(start+i)%len(servers)], payload, message); err == nil {
+			return response, nil
+		}
+	}
+	return nil, client.WrapTimeout(err)
+}
+
+// exchange sends the query over udp, and over tcp when the answer is truncated
+func exchange(address string, payload []byte, query dto.Message) (*dto.Message, error) {
+	conn, err := net.DialTimeout("udp", address, queryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(queryTimeout))
+	if _, err := conn.Write(payload); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, dto.UDPMaxLength)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	response, err := dto.ParseMessage(buffer[:n])
+	if err != nil {
+		return nil, err
+	}
+	if !matches(query, response) {
+		return nil, errors.New("the response does not match the query")
+	}
+	if response.Header&dto.TRUNCATED != 0 {
+		return exchangeTCP(address, payload, query)
+	}
+	return response, nil
+}
+
+func exchangeTCP(address string, payload []byte, query dto.Message) (*dto.Message, error) {
+	conn, err := net.DialTimeout("tcp", address, queryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(queryTimeout))
+	if err := framing.Write(conn, payload); err != nil {
+		return nil, err
+	}
+	data, err := framing.Read(conn)
+	if err != nil {
+		return nil, err
+	}
+	response, err := dto.ParseMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	if !matches(query, response) {
+		return nil, errors.New("the response does not match the query")
+	}
+	return response, nil
+}
+
+// randomID returns an unpredictable id for a query
+func randomID() uint16 {
+	var id [2]byte
+	_, _ = crand.Read(id[:])
+	return binary.BigEndian.Uint16(id[:])
+}
+
+// matches tells if the response answers the query: a response echoing its id and its question
+func matches(query dto.Message, response *dto.Message) bool {
+	if response.ID != query.ID || response.Header&0x8000 == 0 || len(response.Question) != 1 {
+		return false
+	}
+	asked, echoed := query.Question[0], response.Question[0]
+	return normalize(echoed.Name) == normalize(asked.Name) && echoed.Type == asked.Type && echoed.Class == asked.Class
+}
+
+// inZone tells if name is zone or one of its subdomains
+func inZone(name, zone string) bool {
+	return zone == "" || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,199 @@
+package recursive
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+const testPort = "12352"
+
+// zone answers the questions of a fake authoritative server
+type zone func(question dto.Question) dto.Message
+
+func serve(t *testing.T, ctx context.Context, ip string, z zone) {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, testPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		buffer := make([]byte, dto.UDPMaxLength)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query, err := dto.ParseMessage(buffer[:n])
+			if err != nil {
+				continue
+			}
+			response := z(query.Question[0])
+			response.ID = query.ID
+			response.QuestionCount = 1
+			response.Question = query.Question
+			response.ResponseCount = uint16(len(response.Response))
+			response.AuthorityCount = uint16(len(response.Authority))
+			response.AdditionalCount = uint16(len(response.Additional))
+			_, _ = conn.WriteTo(dto.SerializeMessage(response), addr)
+		}
+	}()
+}
+
+func ns(zone, target string) dto.Record {
+	raw, _ := dto.ParseRData(dto.NS, target)
+	return dto.Record{Name: zone, Type: dto.NS, Class: dto.IN, TTL: 3600, Raw: raw}
+}
+
+func a(name, ip string) dto.Record {
+	return dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 300, Data: net.ParseIP(ip).To4()}
+}
+
+func cname(name, target string) dto.Record {
+	raw, _ := dto.ParseRData(dto.CNAME, target)
+	return dto.Record{Name: name, Type: dto.CNAME, Class: dto.IN, TTL: 300, Raw: raw}
+}
+
+func TestRecursiveClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queries := atomic.Int32{}
+	// root, delegates lan with glue
+	serve(t, ctx, "127.0.0.1", func(q dto.Question) dto.Message {
+		queries.Add(1)
+		return dto.Message{
+			Header:     dto.STANDARD_RESPONSE,
+			Authority:  []dto.Record{ns("lan", "ns.lan")},
+			Additional: []dto.Record{a("ns.lan", "127.0.0.2")},
+		}
+	})
+	// lan, delegates example.lan without glue
+	serve(t, ctx, "127.0.0.2", func(q dto.Question) dto.Message {
+		switch q.Name {
+		case "ns1.lan":
+			return dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{a(q.Name, "127.0.0.3")}}
+		case "nxdomain.lan":
+			return dto.Message{Header: dto.STANDARD_RESPONSE | dto.NAME_ERROR}
+		case "target.lan":
+			return dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{a(q.Name, "10.0.0.3")}}
+		}
+		return dto.Message{Header: dto.STANDARD_RESPONSE, Authority: []dto.Record{ns("example.lan", "ns1.lan")}}
+	})
+	// example.lan, authoritative
+	serve(t, ctx, "127.0.0.3", func(q dto.Question) dto.Message {
+		switch q.Name {
+		case "www.example.lan":
+			return dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{cname(q.Name, "cdn.example.lan")}}
+		case "cdn.example.lan":
+			return dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{a(q.Name, "10.0.0.1")}}
+		case "pool.example.lan":
+			return dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{a(q.Name, "10.0.0.4"), a(q.Name, "10.0.0.5")}}
+		case "poisoned.example.lan":
+			return dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{a("target.lan", "6.6.6.6"), a(q.Name, "10.0.0.2")}}
+		case "alias.example.lan":
+			// the record of the target is out of the zone of the server, it is asked to the servers of lan
+			return dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{cname(q.Name, "target.lan"), a("target.lan", "6.6.6.6")}}
+		}
+		return dto.Message{Header: dto.STANDARD_RESPONSE | dto.NAME_ERROR}
+	})
+
+	c := newRecursiveClient("", []string{"127.0.0.1"}, testPort)
+
+	tests := []struct {
+		name      string
+		want      string
+		wantNXErr bool
+	}{
+		{name: "cdn.example.lan", want: "10.0.0.1"},
+		{name: "www.example.lan", want: "10.0.0.1"},
+		{name: "WWW.Example.lan.", want: "10.0.0.1"},
+		{name: "poisoned.example.lan", want: "10.0.0.2"},
+		{name: "alias.example.lan", want: "10.0.0.3"},
+		{name: "missing.example.lan", wantNXErr: true},
+		{name: "nxdomain.lan", wantNXErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := c.ResolveV4(tt.name)
+			if tt.wantNXErr {
+				var nameError *client.NameError
+				if !errors.As(err, &nameError) {
+					t.Fatalf("expecting a NameError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Name != tt.name || record.Data.String() != tt.want {
+				t.Errorf("ResolveV4() = %v, want %s", record, tt.want)
+			}
+		})
+	}
+
+	records, err := c.ResolveSet(ctx, request.Request{}, "pool.example.lan", dto.A)
+	if err != nil || len(records) != 2 || records[1].Data.String() != "10.0.0.5" {
+		t.Errorf("expecting both addresses of the pool, got %v, %v", records, err)
+	}
+
+	// the root is asked once for lan, then the delegation is remembered
+	if n := queries.Load(); n != 1 {
+		t.Errorf("expecting 1 query to the root, got %d", n)
+	}
+}
+
+func TestStubClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primerQueries := atomic.Int32{}
+	// configured server, only asked for the nameservers of the zone
+	serve(t, ctx, "127.0.0.4", func(q dto.Question) dto.Message {
+		primerQueries.Add(1)
+		if q.Name != "corp.lan" || q.Type != dto.NS {
+			return dto.Message{Header: dto.STANDARD_RESPONSE | dto.NAME_ERROR}
+		}
+		return dto.Message{
+			Header:     dto.STANDARD_RESPONSE,
+			Response:   []dto.Record{ns("corp.lan", "ns1.corp.lan")},
+			Additional: []dto.Record{a("ns1.corp.lan", "127.0.0.5")},
+		}
+	})
+	// authoritative server of corp.lan
+	serve(t, ctx, "127.0.0.5", func(q dto.Question) dto.Message {
+		if q.Name == "www.corp.lan" {
+			return dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{a(q.Name, "10.1.1.1")}}
+		}
+		return dto.Message{Header: dto.STANDARD_RESPONSE | dto.NAME_ERROR}
+	})
+
+	c := NewStubClient("corp.lan", []string{"127.0.0.4:" + testPort})
+	c.port = testPort
+
+	for i := 0; i < 3; i++ {
+		record, err := c.ResolveV4("www.corp.lan")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Data.String() != "10.1.1.1" {
+			t.Errorf("ResolveV4() = %v, want 10.1.1.1", record)
+		}
+	}
+	var nameError *client.NameError
+	if _, err := c.ResolveV4("missing.corp.lan"); !errors.As(err, &nameError) {
+		t.Errorf("expecting a NameError, got %v", err)
+	}
+	if n := primerQueries.Load(); n != 1 {
+		t.Errorf("expecting the configured server to be asked once, got %d", n)
+	}
+}
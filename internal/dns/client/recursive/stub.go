@@ -0,0 +1,82 @@
+package recursive
+
+import (
+	"errors"
+	"net"
+	"slices"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// retry delay of a failed priming, the configured servers are used meanwhile
+const failedPrimingTTL = 60
+
+// NewStubClient instantiate a RecursiveClient for a stub zone, the questions are asked directly to the
+// authoritative servers of the zone, found by asking its NS records to the given servers (ip or ip:port)
+func NewStubClient(zone string, servers []string) *RecursiveClient {
+	return newRecursiveClient(zone, servers, defaultPort)
+}
+
+// primedDelegation returns the nameservers of the zone of a stub client, priming them when unknown
+func (c *RecursiveClient) primedDelegation(zone string, servers []string) []string {
+	if c.zone == "" || zone != c.zone || c.known(zone) || !c.priming.CompareAndSwap(false, true) {
+		return servers
+	}
+	defer c.priming.Store(false)
+	primed, ttl, err := c.prime()
+	if err != nil {
+		c.remember(c.zone, c.hints, failedPrimingTTL)
+		return servers
+	}
+	c.remember(c.zone, primed, ttl)
+	return primed
+}
+
+// known tells if the nameservers of the zone are known
+func (c *RecursiveClient) known(zone string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	d, ok := c.zones[zone]
+	return ok && c.clock.Now().Before(d.expiry)
+}
+
+// prime asks the NS records of the zone to the configured servers and returns the addresses of the nameservers
+func (c *RecursiveClient) prime() ([]string, uint32, error) {
+	response, err := c.query(c.hints, dto.Question{Name: c.zone, Type: dto.NS, Class: dto.IN})
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl := uint32(0)
+	targets := make([]string, 0, 4)
+	for _, record := range response.Response {
+		if record.Type != dto.NS || normalize(record.Name) != c.zone {
+			continue
+		}
+		if ttl == 0 || record.TTL < ttl {
+			ttl = record.TTL
+		}
+		targets = append(targets, normalize(record.Value()))
+	}
+	servers := make([]string, 0, len(targets))
+	for _, record := range response.Additional {
+		if record.Type == dto.A && slices.Contains(targets, normalize(record.Name)) {
+			servers = append(servers, net.JoinHostPort(record.Data.String(), c.port))
+		}
+	}
+	for _, target := range targets {
+		if len(servers) > 0 {
+			break
+		}
+		// no glue, only the nameservers inside the zone can be resolved by the stub
+		if !inZone(target, c.zone) {
+			continue
+		}
+		if records, err := c.resolve(target, dto.A, 1); err == nil {
+			servers = append(servers, net.JoinHostPort(records[0].Data.String(), c.port))
+		}
+	}
+	if len(servers) == 0 {
+		return nil, 0, errors.New("no nameserver found for " + c.zone)
+	}
+	return servers, ttl, nil
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.TypedClient = &Router{}
+var _ client.RequestClient = &Router{}
+var _ client.SetClient = &Router{}
+
+// Router sends the questions to the client of the closest configured zone, and the other ones to the fallback client
+type Router struct {
+	fallback client.Client
+	zones    map[string]client.Client
+}
+
+// NewRouter instantiate a Router without zone, the zones must be added before using it
+func NewRouter(fallback client.Client) *Router {
+	return &Router{
+		fallback: fallback,
+		zones:    make(map[string]client.Client),
+	}
+}
+
+// Route sends the questions about the zone and its subdomains to the client
+func (r *Router) Route(zone string, c client.Client) {
+	r.zones[normalize(zone)] = c
+}
+
+// ResolveV4 implements client.Client
+func (r *Router) ResolveV4(name string) (dto.Record, error) {
+	return r.route(name).ResolveV4(name)
+}
+
+// ResolveV6 implements client.Client
+func (r *Router) ResolveV6(name string) (dto.Record, error) {
+	return r.route(name).ResolveV6(name)
+}
+
+// Resolve implements client.TypedClient
+func (r *Router) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.Resolve(r.route(name), name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (r *Router) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.ResolveRequest(ctx, r.route(name), req, name, t)
+}
+
+// ResolveSet implements client.SetClient
+func (r *Router) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	return client.ResolveSet(ctx, r.route(name), req, name, t)
+}
+
+func (r *Router) route(name string) client.Client {
+	for zone := normalize(name); zone != ""; {
+		if c, ok := r.zones[zone]; ok {
+			return c
+		}
+		_, parent, _ := strings.Cut(zone, ".")
+		zone = parent
+	}
+	return r.fallback
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
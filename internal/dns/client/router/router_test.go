@@ -0,0 +1,44 @@
+package router
+
+import (
+	"net"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+)
+
+func newClient(name, address string) client.Client {
+	c := &inmemoryclient.InMemoryClient{}
+	_ = c.Add(name, address)
+	return c
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter(newClient("example.com", "10.0.0.1"))
+	r.Route("corp.lan", newClient("www.corp.lan", "10.0.0.2"))
+	r.Route("dev.corp.lan.", newClient("www.dev.corp.lan", "10.0.0.3"))
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "example.com", want: "10.0.0.1"},
+		{name: "www.corp.lan", want: "10.0.0.2"},
+		{name: "www.dev.corp.lan", want: "10.0.0.3"},
+		{name: "example.com.corp.lan", wantErr: true},
+		{name: "www.corp.lan.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := r.ResolveV4(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !record.Data.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("ResolveV4() = %v, want %s", record.Data, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Package schedule blocks more names at some times of the week, like the social networks for the devices of the children
+// at night, for every client or for the clients of some groups
+package schedule
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/groups"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+var _ client.RequestClient = &Client{}
+
+// days are the names of the days of the week, in the order of time.Weekday
+var days = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Window is the time of the week a schedule is active, in the local time of the server
+type Window struct {
+	days [7]bool
+	// from and to are the times of the day the window opens and closes, it closes the next day when to is not after from
+	from, to time.Duration
+}
+
+// ParseWindow parses the days, mon to sun, weekdays or weekend, every day when empty, and the times of the day
+// from and to, like 21:00 and 07:00. A window across midnight opens on its days and closes the next day,
+// a window from a time to the same one lasts all the day
+func ParseWindow(names []string, from, to string) (Window, error) {
+	var res Window
+	var err error
+	if res.from, err = parseTime(from); err != nil {
+		return res, err
+	}
+	if res.to, err = parseTime(to); err != nil {
+		return res, err
+	}
+	if len(names) == 0 {
+		names = []string{"weekdays", "weekend"}
+	}
+	for _, name := range names {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "weekdays":
+			for d := time.Monday; d <= time.Friday; d++ {
+				res.days[d] = true
+			}
+		case "weekend":
+			res.days[time.Saturday], res.days[time.Sunday] = true, true
+		default:
+			i := slices.Index(days, name)
+			if i < 0 {
+				return res, errors.New("invalid day " + name + ", expecting mon to sun, weekdays or weekend")
+			}
+			res.days[i] = true
+		}
+	}
+	return res, nil
+}
+
+// parseTime parses a time of the day like 07:00
+func parseTime(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, errors.New("invalid time " + value + ", expecting hh:mm like 21:00")
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Active tells if the window is open at the time, in its location
+func (w Window) Active(t time.Time) bool {
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	today, yesterday := t.Weekday(), (t.Weekday()+6)%7
+	switch {
+	case w.from < w.to:
+		return w.days[today] && clock >= w.from && clock < w.to
+	case w.from == w.to:
+		return w.days[today]
+	default:
+		return (w.days[today] && clock >= w.from) || (w.days[yesterday] && clock < w.to)
+	}
+}
+
+// Schedule blocks the names of its blocker while its window is open
+type Schedule struct {
+	Name string
+	// Groups are the names of the client groups the schedule applies to, every client when empty
+	Groups  []string
+	Window  Window
+	Blocker *blocker.Blocker
+}
+
+// Client answers the blocking response for the names of the open schedules of the client, and asks the other ones
+// to its delegate
+type Client struct {
+	delegate  client.Client
+	groups    *groups.Groups
+	schedules []Schedule
+	clock     clock.Clock
+}
+
+// NewClient instantiates the client applying the schedules in front of delegate, the clients are matched to their group
+// by g, nil without client group
+func NewClient(delegate client.Client, g *groups.Groups, schedules []Schedule, c clock.Clock) *Client {
+	return &Client{delegate: delegate, groups: g, schedules: schedules, clock: c}
+}
+
+// ResolveV4 implements client.Client, without client only the schedules of every client apply
+func (c *Client) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client, without client only the schedules of every client apply
+func (c *Client) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *Client) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	now := c.clock.Now()
+	group := ""
+	if c.groups != nil {
+		if g, ok := c.groups.Match(req.Client, req.Zone); ok {
+			group = g.Name
+		}
+	}
+	for _, s := range c.schedules {
+		if len(s.Groups) > 0 && !slices.Contains(s.Groups, group) {
+			continue
+		}
+		if _, blocked := s.Blocker.Match(name); blocked && s.Window.Active(now) {
+			return client.Resolve(s.Blocker, name, t)
+		}
+	}
+	return client.ResolveRequest(ctx, c.delegate, req, name, t)
+}
@@ -0,0 +1,108 @@
+package schedule
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/groups"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestWindow_Active(t *testing.T) {
+	night, err := ParseWindow([]string{"weekdays"}, "21:00", "07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	school, _ := ParseWindow([]string{"mon", "Tue"}, "08:30", "16:00")
+	weekend, _ := ParseWindow([]string{"weekend"}, "00:00", "00:00")
+	// 2024-05-06 is a monday
+	at := func(date int, clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 5, date, t.Hour(), t.Minute(), 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		window Window
+		time   time.Time
+		want   bool
+	}{
+		{"monday evening", night, at(6, "21:00"), true},
+		{"monday before the night", night, at(6, "20:59"), false},
+		{"tuesday morning", night, at(7, "06:59"), true},
+		{"tuesday after the night", night, at(7, "07:00"), false},
+		{"saturday morning after friday", night, at(11, "06:00"), true},
+		{"saturday evening", night, at(11, "22:00"), false},
+		{"monday morning after sunday", night, at(13, "06:00"), false},
+		{"monday at school", school, at(6, "10:00"), true},
+		{"wednesday at school", school, at(8, "10:00"), false},
+		{"sunday all day", weekend, at(12, "23:59"), true},
+		{"friday", weekend, at(10, "12:00"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Active(tt.time); got != tt.want {
+				t.Errorf("Window.Active() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	for _, invalid := range []struct {
+		days     []string
+		from, to string
+	}{
+		{nil, "21h", "07:00"},
+		{nil, "21:00", "25:00"},
+		{[]string{"monday"}, "21:00", "07:00"},
+	} {
+		if _, err := ParseWindow(invalid.days, invalid.from, invalid.to); err == nil {
+			t.Errorf("expecting an error for %v", invalid)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	_, kidsNetwork, _ := net.ParseCIDR("192.168.1.0/28")
+	g := groups.NewGroups(blocker.NewBlocker(10), []groups.Group{{Name: "kids", Networks: []groups.Network{{Subnet: kidsNetwork}}, Blocker: blocker.NewBlocker(10)}})
+	social := blocker.NewBlocker(10)
+	_ = social.AddRule("*.tiktok.com")
+	games := blocker.NewBlocker(10)
+	_ = games.AddRule("games.com")
+	night, _ := ParseWindow(nil, "21:00", "07:00")
+	always, _ := ParseWindow(nil, "00:00", "00:00")
+	fake := clock.NewFake(time.Date(2024, 5, 6, 22, 0, 0, 0, time.Local))
+	c := NewClient(blocker.NewBlocker(10), g, []Schedule{
+		{Name: "night", Groups: []string{"kids"}, Window: night, Blocker: social},
+		{Name: "games", Window: always, Blocker: games},
+	}, fake)
+
+	tests := []struct {
+		client      string
+		name        string
+		wantBlocked bool
+	}{
+		{client: "192.168.1.5", name: "www.tiktok.com", wantBlocked: true},
+		{client: "192.168.1.5", name: "games.com", wantBlocked: true},
+		{client: "192.168.2.5", name: "www.tiktok.com", wantBlocked: false},
+		{client: "192.168.2.5", name: "games.com", wantBlocked: true},
+		{name: "www.tiktok.com", wantBlocked: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.client+" "+tt.name, func(t *testing.T) {
+			_, err := c.ResolveRequest(context.Background(), request.Request{Client: net.ParseIP(tt.client)}, tt.name, dto.A)
+			if (err == nil) != tt.wantBlocked {
+				t.Errorf("Client.ResolveRequest() error = %v, want blocked %v", err, tt.wantBlocked)
+			}
+		})
+	}
+	fake.Advance(10 * time.Hour)
+	if _, err := c.ResolveRequest(context.Background(), request.Request{Client: net.ParseIP("192.168.1.5")}, "www.tiktok.com", dto.A); err == nil {
+		t.Errorf("expecting the name to be resolved once the schedule is over")
+	}
+}
@@ -1,22 +1,33 @@
 package udp
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
-	"log"
-	"math"
 	"net"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/client/family"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
 )
 
-var _ client.Client = &UDPClient{}
+var logger = logging.Component("udp")
+
+var _ client.TypedClient = &UDPClient{}
+var _ client.RequestClient = &UDPClient{}
+var _ client.SetClient = &UDPClient{}
 
 var _ error = &NoResponse{}
 
+// NoResponse is returned when the response has records but none of the asked type, it is a client.ErrNotFound
 type NoResponse struct{}
 
 // Error implements error.
@@ -24,31 +35,63 @@ func (*NoResponse) Error() string {
 	return "no response found"
 }
 
+// Is tells the response is a client.ErrNotFound
+func (*NoResponse) Is(target error) bool {
+	return target == client.ErrNotFound
+}
+
+const (
+	// DefaultTimeout is the time a response is waited for, DefaultRetries the number of times the query is sent again
+	DefaultTimeout = 2 * time.Second
+	DefaultRetries = 1
+)
+
+// UDPClient asks the questions to an upstream over plain UDP. Against the spoofed responses, every query is sent from
+// a fresh connection, on a random source port chosen by the system, with a random id and the letters of its name in a
+// random case (draft-vixie-dnsext-dns0x20), and only the responses echoing the id and the question are accepted.
+// The truncated responses are asked again over tcp to the same address
 type UDPClient struct {
-	id            uint16
-	connexionPool *sync.Pool
-	bufferPool    *sync.Pool
-	idMutex       sync.Locker
+	address    string
+	bufferPool *sync.Pool
+	// timeout is the time a response is waited for, the query is sent again retries times when none comes
+	timeout time.Duration
+	retries int
+	// randomCase randomizes the case of the names asked, disabled for the upstreams not echoing the question as asked
+	randomCase bool
+	// family is the address family the upstream is reached over, the system chooses by default
+	family family.Family
 }
 
 // NewUDPClient instantiate a UDPClient for the given address
 func NewUDPClient(address string) *UDPClient {
 	return &UDPClient{
-		id:      0,
-		idMutex: &sync.Mutex{},
-		connexionPool: &sync.Pool{New: func() any {
-			udpConn, err := net.Dial("udp", address)
-			if err != nil {
-				panic(err)
-			}
-			return udpConn
-		}},
+		address:    address,
+		timeout:    DefaultTimeout,
+		retries:    DefaultRetries,
+		randomCase: true,
 		bufferPool: &sync.Pool{New: func() any {
 			return make([]byte, dto.BufferMaxLength)
 		}},
 	}
 }
 
+// SetTimeout sets the time a response is waited for and the number of times the query is sent again without response,
+// it must be called before using the client
+func (c *UDPClient) SetTimeout(timeout time.Duration, retries int) {
+	c.timeout, c.retries = timeout, retries
+}
+
+// SetRandomCase enables the randomization of the case of the names asked, enabled by default.
+// It must be called before using the client
+func (c *UDPClient) SetRandomCase(enabled bool) {
+	c.randomCase = enabled
+}
+
+// SetFamily sets the address family the upstream is reached over, it must be called before using the client
+func (c *UDPClient) SetFamily(f family.Family) {
+	c.family = f
+}
+
 func (c *UDPClient) ResolveV4(name string) (dto.Record, error) {
 
 	question := dto.Question{
@@ -57,7 +100,7 @@ func (c *UDPClient) ResolveV4(name string) (dto.Record, error) {
 		Class: dto.IN,
 	}
 
-	return c.resolve(question)
+	return client.First(c.resolve(context.Background(), question, nil))
 }
 
 func (c *UDPClient) ResolveV6(name string) (dto.Record, error) {
@@ -66,81 +109,239 @@ func (c *UDPClient) ResolveV6(name string) (dto.Record, error) {
 		Type:  dto.AAAA,
 		Class: dto.IN,
 	}
-	return c.resolve(question)
+	return client.First(c.resolve(context.Background(), question, nil))
+}
+
+// Resolve implements client.TypedClient
+func (c *UDPClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.resolve(context.Background(), dto.Question{Name: name, Type: t, Class: dto.IN}, nil))
+}
+
+// ResolveRequest implements client.RequestClient, the EDNS options of the request are forwarded
+// and the response is no longer waited for once ctx is done
+func (c *UDPClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
 }
 
-func (c *UDPClient) resolve(request dto.Question) (dto.Record, error) {
+// ResolveSet implements client.SetClient
+func (c *UDPClient) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	return c.resolve(ctx, dto.Question{Name: name, Type: t, Class: dto.IN}, req.Options)
+}
+
+func (c *UDPClient) resolve(ctx context.Context, request dto.Question, options []dto.Option) ([]dto.Record, error) {
 
 	request.Name = strings.TrimRight(request.Name, ".")
 
-	udpConn := c.getConn()
-	defer c.recycleConn(udpConn)
+	sockets := &sockets{networks: c.family.Networks("udp")}
+	defer sockets.close()
 
+	question := request
+	if c.randomCase {
+		question.Name = randomCase(question.Name)
+	}
 	message := dto.Message{
-		ID:            c.nextID(),
-		Header:        dto.STANDARD_QUERY,
+		ID:            randomID(),
+		Header:        dnssec.QueryHeader(ctx, dto.STANDARD_QUERY),
 		QuestionCount: 1,
 		ResponseCount: 0,
-		Question:      []dto.Question{request},
+		Question:      []dto.Question{question},
 		Response:      []dto.Record{},
 	}
+	dto.SetOptions(&message, options)
 
 	payload := dto.SerializeMessage(message)
 
-	_, err := udpConn.Write(payload)
+	// a preferred family is sent the first query, the other one the next query when it fails or does not answer,
+	// then they take turns for the retries
+	attempts := max(c.retries+1, len(sockets.networks))
+	var response *dto.Message
+	var udpConn net.Conn
+	var err error
+	for attempt := 0; ; attempt++ {
+		udpConn, err = sockets.get(ctx, c.address, attempt)
+		if err == nil {
+			_, err = udpConn.Write(payload)
+		}
+		if err == nil {
+			// the response to a previous attempt is accepted, it has the same id and question
+			response, err = c.waitResponse(ctx, udpConn, message)
+		}
+		fallback := attempt+1 < len(sockets.networks)
+		if err == nil || ctx.Err() != nil || attempt+1 >= attempts || !(timedOut(err) || fallback) {
+			break
+		}
+		logger.Debug("no response, sending the query again", "server", c.address, "network", sockets.network(attempt+1), "name", request.Name, "attempt", attempt+1, "err", err)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, client.WrapTimeout(ctx.Err())
+	}
+	if err == nil && response.Header&dto.TRUNCATED != 0 {
+		// the answer does not fit in a datagram, the whole one is asked over tcp (rfc7766 section 5)
+		logger.Debug("truncated response, asking over tcp", "server", udpConn.RemoteAddr(), "name", request.Name)
+		response, err = c.exchangeTCP(ctx, payload, message)
+		if err != nil && ctx.Err() != nil {
+			return nil, client.WrapTimeout(ctx.Err())
+		}
+	}
 	if err != nil {
-		return dto.Record{}, err
+		return nil, client.WrapTimeout(err)
+	}
+
+	dnssec.Report(ctx, response.Header&dto.AUTHENTIC_DATA != 0)
+	if response.Header&dto.RCODE_MASK == dto.NAME_ERROR {
+		return nil, &client.NameError{Name: request.Name, TTL: dto.NegativeTTL(response)}
 	}
+	client.ReportAliases(ctx, dto.Aliases(response))
 
-	response, err := c.waitResponse(udpConn, message.ID)
+	records := dto.FindAnswers(response, request.Type)
+	if len(records) == 0 && len(response.Response) == 0 {
+		return nil, &client.NoDataError{Name: request.Name, Type: request.Type, TTL: dto.NegativeTTL(response)}
+	}
+	if len(records) == 0 {
+		return nil, &NoResponse{}
+	}
+	for i := range records {
+		records[i].Name = request.Name // the answer may be at the end of a CNAME chain
+	}
+	return records, nil
+}
+
+// sockets are the connected sockets of a query, one per network of the family dialed on their first attempt.
+// A connected socket only receives the datagrams of the upstream, on its own random port
+type sockets struct {
+	networks []string
+	conns    []net.Conn
+	stops    []func() bool
+}
+
+// network returns the network of the attempt, the networks take turns
+func (s *sockets) network(attempt int) string {
+	return s.networks[attempt%len(s.networks)]
+}
+
+// get returns the socket of the attempt, its reads stop once ctx is done
+func (s *sockets) get(ctx context.Context, address string, attempt int) (net.Conn, error) {
+	if s.conns == nil {
+		s.conns = make([]net.Conn, len(s.networks))
+	}
+	i := attempt % len(s.networks)
+	if s.conns[i] != nil {
+		return s.conns[i], nil
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, s.networks[i], address)
 	if err != nil {
-		return dto.Record{}, err
+		return nil, err
 	}
+	s.conns[i] = conn
+	s.stops = append(s.stops, context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) }))
+	return conn, nil
+}
 
-	if len(response.Response) < 1 {
-		return dto.Record{}, &NoResponse{}
+func (s *sockets) close() {
+	for _, stop := range s.stops {
+		stop()
+	}
+	for _, conn := range s.conns {
+		if conn != nil {
+			_ = conn.Close()
+		}
 	}
+}
 
-	return response.Response[0], nil
+// randomID returns an unpredictable id for a query
+func randomID() uint16 {
+	var id [2]byte
+	_, _ = rand.Read(id[:])
+	return binary.BigEndian.Uint16(id[:])
 }
 
-func (c *UDPClient) nextID() uint16 {
-	c.idMutex.Lock()
-	defer c.idMutex.Unlock()
+// randomCase returns the name with its letters in a random case
+func randomCase(name string) string {
+	bits := make([]byte, (len(name)+7)/8)
+	_, _ = rand.Read(bits)
+	res := []byte(name)
+	for i, c := range res {
+		if bits[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		switch {
+		case c >= 'a' && c <= 'z':
+			res[i] = c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z':
+			res[i] = c - 'A' + 'a'
+		}
+	}
+	return string(res)
+}
 
-	c.id++
-	c.id = c.id % math.MaxUint16
-	return c.id
+// matches tells if the response answers the query: a response, with the QR bit, echoing its id and its question in the same case
+func matches(query dto.Message, response *dto.Message) bool {
+	if response.ID != query.ID || response.Header&0x8000 == 0 || len(response.Question) != 1 {
+		return false
+	}
+	asked, echoed := query.Question[0], response.Question[0]
+	return strings.TrimRight(echoed.Name, ".") == asked.Name && echoed.Type == asked.Type && echoed.Class == asked.Class
 }
 
-func (c *UDPClient) waitResponse(udpConn net.Conn, id uint16) (*dto.Message, error) {
+// waitResponse reads the response to the query, the other datagrams, like the late responses to the previous queries
+// or spoofed responses, are dropped
+func (c *UDPClient) waitResponse(ctx context.Context, udpConn net.Conn, query dto.Message) (*dto.Message, error) {
 	buffer := c.getBuffer()
 	defer c.recycleBuffer(buffer)
-	_ = udpConn.SetReadDeadline(time.Now().Add(10 * time.Second))
-	n, err := udpConn.Read(buffer)
+	_ = udpConn.SetReadDeadline(client.Deadline(ctx, c.timeout))
+	for {
+		n, err := udpConn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			logger.Debug("empty response", "server", udpConn.RemoteAddr())
+		}
+		message, err := dto.ParseMessage(buffer[0:n])
+		if err != nil {
+			logger.Debug("dropping an invalid response", "server", udpConn.RemoteAddr(), "err", err)
+			continue
+		}
+		if matches(query, message) {
+			return message, nil
+		}
+		logger.Debug("dropping a response not matching the query", "server", udpConn.RemoteAddr(), "id", message.ID)
+	}
+}
+
+// exchangeTCP sends the query to the upstream over tcp and reads its response, until ctx is done
+func (c *UDPClient) exchangeTCP(ctx context.Context, payload []byte, query dto.Message) (*dto.Message, error) {
+	deadline := client.Deadline(ctx, c.timeout)
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := c.family.Dial(ctx, &dialer, "tcp", c.address)
 	if err != nil {
 		return nil, err
 	}
-	if n == 0 {
-		log.Println("client read 0 bytes")
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+	_ = conn.SetDeadline(deadline)
+	if err := framing.Write(conn, payload); err != nil {
+		return nil, err
+	}
+	data, err := framing.Read(conn)
+	if err != nil {
+		return nil, err
 	}
-	data := buffer[0:n]
-	message, err := dto.ParseMessage(data)
+	response, err := dto.ParseMessage(data)
 	if err != nil {
 		return nil, err
 	}
-	if id != message.ID {
-		return nil, errors.New("id mismatch")
+	if !matches(query, response) {
+		return nil, errors.New("the tcp response does not match the query")
 	}
-	return message, nil
-}
-
-func (c *UDPClient) getConn() net.Conn {
-	return c.connexionPool.Get().(net.Conn)
+	return response, nil
 }
 
-func (c *UDPClient) recycleConn(conn net.Conn) {
-	c.connexionPool.Put(conn)
+// timedOut tells if no response came before the deadline
+func timedOut(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 func (c *UDPClient) getBuffer() []byte {
@@ -1,11 +1,19 @@
 package udp
 
 import (
+	"context"
+	"errors"
 	"net"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/bluguard/dnshield/internal/dns/client/family"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
 )
 
 func TestUDPClient_ResolveV4(t *testing.T) {
@@ -94,3 +102,236 @@ func TestUDPClient_ResolveV6(t *testing.T) {
 		})
 	}
 }
+
+// serveLossy answers 127.0.0.1 to every question, the first drop queries are lost
+func serveLossy(t *testing.T, drop int32) (string, *atomic.Int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	received := &atomic.Int32{}
+	go func() {
+		buffer := make([]byte, dto.BufferMaxLength)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if received.Add(1) <= drop {
+				continue
+			}
+			query, err := dto.ParseMessage(buffer[:n])
+			if err != nil {
+				continue
+			}
+			response := dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: query.Question, ResponseCount: 1,
+				Response: []dto.Record{{Name: query.Question[0].Name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("127.0.0.1").To4()}}}
+			_, _ = conn.WriteTo(dto.SerializeMessage(response), addr)
+		}
+	}()
+	return conn.LocalAddr().String(), received
+}
+
+func TestUDPClient_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		drop         int32
+		retries      int
+		wantErr      bool
+		wantReceived int32
+	}{
+		{name: "answered", drop: 0, retries: 1, wantReceived: 1},
+		{name: "retried", drop: 1, retries: 1, wantReceived: 2},
+		{name: "lost", drop: 2, retries: 1, wantErr: true, wantReceived: 2},
+		{name: "without retry", drop: 1, retries: 0, wantErr: true, wantReceived: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, received := serveLossy(t, tt.drop)
+			c := NewUDPClient(address)
+			c.SetTimeout(100*time.Millisecond, tt.retries)
+			_, err := c.ResolveV4("example.com")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UDPClient.ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := received.Load(); got != tt.wantReceived {
+				t.Errorf("expecting %d queries, the server received %d", tt.wantReceived, got)
+			}
+		})
+	}
+}
+
+func TestUDPClient_FamilyFallback(t *testing.T) {
+	address, received := serveLossy(t, 0)
+	c := NewUDPClient(address)
+	c.SetTimeout(100*time.Millisecond, 0)
+	c.SetFamily(family.PreferIPv6)
+	// the IPv4 upstream cannot be reached over IPv6, the query is sent over IPv4 without counting as a retry
+	if _, err := c.ResolveV4("example.com"); err != nil {
+		t.Errorf("UDPClient.ResolveV4() error = %v, expecting the fallback to IPv4", err)
+	}
+	if got := received.Load(); got != 1 {
+		t.Errorf("expecting 1 query over IPv4, the server received %d", got)
+	}
+	c.SetFamily(family.IPv6)
+	if _, err := c.ResolveV4("example.com"); err == nil {
+		t.Errorf("expecting an error for an IPv4 upstream reached over IPv6 only")
+	}
+}
+
+func TestUDPClient_ResolveRequestCancelled(t *testing.T) {
+	address, _ := serveLossy(t, 10)
+	c := NewUDPClient(address)
+	c.SetTimeout(time.Minute, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.ResolveRequest(ctx, request.Request{}, "example.com", dto.A); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("UDPClient.ResolveRequest() error = %v, want the error of the context", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expecting the query to end with its context, took %v", elapsed)
+	}
+}
+
+// serveForged answers every question with forged responses, a wrong id then a wrong question, before the real one.
+// The real one echoes the question in lower case when lower is set, like the upstreams not preserving the case
+func serveForged(t *testing.T, lower bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, dto.BufferMaxLength)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query, err := dto.ParseMessage(buffer[:n])
+			if err != nil {
+				continue
+			}
+			answer := func(id uint16, question dto.Question, ip string) {
+				response := dto.Message{ID: id, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: []dto.Question{question}, ResponseCount: 1,
+					Response: []dto.Record{{Name: question.Name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP(ip).To4()}}}
+				_, _ = conn.WriteTo(dto.SerializeMessage(response), addr)
+			}
+			question := query.Question[0]
+			answer(query.ID+1, question, "10.6.6.6")
+			answer(query.ID, dto.Question{Name: "forged." + question.Name, Type: question.Type, Class: question.Class}, "10.6.6.6")
+			if lower {
+				question.Name = strings.ToLower(question.Name)
+			}
+			answer(query.ID, question, "127.0.0.1")
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestUDPClient_Spoofing(t *testing.T) {
+	tests := []struct {
+		name       string
+		lower      bool
+		randomCase bool
+		wantErr    bool
+	}{
+		{name: "random case", randomCase: true},
+		{name: "case not preserved", lower: true, randomCase: true, wantErr: true},
+		{name: "case not randomized", lower: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewUDPClient(serveForged(t, tt.lower))
+			c.SetTimeout(100*time.Millisecond, 0)
+			c.SetRandomCase(tt.randomCase)
+			// a long name is very unlikely to be sent in lower case
+			name := "the-spoofed-names-are-dropped.example.com"
+			record, err := c.ResolveV4(name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UDPClient.ResolveV4() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (!record.Data.Equal(net.ParseIP("127.0.0.1")) || record.Name != name) {
+				t.Errorf("expecting the real answer for the name asked, got %v", record)
+			}
+		})
+	}
+}
+
+func TestRandomCase(t *testing.T) {
+	name := "www.example-0x20.com"
+	changed := false
+	for i := 0; i < 10; i++ {
+		got := randomCase(name)
+		if !strings.EqualFold(got, name) {
+			t.Fatalf("randomCase() = %s, expecting the same name in another case", got)
+		}
+		changed = changed || got != name
+	}
+	if !changed {
+		t.Error("expecting the case of the name to change")
+	}
+}
+
+// serveTruncated answers the questions over udp with a truncated response holding one address, and over tcp on the
+// same port with the three addresses of the name
+func serveTruncated(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	if err != nil {
+		t.Skip("the port of the tcp listener is taken in udp:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	answer := func(query *dto.Message, count int, header uint16) []byte {
+		response := dto.Message{ID: query.ID, Header: dto.STANDARD_RESPONSE | header, QuestionCount: 1, Question: query.Question}
+		for i := 0; i < count; i++ {
+			response.Response = append(response.Response, dto.Record{Name: query.Question[0].Name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.IPv4(10, 0, 0, byte(i+1)).To4()})
+		}
+		response.ResponseCount = uint16(count)
+		return dto.SerializeMessage(response)
+	}
+	go func() {
+		buffer := make([]byte, dto.BufferMaxLength)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if query, err := dto.ParseMessage(buffer[:n]); err == nil {
+				_, _ = conn.WriteTo(answer(query, 1, dto.TRUNCATED), addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			data, err := framing.Read(c)
+			if query, parseErr := dto.ParseMessage(data); err == nil && parseErr == nil {
+				_ = framing.Write(c, answer(query, 3, 0))
+			}
+			c.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestUDPClient_Truncated(t *testing.T) {
+	c := NewUDPClient(serveTruncated(t))
+	c.SetTimeout(time.Second, 0)
+	records, err := c.ResolveSet(context.Background(), request.Request{}, "example.com", dto.A)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Errorf("expecting the three addresses of the tcp response, got %v", records)
+	}
+}
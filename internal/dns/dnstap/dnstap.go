@@ -0,0 +1,253 @@
+// Package dnstap emits the queries of the clients, their responses and the exchanges with the upstream as dnstap
+// messages, the protobuf messages of https://dnstap.info, in a frame stream written to a file or to a unix socket
+package dnstap
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var logger = logging.Component("dnstap")
+
+const (
+	// queueLength is the number of messages waiting for the output, the following ones are dropped
+	queueLength = 1024
+	// retryDelay is the time waited before connecting again to a receiver which failed
+	retryDelay = 5 * time.Second
+)
+
+// types of the dnstap messages
+const (
+	clientQuery       = 5
+	clientResponse    = 6
+	forwarderQuery    = 7
+	forwarderResponse = 8
+)
+
+// Logger emits the dnstap messages, it never blocks the queries: the messages are dropped while the output lags behind.
+// A nil Logger emits nothing
+type Logger struct {
+	identity []byte
+	frames   chan []byte
+	// connect opens the output, once at start for a file and again after a failure for a socket
+	connect func() (output, error)
+	dropped atomic.Uint64
+}
+
+// OpenFile starts the stream written to the file at path, it is truncated
+func OpenFile(path, identity string) (*Logger, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	out, err := newFileOutput(file)
+	if err != nil {
+		return nil, err
+	}
+	opened := false
+	return newLogger(identity, func() (output, error) {
+		if opened {
+			return nil, os.ErrClosed
+		}
+		opened = true
+		return out, nil
+	}), nil
+}
+
+// NewSocketLogger returns the logger streaming to the receiver listening on the unix socket at path,
+// connected once started and again when the connection is lost
+func NewSocketLogger(path, identity string) *Logger {
+	return newLogger(identity, func() (output, error) {
+		out, err := dialSocket(path)
+		if err != nil {
+			return nil, err // not a nil *socketOutput in a non nil output
+		}
+		return out, nil
+	})
+}
+
+func newLogger(identity string, connect func() (output, error)) *Logger {
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	return &Logger{
+		identity: []byte(identity),
+		frames:   make(chan []byte, queueLength),
+		connect:  connect,
+	}
+}
+
+// Dropped returns the number of messages dropped since the start
+func (l *Logger) Dropped() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.dropped.Load()
+}
+
+// Start writes the messages to the output until ctx is done, the stream is then ended and the output closed
+func (l *Logger) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go l.run(ctx, wg)
+}
+
+func (l *Logger) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	out, err := l.connect()
+	var failed time.Time
+	if err != nil {
+		logger.Warn("cannot open the dnstap output, dropping the messages", "err", err, "retry", retryDelay)
+		failed = time.Now()
+	}
+	defer func() {
+		if out != nil {
+			if err := out.close(); err != nil {
+				logger.Warn("cannot end the dnstap stream", "err", err)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case frame := <-l.frames:
+			if out == nil && time.Since(failed) > retryDelay {
+				if out, err = l.connect(); err != nil {
+					logger.Warn("cannot open the dnstap output, dropping the messages", "err", err, "retry", retryDelay)
+					failed = time.Now()
+				}
+			}
+			if out == nil {
+				l.dropped.Add(1)
+				continue
+			}
+			if err := out.write(frame); err != nil {
+				logger.Warn("cannot write the dnstap message", "err", err)
+				_ = out.close()
+				out, failed = nil, time.Now()
+				l.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// emit queues the message, it is dropped when the queue is full
+func (l *Logger) emit(frame []byte) {
+	select {
+	case l.frames <- frame:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// ClientQuery emits the query received from the client of the request
+func (l *Logger) ClientQuery(req request.Request, query []byte, received time.Time) {
+	if l == nil {
+		return
+	}
+	l.emit(l.envelope(appendExchange(l.clientMessage(clientQuery, req), query, received, nil, time.Time{})))
+}
+
+// ClientResponse emits the response sent to the client of the request, with the query it answers
+func (l *Logger) ClientResponse(req request.Request, query, response []byte, received, sent time.Time) {
+	if l == nil {
+		return
+	}
+	l.emit(l.envelope(appendExchange(l.clientMessage(clientResponse, req), query, received, response, sent)))
+}
+
+// forwarder emits the query asked to the upstream, then its response once answered
+func (l *Logger) forwarder(query, response []byte, asked, answered time.Time) {
+	t := uint64(forwarderQuery)
+	if response != nil {
+		t = forwarderResponse
+	}
+	m := protowire.AppendTag(nil, 1, protowire.VarintType)
+	m = protowire.AppendVarint(m, t)
+	l.emit(l.envelope(appendExchange(m, query, asked, response, answered)))
+}
+
+// clientMessage starts the message of the type with the address and the transport of the client of the request
+func (l *Logger) clientMessage(t uint64, req request.Request) []byte {
+	m := protowire.AppendTag(nil, 1, protowire.VarintType)
+	m = protowire.AppendVarint(m, t)
+	if family, address := socketFamily(req.Client); family != 0 {
+		m = protowire.AppendTag(m, 2, protowire.VarintType)
+		m = protowire.AppendVarint(m, family)
+		m = protowire.AppendTag(m, 4, protowire.BytesType)
+		m = protowire.AppendBytes(m, address)
+	}
+	if protocol := socketProtocol(req.Transport); protocol != 0 {
+		m = protowire.AppendTag(m, 3, protowire.VarintType)
+		m = protowire.AppendVarint(m, protocol)
+	}
+	return m
+}
+
+// envelope returns the dnstap frame of the message, with the identity of the server
+func (l *Logger) envelope(message []byte) []byte {
+	res := make([]byte, 0, len(message)+len(l.identity)+32)
+	res = protowire.AppendTag(res, 1, protowire.BytesType)
+	res = protowire.AppendBytes(res, l.identity)
+	res = protowire.AppendTag(res, 2, protowire.BytesType)
+	res = protowire.AppendString(res, "dnshield")
+	res = protowire.AppendTag(res, 14, protowire.BytesType)
+	res = protowire.AppendBytes(res, message)
+	res = protowire.AppendTag(res, 15, protowire.VarintType)
+	return protowire.AppendVarint(res, 1) // MESSAGE
+}
+
+// appendExchange appends the query and the time it was sent at, then the response and its time when not nil
+func appendExchange(m, query []byte, asked time.Time, response []byte, answered time.Time) []byte {
+	m = appendTime(m, 8, 9, asked)
+	m = protowire.AppendTag(m, 10, protowire.BytesType)
+	m = protowire.AppendBytes(m, query)
+	if response == nil {
+		return m
+	}
+	m = appendTime(m, 12, 13, answered)
+	m = protowire.AppendTag(m, 14, protowire.BytesType)
+	return protowire.AppendBytes(m, response)
+}
+
+// appendTime appends the seconds and the nanoseconds of t as the fields sec and nsec
+func appendTime(m []byte, sec, nsec protowire.Number, t time.Time) []byte {
+	m = protowire.AppendTag(m, sec, protowire.VarintType)
+	m = protowire.AppendVarint(m, uint64(t.Unix()))
+	m = protowire.AppendTag(m, nsec, protowire.Fixed32Type)
+	return protowire.AppendFixed32(m, uint32(t.Nanosecond()))
+}
+
+// socketFamily returns the family of the address and its bytes, a zero family when unknown
+func socketFamily(ip net.IP) (uint64, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return 1, ip4 // INET
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		return 2, ip16 // INET6
+	}
+	return 0, nil
+}
+
+// socketProtocol returns the protocol of the transport, zero for the transports dnstap does not define
+func socketProtocol(t request.Transport) uint64 {
+	switch t {
+	case request.UDP:
+		return 1
+	case request.TCP:
+		return 2
+	case request.DOT:
+		return 3
+	case request.DOH:
+		return 4
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+package dnstap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// readFrame reads a data frame, or the type of a control frame with a nil frame
+func readFrame(r io.Reader) ([]byte, uint32, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, 0, err
+	}
+	if n := binary.BigEndian.Uint32(length[:]); n > 0 {
+		frame := make([]byte, n)
+		_, err := io.ReadFull(r, frame)
+		return frame, 0, err
+	}
+	control, err := readControl(io.MultiReader(bytes.NewReader(length[:]), r))
+	return nil, control, err
+}
+
+// fields returns the fields of a protobuf message by number, the varints as their encoding
+func fields(m []byte) map[protowire.Number][]byte {
+	res := make(map[protowire.Number][]byte)
+	for len(m) > 0 {
+		num, typ, n := protowire.ConsumeTag(m)
+		if n < 0 {
+			return res
+		}
+		m = m[n:]
+		if typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(m)
+			if n < 0 {
+				return res
+			}
+			res[num], m = v, m[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, m)
+		if n < 0 {
+			return res
+		}
+		res[num], m = m[:n], m[n:]
+	}
+	return res
+}
+
+func varint(b []byte) uint64 {
+	v, _ := protowire.ConsumeVarint(b)
+	return v
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	l, err := OpenFile(path, "resolver-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	l.Start(ctx, &wg)
+
+	memoryClient := inmemoryclient.InMemoryClient{}
+	memoryClient.Add("nas.lan", "192.168.1.2")
+	upstream := l.Wrap(&memoryClient)
+	if _, err := upstream.ResolveV4("nas.lan"); err != nil {
+		t.Fatal(err)
+	}
+	query := dto.SerializeMessage(dto.Message{ID: 3, Header: dto.STANDARD_QUERY, QuestionCount: 1, Question: []dto.Question{{Name: "nas.lan", Type: dto.A, Class: dto.IN}}})
+	l.ClientQuery(request.Request{Client: net.ParseIP("192.168.1.20"), Transport: request.UDP}, query, time.Now())
+	for len(l.frames) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	if frame, control, err := readFrame(r); err != nil || frame != nil || control != controlStart {
+		t.Fatalf("expecting the stream to start, got control %d", control)
+	}
+	for _, want := range []uint64{forwarderQuery, forwarderResponse, clientQuery} {
+		frame, _, err := readFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope := fields(frame)
+		if string(envelope[1]) != "resolver-1" || varint(envelope[15]) != 1 {
+			t.Fatalf("unexpected envelope %v", envelope)
+		}
+		message := fields(envelope[14])
+		if got := varint(message[1]); got != want {
+			t.Fatalf("expecting a message of type %d, got %d", want, got)
+		}
+		if want == clientQuery {
+			if !bytes.Equal(message[10], query) || !net.IP(message[4]).Equal(net.ParseIP("192.168.1.20")) || varint(message[3]) != 1 {
+				t.Errorf("unexpected client query %v", message)
+			}
+		}
+		if want == forwarderResponse && len(message[14]) == 0 {
+			t.Errorf("expecting the response of the upstream")
+		}
+	}
+	if frame, control, err := readFrame(r); err != nil || frame != nil || control != controlStop {
+		t.Fatalf("expecting the stream to stop, got control %d", control)
+	}
+}
+
+func TestNewSocketLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan uint64, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, control, _ := readFrame(conn); control != controlReady {
+			return
+		}
+		_, _ = conn.Write(controlFrame(controlAccept, true))
+		if _, control, _ := readFrame(conn); control != controlStart {
+			return
+		}
+		frame, _, _ := readFrame(conn)
+		received <- varint(fields(fields(frame)[14])[1])
+		if _, control, _ := readFrame(conn); control == controlStop {
+			_, _ = conn.Write(controlFrame(controlFinish, false))
+		}
+	}()
+
+	l := NewSocketLogger(path, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	l.Start(ctx, &wg)
+	l.ClientResponse(request.Request{Client: net.ParseIP("fd00::20"), Transport: request.DOH}, []byte{0}, []byte{1}, time.Now(), time.Now())
+	select {
+	case got := <-received:
+		if got != clientResponse {
+			t.Errorf("expecting a client response, got %d", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the receiver got no message")
+	}
+	cancel()
+	wg.Wait()
+	if l.Dropped() != 0 {
+		t.Errorf("no message should be dropped, got %d", l.Dropped())
+	}
+}
@@ -0,0 +1,152 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// contentType is the type of the frames of a dnstap stream
+const contentType = "protobuf:dnstap.Dnstap"
+
+// control frames of the frame streams protocol
+const (
+	controlAccept uint32 = 0x01
+	controlStart  uint32 = 0x02
+	controlStop   uint32 = 0x03
+	controlReady  uint32 = 0x04
+	controlFinish uint32 = 0x05
+	// fieldContentType is the only field of the control frames
+	fieldContentType uint32 = 0x01
+	// maxControlLength bounds the control frames read from the receiver
+	maxControlLength = 512
+	handshakeTimeout = 5 * time.Second
+)
+
+// output is the destination of a frame stream
+type output interface {
+	// write writes a data frame
+	write(frame []byte) error
+	// close ends the stream and closes its destination
+	close() error
+}
+
+// controlFrame returns the control frame of the type, with the content type of dnstap when withType is set
+func controlFrame(control uint32, withType bool) []byte {
+	length := 4
+	if withType {
+		length += 8 + len(contentType)
+	}
+	res := make([]byte, 0, 8+length)
+	res = binary.BigEndian.AppendUint32(res, 0) // escape of the control frames
+	res = binary.BigEndian.AppendUint32(res, uint32(length))
+	res = binary.BigEndian.AppendUint32(res, control)
+	if withType {
+		res = binary.BigEndian.AppendUint32(res, fieldContentType)
+		res = binary.BigEndian.AppendUint32(res, uint32(len(contentType)))
+		res = append(res, contentType...)
+	}
+	return res
+}
+
+// readControl reads a control frame and returns its type
+func readControl(r io.Reader) (uint32, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if binary.BigEndian.Uint32(header[:4]) != 0 || length < 4 || length > maxControlLength {
+		return 0, errors.New("invalid control frame")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(payload), nil
+}
+
+// writeFrame writes a data frame, its length followed by its payload
+func writeFrame(w io.Writer, frame []byte) error {
+	buffer := make([]byte, 0, 4+len(frame))
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(frame)))
+	_, err := w.Write(append(buffer, frame...))
+	return err
+}
+
+// fileOutput is a unidirectional stream written to a file
+type fileOutput struct {
+	w io.WriteCloser
+}
+
+// newFileOutput starts the stream of w
+func newFileOutput(w io.WriteCloser) (*fileOutput, error) {
+	if _, err := w.Write(controlFrame(controlStart, true)); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	return &fileOutput{w: w}, nil
+}
+
+func (o *fileOutput) write(frame []byte) error {
+	return writeFrame(o.w, frame)
+}
+
+func (o *fileOutput) close() error {
+	_, err := o.w.Write(controlFrame(controlStop, false))
+	return errors.Join(err, o.w.Close())
+}
+
+// socketOutput is a bidirectional stream to a receiver listening on a unix socket, like dnstap or vector
+type socketOutput struct {
+	conn net.Conn
+}
+
+// dialSocket connects to the receiver and negotiates the content type of the stream
+func dialSocket(path string) (*socketOutput, error) {
+	conn, err := net.DialTimeout("unix", path, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := handshake(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return &socketOutput{conn: conn}, nil
+}
+
+func handshake(conn net.Conn) error {
+	if _, err := conn.Write(controlFrame(controlReady, true)); err != nil {
+		return err
+	}
+	control, err := readControl(conn)
+	if err != nil {
+		return err
+	}
+	if control != controlAccept {
+		return errors.New("the receiver did not accept the dnstap stream")
+	}
+	_, err = conn.Write(controlFrame(controlStart, true))
+	return err
+}
+
+func (o *socketOutput) write(frame []byte) error {
+	return writeFrame(o.conn, frame)
+}
+
+// close stops the stream and waits for the receiver to finish it
+func (o *socketOutput) close() error {
+	_ = o.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	_, err := o.conn.Write(controlFrame(controlStop, false))
+	if err == nil {
+		var control uint32
+		if control, err = readControl(o.conn); err == nil && control != controlFinish {
+			err = errors.New("the receiver did not finish the dnstap stream")
+		}
+	}
+	return errors.Join(err, o.conn.Close())
+}
@@ -0,0 +1,91 @@
+package dnstap
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.SetClient = &Client{}
+
+// Wrap returns the client emitting the exchanges with the upstream client c, c itself when l is nil
+func (l *Logger) Wrap(c client.Client) client.Client {
+	if l == nil {
+		return c
+	}
+	return &Client{logger: l, delegate: c}
+}
+
+// Client is an upstream client whose questions and answers are emitted as forwarder messages.
+// The clients of the upstreams do not expose their wire messages, the messages are rebuilt from the question and its answer
+type Client struct {
+	logger   *Logger
+	delegate client.Client
+}
+
+// ResolveV4 implements client.Client
+func (c *Client) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *Client) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient
+func (c *Client) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *Client) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *Client) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	question := dto.Message{
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: name, Type: t, Class: dto.IN}},
+	}
+	query := dto.SerializeMessage(question)
+	asked := time.Now()
+	c.logger.forwarder(query, nil, asked, time.Time{})
+	records, err := client.ResolveSet(ctx, c.delegate, req, name, t)
+	if rcode, answered := responseCode(err); answered {
+		response := dto.Message{
+			Header:        dto.STANDARD_RESPONSE | rcode,
+			QuestionCount: 1,
+			ResponseCount: uint16(len(records)),
+			Question:      question.Question,
+			Response:      records,
+		}
+		c.logger.forwarder(query, dto.SerializeMessage(response), asked, time.Now())
+	}
+	return records, err
+}
+
+// responseCode returns the rcode of the answer of the upstream, the failures to reach it have no answer
+func responseCode(err error) (uint16, bool) {
+	var nameError *client.NameError
+	var noDataError *client.NoDataError
+	var refusedError *client.RefusedError
+	var serverFail *client.ServerFailError
+	switch {
+	case err == nil, errors.As(err, &noDataError):
+		return 0, true
+	case errors.As(err, &nameError):
+		return dto.NAME_ERROR, true
+	case errors.As(err, &refusedError):
+		return dto.REFUSED, true
+	case errors.As(err, &serverFail):
+		return dto.SERVER_FAILURE, true
+	}
+	return 0, false
+}
@@ -0,0 +1,131 @@
+package dto
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+const (
+	// EDNSPayloadSize is the udp payload size advertised to the clients and to the upstreams,
+	// small enough to avoid the ip fragmentation (dns flag day 2020)
+	EDNSPayloadSize = 1232
+	// DOBit is the flag of the ttl of an OPT record asking for the DNSSEC records (rfc3225)
+	DOBit uint32 = 0x8000
+)
+
+// OptionCode identifies an EDNS option (rfc6891 section 6.1.2)
+type OptionCode uint16
+
+const (
+	NSID         OptionCode = 3
+	ClientSubnet OptionCode = 8
+	Cookie       OptionCode = 10
+	TCPKeepalive OptionCode = 11
+	Padding      OptionCode = 12
+	ExtendedErr  OptionCode = 15
+)
+
+var optionNames = map[OptionCode]string{
+	NSID:         "NSID",
+	ClientSubnet: "ECS",
+	Cookie:       "COOKIE",
+	TCPKeepalive: "KEEPALIVE",
+	Padding:      "PADDING",
+	ExtendedErr:  "EDE",
+}
+
+// Option is an option of the OPT pseudo record
+type Option struct {
+	Code OptionCode
+	Data []byte
+}
+
+// Known tells if the option is defined by a rfc, the others are private or vendor options like device ids
+func (o Option) Known() bool {
+	_, ok := optionNames[o.Code]
+	return ok
+}
+
+// String returns the name of the option
+func (o Option) String() string {
+	if name, ok := optionNames[o.Code]; ok {
+		return name
+	}
+	return "OPTION" + strconv.Itoa(int(o.Code))
+}
+
+// FindOPT returns the OPT pseudo record of the additional section of the message
+func FindOPT(message Message) (Record, bool) {
+	for _, record := range message.Additional {
+		if record.Type == OPT {
+			return record, true
+		}
+	}
+	return Record{}, false
+}
+
+// NewOPT returns an OPT pseudo record advertising the payload size and carrying the options
+func NewOPT(payloadSize uint16, options []Option) Record {
+	return Record{Name: "", Type: OPT, Class: Class(payloadSize), Raw: EncodeOptions(options)}
+}
+
+// SetOptions adds an OPT record carrying the options to the query, advertising EDNSPayloadSize so the upstream
+// answers with messages larger than 512 bytes
+func SetOptions(message *Message, options []Option) {
+	message.Additional = append(message.Additional, NewOPT(EDNSPayloadSize, options))
+	message.AdditionalCount = uint16(len(message.Additional))
+}
+
+// PayloadSize returns the maximum size of the udp response to the query: the payload size advertised by its
+// OPT record bounded by EDNSPayloadSize, 512 bytes without OPT (rfc6891 section 6.2.5)
+func PayloadSize(query Message) int {
+	opt, ok := FindOPT(query)
+	if !ok {
+		return UDPMaxLength
+	}
+	return min(max(int(opt.Class), UDPMaxLength), EDNSPayloadSize)
+}
+
+// EchoOPT adds an OPT record to the response of a query carrying one (rfc6891 section 7),
+// advertising EDNSPayloadSize with the DO bit of the query
+func EchoOPT(query Message, response *Message) {
+	opt, ok := FindOPT(query)
+	if !ok {
+		return
+	}
+	echo := NewOPT(EDNSPayloadSize, nil)
+	echo.TTL = opt.TTL & DOBit
+	response.Additional = append(response.Additional, echo)
+	response.AdditionalCount = uint16(len(response.Additional))
+}
+
+// ParseOptions reads the options of the data of an OPT record
+func ParseOptions(raw []byte) ([]Option, error) {
+	options := make([]Option, 0, 2)
+	for i := 0; i < len(raw); {
+		if i+4 > len(raw) {
+			return nil, errors.New("bad OPT data")
+		}
+		code := OptionCode(binary.BigEndian.Uint16(raw[i:]))
+		length := int(binary.BigEndian.Uint16(raw[i+2:]))
+		i += 4
+		if i+length > len(raw) {
+			return nil, errors.New("bad length of the option " + Option{Code: code}.String())
+		}
+		options = append(options, Option{Code: code, Data: append([]byte(nil), raw[i:i+length]...)})
+		i += length
+	}
+	return options, nil
+}
+
+// EncodeOptions returns the data of an OPT record carrying the options
+func EncodeOptions(options []Option) []byte {
+	res := make([]byte, 0, 4*len(options))
+	for _, option := range options {
+		res = binary.BigEndian.AppendUint16(res, uint16(option.Code))
+		res = binary.BigEndian.AppendUint16(res, uint16(len(option.Data)))
+		res = append(res, option.Data...)
+	}
+	return res
+}
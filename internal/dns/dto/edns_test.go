@@ -0,0 +1,107 @@
+package dto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOPTRoundTrip(t *testing.T) {
+	options := []Option{
+		{Code: Cookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Code: 26946, Data: []byte("device-id")},
+		{Code: Padding},
+	}
+	message := Message{
+		ID:            1,
+		Header:        STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []Question{{Name: "example.com", Type: A, Class: IN}},
+	}
+	SetOptions(&message, options)
+
+	parsed, err := ParseMessage(SerializeMessage(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt, ok := FindOPT(*parsed)
+	if !ok {
+		t.Fatalf("the OPT record is lost")
+	}
+	if opt.Name != "" || opt.Class != EDNSPayloadSize {
+		t.Errorf("unexpected OPT record %v", opt)
+	}
+	got, err := ParseOptions(opt.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, options) {
+		t.Errorf("ParseOptions() = %v, want %v", got, options)
+	}
+	if got[1].Known() || !got[0].Known() || got[1].String() != "OPTION26946" {
+		t.Errorf("only the rfc options are known, got %v", got)
+	}
+}
+
+func TestParseOptions_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{name: "truncated header", raw: []byte{0, 10, 0}},
+		{name: "length overflow", raw: []byte{0, 10, 0, 8, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseOptions(tt.raw); err == nil {
+				t.Errorf("expecting an error")
+			}
+		})
+	}
+}
+
+func TestPayloadSize(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  *Record
+		want int
+	}{
+		{name: "without edns", want: UDPMaxLength},
+		{name: "below the minimum", opt: &Record{Type: OPT, Class: 256}, want: UDPMaxLength},
+		{name: "advertised", opt: &Record{Type: OPT, Class: 1024}, want: 1024},
+		{name: "above the fragmentation limit", opt: &Record{Type: OPT, Class: 4096}, want: EDNSPayloadSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := Message{}
+			if tt.opt != nil {
+				message.Additional = []Record{*tt.opt}
+				message.AdditionalCount = 1
+			}
+			if got := PayloadSize(message); got != tt.want {
+				t.Errorf("PayloadSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEchoOPT(t *testing.T) {
+	response := Message{}
+	EchoOPT(Message{}, &response)
+	if response.AdditionalCount != 0 {
+		t.Fatalf("expecting no OPT record without edns in the query, got %v", response.Additional)
+	}
+
+	query := Message{AdditionalCount: 1, Additional: []Record{{Type: OPT, Class: 4096, TTL: DOBit | 0x0100, Raw: EncodeOptions([]Option{{Code: Cookie, Data: []byte("12345678")}})}}}
+	EchoOPT(query, &response)
+	parsed, err := ParseMessage(SerializeMessage(response))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt, ok := FindOPT(*parsed)
+	if !ok {
+		t.Fatalf("expecting an OPT record in the response")
+	}
+	if opt.Class != EDNSPayloadSize || opt.TTL != DOBit || len(opt.Raw) != 0 {
+		t.Errorf("expecting the payload size and the DO bit only, got %v", opt)
+	}
+}
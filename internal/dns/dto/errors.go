@@ -0,0 +1,38 @@
+package dto
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrMalformed is matched by the errors of the messages which cannot be parsed, they are answered with FORMERR
+var ErrMalformed = errors.New("malformed message")
+
+var _ error = &malformedError{}
+
+// malformedError is the reason a message cannot be parsed, it is an ErrMalformed
+type malformedError struct {
+	err error
+}
+
+// Error implements error.
+func (e *malformedError) Error() string {
+	return "malformed message: " + e.err.Error()
+}
+
+// Unwrap returns ErrMalformed and the reason
+func (e *malformedError) Unwrap() []error {
+	return []error{ErrMalformed, e.err}
+}
+
+// FormatErrorResponse returns the FORMERR response to a query which cannot be parsed, false when the packet
+// has no header or is a response, which is never answered
+func FormatErrorResponse(packet []byte) (Message, bool) {
+	if len(packet) < bufferMinLength || binary.BigEndian.Uint16(packet[2:4])&0x8000 != 0 {
+		return Message{}, false
+	}
+	return Message{
+		ID:     binary.BigEndian.Uint16(packet[0:2]),
+		Header: STANDARD_RESPONSE | FORMAT_ERROR,
+	}, true
+}
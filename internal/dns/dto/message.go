@@ -1,6 +1,9 @@
 package dto
 
-import "net"
+import (
+	"encoding/binary"
+	"net"
+)
 
 type Type uint16
 type Class uint16
@@ -13,6 +16,16 @@ const (
 
 	STANDARD_QUERY    uint16 = 0x0100
 	STANDARD_RESPONSE uint16 = 0x8180
+
+	TRUNCATED uint16 = 0x0200
+	// AUTHENTIC_DATA tells the answer has been validated, or asks the upstream to tell it in a query (rfc6840 section 5.7)
+	AUTHENTIC_DATA uint16 = 0x0020
+
+	RCODE_MASK     uint16 = 0x000F
+	FORMAT_ERROR   uint16 = 0x0001
+	SERVER_FAILURE uint16 = 0x0002
+	NAME_ERROR     uint16 = 0x0003
+	REFUSED        uint16 = 0x0005
 )
 
 //Message represent a simplify dns message
@@ -21,8 +34,13 @@ type Message struct {
 	Header        uint16
 	QuestionCount uint16
 	ResponseCount uint16
-	Question      []Question
-	Response      []Record
+	// AuthorityCount and AdditionalCount are the sizes of the sections following the answers
+	AuthorityCount  uint16
+	AdditionalCount uint16
+	Question        []Question
+	Response        []Record
+	Authority       []Record
+	Additional      []Record
 }
 
 //Question is a representation of a dns question
@@ -39,4 +57,67 @@ type Record struct {
 	Class Class
 	TTL   uint32
 	Data  net.IP
+	// Raw is the data of the records which are not addresses, in wire format with uncompressed names
+	Raw []byte
+}
+
+// FindAnswer returns the first record of the response with the given type, skipping the CNAME chain leading to it
+func FindAnswer(message *Message, t Type) (Record, bool) {
+	for _, record := range message.Response {
+		if record.Type == t {
+			return record, true
+		}
+	}
+	return Record{}, false
+}
+
+// FindAnswers returns all the records of the response with the given type, the RRset answering the question
+func FindAnswers(message *Message, t Type) []Record {
+	var res []Record
+	for _, record := range message.Response {
+		if record.Type == t {
+			res = append(res, record)
+		}
+	}
+	return res
+}
+
+// Aliases returns the targets of the CNAME chain of the response
+func Aliases(message *Message) []string {
+	var res []string
+	for _, record := range message.Response {
+		if record.Type == CNAME {
+			res = append(res, record.Value())
+		}
+	}
+	return res
+}
+
+// EmptyResponse returns the response to the query without records, with the flags and rcode of header
+func EmptyResponse(query Message, header uint16) Message {
+	return Message{
+		ID:            query.ID,
+		Header:        STANDARD_RESPONSE | header,
+		QuestionCount: query.QuestionCount,
+		Question:      query.Question,
+	}
+}
+
+// NegativeTTL returns the duration a negative answer can be cached, the minimum of the ttl
+// and of the minimum field of the SOA record of the authority section (rfc2308 section 5)
+func NegativeTTL(message *Message) uint32 {
+	for _, record := range message.Authority {
+		if record.Type == SOA {
+			return SOAMinimum(record)
+		}
+	}
+	return 0
+}
+
+// SOAMinimum returns the negative caching ttl of a SOA record, the minimum of its ttl and of its minimum field
+func SOAMinimum(record Record) uint32 {
+	if len(record.Raw) < 4 {
+		return 0
+	}
+	return min(record.TTL, binary.BigEndian.Uint32(record.Raw[len(record.Raw)-4:]))
 }
@@ -4,25 +4,23 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"log"
 	"net"
 	"strconv"
-	"strings"
 )
 
 const (
-	BufferMaxLength     = 255
+	BufferMaxLength     = EDNSPayloadSize
+	UDPMaxLength        = 512
+	MessageMaxLength    = 65535
 	bufferMinLength     = 12
 	bufferQuestionStart = 12
-
-	refStartByte = byte(192)
 )
 
 var _ error = &BufferTooLongException{0}
 
 // ParseMessage parse a message from a binary representation
 func ParseMessage(packet []byte) (*Message, error) {
-	if len(packet) > BufferMaxLength {
+	if len(packet) > MessageMaxLength {
 		return nil, &BufferTooLongException{len(packet)}
 	}
 	if len(packet) < bufferMinLength {
@@ -30,14 +28,20 @@ func ParseMessage(packet []byte) (*Message, error) {
 	}
 	message := &Message{} //create an empty message, it will be filled in future
 	if err := parseMetadata(packet, message); err != nil {
-		return nil, err
+		return nil, &malformedError{err}
 	}
 	offset, err := parseQuestion(packet, message)
 	if err != nil {
-		return nil, err
+		return nil, &malformedError{err}
+	}
+	if message.Response, offset, err = parseRecords(packet, offset, message.ResponseCount); err != nil {
+		return nil, &malformedError{err}
+	}
+	if message.Authority, offset, err = parseRecords(packet, offset, message.AuthorityCount); err != nil {
+		return nil, &malformedError{err}
 	}
-	if err := parseResponse(packet, message, offset); err != nil {
-		return nil, err
+	if message.Additional, _, err = parseRecords(packet, offset, message.AdditionalCount); err != nil {
+		return nil, &malformedError{err}
 	}
 	return message, nil
 }
@@ -47,17 +51,12 @@ func parseMetadata(packet []byte, message *Message) error {
 	message.Header = binary.BigEndian.Uint16(packet[2:4])
 	message.QuestionCount = binary.BigEndian.Uint16(packet[4:6])
 	message.ResponseCount = binary.BigEndian.Uint16(packet[6:8])
-	if binary.BigEndian.Uint16(packet[8:10]) != 0 {
-		log.Println(errors.New("authority rrs not supported"))
-	}
-	if binary.BigEndian.Uint16(packet[10:12]) != 0 {
-		log.Println(errors.New("additional rrs not supported"))
-	}
+	message.AuthorityCount = binary.BigEndian.Uint16(packet[8:10])
+	message.AdditionalCount = binary.BigEndian.Uint16(packet[10:12])
 	return nil
 }
 
 func parseQuestion(packet []byte, message *Message) (int, error) {
-	readedBytes := 0
 	buffer := bytes.NewBuffer(packet[bufferQuestionStart:])
 
 	for i := 0; i < int(message.QuestionCount); i++ {
@@ -93,124 +92,101 @@ func parseQuestion(packet []byte, message *Message) (int, error) {
 		question.Class = Class(binary.BigEndian.Uint16(twoBytes))
 
 		message.Question = append(message.Question, question)
-		readedBytes += 6 + len(question.Name)
 	}
-	return readedBytes + bufferQuestionStart, nil
+	return len(packet) - buffer.Len(), nil
 }
 
-func parseResponse(packet []byte, message *Message, offset int) error {
+// parseRecords reads count records starting at offset, it returns them with the offset following the last one
+func parseRecords(packet []byte, offset int, count uint16) ([]Record, int, error) {
+	if count == 0 {
+		return nil, offset, nil
+	}
 	buffer := bytes.NewBuffer(packet[offset:])
+	records := make([]Record, 0, count)
 
-	for i := 0; i < int(message.ResponseCount); i++ {
+	for i := 0; i < int(count); i++ {
 		response := Record{}
 
 		namestart, err := buffer.ReadByte()
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 		response.Name, err = readName(namestart, buffer, packet)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 
 		twoBytes := make([]byte, 2)
 		n, err := buffer.Read(twoBytes)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 		if n != 2 {
-			return errors.New("bad read response type")
+			return nil, 0, errors.New("bad read response type")
 		}
 		response.Type = Type(binary.BigEndian.Uint16(twoBytes))
 
 		n, err = buffer.Read(twoBytes)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 		if n != 2 {
-			return errors.New("bad read response class")
+			return nil, 0, errors.New("bad read response class")
 		}
 		response.Class = Class(binary.BigEndian.Uint16(twoBytes))
 
 		ttlBuffer := make([]byte, 4)
 		n, err = buffer.Read(ttlBuffer)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 		if n != 4 {
-			return errors.New("bad read response TTL")
+			return nil, 0, errors.New("bad read response TTL")
 		}
 		response.TTL = binary.BigEndian.Uint32(ttlBuffer)
 
 		n, err = buffer.Read(twoBytes)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 		if n != 2 {
-			return errors.New("bad read response data length")
+			return nil, 0, errors.New("bad read response data length")
 		}
 		dataLength := binary.BigEndian.Uint16(twoBytes)
+		dataOffset := len(packet) - buffer.Len()
 		data := make([]byte, dataLength)
 		n, err = buffer.Read(data)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 		if n != int(dataLength) {
-			return errors.New("bad read response data")
+			return nil, 0, errors.New("bad read response data")
 		}
 
-		response.Data, err = parseAddress(data, response.Type)
+		if response.Type == A || response.Type == AAAA {
+			response.Data, err = parseAddress(data, response.Type)
+		} else {
+			response.Raw, err = decodeRData(packet, dataOffset, int(dataLength), response.Type)
+		}
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 
-		message.Response = append(message.Response, response)
+		records = append(records, response)
 	}
 
-	return nil
+	return records, len(packet) - buffer.Len(), nil
 }
 
+// readName reads the name starting with namestart, already read from buffer, and following compression pointers
 func readName(namestart byte, buffer *bytes.Buffer, packet []byte) (string, error) {
-	if namestart == refStartByte {
-		ref, err := buffer.ReadByte()
-		if err != nil {
-			return "", err
-		}
-		parts := make([]byte, 2)
-		parts[0] = namestart
-		parts[1] = ref
-		return parseName(parts, packet), nil
-	}
-	parts, err := buffer.ReadBytes(0)
-	parts = parts[0 : len(parts)-1]
+	offset := len(packet) - buffer.Len() - 1
+	name, next, err := decodeName(packet, offset)
 	if err != nil {
 		return "", err
 	}
-	allParts := make([]byte, 0, len(parts)+1)
-	allParts = append(allParts, namestart)
-	allParts = append(allParts, parts...)
-	return parseName(allParts, packet), nil
-}
-
-func parseName(parts []byte, packet []byte) string {
-	start := parts[0]
-	if start == refStartByte {
-		buffer := bytes.NewBuffer(packet[parts[1]:])
-		bytes, _ := buffer.ReadBytes(0)
-		return parseAllParts(bytes[0 : len(bytes)-1])
-	}
-	return parseAllParts(parts)
-}
-
-func parseAllParts(parts []byte) string {
-	var sb strings.Builder
-	size := int(parts[0])
-	sb.Write(parts[1 : size+1])
-	if len(parts) > size+1 {
-		sb.WriteRune('.')
-		sb.WriteString(parseAllParts(parts[size+1:]))
-	}
-	return sb.String()
+	buffer.Next(next - offset - 1)
+	return name, nil
 }
 
 func parseAddress(data []byte, t Type) (net.IP, error) {
@@ -231,5 +207,10 @@ type BufferTooLongException struct {
 
 // Error returns the string of the current error
 func (b *BufferTooLongException) Error() string {
-	return "the length of the buffer" + strconv.Itoa(b.len) + "is too long, maximum length is " + strconv.Itoa(BufferMaxLength)
+	return "the length of the buffer" + strconv.Itoa(b.len) + "is too long, maximum length is " + strconv.Itoa(MessageMaxLength)
+}
+
+// Is tells the buffer is an ErrMalformed
+func (b *BufferTooLongException) Is(target error) bool {
+	return target == ErrMalformed
 }
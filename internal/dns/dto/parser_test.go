@@ -1,8 +1,11 @@
 package dto_test
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"net"
+	"reflect"
 	"testing"
 
 	"github.com/bluguard/dnshield/internal/dns/dto"
@@ -94,6 +97,35 @@ func TestParseRequest(t *testing.T) {
 
 }
 
+func TestParseMessage_Malformed(t *testing.T) {
+	query := dto.SerializeMessage(dto.Message{ID: 9, Header: dto.STANDARD_QUERY, QuestionCount: 1, Question: []dto.Question{{Name: "example.com", Type: dto.A, Class: dto.IN}}})
+	for name, packet := range map[string][]byte{
+		"short":              query[:8],
+		"truncated question": query[:len(query)-2],
+		"missing record":     append(append([]byte{}, query[:7]...), append([]byte{1}, query[8:]...)...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := dto.ParseMessage(packet)
+			if !errors.Is(err, dto.ErrMalformed) {
+				t.Fatalf("expecting a malformed message, got %v", err)
+			}
+			response, ok := dto.FormatErrorResponse(packet)
+			if name == "short" {
+				if ok {
+					t.Fatal("a packet without header cannot be answered")
+				}
+				return
+			}
+			if !ok || response.ID != 9 || response.Header&dto.RCODE_MASK != dto.FORMAT_ERROR {
+				t.Errorf("unexpected response %v", response)
+			}
+		})
+	}
+	if _, ok := dto.FormatErrorResponse(dto.SerializeMessage(dto.Message{ID: 9, Header: dto.STANDARD_RESPONSE})); ok {
+		t.Error("a response must not be answered")
+	}
+}
+
 func testParse(in []byte, out dto.Message, t *testing.T) {
 	message, err := dto.ParseMessage(in)
 	if err != nil {
@@ -169,6 +201,59 @@ func assertMessageEquals(message *dto.Message, out dto.Message, t *testing.T) {
 	}
 }
 
+func TestSerializeMessage_Compression(t *testing.T) {
+	// the answer points to the name of the question, like the response of an upstream
+	response := benchCase.out
+	response.Response = []dto.Record{{Name: "google.com", Type: dto.A, Class: dto.IN, TTL: 212, Data: net.ParseIP("142.250.184.206").To4()}}
+	if got := dto.SerializeMessage(response); !bytes.Equal(got, benchCase.in) {
+		t.Errorf("expecting %x, got %x", benchCase.in, got)
+	}
+
+	mustRData := func(t dto.Type, value string) []byte {
+		res, err := dto.ParseRData(t, value)
+		if err != nil {
+			panic(err)
+		}
+		return res
+	}
+	message := dto.Message{
+		ID:              7,
+		Header:          dto.STANDARD_RESPONSE,
+		QuestionCount:   1,
+		ResponseCount:   2,
+		AuthorityCount:  1,
+		AdditionalCount: 3,
+		Question:        []dto.Question{{Name: "www.example.com", Type: dto.A, Class: dto.IN}},
+		Response: []dto.Record{
+			{Name: "www.example.com", Type: dto.CNAME, Class: dto.IN, TTL: 60, Raw: mustRData(dto.CNAME, "web.Example.com")},
+			{Name: "web.Example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.IPv4(192, 0, 2, 1).To4()},
+		},
+		Authority: []dto.Record{{Name: "example.com", Type: dto.SOA, Class: dto.IN, TTL: 300, Raw: mustRData(dto.SOA, "ns1.example.com hostmaster.example.com 1 7200 3600 1209600 300")}},
+		Additional: []dto.Record{
+			{Name: "_sip._udp.example.com", Type: dto.SRV, Class: dto.IN, TTL: 60, Raw: mustRData(dto.SRV, "10 5 5060 sip.example.com")},
+			{Name: "example.com", Type: dto.Type(99), Class: dto.IN, TTL: 60, Raw: []byte("opaque")},
+			{Name: "", Type: dto.OPT, Class: dto.Class(dto.EDNSPayloadSize)},
+		},
+	}
+	payload := dto.SerializeMessage(message)
+	got, err := dto.ParseMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Question, message.Question) || !reflect.DeepEqual(got.Response, message.Response) ||
+		!reflect.DeepEqual(got.Authority, message.Authority) || !reflect.DeepEqual(got.Additional, message.Additional) {
+		t.Errorf("expecting the sections to round-trip, got %+v", got)
+	}
+	// the names of the SRV record are never compressed
+	if !bytes.Contains(payload, message.Additional[0].Raw) {
+		t.Errorf("expecting the data of the SRV record as is")
+	}
+	// the other names point to the question, the one of the SRV record aside, web.Example.com keeps its case
+	if n := bytes.Count(payload, []byte("\x07example\x03com\x00")); n != 2 {
+		t.Errorf("expecting the domain in the question and the SRV record only, got %d times in %x", n, payload)
+	}
+}
+
 var benchCase = testCase{
 	name: "google.com response type A",
 	in:   decodeString("96a68180000100010000000006676f6f676c6503636f6d0000010001c00c00010001000000d400048efab8ce"),
@@ -188,3 +273,9 @@ func BenchmarkParser(b *testing.B) {
 		dto.ParseMessage(benchCase.in)
 	}
 }
+
+func BenchmarkSerializer(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		dto.SerializeMessage(benchCase.out)
+	}
+}
@@ -0,0 +1,285 @@
+package dto
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxPointers bounds the number of compression pointers followed while reading a name, to stop on loops
+const maxPointers = 16
+
+// Value returns the presentation format of the data of the record
+func (r Record) Value() string {
+	switch r.Type {
+	case A, AAAA:
+		return r.Data.String()
+	}
+	value, err := formatRData(r.Type, r.Raw)
+	if err != nil {
+		return unknownRData(r.Raw)
+	}
+	return value
+}
+
+// ParseRData converts the presentation format of the data of a record of the given type to its wire format,
+// for the types which are not addresses
+func ParseRData(t Type, value string) ([]byte, error) {
+	fields := strings.Fields(value)
+	switch t {
+	case CNAME, NS, PTR:
+		if len(fields) != 1 {
+			return nil, errors.New("bad " + t.String() + " data " + value)
+		}
+		return encodeName(nil, fields[0])
+	case MX:
+		if len(fields) != 2 {
+			return nil, errors.New("bad MX data " + value)
+		}
+		res, err := appendUint16s(nil, fields[:1])
+		if err != nil {
+			return nil, err
+		}
+		return encodeName(res, fields[1])
+	case SRV:
+		if len(fields) != 4 {
+			return nil, errors.New("bad SRV data " + value)
+		}
+		res, err := appendUint16s(nil, fields[:3])
+		if err != nil {
+			return nil, err
+		}
+		return encodeName(res, fields[3])
+	case SOA:
+		if len(fields) != 7 {
+			return nil, errors.New("bad SOA data " + value)
+		}
+		res, err := encodeName(nil, fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if res, err = encodeName(res, fields[1]); err != nil {
+			return nil, err
+		}
+		for _, field := range fields[2:] {
+			n, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			res = binary.BigEndian.AppendUint32(res, uint32(n))
+		}
+		return res, nil
+	case TXT:
+		return encodeStrings(value)
+	}
+	if len(fields) >= 2 && fields[0] == `\#` {
+		return hex.DecodeString(strings.Join(fields[2:], ""))
+	}
+	return nil, errors.New("unsupported data for type " + t.String())
+}
+
+// decodeRData reads the data of a record of the given type starting at offset in the packet,
+// the compressed names are expanded so the data stays valid outside of the packet
+func decodeRData(packet []byte, offset, length int, t Type) ([]byte, error) {
+	end := offset + length
+	if end > len(packet) {
+		return nil, errors.New("bad read response data")
+	}
+	prefix, names, suffix := rdataNames(t)
+	if names == 0 {
+		// other types do not use compression (rfc3597 section 4)
+		return append([]byte(nil), packet[offset:end]...), nil
+	}
+	if offset+prefix > end {
+		return nil, errors.New("bad " + t.String() + " data")
+	}
+	res := append([]byte(nil), packet[offset:offset+prefix]...)
+	position := offset + prefix
+	for i := 0; i < names; i++ {
+		name, next, err := decodeName(packet, position)
+		if err != nil {
+			return nil, err
+		}
+		if res, err = encodeName(res, name); err != nil {
+			return nil, err
+		}
+		position = next
+	}
+	if position+suffix != end {
+		return nil, errors.New("bad " + t.String() + " data")
+	}
+	return append(res, packet[position:end]...), nil
+}
+
+// rdataNames returns the layout of the data of the types with names: the size of the fixed fields before the names,
+// the number of names and the size of the fixed fields after them, no name for the other types
+func rdataNames(t Type) (int, int, int) {
+	switch t {
+	case CNAME, NS, PTR:
+		return 0, 1, 0
+	case MX:
+		return 2, 1, 0
+	case SRV:
+		return 6, 1, 0
+	case SOA:
+		return 0, 2, 20
+	}
+	return 0, 0, 0
+}
+
+// decodeName reads the possibly compressed name at offset, it returns the name and the offset following it
+func decodeName(packet []byte, offset int) (string, int, error) {
+	labels := make([]string, 0, 4)
+	next := -1
+	for pointers := 0; ; {
+		if offset >= len(packet) {
+			return "", 0, errors.New("bad read name")
+		}
+		size := int(packet[offset])
+		switch {
+		case size == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case size&0xC0 == 0xC0:
+			if offset+1 >= len(packet) || pointers >= maxPointers {
+				return "", 0, errors.New("bad name pointer")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			pointers++
+			offset = int(binary.BigEndian.Uint16(packet[offset:]) & 0x3FFF)
+		default:
+			if offset+1+size > len(packet) {
+				return "", 0, errors.New("bad read name")
+			}
+			labels = append(labels, string(packet[offset+1:offset+1+size]))
+			offset += 1 + size
+		}
+	}
+}
+
+// encodeName appends the uncompressed wire format of the name to buffer
+func encodeName(buffer []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, errors.New("bad name " + name)
+			}
+			buffer = append(buffer, byte(len(label)))
+			buffer = append(buffer, label...)
+		}
+	}
+	return append(buffer, 0), nil
+}
+
+func formatRData(t Type, raw []byte) (string, error) {
+	switch t {
+	case CNAME, NS, PTR:
+		name, _, err := decodeName(raw, 0)
+		return name, err
+	case MX:
+		if len(raw) < 3 {
+			return "", errors.New("bad MX data")
+		}
+		name, _, err := decodeName(raw, 2)
+		return strconv.Itoa(int(binary.BigEndian.Uint16(raw))) + " " + name, err
+	case SRV:
+		if len(raw) < 7 {
+			return "", errors.New("bad SRV data")
+		}
+		name, _, err := decodeName(raw, 6)
+		return strconv.Itoa(int(binary.BigEndian.Uint16(raw))) + " " +
+			strconv.Itoa(int(binary.BigEndian.Uint16(raw[2:]))) + " " +
+			strconv.Itoa(int(binary.BigEndian.Uint16(raw[4:]))) + " " + name, err
+	case SOA:
+		mname, next, err := decodeName(raw, 0)
+		if err != nil {
+			return "", err
+		}
+		rname, next, err := decodeName(raw, next)
+		if err != nil {
+			return "", err
+		}
+		if len(raw)-next != 20 {
+			return "", errors.New("bad SOA data")
+		}
+		fields := []string{mname, rname}
+		for i := next; i < len(raw); i += 4 {
+			fields = append(fields, strconv.FormatUint(uint64(binary.BigEndian.Uint32(raw[i:])), 10))
+		}
+		return strings.Join(fields, " "), nil
+	case TXT:
+		parts := make([]string, 0, 1)
+		for i := 0; i < len(raw); {
+			size := int(raw[i])
+			if i+1+size > len(raw) {
+				return "", errors.New("bad TXT data")
+			}
+			parts = append(parts, strconv.Quote(string(raw[i+1:i+1+size])))
+			i += 1 + size
+		}
+		return strings.Join(parts, " "), nil
+	}
+	return unknownRData(raw), nil
+}
+
+// unknownRData returns the generic presentation format of rfc3597
+func unknownRData(raw []byte) string {
+	return `\# ` + strconv.Itoa(len(raw)) + " " + hex.EncodeToString(raw)
+}
+
+func appendUint16s(buffer []byte, fields []string) ([]byte, error) {
+	for _, field := range fields {
+		n, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		buffer = binary.BigEndian.AppendUint16(buffer, uint16(n))
+	}
+	return buffer, nil
+}
+
+// encodeStrings encodes a list of quoted strings, an unquoted value is a single string
+func encodeStrings(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, `"`) {
+		value = strconv.Quote(value)
+	}
+	res := make([]byte, 0, len(value))
+	for value != "" {
+		quoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		s, _ := strconv.Unquote(quoted)
+		if len(s) > 255 {
+			return nil, errors.New("TXT string too long")
+		}
+		res = append(res, byte(len(s)))
+		res = append(res, s...)
+		value = strings.TrimSpace(value[len(quoted):])
+	}
+	return res, nil
+}
+
+// ReverseName returns the name of the PTR record of the address, under in-addr.arpa or ip6.arpa
+func ReverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return strconv.Itoa(int(v4[3])) + "." + strconv.Itoa(int(v4[2])) + "." +
+			strconv.Itoa(int(v4[1])) + "." + strconv.Itoa(int(v4[0])) + ".in-addr.arpa"
+	}
+	nibbles := hex.EncodeToString(ip.To16())
+	var res strings.Builder
+	for i := len(nibbles) - 1; i >= 0; i-- {
+		res.WriteByte(nibbles[i])
+		res.WriteByte('.')
+	}
+	return res.String() + "ip6.arpa"
+}
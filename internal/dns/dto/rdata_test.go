@@ -0,0 +1,126 @@
+package dto_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestParseCNAMEChain(t *testing.T) {
+	// www.example.com A answered by www.example.com CNAME cdn.example.com, cdn.example.com A 10.0.0.1
+	// both the CNAME data and the second owner name are compressed
+	packet := decodeString("12348180000100020000000003777777076578616d706c6503636f6d0000010001" +
+		"c00c000500010000003c00060363646ec010" +
+		"c02d000100010000003c00040a000001")
+
+	message, err := dto.ParseMessage(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dto.Record{
+		{Name: "www.example.com", Type: dto.CNAME, Class: dto.IN, TTL: 60, Raw: decodeString("0363646e076578616d706c6503636f6d00")},
+		{Name: "cdn.example.com", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.1").To4()},
+	}
+	if !reflect.DeepEqual(message.Response, want) {
+		t.Fatalf("ParseMessage() = %v, want %v", message.Response, want)
+	}
+	if value := message.Response[0].Value(); value != "cdn.example.com" {
+		t.Errorf("Value() = %s", value)
+	}
+	if record, ok := dto.FindAnswer(message, dto.A); !ok || !record.Data.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("FindAnswer() = %v, %v", record, ok)
+	}
+	if aliases := dto.Aliases(message); !reflect.DeepEqual(aliases, []string{"cdn.example.com"}) {
+		t.Errorf("Aliases() = %v", aliases)
+	}
+
+	// the expanded data stays valid once serialized in another message
+	reparsed, err := dto.ParseMessage(dto.SerializeMessage(*message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reparsed.Response, want) {
+		t.Errorf("round trip = %v, want %v", reparsed.Response, want)
+	}
+}
+
+func TestParseRData(t *testing.T) {
+	tests := []struct {
+		t     dto.Type
+		value string
+		want  string
+	}{
+		{t: dto.CNAME, value: "cdn.example.com.", want: "cdn.example.com"},
+		{t: dto.NS, value: "ns1.example.com"},
+		{t: dto.PTR, value: "localhost"},
+		{t: dto.MX, value: "10 mail.example.com"},
+		{t: dto.SRV, value: "1 5 443 server.example.com"},
+		{t: dto.SOA, value: "ns1.example.com hostmaster.example.com 2024010101 7200 3600 1209600 300"},
+		{t: dto.TXT, value: `"v=spf1 -all" "second"`},
+		{t: dto.TXT, value: "unquoted text", want: `"unquoted text"`},
+		{t: dto.Type(99), value: `\# 2 abcd`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.t.String(), func(t *testing.T) {
+			raw, err := dto.ParseRData(tt.t, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.value
+			}
+			if got := (dto.Record{Type: tt.t, Raw: raw}).Value(); got != want {
+				t.Errorf("Value() = %s, want %s", got, want)
+			}
+		})
+	}
+
+	if _, err := dto.ParseRData(dto.MX, "mail.example.com"); err == nil {
+		t.Errorf("expecting an error for an MX without preference")
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	raw, err := dto.ParseRData(dto.SOA, "ns1.example.com hostmaster.example.com 1 7200 3600 1209600 300")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ttl  uint32
+		want uint32
+	}{
+		{name: "minimum field", ttl: 3600, want: 300},
+		{name: "record ttl", ttl: 60, want: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &dto.Message{Authority: []dto.Record{{Name: "example.com", Type: dto.SOA, Class: dto.IN, TTL: tt.ttl, Raw: raw}}}
+			if got := dto.NegativeTTL(message); got != tt.want {
+				t.Errorf("NegativeTTL() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	if got := dto.NegativeTTL(&dto.Message{}); got != 0 {
+		t.Errorf("NegativeTTL() without SOA = %d, want 0", got)
+	}
+}
+
+func TestEmptyResponse(t *testing.T) {
+	query := dto.Message{
+		ID:            7,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "example.com", Type: dto.A, Class: dto.IN}},
+	}
+	res, err := dto.ParseMessage(dto.SerializeMessage(dto.EmptyResponse(query, dto.TRUNCATED)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != 7 || res.Header&dto.TRUNCATED == 0 || res.ResponseCount != 0 || res.Question[0].Name != "example.com" {
+		t.Fatalf("expecting a truncated answer to the question, got %v", res)
+	}
+}
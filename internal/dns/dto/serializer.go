@@ -7,47 +7,111 @@ import (
 	"strings"
 )
 
-//SerializeMessage serialize a DNS message into a binary representation
+// maxPointerOffset is the last offset of the message a compression pointer can point to, on 14 bits
+const maxPointerOffset = 0x3FFF
+
+// SerializeMessage serialize a DNS message into a binary representation, the names are compressed (rfc1035 section 4.1.4)
 func SerializeMessage(message Message) []byte {
 	var buffer bytes.Buffer
+	names := compressor{}
 
 	writeUint16(message.ID, &buffer)
 	writeUint16(message.Header, &buffer)
 	writeUint16(message.QuestionCount, &buffer)
 	writeUint16(message.ResponseCount, &buffer)
-	writeUint32(0, &buffer) // additionals rrs and authority rrs
+	writeUint16(message.AuthorityCount, &buffer)
+	writeUint16(message.AdditionalCount, &buffer)
 	for _, question := range message.Question {
-		writeQuestion(question, &buffer)
+		names.writeQuestion(question, &buffer)
 	}
 
 	for _, response := range message.Response {
-		writeResponse(response, &buffer)
+		names.writeResponse(response, &buffer)
+	}
+	for _, response := range message.Authority {
+		names.writeResponse(response, &buffer)
+	}
+	for _, response := range message.Additional {
+		names.writeResponse(response, &buffer)
 	}
 
 	return buffer.Bytes()
 }
 
-func writeQuestion(question Question, buffer *bytes.Buffer) {
-	writeName(question.Name, buffer)
+// compressor remembers the offsets of the names written in the message, a name ending like one of them is written
+// with a pointer to it. The names are compared with their case, so the case of every name is kept
+type compressor map[string]int
+
+func (c compressor) writeQuestion(question Question, buffer *bytes.Buffer) {
+	c.writeName(question.Name, buffer)
 	writeUint16(uint16(question.Type), buffer)
 	writeUint16(uint16(question.Class), buffer)
 }
 
-func writeResponse(response Record, buffer *bytes.Buffer) {
-	writeName(response.Name, buffer)
+func (c compressor) writeResponse(response Record, buffer *bytes.Buffer) {
+	c.writeName(response.Name, buffer)
 	writeUint16(uint16(response.Type), buffer)
 	writeUint16(uint16(response.Class), buffer)
 	writeUint32(response.TTL, buffer)
-	writeData(response.Type, response.Data, buffer)
+	if response.Type == A || response.Type == AAAA {
+		writeData(response.Type, response.Data, buffer)
+	} else if !c.writeRData(response, buffer) {
+		writeUint16(uint16(len(response.Raw)), buffer)
+		buffer.Write(response.Raw)
+	}
+}
+
+// writeRData writes the data of the record with its names compressed, for the types of rfc1035 only: the names of
+// the other types are never compressed (rfc3597 section 4). It returns false when the data is left to the caller
+func (c compressor) writeRData(response Record, buffer *bytes.Buffer) bool {
+	if response.Type == SRV {
+		return false
+	}
+	prefix, count, suffix := rdataNames(response.Type)
+	if count == 0 || len(response.Raw) < prefix {
+		return false
+	}
+	names := make([]string, 0, count)
+	position := prefix
+	for i := 0; i < count; i++ {
+		name, next, err := decodeName(response.Raw, position)
+		if err != nil {
+			return false
+		}
+		names = append(names, name)
+		position = next
+	}
+	if position+suffix != len(response.Raw) {
+		return false
+	}
+	start := buffer.Len()
+	writeUint16(0, buffer) // the length, once the data is written
+	buffer.Write(response.Raw[:prefix])
+	for _, name := range names {
+		c.writeName(name, buffer)
+	}
+	buffer.Write(response.Raw[position:])
+	binary.BigEndian.PutUint16(buffer.Bytes()[start:], uint16(buffer.Len()-start-2))
+	return true
 }
 
-func writeName(s string, buffer *bytes.Buffer) {
-	nameParts := strings.Split(s, ".")
-	for _, p := range nameParts {
-		buffer.WriteByte(uint8(len(p)))
-		buffer.Write([]byte(p))
+// writeName writes the labels of the name until the rest of the name is one already written, which is pointed to
+func (c compressor) writeName(s string, buffer *bytes.Buffer) {
+	s = strings.TrimSuffix(s, ".")
+	for s != "" {
+		if offset, ok := c[s]; ok {
+			writeUint16(0xC000|uint16(offset), buffer)
+			return
+		}
+		if buffer.Len() <= maxPointerOffset {
+			c[s] = buffer.Len()
+		}
+		label, rest, _ := strings.Cut(s, ".")
+		buffer.WriteByte(uint8(len(label)))
+		buffer.WriteString(label)
+		s = rest
 	}
-	buffer.WriteByte(0)
+	buffer.WriteByte(0) // the root, alone for the name of the OPT record
 }
 
 func writeData(t Type, iP net.IP, buffer *bytes.Buffer) {
@@ -0,0 +1,77 @@
+package dto
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	NS    Type = 2
+	CNAME Type = 5
+	SOA   Type = 6
+	PTR   Type = 12
+	MX    Type = 15
+	TXT   Type = 16
+	SRV   Type = 33
+	OPT   Type = 41
+	SVCB  Type = 64
+	HTTPS Type = 65
+	ANY   Type = 255
+)
+
+const unknownTypePrefix = "TYPE"
+
+var typeNames = map[Type]string{
+	A:     "A",
+	NS:    "NS",
+	CNAME: "CNAME",
+	SOA:   "SOA",
+	PTR:   "PTR",
+	MX:    "MX",
+	TXT:   "TXT",
+	AAAA:  "AAAA",
+	SRV:   "SRV",
+	OPT:   "OPT",
+	SVCB:  "SVCB",
+	HTTPS: "HTTPS",
+	ANY:   "ANY",
+}
+
+// String returns the mnemonic of the type, or TYPEn for the unknown types (rfc3597)
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return unknownTypePrefix + strconv.Itoa(int(t))
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t Type) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *Type) UnmarshalText(text []byte) error {
+	res, err := ParseType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = res
+	return nil
+}
+
+// ParseType parses a type given by its mnemonic, its number or its TYPEn representation
+func ParseType(s string) (Type, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for t, name := range typeNames {
+		if name == s {
+			return t, nil
+		}
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, unknownTypePrefix), 10, 16)
+	if err != nil {
+		return 0, errors.New("unknown type " + s)
+	}
+	return Type(n), nil
+}
@@ -0,0 +1,37 @@
+package dto_test
+
+import (
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    dto.Type
+		wantErr bool
+	}{
+		{in: "A", want: dto.A},
+		{in: "aaaa", want: dto.AAAA},
+		{in: "65", want: dto.HTTPS},
+		{in: "TYPE1234", want: dto.Type(1234)},
+		{in: "NOTATYPE", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := dto.ParseType(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseType() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr {
+				if back, _ := dto.ParseType(got.String()); back != got {
+					t.Errorf("ParseType(%v.String()) = %v", got, back)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Package fingerprint infers rough device categories of the clients from the names they ask,
+// like the push domains of the Apple devices or the connectivity checks of Android, to identify the unnamed clients
+package fingerprint
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Category is a rough kind of device
+type Category string
+
+const (
+	Apple       Category = "apple"
+	Android     Category = "android"
+	Windows     Category = "windows"
+	Linux       Category = "linux"
+	Amazon      Category = "amazon"
+	PlayStation Category = "playstation"
+	Xbox        Category = "xbox"
+	Nintendo    Category = "nintendo"
+	SmartTV     Category = "smart-tv"
+)
+
+// defaultMaxClients is the number of clients tracked when no maximum is given
+const defaultMaxClients = 1000
+
+// signatures are the names asked by the devices of a category, a name matches itself and its subdomains
+var signatures = map[string]Category{
+	"push.apple.com":                  Apple,
+	"captive.apple.com":               Apple,
+	"mesu.apple.com":                  Apple,
+	"gateway.icloud.com":              Apple,
+	"connectivitycheck.gstatic.com":   Android,
+	"connectivitycheck.android.com":   Android,
+	"android.clients.google.com":      Android,
+	"android.googleapis.com":          Android,
+	"www.msftconnecttest.com":         Windows,
+	"www.msftncsi.com":                Windows,
+	"settings-win.data.microsoft.com": Windows,
+	"update.microsoft.com":            Windows,
+	"connectivity-check.ubuntu.com":   Linux,
+	"nmcheck.gnome.org":               Linux,
+	"networkcheck.kde.org":            Linux,
+	"fireoscaptiveportal.com":         Amazon,
+	"device-metrics-us.amazon.com":    Amazon,
+	"playstation.net":                 PlayStation,
+	"xboxlive.com":                    Xbox,
+	"nintendo.net":                    Nintendo,
+	"samsungcloudsolution.com":        SmartTV,
+	"lgtvsdp.com":                     SmartTV,
+	"roku.com":                        SmartTV,
+}
+
+// Hint is the category inferred for a client, with the number of names of every category it asked
+type Hint struct {
+	Client   string   `json:"client"`
+	Category Category `json:"category"`
+	// Confidence is the share of the matching names of the category, from 0 to 1
+	Confidence float64             `json:"confidence"`
+	Matches    map[Category]uint64 `json:"matches"`
+}
+
+// Fingerprints counts the names matching a signature asked by every client, it is safe for concurrent use.
+// Only the clients asking such names are tracked, up to a maximum. The methods of a nil Fingerprints do nothing
+type Fingerprints struct {
+	lock       sync.Mutex
+	maxClients int
+	clients    map[string]map[Category]uint64
+}
+
+// NewFingerprints instantiates empty fingerprints tracking up to maxClients clients, defaultMaxClients when zero
+func NewFingerprints(maxClients int) *Fingerprints {
+	if maxClients <= 0 {
+		maxClients = defaultMaxClients
+	}
+	return &Fingerprints{maxClients: maxClients, clients: make(map[string]map[Category]uint64)}
+}
+
+// Observe counts the name asked by the client when it matches a signature, the new clients are ignored once the maximum is reached
+func (f *Fingerprints) Observe(client net.IP, name string) {
+	if f == nil || client == nil {
+		return
+	}
+	category, ok := match(strings.ToLower(strings.TrimSuffix(name, ".")))
+	if !ok {
+		return
+	}
+	address := client.String()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	matches, ok := f.clients[address]
+	if !ok {
+		if len(f.clients) >= f.maxClients {
+			return
+		}
+		matches = make(map[Category]uint64)
+		f.clients[address] = matches
+	}
+	matches[category]++
+}
+
+// match returns the category of the signature of the lower case name or of its closest parent
+func match(name string) (Category, bool) {
+	for parent, found := name, true; found; _, parent, found = strings.Cut(parent, ".") {
+		if category, ok := signatures[parent]; ok {
+			return category, true
+		}
+	}
+	return "", false
+}
+
+// Hints returns the category of every tracked client, sorted by address
+func (f *Fingerprints) Hints() []Hint {
+	if f == nil {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	res := make([]Hint, 0, len(f.clients))
+	for address, matches := range f.clients {
+		res = append(res, newHint(address, matches))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Client < res[j].Client })
+	return res
+}
+
+// newHint returns the hint of the client, the category with the most matches, the first by name on ties
+func newHint(address string, matches map[Category]uint64) Hint {
+	res := Hint{Client: address, Matches: make(map[Category]uint64, len(matches))}
+	var total, best uint64
+	for category, count := range matches {
+		res.Matches[category] = count
+		total += count
+		if count > best || (count == best && category < res.Category) {
+			res.Category, best = category, count
+		}
+	}
+	res.Confidence = float64(best) / float64(total)
+	return res
+}
@@ -0,0 +1,52 @@
+package fingerprint
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprints_Hints(t *testing.T) {
+	f := NewFingerprints(2)
+	phone, laptop, tv := net.ParseIP("192.168.1.10"), net.ParseIP("192.168.1.20"), net.ParseIP("192.168.1.30")
+	for _, name := range []string{"1-courier.push.apple.com.", "example.com", "Captive.Apple.com", "www.msftconnecttest.com"} {
+		f.Observe(phone, name)
+	}
+	f.Observe(laptop, "connectivity-check.ubuntu.com")
+	f.Observe(laptop, "www.google.com")
+	f.Observe(nil, "push.apple.com")
+	// the maximum is reached, the new clients are ignored
+	f.Observe(tv, "api.roku.com")
+
+	want := []Hint{
+		{Client: "192.168.1.10", Category: Apple, Confidence: 2.0 / 3, Matches: map[Category]uint64{Apple: 2, Windows: 1}},
+		{Client: "192.168.1.20", Category: Linux, Confidence: 1, Matches: map[Category]uint64{Linux: 1}},
+	}
+	if got := f.Hints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Hints() = %v, want %v", got, want)
+	}
+	if (*Fingerprints)(nil).Hints() != nil {
+		t.Errorf("expecting no hint without fingerprints")
+	}
+}
+
+func Test_match(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   Category
+		wantOk bool
+	}{
+		{name: "connectivitycheck.gstatic.com", want: Android, wantOk: true},
+		{name: "eu.playstation.net", want: PlayStation, wantOk: true},
+		{name: "gstatic.com"},
+		{name: "notroku.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := match(tt.name)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("match() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
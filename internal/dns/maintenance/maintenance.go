@@ -0,0 +1,133 @@
+// Package maintenance switches the server to answer from its cache only, serving the expired records when it keeps them,
+// while the upstreams or the network are in maintenance. The clients can ask the TXT record of a status domain to know it
+package maintenance
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// statusTTL is the ttl of the answers of the status domain, short so the end of the maintenance is seen quickly
+const statusTTL = 5
+
+// defaultHint is the text of the status domain during a maintenance when the configuration does not set it
+const defaultHint = "planned maintenance, the answers come from the cache"
+
+// ErrMaintenance is the failure of the upstreams during the maintenance, for the questions the cache cannot answer
+var ErrMaintenance = errors.New("the upstreams are not used during the maintenance")
+
+var _ client.TypedClient = &StatusClient{}
+
+// Status is the state of the maintenance
+type Status struct {
+	Enabled bool      `json:"enabled"`
+	Reason  string    `json:"reason,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+// Switch turns the maintenance on and off, it is safe for concurrent use.
+// A nil Switch is never in maintenance
+type Switch struct {
+	status atomic.Pointer[Status]
+}
+
+// NewSwitch instantiates a switch out of maintenance
+func NewSwitch() *Switch {
+	res := &Switch{}
+	res.status.Store(&Status{})
+	return res
+}
+
+// Enabled tells whether the maintenance is in progress
+func (s *Switch) Enabled() bool {
+	return s != nil && s.status.Load().Enabled
+}
+
+// Status returns the state of the maintenance
+func (s *Switch) Status() Status {
+	if s == nil {
+		return Status{}
+	}
+	return *s.status.Load()
+}
+
+// Set starts or ends the maintenance at now and returns its new state, the start of a maintenance in progress is kept
+func (s *Switch) Set(enabled bool, reason string, now time.Time) Status {
+	next := Status{Enabled: enabled, Reason: reason}
+	if enabled {
+		next.Since = now
+		if current := s.status.Load(); current.Enabled {
+			next.Since = current.Since
+		}
+	} else {
+		next.Reason = ""
+	}
+	s.status.Store(&next)
+	return next
+}
+
+// StatusClient answers the TXT record of the status domain: the hint and the reason of the maintenance while
+// it is in progress, ok otherwise. The other names are left to the next clients
+type StatusClient struct {
+	maintenance *Switch
+	domain      string
+	hint        string
+}
+
+// NewStatusClient instantiates the client of the status domain, the default hint is used when hint is empty
+func NewStatusClient(maintenance *Switch, domain, hint string) *StatusClient {
+	if hint == "" {
+		hint = defaultHint
+	}
+	return &StatusClient{maintenance: maintenance, domain: normalize(domain), hint: hint}
+}
+
+// Resolve implements client.TypedClient
+func (c *StatusClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	if normalize(name) != c.domain {
+		return dto.Record{}, errors.New(name + " is not the status domain")
+	}
+	if t != dto.TXT {
+		return dto.Record{}, &client.NoDataError{Name: name, Type: t, TTL: statusTTL}
+	}
+	texts := []string{"ok"}
+	if status := c.maintenance.Status(); status.Enabled {
+		texts = []string{"maintenance", c.hint}
+		if status.Reason != "" {
+			texts = append(texts, status.Reason)
+		}
+	}
+	raw, err := dto.ParseRData(dto.TXT, quote(texts))
+	if err != nil {
+		return dto.Record{}, err
+	}
+	return dto.Record{Name: name, Type: dto.TXT, Class: dto.IN, TTL: statusTTL, Raw: raw}, nil
+}
+
+// ResolveV4 implements client.Client
+func (c *StatusClient) ResolveV4(name string) (dto.Record, error) {
+	return c.Resolve(name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *StatusClient) ResolveV6(name string) (dto.Record, error) {
+	return c.Resolve(name, dto.AAAA)
+}
+
+func quote(texts []string) string {
+	quoted := make([]string, len(texts))
+	for i, text := range texts {
+		quoted[i] = strconv.Quote(text)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
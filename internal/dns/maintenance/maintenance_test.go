@@ -0,0 +1,49 @@
+package maintenance
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestSwitch_Set(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	s := NewSwitch()
+	s.Set(true, "upstream migration", start)
+	status := s.Set(true, "network works", start.Add(time.Hour))
+	if !s.Enabled() || !status.Since.Equal(start) || status.Reason != "network works" {
+		t.Errorf("expecting the start of the maintenance to be kept, got %+v", status)
+	}
+	if status := s.Set(false, "done", start.Add(2*time.Hour)); status.Enabled || status.Reason != "" || s.Enabled() {
+		t.Errorf("expecting the maintenance to be ended, got %+v", status)
+	}
+	if (*Switch)(nil).Enabled() {
+		t.Errorf("expecting a nil switch never to be in maintenance")
+	}
+}
+
+func TestStatusClient_Resolve(t *testing.T) {
+	s := NewSwitch()
+	c := NewStatusClient(s, "status.dnshield.lan.", "")
+
+	got, err := c.Resolve("Status.dnshield.lan", dto.TXT)
+	if err != nil || got.Value() != `"ok"` {
+		t.Errorf("expecting ok out of maintenance, got %v, %v", got.Value(), err)
+	}
+	s.Set(true, "isp works", time.Now())
+	got, err = c.Resolve("status.dnshield.lan", dto.TXT)
+	if want := `"maintenance" "` + defaultHint + `" "isp works"`; err != nil || got.Value() != want {
+		t.Errorf("Resolve() = %v, %v, want %s", got.Value(), err, want)
+	}
+
+	var noData *client.NoDataError
+	if _, err := c.ResolveV4("status.dnshield.lan"); !errors.As(err, &noData) {
+		t.Errorf("expecting no address for the status domain, got %v", err)
+	}
+	if _, err := c.Resolve("example.com", dto.TXT); err == nil || errors.As(err, &noData) {
+		t.Errorf("expecting the other names to be left to the next clients, got %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+const namespace = "dnshield"
+
+// CacheCounters is a cache reporting its activity
+type CacheCounters interface {
+	Counters() (hits, misses, evictions uint64)
+	Len() int
+	Usage() (used, capacity int64)
+}
+
+// BlockerCounters is a blocker reporting its activity
+type BlockerCounters interface {
+	Blocked() uint64
+	Len() int
+	Memory() int
+}
+
+// FailureRate reports the questions answered with SERVFAIL during the last minute
+type FailureRate interface {
+	Rate() uint64
+	Firing() bool
+}
+
+// Metrics holds the prometheus collectors of a server, it is safe for concurrent use.
+// The recording methods of a nil Metrics do nothing
+type Metrics struct {
+	registry  *prometheus.Registry
+	queries   *prometheus.CounterVec
+	answers   *prometheus.CounterVec
+	failures  prometheus.Counter
+	durations *prometheus.HistogramVec
+	requests  *prometheus.CounterVec
+	bytes     *prometheus.CounterVec
+	limited   *prometheus.CounterVec
+	denied    *prometheus.CounterVec
+	misrouted *prometheus.CounterVec
+	dnssec    *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+	filtered  *prometheus.CounterVec
+	sockets   *sockets
+	pools     *pools
+
+	lock        sync.RWMutex
+	cache       CacheCounters
+	blocker     BlockerCounters
+	failureRate FailureRate
+}
+
+// NewMetrics instantiate the collectors in a dedicated registry
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "queries_total", Help: "Questions received, by type.",
+		}, []string{"type"}),
+		answers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "answers_total", Help: "Questions answered, by resolver.",
+		}, []string{"resolver"}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "failures_total", Help: "Questions no resolver was able to answer.",
+		}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "resolver_duration_seconds", Help: "Time spent in each resolver of the chain.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"resolver"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "endpoint_requests_total", Help: "Messages received, by endpoint.",
+		}, []string{"endpoint"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "endpoint_bytes_total", Help: "Bytes of the messages, by endpoint and direction.",
+		}, []string{"endpoint", "direction"}),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "rate_limited_total", Help: "Messages over the rate limit of their client, by endpoint and action.",
+		}, []string{"endpoint", "action"}),
+		denied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "acl_denied_total", Help: "Messages of the clients not allowed by the acl, by endpoint and action.",
+		}, []string{"endpoint", "action"}),
+		misrouted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "unexpected_destination_total", Help: "Responses not sent because they did not match a recent query, by endpoint and reason.",
+		}, []string{"endpoint", "reason"}),
+		dnssec: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "dnssec_answers_total", Help: "Answers of the upstreams with a DNSSEC policy, by outcome.",
+		}, []string{"outcome"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "errors_total", Help: "Failures of the resolvers and malformed queries of the endpoints, by source and kind.",
+		}, []string{"source", "kind"}),
+		filtered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "filtered_types_total", Help: "Questions of the filtered types, by type and answer.",
+		}, []string{"type", "answer"}),
+		sockets: newSockets(),
+		pools:   newPools(),
+	}
+	m.registry.MustRegister(m.queries, m.answers, m.failures, m.durations, m.requests, m.bytes, m.limited, m.denied, m.misrouted, m.dnssec, m.errors, m.filtered, m.sockets, m.pools)
+	m.registry.MustRegister(
+		m.cacheCounter("cache_hits_total", "Cache lookups answered.", func(c CacheCounters) float64 {
+			hits, _, _ := c.Counters()
+			return float64(hits)
+		}),
+		m.cacheCounter("cache_misses_total", "Cache lookups without entry.", func(c CacheCounters) float64 {
+			_, misses, _ := c.Counters()
+			return float64(misses)
+		}),
+		m.cacheCounter("cache_evictions_total", "Entries removed from the full cache before their expiry.", func(c CacheCounters) float64 {
+			_, _, evictions := c.Counters()
+			return float64(evictions)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "cache_entries", Help: "Entries in the cache.",
+		}, func() float64 {
+			if c := m.getCache(); c != nil {
+				return float64(c.Len())
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "cache_memory_bytes", Help: "Bytes taken by the entries of the cache.",
+		}, func() float64 {
+			if c := m.getCache(); c != nil {
+				used, _ := c.Usage()
+				return float64(used)
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "cache_capacity_bytes", Help: "Bytes the entries of the cache may take.",
+		}, func() float64 {
+			if c := m.getCache(); c != nil {
+				_, capacity := c.Usage()
+				return float64(capacity)
+			}
+			return 0
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace, Name: "blocked_total", Help: "Questions answered by the blocker.",
+		}, func() float64 {
+			if b := m.getBlocker(); b != nil {
+				return float64(b.Blocked())
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "failures_last_minute", Help: "Questions answered with SERVFAIL during the last minute.",
+		}, func() float64 {
+			if f := m.getFailures(); f != nil {
+				return float64(f.Rate())
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "failures_alert", Help: "1 while the questions answered with SERVFAIL are over the alert threshold.",
+		}, func() float64 {
+			if f := m.getFailures(); f != nil && f.Firing() {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "blocked_domains", Help: "Domains of the blocking lists.",
+		}, func() float64 {
+			if b := m.getBlocker(); b != nil {
+				return float64(b.Len())
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "blocker_memory_bytes", Help: "Memory allocated for the domains of the blocking lists.",
+		}, func() float64 {
+			if b := m.getBlocker(); b != nil {
+				return float64(b.Memory())
+			}
+			return 0
+		}),
+	)
+	return m
+}
+
+// SetCache set the cache reported by the metrics, it replaces the previous one on reconfiguration
+func (m *Metrics) SetCache(c CacheCounters) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cache = c
+}
+
+// SetBlocker set the blocker reported by the metrics, it replaces the previous one on reconfiguration
+func (m *Metrics) SetBlocker(b BlockerCounters) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.blocker = b
+}
+
+// SetFailureRate set the rate of the failures reported by the metrics
+func (m *Metrics) SetFailureRate(f FailureRate) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.failureRate = f
+}
+
+// Query counts a question of the given type received by the server
+func (m *Metrics) Query(t dto.Type) {
+	if m == nil {
+		return
+	}
+	m.queries.WithLabelValues(t.String()).Inc()
+}
+
+// Answer counts a question answered by the resolver with the given name
+func (m *Metrics) Answer(resolver string) {
+	if m == nil {
+		return
+	}
+	m.answers.WithLabelValues(resolver).Inc()
+}
+
+// Failure counts a question no resolver was able to answer
+func (m *Metrics) Failure() {
+	if m == nil {
+		return
+	}
+	m.failures.Inc()
+}
+
+// Observe records the time spent by a resolver on a question
+func (m *Metrics) Observe(resolver string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.durations.WithLabelValues(resolver).Observe(d.Seconds())
+}
+
+// Request counts a message received by an endpoint with the sizes of the query and of the response
+func (m *Metrics) Request(endpoint string, in, out int) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(endpoint).Inc()
+	m.bytes.WithLabelValues(endpoint, "in").Add(float64(in))
+	m.bytes.WithLabelValues(endpoint, "out").Add(float64(out))
+}
+
+// RateLimited counts a message over the rate limit of its client, dropped, slipped or refused
+func (m *Metrics) RateLimited(endpoint, action string) {
+	if m == nil {
+		return
+	}
+	m.limited.WithLabelValues(endpoint, action).Inc()
+}
+
+// Denied counts a message of a client not allowed by the acl, dropped or refused
+func (m *Metrics) Denied(endpoint, action string) {
+	if m == nil {
+		return
+	}
+	m.denied.WithLabelValues(endpoint, action).Inc()
+}
+
+// UnexpectedDestination counts a response withheld because its destination did not send a matching query recently
+func (m *Metrics) UnexpectedDestination(endpoint, reason string) {
+	if m == nil {
+		return
+	}
+	m.misrouted.WithLabelValues(endpoint, reason).Inc()
+}
+
+// Validation counts an answer of an upstream with a DNSSEC policy, secure, insecure or indeterminate
+func (m *Metrics) Validation(outcome string) {
+	if m == nil {
+		return
+	}
+	m.dnssec.WithLabelValues(outcome).Inc()
+}
+
+// Filtered counts a question of a filtered type, answered refused or empty
+func (m *Metrics) Filtered(t dto.Type, answer string) {
+	if m == nil {
+		return
+	}
+	m.filtered.WithLabelValues(t.String(), answer).Inc()
+}
+
+// Error counts the failure of a resolver or of an endpoint by kind: timeout, malformed or failure.
+// The sources without record and the blocked questions are not failures, they are not counted
+func (m *Metrics) Error(source string, err error) {
+	if m == nil || err == nil || errors.Is(err, client.ErrNotFound) || errors.Is(err, client.ErrBlocked) {
+		return
+	}
+	m.errors.WithLabelValues(source, errorKind(err)).Inc()
+}
+
+// errorKind returns the kind of a failure
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, client.ErrTimeout):
+		return "timeout"
+	case errors.Is(err, dto.ErrMalformed):
+		return "malformed"
+	}
+	return "failure"
+}
+
+// Handler returns the http handler serving the metrics in the prometheus format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) cacheCounter(name, help string, value func(CacheCounters) float64) prometheus.CounterFunc {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help}, func() float64 {
+		if c := m.getCache(); c != nil {
+			return value(c)
+		}
+		return 0
+	})
+}
+
+func (m *Metrics) getCache() CacheCounters {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.cache
+}
+
+func (m *Metrics) getBlocker() BlockerCounters {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.blocker
+}
+
+func (m *Metrics) getFailures() FailureRate {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.failureRate
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+type fakeCache struct{}
+
+func (fakeCache) Counters() (uint64, uint64, uint64) { return 3, 2, 1 }
+func (fakeCache) Len() int                           { return 7 }
+func (fakeCache) Usage() (int64, int64)              { return 1400, 5000 }
+
+type fakeFailures struct{}
+
+func (fakeFailures) Rate() uint64 { return 12 }
+func (fakeFailures) Firing() bool { return true }
+
+type fakeBlocker struct{}
+
+func (fakeBlocker) Blocked() uint64 { return 4 }
+func (fakeBlocker) Len() int        { return 1000 }
+func (fakeBlocker) Memory() int     { return 32000 }
+
+type fakeSocket struct{}
+
+func (fakeSocket) SocketStats() (uint64, uint64, error) { return 2048, 17, nil }
+
+type fakePool struct{}
+
+func (fakePool) PoolStats() (int, uint64, uint64) { return 2, 3, 120 }
+
+func TestMetrics_Handler(t *testing.T) {
+	m := NewMetrics()
+	m.SetCache(fakeCache{})
+	m.SetBlocker(fakeBlocker{})
+	m.SetFailureRate(fakeFailures{})
+	m.SetSocket("udp", ":53", fakeSocket{})
+	m.SetPool("upstream", "https://dns.lan/dns-query", fakePool{})
+	m.SetPool("audit", "https://dns.lan/dns-query", fakePool{})
+	m.Query(dto.A)
+	m.Query(dto.AAAA)
+	m.Answer("Cache")
+	m.Failure()
+	m.Observe("External", 20*time.Millisecond)
+	m.Request("udp", 30, 46)
+	m.Error("External", client.Timeout(errors.New("no response")))
+	m.Error("External", client.NotFound("no record"))
+	m.Error("udp", &dto.BufferTooLongException{})
+	m.Filtered(dto.ANY, "refused")
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expecting status 200, got %d", recorder.Code)
+	}
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		`dnshield_queries_total{type="A"} 1`,
+		`dnshield_queries_total{type="AAAA"} 1`,
+		`dnshield_answers_total{resolver="Cache"} 1`,
+		`dnshield_failures_total 1`,
+		`dnshield_resolver_duration_seconds_count{resolver="External"} 1`,
+		`dnshield_endpoint_requests_total{endpoint="udp"} 1`,
+		`dnshield_errors_total{kind="timeout",source="External"} 1`,
+		`dnshield_errors_total{kind="malformed",source="udp"} 1`,
+		`dnshield_filtered_types_total{answer="refused",type="ANY"} 1`,
+		`dnshield_endpoint_bytes_total{direction="out",endpoint="udp"} 46`,
+		`dnshield_cache_hits_total 3`,
+		`dnshield_cache_misses_total 2`,
+		`dnshield_cache_evictions_total 1`,
+		`dnshield_cache_entries 7`,
+		`dnshield_cache_memory_bytes 1400`,
+		`dnshield_cache_capacity_bytes 5000`,
+		`dnshield_blocked_total 4`,
+		`dnshield_blocked_domains 1000`,
+		`dnshield_blocker_memory_bytes 32000`,
+		`dnshield_failures_last_minute 12`,
+		`dnshield_failures_alert 1`,
+		`dnshield_socket_receive_queue_bytes{address=":53",endpoint="udp"} 2048`,
+		`dnshield_socket_drops_total{address=":53",endpoint="udp"} 17`,
+		`dnshield_upstream_connections{role="upstream",upstream="https://dns.lan/dns-query"} 2`,
+		`dnshield_upstream_dials_total{role="upstream",upstream="https://dns.lan/dns-query"} 3`,
+		`dnshield_upstream_requests_total{role="upstream",upstream="https://dns.lan/dns-query"} 120`,
+		`dnshield_upstream_connections{role="audit",upstream="https://dns.lan/dns-query"} 2`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("missing %s in\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, `kind="failure"`) {
+		t.Errorf("a source without record must not be counted as a failure")
+	}
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *Metrics
+	m.Query(dto.A)
+	m.Filtered(dto.HTTPS, "empty")
+	m.Answer("Cache")
+	m.Failure()
+	m.Observe("Cache", time.Millisecond)
+	m.Request("tcp", 1, 1)
+	m.Error("udp", dto.ErrMalformed)
+	m.SetCache(fakeCache{})
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolCounters is an upstream keeping its connections open between the queries
+type PoolCounters interface {
+	// PoolStats returns the connections open, the connections opened and the requests sent since the upstream was created,
+	// the requests per connection opened tell how well the connections are reused
+	PoolStats() (open int, dials, requests uint64)
+}
+
+// poolKey tells apart an endpoint used in several roles, as an upstream and as the reference of the audit
+type poolKey struct {
+	role     string
+	upstream string
+}
+
+// pools collects the connections of the upstreams, by role and upstream
+type pools struct {
+	lock     sync.RWMutex
+	pools    map[poolKey]PoolCounters
+	open     *prometheus.Desc
+	dials    *prometheus.Desc
+	requests *prometheus.Desc
+}
+
+func newPools() *pools {
+	return &pools{
+		pools: make(map[poolKey]PoolCounters),
+		open: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "upstream_connections"),
+			"Connections open to the upstream, by role and upstream.", []string{"role", "upstream"}, nil),
+		dials: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "upstream_dials_total"),
+			"Connections opened to the upstream, by role and upstream.", []string{"role", "upstream"}, nil),
+		requests: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "upstream_requests_total"),
+			"Requests sent to the upstream over its connections, by role and upstream.", []string{"role", "upstream"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (p *pools) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.open
+	ch <- p.dials
+	ch <- p.requests
+}
+
+// Collect implements prometheus.Collector
+func (p *pools) Collect(ch chan<- prometheus.Metric) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for key, pool := range p.pools {
+		open, dials, requests := pool.PoolStats()
+		ch <- prometheus.MustNewConstMetric(p.open, prometheus.GaugeValue, float64(open), key.role, key.upstream)
+		ch <- prometheus.MustNewConstMetric(p.dials, prometheus.CounterValue, float64(dials), key.role, key.upstream)
+		ch <- prometheus.MustNewConstMetric(p.requests, prometheus.CounterValue, float64(requests), key.role, key.upstream)
+	}
+}
+
+// SetPool sets the connections of the upstream used in the role (upstream, forward, audit) reported by the metrics,
+// it replaces the previous ones on reconfiguration
+func (m *Metrics) SetPool(role, upstream string, p PoolCounters) {
+	if m == nil {
+		return
+	}
+	m.pools.lock.Lock()
+	defer m.pools.lock.Unlock()
+	m.pools.pools[poolKey{role: role, upstream: upstream}] = p
+}
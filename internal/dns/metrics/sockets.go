@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SocketCounters is a socket reporting the receive queues of the kernel, the datagrams dropped there never reach the server
+type SocketCounters interface {
+	// SocketStats returns the bytes waiting in the receive queues and the datagrams dropped since the sockets were opened
+	SocketStats() (queued, drops uint64, err error)
+}
+
+// sockets collects the receive queues of the sockets of the endpoints, by endpoint and address
+type sockets struct {
+	lock    sync.RWMutex
+	sockets map[[2]string]SocketCounters
+	queued  *prometheus.Desc
+	drops   *prometheus.Desc
+}
+
+func newSockets() *sockets {
+	return &sockets{
+		sockets: make(map[[2]string]SocketCounters),
+		queued: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "socket_receive_queue_bytes"),
+			"Bytes waiting in the receive queues of the sockets of the endpoint, by endpoint and address.", []string{"endpoint", "address"}, nil),
+		drops: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "socket_drops_total"),
+			"Datagrams dropped by the kernel before the endpoint read them, by endpoint and address.", []string{"endpoint", "address"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (s *sockets) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.queued
+	ch <- s.drops
+}
+
+// Collect implements prometheus.Collector, the sockets which cannot be read are skipped
+func (s *sockets) Collect(ch chan<- prometheus.Metric) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for key, socket := range s.sockets {
+		queued, drops, err := socket.SocketStats()
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(s.queued, prometheus.GaugeValue, float64(queued), key[0], key[1])
+		ch <- prometheus.MustNewConstMetric(s.drops, prometheus.CounterValue, float64(drops), key[0], key[1])
+	}
+}
+
+// SetSocket sets the sockets of the endpoint listening on address reported by the metrics, it replaces the previous ones
+// on restart
+func (m *Metrics) SetSocket(endpoint, address string, s SocketCounters) {
+	if m == nil {
+		return
+	}
+	m.sockets.lock.Lock()
+	defer m.sockets.lock.Unlock()
+	m.sockets.sockets[[2]string{endpoint, address}] = s
+}
@@ -0,0 +1,20 @@
+package policy
+
+import "github.com/bluguard/dnshield/internal/dns/dto"
+
+// Action is the decision of a policy for a question
+type Action int32
+
+const (
+	// Allow let the question go through the chain unchanged
+	Allow Action = 0
+	// Block answers the question with the blocking response
+	Block Action = 1
+	// Rewrite replaces the question name before the resolution
+	Rewrite Action = 2
+)
+
+// Policy decides what to do with a question, the returned name is only meaningful for Rewrite
+type Policy interface {
+	Evaluate(question dto.Question) (Action, string)
+}
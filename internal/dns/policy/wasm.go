@@ -0,0 +1,152 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("policy")
+
+const (
+	// maximum length of a dns name, size of the buffer shared with the module
+	maxNameLength = 255
+	// maximum number of 64KiB pages the module can use
+	memoryLimitPages = 256
+	// maximum duration of one evaluation, the instance of the module is closed when exceeded and a new one is instantiated
+	evaluationTimeout = 50 * time.Millisecond
+)
+
+var _ Policy = &WasmPolicy{}
+
+// WasmPolicy is a policy implemented by a WebAssembly module running in a sandbox without any host function.
+//
+// The module must export:
+//   - memory: its linear memory
+//   - alloc(size i32) i32: called once to get the address of a buffer of size bytes
+//   - policy(ptr i32, len i32, qtype i32) i32: evaluates the name written in the buffer,
+//     the lowest byte of the result is the Action, for Rewrite the new name is written
+//     by the module at ptr and its length is stored in the upper bytes of the result
+type WasmPolicy struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	module   api.Module
+	policy   api.Function
+	buffer   uint32
+	lock     sync.Mutex
+}
+
+// LoadWasmPolicy loads the module stored in the file at the given path
+func LoadWasmPolicy(ctx context.Context, path string) (*WasmPolicy, error) {
+	binary, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewWasmPolicy(ctx, binary)
+}
+
+// NewWasmPolicy instantiate the given module, the sandbox is closed when the context is done
+func NewWasmPolicy(ctx context.Context, binary []byte) (*WasmPolicy, error) {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(memoryLimitPages).
+		WithCloseOnContextDone(true))
+
+	compiled, err := runtime.CompileModule(ctx, binary)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
+	res := &WasmPolicy{runtime: runtime, compiled: compiled}
+	if err := res.instantiate(ctx); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = runtime.Close(context.Background())
+	}()
+
+	return res, nil
+}
+
+// instantiate runs a new instance of the compiled module, replacing the current one
+func (p *WasmPolicy) instantiate(ctx context.Context) error {
+	// the instances are anonymous, so a new one can run while the closed one is released
+	module, err := p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return err
+	}
+	policy, alloc := module.ExportedFunction("policy"), module.ExportedFunction("alloc")
+	if policy == nil || alloc == nil || module.Memory() == nil {
+		_ = module.Close(ctx)
+		return errors.New("the policy module must export memory, alloc and policy")
+	}
+	result, err := alloc.Call(ctx, maxNameLength)
+	if err != nil {
+		_ = module.Close(ctx)
+		return err
+	}
+	p.module, p.policy, p.buffer = module, policy, api.DecodeU32(result[0])
+	return nil
+}
+
+// Evaluate implements Policy, the question is allowed when the module fails.
+// An evaluation exceeding its time closes the instance of the module, the next questions are evaluated by a new one
+func (p *WasmPolicy) Evaluate(question dto.Question) (Action, string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.module.IsClosed() {
+		if err := p.instantiate(context.Background()); err != nil {
+			logger.Warn("cannot instantiate the policy module again", "err", err)
+			return Allow, ""
+		}
+	}
+	action, name, err := p.call(question)
+	if err != nil {
+		logger.Warn("policy evaluation failed", "name", question.Name, "err", err)
+		return Allow, ""
+	}
+	return action, name
+}
+
+func (p *WasmPolicy) call(question dto.Question) (Action, string, error) {
+	if len(question.Name) > maxNameLength {
+		return Allow, "", errors.New("name too long")
+	}
+	memory := p.module.Memory()
+	if !memory.Write(p.buffer, []byte(question.Name)) {
+		return Allow, "", errors.New("buffer out of the module memory")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), evaluationTimeout)
+	defer cancel()
+	result, err := p.policy.Call(ctx, uint64(p.buffer), uint64(len(question.Name)), uint64(question.Type))
+	if err != nil {
+		return Allow, "", err
+	}
+
+	value := api.DecodeU32(result[0])
+	action := Action(value & 0xFF)
+	if action != Rewrite {
+		return action, "", nil
+	}
+	length := value >> 8
+	if length == 0 || length > maxNameLength {
+		return Allow, "", errors.New("invalid rewritten name length")
+	}
+	name, ok := memory.Read(p.buffer, length)
+	if !ok {
+		return Allow, "", errors.New("rewritten name out of the module memory")
+	}
+	return Rewrite, string(name), nil
+}
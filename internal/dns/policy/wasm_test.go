@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// testModule is a policy module blocking every AAAA question and rewriting the names ending
+// by ".lan" to the name without this suffix
+//
+//	(module
+//	  (memory (export "memory") 1)
+//	  (func (export "alloc") (param i32) (result i32) i32.const 1024)
+//	  (func (export "policy") (param i32 i32 i32) (result i32)
+//	    (if (i32.eq (local.get 2) (i32.const 28)) (then (return (i32.const 1))))
+//	    (if (i32.eq (i32.load8_u (i32.sub (i32.add (local.get 0) (local.get 1)) (i32.const 1))) (i32.const 110))
+//	      (then (return (i32.or (i32.shl (i32.sub (local.get 1) (i32.const 4)) (i32.const 8)) (i32.const 2)))))
+//	    i32.const 0))
+const testModule = "0061736d01000000010d0260017f017f60037f7f7f017f03030200010503010001071b03066d656d6f7279020005616c6c6f63000006706f6c69637900010a350205004180080b2d002002411c46044041010f0b200020016a41016b2d000041ee00460440200141046b4108744102720f0b41000b"
+
+func TestWasmPolicy_Evaluate(t *testing.T) {
+	binary, err := hex.DecodeString(testModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := NewWasmPolicy(ctx, binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		question   dto.Question
+		wantAction Action
+		wantName   string
+	}{
+		{
+			name:       "allowed",
+			question:   dto.Question{Name: "google.com", Type: dto.A, Class: dto.IN},
+			wantAction: Allow,
+		},
+		{
+			name:       "blocked",
+			question:   dto.Question{Name: "google.com", Type: dto.AAAA, Class: dto.IN},
+			wantAction: Block,
+		},
+		{
+			name:       "rewritten",
+			question:   dto.Question{Name: "nas.home.lan", Type: dto.A, Class: dto.IN},
+			wantAction: Rewrite,
+			wantName:   "nas.home",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, name := p.Evaluate(tt.question)
+			if action != tt.wantAction {
+				t.Errorf("WasmPolicy.Evaluate() action = %v, want %v", action, tt.wantAction)
+			}
+			if name != tt.wantName {
+				t.Errorf("WasmPolicy.Evaluate() name = %v, want %v", name, tt.wantName)
+			}
+		})
+	}
+}
+
+// loopModule is the test module looping forever on the TXT questions
+//
+//	(module
+//	  (memory (export "memory") 1)
+//	  (func (export "alloc") (param i32) (result i32) i32.const 1024)
+//	  (func (export "policy") (param i32 i32 i32) (result i32)
+//	    (if (i32.eq (local.get 2) (i32.const 16)) (then (loop (br 0))))
+//	    (if (i32.eq (local.get 2) (i32.const 28)) (then (return (i32.const 1))))
+//	    i32.const 0))
+const loopModule = "0061736d01000000010d0260017f017f60037f7f7f017f03030200010503010001071b03066d656d6f7279020005616c6c6f63000006706f6c69637900010a240205004180080b1c002002411046044003400c000b0b2002411c46044041010f0b41000b"
+
+func TestWasmPolicy_timeout(t *testing.T) {
+	binary, err := hex.DecodeString(loopModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := NewWasmPolicy(ctx, binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if action, _ := p.Evaluate(dto.Question{Name: "loop.com", Type: dto.TXT, Class: dto.IN}); action != Allow {
+			t.Errorf("expecting an evaluation exceeding its time to allow the question, got %v", action)
+		}
+		// the next questions are evaluated by a new instance of the module
+		if action, _ := p.Evaluate(dto.Question{Name: "google.com", Type: dto.AAAA, Class: dto.IN}); action != Block {
+			t.Errorf("expecting the policy to be evaluated after a timeout, got %v", action)
+		}
+	}
+}
+
+func TestNewWasmPolicy_invalid(t *testing.T) {
+	if _, err := NewWasmPolicy(context.Background(), []byte("not a module")); err == nil {
+		t.Fatal("expecting an error for an invalid module")
+	}
+}
@@ -0,0 +1,140 @@
+// Package policytest replays scenarios of clients asking questions against the filtering policy of a configuration,
+// so the rules can be kept under version control and checked before being deployed
+package policytest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"gopkg.in/yaml.v3"
+)
+
+// Outcome is the fate of a question
+type Outcome string
+
+const (
+	// Blocked is answered with the blocking response, by the blocking lists, the rules, the groups or the policy
+	Blocked Outcome = "blocked"
+	// Local is answered by the server, from the custom records, the hosts, the overrides or the health checks
+	Local Outcome = "local"
+	// Forwarded is asked to the upstream
+	Forwarded Outcome = "forwarded"
+	// Refused is refused by the access list
+	Refused Outcome = "refused"
+	// Dropped is ignored by the access list
+	Dropped Outcome = "dropped"
+	// Allowed is expected from the questions either answered locally or forwarded, it is never the outcome of a question
+	Allowed Outcome = "allowed"
+)
+
+var expectations = []Outcome{Blocked, Local, Forwarded, Refused, Dropped, Allowed}
+
+// Scenario is a question of a client and the outcome expected for it
+type Scenario struct {
+	// Client is the address of the client, a client of unknown address when empty
+	Client string `yaml:"client"`
+	Name   string `yaml:"qname"`
+	// Type is the type of the question, A when empty
+	Type   string  `yaml:"qtype"`
+	Expect Outcome `yaml:"expect"`
+	// Answer is an address the answer is expected to contain, not checked when empty
+	Answer string `yaml:"answer"`
+}
+
+// Question returns the question asked by the client of the scenario
+func (s Scenario) Question() (dto.Question, error) {
+	t := dto.A
+	if s.Type != "" {
+		var err error
+		if t, err = dto.ParseType(s.Type); err != nil {
+			return dto.Question{}, err
+		}
+	}
+	return dto.Question{Name: strings.TrimSuffix(s.Name, "."), Type: t, Class: dto.IN}, nil
+}
+
+// ClientIP returns the address of the client, nil when unknown
+func (s Scenario) ClientIP() net.IP {
+	return net.ParseIP(s.Client)
+}
+
+func (s Scenario) check() error {
+	if s.Name == "" {
+		return errors.New("a scenario needs a qname")
+	}
+	if s.Client != "" && s.ClientIP() == nil {
+		return errors.New("invalid client " + s.Client + " of " + s.Name)
+	}
+	if !slices.Contains(expectations, s.Expect) {
+		return errors.New("unknown outcome " + string(s.Expect) + " of " + s.Name + ", expecting blocked, local, forwarded, allowed, refused or dropped")
+	}
+	if s.Answer != "" && net.ParseIP(s.Answer) == nil {
+		return errors.New("invalid answer " + s.Answer + " of " + s.Name)
+	}
+	_, err := s.Question()
+	return err
+}
+
+// Load reads the list of scenarios of a yaml document, json being valid yaml
+func Load(r io.Reader) ([]Scenario, error) {
+	var res []Scenario
+	if err := yaml.NewDecoder(r).Decode(&res); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	for _, s := range res {
+		if err := s.check(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Result is the outcome of a scenario and the addresses answered
+type Result struct {
+	Scenario Scenario
+	Outcome  Outcome
+	Answers  []string
+}
+
+// Passed tells if the outcome and the answers are the expected ones
+func (r Result) Passed() bool {
+	switch {
+	case r.Scenario.Expect == Allowed && r.Outcome != Local && r.Outcome != Forwarded:
+		return false
+	case r.Scenario.Expect != Allowed && r.Outcome != r.Scenario.Expect:
+		return false
+	}
+	if r.Scenario.Answer == "" {
+		return true
+	}
+	expected := net.ParseIP(r.Scenario.Answer)
+	return slices.ContainsFunc(r.Answers, func(answer string) bool {
+		return expected.Equal(net.ParseIP(answer))
+	})
+}
+
+// String describes the result for the report of the test
+func (r Result) String() string {
+	res := r.Scenario.Name
+	if r.Scenario.Client != "" {
+		res = r.Scenario.Client + " " + res
+	}
+	if r.Scenario.Type != "" {
+		res += " " + r.Scenario.Type
+	}
+	res += ": " + string(r.Outcome)
+	if len(r.Answers) > 0 {
+		res += " " + strings.Join(r.Answers, ",")
+	}
+	if !r.Passed() {
+		res += ", expecting " + string(r.Scenario.Expect)
+		if r.Scenario.Answer != "" {
+			res += " " + r.Scenario.Answer
+		}
+	}
+	return res
+}
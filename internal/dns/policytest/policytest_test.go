@@ -0,0 +1,72 @@
+package policytest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func TestLoad(t *testing.T) {
+	scenarios, err := Load(strings.NewReader(`
+- client: 192.168.1.20
+  qname: ads.example.com
+  expect: blocked
+- client: 192.168.1.21
+  qname: nas.lan.
+  qtype: AAAA
+  expect: local
+  answer: fd00::2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenarios) != 2 {
+		t.Fatalf("expecting 2 scenarios, got %d", len(scenarios))
+	}
+	question, err := scenarios[1].Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if question.Name != "nas.lan" || question.Type != dto.AAAA {
+		t.Errorf("unexpected question %v", question)
+	}
+	if question, _ := scenarios[0].Question(); question.Type != dto.A {
+		t.Errorf("expecting an A question by default, got %v", question.Type)
+	}
+
+	for _, invalid := range []string{
+		"- qname: ads.example.com\n  expect: hidden",
+		"- client: router\n  qname: ads.example.com\n  expect: blocked",
+		"- expect: blocked",
+		"- qname: ads.example.com\n  qtype: BOGUS\n  expect: blocked",
+		"- qname: nas.lan\n  expect: local\n  answer: nas",
+	} {
+		if _, err := Load(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expecting an error for %q", invalid)
+		}
+	}
+}
+
+func TestResult_Passed(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		want   bool
+	}{
+		{"same outcome", Result{Scenario: Scenario{Expect: Blocked}, Outcome: Blocked}, true},
+		{"other outcome", Result{Scenario: Scenario{Expect: Blocked}, Outcome: Forwarded}, false},
+		{"allowed forwarded", Result{Scenario: Scenario{Expect: Allowed}, Outcome: Forwarded}, true},
+		{"allowed local", Result{Scenario: Scenario{Expect: Allowed}, Outcome: Local}, true},
+		{"allowed blocked", Result{Scenario: Scenario{Expect: Allowed}, Outcome: Blocked}, false},
+		{"expected answer", Result{Scenario: Scenario{Expect: Local, Answer: "fd00::2"}, Outcome: Local, Answers: []string{"fd00:0::2"}}, true},
+		{"other answer", Result{Scenario: Scenario{Expect: Local, Answer: "192.168.1.2"}, Outcome: Local, Answers: []string{"192.168.1.3"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.Passed(); got != tt.want {
+				t.Errorf("Passed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
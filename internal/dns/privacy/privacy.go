@@ -0,0 +1,147 @@
+// Package privacy anonymizes the questions recorded by the query log, the stats and the traces: the addresses of the clients are
+// hashed or truncated, and the names asked fewer times than a threshold are left out, so the records of a shared network
+// do not tell which member asked for a rare name
+package privacy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Mode is how the addresses of the clients are recorded
+type Mode string
+
+const (
+	// Plain records the addresses as they are
+	Plain Mode = ""
+	// Hash records a salted hash of the addresses, the questions of a client can be told apart without its address
+	Hash Mode = "hash"
+	// Truncate records the network of the addresses, their last bits zeroed
+	Truncate Mode = "truncate"
+)
+
+// default prefixes kept by the truncation
+const (
+	DefaultPrefixV4 = 24
+	DefaultPrefixV6 = 48
+)
+
+// maxNames is the number of names counted for their popularity, the counts start over once reached
+const maxNames = 100000
+
+// processSalt is the salt of the hashes without configured salt, the hashes of a client are the same across the reloads
+// of the configuration but not across the restarts
+var processSalt = func() []byte {
+	salt := make([]byte, 16)
+	_, _ = rand.Read(salt)
+	return salt
+}()
+
+// ParseMode returns the mode of its name
+func ParseMode(name string) (Mode, error) {
+	switch m := Mode(strings.ToLower(name)); m {
+	case Plain, Hash, Truncate:
+		return m, nil
+	}
+	return "", errors.New("unknown client anonymization " + name + ", expecting hash or truncate")
+}
+
+// Anonymizer anonymizes the clients and the names of the questions, it is safe for concurrent use.
+// A nil Anonymizer keeps them as they are
+type Anonymizer struct {
+	mode               Mode
+	salt               []byte
+	prefixV4, prefixV6 int
+	// minCount is the number of times a name must be asked to be recorded, counts holds the numbers of times
+	minCount uint64
+	lock     sync.Mutex
+	counts   map[string]uint64
+}
+
+// NewAnonymizer returns the anonymizer of the clients in the mode, keeping the prefixes of their addresses when
+// truncating them, the default ones when 0, or hashing them with the salt, a random one drawn once per process when empty.
+// The names are recorded once asked minCount times, always when 0
+func NewAnonymizer(mode Mode, salt string, prefixV4, prefixV6 int, minCount uint64) *Anonymizer {
+	res := &Anonymizer{mode: mode, salt: []byte(salt), prefixV4: prefixV4, prefixV6: prefixV6, minCount: minCount}
+	if len(res.salt) == 0 {
+		res.salt = processSalt
+	}
+	if res.prefixV4 == 0 {
+		res.prefixV4 = DefaultPrefixV4
+	}
+	if res.prefixV6 == 0 {
+		res.prefixV6 = DefaultPrefixV6
+	}
+	if minCount > 0 {
+		res.counts = make(map[string]uint64)
+	}
+	return res
+}
+
+// Client returns the address of the client as it is recorded, empty for a nil address
+func (a *Anonymizer) Client(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if a == nil {
+		return ip.String()
+	}
+	switch a.mode {
+	case Hash:
+		sum := sha256.Sum256(append(append([]byte{}, a.salt...), ip.To16()...))
+		return hex.EncodeToString(sum[:8])
+	case Truncate:
+		if v4 := ip.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(a.prefixV4, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(a.prefixV6, 128)).String()
+	}
+	return ip.String()
+}
+
+// Name counts the question of the name and returns it as it is recorded, empty while it has been asked fewer times
+// than the threshold
+func (a *Anonymizer) Name(name string) string {
+	if a == nil || a.minCount == 0 {
+		return name
+	}
+	key := strings.ToLower(strings.TrimSuffix(name, "."))
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.counts[key]; !ok && len(a.counts) >= maxNames {
+		a.counts = make(map[string]uint64)
+	}
+	a.counts[key]++
+	if a.counts[key] < a.minCount {
+		return ""
+	}
+	return name
+}
+
+// Recorded returns the name as it is recorded without counting its question, empty while it has been asked fewer times
+// than the threshold
+func (a *Anonymizer) Recorded(name string) string {
+	if a == nil || a.minCount == 0 {
+		return name
+	}
+	key := strings.ToLower(strings.TrimSuffix(name, "."))
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.counts[key] < a.minCount {
+		return ""
+	}
+	return name
+}
+
+// MinCount returns the number of times a name must be asked to be recorded, 0 when every name is
+func (a *Anonymizer) MinCount() uint64 {
+	if a == nil {
+		return 0
+	}
+	return a.minCount
+}
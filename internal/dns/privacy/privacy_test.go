@@ -0,0 +1,68 @@
+package privacy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAnonymizer_Client(t *testing.T) {
+	tests := []struct {
+		name       string
+		anonymizer *Anonymizer
+		ip         string
+		want       string
+	}{
+		{"nil", nil, "192.168.1.20", "192.168.1.20"},
+		{"plain", NewAnonymizer(Plain, "", 0, 0, 0), "192.168.1.20", "192.168.1.20"},
+		{"truncated v4", NewAnonymizer(Truncate, "", 0, 0, 0), "192.168.1.20", "192.168.1.0"},
+		{"truncated v6", NewAnonymizer(Truncate, "", 0, 0, 0), "2001:db8:1:2::20", "2001:db8:1::"},
+		{"custom prefix", NewAnonymizer(Truncate, "", 16, 32, 0), "192.168.1.20", "192.168.0.0"},
+		{"hashed", NewAnonymizer(Hash, "salt", 0, 0, 0), "192.168.1.20", NewAnonymizer(Hash, "salt", 0, 0, 0).Client(net.ParseIP("192.168.1.20"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.anonymizer.Client(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("Client() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	salted := NewAnonymizer(Hash, "salt", 0, 0, 0)
+	hashed := salted.Client(net.ParseIP("192.168.1.20"))
+	if len(hashed) != 16 || hashed == salted.Client(net.ParseIP("192.168.1.21")) {
+		t.Errorf("expecting distinct hashes for distinct clients, got %s", hashed)
+	}
+	if hashed == NewAnonymizer(Hash, "pepper", 0, 0, 0).Client(net.ParseIP("192.168.1.20")) {
+		t.Error("expecting the hash to depend on the salt")
+	}
+	if got := salted.Client(nil); got != "" {
+		t.Errorf("expecting no client for a nil address, got %s", got)
+	}
+}
+
+func TestAnonymizer_Name(t *testing.T) {
+	a := NewAnonymizer(Plain, "", 0, 0, 3)
+	for i, want := range []string{"", "", "example.com", "example.com"} {
+		if got := a.Name("example.com"); got != want {
+			t.Errorf("question %d: Name() = %q, want %q", i+1, got, want)
+		}
+	}
+	if got := a.Name("Example.com."); got != "Example.com." {
+		t.Errorf("expecting the names to be counted regardless of their case, got %q", got)
+	}
+	if got := a.Name("rare.example"); got != "" {
+		t.Errorf("expecting a rare name to be left out, got %q", got)
+	}
+	var none *Anonymizer
+	if got := none.Name("rare.example"); got != "rare.example" {
+		t.Errorf("expecting a nil anonymizer to keep the names, got %q", got)
+	}
+	if got := a.Recorded("example.com"); got != "example.com" {
+		t.Errorf("expecting a name asked enough to be recorded, got %q", got)
+	}
+	for i := 0; i < 3; i++ {
+		if got := a.Recorded("other.example"); got != "" {
+			t.Errorf("expecting Recorded not to count the questions, got %q", got)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields are the columns of an export, in their default order
+var Fields = []string{"time", "client", "name", "type", "rcode", "resolver", "duration"}
+
+// ErrDisabled is returned by the export of a log which is not enabled
+var ErrDisabled = errors.New("the query log is not enabled")
+
+// Format is the file format of an export
+type Format string
+
+const (
+	CSV Format = "csv"
+	// Parquet has a timestamp column for the time and a double for the duration in seconds, the other columns are strings
+	Parquet Format = "parquet"
+)
+
+// ParseFormat parses the format of an export, csv when empty
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", CSV:
+		return CSV, nil
+	case Parquet:
+		return Parquet, nil
+	}
+	return "", errors.New("unknown format " + s + ", expecting csv or parquet")
+}
+
+// ContentType returns the media type of the format
+func (f Format) ContentType() string {
+	if f == Parquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// ExportOptions selects the entries and the columns of an export
+type ExportOptions struct {
+	// From and To bound the time of the entries, To excluded, a zero time does not bound
+	From time.Time
+	To   time.Time
+	// Fields are the exported columns, all of them when empty
+	Fields []string
+	// Anonymize truncates the client addresses to their /24 network in ipv4 and /48 in ipv6
+	Anonymize bool
+	// Format is the format of the export, csv when empty
+	Format Format
+}
+
+// ParseFields parses a comma separated list of fields
+func ParseFields(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	res := strings.Split(s, ",")
+	for i, field := range res {
+		res[i] = strings.TrimSpace(field)
+		if !isField(res[i]) {
+			return nil, errors.New("unknown field " + res[i] + ", expecting " + strings.Join(Fields, ", "))
+		}
+	}
+	return res, nil
+}
+
+func isField(name string) bool {
+	for _, field := range Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportCSV writes the entries of the log read from r matching the options as csv, with a header line.
+// The lines which cannot be decoded, like the last one of a log being written, are skipped.
+// It returns the number of exported entries
+func ExportCSV(r io.Reader, w io.Writer, opts ExportOptions) (int, error) {
+	fields := opts.fields()
+	writer := csv.NewWriter(w)
+	if err := writer.Write(fields); err != nil {
+		return 0, err
+	}
+	row := make([]string, len(fields))
+	count, err := opts.scan(r, func(entry Entry) error {
+		for i, field := range fields {
+			row[i] = entry.field(field)
+		}
+		return writer.Write(row)
+	})
+	if err != nil {
+		return count, err
+	}
+	writer.Flush()
+	return count, writer.Error()
+}
+
+// ExportParquet writes the entries of the log read from r matching the options as a parquet file, the file is
+// only complete once the export succeeded. It returns the number of exported entries
+func ExportParquet(r io.Reader, w io.Writer, opts ExportOptions) (int, error) {
+	writer := newParquetWriter(w, opts.fields())
+	if err := writer.start(); err != nil {
+		return 0, err
+	}
+	count, err := opts.scan(r, writer.add)
+	if err != nil {
+		return count, err
+	}
+	return count, writer.close()
+}
+
+// Export writes the entries of the log read from r matching the options in the format of the options.
+// It returns the number of exported entries
+func Export(r io.Reader, w io.Writer, opts ExportOptions) (int, error) {
+	if opts.Format == Parquet {
+		return ExportParquet(r, w, opts)
+	}
+	return ExportCSV(r, w, opts)
+}
+
+// scan calls fn with the entries of the log read from r matching the options, anonymized when asked.
+// The lines which cannot be decoded are skipped. It returns the number of entries fn accepted
+func (opts ExportOptions) scan(r io.Reader, fn func(Entry) error) (int, error) {
+	count := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !opts.includes(entry.Time) {
+			continue
+		}
+		if opts.Anonymize {
+			entry.Client = anonymize(entry.Client)
+		}
+		if err := fn(entry); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// fields returns the exported columns
+func (opts ExportOptions) fields() []string {
+	if len(opts.Fields) == 0 {
+		return Fields
+	}
+	return opts.Fields
+}
+
+// ExportFiles writes the entries of the log at path and of its rotated files matching the options, oldest first.
+// It returns the number of exported entries
+func ExportFiles(path string, w io.Writer, opts ExportOptions) (int, error) {
+	// the rotated files are read first, a line truncated at the end of one of them does not swallow the next one
+	files := Files(path)
+	readers := make([]io.Reader, 0, 2*len(files))
+	for _, name := range files {
+		file, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue // removed by a rotation since listed
+		}
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		readers = append(readers, file, strings.NewReader("\n"))
+	}
+	return Export(io.MultiReader(readers...), w, opts)
+}
+
+// Export writes the entries of the log matching the options, they keep being logged meanwhile but the purges
+// wait for the end of the export. ErrDisabled is returned by a nil Logger
+func (l *Logger) Export(w io.Writer, opts ExportOptions) (int, error) {
+	if l == nil {
+		return 0, ErrDisabled
+	}
+	l.purgeLock.Lock()
+	defer l.purgeLock.Unlock()
+	return ExportFiles(l.path, w, opts)
+}
+
+func (opts ExportOptions) includes(t time.Time) bool {
+	if !opts.From.IsZero() && t.Before(opts.From) {
+		return false
+	}
+	return opts.To.IsZero() || t.Before(opts.To)
+}
+
+// field returns the value of the column, the durations are in seconds to be summed in a spreadsheet
+func (e Entry) field(name string) string {
+	switch name {
+	case "time":
+		return e.Time.Format(time.RFC3339Nano)
+	case "client":
+		return e.Client
+	case "name":
+		return e.Name
+	case "type":
+		return e.Type
+	case "rcode":
+		return e.Rcode
+	case "resolver":
+		return e.Resolver
+	case "duration":
+		return strconv.FormatFloat(e.Duration.Seconds(), 'f', -1, 64)
+	}
+	return ""
+}
+
+// anonymize removes the host part of the address, an address which cannot be parsed is dropped
+func anonymize(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,252 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+func TestExportCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	l.Log(Entry{Time: start, Client: "192.168.1.12", Name: "example.com", Type: "A", Rcode: NoError, Resolver: "Cache", Duration: 1500 * time.Microsecond})
+	l.Log(Entry{Time: start.Add(time.Hour), Client: "2001:db8:1:2::5", Name: "ads.com", Type: "AAAA", Rcode: NXDomain, Resolver: "Block"})
+	l.Log(Entry{Time: start.Add(2 * time.Hour), Name: "late.com", Type: "A", Rcode: ServFail})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l.Log(Entry{Time: start, Name: "closed.com"})
+	// a line truncated by a crash is skipped
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	_, _ = file.WriteString(`{"time":"2024-03-01T10:`)
+	file.Close()
+
+	tests := []struct {
+		name string
+		opts ExportOptions
+		want string
+	}{
+		{
+			name: "all",
+			want: "time,client,name,type,rcode,resolver,duration\n" +
+				"2024-03-01T10:00:00Z,192.168.1.12,example.com,A,NOERROR,Cache,0.0015\n" +
+				"2024-03-01T11:00:00Z,2001:db8:1:2::5,ads.com,AAAA,NXDOMAIN,Block,0\n" +
+				"2024-03-01T12:00:00Z,,late.com,A,SERVFAIL,,0\n",
+		},
+		{
+			name: "time range and fields",
+			opts: ExportOptions{From: start.Add(time.Minute), To: start.Add(2 * time.Hour), Fields: []string{"name", "rcode"}},
+			want: "name,rcode\nads.com,NXDOMAIN\n",
+		},
+		{
+			name: "anonymized",
+			opts: ExportOptions{To: start.Add(2 * time.Hour), Fields: []string{"client"}, Anonymize: true},
+			want: "client\n192.168.1.0\n2001:db8:1::\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			out := &bytes.Buffer{}
+			if _, err := ExportCSV(file, out, tt.opts); err != nil {
+				t.Fatalf("ExportCSV() error = %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("ExportCSV() = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("name, client")
+	if err != nil || len(fields) != 2 || fields[1] != "client" {
+		t.Errorf("ParseFields() = %v, %v", fields, err)
+	}
+	if _, err := ParseFields("name,password"); err == nil {
+		t.Errorf("expecting an error for an unknown field")
+	}
+}
+
+func TestLogger_Export(t *testing.T) {
+	var disabled *Logger
+	if _, err := disabled.Export(&bytes.Buffer{}, ExportOptions{}); !errors.Is(err, ErrDisabled) {
+		t.Fatalf("expecting ErrDisabled without log, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	l.Log(Entry{Time: start, Name: "first.com", Type: "A", Rcode: NoError})
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetRotation(info.Size(), 1)
+	l.Log(Entry{Time: start.Add(time.Minute), Name: "second.com", Type: "A", Rcode: NoError})
+
+	out := &bytes.Buffer{}
+	count, err := l.Export(out, ExportOptions{Fields: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rotated file comes first
+	if want := "name\nfirst.com\nsecond.com\n"; count != 2 || out.String() != want {
+		t.Errorf("Export() = %d, %q, want 2, %q", count, out.String(), want)
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	log := bytes.Buffer{}
+	for _, entry := range []Entry{
+		{Time: start, Client: "192.168.1.12", Name: "example.com", Type: "A", Rcode: NoError, Duration: 1500 * time.Microsecond},
+		{Time: start.Add(time.Hour), Client: "192.168.1.13", Name: "ads.com", Type: "AAAA", Rcode: NXDomain},
+		{Time: start.Add(2 * time.Hour), Name: "late.com"},
+	} {
+		line, _ := json.Marshal(entry)
+		log.Write(append(line, '\n'))
+	}
+	out := &bytes.Buffer{}
+	count, err := Export(&log, out, ExportOptions{To: start.Add(2 * time.Hour), Fields: []string{"time", "client", "name", "duration"}, Anonymize: true, Format: Parquet})
+	if err != nil || count != 2 {
+		t.Fatalf("Export() = %d, %v", count, err)
+	}
+
+	file := out.Bytes()
+	if !bytes.HasPrefix(file, []byte(parquetMagic)) || !bytes.HasSuffix(file, []byte(parquetMagic)) {
+		t.Fatalf("expecting the parquet magic number around the file")
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := (&compactReader{buf: file[len(file)-8-size : len(file)-8]}).structure()
+	if meta[3] != int64(2) {
+		t.Errorf("expecting 2 rows, got %v", meta[3])
+	}
+	schema := meta[2].([]any)
+	var names []string
+	for _, element := range schema[1:] {
+		names = append(names, element.(map[int16]any)[4].(string))
+	}
+	if len(names) != 4 || names[0] != "time" || names[3] != "duration" {
+		t.Errorf("expecting the columns of the options in the schema, got %v", names)
+	}
+
+	// the values of a column are in a single page of its chunk
+	chunks := meta[4].([]any)[0].(map[int16]any)[1].([]any)
+	values := func(column int) []byte {
+		offset := chunks[column].(map[int16]any)[3].(map[int16]any)[9].(int64)
+		reader := &compactReader{buf: file, pos: int(offset)}
+		header := reader.structure()
+		page, err := snappy.Decode(nil, file[reader.pos:reader.pos+int(header[3].(int64))])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+	if page := values(0); binary.LittleEndian.Uint64(page[8:]) != uint64(start.Add(time.Hour).UnixMicro()) {
+		t.Errorf("expecting the time of the second entry in microseconds, got %v", page)
+	}
+	if page := values(1); string(page[4:15]) != "192.168.1.0" {
+		t.Errorf("expecting the anonymized client of the first entry, got %q", page)
+	}
+	if page := values(2); string(page) != "\x0b\x00\x00\x00example.com\x07\x00\x00\x00ads.com" {
+		t.Errorf("expecting the names of the entries, got %q", page)
+	}
+	if page := values(3); math.Float64frombits(binary.LittleEndian.Uint64(page)) != 0.0015 {
+		t.Errorf("expecting the duration of the first entry in seconds, got %v", page)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if format, err := ParseFormat(""); err != nil || format != CSV {
+		t.Errorf("ParseFormat() = %v, %v, want csv", format, err)
+	}
+	if format, err := ParseFormat("parquet"); err != nil || format != Parquet {
+		t.Errorf("ParseFormat() = %v, %v, want parquet", format, err)
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Errorf("expecting an error for an unknown format")
+	}
+}
+
+// compactReader decodes the thrift structures of the compact protocol, the fields by id with the integers as int64
+type compactReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *compactReader) structure() map[int16]any {
+	res := make(map[int16]any)
+	var last int16
+	for {
+		header := r.buf[r.pos]
+		r.pos++
+		if header == 0 {
+			return res
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+		res[id] = r.value(header & 0x0F)
+	}
+}
+
+func (r *compactReader) value(t byte) any {
+	switch t {
+	case compactI32, compactI64:
+		return r.varint()
+	case compactBinary:
+		size := int(r.uvarint())
+		r.pos += size
+		return string(r.buf[r.pos-size : r.pos])
+	case compactList:
+		header := r.buf[r.pos]
+		r.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		res := make([]any, size)
+		for i := range res {
+			res[i] = r.value(header & 0x0F)
+		}
+		return res
+	case compactStruct:
+		return r.structure()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *compactReader) varint() int64 {
+	v, n := binary.Varint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	r.pos += n
+	return v
+}
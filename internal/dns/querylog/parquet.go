@@ -0,0 +1,288 @@
+package querylog
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+const (
+	parquetMagic = "PAR1"
+	// parquetRowGroup is the number of entries of a row group, the columns of a group are held in memory until written
+	parquetRowGroup = 1 << 16
+)
+
+// physical and converted types, encodings and codec of the parquet format
+const (
+	parquetInt64           = 2
+	parquetDouble          = 5
+	parquetByteArray       = 6
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+	parquetRequired        = 0
+	parquetPlain           = 0
+	parquetRLE             = 3
+	parquetSnappy          = 1
+	parquetDataPage        = 0
+)
+
+// parquetColumn is a column of the export, the time is a timestamp in microseconds and the duration in seconds
+type parquetColumn struct {
+	name      string
+	physical  int32
+	converted int32 // -1 without converted type
+	values    []byte
+}
+
+// parquetChunk is the position of a column chunk written in the file
+type parquetChunk struct {
+	offset       int64
+	compressed   int64
+	uncompressed int64
+}
+
+// parquetGroup is a row group written in the file
+type parquetGroup struct {
+	rows   int
+	chunks []parquetChunk
+}
+
+// parquetWriter writes the entries as a parquet file, in row groups of parquetRowGroup entries, every column
+// chunk in a single plain encoded page compressed with snappy
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []parquetColumn
+	rows    int
+	groups  []parquetGroup
+}
+
+func newParquetWriter(w io.Writer, fields []string) *parquetWriter {
+	res := &parquetWriter{w: w, columns: make([]parquetColumn, len(fields))}
+	for i, field := range fields {
+		res.columns[i] = parquetColumn{name: field, physical: parquetByteArray, converted: parquetUTF8}
+		switch field {
+		case "time":
+			res.columns[i].physical, res.columns[i].converted = parquetInt64, parquetTimestampMicros
+		case "duration":
+			res.columns[i].physical, res.columns[i].converted = parquetDouble, -1
+		}
+	}
+	return res
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// start writes the magic number opening the file
+func (p *parquetWriter) start() error {
+	return p.write([]byte(parquetMagic))
+}
+
+// add appends the entry to the row group, which is written once full
+func (p *parquetWriter) add(entry Entry) error {
+	for i := range p.columns {
+		column := &p.columns[i]
+		switch column.name {
+		case "time":
+			column.values = binary.LittleEndian.AppendUint64(column.values, uint64(entry.Time.UnixMicro()))
+		case "duration":
+			column.values = binary.LittleEndian.AppendUint64(column.values, math.Float64bits(entry.Duration.Seconds()))
+		default:
+			value := entry.field(column.name)
+			column.values = binary.LittleEndian.AppendUint32(column.values, uint32(len(value)))
+			column.values = append(column.values, value...)
+		}
+	}
+	p.rows++
+	if p.rows == parquetRowGroup {
+		return p.flush()
+	}
+	return nil
+}
+
+// flush writes the row group
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	group := parquetGroup{rows: p.rows, chunks: make([]parquetChunk, len(p.columns))}
+	for i := range p.columns {
+		column := &p.columns[i]
+		page := snappy.Encode(nil, column.values)
+		header := newCompact()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(column.values)))
+		header.i32(3, int32(len(page)))
+		header.begin(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		group.chunks[i] = parquetChunk{
+			offset:       p.offset,
+			compressed:   int64(len(header.buf) + len(page)),
+			uncompressed: int64(len(header.buf) + len(column.values)),
+		}
+		if err := p.write(header.buf); err != nil {
+			return err
+		}
+		if err := p.write(page); err != nil {
+			return err
+		}
+		column.values = column.values[:0]
+	}
+	p.groups = append(p.groups, group)
+	p.rows = 0
+	return nil
+}
+
+// close writes the last row group and the footer of the file
+func (p *parquetWriter) close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	var total int64
+	for _, group := range p.groups {
+		total += int64(group.rows)
+	}
+
+	meta := newCompact()
+	meta.i32(1, 1)
+	meta.list(2, compactStruct, len(p.columns)+1)
+	meta.element()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.end()
+	for _, column := range p.columns {
+		meta.element()
+		meta.i32(1, column.physical)
+		meta.i32(3, parquetRequired)
+		meta.binary(4, column.name)
+		if column.converted >= 0 {
+			meta.i32(6, column.converted)
+		}
+		meta.end()
+	}
+	meta.i64(3, total)
+	meta.list(4, compactStruct, len(p.groups))
+	for _, group := range p.groups {
+		meta.element()
+		meta.list(1, compactStruct, len(group.chunks))
+		var size int64
+		for i, chunk := range group.chunks {
+			size += chunk.uncompressed
+			meta.element()
+			meta.i64(2, chunk.offset)
+			meta.begin(3)
+			meta.i32(1, p.columns[i].physical)
+			meta.list(2, compactI32, 1)
+			meta.buf = binary.AppendVarint(meta.buf, parquetPlain)
+			meta.list(3, compactBinary, 1)
+			meta.buf = appendString(meta.buf, p.columns[i].name)
+			meta.i32(4, parquetSnappy)
+			meta.i64(5, int64(group.rows))
+			meta.i64(6, chunk.uncompressed)
+			meta.i64(7, chunk.compressed)
+			meta.i64(9, chunk.offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, size)
+		meta.i64(3, int64(group.rows))
+		meta.end()
+	}
+	meta.binary(6, "dnshield")
+	meta.end()
+
+	footer := binary.LittleEndian.AppendUint32(meta.buf, uint32(len(meta.buf)))
+	return p.write(append(footer, parquetMagic...))
+}
+
+// types of the thrift compact protocol
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compact encodes the thrift structures of the parquet metadata with the compact protocol
+type compact struct {
+	buf []byte
+	// last are the ids of the last fields written in the structures being written
+	last []int16
+}
+
+// newCompact starts a structure
+func newCompact() *compact {
+	return &compact{last: []int16{0}}
+}
+
+func (c *compact) field(id int16, t byte) {
+	last := &c.last[len(c.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		c.buf = append(c.buf, byte(delta)<<4|t)
+	} else {
+		c.buf = append(c.buf, t)
+		c.buf = binary.AppendVarint(c.buf, int64(id))
+	}
+	*last = id
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, compactI32)
+	c.buf = binary.AppendVarint(c.buf, int64(v))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, compactI64)
+	c.buf = binary.AppendVarint(c.buf, v)
+}
+
+func (c *compact) binary(id int16, v string) {
+	c.field(id, compactBinary)
+	c.buf = appendString(c.buf, v)
+}
+
+// list starts a list of size elements of type t, the elements follow
+func (c *compact) list(id int16, t byte, size int) {
+	c.field(id, compactList)
+	if size < 15 {
+		c.buf = append(c.buf, byte(size)<<4|t)
+		return
+	}
+	c.buf = append(c.buf, 0xF0|t)
+	c.buf = binary.AppendUvarint(c.buf, uint64(size))
+}
+
+// begin starts a structure field, its fields follow until end
+func (c *compact) begin(id int16) {
+	c.field(id, compactStruct)
+	c.element()
+}
+
+// element starts a structure element of a list, its fields follow until end
+func (c *compact) element() {
+	c.last = append(c.last, 0)
+}
+
+// end ends the structure being written
+func (c *compact) end() {
+	c.buf = append(c.buf, 0)
+	c.last = c.last[:len(c.last)-1]
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
@@ -0,0 +1,230 @@
+package querylog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+// retentionInterval is the interval between two purges of the expired entries
+const retentionInterval = time.Hour
+
+// ClientMatcher returns a matcher of the entries of the client
+func ClientMatcher(client string) func(Entry) bool {
+	return func(e Entry) bool {
+		return e.Client == client
+	}
+}
+
+// BeforeMatcher returns a matcher of the entries older than t
+func BeforeMatcher(t time.Time) func(Entry) bool {
+	return func(e Entry) bool {
+		return e.Time.Before(t)
+	}
+}
+
+// PurgeFile removes the entries of the log at path matching match, it returns the number of removed entries.
+// The log is rewritten in a new file replacing the previous one, it must not be written meanwhile.
+// The lines which cannot be decoded are kept
+func PurgeFile(path string, match func(Entry) bool) (int, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := createPurge(in, path)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(out.Name()) // no effect once renamed
+
+	writer := bufio.NewWriter(out)
+	removed, _, err := filterLines(in, 0, writer, match, true)
+	if err := closePurge(out, writer, err); err != nil {
+		return 0, err
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, os.Rename(out.Name(), path)
+}
+
+// createPurge creates the file receiving the entries of the file in kept by a purge of the log at path, with its mode
+func createPurge(in *os.File, path string) (*os.File, error) {
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path+".purge", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+}
+
+// closePurge flushes and closes the file of a purge, the error of the filtering first
+func closePurge(out *os.File, writer *bufio.Writer, err error) error {
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// filterLines writes the lines of in from offset which do not match to writer, it returns the number of removed
+// entries and the offset following the last line read. The last line without line feed is being written, it is left
+// for later unless partial is set. The lines which cannot be decoded are kept
+func filterLines(in *os.File, offset int64, writer *bufio.Writer, match func(Entry) bool, partial bool) (int, int64, error) {
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return 0, offset, err
+	}
+	removed := 0
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && (len(line) == 0 || !partial) {
+			return removed, offset, nil
+		}
+		if err != nil && err != io.EOF {
+			return removed, offset, err
+		}
+		offset += int64(len(line))
+		var entry Entry
+		if json.Unmarshal(bytes.TrimSuffix(line, []byte{'\n'}), &entry) == nil && match(entry) {
+			removed++
+			continue
+		}
+		_, _ = writer.Write(bytes.TrimSuffix(line, []byte{'\n'}))
+		_ = writer.WriteByte('\n')
+	}
+}
+
+// Purge removes the entries of the log and of its rotated files matching match. Each file is rewritten while the
+// entries go on being logged, then the entries logged meanwhile are filtered too and the new file replaces the
+// previous one while they wait
+func (l *Logger) Purge(match func(Entry) bool) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+	l.purgeLock.Lock()
+	defer l.purgeLock.Unlock()
+	l.lock.Lock()
+	closed := l.file == nil
+	l.lock.Unlock()
+	if closed {
+		return 0, os.ErrClosed
+	}
+	removed := 0
+	for _, file := range Files(l.path) {
+		n, err := l.purgeFile(file, match)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// purgeFile removes the entries of the file at path matching match, it may be rotated meanwhile: the new file replaces
+// it wherever it is then, nothing is done once it has been removed by the rotation
+func (l *Logger) purgeFile(path string, match func(Entry) bool) (int, error) {
+	in, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil // removed by a rotation since listed
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := createPurge(in, path)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(out.Name()) // no effect once renamed
+
+	writer := bufio.NewWriter(out)
+	removed, offset, err := filterLines(in, 0, writer, match, false)
+	if err != nil || removed == 0 {
+		return 0, closePurge(out, writer, err)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	current, ok := locate(in, Files(l.path))
+	if !ok {
+		_ = out.Close()
+		return 0, nil // removed by a rotation meanwhile
+	}
+	// the entries logged meanwhile, whole as the log waits
+	more, _, err := filterLines(in, offset, writer, match, true)
+	if err := closePurge(out, writer, err); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(out.Name(), current); err != nil {
+		return 0, err
+	}
+	if current == l.path && l.file != nil {
+		// the following entries go to the new file
+		return removed + more, l.open()
+	}
+	return removed + more, nil
+}
+
+// locate returns the path of the files which is the open file, the files of the log being rotated
+func locate(file *os.File, paths []string) (string, bool) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", false
+	}
+	for _, path := range paths {
+		if current, err := os.Stat(path); err == nil && os.SameFile(info, current) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// PurgeFiles removes the entries matching match from the log at path and its rotated files, it returns the number of removed entries.
+// The log must not be written meanwhile
+func PurgeFiles(path string, match func(Entry) bool) (int, error) {
+	removed := 0
+	for _, file := range Files(path) {
+		n, err := PurgeFile(file, match)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// StartRetention removes the entries older than retention from the log every hour until ctx is done
+func StartRetention(ctx context.Context, wg *sync.WaitGroup, l *Logger, retention time.Duration, clk clock.Clock) {
+	wg.Add(1)
+	go retentionScheduler(ctx, wg, l, retention, clk, clk.NewTicker(retentionInterval))
+}
+
+func retentionScheduler(ctx context.Context, wg *sync.WaitGroup, l *Logger, retention time.Duration, clk clock.Clock, ticker clock.Ticker) {
+	defer wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			removed, err := l.Purge(BeforeMatcher(clk.Now().Add(-retention)))
+			if err != nil {
+				logger.Error("cannot purge the query log", "err", err)
+				continue
+			}
+			if removed > 0 {
+				logger.Info("removed the expired entries from the query log", "entries", removed)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package querylog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func exportNames(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	out := &bytes.Buffer{}
+	if _, err := ExportCSV(file, out, ExportOptions{Fields: []string{"name"}}); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestLogger_Purge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	now := time.Now()
+	l.Log(Entry{Time: now, Client: "192.168.1.42", Name: "a.com"})
+	l.Log(Entry{Time: now, Client: "192.168.1.12", Name: "b.com"})
+	l.Log(Entry{Time: now, Client: "192.168.1.42", Name: "c.com"})
+
+	removed, err := l.Purge(ClientMatcher("192.168.1.42"))
+	if err != nil || removed != 2 {
+		t.Fatalf("Logger.Purge() = %d, %v, want 2 removed entries", removed, err)
+	}
+	// the entries following the purge are written to the new file
+	l.Log(Entry{Time: now, Client: "192.168.1.42", Name: "d.com"})
+	if got := exportNames(t, path); got != "name\nb.com\nd.com\n" {
+		t.Errorf("unexpected entries after the purge %q", got)
+	}
+	if _, err := os.Stat(path + ".purge"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file should be removed, got %v", err)
+	}
+
+	removed, err = PurgeFile(path, ClientMatcher("10.0.0.1"))
+	if err != nil || removed != 0 {
+		t.Errorf("PurgeFile() = %d, %v, want nothing removed", removed, err)
+	}
+}
+
+func TestFilterLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	content := `{"client":"192.168.1.42","name":"a.com"}` + "\n" + `{"client":"192.168.1.12","name":"b.com"}` + "\n" + `{"client":"192.168.1.42"`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out := &bytes.Buffer{}
+	writer := bufio.NewWriter(out)
+	removed, offset, err := filterLines(in, 0, writer, ClientMatcher("192.168.1.42"), false)
+	_ = writer.Flush()
+	if err != nil || removed != 1 || offset != int64(strings.LastIndex(content, "\n")+1) {
+		t.Errorf("filterLines() = %d, %d, %v, want the line being written left for later", removed, offset, err)
+	}
+	if out.String() != `{"client":"192.168.1.12","name":"b.com"}`+"\n" {
+		t.Errorf("unexpected kept lines %q", out.String())
+	}
+}
+
+func TestStartRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	clk := clock.NewFake(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	StartRetention(ctx, wg, l, 24*time.Hour, clk)
+
+	l.Log(Entry{Time: clk.Now(), Name: "old.com"})
+	clk.Advance(23 * time.Hour)
+	l.Log(Entry{Time: clk.Now(), Name: "recent.com"})
+	clk.Advance(2 * time.Hour)
+	// the last tick is delivered once the purge of the previous one is done
+	clk.Advance(time.Hour)
+
+	if got := exportNames(t, path); got != "name\nrecent.com\n" {
+		t.Errorf("the expired entries should be removed, got %q", got)
+	}
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,119 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("querylog")
+
+// Rcodes of the entries
+const (
+	NoError  = "NOERROR"
+	NXDomain = "NXDOMAIN"
+	NoData   = "NODATA"
+	ServFail = "SERVFAIL"
+	Refused  = "REFUSED"
+)
+
+// Entry is a question answered by the server, written as a json line
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client,omitempty"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Rcode  string    `json:"rcode"`
+	// Resolver is the name of the resolver of the chain which answered, empty when none did
+	Resolver string        `json:"resolver,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Logger appends the entries to a file, it is safe for concurrent use and a nil Logger logs nothing
+type Logger struct {
+	lock sync.Mutex
+	// purgeLock runs one purge at a time, the entries are logged while it rewrites the files
+	purgeLock sync.Mutex
+	path      string
+	file      *os.File
+	// size is the size of the file, the log is rotated once it reaches maxSize when positive
+	size    int64
+	maxSize int64
+	backups int
+	buffer  bytes.Buffer
+	encoder *json.Encoder
+}
+
+// Open opens the query log at path, the entries are appended to the existing ones
+func Open(path string) (*Logger, error) {
+	l := &Logger{path: path}
+	l.encoder = json.NewEncoder(&l.buffer)
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file at the path of the log, the lock must be held
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if l.file != nil {
+		_ = l.file.Close()
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Log writes the entry, the entries logged once the logger is closed are dropped
+func (l *Logger) Log(entry Entry) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return
+	}
+	l.buffer.Reset()
+	if err := l.encoder.Encode(entry); err != nil {
+		logger.Error("cannot encode the query log entry", "err", err)
+		return
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(l.buffer.Len()) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			logger.Error("cannot rotate the query log", "path", l.path, "err", err)
+		}
+	}
+	n, err := l.file.Write(l.buffer.Bytes())
+	l.size += int64(n)
+	if err != nil {
+		logger.Error("cannot write the query log", "path", l.path, "err", err)
+	}
+}
+
+// Close closes the file of the log
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
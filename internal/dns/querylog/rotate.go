@@ -0,0 +1,48 @@
+package querylog
+
+import (
+	"os"
+	"strconv"
+)
+
+// SetRotation rotates the log once its file reaches maxSize bytes, keeping backups rotated files,
+// path.1 being the most recent. At least one rotated file is kept, a maxSize of 0 disables the rotation
+func (l *Logger) SetRotation(maxSize int64, backups int) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.maxSize, l.backups = maxSize, max(backups, 1)
+}
+
+// rotate shifts the rotated files, renames the file of the log to path.1 and opens a new one, the lock must be held
+func (l *Logger) rotate() error {
+	_ = os.Remove(rotatedPath(l.path, l.backups))
+	for i := l.backups - 1; i > 0; i-- {
+		if err := os.Rename(rotatedPath(l.path, i), rotatedPath(l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func rotatedPath(path string, index int) string {
+	return path + "." + strconv.Itoa(index)
+}
+
+// Files returns the existing files of the log at path from the oldest to the current one
+func Files(path string) []string {
+	res := []string{path}
+	for i := 1; ; i++ {
+		rotated := rotatedPath(path, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		res = append([]string{rotated}, res...)
+	}
+	return res
+}
@@ -0,0 +1,51 @@
+package querylog
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLogger_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	entry := Entry{Time: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), Client: "192.168.1.42", Type: "A", Rcode: NoError}
+	entry.Name = "0.example.com"
+	l.Log(entry)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two entries per file
+	l.SetRotation(2*info.Size(), 2)
+	for i := 1; i < 7; i++ {
+		entry.Name = strconv.Itoa(i) + ".example.com"
+		l.Log(entry)
+	}
+
+	files := Files(path)
+	want := []string{path + ".2", path + ".1", path}
+	if len(files) != len(want) {
+		t.Fatalf("Files() = %v, want %v", files, want)
+	}
+	wantNames := []string{"name\n2.example.com\n3.example.com\n", "name\n4.example.com\n5.example.com\n", "name\n6.example.com\n"}
+	for i, file := range files {
+		if file != want[i] {
+			t.Errorf("Files()[%d] = %s, want %s", i, file, want[i])
+		}
+		if got := exportNames(t, file); got != wantNames[i] {
+			t.Errorf("entries of %s = %q, want %q", file, got, wantNames[i])
+		}
+	}
+
+	removed, err := l.Purge(ClientMatcher("192.168.1.42"))
+	if err != nil || removed != 5 {
+		t.Errorf("Logger.Purge() = %d, %v, want the 5 entries of all the files", removed, err)
+	}
+}
@@ -0,0 +1,119 @@
+// Package ratelimit limits the queries of every client with a token bucket, so a server exposed beyond the LAN
+// cannot be abused to reflect and amplify traffic toward a spoofed address
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+// Action is the handling of a query decided by the limiter
+type Action int
+
+const (
+	// Allow answers the query
+	Allow Action = iota
+	// Drop ignores the query
+	Drop
+	// Slip answers a truncated response without records, so a legitimate client retries over tcp
+	// while the spoofed victim receives no more bytes than the query (RRL slip)
+	Slip
+)
+
+const (
+	// maxBuckets is the number of clients tracked before the idle ones are forgotten
+	maxBuckets = 100000
+	// ipv6PrefixLength is the prefix of the ipv6 clients sharing a bucket, a host usually owns a /64
+	ipv6PrefixLength = 64
+)
+
+// bucket is the token bucket of a client
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// limited is the number of queries over the limit, to slip one out of slip
+	limited int
+}
+
+// Limiter limits the queries per second of every client, it is safe for concurrent use.
+// A nil Limiter allows every query
+type Limiter struct {
+	rate  float64
+	burst float64
+	slip  int
+	clock clock.Clock
+
+	lock    sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewLimiter instantiates a limiter allowing qps queries per second to every client with bursts of burst queries,
+// qps when burst is zero. One limited query out of slip is slipped, none when slip is not positive
+func NewLimiter(qps float64, burst int, slip int, clk clock.Clock) *Limiter {
+	if burst <= 0 {
+		burst = max(int(qps), 1)
+	}
+	return &Limiter{
+		rate:    qps,
+		burst:   float64(burst),
+		slip:    slip,
+		clock:   clk,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token of the bucket of the client, it returns the action to apply to its query
+func (l *Limiter) Allow(client net.IP) Action {
+	if l == nil || client == nil {
+		return Allow
+	}
+	key := bucketKey(client)
+	now := l.clock.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.forgetIdle(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return Allow
+	}
+	b.limited++
+	if l.slip > 0 && b.limited%l.slip == 0 {
+		return Slip
+	}
+	return Drop
+}
+
+// forgetIdle removes the buckets refilled since their last query, all of them when there are still too many,
+// it must be called with the lock held
+func (l *Limiter) forgetIdle(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) >= maxBuckets {
+		// too many active clients, likely spoofed, the limits restart from a full bucket
+		l.buckets = make(map[string]*bucket)
+	}
+}
+
+// bucketKey returns the key of the bucket of the client, its address or its /64 prefix in ipv6
+func bucketKey(client net.IP) string {
+	if client.To4() != nil {
+		return client.To4().String()
+	}
+	return client.Mask(net.CIDRMask(ipv6PrefixLength, 128)).String()
+}
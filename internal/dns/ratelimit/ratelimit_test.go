@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	l := NewLimiter(2, 3, 2, clk)
+	client := net.ParseIP("203.0.113.7")
+
+	var got []Action
+	for i := 0; i < 6; i++ {
+		got = append(got, l.Allow(client))
+	}
+	want := []Action{Allow, Allow, Allow, Drop, Slip, Drop}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Allow() = %v, want %v", got, want)
+	}
+	if l.Allow(net.ParseIP("203.0.113.8")) != Allow {
+		t.Errorf("expecting every client to have its own bucket")
+	}
+
+	// 2 queries per second refill a token every 500ms
+	clk.Advance(500 * time.Millisecond)
+	if l.Allow(client) != Allow || l.Allow(client) == Allow {
+		t.Errorf("expecting a single token after 500ms")
+	}
+}
+
+func TestLimiter_AllowIPv6Prefix(t *testing.T) {
+	l := NewLimiter(1, 1, 0, clock.NewFake(time.Now()))
+	if l.Allow(net.ParseIP("2001:db8::1")) != Allow {
+		t.Fatalf("expecting the first query to be allowed")
+	}
+	// the addresses of the same /64 share the bucket, without slip the limited queries are dropped
+	if got := l.Allow(net.ParseIP("2001:db8::2")); got != Drop {
+		t.Errorf("Allow() = %v, want %v", got, Drop)
+	}
+	if got := l.Allow(net.ParseIP("2001:db8:0:1::1")); got != Allow {
+		t.Errorf("Allow() = %v, want %v", got, Allow)
+	}
+	if (*Limiter)(nil).Allow(net.ParseIP("2001:db8::1")) != Allow {
+		t.Errorf("expecting a nil limiter to allow every query")
+	}
+}
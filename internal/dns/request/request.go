@@ -0,0 +1,92 @@
+package request
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"net"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// Transport is the protocol a query has been received with
+type Transport string
+
+const (
+	UDP  Transport = "udp"
+	TCP  Transport = "tcp"
+	DOH  Transport = "doh"
+	DOT  Transport = "dot"
+	GRPC Transport = "grpc"
+)
+
+// Request is the metadata of a query, filled by the endpoint receiving it and given to the resolvers of the chain.
+// The zero value is a query of unknown origin
+type Request struct {
+	// Client is the address the query comes from, nil when unknown
+	Client net.IP
+	// Zone is the interface of a link-local client address, like eth0, empty otherwise
+	Zone string
+	// Transport is the protocol of the endpoint
+	Transport Transport
+	// Endpoint is the listen address of the endpoint
+	Endpoint string
+	// View is the set of answers the client is entitled to, empty for the default one
+	View string
+	// Group is the client group of the client, resolved once by the resolver chain for all its resolvers,
+	// empty outside of the groups
+	Group string
+	// TraceID identifies the query in the logs
+	TraceID string
+	// Options are the EDNS options of the query forwarded to the upstream
+	Options []dto.Option
+}
+
+// NewTraceID returns a random identifier for a query
+func NewTraceID() string {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	return hex.EncodeToString(id[:])
+}
+
+// ClientAddress returns the ip of a host:port address without its zone, nil when it cannot be parsed
+func ClientAddress(address string) net.IP {
+	ip, _ := SplitClient(address)
+	return ip
+}
+
+// SplitClient returns the ip and the zone of a host:port address, like [fe80::1%eth0]:53, the ip is nil when it cannot be parsed
+func SplitClient(address string) (net.IP, string) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	host, zone, _ := strings.Cut(host, "%")
+	return net.ParseIP(host), zone
+}
+
+// String returns the known metadata of the request for the logs
+func (r Request) String() string {
+	fields := make([]string, 0, 4)
+	if r.TraceID != "" {
+		fields = append(fields, "trace="+r.TraceID)
+	}
+	if r.Transport != "" {
+		fields = append(fields, "transport="+string(r.Transport))
+	}
+	if r.Client != nil {
+		client := r.Client.String()
+		if r.Zone != "" {
+			client += "%" + r.Zone
+		}
+		fields = append(fields, "client="+client)
+	}
+	if r.View != "" {
+		fields = append(fields, "view="+r.View)
+	}
+	if r.Group != "" {
+		fields = append(fields, "group="+r.Group)
+	}
+	return strings.Join(fields, " ")
+}
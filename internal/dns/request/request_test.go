@@ -0,0 +1,53 @@
+package request
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRequest_String(t *testing.T) {
+	tests := []struct {
+		name    string
+		request Request
+		want    string
+	}{
+		{name: "unknown", request: Request{}, want: ""},
+		{
+			name:    "udp",
+			request: Request{Client: net.ParseIP("192.168.1.10"), Transport: UDP, Endpoint: "127.0.0.1:53", TraceID: "0a1b"},
+			want:    "trace=0a1b transport=udp client=192.168.1.10",
+		},
+		{name: "view", request: Request{Transport: DOH, View: "kids"}, want: "transport=doh view=kids"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.request.String(); got != tt.want {
+				t.Errorf("Request.String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    net.IP
+	}{
+		{address: "192.168.1.10:5353", want: net.ParseIP("192.168.1.10")},
+		{address: "[::1]:53", want: net.ParseIP("::1")},
+		{address: "10.0.0.1", want: net.ParseIP("10.0.0.1")},
+		{address: "[fe80::1%eth0]:53", want: net.ParseIP("fe80::1")},
+		{address: "invalid", want: nil},
+	}
+	for _, tt := range tests {
+		if got := ClientAddress(tt.address); !got.Equal(tt.want) {
+			t.Errorf("ClientAddress(%s) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+	if ip, zone := SplitClient("[fe80::1%eth0]:53"); !ip.Equal(net.ParseIP("fe80::1")) || zone != "eth0" {
+		t.Errorf("SplitClient() = %v %s, want fe80::1 eth0", ip, zone)
+	}
+	if NewTraceID() == NewTraceID() {
+		t.Errorf("expecting distinct trace ids")
+	}
+}
@@ -0,0 +1,68 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+// maximum number of questions of a batch resolved at the same time
+const maxBatchWorkers = 16
+
+// Result is the answer to a question of a batch, Err is set when the question has no answer
+type Result struct {
+	Question dto.Question
+	Record   dto.Record
+	Err      error
+}
+
+type indexedResult struct {
+	index  int
+	result Result
+}
+
+// ResolveBatch resolves the questions concurrently and returns their results in the same order.
+// The questions not answered when ctx is done get the error of the context, the other results are kept
+func (resolverChain *ResolverChain) ResolveBatch(ctx context.Context, questions []dto.Question) []Result {
+	results := make([]Result, len(questions))
+	jobs := make(chan int, len(questions))
+	for i := range questions {
+		results[i].Question = questions[i]
+		jobs <- i
+	}
+	close(jobs)
+
+	// buffered so the workers never block once the deadline is reached
+	done := make(chan indexedResult, len(questions))
+	for w := 0; w < min(maxBatchWorkers, len(questions)); w++ {
+		go func() {
+			for i := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				records, _, err := resolverChain.resolveOne(ctx, request.Request{}, questions[i])
+				record, err := client.First(records, err)
+				done <- indexedResult{index: i, result: Result{Question: questions[i], Record: record, Err: err}}
+			}
+		}()
+	}
+
+	answered := make([]bool, len(questions))
+	for remaining := len(questions); remaining > 0; remaining-- {
+		select {
+		case r := <-done:
+			results[r.index] = r.result
+			answered[r.index] = true
+		case <-ctx.Done():
+			for i := range results {
+				if !answered[i] {
+					results[i].Err = ctx.Err()
+				}
+			}
+			return results
+		}
+	}
+	return results
+}
@@ -0,0 +1,68 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+var _ Resolver = slowResolver{}
+
+// slowResolver answers after a delay for slow.lan, and delegates to resolverMock otherwise
+type slowResolver struct{}
+
+// Name implements Resolver
+func (slowResolver) Name() string {
+	return "slow"
+}
+
+// Resolve implements Resolver
+func (slowResolver) Resolve(question dto.Question) (dto.Record, bool) {
+	if question.Name == "slow.lan" {
+		time.Sleep(time.Second)
+	}
+	return resolverMock{}.Resolve(question)
+}
+
+func TestResolverChain_ResolveBatch(t *testing.T) {
+	resolverChain := NewResolverChain([]Resolver{slowResolver{}})
+
+	questions := []dto.Question{
+		{Name: "localhost", Type: dto.A, Class: dto.IN},
+		{Name: "slow.lan", Type: dto.A, Class: dto.IN},
+		{Name: "localhost", Type: dto.Type(50), Class: dto.IN},
+		{Name: "localhost", Type: dto.AAAA, Class: dto.IN},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results := resolverChain.ResolveBatch(ctx, questions)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the batch should stop at the deadline, took %v", elapsed)
+	}
+
+	if len(results) != len(questions) {
+		t.Fatalf("expecting %d results, got %d", len(questions), len(results))
+	}
+	for i, result := range results {
+		if result.Question != questions[i] {
+			t.Errorf("result %d is for %v, want %v", i, result.Question, questions[i])
+		}
+	}
+	if results[0].Err != nil || results[0].Record.Data.String() != "127.0.0.1" {
+		t.Errorf("unexpected result for localhost A %v", results[0])
+	}
+	if !errors.Is(results[1].Err, context.DeadlineExceeded) {
+		t.Errorf("expecting a deadline error for slow.lan, got %v", results[1].Err)
+	}
+	if results[2].Err == nil {
+		t.Errorf("expecting an error for an unknown type")
+	}
+	if results[3].Err != nil {
+		t.Errorf("unexpected error for localhost AAAA %v", results[3].Err)
+	}
+}
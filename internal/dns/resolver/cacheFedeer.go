@@ -1,16 +1,33 @@
 package resolver
 
 import (
+	"context"
+	"errors"
+
 	"github.com/bluguard/dnshield/internal/dns/cache"
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/client/offline"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/tracing"
 )
 
 var _ Resolver = &Cachefeeder{}
+var _ ErrorResolver = &Cachefeeder{}
+var _ RequestResolver = &Cachefeeder{}
+var _ SetResolver = &Cachefeeder{}
 
 // Cachefeeder is in charge to feed a cache based on the answer of a resolver
 type Cachefeeder struct {
 	delegate Resolver
 	cache    cache.Feedable
+	// maintenance is nil when the delegate is always used
+	maintenance *maintenance.Switch
+	// offline is nil when the delegate is always used, offlineAnswer answers the questions without stale record
+	offline       *offline.Switch
+	offlineAnswer offline.Answer
 }
 
 func NewCacheFeeder(delegate Resolver, cache cache.Feedable) *Cachefeeder {
@@ -20,6 +37,19 @@ func NewCacheFeeder(delegate Resolver, cache cache.Feedable) *Cachefeeder {
 	}
 }
 
+// SetMaintenance answers from the expired records of the cache only, without the delegate, while the maintenance is enabled.
+// It must be called before using the resolver
+func (r *Cachefeeder) SetMaintenance(m *maintenance.Switch) {
+	r.maintenance = m
+}
+
+// SetOffline answers from the expired records of the cache, or with answer, without the delegate while the switch is offline.
+// It must be called before using the resolver
+func (r *Cachefeeder) SetOffline(s *offline.Switch, answer offline.Answer) {
+	r.offline = s
+	r.offlineAnswer = answer
+}
+
 // Name implements Resolver
 func (r *Cachefeeder) Name() string {
 	return r.delegate.Name()
@@ -27,9 +57,96 @@ func (r *Cachefeeder) Name() string {
 
 // Resolve implements Resolver
 func (r *Cachefeeder) Resolve(question dto.Question) (dto.Record, bool) {
-	result, ok := r.delegate.Resolve(question)
-	if ok {
-		r.cache.Feed(result)
+	result, err := r.ResolveWithError(question)
+	return result, err == nil
+}
+
+// ResolveWithError implements ErrorResolver, the negative answers are fed to the cache when it supports them
+func (r *Cachefeeder) ResolveWithError(question dto.Question) (dto.Record, error) {
+	return r.ResolveRequest(context.Background(), request.Request{}, question)
+}
+
+// ResolveRequest implements RequestResolver
+func (r *Cachefeeder) ResolveRequest(ctx context.Context, req request.Request, question dto.Question) (dto.Record, error) {
+	return client.First(r.ResolveSet(ctx, req, question))
+}
+
+// ResolveSet implements SetResolver, the whole set is fed to the cache when it keeps sets
+func (r *Cachefeeder) ResolveSet(ctx context.Context, req request.Request, question dto.Question) ([]dto.Record, error) {
+	if r.maintenance.Enabled() {
+		return r.resolveStale(ctx, req, question, maintenance.ErrMaintenance)
+	}
+	if r.offline.Enabled() {
+		return r.resolveStale(ctx, req, question, r.offlineAnswer.Error(question.Name))
+	}
+	// the aliases and the DNSSEC outcome of the answer are kept with it, and reported to the chain
+	delegateCtx, status := dnssec.WithStatus(ctx)
+	delegateCtx, aliases := client.WithAliases(delegateCtx)
+	records, err := resolveSet(delegateCtx, r.delegate, req, question)
+	client.ReportAliases(ctx, aliases.Names())
+	dnssec.Share(ctx, status.Outcome())
+	if err != nil && isNegative(err) {
+		r.feedNegative(question, err, status.Outcome())
+		return records, err
+	}
+	if err != nil {
+		return r.resolveStale(ctx, req, question, err)
+	}
+	r.feed(records, aliases.Names(), status.Outcome())
+	return records, nil
+}
+
+// feed gives the records to the cache with their aliases and their DNSSEC outcome, only the first record when the cache
+// does not keep sets
+func (r *Cachefeeder) feed(records []dto.Record, aliases []string, outcome dnssec.Outcome) {
+	switch c := r.cache.(type) {
+	case cache.ValidatedFeedable:
+		c.FeedValidated(records, r.delegate.Name(), aliases, outcome)
+	case cache.AliasFeedable:
+		c.FeedAliased(records, r.delegate.Name(), aliases)
+	case cache.SetFeedable:
+		c.FeedSet(records, r.delegate.Name())
+	case cache.SourcedFeedable:
+		c.FeedFrom(records[0], r.delegate.Name())
+	default:
+		c.Feed(records[0])
+	}
+}
+
+// resolveStale answers with the expired records of the cache, when it keeps them, after a failure of the delegate
+func (r *Cachefeeder) resolveStale(ctx context.Context, req request.Request, question dto.Question, err error) ([]dto.Record, error) {
+	stale, ok := r.cache.(cache.StaleResolver)
+	if !ok {
+		return nil, err
+	}
+	_, span := tracing.Start(ctx, "cache stale lookup")
+	records, staleErr := stale.ResolveStaleSet(ctx, req, question.Name, question.Type)
+	span.SetBool("dns.cache.hit", staleErr == nil)
+	span.End(nil)
+	if staleErr != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *Cachefeeder) feedNegative(question dto.Question, err error, outcome dnssec.Outcome) {
+	var feed func(name string, t dto.Type, nxdomain bool, ttl uint32)
+	switch c := r.cache.(type) {
+	case cache.ValidatedFeedable:
+		feed = func(name string, t dto.Type, nxdomain bool, ttl uint32) {
+			c.FeedNegativeValidated(name, t, nxdomain, ttl, outcome)
+		}
+	case cache.NegativeFeedable:
+		feed = c.FeedNegative
+	default:
+		return
+	}
+	var nameError *client.NameError
+	var noDataError *client.NoDataError
+	switch {
+	case errors.As(err, &nameError):
+		feed(question.Name, question.Type, true, nameError.TTL)
+	case errors.As(err, &noDataError):
+		feed(question.Name, question.Type, false, noDataError.TTL)
 	}
-	return result, ok
 }
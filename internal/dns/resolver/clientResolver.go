@@ -1,11 +1,16 @@
 package resolver
 
 import (
+	"context"
 	"github.com/bluguard/dnshield/internal/dns/client"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
 )
 
 var _ Resolver = &ClientResolver{}
+var _ ErrorResolver = &ClientResolver{}
+var _ RequestResolver = &ClientResolver{}
+var _ SetResolver = &ClientResolver{}
 
 func NewClientresolver(c client.Client, name string) *ClientResolver {
 	return &ClientResolver{
@@ -28,18 +33,24 @@ func (resolver *ClientResolver) Name() string {
 // Resolve implements Resolver
 // Use the client to get the records
 func (resolver *ClientResolver) Resolve(question dto.Question) (dto.Record, bool) {
-	var callClient func(string) (dto.Record, error)
-	if question.Type == dto.A {
-		callClient = resolver.client.ResolveV4
-	} else if question.Type == dto.AAAA {
-		callClient = resolver.client.ResolveV6
-	}
-	if callClient == nil {
-		return dto.Record{}, false
-	}
-	record, err := callClient(question.Name)
+	record, err := resolver.ResolveWithError(question)
 	if err != nil {
 		return dto.Record{}, false
 	}
 	return record, true
 }
+
+// ResolveWithError implements ErrorResolver
+func (resolver *ClientResolver) ResolveWithError(question dto.Question) (dto.Record, error) {
+	return resolver.ResolveRequest(context.Background(), request.Request{}, question)
+}
+
+// ResolveRequest implements RequestResolver
+func (resolver *ClientResolver) ResolveRequest(ctx context.Context, req request.Request, question dto.Question) (dto.Record, error) {
+	return client.ResolveRequest(ctx, resolver.client, req, question.Name, question.Type)
+}
+
+// ResolveSet implements SetResolver
+func (resolver *ClientResolver) ResolveSet(ctx context.Context, req request.Request, question dto.Question) ([]dto.Record, error) {
+	return client.ResolveSet(ctx, resolver.client, req, question.Name, question.Type)
+}
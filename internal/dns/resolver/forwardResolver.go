@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ Resolver = &ForwardResolver{}
+var _ ErrorResolver = &ForwardResolver{}
+var _ RequestResolver = &ForwardResolver{}
+var _ SetResolver = &ForwardResolver{}
+
+// NewForwardResolver instantiate a ForwardResolver without zone, the zones must be added before using it
+func NewForwardResolver(name string) *ForwardResolver {
+	return &ForwardResolver{
+		name:  name,
+		zones: make(map[string]Resolver),
+	}
+}
+
+// ForwardResolver sends the questions about its zones to the resolver of the closest zone, the other questions are
+// left to the next resolvers of the chain. The questions of a zone never reach the next resolvers, so an internal zone
+// does not leak to the public upstream: a failure of the resolver of the zone is a failure of the chain
+type ForwardResolver struct {
+	name  string
+	zones map[string]Resolver
+}
+
+// Forward sends the questions about the zone and its subdomains to the delegate
+func (resolver *ForwardResolver) Forward(zone string, delegate Resolver) {
+	resolver.zones[normalize(zone)] = delegate
+}
+
+// Name implements Resolver
+func (resolver *ForwardResolver) Name() string {
+	return resolver.name
+}
+
+// Resolve implements Resolver
+func (resolver *ForwardResolver) Resolve(question dto.Question) (dto.Record, bool) {
+	record, err := resolver.ResolveWithError(question)
+	return record, err == nil
+}
+
+// ResolveWithError implements ErrorResolver
+func (resolver *ForwardResolver) ResolveWithError(question dto.Question) (dto.Record, error) {
+	return resolver.ResolveRequest(context.Background(), request.Request{}, question)
+}
+
+// ResolveRequest implements RequestResolver
+func (resolver *ForwardResolver) ResolveRequest(ctx context.Context, req request.Request, question dto.Question) (dto.Record, error) {
+	return client.First(resolver.ResolveSet(ctx, req, question))
+}
+
+// ResolveSet implements SetResolver
+func (resolver *ForwardResolver) ResolveSet(ctx context.Context, req request.Request, question dto.Question) ([]dto.Record, error) {
+	delegate, ok := resolver.route(question.Name)
+	if !ok {
+		return nil, client.NotFound(question.Name + " is not forwarded")
+	}
+	records, err := resolveSet(ctx, delegate, req, question)
+	if err != nil && !isNegative(err) {
+		return nil, &client.ServerFailError{Name: question.Name, Err: err}
+	}
+	return records, err
+}
+
+func (resolver *ForwardResolver) route(name string) (Resolver, bool) {
+	for zone := normalize(name); zone != ""; {
+		if delegate, ok := resolver.zones[zone]; ok {
+			return delegate, true
+		}
+		_, parent, _ := strings.Cut(zone, ".")
+		zone = parent
+	}
+	return nil, false
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
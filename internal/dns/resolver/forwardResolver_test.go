@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+// countingResolver counts the questions asked to the resolver it wraps
+type countingResolver struct {
+	Resolver
+	calls int
+}
+
+// Resolve implements Resolver
+func (r *countingResolver) Resolve(question dto.Question) (dto.Record, bool) {
+	r.calls++
+	return r.Resolver.Resolve(question)
+}
+
+func TestForwardResolver(t *testing.T) {
+	forward := NewForwardResolver("Forward")
+	corp := &inmemoryclient.InMemoryClient{}
+	_ = corp.Add("nas.corp.internal", "10.0.0.10")
+	forward.Forward("Corp.Internal.", NewClientresolver(corp, "corp"))
+	external := &countingResolver{Resolver: resolverMock{}}
+	chain := NewResolverChain([]Resolver{forward, external})
+
+	tests := []struct {
+		name         string
+		wantAnswer   bool
+		wantExternal int
+	}{
+		{name: "example.com", wantAnswer: true, wantExternal: 1},
+		{name: "corp.internal.example.com", wantAnswer: true, wantExternal: 1},
+		{name: "nas.corp.internal", wantAnswer: true, wantExternal: 0},
+		{name: "printer.corp.internal", wantAnswer: false, wantExternal: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			external.calls = 0
+			_, answeredBy, err := chain.ask(context.Background(), request.Request{}, dto.Question{Name: tt.name, Type: dto.A, Class: dto.IN})
+			if (err == nil) != tt.wantAnswer {
+				t.Errorf("ResolverChain.ask() error = %v, want an answer %v", err, tt.wantAnswer)
+			}
+			if external.calls != tt.wantExternal {
+				t.Errorf("the external resolver got %d questions, want %d", external.calls, tt.wantExternal)
+			}
+			var serverFail *client.ServerFailError
+			if !tt.wantAnswer && (!errors.As(err, &serverFail) || answeredBy != "Forward") {
+				t.Errorf("expecting a failure of the forward resolver, got %v from %s", err, answeredBy)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package resolver
+
+import (
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/policy"
+)
+
+var _ Resolver = &PolicyResolver{}
+var _ ResolvingRewriter = &PolicyResolver{}
+var _ ErrorResolver = &PolicyResolver{}
+
+// PolicyResolver applies a policy to the questions, blocking or rewriting them for the following resolvers
+type PolicyResolver struct {
+	policy   policy.Policy
+	name     string
+	response blocker.Response
+}
+
+func NewPolicyResolver(p policy.Policy, name string) *PolicyResolver {
+	return &PolicyResolver{
+		policy: p,
+		name:   name,
+	}
+}
+
+// SetResponse sets the answer to the blocked questions
+func (r *PolicyResolver) SetResponse(response blocker.Response) {
+	r.response = response
+}
+
+// Name implements Resolver
+func (r *PolicyResolver) Name() string {
+	return r.name
+}
+
+// RewriteResolve implements ResolvingRewriter, the policy is evaluated once per question: the blocked questions get the
+// response of the blocking mode, the rewritten ones are left to the following resolvers
+func (r *PolicyResolver) RewriteResolve(question dto.Question) (dto.Question, dto.Record, error) {
+	action, name := r.policy.Evaluate(question)
+	switch {
+	case action == policy.Rewrite:
+		question.Name = name
+	case action == policy.Block && (question.Type == dto.A || question.Type == dto.AAAA):
+		record, err := r.response.Answer(question.Name, question.Type)
+		return question, record, err
+	}
+	return question, dto.Record{}, client.NotFound("not blocking")
+}
+
+// Resolve implements Resolver
+// Answers only for blocked questions
+func (r *PolicyResolver) Resolve(question dto.Question) (dto.Record, bool) {
+	record, err := r.ResolveWithError(question)
+	return record, err == nil
+}
+
+// ResolveWithError implements ErrorResolver, the blocked questions get the response of the blocking mode
+func (r *PolicyResolver) ResolveWithError(question dto.Question) (dto.Record, error) {
+	if question.Type != dto.A && question.Type != dto.AAAA {
+		return dto.Record{}, client.NotFound("not blocking")
+	}
+	if action, _ := r.policy.Evaluate(question); action == policy.Block {
+		return r.response.Answer(question.Name, question.Type)
+	}
+	return dto.Record{}, client.NotFound("not blocking")
+}
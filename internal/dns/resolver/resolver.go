@@ -1,18 +1,84 @@
 package resolver
 
 import (
+	"context"
 	"errors"
-	"log"
+	"net"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/bluguard/dnshield/internal/dns/alert"
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/dnstap"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/fingerprint"
+	"github.com/bluguard/dnshield/internal/dns/metrics"
+	"github.com/bluguard/dnshield/internal/dns/privacy"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/sortlist"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+	"github.com/bluguard/dnshield/internal/dns/tracing"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
 )
 
+var logger = logging.Component("resolver")
+
 type Resolver interface {
 	Resolve(dto.Question) (dto.Record, bool)
 	Name() string
 }
 
+// ErrorResolver is a resolver telling why it has no answer, a *client.NameError, a *client.NoDataError or a *client.RefusedError
+// stops the chain with a negative answer instead of asking the following resolvers, a *client.ServerFailError stops it with a failure
+type ErrorResolver interface {
+	ResolveWithError(dto.Question) (dto.Record, error)
+}
+
+// RequestResolver is a resolver using the metadata of the request, like the address of the client, and stopping once
+// the context of the request is done. Its errors have the same meaning as the ones of an ErrorResolver
+type RequestResolver interface {
+	ResolveRequest(context.Context, request.Request, dto.Question) (dto.Record, error)
+}
+
+// SetResolver is a resolver answering all the records of the name and the type of the question, like the addresses of
+// a load balanced service. Its errors have the same meaning as the ones of an ErrorResolver
+type SetResolver interface {
+	ResolveSet(context.Context, request.Request, dto.Question) ([]dto.Record, error)
+}
+
+// Rewriter is a resolver able to modify the question asked to itself and to the following resolvers of the chain
+type Rewriter interface {
+	Rewrite(dto.Question) dto.Question
+}
+
+// RequestRewriter is a Rewriter depending on the client of the request
+type RequestRewriter interface {
+	RewriteRequest(request.Request, dto.Question) dto.Question
+}
+
+// ResolvingRewriter is a Rewriter deciding at once whether it answers the question or rewrites it for the following
+// resolvers. Its errors have the same meaning as the ones of an ErrorResolver
+type ResolvingRewriter interface {
+	RewriteResolve(dto.Question) (dto.Question, dto.Record, error)
+}
+
+// GroupMatcher tells the client group of a client, empty outside of the groups
+type GroupMatcher interface {
+	MatchGroup(ip net.IP, zone string) string
+}
+
+// Cloaker checks the CNAME chain of an answer for the client of the request, it returns the response replacing the
+// answer, and true, when the chain goes through a name blocked for the client
+type Cloaker interface {
+	Cloak(req request.Request, question dto.Question, aliases []string) ([]dto.Record, bool, error)
+}
+
+// CloakingName is the name of the answers replaced by the Cloaker
+const CloakingName = "Cloaking"
+
 func NewResolverChain(chain []Resolver) *ResolverChain {
 	return &ResolverChain{
 		chain: chain,
@@ -21,11 +87,138 @@ func NewResolverChain(chain []Resolver) *ResolverChain {
 
 // ResolverChain is in charge to ask all subresolver if they know the answer to the every question in the dns message
 type ResolverChain struct {
-	chain []Resolver
+	chain   []Resolver
+	stats   *stats.Stats
+	metrics *metrics.Metrics
+	// failures watches the rate of the questions answered with SERVFAIL, nil when not watched
+	failures *alert.Monitor
+	// queryLog records every question, nil when disabled
+	queryLog *querylog.Logger
+	// anonymizer anonymizes the clients and the names recorded by the stats, the query log and the traces, nil to keep them
+	anonymizer *privacy.Anonymizer
+	// tap emits the queries and their responses as dnstap messages, nil when disabled
+	tap *dnstap.Logger
+	// tracer starts the traces of the sampled queries, nil when disabled
+	tracer *tracing.Tracer
+	// fingerprints infers the categories of the clients from their questions, nil when disabled
+	fingerprints *fingerprint.Fingerprints
+	// sorter orders the addresses of the answers, nil to keep the order of the resolvers
+	sorter *sortlist.Sorter
+	// blocking are the names of the resolvers whose answers are counted as blocked in the stats
+	blocking map[string]bool
+	// cloaker checks the CNAME chains of the answers for every request, nil when they are not checked
+	cloaker Cloaker
+	// passUnknownOptions forwards the EDNS options of the queries not defined by a rfc, they are stripped otherwise
+	passUnknownOptions bool
+	// groups resolves the client group of the requests, nil without group
+	groups GroupMatcher
+}
+
+// SetStats set the counters updated by the chain for every question
+func (resolverChain *ResolverChain) SetStats(s *stats.Stats) {
+	resolverChain.stats = s
+}
+
+// SetFailureMonitor set the monitor counting the questions no resolver was able to answer
+func (resolverChain *ResolverChain) SetFailureMonitor(m *alert.Monitor) {
+	resolverChain.failures = m
+}
+
+// SetGroups sets the client groups, the group of a client is resolved once for all the resolvers so they agree on it
+func (resolverChain *ResolverChain) SetGroups(g GroupMatcher) {
+	resolverChain.groups = g
+}
+
+// SetBlocking set the names of the resolvers whose answers are counted as blocked
+func (resolverChain *ResolverChain) SetBlocking(names ...string) {
+	resolverChain.blocking = make(map[string]bool, len(names))
+	for _, name := range names {
+		resolverChain.blocking[name] = true
+	}
+}
+
+// SetCloaker set the check of the CNAME chains of the answers, for the client of every request. The answers of the
+// cache are checked like the ones of the upstreams, so an answer cached for a client is checked for the next ones
+func (resolverChain *ResolverChain) SetCloaker(c Cloaker) {
+	resolverChain.cloaker = c
 }
 
+// SetMetrics set the metrics updated by the chain for every question
+func (resolverChain *ResolverChain) SetMetrics(m *metrics.Metrics) {
+	resolverChain.metrics = m
+}
+
+// SetQueryLog set the log recording every question
+func (resolverChain *ResolverChain) SetQueryLog(l *querylog.Logger) {
+	resolverChain.queryLog = l
+}
+
+// SetAnonymizer set the anonymizer of the clients and the names recorded for every question
+func (resolverChain *ResolverChain) SetAnonymizer(a *privacy.Anonymizer) {
+	resolverChain.anonymizer = a
+}
+
+// SetDnstap set the logger emitting every query and its response as dnstap messages
+func (resolverChain *ResolverChain) SetDnstap(l *dnstap.Logger) {
+	resolverChain.tap = l
+}
+
+// SetTracer set the tracer exporting the spans of the sampled queries
+func (resolverChain *ResolverChain) SetTracer(t *tracing.Tracer) {
+	resolverChain.tracer = t
+}
+
+// Trace starts the root span of the query of the request, when sampled. The endpoints start it before parsing the
+// query, and end it once answered. The returned context carries the span to the spans of the resolution
+func (resolverChain *ResolverChain) Trace(ctx context.Context, req request.Request) (context.Context, *tracing.Span) {
+	ctx, span := resolverChain.tracer.Trace(ctx, req.TraceID, "query")
+	span.SetString("network.transport", string(req.Transport))
+	if req.Client != nil {
+		span.SetString("client.address", resolverChain.anonymizer.Client(req.Client))
+	}
+	return ctx, span
+}
+
+// SetFingerprints set the categories of the clients inferred from every question
+func (resolverChain *ResolverChain) SetFingerprints(f *fingerprint.Fingerprints) {
+	resolverChain.fingerprints = f
+}
+
+// SetSorter set the ordering of the addresses of every answer
+func (resolverChain *ResolverChain) SetSorter(s *sortlist.Sorter) {
+	resolverChain.sorter = s
+}
+
+// SetPassUnknownOptions tells whether the EDNS options not defined by a rfc, like device ids, are forwarded to the upstream
+func (resolverChain *ResolverChain) SetPassUnknownOptions(pass bool) {
+	resolverChain.passUnknownOptions = pass
+}
+
+// Resolve answers the questions of the message, without metadata about the request
 func (resolverChain *ResolverChain) Resolve(message dto.Message) dto.Message {
-	records := resolverChain.resolveAll(message.Question)
+	return resolverChain.ResolveRequest(context.Background(), request.Request{}, message)
+}
+
+// ResolveRequest answers the questions of the message, the context and the metadata of the request are given to the resolvers
+// using them. The questions not answered once ctx is done have no answer
+func (resolverChain *ResolverChain) ResolveRequest(ctx context.Context, req request.Request, message dto.Message) dto.Message {
+	var query []byte
+	received := time.Now()
+	if resolverChain.tap != nil {
+		query = dto.SerializeMessage(message)
+		resolverChain.tap.ClientQuery(req, query, received)
+	}
+	req.Options = resolverChain.forwardedOptions(req, message)
+	records, rcode, authenticated := resolverChain.resolveAll(ctx, req, message.Question)
+	if span := tracing.FromContext(ctx); span != nil {
+		for _, question := range message.Question {
+			span.SetQuestionName(question.Name)
+			span.SetString("dns.question.type", question.Type.String())
+		}
+		span.SetInt("dns.rcode", int64(rcode))
+		span.SetInt("dns.answers", int64(len(records)))
+	}
+	resolverChain.sorter.Sort(records)
 	response := dto.Message{
 		ID:            message.ID,
 		Header:        dto.STANDARD_RESPONSE,
@@ -34,28 +227,258 @@ func (resolverChain *ResolverChain) Resolve(message dto.Message) dto.Message {
 		Question:      message.Question,
 		Response:      records,
 	}
+	response.Header |= rcode
+	if authenticated && wantsAuthenticated(message) {
+		response.Header |= dto.AUTHENTIC_DATA
+	}
+	dto.EchoOPT(message, &response)
+	if resolverChain.tap != nil {
+		resolverChain.tap.ClientResponse(req, query, dto.SerializeMessage(response), received, time.Now())
+	}
 
 	return response
 }
 
-func (resolverChain *ResolverChain) resolveAll(questions []dto.Question) []dto.Record {
+// Explain asks the question like a query of the request would, it returns the records and the name of the resolver
+// which answered, empty when none of the resolvers did
+func (resolverChain *ResolverChain) Explain(ctx context.Context, req request.Request, question dto.Question) ([]dto.Record, string, error) {
+	return resolverChain.ask(ctx, req, question)
+}
+
+// forwardedOptions returns the EDNS options of the query passed to the upstream,
+// the known options only concern the hop to the server and are never forwarded
+func (resolverChain *ResolverChain) forwardedOptions(req request.Request, message dto.Message) []dto.Option {
+	opt, ok := dto.FindOPT(message)
+	if !ok || !resolverChain.passUnknownOptions {
+		return nil
+	}
+	options, err := dto.ParseOptions(opt.Raw)
+	if err != nil {
+		logger.Warn("dropping the EDNS options", "request", req, "err", err)
+		return nil
+	}
+	res := make([]dto.Option, 0, len(options))
+	for _, option := range options {
+		if !option.Known() {
+			res = append(res, option)
+		}
+	}
+	return res
+}
+
+// resolveAll returns the records answering the questions and the rcode of the response,
+// NAME_ERROR when a name does not exist, REFUSED when a question is refused or SERVER_FAILURE when
+// a resolver failed, a question without record in any resolver is answered without error.
+// The response is authenticated when the answers of all the questions are secure
+func (resolverChain *ResolverChain) resolveAll(ctx context.Context, req request.Request, questions []dto.Question) ([]dto.Record, uint16, bool) {
 	records := make([]dto.Record, 0, 4)
+	var rcode uint16
+	authenticated := len(questions) > 0
 	for _, question := range questions {
-		r, err := resolverChain.resolveOne(question)
-		if err != nil {
-			log.Println(err.Error())
-		} else {
-			records = append(records, r)
+		answers, secure, err := resolverChain.resolveOne(ctx, req, question)
+		authenticated = authenticated && secure
+		var nameError *client.NameError
+		var noDataError *client.NoDataError
+		var refusedError *client.RefusedError
+		switch {
+		case err == nil:
+			records = append(records, answers...)
+		case errors.As(err, &refusedError):
+			rcode = dto.REFUSED
+		case errors.As(err, &nameError):
+			if rcode != dto.REFUSED {
+				rcode = dto.NAME_ERROR
+			}
+		case errors.As(err, &noDataError):
+		case errors.Is(err, client.ErrNotFound):
+			logger.Debug("no record for the question", "request", req, "name", question.Name, "type", question.Type)
+		default:
+			if rcode == 0 {
+				rcode = dto.SERVER_FAILURE
+			}
+			logger.Warn("cannot resolve the question", "request", req, "name", question.Name, "type", question.Type, "err", err)
 		}
 	}
-	return records
+	return records, rcode, authenticated
+}
+
+// resolveOne answers the question, it tells if the answer is authenticated by the DNSSEC policy of its upstream
+func (resolverChain *ResolverChain) resolveOne(ctx context.Context, req request.Request, question dto.Question) ([]dto.Record, bool, error) {
+	start := time.Now()
+	resolverChain.fingerprints.Observe(req.Client, question.Name)
+	ctx, status := dnssec.WithStatus(ctx)
+	records, answeredBy, err := resolverChain.ask(ctx, req, question)
+	outcome := status.Outcome()
+	if outcome != "" {
+		resolverChain.metrics.Validation(string(outcome))
+	}
+	blocked := resolverChain.blocking[answeredBy] || errors.Is(err, client.ErrBlocked)
+	resolverChain.stats.Observe(resolverChain.anonymizer.Client(req.Client), question.Name, blocked)
+	if resolverChain.queryLog != nil {
+		entry := newEntry(req, question, start, answeredBy, err)
+		entry.Client, entry.Name = resolverChain.anonymizer.Client(req.Client), resolverChain.anonymizer.Name(entry.Name)
+		resolverChain.queryLog.Log(entry)
+	}
+	return records, outcome == dnssec.Secure && (err == nil || isNegative(err)), err
+}
+
+// wantsAuthenticated tells if the client asked for the AD bit, with the AD bit or the DO bit of its query (rfc6840 section 5.7)
+func wantsAuthenticated(message dto.Message) bool {
+	if message.Header&dto.AUTHENTIC_DATA != 0 {
+		return true
+	}
+	opt, ok := dto.FindOPT(message)
+	return ok && opt.TTL&dto.DOBit != 0
 }
 
-func (resolverChain *ResolverChain) resolveOne(question dto.Question) (dto.Record, error) {
+// ask asks the question to the resolvers of the chain until one answers, it returns the name of the resolver which answered
+func (resolverChain *ResolverChain) ask(ctx context.Context, req request.Request, question dto.Question) ([]dto.Record, string, error) {
+	resolverChain.stats.Query()
+	resolverChain.metrics.Query(question.Type)
+	if resolverChain.groups != nil && req.Group == "" && req.Client != nil {
+		req.Group = resolverChain.groups.MatchGroup(req.Client, req.Zone)
+	}
+	name := question.Name
+	// the names are asked in lower case to the resolvers, the ones sent with a random case (0x20) match the local names
+	question.Name = strings.ToLower(question.Name)
+	var failure error
 	for _, resolver := range resolverChain.chain {
-		if record, ok := resolver.Resolve(question); ok {
-			return record, nil
+		if rewriter, ok := resolver.(Rewriter); ok {
+			question = rewriter.Rewrite(question)
+		}
+		if rewriter, ok := resolver.(RequestRewriter); ok {
+			question = rewriter.RewriteRequest(req, question)
+		}
+		if ctx.Err() != nil {
+			// the client no longer waits for the answer
+			resolverChain.failure()
+			return nil, "", client.WrapTimeout(ctx.Err())
+		}
+		start := time.Now()
+		spanCtx, span := tracing.Start(ctx, "resolver "+resolver.Name())
+		spanCtx, aliases := client.WithAliases(spanCtx)
+		var records []dto.Record
+		var err error
+		if rewriter, ok := resolver.(ResolvingRewriter); ok {
+			var record dto.Record
+			if question, record, err = rewriter.RewriteResolve(question); err == nil {
+				records = []dto.Record{record}
+			}
+		} else {
+			records, err = resolveSet(spanCtx, resolver, req, question)
+		}
+		span.SetInt("dns.answers", int64(len(records)))
+		span.End(err)
+		resolverChain.metrics.Observe(resolver.Name(), time.Since(start))
+		if err == nil && resolverChain.cloaker != nil {
+			if cloaked, ok, cloakErr := resolverChain.cloaker.Cloak(req, question, aliases.Names()); ok {
+				for i := range cloaked {
+					cloaked[i].Name = name
+				}
+				resolverChain.stats.Answer(CloakingName)
+				resolverChain.metrics.Answer(CloakingName)
+				return cloaked, CloakingName, cloakErr
+			}
+		}
+		if err == nil {
+			for i := range records {
+				records[i].Name = name // Keep the answer consistent with the initial question
+			}
+			resolverChain.stats.Answer(resolver.Name())
+			resolverChain.metrics.Answer(resolver.Name())
+			return records, resolver.Name(), nil
+		}
+		if isNegative(err) {
+			resolverChain.stats.Answer(resolver.Name())
+			resolverChain.metrics.Answer(resolver.Name())
+			return nil, resolver.Name(), err
 		}
+		resolverChain.metrics.Error(resolver.Name(), err)
+		var serverFail *client.ServerFailError
+		if errors.As(err, &serverFail) {
+			resolverChain.failure()
+			return nil, resolver.Name(), err
+		}
+		if !errors.Is(err, client.ErrNotFound) {
+			failure = err
+		}
+	}
+	if failure != nil {
+		// the resolver which failed might have answered
+		resolverChain.failure()
+		return nil, "", &client.ServerFailError{Name: name, Err: failure}
+	}
+	// a name no resolver knows is answered without record, it is not a failure of the server
+	return nil, "", client.NotFound("no record found for " + question.Name + " with class " + strconv.Itoa(int(question.Type)))
+}
+
+// failure counts a question no resolver was able to answer, it is answered with SERVFAIL
+func (resolverChain *ResolverChain) failure() {
+	resolverChain.stats.Failure()
+	resolverChain.metrics.Failure()
+	resolverChain.failures.Failure()
+}
+
+// newEntry returns the entry of the query log of a question
+func newEntry(req request.Request, question dto.Question, start time.Time, answeredBy string, err error) querylog.Entry {
+	entry := querylog.Entry{
+		Time:     start,
+		Name:     question.Name,
+		Type:     question.Type.String(),
+		Rcode:    querylog.NoError,
+		Resolver: answeredBy,
+		Duration: time.Since(start),
+	}
+	if req.Client != nil {
+		entry.Client = req.Client.String()
 	}
-	return dto.Record{}, errors.New("no record found for " + question.Name + " with class " + strconv.Itoa(int(question.Type)))
+	var nameError *client.NameError
+	var noDataError *client.NoDataError
+	var refusedError *client.RefusedError
+	switch {
+	case err == nil:
+	case errors.As(err, &nameError):
+		entry.Rcode = querylog.NXDomain
+	case errors.As(err, &refusedError):
+		entry.Rcode = querylog.Refused
+	case errors.As(err, &noDataError):
+		entry.Rcode = querylog.NoData
+	default:
+		entry.Rcode = querylog.ServFail
+	}
+	return entry
+}
+
+// resolve asks the question to the resolver, with the metadata of the request and the reason of the failure when it supports them
+func resolve(ctx context.Context, resolver Resolver, req request.Request, question dto.Question) (dto.Record, error) {
+	if requestResolver, ok := resolver.(RequestResolver); ok {
+		return requestResolver.ResolveRequest(ctx, req, question)
+	}
+	if errorResolver, ok := resolver.(ErrorResolver); ok {
+		return errorResolver.ResolveWithError(question)
+	}
+	if record, ok := resolver.Resolve(question); ok {
+		return record, nil
+	}
+	return dto.Record{}, client.NotFound("no answer from " + resolver.Name())
+}
+
+// resolveSet asks the question to the resolver, all the records of the answer when it supports them
+func resolveSet(ctx context.Context, resolver Resolver, req request.Request, question dto.Question) ([]dto.Record, error) {
+	if setResolver, ok := resolver.(SetResolver); ok {
+		return setResolver.ResolveSet(ctx, req, question)
+	}
+	record, err := resolve(ctx, resolver, req, question)
+	if err != nil {
+		return nil, err
+	}
+	return []dto.Record{record}, nil
+}
+
+// isNegative tells if the error is an authoritative negative answer or a refusal
+func isNegative(err error) bool {
+	var nameError *client.NameError
+	var noDataError *client.NoDataError
+	var refusedError *client.RefusedError
+	return errors.As(err, &nameError) || errors.As(err, &noDataError) || errors.As(err, &refusedError)
 }
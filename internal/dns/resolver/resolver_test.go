@@ -1,11 +1,31 @@
 package resolver
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/bluguard/dnshield/internal/dns/cache/memorycache"
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/client/offline"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/policy"
+	"github.com/bluguard/dnshield/internal/dns/privacy"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/stats"
 )
 
 var _ Resolver = resolverMock{}
@@ -164,3 +184,678 @@ func TestResolverChain_Resolve(t *testing.T) {
 		})
 	}
 }
+
+var _ client.Client = &negativeClient{}
+
+// negativeClient answers nxdomain.lan does not exist and nodata.lan has no address
+type negativeClient struct {
+	calls int
+}
+
+// ResolveV4 implements client.Client
+func (c *negativeClient) ResolveV4(name string) (dto.Record, error) {
+	c.calls++
+	if name == "nxdomain.lan" {
+		return dto.Record{}, &client.NameError{Name: name, TTL: 300}
+	}
+	return dto.Record{}, &client.NoDataError{Name: name, Type: dto.A, TTL: 300}
+}
+
+// ResolveV6 implements client.Client
+func (c *negativeClient) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveV4(name)
+}
+
+func TestResolverChain_Negative(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	memCache := memorycache.NewMemoryCache(ctx, wg, 1000, 1, false, time.Minute)
+	upstream := &negativeClient{}
+	resolverChain := NewResolverChain([]Resolver{
+		NewClientresolver(memCache, "Cache"),
+		NewCacheFeeder(NewClientresolver(upstream, "External"), memCache),
+		resolverMock{}, // never asked, the negative answers stop the chain
+	})
+
+	tests := []struct {
+		name       string
+		wantHeader uint16
+	}{
+		{name: "nxdomain.lan", wantHeader: dto.STANDARD_RESPONSE | dto.NAME_ERROR},
+		{name: "nodata.lan", wantHeader: dto.STANDARD_RESPONSE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream.calls = 0
+			for i := 0; i < 3; i++ {
+				got := resolverChain.Resolve(dto.Message{
+					ID:            1,
+					Header:        dto.STANDARD_QUERY,
+					QuestionCount: 1,
+					Question:      []dto.Question{{Name: tt.name, Type: dto.A, Class: dto.IN}},
+				})
+				if got.Header != tt.wantHeader || got.ResponseCount != 0 {
+					t.Fatalf("ResolverChain.Resolve() = %v, want header %x without answer", got, tt.wantHeader)
+				}
+			}
+			if upstream.calls != 1 {
+				t.Errorf("expecting the negative answer to be cached, got %d upstream calls", upstream.calls)
+			}
+		})
+	}
+}
+
+// errorClient fails every question with its error
+type errorClient struct {
+	err error
+}
+
+// ResolveV4 implements client.Client
+func (c errorClient) ResolveV4(string) (dto.Record, error) {
+	return dto.Record{}, c.err
+}
+
+// ResolveV6 implements client.Client
+func (c errorClient) ResolveV6(string) (dto.Record, error) {
+	return dto.Record{}, c.err
+}
+
+func TestResolverChain_Failure(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantHeader uint16
+	}{
+		{name: "not found", err: client.NotFound("no record"), wantHeader: dto.STANDARD_RESPONSE},
+		{name: "timeout", err: client.Timeout(errors.New("no response")), wantHeader: dto.STANDARD_RESPONSE | dto.SERVER_FAILURE},
+		{name: "failure", err: errors.New("connection refused"), wantHeader: dto.STANDARD_RESPONSE | dto.SERVER_FAILURE},
+		{name: "blocked", err: client.Blocked(&client.NameError{Name: "ads.com"}), wantHeader: dto.STANDARD_RESPONSE | dto.NAME_ERROR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolverChain := NewResolverChain([]Resolver{
+				NewClientresolver(errorClient{tt.err}, "External"),
+				NewClientresolver(errorClient{client.NotFound("no record")}, "Local"),
+			})
+			counters := stats.NewStats()
+			resolverChain.SetStats(counters)
+			got := resolverChain.Resolve(dto.Message{
+				ID:            1,
+				Header:        dto.STANDARD_QUERY,
+				QuestionCount: 1,
+				Question:      []dto.Question{{Name: "ads.com", Type: dto.A, Class: dto.IN}},
+			})
+			if got.Header != tt.wantHeader || got.ResponseCount != 0 {
+				t.Errorf("ResolverChain.Resolve() = %v, want header %x without answer", got, tt.wantHeader)
+			}
+			if blocked := counters.Top(1).Blocked; (len(blocked) == 1) != errors.Is(tt.err, client.ErrBlocked) {
+				t.Errorf("unexpected blocked questions %v", blocked)
+			}
+			// only the questions answered with SERVFAIL are failures of the server
+			wantFailures := uint64(0)
+			if tt.wantHeader == dto.STANDARD_RESPONSE|dto.SERVER_FAILURE {
+				wantFailures = 1
+			}
+			if failures := counters.Snapshot().Failures; failures != wantFailures {
+				t.Errorf("expecting %d failures, got %d", wantFailures, failures)
+			}
+		})
+	}
+}
+
+var _ client.RequestClient = &viewClient{}
+
+// viewClient answers an address depending on the view of the request
+type viewClient struct {
+	requests []request.Request
+}
+
+// ResolveV4 implements client.Client
+func (c *viewClient) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *viewClient) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *viewClient) ResolveRequest(_ context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	c.requests = append(c.requests, req)
+	address := "10.0.0.1"
+	if req.View == "staging" {
+		address = "10.0.0.2"
+	}
+	return dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP(address).To4()}, nil
+}
+
+func TestResolverChain_ResolveRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	upstream := &viewClient{}
+	resolverChain := NewResolverChain([]Resolver{
+		NewCacheFeeder(NewClientresolver(upstream, "External"), memorycache.NewMemoryCache(ctx, wg, 1000, 1, false, time.Minute)),
+	})
+	message := dto.Message{
+		ID:            1,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "api.example.com", Type: dto.A, Class: dto.IN}},
+	}
+
+	req := request.Request{Client: net.ParseIP("192.168.1.10"), Transport: request.UDP, View: "staging", TraceID: "0a1b"}
+	got := resolverChain.ResolveRequest(context.Background(), req, message)
+	if got.ResponseCount != 1 || !got.Response[0].Data.Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("expecting the answer of the staging view, got %v", got)
+	}
+	if len(upstream.requests) != 1 || !reflect.DeepEqual(upstream.requests[0], req) {
+		t.Errorf("the metadata should reach the client, got %v", upstream.requests)
+	}
+
+	got = resolverChain.Resolve(message)
+	if got.ResponseCount != 1 || !got.Response[0].Data.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("expecting the answer of the default view, got %v", got)
+	}
+}
+
+func TestResolverChain_ResolveRequestCancelled(t *testing.T) {
+	upstream := &viewClient{}
+	resolverChain := NewResolverChain([]Resolver{NewClientresolver(upstream, "External")})
+	counters := stats.NewStats()
+	resolverChain.SetStats(counters)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	got := resolverChain.ResolveRequest(ctx, request.Request{Transport: request.UDP}, dto.Message{
+		ID:            1,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "example.com", Type: dto.A, Class: dto.IN}},
+	})
+	if got.ResponseCount != 0 || len(upstream.requests) != 0 {
+		t.Errorf("expecting the upstream not to be asked once the client is gone, got %v and %d requests", got, len(upstream.requests))
+	}
+	if failures := counters.Snapshot().Failures; failures != 1 {
+		t.Errorf("expecting the cancelled question to be counted as a failure, got %d", failures)
+	}
+}
+
+func TestResolverChain_UnknownOptions(t *testing.T) {
+	message := dto.Message{
+		ID:            1,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "example.com", Type: dto.A, Class: dto.IN}},
+	}
+	deviceID := dto.Option{Code: 26946, Data: []byte("device")}
+	dto.SetOptions(&message, []dto.Option{{Code: dto.Cookie, Data: []byte("12345678")}, deviceID})
+
+	tests := []struct {
+		name string
+		pass bool
+		want []dto.Option
+	}{
+		{name: "strip", pass: false, want: nil},
+		{name: "pass", pass: true, want: []dto.Option{deviceID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &viewClient{}
+			resolverChain := NewResolverChain([]Resolver{NewClientresolver(upstream, "External")})
+			resolverChain.SetPassUnknownOptions(tt.pass)
+			response := resolverChain.ResolveRequest(context.Background(), request.Request{Transport: request.UDP}, message)
+			if len(upstream.requests) != 1 || !reflect.DeepEqual(upstream.requests[0].Options, tt.want) {
+				t.Errorf("expecting the options %v to be forwarded, got %v", tt.want, upstream.requests)
+			}
+			if opt, ok := dto.FindOPT(response); !ok || opt.Class != dto.EDNSPayloadSize {
+				t.Errorf("expecting the response to echo the OPT record, got %v", response.Additional)
+			}
+		})
+	}
+}
+
+func TestResolverChain_QueryLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := querylog.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolverChain := NewResolverChain([]Resolver{
+		NewClientresolver(&negativeClient{}, "Negative"),
+	})
+	resolverChain.SetQueryLog(l)
+	req := request.Request{Client: net.ParseIP("10.0.0.3")}
+	resolverChain.ResolveRequest(context.Background(), req, dto.Message{
+		ID:            1,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 2,
+		Question: []dto.Question{
+			{Name: "nxdomain.lan", Type: dto.A, Class: dto.IN},
+			{Name: "nodata.lan", Type: dto.AAAA, Class: dto.IN},
+		},
+	})
+	resolverChain.SetQueryLog(nil)
+	resolverChain.Resolve(dto.Message{ID: 2, QuestionCount: 1, Question: []dto.Question{{Name: "nxdomain.lan", Type: dto.A, Class: dto.IN}}})
+	_ = l.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	out := &bytes.Buffer{}
+	if _, err := querylog.ExportCSV(file, out, querylog.ExportOptions{Fields: []string{"client", "name", "type", "rcode", "resolver"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := "client,name,type,rcode,resolver\n" +
+		"10.0.0.3,nxdomain.lan,A,NXDOMAIN,Negative\n" +
+		"10.0.0.3,nodata.lan,AAAA,NODATA,Negative\n"
+	if out.String() != want {
+		t.Errorf("query log = %q, want %q", out.String(), want)
+	}
+}
+
+func TestResolverChain_QueryLogPrivacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := querylog.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolverChain := NewResolverChain([]Resolver{
+		NewClientresolver(&negativeClient{}, "Negative"),
+	})
+	resolverChain.SetQueryLog(l)
+	resolverChain.SetAnonymizer(privacy.NewAnonymizer(privacy.Truncate, "", 0, 0, 2))
+	req := request.Request{Client: net.ParseIP("10.0.0.3")}
+	for _, name := range []string{"nxdomain.lan", "nodata.lan", "nxdomain.lan"} {
+		resolverChain.ResolveRequest(context.Background(), req, dto.Message{
+			ID:            1,
+			Header:        dto.STANDARD_QUERY,
+			QuestionCount: 1,
+			Question:      []dto.Question{{Name: name, Type: dto.A, Class: dto.IN}},
+		})
+	}
+	_ = l.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	out := &bytes.Buffer{}
+	if _, err := querylog.ExportCSV(file, out, querylog.ExportOptions{Fields: []string{"client", "name"}}); err != nil {
+		t.Fatal(err)
+	}
+	// the names are recorded once asked twice
+	want := "client,name\n10.0.0.0,\n10.0.0.0,\n10.0.0.0,nxdomain.lan\n"
+	if out.String() != want {
+		t.Errorf("query log = %q, want %q", out.String(), want)
+	}
+}
+
+func TestResolverChain_BlockingResponse(t *testing.T) {
+	b := blocker.NewBlocker(10)
+	_ = b.Init(context.Background(), "list", func(_ context.Context, add func(string)) error { add("ads.com"); return nil })
+	resolverChain := NewResolverChain([]Resolver{
+		NewClientresolver(b, "Block"),
+		resolverMock{}, // never asked, the blocker stops the chain
+	})
+	tests := []struct {
+		mode       blocker.Mode
+		wantHeader uint16
+		wantCount  uint16
+	}{
+		{mode: blocker.NullMode, wantHeader: dto.STANDARD_RESPONSE, wantCount: 1},
+		{mode: blocker.NXDomainMode, wantHeader: dto.STANDARD_RESPONSE | dto.NAME_ERROR},
+		{mode: blocker.RefusedMode, wantHeader: dto.STANDARD_RESPONSE | dto.REFUSED},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			b.SetResponse(blocker.Response{Mode: tt.mode})
+			got := resolverChain.Resolve(dto.Message{
+				ID:            1,
+				Header:        dto.STANDARD_QUERY,
+				QuestionCount: 1,
+				Question:      []dto.Question{{Name: "ads.com", Type: dto.A, Class: dto.IN}},
+			})
+			if got.Header != tt.wantHeader || got.ResponseCount != tt.wantCount {
+				t.Errorf("ResolverChain.Resolve() = %v, want header %x with %d answers", got, tt.wantHeader, tt.wantCount)
+			}
+		})
+	}
+}
+
+var _ client.SetClient = &aliasClient{}
+
+// aliasClient answers the names through a CNAME to its alias, it counts the questions asked
+type aliasClient struct {
+	alias string
+	asked int
+}
+
+// ResolveV4 implements client.Client
+func (c *aliasClient) ResolveV4(name string) (dto.Record, error) {
+	return client.First(c.ResolveSet(context.Background(), request.Request{}, name, dto.A))
+}
+
+// ResolveV6 implements client.Client
+func (c *aliasClient) ResolveV6(name string) (dto.Record, error) {
+	return client.First(c.ResolveSet(context.Background(), request.Request{}, name, dto.AAAA))
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *aliasClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *aliasClient) ResolveSet(ctx context.Context, _ request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	c.asked++
+	client.ReportAliases(ctx, []string{c.alias + "."})
+	return []dto.Record{{Name: name, Type: t, Class: dto.IN, TTL: 300, Data: net.ParseIP("192.0.2.10").To4()}}, nil
+}
+
+func TestResolverChain_Cloaking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	b := blocker.NewBlocker(10)
+	_ = b.Init(context.Background(), "trackers", func(_ context.Context, add func(string)) error { add("tracker.example.net"); return nil })
+	b.SetResponse(blocker.Response{Mode: blocker.NXDomainMode})
+	paused := net.ParseIP("192.168.1.5")
+	cloaking := blocker.NewCloaking(b)
+	cloaking.SetPaused(func(ip net.IP) bool { return ip.Equal(paused) })
+
+	memCache := memorycache.NewMemoryCache(ctx, wg, 100000, 1, false, time.Minute)
+	upstream := &aliasClient{alias: "Tracker.example.net"}
+	resolverChain := NewResolverChain([]Resolver{
+		NewClientresolver(memCache, "Cache"),
+		NewCacheFeeder(NewClientresolver(upstream, "External"), memCache),
+	})
+	resolverChain.SetCloaker(cloaking)
+	query := dto.Message{ID: 1, Header: dto.STANDARD_QUERY, QuestionCount: 1, Question: []dto.Question{{Name: "metrics.example.com", Type: dto.A, Class: dto.IN}}}
+
+	// the answer to the paused client is not blocked, and cached with its alias
+	got := resolverChain.ResolveRequest(context.Background(), request.Request{Client: paused}, query)
+	if got.Header&dto.RCODE_MASK != 0 || len(got.Response) != 1 {
+		t.Fatalf("expecting the answer of the upstream for the paused client, got %v", got)
+	}
+	// the cached answer is checked again for the other clients
+	got = resolverChain.ResolveRequest(context.Background(), request.Request{Client: net.ParseIP("192.168.1.6")}, query)
+	if got.Header&dto.RCODE_MASK != dto.NAME_ERROR {
+		t.Errorf("expecting the cached answer to be blocked for another client, got %v", got)
+	}
+	got = resolverChain.ResolveRequest(context.Background(), request.Request{Client: paused}, query)
+	if got.Header&dto.RCODE_MASK != 0 || len(got.Response) != 1 || upstream.asked != 1 {
+		t.Errorf("expecting the cached answer for the paused client and a single upstream question, got %v and %d questions", got, upstream.asked)
+	}
+	_, answeredBy, _ := resolverChain.Explain(context.Background(), request.Request{}, query.Question[0])
+	if answeredBy != CloakingName {
+		t.Errorf("expecting the answer to be blocked by %s, got %s", CloakingName, answeredBy)
+	}
+}
+
+// countingPolicy blocks the names starting with ads. and rewrites the ones ending with .lan, it counts its evaluations
+type countingPolicy struct {
+	evaluations int
+}
+
+// Evaluate implements policy.Policy
+func (p *countingPolicy) Evaluate(question dto.Question) (policy.Action, string) {
+	p.evaluations++
+	switch {
+	case strings.HasPrefix(question.Name, "ads."):
+		return policy.Block, ""
+	case strings.HasSuffix(question.Name, ".lan"):
+		return policy.Rewrite, strings.TrimSuffix(question.Name, ".lan")
+	}
+	return policy.Allow, ""
+}
+
+func TestPolicyResolver(t *testing.T) {
+	p := &countingPolicy{}
+	local := &inmemoryclient.InMemoryClient{}
+	_ = local.Add("nas.home", "192.168.1.2")
+	policyResolver := NewPolicyResolver(p, "Policy")
+	policyResolver.SetResponse(blocker.Response{Mode: blocker.NXDomainMode})
+	resolverChain := NewResolverChain([]Resolver{policyResolver, NewClientresolver(local, "Custom")})
+
+	records, answeredBy, err := resolverChain.Explain(context.Background(), request.Request{}, dto.Question{Name: "ads.example.com", Type: dto.A, Class: dto.IN})
+	if err == nil || answeredBy != "Policy" || p.evaluations != 1 {
+		t.Errorf("expecting the question to be blocked after a single evaluation, got %v %s %v after %d", records, answeredBy, err, p.evaluations)
+	}
+	records, answeredBy, err = resolverChain.Explain(context.Background(), request.Request{}, dto.Question{Name: "nas.home.lan", Type: dto.A, Class: dto.IN})
+	if err != nil || answeredBy != "Custom" || len(records) != 1 || p.evaluations != 2 {
+		t.Errorf("expecting the rewritten question to be answered after a single evaluation, got %v %s %v after %d", records, answeredBy, err, p.evaluations)
+	}
+}
+
+func TestCachefeeder_Maintenance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	memCache := memorycache.NewMemoryCache(ctx, wg, 1000, 1, false, time.Minute)
+	memCache.SetServeStale(time.Hour)
+	upstream := &viewClient{}
+	switcher := maintenance.NewSwitch()
+	feeder := NewCacheFeeder(NewClientresolver(upstream, "External"), memCache)
+	feeder.SetMaintenance(switcher)
+
+	cached := dto.Question{Name: "cached.example.com", Type: dto.A, Class: dto.IN}
+	if _, err := feeder.ResolveWithError(cached); err != nil {
+		t.Fatal(err)
+	}
+	switcher.Set(true, "upstream migration", time.Now())
+	if got, err := feeder.ResolveWithError(cached); err != nil || !got.Data.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("expecting the cached answer during the maintenance, got %v, %v", got, err)
+	}
+	if _, err := feeder.ResolveWithError(dto.Question{Name: "new.example.com", Type: dto.A, Class: dto.IN}); !errors.Is(err, maintenance.ErrMaintenance) {
+		t.Errorf("expecting ErrMaintenance for a name out of the cache, got %v", err)
+	}
+	if len(upstream.requests) != 1 {
+		t.Errorf("expecting the upstream not to be asked during the maintenance, got %d requests", len(upstream.requests))
+	}
+}
+
+func TestCachefeeder_Offline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	memCache := memorycache.NewMemoryCache(ctx, wg, 1000, 1, false, time.Minute)
+	memCache.SetServeStale(time.Hour)
+	upstream := &viewClient{}
+	switcher := offline.NewSwitch(false)
+	feeder := NewCacheFeeder(NewClientresolver(upstream, "External"), memCache)
+	feeder.SetOffline(switcher, offline.NXDomain)
+
+	cached := dto.Question{Name: "cached.example.com", Type: dto.A, Class: dto.IN}
+	if _, err := feeder.ResolveWithError(cached); err != nil {
+		t.Fatal(err)
+	}
+	switcher.Set(true)
+	if got, err := feeder.ResolveWithError(cached); err != nil || !got.Data.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("expecting the cached answer while offline, got %v, %v", got, err)
+	}
+	var nameError *client.NameError
+	if _, err := feeder.ResolveWithError(dto.Question{Name: "new.example.com", Type: dto.A, Class: dto.IN}); !errors.As(err, &nameError) {
+		t.Errorf("expecting the offline answer for a name out of the cache, got %v", err)
+	}
+	if len(upstream.requests) != 1 {
+		t.Errorf("expecting the upstream not to be asked while offline, got %d requests", len(upstream.requests))
+	}
+	switcher.Set(false)
+	if _, err := feeder.ResolveWithError(dto.Question{Name: "new.example.com", Type: dto.A, Class: dto.IN}); err != nil {
+		t.Errorf("expecting the upstream to answer once back online, got %v", err)
+	}
+}
+
+var _ client.RequestClient = &validatorClient{}
+
+// validatorClient answers like a validating upstream, with the AD bit
+type validatorClient struct {
+	calls int
+}
+
+// ResolveV4 implements client.Client
+func (c *validatorClient) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *validatorClient) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *validatorClient) ResolveRequest(ctx context.Context, _ request.Request, name string, t dto.Type) (dto.Record, error) {
+	c.calls++
+	dnssec.Report(ctx, true)
+	return dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("10.0.0.1").To4()}, nil
+}
+
+func TestResolverChain_Authenticated(t *testing.T) {
+	query := func(header uint16, dnssecOK bool) dto.Message {
+		message := dto.Message{
+			ID:            1,
+			Header:        header,
+			QuestionCount: 1,
+			Question:      []dto.Question{{Name: "example.com", Type: dto.A, Class: dto.IN}},
+		}
+		if dnssecOK {
+			opt := dto.NewOPT(dto.EDNSPayloadSize, nil)
+			opt.TTL |= dto.DOBit
+			message.Additional, message.AdditionalCount = []dto.Record{opt}, 1
+		}
+		return message
+	}
+	tests := []struct {
+		name    string
+		policy  dnssec.Policy
+		message dto.Message
+		want    bool
+	}{
+		{name: "trusted with AD", policy: dnssec.Trust, message: query(dto.STANDARD_QUERY|dto.AUTHENTIC_DATA, false), want: true},
+		{name: "trusted with DO", policy: dnssec.Trust, message: query(dto.STANDARD_QUERY, true), want: true},
+		{name: "trusted without asking", policy: dnssec.Trust, message: query(dto.STANDARD_QUERY, false), want: false},
+		{name: "validated locally", policy: dnssec.Validate, message: query(dto.STANDARD_QUERY|dto.AUTHENTIC_DATA, false), want: false},
+		{name: "ignored", policy: dnssec.Ignore, message: query(dto.STANDARD_QUERY|dto.AUTHENTIC_DATA, false), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolverChain := NewResolverChain([]Resolver{NewClientresolver(dnssec.Wrap(tt.policy, &validatorClient{}), "External")})
+			response := resolverChain.ResolveRequest(context.Background(), request.Request{Transport: request.UDP}, tt.message)
+			if got := response.Header&dto.AUTHENTIC_DATA != 0; got != tt.want || response.ResponseCount != 1 {
+				t.Errorf("AD bit of the response = %v, want %v, got %v", got, tt.want, response)
+			}
+		})
+	}
+}
+
+func TestResolverChain_AuthenticatedFromCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	memCache := memorycache.NewMemoryCache(ctx, wg, 1000, 1, false, time.Minute)
+	upstream := &validatorClient{}
+	resolverChain := NewResolverChain([]Resolver{
+		NewClientresolver(memCache, "Cache"),
+		NewCacheFeeder(NewClientresolver(dnssec.Wrap(dnssec.Trust, upstream), "External"), memCache),
+	})
+	for i := 0; i < 2; i++ {
+		response := resolverChain.ResolveRequest(context.Background(), request.Request{Transport: request.UDP}, dto.Message{
+			ID:            1,
+			Header:        dto.STANDARD_QUERY | dto.AUTHENTIC_DATA,
+			QuestionCount: 1,
+			Question:      []dto.Question{{Name: "example.com", Type: dto.A, Class: dto.IN}},
+		})
+		if response.Header&dto.AUTHENTIC_DATA == 0 || response.ResponseCount != 1 {
+			t.Errorf("expecting the answer %d to be authenticated, got %v", i, response)
+		}
+	}
+	if upstream.calls != 1 {
+		t.Errorf("expecting the second answer from the cache, the upstream was asked %d times", upstream.calls)
+	}
+}
+
+var _ client.SetClient = &balancedClient{}
+
+// balancedClient answers the addresses of a load balanced service, counting the questions
+type balancedClient struct {
+	questions int
+}
+
+// ResolveV4 implements client.Client
+func (c *balancedClient) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *balancedClient) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *balancedClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *balancedClient) ResolveSet(_ context.Context, _ request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	c.questions++
+	var res []dto.Record
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		res = append(res, dto.Record{Name: name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP(ip).To4()})
+	}
+	return res, nil
+}
+
+func TestResolverChain_Set(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	memCache := memorycache.NewMemoryCache(ctx, wg, 1000, 1, false, time.Minute)
+	upstream := &balancedClient{}
+	resolverChain := NewResolverChain([]Resolver{
+		NewClientresolver(memCache, "Cache"),
+		NewCacheFeeder(NewClientresolver(upstream, "External"), memCache),
+	})
+	query := dto.Message{ID: 1, QuestionCount: 1, Question: []dto.Question{{Name: "service.com", Type: dto.A, Class: dto.IN}}}
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	for _, answeredBy := range []string{"upstream", "cache"} {
+		response := resolverChain.Resolve(query)
+		var got []string
+		for _, record := range response.Response {
+			got = append(got, record.Value())
+		}
+		if !reflect.DeepEqual(got, want) || int(response.ResponseCount) != len(want) {
+			t.Errorf("answer of the %s = %v, want %v", answeredBy, got, want)
+		}
+	}
+	if upstream.questions != 1 {
+		t.Errorf("expecting the second answer from the cache, the upstream got %d questions", upstream.questions)
+	}
+}
@@ -0,0 +1,175 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ Resolver = &RewriteResolver{}
+var _ Rewriter = &RewriteResolver{}
+var _ SetResolver = &RewriteResolver{}
+
+const (
+	// defaultRewriteTTL is the ttl of the answers of the rewrites without ttl
+	defaultRewriteTTL = 200
+	// maxRewrites bounds the aliases followed for a question, to stop on loops
+	maxRewrites = 8
+)
+
+// Rewrite answers fixed addresses or an alias for a name, or for the subdomains of a domain with *.name
+type Rewrite struct {
+	pattern string
+	address net.IP
+	target  string
+	ttl     uint32
+}
+
+// NewRewrite returns the rewrite of the domain, *.name for the subdomains of name, to the answer: an ipv4 or ipv6 address,
+// or the name it is an alias of
+func NewRewrite(domain, answer string, ttl uint32) (Rewrite, error) {
+	pattern := normalize(strings.TrimSpace(domain))
+	if pattern == "" || pattern == "*" || strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+		return Rewrite{}, errors.New("invalid rewritten domain " + domain + ", expecting a name or *.name")
+	}
+	if ttl == 0 {
+		ttl = defaultRewriteTTL
+	}
+	res := Rewrite{pattern: pattern, ttl: ttl}
+	answer = strings.TrimSpace(answer)
+	if res.address = net.ParseIP(answer); res.address != nil {
+		if v4 := res.address.To4(); v4 != nil {
+			res.address = v4
+		}
+		return res, nil
+	}
+	res.target = normalize(answer)
+	if _, err := dto.ParseRData(dto.CNAME, res.target); err != nil || res.target == "" || strings.Contains(res.target, "*") {
+		return Rewrite{}, errors.New("invalid answer " + answer + " of the rewrite of " + domain + ", expecting an address or a name")
+	}
+	return res, nil
+}
+
+// rewriteRule is the answer of a pattern, its addresses or its alias
+type rewriteRule struct {
+	v4, v6 []net.IP
+	target string
+	ttl    uint32
+}
+
+// RewriteResolver answers the local zones of the rewrites: the addresses of a name, or the answers of the following
+// resolvers to its alias under the name asked. The other types of questions about a rewritten name have no record,
+// so the upstream never answers for it
+type RewriteResolver struct {
+	rules map[string]*rewriteRule
+	name  string
+}
+
+// NewRewriteResolver returns the resolver answering the rewrites, a name is either an alias or has addresses
+func NewRewriteResolver(rewrites []Rewrite, name string) (*RewriteResolver, error) {
+	res := &RewriteResolver{rules: make(map[string]*rewriteRule, len(rewrites)), name: name}
+	for _, rewrite := range rewrites {
+		rule, ok := res.rules[rewrite.pattern]
+		if !ok {
+			rule = &rewriteRule{ttl: rewrite.ttl}
+			res.rules[rewrite.pattern] = rule
+		}
+		switch {
+		case rewrite.target != "" && (rule.target != "" || len(rule.v4)+len(rule.v6) > 0):
+			return nil, errors.New("the rewrite of " + rewrite.pattern + " to the alias " + rewrite.target + " cannot have other answers")
+		case rewrite.target != "":
+			rule.target = rewrite.target
+		case rule.target != "":
+			return nil, errors.New("the rewrite of " + rewrite.pattern + " to the alias " + rule.target + " cannot have other answers")
+		case rewrite.address.To4() != nil:
+			rule.v4 = append(rule.v4, rewrite.address)
+		default:
+			rule.v6 = append(rule.v6, rewrite.address)
+		}
+		rule.ttl = min(rule.ttl, rewrite.ttl)
+	}
+	return res, nil
+}
+
+// Name implements Resolver
+func (r *RewriteResolver) Name() string {
+	return r.name
+}
+
+// Rewrite implements Rewriter, the question about an alias is asked about its target, the alias itself is answered
+// to the CNAME questions
+func (r *RewriteResolver) Rewrite(question dto.Question) dto.Question {
+	if question.Type == dto.CNAME {
+		return question
+	}
+	for i := 0; i < maxRewrites; i++ {
+		rule := r.match(question.Name)
+		if rule == nil || rule.target == "" {
+			break
+		}
+		question.Name = rule.target
+	}
+	return question
+}
+
+// Resolve implements Resolver
+func (r *RewriteResolver) Resolve(question dto.Question) (dto.Record, bool) {
+	records, err := r.ResolveSet(context.Background(), request.Request{}, question)
+	if err != nil {
+		return dto.Record{}, false
+	}
+	return records[0], true
+}
+
+// ResolveSet implements SetResolver
+func (r *RewriteResolver) ResolveSet(_ context.Context, _ request.Request, question dto.Question) ([]dto.Record, error) {
+	rule := r.match(question.Name)
+	if rule == nil {
+		return nil, client.NotFound(question.Name + " is not rewritten")
+	}
+	if rule.target != "" {
+		if question.Type != dto.CNAME {
+			// an alias left by Rewrite is a loop
+			return nil, client.NotFound("too many aliases rewriting " + question.Name)
+		}
+		raw, err := dto.ParseRData(dto.CNAME, rule.target)
+		if err != nil {
+			return nil, err
+		}
+		return []dto.Record{{Name: question.Name, Type: dto.CNAME, Class: dto.IN, TTL: rule.ttl, Raw: raw}}, nil
+	}
+	var addresses []net.IP
+	switch question.Type {
+	case dto.A:
+		addresses = rule.v4
+	case dto.AAAA:
+		addresses = rule.v6
+	}
+	if len(addresses) == 0 {
+		return nil, &client.NoDataError{Name: question.Name, Type: question.Type, TTL: rule.ttl}
+	}
+	res := make([]dto.Record, len(addresses))
+	for i, address := range addresses {
+		res[i] = dto.Record{Name: question.Name, Type: question.Type, Class: dto.IN, TTL: rule.ttl, Data: address}
+	}
+	return res, nil
+}
+
+// match returns the rule of the name, the one of the name itself first then the one of its closest parent with a wildcard
+func (r *RewriteResolver) match(name string) *rewriteRule {
+	name = normalize(name)
+	if rule, ok := r.rules[name]; ok {
+		return rule
+	}
+	for _, parent, found := strings.Cut(name, "."); found; _, parent, found = strings.Cut(parent, ".") {
+		if rule, ok := r.rules["*."+parent]; ok {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+func TestRewriteResolver(t *testing.T) {
+	var rewrites []Rewrite
+	for _, r := range [][2]string{
+		{"*.lab.home", "192.168.1.50"},
+		{"*.lab.home", "fd00::50"},
+		{"printer.lab.home", "192.168.1.60"},
+		{"media.home", "jellyfin.lab.home"},
+		{"tv.home", "nas.home"},
+		{"loop.home", "loop.home"},
+	} {
+		rewrite, err := NewRewrite(r[0], r[1], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	rewriter, err := NewRewriteResolver(rewrites, "Rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &inmemoryclient.InMemoryClient{}
+	_ = local.Add("nas.home", "192.168.1.2")
+	chain := NewResolverChain([]Resolver{rewriter, NewClientresolver(local, "Custom")})
+
+	tests := []struct {
+		name       string
+		t          dto.Type
+		wantAnswer string
+		// wantNoData expects a NODATA answer, the question is asked to the next resolvers without answer nor NODATA
+		wantNoData bool
+	}{
+		{name: "gitlab.lab.home", t: dto.A, wantAnswer: "192.168.1.50"},
+		{name: "ci.gitlab.lab.home.", t: dto.AAAA, wantAnswer: "fd00::50"},
+		{name: "printer.lab.home", t: dto.A, wantAnswer: "192.168.1.60"},
+		{name: "printer.lab.home", t: dto.AAAA, wantNoData: true},
+		{name: "lab.home", t: dto.A},
+		{name: "media.home", t: dto.A, wantAnswer: "192.168.1.50"},
+		{name: "TV.home", t: dto.A, wantAnswer: "192.168.1.2"},
+		{name: "gitlab.lab.home", t: dto.MX, wantNoData: true},
+		{name: "loop.home", t: dto.A},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.t.String(), func(t *testing.T) {
+			records, _, err := chain.ask(context.Background(), request.Request{}, dto.Question{Name: tt.name, Type: tt.t, Class: dto.IN})
+			if tt.wantAnswer == "" {
+				var noData *client.NoDataError
+				if errors.As(err, &noData) != tt.wantNoData || (!tt.wantNoData && !errors.Is(err, client.ErrNotFound)) {
+					t.Errorf("ResolverChain.ask() = %v, %v, want NODATA %v", records, err, tt.wantNoData)
+				}
+				return
+			}
+			if err != nil || len(records) != 1 || records[0].Data.String() != tt.wantAnswer {
+				t.Fatalf("ResolverChain.ask() = %v, %v, want %s", records, err, tt.wantAnswer)
+			}
+			if records[0].Name != tt.name {
+				t.Errorf("the answer should keep the name of the question, got %s", records[0].Name)
+			}
+		})
+	}
+
+	records, _, err := chain.ask(context.Background(), request.Request{}, dto.Question{Name: "media.home", Type: dto.CNAME, Class: dto.IN})
+	if err != nil || len(records) != 1 || records[0].Type != dto.CNAME {
+		t.Fatalf("expecting the alias, got %v, %v", records, err)
+	}
+	if target := records[0].Value(); strings.TrimSuffix(target, ".") != "jellyfin.lab.home" {
+		t.Errorf("expecting the alias of jellyfin.lab.home, got %s", target)
+	}
+}
+
+func TestNewRewriteResolver(t *testing.T) {
+	for _, invalid := range [][2]string{{"*", "192.168.1.50"}, {"a.*.home", "192.168.1.50"}, {"nas.home", "*.home"}, {"nas.home", ""}} {
+		if _, err := NewRewrite(invalid[0], invalid[1], 0); err == nil {
+			t.Errorf("expecting an error for the rewrite of %s to %s", invalid[0], invalid[1])
+		}
+	}
+	alias, _ := NewRewrite("nas.home", "storage.home", 0)
+	address, _ := NewRewrite("nas.home", "192.168.1.2", 0)
+	if _, err := NewRewriteResolver([]Rewrite{address, alias}, "Rewrite"); err == nil {
+		t.Errorf("expecting an error for an alias with addresses")
+	}
+}
@@ -0,0 +1,101 @@
+package resolver
+
+import (
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ Resolver = &SafeSearchResolver{}
+var _ RequestRewriter = &SafeSearchResolver{}
+
+// safeSearchNames are the names of the search engines and their SafeSearch or restricted variant
+var safeSearchNames = map[string]string{
+	"bing.com":                 "strict.bing.com",
+	"www.bing.com":             "strict.bing.com",
+	"duckduckgo.com":           "safe.duckduckgo.com",
+	"www.duckduckgo.com":       "safe.duckduckgo.com",
+	"youtube.com":              "restrict.youtube.com",
+	"www.youtube.com":          "restrict.youtube.com",
+	"m.youtube.com":            "restrict.youtube.com",
+	"youtubei.googleapis.com":  "restrict.youtube.com",
+	"youtube.googleapis.com":   "restrict.youtube.com",
+	"www.youtube-nocookie.com": "restrict.youtube.com",
+}
+
+// googleSafeSearch is the SafeSearch variant of all the country domains of google
+const googleSafeSearch = "forcesafesearch.google.com"
+
+// SafeSearchResolver asks the SafeSearch variant of the search engines, like forcesafesearch.google.com for www.google.com,
+// to the following resolvers, for all the clients or for the ones of some client groups. The answer keeps the name of the question.
+// It answers no question itself
+type SafeSearchResolver struct {
+	all    bool
+	groups map[string]bool
+	name   string
+}
+
+// NewSafeSearchResolver returns the resolver enforcing SafeSearch for all the clients when all is set,
+// or for the clients of the groups, the group of a client being the one resolved by the chain
+func NewSafeSearchResolver(all bool, groups []string, name string) *SafeSearchResolver {
+	res := &SafeSearchResolver{all: all, groups: make(map[string]bool, len(groups)), name: name}
+	for _, group := range groups {
+		res.groups[group] = true
+	}
+	return res
+}
+
+// Name implements Resolver
+func (r *SafeSearchResolver) Name() string {
+	return r.name
+}
+
+// RewriteRequest implements RequestRewriter, only the addresses are rewritten
+func (r *SafeSearchResolver) RewriteRequest(req request.Request, question dto.Question) dto.Question {
+	if question.Type != dto.A && question.Type != dto.AAAA {
+		return question
+	}
+	if !r.all && (req.Group == "" || !r.groups[req.Group]) {
+		return question
+	}
+	if safe, ok := SafeSearchName(question.Name); ok {
+		question.Name = safe
+	}
+	return question
+}
+
+// Resolve implements Resolver
+func (r *SafeSearchResolver) Resolve(dto.Question) (dto.Record, bool) {
+	return dto.Record{}, false
+}
+
+// SafeSearchName returns the SafeSearch variant of the name of a search engine
+func SafeSearchName(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if safe, ok := safeSearchNames[name]; ok {
+		return safe, true
+	}
+	if isGoogle(strings.TrimPrefix(name, "www.")) {
+		return googleSafeSearch, true
+	}
+	return "", false
+}
+
+// isGoogle tells if the name is a search domain of google, google.com or a country domain like google.fr or google.co.uk
+func isGoogle(name string) bool {
+	country, ok := strings.CutPrefix(name, "google.")
+	if !ok || country == "" {
+		return false
+	}
+	labels := strings.Split(country, ".")
+	if len(labels) > 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) < 2 || len(label) > 3 {
+			return false
+		}
+	}
+	return true
+}
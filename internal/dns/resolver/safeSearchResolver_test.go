@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/groups"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+func TestSafeSearchResolver(t *testing.T) {
+	local := &inmemoryclient.InMemoryClient{}
+	_ = local.Add("forcesafesearch.google.com", "216.239.38.120")
+	_ = local.Add("restrict.youtube.com", "216.239.38.119")
+	_ = local.Add("www.google.com", "142.250.74.4")
+	_ = local.Add("youtube.com", "142.250.74.14")
+	_, kids, _ := net.ParseCIDR("192.168.1.0/28")
+	_, parents, _ := net.ParseCIDR("192.168.1.8/30")
+	base := blocker.NewBlocker(10)
+	_ = base.Init(context.Background(), "video", func(_ context.Context, add func(string)) error { add("youtube.com"); return nil })
+	g := groups.NewGroups(base, []groups.Group{
+		{Name: "kids", Networks: []groups.Network{{Subnet: kids}}, Blocker: blocker.NewBlocker(10)},
+		{Name: "parents", Networks: []groups.Network{{Subnet: parents}}, Blocker: blocker.NewBlocker(10)},
+	})
+	// the names are blocked as asked, before being rewritten
+	chain := NewResolverChain([]Resolver{NewClientresolver(g, "Block"), NewSafeSearchResolver(false, []string{"kids"}, "SafeSearch"), NewClientresolver(local, "Custom")})
+	chain.SetGroups(g)
+
+	tests := []struct {
+		name       string
+		client     string
+		wantAnswer string
+	}{
+		{name: "www.google.com", client: "192.168.1.2", wantAnswer: "216.239.38.120"},
+		{name: "Google.co.UK.", client: "192.168.1.2", wantAnswer: "216.239.38.120"},
+		{name: "m.youtube.com", client: "192.168.1.2", wantAnswer: "216.239.38.119"},
+		{name: "www.google.com", client: "192.168.1.20", wantAnswer: "142.250.74.4"},
+		{name: "www.google.com", client: "192.168.1.9", wantAnswer: "142.250.74.4"},
+		{name: "youtube.com", client: "192.168.1.2", wantAnswer: "0.0.0.0"},
+		{name: "mail.google.com", client: "192.168.1.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.client, func(t *testing.T) {
+			req := request.Request{Client: net.ParseIP(tt.client)}
+			records, _, err := chain.ask(context.Background(), req, dto.Question{Name: tt.name, Type: dto.A, Class: dto.IN})
+			if tt.wantAnswer == "" {
+				if err == nil {
+					t.Errorf("expecting no answer, got %v", records)
+				}
+				return
+			}
+			if err != nil || len(records) != 1 || records[0].Data.String() != tt.wantAnswer {
+				t.Fatalf("ResolverChain.ask() = %v, %v, want %s", records, err, tt.wantAnswer)
+			}
+			if records[0].Name != tt.name {
+				t.Errorf("the answer should keep the name of the question, got %s", records[0].Name)
+			}
+		})
+	}
+}
+
+func TestSafeSearchName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"google.com", "forcesafesearch.google.com"},
+		{"www.google.fr", "forcesafesearch.google.com"},
+		{"www.google.com.au.", "forcesafesearch.google.com"},
+		{"www.bing.com", "strict.bing.com"},
+		{"duckduckgo.com", "safe.duckduckgo.com"},
+		{"youtubei.googleapis.com", "restrict.youtube.com"},
+		{"google.example.com", ""},
+		{"maps.google.com", ""},
+		{"notgoogle.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := SafeSearchName(tt.name); got != tt.want {
+				t.Errorf("SafeSearchName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ Resolver = &SuffixFallbackResolver{}
+var _ SetResolver = &SuffixFallbackResolver{}
+
+// SuffixFallbackResolver answers the names of the local suffix the local resolvers do not know with their names
+// without the suffix: nas.home.lan is answered with the custom name nas. Placed after the local resolvers, it keeps
+// the names declared without suffix from being asked to the upstream, which would answer NXDOMAIN
+type SuffixFallbackResolver struct {
+	suffix string
+	local  []Resolver
+	name   string
+}
+
+// NewSuffixFallbackResolver returns the resolver asking the names of the suffix to the local resolvers without the suffix
+func NewSuffixFallbackResolver(suffix string, local []Resolver, name string) *SuffixFallbackResolver {
+	return &SuffixFallbackResolver{
+		suffix: "." + strings.ToLower(strings.Trim(suffix, ".")),
+		local:  local,
+		name:   name,
+	}
+}
+
+// Name implements Resolver
+func (r *SuffixFallbackResolver) Name() string {
+	return r.name
+}
+
+// Resolve implements Resolver
+func (r *SuffixFallbackResolver) Resolve(question dto.Question) (dto.Record, bool) {
+	records, err := r.ResolveSet(context.Background(), request.Request{}, question)
+	if err != nil {
+		return dto.Record{}, false
+	}
+	return records[0], true
+}
+
+// ResolveSet implements SetResolver, a negative answer of a local resolver is kept
+func (r *SuffixFallbackResolver) ResolveSet(ctx context.Context, req request.Request, question dto.Question) ([]dto.Record, error) {
+	name := strings.TrimSuffix(question.Name, ".")
+	if len(name) <= len(r.suffix) || !strings.EqualFold(name[len(name)-len(r.suffix):], r.suffix) {
+		return nil, client.NotFound(question.Name + " is not a name of the suffix")
+	}
+	short := question
+	short.Name = strings.ToLower(name[:len(name)-len(r.suffix)])
+	for _, local := range r.local {
+		records, err := resolveSet(ctx, local, req, short)
+		if err == nil || isNegative(err) {
+			return records, err
+		}
+	}
+	return nil, client.NotFound(short.Name + " is not a local name")
+}
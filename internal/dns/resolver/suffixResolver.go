@@ -0,0 +1,74 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ Resolver = &SuffixResolver{}
+var _ SetResolver = &SuffixResolver{}
+
+// SuffixResolver completes the single-label names with a local suffix, like a search domain, for the devices which
+// do not apply the search domain of their DHCP lease: nas is asked as nas.home.lan to the local resolvers.
+// Placed after the local resolvers, it only completes the addresses of the names they do not know themselves,
+// the top level domains and the other types are left to the following resolvers as they are
+type SuffixResolver struct {
+	suffix string
+	local  []Resolver
+	name   string
+}
+
+// NewSuffixResolver returns the resolver asking the single-label names completed with the suffix to the local resolvers
+func NewSuffixResolver(suffix string, local []Resolver, name string) *SuffixResolver {
+	return &SuffixResolver{
+		suffix: strings.ToLower(strings.Trim(suffix, ".")),
+		local:  local,
+		name:   name,
+	}
+}
+
+// Name implements Resolver
+func (r *SuffixResolver) Name() string {
+	return r.name
+}
+
+// Resolve implements Resolver
+func (r *SuffixResolver) Resolve(question dto.Question) (dto.Record, bool) {
+	records, err := r.ResolveSet(context.Background(), request.Request{}, question)
+	if err != nil {
+		return dto.Record{}, false
+	}
+	return records[0], true
+}
+
+// ResolveSet implements SetResolver, localhost is never completed and a negative answer of a local resolver is kept
+func (r *SuffixResolver) ResolveSet(ctx context.Context, req request.Request, question dto.Question) ([]dto.Record, error) {
+	if question.Type != dto.A && question.Type != dto.AAAA {
+		return nil, client.NotFound("only the addresses are completed")
+	}
+	name := strings.ToLower(strings.TrimSuffix(question.Name, "."))
+	if name == "" || strings.Contains(name, ".") || name == "localhost" || isTLD(name) {
+		return nil, client.NotFound(question.Name + " is not a single-label host name")
+	}
+	completed := question
+	completed.Name = name + "." + r.suffix
+	for _, local := range r.local {
+		records, err := resolveSet(ctx, local, req, completed)
+		if err == nil || isNegative(err) {
+			return records, err
+		}
+	}
+	return nil, client.NotFound(completed.Name + " is not a local name")
+}
+
+// isTLD tells if the single-label name is a top level domain of the icann, like com, which is not completed
+func isTLD(name string) bool {
+	_, icann := publicsuffix.PublicSuffix(name)
+	return icann
+}
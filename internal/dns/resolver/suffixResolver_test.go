@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+func TestSuffixResolver(t *testing.T) {
+	local := &inmemoryclient.InMemoryClient{}
+	_ = local.Add("nas.home.lan", "192.168.1.2")
+	_ = local.Add("localhost", "127.0.0.1")
+	_ = local.Add("com.home.lan", "192.168.1.4")
+	txt, _ := dto.ParseRData(dto.TXT, "nas")
+	_ = local.AddRecord(dto.Record{Name: "nas.home.lan", Type: dto.TXT, Raw: txt})
+	custom := NewClientresolver(local, "Custom")
+	chain := NewResolverChain([]Resolver{custom, NewSuffixResolver(".Home.Lan.", []Resolver{custom}, "Suffix")})
+
+	tests := []struct {
+		name       string
+		qtype      dto.Type
+		wantAnswer string
+	}{
+		{name: "nas", wantAnswer: "192.168.1.2"},
+		{name: "nas.", wantAnswer: "192.168.1.2"},
+		{name: "nas.home.lan", wantAnswer: "192.168.1.2"},
+		{name: "localhost", wantAnswer: "127.0.0.1"},
+		{name: "nas", qtype: dto.TXT},
+		{name: "com"},
+		{name: "printer"},
+		{name: "nas.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qtype := tt.qtype
+			if qtype == 0 {
+				qtype = dto.A
+			}
+			records, _, err := chain.ask(context.Background(), request.Request{}, dto.Question{Name: tt.name, Type: qtype, Class: dto.IN})
+			if tt.wantAnswer == "" {
+				if err == nil {
+					t.Errorf("expecting no answer, got %v", records)
+				}
+				return
+			}
+			if err != nil || len(records) != 1 || records[0].Data.String() != tt.wantAnswer {
+				t.Fatalf("ResolverChain.ask() = %v, %v, want %s", records, err, tt.wantAnswer)
+			}
+			if records[0].Name != tt.name {
+				t.Errorf("the answer should keep the name of the question, got %s", records[0].Name)
+			}
+		})
+	}
+}
+
+func TestSuffixFallbackResolver(t *testing.T) {
+	local := &inmemoryclient.InMemoryClient{}
+	_ = local.Add("nas", "192.168.1.2")
+	_ = local.Add("printer.home.lan", "192.168.1.3")
+	custom := NewClientresolver(local, "Custom")
+	chain := NewResolverChain([]Resolver{
+		custom,
+		NewSuffixResolver("home.lan", []Resolver{custom}, "Suffix"),
+		NewSuffixFallbackResolver("home.lan", []Resolver{custom}, "Search"),
+	})
+
+	tests := []struct {
+		name       string
+		wantAnswer string
+		wantBy     string
+	}{
+		{name: "nas", wantAnswer: "192.168.1.2", wantBy: "Custom"},
+		{name: "NAS.Home.Lan.", wantAnswer: "192.168.1.2", wantBy: "Search"},
+		{name: "printer", wantAnswer: "192.168.1.3", wantBy: "Suffix"},
+		{name: "tv.home.lan"},
+		{name: "home.lan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, by, err := chain.ask(context.Background(), request.Request{}, dto.Question{Name: tt.name, Type: dto.A, Class: dto.IN})
+			if tt.wantAnswer == "" {
+				if err == nil {
+					t.Errorf("expecting no answer, got %v", records)
+				}
+				return
+			}
+			if err != nil || len(records) != 1 || records[0].Data.String() != tt.wantAnswer || by != tt.wantBy {
+				t.Fatalf("ResolverChain.ask() = %v, %s, %v, want %s from %s", records, by, err, tt.wantAnswer, tt.wantBy)
+			}
+			if records[0].Name != tt.name {
+				t.Errorf("the answer should keep the name of the question, got %s", records[0].Name)
+			}
+		})
+	}
+}
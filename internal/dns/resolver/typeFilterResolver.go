@@ -0,0 +1,66 @@
+package resolver
+
+import (
+	"errors"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/metrics"
+)
+
+var _ Resolver = &TypeFilterResolver{}
+var _ ErrorResolver = &TypeFilterResolver{}
+
+const (
+	// FilterRefused answers the filtered questions with REFUSED
+	FilterRefused = "refused"
+	// FilterEmpty answers the filtered questions without record, like a name without record of the type
+	FilterEmpty = "empty"
+)
+
+// TypeFilterResolver answers the questions of the filtered types without asking the following resolvers,
+// like ANY abused for amplification, or HTTPS and SVCB whose hints let the clients bypass the filtering of the names
+type TypeFilterResolver struct {
+	// answers are the answers of the filtered types, refused or empty
+	answers map[dto.Type]string
+	metrics *metrics.Metrics
+	name    string
+}
+
+// NewTypeFilterResolver returns the resolver answering the questions of the types with the answer given by type
+func NewTypeFilterResolver(answers map[dto.Type]string, name string) (*TypeFilterResolver, error) {
+	for t, answer := range answers {
+		if answer != FilterRefused && answer != FilterEmpty {
+			return nil, errors.New("unknown answer " + answer + " of the filtered type " + t.String() + ", expecting refused or empty")
+		}
+	}
+	return &TypeFilterResolver{answers: answers, name: name}, nil
+}
+
+// SetMetrics set the metrics counting the filtered questions by type
+func (r *TypeFilterResolver) SetMetrics(m *metrics.Metrics) {
+	r.metrics = m
+}
+
+// Name implements Resolver
+func (r *TypeFilterResolver) Name() string {
+	return r.name
+}
+
+// Resolve implements Resolver, a filtered question has no record
+func (r *TypeFilterResolver) Resolve(dto.Question) (dto.Record, bool) {
+	return dto.Record{}, false
+}
+
+// ResolveWithError implements ErrorResolver
+func (r *TypeFilterResolver) ResolveWithError(question dto.Question) (dto.Record, error) {
+	answer, ok := r.answers[question.Type]
+	if !ok {
+		return dto.Record{}, client.NotFound("the type " + question.Type.String() + " is not filtered")
+	}
+	r.metrics.Filtered(question.Type, answer)
+	if answer == FilterRefused {
+		return dto.Record{}, &client.RefusedError{Name: question.Name}
+	}
+	return dto.Record{}, &client.NoDataError{Name: question.Name, Type: question.Type}
+}
@@ -0,0 +1,50 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+func TestTypeFilterResolver(t *testing.T) {
+	local := &inmemoryclient.InMemoryClient{}
+	_ = local.Add("www.example.com", "93.184.216.34")
+	filter, err := NewTypeFilterResolver(map[dto.Type]string{dto.ANY: FilterRefused, dto.HTTPS: FilterEmpty}, "TypeFilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := NewResolverChain([]Resolver{filter, NewClientresolver(local, "Custom")})
+
+	tests := []struct {
+		name       string
+		qtype      dto.Type
+		wantHeader uint16
+		wantCount  uint16
+	}{
+		{name: "any", qtype: dto.ANY, wantHeader: dto.STANDARD_RESPONSE | dto.REFUSED},
+		{name: "https", qtype: dto.HTTPS, wantHeader: dto.STANDARD_RESPONSE},
+		{name: "a", qtype: dto.A, wantHeader: dto.STANDARD_RESPONSE, wantCount: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			question := dto.Question{Name: "www.example.com", Type: tt.qtype, Class: dto.IN}
+			response := chain.ResolveRequest(context.Background(), request.Request{}, dto.Message{ID: 1, QuestionCount: 1, Question: []dto.Question{question}})
+			if response.Header != tt.wantHeader || response.ResponseCount != tt.wantCount {
+				t.Errorf("ResolveRequest() header = %x with %d records, want %x with %d", response.Header, response.ResponseCount, tt.wantHeader, tt.wantCount)
+			}
+		})
+	}
+
+	if _, err := filter.ResolveWithError(dto.Question{Name: "www.example.com", Type: dto.HTTPS}); err == nil {
+		t.Errorf("expecting no record for a filtered type")
+	} else if _, ok := err.(*client.NoDataError); !ok {
+		t.Errorf("expecting a NoDataError, got %v", err)
+	}
+	if _, err := NewTypeFilterResolver(map[dto.Type]string{dto.ANY: "drop"}, "TypeFilter"); err == nil {
+		t.Errorf("expecting an error for an unknown answer")
+	}
+}
@@ -0,0 +1,136 @@
+// Package selftest measures the defenses of the upstream clients against the spoofed answers:
+// the randomness of the ids, of the source ports and of the case of the names of their queries
+package selftest
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+const (
+	// Zone is the zone of the names asked during the test, reserved for testing (rfc6761 section 6.2)
+	Zone = "selftest.dnshield.test"
+	// points of the score given by the ids, the ports and the case, out of 100
+	idPoints   = 45
+	portPoints = 45
+	casePoints = 10
+)
+
+// Report is the result of a self-test, the randomness is estimated as the entropy in bits
+// of the differences between consecutive queries, at most MaxBits for the number of queries
+type Report struct {
+	Queries  int
+	IDBits   float64
+	PortBits float64
+	MaxBits  float64
+	// CaseRandomized is the number of queries whose name was sent with a case different from the question
+	CaseRandomized int
+	// Score rates the defenses from 0 to 100
+	Score int
+}
+
+// query is a query received by the fake upstream
+type query struct {
+	id   uint16
+	port int
+	name string
+}
+
+// Run asks questions to the client built for the address of a local fake upstream, and measures the randomness of the queries it receives
+func Run(newClient func(address string) client.Client, questions int) (Report, error) {
+	if questions < 3 {
+		return Report{}, errors.New("the self-test needs at least 3 questions")
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return Report{}, err
+	}
+
+	var received []query
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		received = serve(conn)
+	}()
+
+	c := newClient(conn.LocalAddr().String())
+	for i := 0; i < questions; i++ {
+		// the errors do not matter, only the queries received are measured
+		_, _ = c.ResolveV4("host" + strconv.Itoa(i) + "." + Zone)
+	}
+	// every question has been answered, closing the connection stops the fake upstream
+	conn.Close()
+	wg.Wait()
+	return newReport(received), nil
+}
+
+// serve answers the queries with an address until its connection is closed, it returns the received queries
+func serve(conn *net.UDPConn) []query {
+	var res []query
+	buffer := make([]byte, dto.BufferMaxLength)
+	for {
+		n, address, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return res
+		}
+		message, err := dto.ParseMessage(buffer[:n])
+		if err != nil || len(message.Question) == 0 {
+			continue
+		}
+		question := message.Question[0]
+		res = append(res, query{id: message.ID, port: address.Port, name: question.Name})
+		response := dto.Message{ID: message.ID, Header: dto.STANDARD_RESPONSE, QuestionCount: 1, Question: message.Question}
+		if question.Type == dto.A {
+			response.ResponseCount = 1
+			response.Response = []dto.Record{{Name: question.Name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.ParseIP("192.0.2.1").To4()}}
+		}
+		_, _ = conn.WriteToUDP(dto.SerializeMessage(response), address)
+	}
+}
+
+func newReport(queries []query) Report {
+	res := Report{Queries: len(queries)}
+	if len(queries) < 3 {
+		return res
+	}
+	ids := make([]int, 0, len(queries))
+	ports := make([]int, 0, len(queries))
+	for _, q := range queries {
+		ids = append(ids, int(q.id))
+		ports = append(ports, q.port)
+		if q.name != strings.ToLower(q.name) {
+			res.CaseRandomized++
+		}
+	}
+	res.MaxBits = math.Log2(float64(len(queries) - 1))
+	res.IDBits = deltaEntropy(ids)
+	res.PortBits = deltaEntropy(ports)
+	score := idPoints*res.IDBits/res.MaxBits + portPoints*res.PortBits/res.MaxBits +
+		casePoints*float64(res.CaseRandomized)/float64(res.Queries)
+	res.Score = int(math.Round(score))
+	return res
+}
+
+// deltaEntropy returns the shannon entropy in bits of the differences between the consecutive 16 bits values,
+// 0 for values following a sequence whatever its step
+func deltaEntropy(values []int) float64 {
+	counts := make(map[int]int)
+	for i := 1; i < len(values); i++ {
+		counts[(values[i]-values[i-1])&math.MaxUint16]++
+	}
+	total := float64(len(values) - 1)
+	res := 0.0
+	for _, count := range counts {
+		p := float64(count) / total
+		res -= p * math.Log2(p)
+	}
+	return res
+}
@@ -0,0 +1,56 @@
+package selftest
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/udp"
+)
+
+func TestRun(t *testing.T) {
+	report, err := Run(func(address string) client.Client {
+		return udp.NewUDPClient(address)
+	}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Queries != 20 {
+		t.Errorf("Run() received %d queries, want 20", report.Queries)
+	}
+	// the udp client randomizes the ids, the ports and the case of the names
+	if report.IDBits < report.MaxBits-1 {
+		t.Errorf("Run() id randomness = %f, want about %f", report.IDBits, report.MaxBits)
+	}
+	if report.PortBits == 0 || report.CaseRandomized == 0 {
+		t.Errorf("Run() = %+v, expecting random ports and case", report)
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	sequential := make([]query, 0, 100)
+	randomized := make([]query, 0, 100)
+	for i := 0; i < 100; i++ {
+		sequential = append(sequential, query{id: uint16(i), port: 40000, name: "host.selftest.dnshield.test"})
+		randomized = append(randomized, query{id: uint16(random.Intn(1 << 16)), port: 1024 + random.Intn(60000), name: "HoSt.sElftest.dnshield.test"})
+	}
+
+	tests := []struct {
+		name     string
+		queries  []query
+		minScore int
+		maxScore int
+	}{
+		{name: "sequential ids fixed port", queries: sequential, minScore: 0, maxScore: 0},
+		{name: "random ids ports and case", queries: randomized, minScore: 95, maxScore: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := newReport(tt.queries)
+			if report.Score < tt.minScore || report.Score > tt.maxScore {
+				t.Errorf("newReport() score = %d, want between %d and %d (%+v)", report.Score, tt.minScore, tt.maxScore, report)
+			}
+		})
+	}
+}
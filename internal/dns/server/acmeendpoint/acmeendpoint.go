@@ -0,0 +1,74 @@
+package acmeendpoint
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint/certificate"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("acmeendpoint")
+
+const shutdownTimeout = 5 * time.Second
+
+var _ endpoint.Endpoint = &ACMEEndpoint{}
+
+// ACMEEndpoint answers the http-01 challenges of the certificate authority over http, it must be reachable on port 80
+type ACMEEndpoint struct {
+	laddr       string
+	provisioner *certificate.Provisioner
+	started     atomic.Bool
+}
+
+// NewACMEEndpoint create a new endpoint answering the challenges of the provisioner
+func NewACMEEndpoint(address string, p *certificate.Provisioner) *ACMEEndpoint {
+	return &ACMEEndpoint{
+		laddr:       address,
+		provisioner: p,
+	}
+}
+
+// SetChain implements endpoint.Endpoint, the challenges do not depend on the chain
+func (e *ACMEEndpoint) SetChain(*resolver.ResolverChain) {}
+
+// Start implements endpoint.Endpoint
+func (e *ACMEEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if !e.started.CompareAndSwap(false, true) {
+		panic("endpoint is already started")
+	}
+	logger.Info("starting acme endpoint", "address", e.laddr)
+	listener, err := handoff.Listen(ctx, net.ListenConfig{}, "tcp", e.laddr)
+	if err != nil {
+		return errors.New("cannot listen on tcp " + e.laddr + ": " + err.Error())
+	}
+	wg.Add(1)
+	go e.run(ctx, wg, listener)
+	return nil
+}
+
+func (e *ACMEEndpoint) run(ctx context.Context, wg *sync.WaitGroup, listener net.Listener) {
+	defer wg.Done()
+
+	server := &http.Server{Addr: e.laddr, Handler: e.provisioner.ChallengeHandler(), ReadHeaderTimeout: shutdownTimeout}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("acme endpoint failed", "address", e.laddr, "err", err)
+	}
+	logger.Info("acme endpoint stopped", "address", e.laddr)
+}
@@ -0,0 +1,838 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/cache"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/chaos"
+	"github.com/bluguard/dnshield/internal/dns/client/pause"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/fingerprint"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/ratelimit"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+	"github.com/bluguard/dnshield/internal/dns/server/snapshots"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+	"github.com/bluguard/dnshield/internal/dns/tracing"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+	"github.com/bluguard/dnshield/internal/dns/zonefile"
+)
+
+var logger = logging.Component("admin")
+
+const (
+	shutdownTimeout = 5 * time.Second
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	defaultTop      = 10
+	maxTop          = 100
+	// queryTimeout bounds the resolution of the questions asked through the api
+	queryTimeout = 5 * time.Second
+)
+
+var _ endpoint.Endpoint = &AdminEndpoint{}
+
+// API is the set of operations exposed by the admin endpoint
+type API interface {
+	// TestDomain tells whether the name would be blocked, without resolving it
+	TestDomain(name string) Verdict
+	// CacheEntries returns a page of the cache entries matching the pattern and the type, with the total number of matches
+	CacheEntries(pattern string, t dto.Type, offset, limit int) ([]cache.Entry, int)
+	// FlushCache removes all the entries of the cache, it returns the number of removed entries
+	FlushCache() int
+	// Query resolves the question like a query of a client would, the answer is cached
+	Query(ctx context.Context, name string, t dto.Type) Resolution
+	// PurgeClient removes the entries of the client from the query log, it returns the number of removed entries
+	PurgeClient(client string) (int, error)
+	// ExportQueryLog writes the entries of the query log matching the options in their format, it returns the number
+	// of exported entries, querylog.ErrDisabled without query log
+	ExportQueryLog(w io.Writer, opts querylog.ExportOptions) (int, error)
+	// Configuration returns the running configuration, pulled by the standby servers
+	Configuration() configuration.ServerConf
+	// BlockedNames returns the names blocked by the list source of the client group, of every client when group is empty
+	BlockedNames(group, source string) []string
+	// AllowedPatterns returns the patterns of the names never blocked for the client group, for every client when group is empty
+	AllowedPatterns(group string) []string
+	// BlockingLists returns the blocking lists and the rules, with the number of names they block and of questions they blocked
+	BlockingLists() []blocker.List
+	// LocalRecords returns the records of the local names and services
+	LocalRecords() []dto.Record
+	// ClientHints returns the kind of device inferred for the clients, empty when the client hints are disabled
+	ClientHints() []fingerprint.Hint
+	// ChaosRules returns the rules of the failures injected in front of the upstreams, ErrChaosDisabled without chaos mode
+	ChaosRules() ([]chaos.Rule, error)
+	// SetChaosRules replaces the rules of the failures injected until the next reload, ErrChaosDisabled without chaos mode
+	SetChaosRules(rules []chaos.Rule) error
+	// Maintenance returns the state of the maintenance
+	Maintenance() maintenance.Status
+	// SetMaintenance starts or ends the maintenance, during which the answers only come from the cache
+	SetMaintenance(enabled bool, reason string) maintenance.Status
+	// Offline returns whether the external resolution is off
+	Offline() OfflineStatus
+	// SetOffline switches the external resolution off or back on, the upstreams are not asked while it is off
+	SetOffline(enabled bool) OfflineStatus
+	// Pauses returns the pauses of the blocking in progress
+	Pauses() []pause.Pause
+	// PauseBlocking suspends the blocking for the client during d, for every client when client is empty
+	PauseBlocking(client string, d time.Duration) ([]pause.Pause, error)
+	// ResumeBlocking ends the pause of the blocking of the client, of every client when client is empty
+	ResumeBlocking(client string) ([]pause.Pause, error)
+	// Stats returns the counters of the server
+	Stats() stats.Snapshot
+	// Top returns the n most asked names, the n most blocked ones and the n most active clients
+	Top(n int) stats.Top
+	// Window returns the activity of the last hour or day with its n most asked names, the n most blocked ones
+	// and the n most active clients
+	Window(period stats.Period, n int) (stats.Activity, stats.Top)
+	// Rules returns the allowed domains and the block rules of the configuration, with the ones edited through the api
+	Rules() Rules
+	// AddRule allows or blocks the names matching the pattern, the rule is kept across the reloads until the server stops
+	AddRule(edit RuleEdit) (Rules, error)
+	// RemoveRule removes an allowed domain or a block rule, the removal is kept across the reloads until the server stops
+	RemoveRule(edit RuleEdit) (Rules, error)
+	// Snapshots returns the snapshots of the configurations run by the server, oldest first
+	Snapshots() []snapshots.Summary
+	// DiffSnapshots returns the settings changed from a snapshot to another, 0 is the running configuration,
+	// snapshots.ErrNotFound when one of them is not in the history
+	DiffSnapshots(from, to int) ([]snapshots.Change, error)
+	// Rollback runs the configuration of the snapshot again until the next reload, it returns the snapshot
+	Rollback(id int) (snapshots.Summary, error)
+	// Readiness resolves the canary name through the chain and asks the upstreams, it tells whether the server answers
+	Readiness(ctx context.Context) Readiness
+}
+
+// ErrChaosDisabled is returned by the chaos operations when the chaos mode is not enabled in the configuration
+var ErrChaosDisabled = errors.New("the chaos mode is disabled")
+
+// Verdict is the blocking decision for a name
+type Verdict struct {
+	Name    string   `json:"name"`
+	Blocked bool     `json:"blocked"`
+	Rule    string   `json:"rule,omitempty"`
+	Answers []string `json:"answers,omitempty"`
+}
+
+// RuleKind tells whether a rule allows or blocks the names it matches
+type RuleKind string
+
+const (
+	// AllowRule is an allowed domain, *.name allows the subdomains
+	AllowRule RuleKind = "allow"
+	// BlockRule is a block rule, *.name blocks the subdomains and /regexp/ the names it matches
+	BlockRule RuleKind = "block"
+)
+
+// Rules are the allowed domains and the block rules of the running configuration
+type Rules struct {
+	Allow []string `json:"allow"`
+	Block []string `json:"block"`
+}
+
+// RuleEdit is a rule added or removed through the api
+type RuleEdit struct {
+	Kind    RuleKind `json:"kind"`
+	Pattern string   `json:"pattern"`
+}
+
+// Resolution is the answer of the server to a question, with the name of the resolver which answered
+type Resolution struct {
+	Resolver string   `json:"resolver,omitempty"`
+	Answers  []Answer `json:"answers"`
+	Error    string   `json:"error,omitempty"`
+	// Blocked tells the answer is the blocking response, Rule is the list or the rule which blocked the name when known
+	Blocked  bool          `json:"blocked,omitempty"`
+	Rule     string        `json:"rule,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// Trace are the steps of the resolution, the resolvers of the chain asked in turn, the cache lookups and the upstreams
+	Trace []tracing.Step `json:"trace,omitempty"`
+}
+
+// Answer is a record of a Resolution
+type Answer struct {
+	Name string   `json:"name"`
+	Type dto.Type `json:"type"`
+	TTL  uint32   `json:"ttl"`
+	Data string   `json:"data"`
+}
+
+// PauseRequest pauses the blocking of a client for some minutes, every client when Client is empty.
+// Minutes is not used to resume the blocking
+type PauseRequest struct {
+	Client  string `json:"client,omitempty"`
+	Minutes int    `json:"minutes,omitempty"`
+}
+
+// RollbackRequest runs the configuration of the snapshot ID again
+type RollbackRequest struct {
+	ID int `json:"id"`
+}
+
+// OfflineStatus is the state of the external resolution, Answer is the answer to the names neither local nor in the cache
+// while it is off
+type OfflineStatus struct {
+	Enabled bool   `json:"enabled"`
+	Answer  string `json:"answer,omitempty"`
+}
+
+// Probe is the outcome of a question asked to check the readiness of the server
+type Probe struct {
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Readiness tells whether the server answers: the canary name resolved through the chain, and at least one upstream
+// reachable unless the external resolution is off
+type Readiness struct {
+	Ready     bool    `json:"ready"`
+	Chain     Probe   `json:"chain"`
+	Upstreams []Probe `json:"upstreams,omitempty"`
+}
+
+// flushResult is the outcome of a flush of the cache
+type flushResult struct {
+	Flushed int `json:"flushed"`
+}
+
+// purgeResult is the outcome of a purge
+type purgeResult struct {
+	Removed int `json:"removed"`
+}
+
+// cachePage is a page of cache entries
+type cachePage struct {
+	Total   int           `json:"total"`
+	Offset  int           `json:"offset"`
+	Entries []cache.Entry `json:"entries"`
+}
+
+// statsPage is the counters of the server with the most asked names and the most active clients,
+// since the start or during the Window
+type statsPage struct {
+	Counters stats.Snapshot `json:"counters"`
+	Top      stats.Top      `json:"top"`
+	// Window is the activity of the period of the top, nil for the top since the start
+	Window *stats.Activity `json:"window,omitempty"`
+}
+
+// clientsPage is the kind of device of the clients, with the number of clients of every kind
+type clientsPage struct {
+	Categories map[fingerprint.Category]int `json:"categories"`
+	Clients    []fingerprint.Hint           `json:"clients"`
+}
+
+// AdminEndpoint serves the admin api over http
+type AdminEndpoint struct {
+	laddr   string
+	api     API
+	started atomic.Bool
+	// limiter limits the changes of every client, retryAfter is the seconds a limited client waits for a token
+	limiter    *ratelimit.Limiter
+	retryAfter int
+	// audit records the changes, it is closed once the endpoint is stopped
+	audit *AuditLog
+}
+
+// NewAdminEndpoint create a new admin endpoint serving the given api, with the default limit of the changes
+func NewAdminEndpoint(address string, api API) *AdminEndpoint {
+	res := &AdminEndpoint{
+		laddr: address,
+		api:   api,
+	}
+	res.SetMutationLimit(defaultMutationsPerMinute)
+	return res
+}
+
+// SetMutationLimit limits the changes of every client to perMinute, the default when it is not positive
+func (e *AdminEndpoint) SetMutationLimit(perMinute int) {
+	if perMinute <= 0 {
+		perMinute = defaultMutationsPerMinute
+	}
+	e.limiter = ratelimit.NewLimiter(float64(perMinute)/60, max(perMinute/4, 1), 0, clock.Real{})
+	e.retryAfter = max(60/perMinute, 1)
+}
+
+// SetAuditLog sets the log recording the changes, the endpoint closes it once stopped
+func (e *AdminEndpoint) SetAuditLog(audit *AuditLog) {
+	e.audit = audit
+}
+
+// SetChain implements endpoint.Endpoint, the api does not use the chain directly
+func (e *AdminEndpoint) SetChain(*resolver.ResolverChain) {}
+
+// Start implements endpoint.Endpoint
+func (e *AdminEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if !e.started.CompareAndSwap(false, true) {
+		panic("endpoint is already started")
+	}
+	logger.Info("starting admin endpoint", "address", e.laddr)
+	listener, err := handoff.Listen(ctx, net.ListenConfig{}, "tcp", e.laddr)
+	if err != nil {
+		_ = e.audit.Close()
+		return errors.New("cannot listen on tcp " + e.laddr + ": " + err.Error())
+	}
+	wg.Add(1)
+	go e.run(ctx, wg, listener)
+	return nil
+}
+
+func (e *AdminEndpoint) run(ctx context.Context, wg *sync.WaitGroup, listener net.Listener) {
+	defer wg.Done()
+	defer e.audit.Close()
+
+	server := &http.Server{Addr: e.laddr, Handler: e.Handler(), ReadHeaderTimeout: shutdownTimeout}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("admin endpoint failed", "address", e.laddr, "err", err)
+	}
+	logger.Info("admin endpoint stopped", "address", e.laddr)
+}
+
+// Handler returns the http handler of the api
+func (e *AdminEndpoint) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/test-domain", e.testDomain)
+	mux.HandleFunc(cachePath, guarded(e.cacheEntries))
+	mux.HandleFunc(queryPath, e.query)
+	mux.HandleFunc("/api/purge", guarded(e.purge))
+	mux.HandleFunc(exportLogPath, e.exportLog)
+	mux.HandleFunc(configPath, e.configuration)
+	mux.HandleFunc(blocklistPath, e.blocklist)
+	mux.HandleFunc(allowlistPath, e.allowlist)
+	mux.HandleFunc("/api/lists", e.lists)
+	mux.HandleFunc("/api/clients", e.clients)
+	mux.HandleFunc("/api/chaos", guarded(e.chaos))
+	mux.HandleFunc("/api/zone", e.zone)
+	mux.HandleFunc(maintenancePath, guarded(e.maintenance))
+	mux.HandleFunc(offlinePath, guarded(e.offline))
+	mux.HandleFunc(pausePath, guarded(e.pause))
+	mux.HandleFunc("/api/stats", e.stats)
+	mux.HandleFunc(rulesPath, guarded(e.rules))
+	mux.HandleFunc(snapshotsPath, e.snapshots)
+	mux.HandleFunc(diffPath, e.diff)
+	mux.HandleFunc(rollbackPath, guarded(e.rollback))
+	mux.HandleFunc("/healthz", e.healthz)
+	mux.HandleFunc("/readyz", e.readyz)
+	mux.HandleFunc("/", e.dashboard)
+	return e.audited(mux)
+}
+
+func (e *AdminEndpoint) testDomain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	writeJSON(w, http.StatusOK, e.api.TestDomain(name))
+}
+
+// cacheEntries returns a page of the cache entries on GET, and flushes the cache on DELETE
+func (e *AdminEndpoint) cacheEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		writeJSON(w, http.StatusOK, flushResult{Flushed: e.api.FlushCache()})
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	var t dto.Type
+	if query.Get("type") != "" {
+		var err error
+		if t, err = dto.ParseType(query.Get("type")); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	limit, err := intParam(query.Get("limit"), defaultPageSize)
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		writeError(w, http.StatusBadRequest, "invalid limit, maximum is "+strconv.Itoa(maxPageSize))
+		return
+	}
+	entries, total := e.api.CacheEntries(query.Get("pattern"), t, offset, limit)
+	writeJSON(w, http.StatusOK, cachePage{Total: total, Offset: offset, Entries: entries})
+}
+
+// query resolves the name with the type, A when not given
+func (e *AdminEndpoint) query(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	t := dto.A
+	if query.Get("type") != "" {
+		var err error
+		if t, err = dto.ParseType(query.Get("type")); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+	writeJSON(w, http.StatusOK, e.api.Query(ctx, name, t))
+}
+
+// healthz tells the server is alive, it answers as long as the admin endpoint is served
+func (e *AdminEndpoint) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz checks the server answers, with 503 when it does not
+func (e *AdminEndpoint) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+	readiness := e.api.Readiness(ctx)
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness)
+}
+
+func (e *AdminEndpoint) purge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	client := net.ParseIP(r.URL.Query().Get("client"))
+	if client == nil {
+		writeError(w, http.StatusBadRequest, "a client ip is required")
+		return
+	}
+	removed, err := e.api.PurgeClient(client.String())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, purgeResult{Removed: removed})
+}
+
+// exportLog writes the entries of the query log in a time range as csv or parquet, with the columns, the anonymization
+// and the format of the export-log command
+func (e *AdminEndpoint) exportLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	opts := querylog.ExportOptions{Anonymize: query.Get("anonymize") == "true"}
+	var err error
+	if opts.From, err = timeParam(query.Get("from")); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid from: "+err.Error())
+		return
+	}
+	if opts.To, err = timeParam(query.Get("to")); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid to: "+err.Error())
+		return
+	}
+	if opts.Fields, err = querylog.ParseFields(query.Get("fields")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Format, err = querylog.ParseFormat(query.Get("format")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	out := &startedWriter{w: w, contentType: opts.Format.ContentType()}
+	_, err = e.api.ExportQueryLog(out, opts)
+	switch {
+	case err == nil:
+	case errors.Is(err, querylog.ErrDisabled):
+		writeError(w, http.StatusNotFound, err.Error())
+	case !out.started:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		logger.Error("query log export interrupted", "err", err) // the export is truncated, the status is sent
+	}
+}
+
+// startedWriter sends the headers of the export with the first write, an export failing before can still send an error
+type startedWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", s.contentType)
+	}
+	return s.w.Write(p)
+}
+
+func (e *AdminEndpoint) configuration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, e.api.Configuration())
+}
+
+// blocklist writes the names of a list in the hosts format read by the blocking list parser
+func (e *AdminEndpoint) blocklist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		writeError(w, http.StatusBadRequest, "source is required")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	writer := bufio.NewWriter(w)
+	for _, name := range e.api.BlockedNames(r.URL.Query().Get("group"), source) {
+		_, _ = writer.WriteString("0.0.0.0 " + name + "\n")
+	}
+	_ = writer.Flush()
+}
+
+// lists writes the activity of the blocking lists, to find the useless ones and the ones blocking too much
+func (e *AdminEndpoint) lists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, e.api.BlockingLists())
+}
+
+// allowlist writes the allowed patterns, one per line
+func (e *AdminEndpoint) allowlist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	writer := bufio.NewWriter(w)
+	for _, pattern := range e.api.AllowedPatterns(r.URL.Query().Get("group")) {
+		_, _ = writer.WriteString(pattern + "\n")
+	}
+	_ = writer.Flush()
+}
+
+func (e *AdminEndpoint) clients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	res := clientsPage{Categories: make(map[fingerprint.Category]int), Clients: e.api.ClientHints()}
+	for _, hint := range res.Clients {
+		res.Categories[hint.Category]++
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// chaos returns the chaos rules on GET and replaces them with the rules of the body on PUT
+func (e *AdminEndpoint) chaos(w http.ResponseWriter, r *http.Request) {
+	var rules []chaos.Rule
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		rules, err = e.api.ChaosRules()
+	case http.MethodPut:
+		if err = json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid rules: "+err.Error())
+			return
+		}
+		err = e.api.SetChaosRules(rules)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	switch {
+	case errors.Is(err, ErrChaosDisabled):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, rules)
+	}
+}
+
+// zone writes the local records as a zone file, the zone of the origin when given
+func (e *AdminEndpoint) zone(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/dns")
+	// the serial changes every second, so a secondary always takes the latest export
+	_ = zonefile.Write(w, r.URL.Query().Get("origin"), uint32(time.Now().Unix()), e.api.LocalRecords())
+}
+
+// maintenance returns the state of the maintenance, or changes it with the state put
+func (e *AdminEndpoint) maintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, e.api.Maintenance())
+	case http.MethodPut:
+		var status maintenance.Status
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid maintenance: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, e.api.SetMaintenance(status.Enabled, status.Reason))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// offline returns the state of the external resolution, or switches it with the state put
+func (e *AdminEndpoint) offline(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, e.api.Offline())
+	case http.MethodPut:
+		var status OfflineStatus
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid offline state: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, e.api.SetOffline(status.Enabled))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// pause returns the pauses of the blocking on GET, pauses the blocking on POST and resumes it on DELETE
+func (e *AdminEndpoint) pause(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, e.api.Pauses())
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req PauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid pause: "+err.Error())
+		return
+	}
+	var pauses []pause.Pause
+	var err error
+	if r.Method == http.MethodPost {
+		pauses, err = e.api.PauseBlocking(req.Client, time.Duration(req.Minutes)*time.Minute)
+	} else {
+		pauses, err = e.api.ResumeBlocking(req.Client)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, pauses)
+}
+
+func (e *AdminEndpoint) stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	n, err := intParam(r.URL.Query().Get("top"), defaultTop)
+	if err != nil || n < 0 || n > maxTop {
+		writeError(w, http.StatusBadRequest, "invalid top, maximum is "+strconv.Itoa(maxTop))
+		return
+	}
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		writeJSON(w, http.StatusOK, statsPage{Counters: e.api.Stats(), Top: e.api.Top(n)})
+		return
+	}
+	period, err := stats.ParsePeriod(window)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	activity, top := e.api.Window(period, n)
+	writeJSON(w, http.StatusOK, statsPage{Counters: e.api.Stats(), Top: top, Window: &activity})
+}
+
+// rules returns the rules on GET, adds the rule of the body on POST and removes it on DELETE
+func (e *AdminEndpoint) rules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, e.api.Rules())
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var edit RuleEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid rule: "+err.Error())
+		return
+	}
+	var rules Rules
+	var err error
+	if r.Method == http.MethodPost {
+		rules, err = e.api.AddRule(edit)
+	} else {
+		rules, err = e.api.RemoveRule(edit)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (e *AdminEndpoint) snapshots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, e.api.Snapshots())
+}
+
+// diff returns the settings changed from the snapshot from to the snapshot to, the running configuration when not given
+func (e *AdminEndpoint) diff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	from, err := intParam(r.URL.Query().Get("from"), 0)
+	if err != nil || from <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid from, expecting the id of a snapshot")
+		return
+	}
+	to, err := intParam(r.URL.Query().Get("to"), 0)
+	if err != nil || to < 0 {
+		writeError(w, http.StatusBadRequest, "invalid to, expecting the id of a snapshot")
+		return
+	}
+	changes, err := e.api.DiffSnapshots(from, to)
+	writeSnapshotResult(w, changes, err)
+}
+
+// rollback runs the configuration of the snapshot of the body again
+func (e *AdminEndpoint) rollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req RollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid rollback, expecting the id of a snapshot")
+		return
+	}
+	snapshot, err := e.api.Rollback(req.ID)
+	writeSnapshotResult(w, snapshot, err)
+}
+
+// writeSnapshotResult writes the result of an operation on the snapshots, or its error
+func writeSnapshotResult(w http.ResponseWriter, v any, err error) {
+	switch {
+	case errors.Is(err, snapshots.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// guarded refuses the changes of checkChange before they reach the handler, the reads are served as is
+func guarded(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if status, err := checkChange(r); err != nil {
+				writeError(w, status, err.Error())
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// checkChange refuses the changes a page of another site could send through the browser of an administrator:
+// a cross-site form can only post text/plain or form bodies, and a browser tells the origin of its requests
+func checkChange(r *http.Request) (int, error) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return http.StatusUnsupportedMediaType, errors.New("the changes are expected as application/json")
+	}
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return http.StatusForbidden, errors.New("the changes from another site are refused")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			return http.StatusForbidden, errors.New("the changes from the origin " + origin + " are refused")
+		}
+	}
+	return http.StatusOK, nil
+}
+
+func intParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// timeParam parses a RFC 3339 time, the empty string is the zero time
+func timeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
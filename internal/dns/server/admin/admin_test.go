@@ -0,0 +1,885 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/cache"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/chaos"
+	"github.com/bluguard/dnshield/internal/dns/client/pause"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/fingerprint"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/server/snapshots"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+	"github.com/bluguard/dnshield/internal/dns/tracing"
+)
+
+var _ API = mockAPI{}
+
+type mockAPI struct{}
+
+// TestDomain implements API
+func (mockAPI) TestDomain(name string) Verdict {
+	if name == "ads.com" {
+		return Verdict{Name: name, Blocked: true, Rule: "list", Answers: []string{"A 0.0.0.0"}}
+	}
+	return Verdict{Name: name}
+}
+
+// CacheEntries implements API
+func (mockAPI) CacheEntries(pattern string, t dto.Type, offset, limit int) ([]cache.Entry, int) {
+	entries := []cache.Entry{
+		{Name: "a.com", Type: dto.A, TTL: 10, Data: "10.0.0.1"},
+		{Name: "b.com", Type: dto.A, TTL: 10, Data: "10.0.0.2"},
+		{Name: "c.com", Type: dto.A, TTL: 10, Data: "10.0.0.3"},
+	}
+	end := min(offset+limit, len(entries))
+	return entries[min(offset, end):end], len(entries)
+}
+
+// PurgeClient implements API
+func (mockAPI) PurgeClient(client string) (int, error) {
+	if client == "192.168.1.42" {
+		return 3, nil
+	}
+	return 0, nil
+}
+
+// ExportQueryLog implements API
+func (mockAPI) ExportQueryLog(w io.Writer, opts querylog.ExportOptions) (int, error) {
+	log := `{"time":"2024-03-01T10:00:00Z","client":"192.168.1.12","name":"example.com","type":"A","rcode":"NOERROR","duration":0}
+{"time":"2024-03-01T11:00:00Z","client":"192.168.1.13","name":"ads.com","type":"A","rcode":"NXDOMAIN","resolver":"Block","duration":0}
+`
+	return querylog.Export(strings.NewReader(log), w, opts)
+}
+
+// Configuration implements API
+func (mockAPI) Configuration() configuration.ServerConf {
+	conf := configuration.Default()
+	conf.BlockingLists = []string{"https://lists.lan/ads"}
+	return conf
+}
+
+// BlockedNames implements API
+func (mockAPI) BlockedNames(group, source string) []string {
+	switch {
+	case group == "" && source == "https://lists.lan/ads":
+		return []string{"ads.com", "tracker.com"}
+	case group == "kids" && source == "/etc/dnshield/adult.txt":
+		return []string{"adult.com"}
+	}
+	return nil
+}
+
+// AllowedPatterns implements API
+func (mockAPI) AllowedPatterns(group string) []string {
+	if group == "kids" {
+		return []string{"school.com"}
+	}
+	return []string{"*.cdn.com"}
+}
+
+// BlockingLists implements API
+func (mockAPI) BlockingLists() []blocker.List {
+	return []blocker.List{{Source: "https://lists.lan/ads", Names: 2, Hits: 5}, {Source: blocker.RulesList, Hits: 1}}
+}
+
+// LocalRecords implements API
+func (mockAPI) LocalRecords() []dto.Record {
+	return []dto.Record{
+		{Name: "nas.lan", Type: dto.A, Class: dto.IN, TTL: 200, Data: net.ParseIP("192.168.1.10").To4()},
+		{Name: "router.home", Type: dto.A, Class: dto.IN, TTL: 200, Data: net.ParseIP("192.168.1.1").To4()},
+	}
+}
+
+// ClientHints implements API
+func (mockAPI) ClientHints() []fingerprint.Hint {
+	return []fingerprint.Hint{
+		{Client: "192.168.1.10", Category: fingerprint.Apple, Confidence: 1, Matches: map[fingerprint.Category]uint64{fingerprint.Apple: 3}},
+		{Client: "192.168.1.11", Category: fingerprint.Apple, Confidence: 1, Matches: map[fingerprint.Category]uint64{fingerprint.Apple: 1}},
+		{Client: "192.168.1.20", Category: fingerprint.Android, Confidence: 1, Matches: map[fingerprint.Category]uint64{fingerprint.Android: 2}},
+	}
+}
+
+// ChaosRules implements API
+func (mockAPI) ChaosRules() ([]chaos.Rule, error) {
+	return nil, ErrChaosDisabled
+}
+
+// SetChaosRules implements API
+func (mockAPI) SetChaosRules(rules []chaos.Rule) error {
+	return ErrChaosDisabled
+}
+
+// Maintenance implements API
+func (mockAPI) Maintenance() maintenance.Status {
+	return maintenance.Status{}
+}
+
+// SetMaintenance implements API
+func (mockAPI) SetMaintenance(enabled bool, reason string) maintenance.Status {
+	return maintenance.NewSwitch().Set(enabled, reason, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))
+}
+
+// pauseEnd is the end of the pauses of the mock
+var pauseEnd = time.Date(2024, 5, 1, 8, 15, 0, 0, time.UTC)
+
+// Pauses implements API
+func (mockAPI) Pauses() []pause.Pause {
+	return nil
+}
+
+// PauseBlocking implements API
+func (mockAPI) PauseBlocking(client string, d time.Duration) ([]pause.Pause, error) {
+	if d <= 0 {
+		return nil, errors.New("invalid duration")
+	}
+	return []pause.Pause{{Client: client, Until: pauseEnd}}, nil
+}
+
+// ResumeBlocking implements API
+func (mockAPI) ResumeBlocking(client string) ([]pause.Pause, error) {
+	return []pause.Pause{}, nil
+}
+
+// Offline implements API
+func (mockAPI) Offline() OfflineStatus {
+	return OfflineStatus{Answer: "servfail"}
+}
+
+// SetOffline implements API
+func (mockAPI) SetOffline(enabled bool) OfflineStatus {
+	return OfflineStatus{Enabled: enabled, Answer: "servfail"}
+}
+
+// Stats implements API
+func (mockAPI) Stats() stats.Snapshot {
+	return stats.Snapshot{Queries: 42, Resolvers: map[string]uint64{"Block": 5}}
+}
+
+// Top implements API
+func (mockAPI) Top(n int) stats.Top {
+	res := stats.Top{
+		Domains: []stats.Count{{Key: "a.com", Count: 30}, {Key: "ads.com", Count: 5}},
+		Blocked: []stats.Count{{Key: "ads.com", Count: 5}},
+		Clients: []stats.ClientActivity{{Client: "192.168.1.10", Queries: 42, Blocked: 5}},
+	}
+	res.Domains = res.Domains[:min(n, len(res.Domains))]
+	return res
+}
+
+// Window implements API
+func (m mockAPI) Window(period stats.Period, n int) (stats.Activity, stats.Top) {
+	return stats.Activity{Period: period, Queries: 12, Cached: 3, CacheHitRatio: 0.25}, m.Top(n)
+}
+
+// FlushCache implements API
+func (mockAPI) FlushCache() int {
+	return 3
+}
+
+// Query implements API
+func (mockAPI) Query(_ context.Context, name string, t dto.Type) Resolution {
+	if name != "a.com" {
+		return Resolution{Answers: []Answer{}, Error: "no record found for " + name}
+	}
+	return Resolution{Resolver: "Cache", Answers: []Answer{{Name: name, Type: t, TTL: 10, Data: "::1"}}, Trace: []tracing.Step{
+		{Name: "query", Duration: time.Millisecond},
+		{Name: "resolver Cache", Depth: 1, Duration: time.Microsecond, Attributes: map[string]string{"dns.answers": "1"}},
+		{Name: "cache lookup", Depth: 2, Attributes: map[string]string{"dns.cache.hit": "true", "dns.cache.ttl": "10"}},
+	}}
+}
+
+// Rules implements API
+func (mockAPI) Rules() Rules {
+	return Rules{Allow: []string{"*.cdn.com"}, Block: []string{"ads.com"}}
+}
+
+// AddRule implements API
+func (m mockAPI) AddRule(edit RuleEdit) (Rules, error) {
+	rules := m.Rules()
+	switch edit.Kind {
+	case AllowRule:
+		rules.Allow = append(rules.Allow, edit.Pattern)
+	case BlockRule:
+		rules.Block = append(rules.Block, edit.Pattern)
+	default:
+		return Rules{}, errors.New("unknown kind")
+	}
+	return rules, nil
+}
+
+// RemoveRule implements API
+func (m mockAPI) RemoveRule(edit RuleEdit) (Rules, error) {
+	if edit.Kind != BlockRule {
+		return Rules{}, errors.New("unknown kind")
+	}
+	return Rules{Allow: m.Rules().Allow, Block: []string{}}, nil
+}
+
+// Snapshots implements API
+func (mockAPI) Snapshots() []snapshots.Summary {
+	return []snapshots.Summary{{ID: 1, Reason: "start"}, {ID: 2, Reason: "reload"}}
+}
+
+// DiffSnapshots implements API
+func (mockAPI) DiffSnapshots(from, to int) ([]snapshots.Change, error) {
+	if from > 2 || to > 2 {
+		return nil, snapshots.ErrNotFound
+	}
+	return []snapshots.Change{{Path: "block_rules[0]", To: "ads.com"}}, nil
+}
+
+// Rollback implements API
+func (m mockAPI) Rollback(id int) (snapshots.Summary, error) {
+	if id > 2 {
+		return snapshots.Summary{}, snapshots.ErrNotFound
+	}
+	return m.Snapshots()[id-1], nil
+}
+
+// Readiness implements API
+func (mockAPI) Readiness(context.Context) Readiness {
+	return Readiness{Ready: true, Chain: Probe{Name: "example.com", OK: true}, Upstreams: []Probe{{Name: "1.1.1.1:53", OK: true}}}
+}
+
+// unreadyAPI has no upstream answering
+type unreadyAPI struct{ mockAPI }
+
+// Readiness implements API
+func (unreadyAPI) Readiness(context.Context) Readiness {
+	return Readiness{Chain: Probe{Name: "example.com", Error: "timeout"}, Upstreams: []Probe{{Name: "1.1.1.1:53", Error: "timeout"}}}
+}
+
+// newChange returns a request of the admin api with a json body, as sent by the dashboard and the command line
+func newChange(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestAdminEndpoint_testDomain(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	tests := []struct {
+		name        string
+		target      string
+		wantStatus  int
+		wantBlocked bool
+	}{
+		{name: "blocked", target: "/api/test-domain?name=ads.com", wantStatus: http.StatusOK, wantBlocked: true},
+		{name: "not blocked", target: "/api/test-domain?name=google.com", wantStatus: http.StatusOK, wantBlocked: false},
+		{name: "missing name", target: "/api/test-domain", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var verdict Verdict
+			if err := json.NewDecoder(recorder.Body).Decode(&verdict); err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Blocked != tt.wantBlocked {
+				t.Errorf("blocked = %v, want %v", verdict.Blocked, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_cacheEntries(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	tests := []struct {
+		name        string
+		target      string
+		wantStatus  int
+		wantEntries int
+	}{
+		{name: "default page", target: "/api/cache", wantStatus: http.StatusOK, wantEntries: 3},
+		{name: "second page", target: "/api/cache?offset=2&limit=2&type=A", wantStatus: http.StatusOK, wantEntries: 1},
+		{name: "invalid type", target: "/api/cache?type=NOTATYPE", wantStatus: http.StatusBadRequest},
+		{name: "invalid limit", target: "/api/cache?limit=0", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var page cachePage
+			if err := json.NewDecoder(recorder.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != 3 || len(page.Entries) != tt.wantEntries {
+				t.Errorf("got %d entries on %d, want %d on 3", len(page.Entries), page.Total, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_purge(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		wantStatus  int
+		wantRemoved int
+	}{
+		{name: "client", method: http.MethodPost, target: "/api/purge?client=192.168.1.42", wantStatus: http.StatusOK, wantRemoved: 3},
+		{name: "missing client", method: http.MethodPost, target: "/api/purge", wantStatus: http.StatusBadRequest},
+		{name: "invalid client", method: http.MethodPost, target: "/api/purge?client=phone", wantStatus: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, target: "/api/purge?client=192.168.1.42", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, newChange(tt.method, tt.target, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var result purgeResult
+			if err := json.NewDecoder(recorder.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Removed != tt.wantRemoved {
+				t.Errorf("removed = %d, want %d", result.Removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+// noLogAPI runs without query log
+type noLogAPI struct{ mockAPI }
+
+// ExportQueryLog implements API
+func (noLogAPI) ExportQueryLog(io.Writer, querylog.ExportOptions) (int, error) {
+	return 0, querylog.ErrDisabled
+}
+
+func TestAdminEndpoint_exportLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		api        API
+		method     string
+		target     string
+		wantStatus int
+		wantBody   string
+		// wantType is the content type of the export, csv when empty
+		wantType string
+	}{
+		{name: "all", api: mockAPI{}, method: http.MethodGet, target: "/api/querylog/export?fields=name,rcode", wantStatus: http.StatusOK,
+			wantBody: "name,rcode\nexample.com,NOERROR\nads.com,NXDOMAIN\n"},
+		{name: "time range anonymized", api: mockAPI{}, method: http.MethodGet,
+			target: "/api/querylog/export?from=2024-03-01T10:30:00Z&fields=client&anonymize=true", wantStatus: http.StatusOK,
+			wantBody: "client\n192.168.1.0\n"},
+		{name: "invalid from", api: mockAPI{}, method: http.MethodGet, target: "/api/querylog/export?from=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "unknown field", api: mockAPI{}, method: http.MethodGet, target: "/api/querylog/export?fields=password", wantStatus: http.StatusBadRequest},
+		{name: "parquet", api: mockAPI{}, method: http.MethodGet, target: "/api/querylog/export?format=parquet", wantStatus: http.StatusOK,
+			wantType: "application/vnd.apache.parquet"},
+		{name: "unknown format", api: mockAPI{}, method: http.MethodGet, target: "/api/querylog/export?format=xlsx", wantStatus: http.StatusBadRequest},
+		{name: "post", api: mockAPI{}, method: http.MethodPost, target: "/api/querylog/export", wantStatus: http.StatusMethodNotAllowed},
+		{name: "no query log", api: noLogAPI{}, method: http.MethodGet, target: "/api/querylog/export", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			NewAdminEndpoint("127.0.0.1:0", tt.api).Handler().ServeHTTP(recorder, newChange(tt.method, tt.target, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			wantType := tt.wantType
+			if wantType == "" {
+				wantType = "text/csv"
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != wantType {
+				t.Errorf("content type = %q, want %s", contentType, wantType)
+			}
+			if tt.wantType != "" {
+				if !strings.HasPrefix(recorder.Body.String(), "PAR1") {
+					t.Errorf("body = %q, want a parquet file", recorder.Body.String())
+				}
+				return
+			}
+			if recorder.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", recorder.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_clients(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/clients", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	var page clientsPage
+	if err := json.NewDecoder(recorder.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	want := map[fingerprint.Category]int{fingerprint.Apple: 2, fingerprint.Android: 1}
+	if !reflect.DeepEqual(page.Categories, want) || len(page.Clients) != 3 {
+		t.Errorf("unexpected clients %v", page)
+	}
+}
+
+func TestAdminEndpoint_zone(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/zone?origin=lan", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, "\tSOA\t") || !strings.Contains(body, "nas.lan.\t200\tIN\tA\t192.168.1.10\n") {
+		t.Errorf("expecting the zone of lan, got\n%s", body)
+	}
+	if strings.Contains(body, "router.home") {
+		t.Errorf("expecting the names outside of the origin to be skipped, got\n%s", body)
+	}
+}
+
+func TestAdminEndpoint_chaos(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{name: "get disabled", method: http.MethodGet, wantStatus: http.StatusConflict},
+		{name: "put disabled", method: http.MethodPut, body: `[{"pattern":"*","latency":200}]`, wantStatus: http.StatusConflict},
+		{name: "invalid rules", method: http.MethodPut, body: `{"pattern":"*"}`, wantStatus: http.StatusBadRequest},
+		{name: "post", method: http.MethodPost, body: `[]`, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, newChange(tt.method, "/api/chaos", strings.NewReader(tt.body)))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_maintenance(t *testing.T) {
+	server := httptest.NewServer(NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler())
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	status, err := SetMaintenance(address, true, "upstream migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.Reason != "upstream migration" || status.Since.IsZero() {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	resp, err := http.Post(server.URL+"/api/maintenance", "application/json", strings.NewReader(`{"enabled":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdminEndpoint_health(t *testing.T) {
+	tests := []struct {
+		name       string
+		api        API
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{name: "alive", api: mockAPI{}, method: http.MethodGet, target: "/healthz", wantStatus: http.StatusOK},
+		{name: "alive when not ready", api: unreadyAPI{}, method: http.MethodHead, target: "/healthz", wantStatus: http.StatusOK},
+		{name: "ready", api: mockAPI{}, method: http.MethodGet, target: "/readyz", wantStatus: http.StatusOK},
+		{name: "not ready", api: unreadyAPI{}, method: http.MethodGet, target: "/readyz", wantStatus: http.StatusServiceUnavailable},
+		{name: "wrong method", api: mockAPI{}, method: http.MethodPost, target: "/readyz", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			NewAdminEndpoint("127.0.0.1:0", tt.api).Handler().ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.target, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.target != "/readyz" || tt.method != http.MethodGet {
+				return
+			}
+			var readiness Readiness
+			if err := json.NewDecoder(recorder.Body).Decode(&readiness); err != nil {
+				t.Fatal(err)
+			}
+			if readiness.Ready != (tt.wantStatus == http.StatusOK) || len(readiness.Upstreams) != 1 {
+				t.Errorf("unexpected readiness %+v", readiness)
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_offline(t *testing.T) {
+	server := httptest.NewServer(NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler())
+	defer server.Close()
+
+	status, err := SetOffline(strings.TrimPrefix(server.URL, "http://"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != (OfflineStatus{Enabled: true, Answer: "servfail"}) {
+		t.Errorf("unexpected status %+v", status)
+	}
+	resp, err := http.Get(server.URL + "/api/offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || status.Enabled {
+		t.Errorf("unexpected status %+v, %v", status, err)
+	}
+}
+
+func TestAdminEndpoint_pause(t *testing.T) {
+	server := httptest.NewServer(NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler())
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	pauses, err := PauseBlocking(address, "192.168.1.20", 15)
+	if err != nil || len(pauses) != 1 || pauses[0].Client != "192.168.1.20" || !pauses[0].Until.Equal(pauseEnd) {
+		t.Errorf("PauseBlocking() = %+v, %v", pauses, err)
+	}
+	if _, err := PauseBlocking(address, "", 0); err == nil {
+		t.Errorf("expecting the error of the api")
+	}
+	if pauses, err := ResumeBlocking(address, "192.168.1.20"); err != nil || len(pauses) != 0 {
+		t.Errorf("ResumeBlocking() = %+v, %v", pauses, err)
+	}
+
+	// a page of another site can post a text body, or a json body from its own origin
+	resp, err := http.Post(server.URL+"/api/pause", "text/plain", strings.NewReader(`{"minutes":60}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+	}
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/pause", strings.NewReader(`{"minutes":60}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://attacker.example")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestAdminEndpoint_operations(t *testing.T) {
+	server := httptest.NewServer(NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler())
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	if flushed, err := FlushCache(address); err != nil || flushed != 3 {
+		t.Errorf("FlushCache() = %d, %v, want 3", flushed, err)
+	}
+	resolution, err := Query(address, "a.com", dto.AAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution.Resolver != "Cache" || len(resolution.Answers) != 1 || resolution.Answers[0].Type != dto.AAAA {
+		t.Errorf("unexpected resolution %+v", resolution)
+	}
+	if len(resolution.Trace) != 3 || resolution.Trace[2].Attributes["dns.cache.ttl"] != "10" {
+		t.Errorf("expecting the steps of the resolution, got %+v", resolution.Trace)
+	}
+	if resolution, _ := Query(address, "unknown.com", dto.A); resolution.Error == "" {
+		t.Errorf("expecting the error of the resolution, got %+v", resolution)
+	}
+	rules, err := EditRule(address, RuleEdit{Kind: BlockRule, Pattern: "ads.net"}, true)
+	if err != nil || !reflect.DeepEqual(rules.Block, []string{"ads.com", "ads.net"}) {
+		t.Errorf("EditRule() = %v, %v", rules, err)
+	}
+	if _, err := EditRule(address, RuleEdit{Kind: AllowRule, Pattern: "*.cdn.com"}, false); err == nil {
+		t.Errorf("expecting the error of the api")
+	}
+	if summary, err := Rollback(address, 1); err != nil || summary.Reason != "start" {
+		t.Errorf("Rollback() = %+v, %v", summary, err)
+	}
+}
+
+func TestAdminEndpoint_follow(t *testing.T) {
+	server := httptest.NewServer(NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler())
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	conf, err := FetchConfiguration(address)
+	if err != nil {
+		t.Fatalf("FetchConfiguration() error = %v", err)
+	}
+	if !reflect.DeepEqual(conf, mockAPI{}.Configuration()) {
+		t.Errorf("FetchConfiguration() = %v, want %v", conf, mockAPI{}.Configuration())
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{name: "blocklist", url: BlocklistURL(address, "", "https://lists.lan/ads"), want: "0.0.0.0 ads.com\n0.0.0.0 tracker.com\n"},
+		{name: "unknown blocklist", url: BlocklistURL(address, "", "https://lists.lan/other"), want: ""},
+		{name: "allowlist", url: AllowlistURL(address, ""), want: "*.cdn.com\n"},
+		{name: "local blocklist of a group", url: BlocklistURL(address, "kids", "/etc/dnshield/adult.txt"), want: "0.0.0.0 adult.com\n"},
+		{name: "allowlist of a group", url: AllowlistURL(address, "kids"), want: "school.com\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(body) != tt.want {
+				t.Errorf("GET %s = %d %q, want %q", tt.url, resp.StatusCode, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_lists(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/lists", nil))
+	var lists []blocker.List
+	if err := json.NewDecoder(recorder.Body).Decode(&lists); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("unexpected answer %d: %v", recorder.Code, err)
+	}
+	if !reflect.DeepEqual(lists, mockAPI{}.BlockingLists()) {
+		t.Errorf("GET /api/lists = %v, want %v", lists, mockAPI{}.BlockingLists())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/lists", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /api/lists status = %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdminEndpoint_stats(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	tests := []struct {
+		name        string
+		target      string
+		wantStatus  int
+		wantDomains int
+		wantWindow  stats.Period
+	}{
+		{name: "default top", target: "/api/stats", wantStatus: http.StatusOK, wantDomains: 2},
+		{name: "top 1", target: "/api/stats?top=1", wantStatus: http.StatusOK, wantDomains: 1},
+		{name: "invalid top", target: "/api/stats?top=1000", wantStatus: http.StatusBadRequest},
+		{name: "last hour", target: "/api/stats?window=hour&top=1", wantStatus: http.StatusOK, wantDomains: 1, wantWindow: stats.Hour},
+		{name: "invalid window", target: "/api/stats?window=week", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var page statsPage
+			if err := json.NewDecoder(recorder.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+			if page.Counters.Queries != 42 || len(page.Top.Domains) != tt.wantDomains || len(page.Top.Clients) != 1 {
+				t.Errorf("unexpected stats %+v", page)
+			}
+			if (page.Window == nil) != (tt.wantWindow == "") || (page.Window != nil && page.Window.Period != tt.wantWindow) {
+				t.Errorf("unexpected window %+v, want %q", page.Window, tt.wantWindow)
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_rules(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		want       Rules
+	}{
+		{name: "get", method: http.MethodGet, wantStatus: http.StatusOK, want: Rules{Allow: []string{"*.cdn.com"}, Block: []string{"ads.com"}}},
+		{name: "add", method: http.MethodPost, body: `{"kind":"allow","pattern":"ads.com"}`, wantStatus: http.StatusOK,
+			want: Rules{Allow: []string{"*.cdn.com", "ads.com"}, Block: []string{"ads.com"}}},
+		{name: "remove", method: http.MethodDelete, body: `{"kind":"block","pattern":"ads.com"}`, wantStatus: http.StatusOK,
+			want: Rules{Allow: []string{"*.cdn.com"}, Block: []string{}}},
+		{name: "unknown kind", method: http.MethodPost, body: `{"kind":"deny","pattern":"ads.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid body", method: http.MethodPost, body: `ads.com`, wantStatus: http.StatusBadRequest},
+		{name: "put", method: http.MethodPut, body: `{}`, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, newChange(tt.method, "/api/rules", strings.NewReader(tt.body)))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var rules Rules
+			if err := json.NewDecoder(recorder.Body).Decode(&rules); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Errorf("rules = %v, want %v", rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_snapshots(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{name: "list", method: http.MethodGet, target: "/api/snapshots", wantStatus: http.StatusOK, want: `"reason":"reload"`},
+		{name: "diff", method: http.MethodGet, target: "/api/snapshots/diff?from=1&to=2", wantStatus: http.StatusOK, want: `"path":"block_rules[0]"`},
+		{name: "diff to the running configuration", method: http.MethodGet, target: "/api/snapshots/diff?from=1", wantStatus: http.StatusOK},
+		{name: "diff without from", method: http.MethodGet, target: "/api/snapshots/diff", wantStatus: http.StatusBadRequest},
+		{name: "diff of a pruned snapshot", method: http.MethodGet, target: "/api/snapshots/diff?from=7", wantStatus: http.StatusNotFound},
+		{name: "rollback", method: http.MethodPost, target: "/api/snapshots/rollback", body: `{"id":1}`, wantStatus: http.StatusOK, want: `"reason":"start"`},
+		{name: "rollback to an unknown snapshot", method: http.MethodPost, target: "/api/snapshots/rollback", body: `{"id":7}`, wantStatus: http.StatusNotFound},
+		{name: "rollback without body", method: http.MethodPost, target: "/api/snapshots/rollback?id=1", wantStatus: http.StatusBadRequest},
+		{name: "rollback with get", method: http.MethodGet, target: "/api/snapshots/rollback?id=1", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, newChange(tt.method, tt.target, strings.NewReader(tt.body)))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if !strings.Contains(recorder.Body.String(), tt.want) {
+				t.Errorf("expecting %s in %s", tt.want, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminEndpoint_crossSite(t *testing.T) {
+	endpoint := NewAdminEndpoint("127.0.0.1:0", mockAPI{})
+	endpoint.SetMutationLimit(6000)
+	handler := endpoint.Handler()
+
+	changes := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: http.MethodPost, target: "/api/rules", body: `{"kind":"block","pattern":"ads.net"}`},
+		{method: http.MethodPut, target: "/api/chaos", body: `[]`},
+		{method: http.MethodPut, target: "/api/maintenance", body: `{"enabled":true}`},
+		{method: http.MethodPut, target: "/api/offline", body: `{"enabled":true}`},
+		{method: http.MethodDelete, target: "/api/cache"},
+		{method: http.MethodPost, target: "/api/purge?client=192.168.1.42"},
+		{method: http.MethodPost, target: "/api/snapshots/rollback", body: `{"id":1}`},
+	}
+	for _, tt := range changes {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if recorder.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("status without json = %d, want %d", recorder.Code, http.StatusUnsupportedMediaType)
+			}
+			for header, value := range map[string]string{"Origin": "https://attacker.example", "Sec-Fetch-Site": "cross-site"} {
+				req := newChange(tt.method, tt.target, strings.NewReader(tt.body))
+				req.Header.Set(header, value)
+				recorder = httptest.NewRecorder()
+				handler.ServeHTTP(recorder, req)
+				if recorder.Code != http.StatusForbidden {
+					t.Errorf("status with %s %s = %d, want %d", header, value, recorder.Code, http.StatusForbidden)
+				}
+			}
+		})
+	}
+	req := newChange(http.MethodPost, "/api/rules", strings.NewReader(`{"kind":"block","pattern":"ads.net"}`))
+	req.Header.Set("Origin", "http://"+req.Host)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status from the dashboard = %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestAdminEndpoint_dashboard(t *testing.T) {
+	handler := NewAdminEndpoint("127.0.0.1:0", mockAPI{}).Handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status = %d %s, want the page", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), "/api/stats") {
+		t.Errorf("expecting the page to poll the stats")
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/ratelimit"
+)
+
+const (
+	// defaultMutationsPerMinute is the number of changes a client can make per minute when the configuration does not set it
+	defaultMutationsPerMinute = 60
+	// maxMutationBody is the size of the largest body of a change, the bodies are recorded in the audit log
+	maxMutationBody = 64 << 10
+)
+
+// AuditEntry is a change requested to the admin api
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Client is the address of the requester. ClaimedUser is the user of its basic authentication when given, it is
+	// not verified: the admin api authenticates nobody, anyone reaching it can claim any user
+	Client      string `json:"client"`
+	ClaimedUser string `json:"claimed_user,omitempty"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	// Change is the body of the request, Status the status answered, 429 for the changes over the limit
+	Change string `json:"change,omitempty"`
+	Status int    `json:"status"`
+}
+
+// AuditLog records the changes made through the admin api in an append-only file of json lines, it is safe for concurrent use.
+// The entries of a nil AuditLog are only written to the log of the server
+type AuditLog struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog opens the audit log at path, the file is created when it does not exist and is only appended to
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: file}, nil
+}
+
+// Record appends the entry to the log
+func (a *AuditLog) Record(entry AuditEntry) {
+	logger.Info("admin change", "client", entry.Client, "claimed_user", entry.ClaimedUser, "method", entry.Method, "path", entry.Path, "status", entry.Status)
+	if a == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error("error encoding the audit entry", "err", err)
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		logger.Error("error writing the audit log", "path", a.file.Name(), "err", err)
+	}
+}
+
+// Close closes the file of the log
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.file.Close()
+}
+
+// statusRecorder keeps the status written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// audited limits and records the requests changing the state of the server, the reads are served as is
+func (e *AdminEndpoint) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		entry := AuditEntry{Time: time.Now(), Client: r.RemoteAddr, Method: r.Method, Path: r.URL.RequestURI()}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			entry.Client = host
+		}
+		entry.ClaimedUser, _, _ = r.BasicAuth()
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxMutationBody+1))
+		switch {
+		case err != nil:
+			entry.Status = http.StatusBadRequest
+			writeError(w, entry.Status, "cannot read the body: "+err.Error())
+		case len(body) > maxMutationBody:
+			entry.Status = http.StatusRequestEntityTooLarge
+			writeError(w, entry.Status, "the body is larger than "+strconv.Itoa(maxMutationBody)+" bytes")
+		case e.limiter.Allow(net.ParseIP(entry.Client)) != ratelimit.Allow:
+			entry.Status = http.StatusTooManyRequests
+			w.Header().Set("Retry-After", strconv.Itoa(e.retryAfter))
+			writeError(w, entry.Status, "too many changes, retry later")
+		default:
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+			entry.Status = recorder.status
+		}
+		if len(body) <= maxMutationBody {
+			entry.Change = string(body)
+		}
+		e.audit.Record(entry)
+	})
+}
@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminEndpoint_audited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := NewAdminEndpoint("127.0.0.1:0", mockAPI{})
+	endpoint.SetMutationLimit(4) // a burst of one change
+	endpoint.SetAuditLog(audit)
+	handler := endpoint.Handler()
+
+	requests := []struct {
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusOK},
+		{method: http.MethodPost, body: `{"kind":"block","pattern":"ads.lan"}`, wantStatus: http.StatusOK},
+		{method: http.MethodPost, body: `{"kind":"block","pattern":"tracker.lan"}`, wantStatus: http.StatusTooManyRequests},
+		{method: http.MethodGet, wantStatus: http.StatusOK},
+	}
+	for _, tt := range requests {
+		request := newChange(tt.method, "/api/rules", strings.NewReader(tt.body))
+		request.SetBasicAuth("alice", "secret")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.body, recorder.Code, tt.wantStatus)
+		}
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []AuditEntry
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expecting the 2 changes to be recorded, got %v", entries)
+	}
+	first, limited := entries[0], entries[1]
+	if first.Client != "192.0.2.1" || first.ClaimedUser != "alice" || first.Method != http.MethodPost || first.Path != "/api/rules" ||
+		first.Change != `{"kind":"block","pattern":"ads.lan"}` || first.Status != http.StatusOK || first.Time.IsZero() {
+		t.Errorf("unexpected entry %+v", first)
+	}
+	if limited.Status != http.StatusTooManyRequests || !strings.Contains(limited.Change, "tracker.lan") {
+		t.Errorf("expecting the limited change to be recorded, got %+v", limited)
+	}
+}
@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client/pause"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/server/snapshots"
+)
+
+const (
+	configPath      = "/api/config"
+	cachePath       = "/api/cache"
+	queryPath       = "/api/query"
+	exportLogPath   = "/api/querylog/export"
+	rulesPath       = "/api/rules"
+	blocklistPath   = "/api/blocklist"
+	allowlistPath   = "/api/allowlist"
+	maintenancePath = "/api/maintenance"
+	offlinePath     = "/api/offline"
+	pausePath       = "/api/pause"
+	snapshotsPath   = "/api/snapshots"
+	diffPath        = "/api/snapshots/diff"
+	rollbackPath    = "/api/snapshots/rollback"
+	clientTimeout   = 30 * time.Second
+)
+
+var httpClient = &http.Client{Timeout: clientTimeout}
+
+// FetchConfiguration returns the running configuration of the server whose admin endpoint listens on address
+func FetchConfiguration(address string) (configuration.ServerConf, error) {
+	var conf configuration.ServerConf
+	resp, err := httpClient.Get("http://" + address + configPath)
+	if err != nil {
+		return conf, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conf, errors.New("cannot fetch the configuration of " + address + ": " + resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&conf); err != nil {
+		return conf, errors.New("cannot decode the configuration of " + address + ": " + err.Error())
+	}
+	return conf, nil
+}
+
+// SetMaintenance starts or ends the maintenance of the server whose admin endpoint listens on address, it returns its new state
+func SetMaintenance(address string, enabled bool, reason string) (maintenance.Status, error) {
+	var status maintenance.Status
+	body, err := json.Marshal(maintenance.Status{Enabled: enabled, Reason: reason})
+	if err != nil {
+		return status, err
+	}
+	req, err := http.NewRequest(http.MethodPut, "http://"+address+maintenancePath, bytes.NewReader(body))
+	if err != nil {
+		return status, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, errors.New("cannot set the maintenance of " + address + ": " + resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, errors.New("cannot decode the maintenance of " + address + ": " + err.Error())
+	}
+	return status, nil
+}
+
+// ListSnapshots returns the snapshots of the configurations of the server whose admin endpoint listens on address
+func ListSnapshots(address string) ([]snapshots.Summary, error) {
+	var res []snapshots.Summary
+	err := callSnapshots(http.MethodGet, "http://"+address+snapshotsPath, &res)
+	return res, err
+}
+
+// DiffSnapshots returns the settings changed from a snapshot to another on the server whose admin endpoint listens on address,
+// to the running configuration when to is 0
+func DiffSnapshots(address string, from, to int) ([]snapshots.Change, error) {
+	var res []snapshots.Change
+	err := callSnapshots(http.MethodGet, "http://"+address+diffPath+"?from="+strconv.Itoa(from)+"&to="+strconv.Itoa(to), &res)
+	return res, err
+}
+
+// Rollback runs the configuration of the snapshot again on the server whose admin endpoint listens on address
+func Rollback(address string, id int) (snapshots.Summary, error) {
+	var res snapshots.Summary
+	err := call(http.MethodPost, "http://"+address+rollbackPath, RollbackRequest{ID: id}, &res)
+	return res, err
+}
+
+// callSnapshots sends the request to the snapshots api and decodes its result
+func callSnapshots(method, target string, result any) error {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return err
+	}
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		return errors.New("snapshots request failed: " + resp.Status + " " + failure.Error)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.New("cannot decode the snapshots answer: " + err.Error())
+	}
+	return nil
+}
+
+// BlocklistURL returns the url of the names blocked by the list source of the client group, of every client when group
+// is empty, on the server whose admin endpoint listens on address
+func BlocklistURL(address, group, source string) string {
+	query := url.Values{"source": {source}}
+	if group != "" {
+		query.Set("group", group)
+	}
+	return "http://" + address + blocklistPath + "?" + query.Encode()
+}
+
+// AllowlistURL returns the url of the allowed patterns of the client group, of every client when group is empty,
+// on the server whose admin endpoint listens on address
+func AllowlistURL(address, group string) string {
+	if group == "" {
+		return "http://" + address + allowlistPath
+	}
+	return "http://" + address + allowlistPath + "?" + url.Values{"group": {group}}.Encode()
+}
+
+// FlushCache removes all the entries of the cache of the server whose admin endpoint listens on address,
+// it returns the number of removed entries
+func FlushCache(address string) (int, error) {
+	var res flushResult
+	err := call(http.MethodDelete, "http://"+address+cachePath, nil, &res)
+	return res.Flushed, err
+}
+
+// EditRule adds the rule to the server whose admin endpoint listens on address, or removes it when add is false,
+// it returns the rules edited
+func EditRule(address string, edit RuleEdit, add bool) (Rules, error) {
+	method := http.MethodDelete
+	if add {
+		method = http.MethodPost
+	}
+	var res Rules
+	err := call(method, "http://"+address+rulesPath, edit, &res)
+	return res, err
+}
+
+// Query resolves the name with the type on the server whose admin endpoint listens on address
+func Query(address, name string, t dto.Type) (Resolution, error) {
+	var res Resolution
+	err := call(http.MethodGet, "http://"+address+queryPath+"?name="+url.QueryEscape(name)+"&type="+url.QueryEscape(t.String()), nil, &res)
+	return res, err
+}
+
+// SetOffline switches the external resolution of the server whose admin endpoint listens on address off, or back on
+// when enabled is false, it returns its new state
+func SetOffline(address string, enabled bool) (OfflineStatus, error) {
+	var res OfflineStatus
+	err := call(http.MethodPut, "http://"+address+offlinePath, OfflineStatus{Enabled: enabled}, &res)
+	return res, err
+}
+
+// PauseBlocking suspends the blocking of the client for some minutes, of every client when client is empty, on the server
+// whose admin endpoint listens on address. It returns the pauses in progress
+func PauseBlocking(address, client string, minutes int) ([]pause.Pause, error) {
+	var res []pause.Pause
+	err := call(http.MethodPost, "http://"+address+pausePath, PauseRequest{Client: client, Minutes: minutes}, &res)
+	return res, err
+}
+
+// ResumeBlocking ends the pause of the blocking of the client, of every client when client is empty, on the server
+// whose admin endpoint listens on address. It returns the pauses still in progress
+func ResumeBlocking(address, client string) ([]pause.Pause, error) {
+	var res []pause.Pause
+	err := call(http.MethodDelete, "http://"+address+pausePath, PauseRequest{Client: client}, &res)
+	return res, err
+}
+
+// call sends the request with the body encoded in json when not nil, then decodes the result
+func call(method, target string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		return errors.New("request failed: " + resp.Status + " " + failure.Error)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.New("cannot decode the answer: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package admin
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboardPage shows the activity of the server and edits its rules with the api, it has no dependency
+// so it is served as is, even on a network without internet access
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// dashboard serves the page of the dashboard on the root of the endpoint
+func (e *AdminEndpoint) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(dashboardPage)
+}
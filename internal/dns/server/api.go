@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/cache"
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/chaos"
+	"github.com/bluguard/dnshield/internal/dns/client/hosts"
+	"github.com/bluguard/dnshield/internal/dns/client/offline"
+	"github.com/bluguard/dnshield/internal/dns/client/pause"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/fingerprint"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/tracing"
+)
+
+var _ admin.API = &Server{}
+
+// CacheEntries implements admin.API
+func (s *Server) CacheEntries(pattern string, t dto.Type, offset, limit int) ([]cache.Entry, int) {
+	s.lock.RLock()
+	c := s.cache
+	s.lock.RUnlock()
+	return c.Entries(pattern, t, offset, limit)
+}
+
+// FlushCache implements admin.API
+func (s *Server) FlushCache() int {
+	s.lock.RLock()
+	c := s.cache
+	s.lock.RUnlock()
+	flushed := c.Len()
+	c.Clear()
+	logger.Info("cache flushed", "entries", flushed)
+	return flushed
+}
+
+// Query implements admin.API
+func (s *Server) Query(ctx context.Context, name string, t dto.Type) admin.Resolution {
+	s.lock.RLock()
+	chain, b, p := s.chain, s.blocker, s.policy
+	s.lock.RUnlock()
+	question := dto.Question{Name: strings.TrimSuffix(name, "."), Type: t, Class: dto.IN}
+	ctx, span, recorder := tracing.Record(ctx, "query")
+	span.SetQuestionName(question.Name)
+	span.SetString("dns.question.type", t.String())
+	records, answeredBy, err := chain.Explain(ctx, request.Request{}, question)
+	span.End(err)
+	res := admin.Resolution{Resolver: answeredBy, Answers: make([]admin.Answer, 0, len(records))}
+	for _, record := range records {
+		res.Answers = append(res.Answers, admin.Answer{Name: record.Name, Type: record.Type, TTL: record.TTL, Data: record.Value()})
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if answeredBy == "Block" || answeredBy == "Policy" || answeredBy == resolver.CloakingName || errors.Is(err, client.ErrBlocked) {
+		res.Blocked = true
+		res.Rule, _ = match(question.Name, t, b, p)
+	}
+	res.Trace = recorder.Steps()
+	if len(res.Trace) > 0 {
+		res.Duration = res.Trace[0].Duration
+	}
+	return res
+}
+
+// PurgeClient implements admin.API, the client is removed from the query log and the tops of the stats. Its address is
+// anonymized like the recorded ones
+func (s *Server) PurgeClient(client string) (int, error) {
+	s.lock.RLock()
+	l, anonymizer := s.queryLog, s.anonymizer
+	s.lock.RUnlock()
+	if ip := net.ParseIP(client); ip != nil {
+		client = anonymizer.Client(ip)
+	}
+	s.stats.ForgetClient(client)
+	return l.Purge(querylog.ClientMatcher(client))
+}
+
+// ExportQueryLog implements admin.API
+func (s *Server) ExportQueryLog(w io.Writer, opts querylog.ExportOptions) (int, error) {
+	s.lock.RLock()
+	l := s.queryLog
+	s.lock.RUnlock()
+	return l.Export(w, opts)
+}
+
+// Configuration implements admin.API
+func (s *Server) Configuration() configuration.ServerConf {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.conf
+}
+
+// BlockedNames implements admin.API
+func (s *Server) BlockedNames(group, source string) []string {
+	b := s.groupBlocker(group)
+	if b == nil {
+		return nil
+	}
+	return b.Names(source)
+}
+
+// BlockingLists implements admin.API
+func (s *Server) BlockingLists() []blocker.List {
+	s.lock.RLock()
+	b := s.blocker
+	s.lock.RUnlock()
+	if b == nil {
+		return nil
+	}
+	return b.Lists()
+}
+
+// AllowedPatterns implements admin.API
+func (s *Server) AllowedPatterns(group string) []string {
+	b := s.groupBlocker(group)
+	if b == nil {
+		return nil
+	}
+	return b.AllowedPatterns()
+}
+
+// groupBlocker returns the blocker of the client group, the one of every client when group is empty, nil when unknown
+func (s *Server) groupBlocker(group string) *blocker.Blocker {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if group == "" {
+		return s.blocker
+	}
+	return s.groups.Blocker(group)
+}
+
+// LocalRecords returns the records of the local names and services of the configuration, followed by the ones of its hosts file
+func LocalRecords(conf configuration.ServerConf) []dto.Record {
+	res := buildCustom(conf).Records()
+	if conf.Hosts.Path != "" {
+		file, err := hosts.Load(conf.Hosts.Path)
+		if err != nil {
+			logger.Error("error loading the hosts file", "path", conf.Hosts.Path, "err", err)
+		}
+		if file != nil {
+			res = append(res, file.Records()...)
+		}
+	}
+	return res
+}
+
+// LocalRecords implements admin.API, the records of the DHCP leases follow the ones of the hosts file
+func (s *Server) LocalRecords() []dto.Record {
+	s.lock.RLock()
+	custom, hostsFile, dhcp := s.custom, s.hosts, s.leases
+	s.lock.RUnlock()
+	return append(append(custom.Records(), hostsFile.Records()...), dhcp.Records()...)
+}
+
+// Maintenance implements admin.API
+func (s *Server) Maintenance() maintenance.Status {
+	return s.maintenance.Status()
+}
+
+// SetMaintenance implements admin.API
+func (s *Server) SetMaintenance(enabled bool, reason string) maintenance.Status {
+	if enabled {
+		logger.Warn("maintenance started, answering from the cache only", "reason", reason)
+	} else {
+		logger.Info("maintenance ended")
+	}
+	return s.maintenance.Set(enabled, reason, time.Now())
+}
+
+// Offline implements admin.API
+func (s *Server) Offline() admin.OfflineStatus {
+	conf := s.Configuration()
+	answer, _ := offline.ParseAnswer(conf.OfflineAnswer)
+	return admin.OfflineStatus{Enabled: s.offline.Enabled() || !conf.AllowExternal, Answer: answer.String()}
+}
+
+// SetOffline implements admin.API
+func (s *Server) SetOffline(enabled bool) admin.OfflineStatus {
+	if enabled {
+		logger.Warn("external resolution switched off, answering the local names and the cache only")
+	} else {
+		logger.Info("external resolution switched back on")
+	}
+	s.offline.Set(enabled)
+	return s.Offline()
+}
+
+// Pauses implements admin.API
+func (s *Server) Pauses() []pause.Pause {
+	return s.pause.Pauses()
+}
+
+// PauseBlocking implements admin.API
+func (s *Server) PauseBlocking(client string, d time.Duration) ([]pause.Pause, error) {
+	p, err := s.pause.Pause(client, d)
+	if err != nil {
+		return nil, err
+	}
+	logger.Warn("blocking paused", "client", p.Client, "until", p.Until)
+	return s.pause.Pauses(), nil
+}
+
+// ResumeBlocking implements admin.API
+func (s *Server) ResumeBlocking(client string) ([]pause.Pause, error) {
+	resumed, err := s.pause.Resume(client)
+	if err != nil {
+		return nil, err
+	}
+	if resumed {
+		logger.Info("blocking resumed", "client", client)
+	}
+	return s.pause.Pauses(), nil
+}
+
+// ClientHints implements admin.API
+func (s *Server) ClientHints() []fingerprint.Hint {
+	s.lock.RLock()
+	hints := s.hints
+	s.lock.RUnlock()
+	return hints.Hints()
+}
+
+// ChaosRules implements admin.API
+func (s *Server) ChaosRules() ([]chaos.Rule, error) {
+	s.lock.RLock()
+	injector := s.chaos
+	s.lock.RUnlock()
+	if injector == nil {
+		return nil, admin.ErrChaosDisabled
+	}
+	return injector.Rules(), nil
+}
+
+// SetChaosRules implements admin.API
+func (s *Server) SetChaosRules(rules []chaos.Rule) error {
+	s.lock.RLock()
+	injector := s.chaos
+	s.lock.RUnlock()
+	if injector == nil {
+		return admin.ErrChaosDisabled
+	}
+	for _, rule := range rules {
+		if err := rule.Check(); err != nil {
+			return err
+		}
+	}
+	logger.Warn("chaos rules replaced until the next reload", "rules", len(rules))
+	injector.SetRules(rules)
+	return nil
+}
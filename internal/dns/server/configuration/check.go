@@ -0,0 +1,269 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxTTL is the largest ttl of a record, RFC 2181 reserves the most significant bit
+const maxTTL = math.MaxInt32
+
+// deprecatedKeys are the keys removed from the configuration, by path like cache.size, with the way to replace them.
+// The files written for an older version keep loading, the keys are ignored with a warning
+var deprecatedKeys = map[string]string{}
+
+// decode reads the configuration in data, its keys must be fields of the configuration so a misspelled one is not
+// ignored silently, the deprecated ones are ignored with a warning. The errors tell the line and the column they occur at
+// when positions, data being the file itself
+func decode(data []byte, conf *ServerConf, positions bool) error {
+	// an invalid file has no key to check, the decoder reports its error
+	keys, _ := unknownKeys(data, reflect.TypeOf(*conf))
+	for _, key := range keys {
+		advice, deprecated := deprecatedKeys[key.path]
+		if !deprecated {
+			err := errors.New(unknownField + strconv.Quote(key.name))
+			if positions {
+				err = errors.New(position(data, key.offset) + ": " + err.Error())
+			}
+			return err
+		}
+		logger.Warn("ignoring the deprecated key of the configuration", "key", key.path, "replacement", advice)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if len(keys) == 0 {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(conf)
+	if err == nil {
+		return nil
+	}
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		err = errors.New("the value of " + typeError.Field + " is a " + typeError.Value + ", expecting a " + typeError.Type.String())
+	}
+	if !positions {
+		return err
+	}
+	// the offsets of the errors are the ones of the byte following the invalid character or value
+	offset := decoder.InputOffset()
+	var syntaxError *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxError):
+		offset = syntaxError.Offset - 1
+	case typeError != nil:
+		offset = typeError.Offset - 1
+	}
+	return errors.New(position(data, offset) + ": " + err.Error())
+}
+
+// unknownField prefixes the error of an unknown key, followed by the quoted key, like the one of the json decoder
+const unknownField = "json: unknown field "
+
+// keyAt is a key of the file, its path from the root like cache.size and the offset of its opening quote
+type keyAt struct {
+	name   string
+	path   string
+	offset int64
+}
+
+// unknownKeys returns the keys of data which are not fields of t, in the order of the file. The keys below an unknown
+// key are not reported
+func unknownKeys(data []byte, t reflect.Type) ([]keyAt, error) {
+	var res []keyAt
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	err := walkKeys(decoder, data, t, "", &res)
+	return res, err
+}
+
+// walkKeys reads the next value of the decoder, which is decoded in a value of type t, nil when it is not decoded
+func walkKeys(decoder *json.Decoder, data []byte, t reflect.Type, path string, res *[]keyAt) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for decoder.More() {
+			if err := walkKeys(decoder, data, elem, path, res); err != nil {
+				return err
+			}
+		}
+	case json.Delim('{'):
+		for decoder.More() {
+			// the offset is the one of the end of the previous token, the key starts at the following quote
+			offset := decoder.InputOffset()
+			token, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := token.(string)
+			if i := bytes.IndexByte(data[offset:], '"'); i >= 0 {
+				offset += int64(i)
+			}
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			var value reflect.Type
+			switch {
+			case t == nil:
+			case t.Kind() == reflect.Map:
+				value = t.Elem()
+			case t.Kind() == reflect.Struct:
+				var known bool
+				if value, known = fieldType(t, key); !known {
+					*res = append(*res, keyAt{name: key, path: keyPath, offset: offset})
+				}
+			}
+			if err := walkKeys(decoder, data, value, keyPath, res); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	// the closing delimiter
+	_, err = decoder.Token()
+	return err
+}
+
+// fieldType returns the type of the field of the struct t the json decoder fills with the value of key,
+// the fields of the embedded structs included
+func fieldType(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		switch {
+		case name == "-" && !strings.HasPrefix(tag, "-,"):
+			continue
+		case field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct:
+			if res, ok := fieldType(field.Type, key); ok {
+				return res, true
+			}
+			continue
+		case !field.IsExported():
+			continue
+		case name == "":
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
+
+// position returns the line and the column of the byte at offset in data
+func position(data []byte, offset int64) string {
+	offset = max(0, min(offset, int64(len(data))))
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return "line " + strconv.Itoa(line) + ", column " + strconv.Itoa(column)
+}
+
+// checkAddress returns an error when the address is not a host, possibly empty, and a port
+func checkAddress(owner, address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil && strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+		return errors.New("invalid address " + address + " of the " + owner + ", an IPv6 host is written in brackets like [::]:53")
+	}
+	if err != nil {
+		return errors.New("invalid address of the " + owner + ": " + err.Error())
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return errors.New("invalid port " + port + " of the " + owner + " " + address + ", expecting a number up to 65535")
+	}
+	return nil
+}
+
+// checkAddresses checks the addresses the server listens on and the endpoints of the upstreams
+func (c ServerConf) checkAddresses() error {
+	for _, address := range c.listenAddresses() {
+		if err := checkAddress("endpoint", address); err != nil {
+			return err
+		}
+	}
+	sources := append([]ExternalSource{c.External, c.Audit.Reference}, c.Upstreams.Sources...)
+	for _, f := range c.Forwarders {
+		sources = append(sources, f.Upstream)
+	}
+	for _, source := range sources {
+		if err := source.checkEndpoint(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEndpoint checks the endpoint of the source is an https url for DOH, and an address with a port otherwise
+func (s ExternalSource) checkEndpoint() error {
+	if s.Endpoint == "" || !s.needsEndpoint() {
+		return nil
+	}
+	if s.Type != "DOH" {
+		return checkAddress("upstream", s.Endpoint)
+	}
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return errors.New("invalid url of the DOH upstream: " + err.Error())
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("invalid url of the DOH upstream " + s.Endpoint + ", expecting https://host/path")
+	}
+	return nil
+}
+
+// checkTTLs returns an error when a ttl of the configuration does not fit in a record
+func (c ServerConf) checkTTLs() error {
+	type namedTTL struct {
+		name string
+		ttl  uint32
+	}
+	ttls := []namedTTL{
+		{"cache.basettl", c.Cache.Basettl},
+		{"blocking.ttl", c.Blocking.TTL},
+		{"nxdomain_cache.ttl", c.NXDomainCache.TTL},
+		{"metered.min_ttl", c.Metered.MinTTL},
+		{"noise.ttl", c.Noise.TTL},
+	}
+	for _, r := range c.Rewrites {
+		ttls = append(ttls, namedTTL{"the ttl of the rewrite of " + r.Domain, r.TTL})
+	}
+	for _, group := range c.ResponseGroups {
+		ttls = append(ttls, namedTTL{"the ttl of the response group " + group.Name, group.TTL})
+	}
+	for _, group := range c.ClientGroups {
+		ttls = append(ttls, namedTTL{"the metered.min_ttl of the client group " + group.Name, group.Metered.MinTTL})
+	}
+	for _, t := range ttls {
+		if t.ttl > maxTTL {
+			return errors.New(t.name + " is " + strconv.FormatUint(uint64(t.ttl), 10) + ", a ttl cannot exceed " + strconv.Itoa(maxTTL) + " seconds")
+		}
+	}
+	return nil
+}
+
+// Lists returns the blocking and allow lists of the configuration and of its client groups
+func (c ServerConf) Lists() []string {
+	res := append(append([]string{}, c.BlockingLists...), c.AllowLists...)
+	for _, group := range c.ClientGroups {
+		res = append(append(res, group.BlockingLists...), group.AllowLists...)
+	}
+	return res
+}
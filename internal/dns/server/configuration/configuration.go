@@ -1,13 +1,265 @@
 package configuration
 
+import (
+	"errors"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	"github.com/bluguard/dnshield/internal/dns/cache/memorycache"
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/chaos"
+	"github.com/bluguard/dnshield/internal/dns/client/groups"
+	"github.com/bluguard/dnshield/internal/dns/client/healthcheck"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/client/leases"
+	"github.com/bluguard/dnshield/internal/dns/client/multiclient"
+	noiseclient "github.com/bluguard/dnshield/internal/dns/client/noise"
+	"github.com/bluguard/dnshield/internal/dns/client/offline"
+	"github.com/bluguard/dnshield/internal/dns/client/override"
+	scheduleclient "github.com/bluguard/dnshield/internal/dns/client/schedule"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	privacymode "github.com/bluguard/dnshield/internal/dns/privacy"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint/dohendpoint"
+	"github.com/bluguard/dnshield/internal/dns/sortlist"
+	blockparser "github.com/bluguard/dnshield/internal/dns/util/blockParser"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
 type udpEndpoint struct {
 	Enabled bool
 	Address string `json:"address"`
+	TCP     bool   `json:"tcp"`
+	// Workers is the number of sockets of the udp endpoints, the one of the profile when zero
+	Workers int `json:"workers,omitempty"`
+	// Handlers is the number of queries of an udp endpoint resolved at once, the one of the profile when zero
+	Handlers  int       `json:"handlers,omitempty"`
+	RateLimit rateLimit `json:"rate_limit"`
+}
+
+// rateLimit limits the queries per second of every client ip, or ipv6 /64, disabled when QPS is zero.
+// Every Slip-th limited udp query is answered truncated so a legitimate client retries over tcp, 2 when zero, never when negative
+type rateLimit struct {
+	QPS   float64 `json:"qps,omitempty"`
+	Burst int     `json:"burst,omitempty"`
+	Slip  int     `json:"slip,omitempty"`
+}
+
+type dohEndpoint struct {
+	Address string `json:"address,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Cert    string `json:"cert,omitempty"`
+	Key     string `json:"key,omitempty"`
+	// ACME serves https with the certificate provisioned for the acme domains
+	ACME bool `json:"acme,omitempty"`
+}
+
+// acme provisions and renews the certificates of the Domains from Let's Encrypt, or from the authority of Directory.
+// They are validated with the tls-alpn-01 challenge when an acme endpoint listens on port 443, or with the http-01
+// challenge served on HTTPAddress, reachable on port 80. The account and the certificates are kept in CacheDir
+type acme struct {
+	Domains     []string `json:"domains,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	CacheDir    string   `json:"cache_dir,omitempty"`
+	HTTPAddress string   `json:"http_address,omitempty"`
+	Directory   string   `json:"directory,omitempty"`
+}
+
+func (a acme) enabled() bool {
+	return len(a.Domains) > 0
+}
+
+func (a acme) check() error {
+	if !a.enabled() {
+		return nil
+	}
+	if a.CacheDir == "" {
+		return errors.New("the acme certificates need a cache directory")
+	}
+	for _, domain := range a.Domains {
+		if domain == "" || strings.ContainsAny(domain, ":/ ") {
+			return errors.New("invalid acme domain " + domain)
+		}
+	}
+	if a.HTTPAddress != "" {
+		if _, _, err := net.SplitHostPort(a.HTTPAddress); err != nil {
+			return errors.New("invalid address of the acme challenges: " + err.Error())
+		}
+	}
+	if a.Directory != "" {
+		u, err := url.Parse(a.Directory)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("invalid acme directory " + a.Directory + ", expecting an https url")
+		}
+	}
+	return nil
+}
+
+// listenEndpoint is a dns endpoint of the endpoints list, listening on its address with its protocol, udp, tcp, doh or dot.
+// The doh endpoints take the path and the certificate of their entry, the udp and tcp ones the rate limit of the endpoint.
+// The dot endpoints need a certificate, reloaded when its files change with Reload, or the one provisioned with acme
+type listenEndpoint struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Path     string `json:"path,omitempty"`
+	Cert     string `json:"cert,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Reload   bool   `json:"reload,omitempty"`
+	ACME     bool   `json:"acme,omitempty"`
+}
+
+// network returns the network the endpoint listens on, the doh endpoints listen on tcp
+func (e listenEndpoint) network() string {
+	if e.Protocol == "udp" {
+		return "udp"
+	}
+	return "tcp"
+}
+
+func (e listenEndpoint) check() error {
+	switch e.Protocol {
+	case "udp", "tcp", "doh", "dot":
+	default:
+		return errors.New("unknown endpoint protocol " + e.Protocol + ", expecting udp, tcp, doh or dot")
+	}
+	if e.Address == "" {
+		return errors.New("the " + e.Protocol + " endpoint needs an address")
+	}
+	if err := checkAddress(e.Protocol+" endpoint", e.Address); err != nil {
+		return err
+	}
+	if (e.Cert == "") != (e.Key == "") {
+		return errors.New("the " + e.Protocol + " endpoint " + e.Address + " needs both a certificate and a key")
+	}
+	if e.Protocol == "dot" && e.Cert == "" && !e.ACME {
+		return errors.New("the dot endpoint " + e.Address + " needs a certificate")
+	}
+	if (e.Cert != "" || e.ACME) && e.Protocol != "doh" && e.Protocol != "dot" {
+		return errors.New("the certificate only applies to the doh and dot endpoints")
+	}
+	if e.ACME && (e.Cert != "" || e.Reload) {
+		return errors.New("the " + e.Protocol + " endpoint " + e.Address + " has both a certificate and acme")
+	}
+	if e.Path != "" && e.Protocol != "doh" {
+		return errors.New("the path only applies to the doh endpoints")
+	}
+	if e.Reload && e.Protocol != "dot" {
+		return errors.New("the reload of the certificate only applies to the dot endpoints")
+	}
+	return nil
+}
+
+type grpcEndpoint struct {
+	Address string `json:"address,omitempty"`
+}
+
+type publicStats struct {
+	Address string `json:"address,omitempty"`
+}
+
+type metricsEndpoint struct {
+	Address string `json:"address,omitempty"`
+}
+
+// adminEndpoint serves the admin api. Its changes are limited to MutationsPerMinute per client, 60 when not set,
+// and recorded in the append-only AuditLog file, in the log of the server when no file is set.
+// Canary is the name resolved through the chain to check the readiness of the server, example.com when not set
+type adminEndpoint struct {
+	Address            string `json:"address,omitempty"`
+	AuditLog           string `json:"audit_log,omitempty"`
+	MutationsPerMinute int    `json:"mutations_per_minute,omitempty"`
+	Canary             string `json:"canary,omitempty"`
+}
+
+// defaultCanary is the name resolved to check the readiness without configured canary
+const defaultCanary = "example.com"
+
+// loopback tells if the admin api only listens on a loopback address, or is disabled
+func (a adminEndpoint) loopback() bool {
+	if a.Address == "" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(a.Address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// CanaryName returns the name resolved to check the readiness of the server
+func (a adminEndpoint) CanaryName() string {
+	if a.Canary == "" {
+		return defaultCanary
+	}
+	return strings.TrimSuffix(a.Canary, ".")
+}
+
+// ExternalSource is the upstream, Type is one of DOH, DOT, DOQ, UDP or RECURSIVE (resolving from the root servers, without endpoint),
+// or the name of a preset provider like cloudflare or quad9, without endpoint
+type ExternalSource struct {
+	Type       string `json:"type"`
+	Endpoint   string `json:"endpoint"`
+	ServerName string `json:"server_name,omitempty"`
+	// Timeout bounds each query to an UDP or DOH upstream in milliseconds, 0 keeps the default: 2s for UDP, 5s for DOH.
+	// Retries is the number of times the query is sent again when it times out or fails, 0 sends it once.
+	// Without retries the default applies: one retry for UDP, none for DOH
+	Timeout uint32  `json:"timeout,omitempty"`
+	Retries *uint32 `json:"retries,omitempty"`
+	// KeepCase sends the names to an UDP upstream in their case, for the upstreams not echoing the question as asked.
+	// The letters of the names are in a random case otherwise, the responses echoing them in another case are dropped
+	KeepCase bool `json:"keep_case,omitempty"`
+	// Connections is the number of connections a DOH upstream opens at most, 100 when 0, IdleTimeout the time in seconds
+	// an idle connection is kept open for the next queries, 90 when 0. Prewarm opens a connection at startup
+	Connections uint32 `json:"connections,omitempty"`
+	IdleTimeout uint32 `json:"idle_timeout,omitempty"`
+	Prewarm     bool   `json:"prewarm,omitempty"`
+	// DNSSEC is the policy of the answers of the upstream: ignore (default), trust its AD bit as a validator,
+	// over an encrypted transport only, or validate them locally
+	DNSSEC string `json:"dnssec,omitempty"`
+	// Family is the address family the upstream is reached over, whatever the type of the records asked: ipv4 or
+	// ipv6 only, or prefer-ipv4 and prefer-ipv6 falling back to the other family when the preferred one is unreachable.
+	// The system chooses when empty
+	Family string `json:"family,omitempty"`
+}
+
+// upstreams are several external sources used instead of External, spread according to Strategy:
+// failover (default), round-robin or race
+type upstreams struct {
+	Strategy string           `json:"strategy,omitempty"`
+	Sources  []ExternalSource `json:"sources,omitempty"`
 }
 
-type externalSource struct {
-	Type     string `json:"type"`
-	Endpoint string `json:"endpoint"`
+// forwarder sends the questions about Zone and its subdomains to Upstream instead of the external source
+type forwarder struct {
+	Zone     string         `json:"zone"`
+	Upstream ExternalSource `json:"upstream"`
+}
+
+// Sources returns the sources of the upstream of the forwarder, with its preset expanded
+func (f forwarder) Sources() []ExternalSource {
+	return f.Upstream.expand()
+}
+
+type audit struct {
+	Rate      float64        `json:"rate,omitempty"`
+	Reference ExternalSource `json:"reference"`
+}
+
+// stubZone is a zone resolved by its authoritative servers, found by asking its NS records to Servers (ip or ip:port)
+type stubZone struct {
+	Zone    string   `json:"zone"`
+	Servers []string `json:"servers"`
 }
 
 type custom struct {
@@ -15,21 +267,1282 @@ type custom struct {
 	Address string `json:"address"`
 }
 
+// reverse is the PTR record of an address, answered before the ones synthesized from the custom names
+type reverse struct {
+	Address string `json:"address"`
+	Name    string `json:"name"`
+}
+
+// hostsFile is a file in the /etc/hosts format, which can also hold records of the other types as name, type and data
+type hostsFile struct {
+	Path string `json:"path,omitempty"`
+	// Interval is the interval in seconds between two checks of the file for changes, 10 when 0
+	Interval uint32 `json:"interval,omitempty"`
+}
+
+// mdns answers the names of .local and of the LAN Suffixes, asked as names of .local, by querying the devices of the
+// local network with multicast DNS, on Interface or through the default route when empty, or through the unicast
+// Bridge, like the DNS server of avahi or bonjour, when set. The names are never asked to the upstream
+type mdns struct {
+	Enabled   bool     `json:"enabled"`
+	Suffixes  []string `json:"suffixes,omitempty"`
+	Interface string   `json:"interface,omitempty"`
+	Bridge    string   `json:"bridge,omitempty"`
+	// Timeout is the time in milliseconds the devices have to answer, 1000 when 0
+	Timeout uint32 `json:"timeout,omitempty"`
+}
+
+func (m mdns) check() error {
+	if !m.Enabled {
+		return nil
+	}
+	if m.Interface != "" && m.Bridge != "" {
+		return errors.New("mdns is asked either on an interface or through a bridge")
+	}
+	if m.Bridge != "" {
+		if err := checkAddress("mdns bridge", m.Bridge); err != nil {
+			return err
+		}
+	}
+	for _, suffix := range m.Suffixes {
+		if strings.Trim(suffix, ".") == "" || strings.Contains(suffix, "*") {
+			return errors.New("invalid mdns suffix " + suffix + ", expecting a domain like lan")
+		}
+	}
+	return nil
+}
+
+// Zones returns the zones answered with multicast DNS, .local and the suffixes
+func (m mdns) Zones() []string {
+	return append([]string{"local"}, m.Suffixes...)
+}
+
+// dhcpLeases are the lease files of the local DHCP servers, or the urls of a lease API answering the leases in json,
+// whose hostnames are answered with their addresses and the addresses with PTR records of the hostnames
+type dhcpLeases struct {
+	Sources []string `json:"sources,omitempty"`
+	// Format is the format of the lease files, dnsmasq, isc or kea, guessed from their content when empty
+	Format string `json:"format,omitempty"`
+	// Domain qualifies the hostnames, which are answered with and without it. The hostnames with several labels are only
+	// answered when they are under the domain
+	Domain string `json:"domain,omitempty"`
+	// Interval is the interval in seconds between two checks of the sources for changes, 10 when 0
+	Interval uint32 `json:"interval,omitempty"`
+}
+
+func (d dhcpLeases) check() error {
+	if _, err := leases.ParseFormat(d.Format); err != nil {
+		return err
+	}
+	for _, source := range d.Sources {
+		if source == "" {
+			return errors.New("empty lease source")
+		}
+		if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+			continue
+		}
+		if u, err := url.Parse(source); err != nil || u.Host == "" {
+			return errors.New("invalid lease api url " + source)
+		}
+	}
+	if strings.Contains(d.Domain, "*") || strings.Contains(d.Domain, " ") {
+		return errors.New("invalid lease domain " + d.Domain + ", expecting a domain like lan")
+	}
+	return nil
+}
+
+// service is a local service published with DNS-SD, discovered as Instance.Type.Domain
+type service struct {
+	Instance string `json:"instance"`
+	// Type is the service and its protocol, e.g. _ipp._tcp
+	Type   string   `json:"type"`
+	Domain string   `json:"domain"`
+	Host   string   `json:"host"`
+	Port   uint16   `json:"port"`
+	TXT    []string `json:"txt,omitempty"`
+}
+
+// responseGroup answers the questions about Domains with Addresses instead of the upstream answers,
+// e.g. the LAN address of a name whose public zone points at the WAN address
+type responseGroup struct {
+	Name string `json:"name"`
+	// Domains are the overridden names, *.name overrides the subdomains
+	Domains []string `json:"domains"`
+	// Addresses are the answers, the domains have no record of the family without address
+	Addresses []string `json:"addresses"`
+	TTL       uint32   `json:"ttl,omitempty"`
+}
+
+// rewrite answers an address or an alias for a name, or for the subdomains of a domain with *.name, like 192.168.1.50
+// for *.lab.home. An alias is resolved like the name asked, a name has either an alias or addresses
+type rewrite struct {
+	Domain string `json:"domain"`
+	Answer string `json:"answer"`
+	TTL    uint32 `json:"ttl,omitempty"`
+}
+
+// typeFilter answers the questions of its Types, like ANY, HTTPS or 65, without asking any source:
+// refused (default) or empty, an answer without record
+type typeFilter struct {
+	Types  []string `json:"types"`
+	Answer string   `json:"answer,omitempty"`
+}
+
+// clientGroup blocks more names for the clients of its networks, e.g. the adult content for the devices of the children.
+// Its lists and block rules are applied on top of the ones of every client, its allowed names are never blocked for its clients.
+// A client belongs to the group of its most specific network
+type clientGroup struct {
+	Name string `json:"name"`
+	// Clients are the addresses, the subnets and the devices of the clients of the group. A link-local address
+	// or subnet may be restricted to an interface with a zone, like fe80::1%eth0 or fe80::%eth0/64
+	Clients       []string `json:"clients"`
+	BlockingLists []string `json:"blocking_list,omitempty"`
+	AllowLists    []string `json:"allow_list,omitempty"`
+	AllowDomains  []string `json:"allow_domains,omitempty"`
+	BlockRules    []string `json:"block_rules,omitempty"`
+	// Custom are the local names answered to the clients of the group only, before the custom names of every client
+	Custom []custom `json:"custom,omitempty"`
+	// SafeSearch asks the SafeSearch variant of the search engines for the clients of the group
+	SafeSearch bool `json:"safe_search,omitempty"`
+	// Metered keeps the answers of the group for its minimum ttl and serves them stale for longer, without prefetching
+	// for the group. The min_ttl and serve_stale of the metered section are taken when 0
+	Metered metered `json:"metered"`
+}
+
+// device is a client with several addresses, like the ipv4, the ipv6 and the link-local addresses of a dual-stack phone,
+// named in the client groups as a single client
+type device struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`
+}
+
+// Networks returns the subnets of the clients of the group, an address is a subnet of a single client
+// and a device the addresses it is known by
+func (g clientGroup) Networks(devices []device) ([]groups.Network, error) {
+	res := make([]groups.Network, 0, len(g.Clients))
+	for _, value := range g.Clients {
+		if i := slices.IndexFunc(devices, func(d device) bool { return d.Name == value }); i >= 0 {
+			for _, address := range devices[i].Addresses {
+				network, err := parseNetwork(address)
+				if err != nil {
+					return nil, errors.New("device " + value + " of the group " + g.Name + ": " + err.Error())
+				}
+				res = append(res, network)
+			}
+			continue
+		}
+		network, err := parseNetwork(value)
+		if err != nil {
+			return nil, errors.New("group " + g.Name + ": " + err.Error())
+		}
+		res = append(res, network)
+	}
+	return res, nil
+}
+
+// parseNetwork parses an address or a subnet, with the zone of a link-local one
+func parseNetwork(value string) (groups.Network, error) {
+	address, zone := value, ""
+	if i := strings.IndexByte(value, '%'); i >= 0 {
+		zone, address = value[i+1:], value[:i]
+		if j := strings.IndexByte(zone, '/'); j >= 0 {
+			zone, address = zone[:j], address+zone[j:]
+		}
+	}
+	var subnet *net.IPNet
+	if ip := net.ParseIP(address); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		subnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else if _, parsed, err := net.ParseCIDR(address); err == nil {
+		subnet = parsed
+	} else {
+		return groups.Network{}, errors.New("invalid client " + value + ", expecting an address, a subnet or a device")
+	}
+	if zone != "" && !subnet.IP.IsLinkLocalUnicast() {
+		return groups.Network{}, errors.New("invalid client " + value + ", only a link-local address has a zone")
+	}
+	return groups.Network{Subnet: subnet, Zone: zone}, nil
+}
+
+type autoTune struct {
+	Enabled bool  `json:"enabled"`
+	MinSize int64 `json:"min_size,omitempty"`
+	MaxSize int64 `json:"max_size,omitempty"`
+}
+
 type cache struct {
-	Size         int64  `json:"size,omitempty"`
-	Basettl      uint32 `json:"basettl,omitempty"`
-	ForceBasettl bool   `json:"force_base_ttl,omitempty"`
+	// Size is the memory of the cache in bytes, the one of the profile when 0, -1 disables the cache
+	Size         int64    `json:"size,omitempty"`
+	Basettl      uint32   `json:"basettl,omitempty"`
+	ForceBasettl bool     `json:"force_base_ttl,omitempty"`
+	AutoTune     autoTune `json:"auto_tune"`
+	// Eviction is the policy removing an entry from the full cache, ttl (default), lru or lfu
+	Eviction string `json:"eviction,omitempty"`
+	// Snapshot is the file the cache is saved to every SnapshotInterval seconds and at shutdown, and loaded from at startup
+	Snapshot         string   `json:"snapshot,omitempty"`
+	SnapshotInterval uint32   `json:"snapshot_interval,omitempty"`
+	Prefetch         prefetch `json:"prefetch"`
+}
+
+// prefetch resolves again the popular names before their expiry, it is disabled in metered mode
+type prefetch struct {
+	// Threshold is the number of hits making an entry popular, 0 disables the prefetch
+	Threshold uint32 `json:"threshold,omitempty"`
+	// Lead is the duration in seconds before the expiry the popular entries are resolved again
+	Lead uint32 `json:"lead,omitempty"`
+}
+
+// metered mode limits the upstream queries for the deployments paying for their traffic. At the top level it applies
+// to every client, in a client group to the clients of the group: the cache is shared, an entry expired for the other
+// clients still answers the metered group until its minimum ttl, and the hits of the group do not make it prefetched
+type metered struct {
+	Enabled bool `json:"enabled"`
+	// MinTTL is the minimum ttl of the cached records, in seconds
+	MinTTL uint32 `json:"min_ttl,omitempty"`
+	// ServeStale is the duration the expired records are served when the upstream fails, in seconds
+	ServeStale uint32 `json:"serve_stale,omitempty"`
+}
+
+// noise keeps the answers to the names asked by the devices on their own, like the connectivity checks, the time servers
+// and the telemetry, for a long time in the cache. The clients still receive the ttl of the records, and the negative
+// answers are cached as usual
+type noise struct {
+	Enabled bool `json:"enabled"`
+	// TTL is the minimum time the answers to the noise domains are kept in the cache, in seconds, 6 hours when 0
+	TTL uint32 `json:"ttl,omitempty"`
+	// Domains are more noise domains than the well-known ones, *.name for the subdomains of name
+	Domains []string `json:"domains,omitempty"`
+}
+
+func (n noise) check() error {
+	for _, domain := range n.Domains {
+		if err := noiseclient.CheckDomain(domain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshots keeps the last configurations run by the server with the rules edited through the api, so a bad change
+// can be rolled back. They are kept in memory, and written to Directory when set so they survive the restarts
+type snapshots struct {
+	Directory string `json:"directory,omitempty"`
+	// Keep is the number of snapshots kept, 20 when 0
+	Keep int `json:"keep,omitempty"`
+}
+
+func (s snapshots) check() error {
+	if s.Keep < 0 {
+		return errors.New("the number of snapshots kept cannot be negative")
+	}
+	return nil
+}
+
+// equal tells if the noise settings are the same, the cache keeps the answers according to them
+func (n noise) equal(other noise) bool {
+	return n.Enabled == other.Enabled && n.TTL == other.TTL && slices.Equal(n.Domains, other.Domains)
+}
+
+// NoiseDomains returns the well-known noise domains followed by the ones of the configuration
+func (n noise) NoiseDomains() []string {
+	return append(slices.Clip(noiseclient.Domains), n.Domains...)
+}
+
+type nxdomainCache struct {
+	TTL uint32 `json:"ttl,omitempty"`
+	// Size is the number of names of the negative cache, the one of the profile when 0, -1 disables the cache
+	Size int `json:"size,omitempty"`
+}
+
+type edns struct {
+	// UnknownOptions is the handling of the EDNS options not defined by a rfc, "strip" (default) or "pass" to the upstream
+	UnknownOptions string `json:"unknown_options,omitempty"`
+}
+
+// alerts warns when the questions answered with SERVFAIL during the last minute reach ServFailPerMinute, disabled when zero.
+// The alerts and their resolution are logged, and posted as json to the Webhook when set
+type alerts struct {
+	ServFailPerMinute int    `json:"servfail_per_minute,omitempty"`
+	Webhook           string `json:"webhook,omitempty"`
+}
+
+func (a alerts) check() error {
+	if a.ServFailPerMinute < 0 {
+		return errors.New("the SERVFAIL alert threshold cannot be negative")
+	}
+	if a.Webhook == "" {
+		return nil
+	}
+	u, err := url.Parse(a.Webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid alert webhook " + a.Webhook + ", expecting an http or https url")
+	}
+	return nil
+}
+
+type queryLog struct {
+	// Path is the file the questions are appended to, the log is disabled when empty
+	Path string `json:"path,omitempty"`
+	// Retention is the duration in seconds the entries are kept, 0 keeps them forever
+	Retention uint32 `json:"retention,omitempty"`
+	// MaxSize is the size in megabytes the file is rotated at, keeping Backups rotated files, 0 disables the rotation
+	MaxSize uint32 `json:"max_size,omitempty"`
+	Backups int    `json:"backups,omitempty"`
+}
+
+// privacy anonymizes the questions recorded by the query log, the stats and the traces, for the networks shared by
+// several people, dnstap cannot be enabled with it. The Clients are recorded as they are when empty, as a hash of their address with the Salt, random at
+// each start when empty, or truncated to their networks of PrefixV4 and PrefixV6 bits, 24 and 48 when 0
+type privacy struct {
+	Clients  string `json:"clients,omitempty"`
+	Salt     string `json:"salt,omitempty"`
+	PrefixV4 int    `json:"prefix_v4,omitempty"`
+	PrefixV6 int    `json:"prefix_v6,omitempty"`
+	// MinCount leaves the names asked fewer times out of the query log and the tops of the stats
+	MinCount uint32 `json:"min_count,omitempty"`
+	// RetentionDays purges the entries of the query log older than the days, even with a longer retention
+	RetentionDays uint32 `json:"retention_days,omitempty"`
+}
+
+// anonymizes tells if the clients or the names are anonymized
+func (p privacy) anonymizes() bool {
+	return p.Clients != "" || p.MinCount > 0
+}
+
+func (p privacy) check() error {
+	if _, err := privacymode.ParseMode(p.Clients); err != nil {
+		return err
+	}
+	if p.PrefixV4 < 0 || p.PrefixV4 > 32 {
+		return errors.New("the privacy IPv4 prefix must be between 0 and 32")
+	}
+	if p.PrefixV6 < 0 || p.PrefixV6 > 128 {
+		return errors.New("the privacy IPv6 prefix must be between 0 and 128")
+	}
+	return nil
+}
+
+// dnstap emits the queries of the clients and their responses as dnstap messages, to the receiver listening on the unix
+// Socket or to the File, disabled when both are empty. Upstream adds the exchanges with the upstream
+type dnstap struct {
+	Socket string `json:"socket,omitempty"`
+	File   string `json:"file,omitempty"`
+	// Identity is the name of the server in the messages, the hostname when empty
+	Identity string `json:"identity,omitempty"`
+	Upstream bool   `json:"upstream,omitempty"`
+}
+
+func (d dnstap) check() error {
+	if d.Socket != "" && d.File != "" {
+		return errors.New("dnstap is written either to a socket or to a file")
+	}
+	if d.Upstream && d.Socket == "" && d.File == "" {
+		return errors.New("the dnstap upstream messages need a socket or a file")
+	}
+	return nil
+}
+
+// tracing exports the spans of the queries to the OTLP/HTTP collector at Endpoint, like http://localhost:4318,
+// disabled when empty
+type tracing struct {
+	Endpoint string `json:"endpoint,omitempty"`
+	// SampleRate is the fraction of the queries traced, from 0 to 1, all of them when 0
+	SampleRate float64 `json:"sample_rate,omitempty"`
+	// ServiceName is the name of the server in the traces, dnshield when empty
+	ServiceName string `json:"service_name,omitempty"`
+}
+
+func (t tracing) check() error {
+	if t.SampleRate < 0 || t.SampleRate > 1 {
+		return errors.New("the tracing sample rate must be between 0 and 1")
+	}
+	if t.Endpoint == "" {
+		return nil
+	}
+	target, err := url.Parse(t.Endpoint)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("invalid tracing endpoint " + t.Endpoint + ", expecting an http or https url")
+	}
+	return nil
+}
+
+// Rate returns the fraction of the queries traced
+func (t tracing) Rate() float64 {
+	if t.SampleRate == 0 {
+		return 1
+	}
+	return t.SampleRate
+}
+
+// clientHints infers the kind of device of the clients from the names they ask, shown by the admin endpoint
+type clientHints struct {
+	Enabled bool `json:"enabled"`
+	// MaxClients is the number of clients tracked, 1000 when zero
+	MaxClients int `json:"max_clients,omitempty"`
+}
+
+type blocking struct {
+	// Mode is the answer to the blocked questions: null (default) for 0.0.0.0 and ::, nxdomain, refused,
+	// or sinkhole for the addresses SinkholeV4 and SinkholeV6
+	Mode       string `json:"mode,omitempty"`
+	SinkholeV4 string `json:"sinkhole_v4,omitempty"`
+	SinkholeV6 string `json:"sinkhole_v6,omitempty"`
+	// TTL is the ttl in seconds of the blocked answers, 10 when zero
+	TTL uint32 `json:"ttl,omitempty"`
+	// CNAMECloaking blocks the answers of the upstream whose CNAME chain goes through a blocked name
+	CNAMECloaking bool `json:"cname_cloaking,omitempty"`
+	// Schedules block more names at some times of the week
+	Schedules []schedule `json:"schedules,omitempty"`
+}
+
+// schedule blocks the names of its rules on its days from a time to another in the local time of the server, like *.tiktok.com
+// for the group kids from 21:00 to 07:00 on weekdays
+type schedule struct {
+	Name string `json:"name"`
+	// Groups are the client groups the schedule applies to, every client when empty
+	Groups     []string `json:"groups,omitempty"`
+	BlockRules []string `json:"block_rules"`
+	// Days are mon to sun, weekdays or weekend, every day when empty. A schedule across midnight starts on its days
+	Days []string `json:"days,omitempty"`
+	// From and To are the times the schedule starts and ends like 21:00, it lasts all the day when they are equal
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Window returns the time of the week the schedule is active
+func (s schedule) Window() (scheduleclient.Window, error) {
+	res, err := scheduleclient.ParseWindow(s.Days, s.From, s.To)
+	if err != nil {
+		return res, errors.New("schedule " + s.Name + ": " + err.Error())
+	}
+	return res, nil
+}
+
+// follow makes the server a standby of a primary, applying its configuration and blocking lists
+type follow struct {
+	// Primary is the address of the admin endpoint of the primary
+	Primary string `json:"primary,omitempty"`
+	// Interval is the duration in seconds between two pulls of the configuration of the primary
+	Interval uint32 `json:"interval,omitempty"`
+}
+
+// chaosMode injects failures in front of the upstreams to validate the timeouts, the failover and the serve-stale,
+// its rules can be replaced through the admin endpoint until the next reload. It must not be enabled in production
+type chaosMode struct {
+	Enabled bool         `json:"enabled"`
+	Rules   []chaos.Rule `json:"rules,omitempty"`
+}
+
+// logConf is the output of the logs: Level is debug, info (default), warn or error, Format is text (default) or json
+// and Output is stderr (default), stdout or the path of a file. Components are the levels differing from Level, by component
+type logConf struct {
+	Level      string            `json:"level,omitempty"`
+	Format     string            `json:"format,omitempty"`
+	Output     string            `json:"output,omitempty"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// accessControl restricts the clients of the dns endpoints to the Allowed subnets, every client is allowed when empty.
+// The queries of the others are refused, or dropped when Action is drop
+type accessControl struct {
+	Allowed []string `json:"allowed,omitempty"`
+	Action  string   `json:"action,omitempty"`
+}
+
+// answerOrder orders the addresses of the answers: keep (default), rfc6724 or shuffle,
+// then the addresses of the Preferred subnets first, in their order
+type answerOrder struct {
+	Mode      string   `json:"mode,omitempty"`
+	Preferred []string `json:"preferred,omitempty"`
+}
+
+// maintenanceConf is the answer of the status domain, telling the clients whether a maintenance is in progress
+type maintenanceConf struct {
+	// StatusDomain answers TXT ok, or maintenance with the hint and the reason during a maintenance, disabled when empty
+	StatusDomain string `json:"status_domain,omitempty"`
+	Hint         string `json:"hint,omitempty"`
+}
+
+// healthCheck is a name answered by the server itself: Address, 127.0.0.1 when empty, to A or AAAA
+// and Text, ok followed by the version of the server when empty, to TXT
+type healthCheck struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+// runAs is the unprivileged user, and its group when Group is empty, the process switches to once its endpoints listen
+type runAs struct {
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+type policy struct {
+	Wasm string `json:"wasm,omitempty"`
 }
 
 // ServerConf represents the configuration of the dns server
 type ServerConf struct {
-	AllowExternal bool           `json:"allow_external"`
-	BlockingLists []string       `json:"blocking_list"`
-	Custom        []custom       `json:"custom"`
-	Cache         cache          `json:"cache"`
-	External      externalSource `json:"external"`
-	Endpoint      udpEndpoint    `json:"endpoint"`
-	Memdump       string         `json:"memdump,omitempty"`
+	// Profile sizes the resources of the server for the device it runs on, tiny (or router), default or large
+	Profile       string `json:"profile,omitempty"`
+	AllowExternal bool   `json:"allow_external"`
+	// OfflineAnswer is the answer to the names which are not local without external resolution, or while it is switched
+	// off through the api: refused, nxdomain, servfail or stale. The expired records of the cache are answered first when
+	// it keeps them, stale keeps them for a week unless metered
+	OfflineAnswer string `json:"offline_answer,omitempty"`
+	// BlockingLists are the urls of the lists of blocked names, or the paths of local files or of directories of lists
+	BlockingLists []string `json:"blocking_list"`
+	// BlockingListFormats are the formats of the blocking lists by url, hosts, domains or adblock, detected on every line
+	// of the lists missing
+	BlockingListFormats map[string]string `json:"blocking_list_formats,omitempty"`
+	// BlockingListsRefresh is the interval in seconds between two downloads of the blocking lists, 0 disables the refresh
+	BlockingListsRefresh uint32 `json:"blocking_list_refresh,omitempty"`
+	// BlockingListsCache is the directory keeping a copy of the downloaded lists, they are downloaded again only once
+	// changed and read from the copy while their source is unreachable. The lists are not kept when empty
+	BlockingListsCache string `json:"blocking_list_cache,omitempty"`
+	// BlockingListsStructure holds the names of the lists, compact or map (faster, taking more memory), the one of
+	// the profile when empty
+	BlockingListsStructure string `json:"blocking_list_structure,omitempty"`
+	// AllowLists are lists of names never blocked, AllowDomains are names never blocked, *.name allows the subdomains
+	AllowLists   []string `json:"allow_list,omitempty"`
+	AllowDomains []string `json:"allow_domains,omitempty"`
+	// BlockRules are names blocked without list, *.name blocks the subdomains and /regexp/ the names it matches
+	BlockRules []string `json:"block_rules,omitempty"`
+	Blocking   blocking `json:"blocking"`
+	Custom     []custom `json:"custom"`
+	// SearchSuffix completes the single-label names the local resolvers do not know, nas is resolved as nas.home.lan with
+	// home.lan, for the devices ignoring the search domain of their DHCP lease. Only the addresses are completed, never
+	// the top level domains
+	SearchSuffix string `json:"search_suffix,omitempty"`
+	// SearchFallback answers the names of the search suffix without local answer with the local names without the suffix,
+	// nas.home.lan with the custom name nas, instead of asking them to the upstream
+	SearchFallback bool `json:"search_fallback,omitempty"`
+	// SafeSearch asks the SafeSearch or restricted variant of google, bing, duckduckgo and youtube for every client,
+	// like forcesafesearch.google.com for www.google.com, the client groups may enforce it for their clients only
+	SafeSearch bool `json:"safe_search,omitempty"`
+	// Reverse are the explicit PTR records, the custom names get one for their address otherwise
+	Reverse []reverse `json:"reverse,omitempty"`
+	// Hosts are local names managed outside the configuration, reloaded when the file changes
+	Hosts hostsFile `json:"hosts"`
+	// MDNS answers the names of the devices of the local network advertised with multicast DNS
+	MDNS mdns `json:"mdns"`
+	// Leases answers the hostnames of the leases of the local DHCP servers
+	Leases dhcpLeases `json:"leases"`
+	// Services are the local services answered from the custom records to the DNS-SD clients
+	Services []service `json:"services,omitempty"`
+	// ResponseGroups override the answers of the upstream for their domains
+	ResponseGroups []responseGroup `json:"response_groups,omitempty"`
+	// Rewrites answer fixed addresses or aliases for local zones, the other questions about their names have no record
+	Rewrites []rewrite `json:"rewrites,omitempty"`
+	// TypeFilters answer the questions of some types before any other resolver, like ANY abused for amplification
+	// or HTTPS whose hints let the clients bypass the filtering
+	TypeFilters []typeFilter `json:"type_filters,omitempty"`
+	// Chain orders the stages of the resolver chain by name among ChainStages, the stages left out are not asked.
+	// The stages keep the options of their own settings, the default order is used when empty
+	Chain []string `json:"chain,omitempty"`
+	// ClientGroups block more names for some clients, Devices are the clients known by several addresses
+	ClientGroups  []clientGroup    `json:"client_groups,omitempty"`
+	Devices       []device         `json:"devices,omitempty"`
+	Cache         cache            `json:"cache"`
+	NXDomainCache nxdomainCache    `json:"nxdomain_cache"`
+	Metered       metered          `json:"metered"`
+	Noise         noise            `json:"noise"`
+	External      ExternalSource   `json:"external"`
+	Upstreams     upstreams        `json:"upstreams"`
+	Forwarders    []forwarder      `json:"forwarders,omitempty"`
+	Audit         audit            `json:"audit"`
+	Snapshots     snapshots        `json:"snapshots"`
+	Chaos         chaosMode        `json:"chaos"`
+	StubZones     []stubZone       `json:"stub_zones,omitempty"`
+	Endpoint      udpEndpoint      `json:"endpoint"`
+	Endpoints     []listenEndpoint `json:"endpoints,omitempty"`
+	ACME          acme             `json:"acme"`
+	Doh           dohEndpoint      `json:"doh"`
+	Grpc          grpcEndpoint     `json:"grpc"`
+	PublicStats   publicStats      `json:"public_stats"`
+	Metrics       metricsEndpoint  `json:"metrics"`
+	Admin         adminEndpoint    `json:"admin"`
+	Policy        policy           `json:"policy"`
+	EDNS          edns             `json:"edns"`
+	AnswerOrder   answerOrder      `json:"answer_order"`
+	ACL           accessControl    `json:"acl"`
+	QueryLog      queryLog         `json:"query_log"`
+	Privacy       privacy          `json:"privacy"`
+	Dnstap        dnstap           `json:"dnstap"`
+	Tracing       tracing          `json:"tracing"`
+	Alerts        alerts           `json:"alerts"`
+	ClientHints   clientHints      `json:"client_hints"`
+	Follow        follow           `json:"follow"`
+	GitSync       gitSync          `json:"git_sync"`
+	Log           logConf          `json:"log"`
+	Maintenance   maintenanceConf  `json:"maintenance"`
+	// DrainTimeout is the time in seconds the stopped endpoints answer the queries they already received, 5 when 0
+	DrainTimeout uint32 `json:"drain_timeout,omitempty"`
+	// RunAs drops the privileges of root once the endpoints listen, the endpoints restarted by a reload can no longer
+	// bind the privileged ports, unless their sockets are passed by systemd
+	RunAs runAs `json:"run_as"`
+	// HealthChecks are answered before any other source, even during a maintenance or without upstream
+	HealthChecks []healthCheck `json:"health_checks,omitempty"`
+	Memdump      string        `json:"memdump,omitempty"`
+	Tenants      []Tenant      `json:"tenants,omitempty"`
+}
+
+// ChainStages are the names of the stages of the resolver chain in their default order, a stage is part of the chain
+// when its settings enable it. External is the upstreams, or the offline answer without external resolution
+var ChainStages = []string{"TypeFilter", "Health", "Policy", "Status", "Block", "SafeSearch", "Override", "Rewrite",
+	"Custom", "Hosts", "Leases", "Suffix", "Search", "Cache", "MDNS", "Forward", "External"}
+
+// checkChain checks the stages of the chain are known and listed once
+func checkChain(chain []string) error {
+	listed := make(map[string]bool, len(chain))
+	for _, stage := range chain {
+		known := false
+		for _, name := range ChainStages {
+			known = known || strings.EqualFold(stage, name)
+		}
+		if !known {
+			return errors.New("unknown chain stage " + stage + ", expecting one of " + strings.Join(ChainStages, ", "))
+		}
+		if listed[strings.ToLower(stage)] {
+			return errors.New("the chain stage " + stage + " is listed twice")
+		}
+		listed[strings.ToLower(stage)] = true
+	}
+	return nil
+}
+
+// Tenant is an isolated server running in the same process, with its own configuration. Its endpoints listen on
+// their own addresses, except the doh ones which may share the address of another server with their own path
+type Tenant struct {
+	Name string `json:"name"`
+	ServerConf
+}
+
+// Validate checks the configuration is consistent
+func (c ServerConf) Validate() error {
+	addresses := make(map[string]string)
+	for _, address := range c.listenAddresses() {
+		addresses[address] = "main"
+	}
+	doh := c.dohEndpoints()
+	if c.EDNS.UnknownOptions != "" && c.EDNS.UnknownOptions != "strip" && c.EDNS.UnknownOptions != "pass" {
+		return errors.New("unknown EDNS options handling " + c.EDNS.UnknownOptions + ", expecting strip or pass")
+	}
+	if _, err := c.BlockingResponse(); err != nil {
+		return err
+	}
+	if err := c.checkAddresses(); err != nil {
+		return err
+	}
+	if err := c.checkTTLs(); err != nil {
+		return err
+	}
+	for _, rule := range c.BlockRules {
+		if err := blocker.CheckRule(rule); err != nil {
+			return err
+		}
+	}
+	for _, format := range c.BlockingListFormats {
+		if _, err := blockparser.ParseFormat(format); err != nil {
+			return err
+		}
+	}
+	if err := c.checkClientGroups(); err != nil {
+		return err
+	}
+	if err := c.checkSchedules(); err != nil {
+		return err
+	}
+	if _, err := multiclient.ParseStrategy(c.Upstreams.Strategy); err != nil {
+		return err
+	}
+	if _, err := memorycache.ParseEviction(c.Cache.Eviction); err != nil {
+		return err
+	}
+	if err := c.checkOffline(); err != nil {
+		return err
+	}
+	if err := c.checkProfile(); err != nil {
+		return err
+	}
+	if err := c.checkSearchSuffix(); err != nil {
+		return err
+	}
+	if err := c.Noise.check(); err != nil {
+		return err
+	}
+	if err := c.GitSync.check(c.Admin); err != nil {
+		return err
+	}
+	if err := c.Snapshots.check(); err != nil {
+		return err
+	}
+	for _, source := range append([]ExternalSource{c.External}, c.Upstreams.Sources...) {
+		if err := source.check(); err != nil {
+			return err
+		}
+	}
+	for _, f := range c.Forwarders {
+		if f.Zone == "" || (f.Upstream.Endpoint == "" && f.Upstream.needsEndpoint()) {
+			return errors.New("forwarder " + f.Zone + " needs a zone and an upstream")
+		}
+		if err := f.Upstream.check(); err != nil {
+			return err
+		}
+	}
+	if _, err := c.Overrides(); err != nil {
+		return err
+	}
+	if _, err := c.Rewriter(""); err != nil {
+		return err
+	}
+	if _, err := c.TypeFilter(""); err != nil {
+		return err
+	}
+	if _, err := c.Checks(); err != nil {
+		return err
+	}
+	if _, err := c.AnswerSorter(); err != nil {
+		return err
+	}
+	if _, err := c.AccessList(); err != nil {
+		return err
+	}
+	if err := logging.Check(c.Log.Format, c.Log.Level, c.Log.Components); err != nil {
+		return err
+	}
+	for _, r := range c.Reverse {
+		if net.ParseIP(r.Address) == nil || r.Name == "" {
+			return errors.New("reverse record of " + r.Address + " needs an address and a name")
+		}
+	}
+	for _, s := range c.LocalServices() {
+		if err := s.Check(); err != nil {
+			return err
+		}
+	}
+	if c.Follow.Primary != "" && c.Follow.Primary == c.Admin.Address {
+		return errors.New("the server cannot follow itself")
+	}
+	if err := c.ACME.check(); err != nil {
+		return err
+	}
+	if err := c.checkEndpoints(); err != nil {
+		return err
+	}
+	if err := c.Alerts.check(); err != nil {
+		return err
+	}
+	if err := c.Dnstap.check(); err != nil {
+		return err
+	}
+	if err := c.Tracing.check(); err != nil {
+		return err
+	}
+	if err := c.MDNS.check(); err != nil {
+		return err
+	}
+	if err := c.Leases.check(); err != nil {
+		return err
+	}
+	if err := c.Privacy.check(); err != nil {
+		return err
+	}
+	if c.Privacy.anonymizes() && (c.Dnstap.Socket != "" || c.Dnstap.File != "") {
+		return errors.New("dnstap emits the messages of the clients as they are, it cannot be enabled with the privacy anonymizing them")
+	}
+	if err := checkChain(c.Chain); err != nil {
+		return err
+	}
+	if c.Endpoint.RateLimit.QPS < 0 || c.Endpoint.RateLimit.Burst < 0 {
+		return errors.New("the rate limit cannot be negative")
+	}
+	if c.Endpoint.Workers < 0 {
+		return errors.New("the udp workers cannot be negative")
+	}
+	if c.Endpoint.Handlers < 0 {
+		return errors.New("the udp handlers cannot be negative")
+	}
+	if c.Admin.MutationsPerMinute < 0 {
+		return errors.New("the admin mutations per minute cannot be negative")
+	}
+	if canary := c.Admin.CanaryName(); canary == "" || strings.Contains(canary, "..") || strings.ContainsAny(canary, "*:/ ") {
+		return errors.New("invalid readiness canary " + c.Admin.Canary)
+	}
+	for _, rule := range c.Chaos.Rules {
+		if err := rule.Check(); err != nil {
+			return err
+		}
+	}
+	for _, stub := range c.StubZones {
+		if stub.Zone == "" || len(stub.Servers) == 0 {
+			return errors.New("stub zone " + stub.Zone + " needs a zone and servers")
+		}
+	}
+	for _, tenant := range c.Tenants {
+		if tenant.Name == "" {
+			return errors.New("tenant without name")
+		}
+		if len(tenant.Tenants) > 0 {
+			return errors.New("tenant " + tenant.Name + " cannot have tenants")
+		}
+		tenantDoh := tenant.dohEndpoints()
+		for _, address := range tenant.listenAddresses() {
+			owner, used := addresses[address]
+			e, isDoh := tenantDoh[address]
+			if used && !(isDoh && canShareDoh(doh[address], e[0])) {
+				return errors.New("tenant " + tenant.Name + " listens on " + address + " already used by " + owner)
+			}
+			addresses[address] = tenant.Name
+			if isDoh {
+				doh[address] = append(doh[address], e...)
+			}
+		}
+	}
+	return nil
+}
+
+// BlockingResponse returns the answer to the blocked questions
+func (c ServerConf) BlockingResponse() (blocker.Response, error) {
+	res, err := blocker.ParseResponse(c.Blocking.Mode, c.Blocking.SinkholeV4, c.Blocking.SinkholeV6)
+	res.TTL = c.Blocking.TTL
+	return res, err
+}
+
+// Overrides returns the response groups overriding the upstream answers
+func (c ServerConf) Overrides() (*override.Overrides, error) {
+	res := override.NewOverrides()
+	for _, group := range c.ResponseGroups {
+		g, err := override.NewGroup(group.Name, group.Domains, group.Addresses, group.TTL)
+		if err != nil {
+			return nil, err
+		}
+		res.Add(g)
+	}
+	return res, nil
+}
+
+// Rewriter returns the resolver named name answering the rewrites, nil without rewrite
+func (c ServerConf) Rewriter(name string) (*resolver.RewriteResolver, error) {
+	if len(c.Rewrites) == 0 {
+		return nil, nil
+	}
+	rewrites := make([]resolver.Rewrite, 0, len(c.Rewrites))
+	for _, r := range c.Rewrites {
+		rewrite, err := resolver.NewRewrite(r.Domain, r.Answer, r.TTL)
+		if err != nil {
+			return nil, err
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	return resolver.NewRewriteResolver(rewrites, name)
+}
+
+// TypeFilter returns the resolver named name answering the filtered types, nil without filter
+func (c ServerConf) TypeFilter(name string) (*resolver.TypeFilterResolver, error) {
+	if len(c.TypeFilters) == 0 {
+		return nil, nil
+	}
+	answers := make(map[dto.Type]string)
+	for _, filter := range c.TypeFilters {
+		answer := filter.Answer
+		if answer == "" {
+			answer = resolver.FilterRefused
+		}
+		for _, s := range filter.Types {
+			t, err := dto.ParseType(s)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := answers[t]; ok {
+				return nil, errors.New("the type " + t.String() + " is filtered twice")
+			}
+			answers[t] = answer
+		}
+	}
+	return resolver.NewTypeFilterResolver(answers, name)
+}
+
+// Checks returns the health checks, with their defaults
+func (c ServerConf) Checks() ([]healthcheck.Check, error) {
+	res := make([]healthcheck.Check, 0, len(c.HealthChecks))
+	names := make(map[string]bool, len(c.HealthChecks))
+	for _, check := range c.HealthChecks {
+		name := strings.ToLower(strings.TrimSuffix(check.Name, "."))
+		if name == "" || names[name] {
+			return nil, errors.New("the health check " + check.Name + " needs a unique name")
+		}
+		names[name] = true
+		address := net.ParseIP("127.0.0.1")
+		if check.Address != "" {
+			if address = net.ParseIP(check.Address); address == nil {
+				return nil, errors.New("invalid address " + check.Address + " of the health check " + check.Name)
+			}
+		}
+		text := check.Text
+		if text == "" {
+			text = healthcheck.DefaultText()
+		}
+		if len(text) > 255 {
+			return nil, errors.New("the text of the health check " + check.Name + " is longer than 255 characters")
+		}
+		res = append(res, healthcheck.Check{Name: check.Name, Address: address, Text: text})
+	}
+	return res, nil
+}
+
+// ListFormat returns the format of the blocking list at url, auto when not configured
+func (c ServerConf) ListFormat(url string) blockparser.Format {
+	format, _ := blockparser.ParseFormat(c.BlockingListFormats[url])
+	return format
+}
+
+// AccessList returns the acl of the clients of the dns endpoints, nil when every client is allowed
+func (c ServerConf) AccessList() (*acl.ACL, error) {
+	denial, err := acl.ParseDenial(c.ACL.Action)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.ACL.Allowed) == 0 {
+		return nil, nil
+	}
+	allowed := make([]*net.IPNet, 0, len(c.ACL.Allowed))
+	for _, cidr := range c.ACL.Allowed {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.New("invalid acl subnet " + cidr)
+		}
+		allowed = append(allowed, subnet)
+	}
+	return acl.NewACL(allowed, denial), nil
+}
+
+// checkClientGroups checks the devices and the groups have a unique name, valid clients and valid rules
+func (c ServerConf) checkClientGroups() error {
+	devices := make(map[string]bool, len(c.Devices))
+	for _, d := range c.Devices {
+		if d.Name == "" || devices[d.Name] {
+			return errors.New("the devices need a unique name, got " + strconv.Quote(d.Name))
+		}
+		devices[d.Name] = true
+		for _, address := range d.Addresses {
+			if _, err := parseNetwork(address); err != nil {
+				return errors.New("device " + d.Name + ": " + err.Error())
+			}
+		}
+	}
+	names := make(map[string]bool, len(c.ClientGroups))
+	for _, group := range c.ClientGroups {
+		if group.Name == "" || names[group.Name] {
+			return errors.New("the client groups need a unique name, got " + strconv.Quote(group.Name))
+		}
+		names[group.Name] = true
+		if _, err := group.Networks(c.Devices); err != nil {
+			return err
+		}
+		for _, rule := range group.BlockRules {
+			if err := blocker.CheckRule(rule); err != nil {
+				return errors.New("group " + group.Name + ": " + err.Error())
+			}
+		}
+		for _, c := range group.Custom {
+			if c.Name == "" || net.ParseIP(c.Address) == nil {
+				return errors.New("group " + group.Name + ": invalid custom name " + c.Name + " with address " + c.Address)
+			}
+		}
+	}
+	return nil
+}
+
+// AnswerSorter returns the sorter of the addresses of the answers, nil when they keep the order of the upstream
+func (c ServerConf) AnswerSorter() (*sortlist.Sorter, error) {
+	mode, err := sortlist.ParseMode(c.AnswerOrder.Mode)
+	if err != nil {
+		return nil, err
+	}
+	preferred := make([]*net.IPNet, 0, len(c.AnswerOrder.Preferred))
+	for _, cidr := range c.AnswerOrder.Preferred {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.New("invalid preferred subnet " + cidr)
+		}
+		preferred = append(preferred, subnet)
+	}
+	if mode == sortlist.Keep && len(preferred) == 0 {
+		return nil, nil
+	}
+	return sortlist.NewSorter(mode, preferred), nil
+}
+
+// LocalServices returns the services published with DNS-SD
+func (c ServerConf) LocalServices() []inmemoryclient.Service {
+	res := make([]inmemoryclient.Service, 0, len(c.Services))
+	for _, s := range c.Services {
+		res = append(res, inmemoryclient.Service{Instance: s.Instance, Type: s.Type, Domain: s.Domain, Host: s.Host, Port: s.Port, TXT: s.TXT})
+	}
+	return res
+}
+
+// checkSchedules checks the schedules have rules, a valid window and apply to known client groups
+func (c ServerConf) checkSchedules() error {
+	for _, s := range c.Blocking.Schedules {
+		if len(s.BlockRules) == 0 {
+			return errors.New("schedule " + s.Name + " has no block rule")
+		}
+		for _, rule := range s.BlockRules {
+			if err := blocker.CheckRule(rule); err != nil {
+				return errors.New("schedule " + s.Name + ": " + err.Error())
+			}
+		}
+		if _, err := s.Window(); err != nil {
+			return err
+		}
+		for _, group := range s.Groups {
+			if !slices.ContainsFunc(c.ClientGroups, func(g clientGroup) bool { return g.Name == group }) {
+				return errors.New("schedule " + s.Name + " applies to the unknown client group " + group)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSearchSuffix checks the suffix is a domain, its dots at both ends are ignored
+func (c ServerConf) checkSearchSuffix() error {
+	if c.SearchSuffix == "" {
+		if c.SearchFallback {
+			return errors.New("the search fallback needs a search suffix")
+		}
+		return nil
+	}
+	suffix := strings.Trim(c.SearchSuffix, ".")
+	if suffix == "" || strings.Contains(suffix, "..") || strings.ContainsAny(suffix, "*:/ ") {
+		return errors.New("invalid search suffix " + c.SearchSuffix + ", expecting a domain like home.lan")
+	}
+	return nil
+}
+
+// checkOffline checks the features asking the upstreams are disabled without external resolution
+func (c ServerConf) checkOffline() error {
+	if _, err := offline.ParseAnswer(c.OfflineAnswer); err != nil {
+		return err
+	}
+	if c.AllowExternal {
+		return nil
+	}
+	switch {
+	case len(c.Forwarders) > 0:
+		return errors.New("the forwarders need the external resolution")
+	case len(c.StubZones) > 0:
+		return errors.New("the stub zones need the external resolution")
+	case c.Cache.Prefetch.Threshold > 0:
+		return errors.New("the prefetch needs the external resolution")
+	case c.Audit.Rate > 0:
+		return errors.New("the audit needs the external resolution")
+	}
+	return nil
+}
+
+// Following returns the configuration of a standby following primary:
+// the policy of the primary with the endpoints, the acl, the logs, the query log and its privacy, the tracing, the alerts, the client hints, the chaos mode,
+// the mdns, the leases, the hosts file, the cache snapshot and the tenants of c. The lists, the local files of the
+// primary included, are read from the primary
+func (c ServerConf) Following(primary ServerConf) ServerConf {
+	res := primary
+	res.Endpoint, res.Endpoints, res.Doh, res.Grpc, res.ACL, res.ACME = c.Endpoint, c.Endpoints, c.Doh, c.Grpc, c.ACL, c.ACME
+	res.PublicStats, res.Metrics, res.Admin, res.DrainTimeout, res.RunAs = c.PublicStats, c.Metrics, c.Admin, c.DrainTimeout, c.RunAs
+	res.QueryLog, res.Dnstap, res.Tracing, res.Alerts, res.Follow, res.Memdump, res.Tenants = c.QueryLog, c.Dnstap, c.Tracing, c.Alerts, c.Follow, c.Memdump, c.Tenants
+	res.Chaos, res.ClientHints, res.Log, res.Hosts, res.MDNS, res.Profile = c.Chaos, c.ClientHints, c.Log, c.Hosts, c.MDNS, c.Profile
+	res.BlockingListsCache, res.GitSync, res.Snapshots, res.Leases, res.Privacy = c.BlockingListsCache, c.GitSync, c.Snapshots, c.Leases, c.Privacy
+	// the snapshot of the cache is a file of the standby, the lists of the primary are read through its admin api
+	res.Cache.Snapshot, res.Cache.SnapshotInterval = c.Cache.Snapshot, c.Cache.SnapshotInterval
+	return res
+}
+
+// SameEndpoints tells if both configurations serve the same endpoints, so they can be kept running on reload
+func (c ServerConf) SameEndpoints(other ServerConf) bool {
+	return c.Endpoint == other.Endpoint && slices.Equal(c.Endpoints, other.Endpoints) && c.Doh == other.Doh && c.Grpc == other.Grpc &&
+		c.SameACME(other) && c.Resources().UDPWorkers == other.Resources().UDPWorkers &&
+		c.Resources().UDPHandlers == other.Resources().UDPHandlers &&
+		c.PublicStats == other.PublicStats && c.Metrics == other.Metrics && c.Admin == other.Admin
+}
+
+// SameACME tells if both configurations provision the same certificates, so the provisioner can be kept on reload
+func (c ServerConf) SameACME(other ServerConf) bool {
+	a, b := c.ACME, other.ACME
+	return slices.Equal(a.Domains, b.Domains) && a.Email == b.Email && a.CacheDir == b.CacheDir &&
+		a.HTTPAddress == b.HTTPAddress && a.Directory == b.Directory
+}
+
+// StatePaths returns the directories and the files the server keeps its state in, the ones of the tenants included.
+// They are given to the user of RunAs before the process switches to it, the server created them as root
+func (c ServerConf) StatePaths() []string {
+	var res []string
+	for _, path := range []string{c.Snapshots.Directory, c.BlockingListsCache, c.GitSync.Dir, c.ACME.CacheDir, c.Cache.Snapshot, c.QueryLog.Path} {
+		if path != "" {
+			res = append(res, path)
+		}
+	}
+	for _, tenant := range c.Tenants {
+		res = append(res, tenant.StatePaths()...)
+	}
+	return res
+}
+
+// MeteredGroups returns the metered settings of the metered client groups by name, with the ones of the metered section
+// when 0
+func (c ServerConf) MeteredGroups() map[string]metered {
+	res := map[string]metered{}
+	for _, group := range c.ClientGroups {
+		if !group.Metered.Enabled {
+			continue
+		}
+		m := group.Metered
+		if m.MinTTL == 0 {
+			m.MinTTL = c.Metered.MinTTL
+		}
+		if m.ServeStale == 0 {
+			m.ServeStale = c.Metered.ServeStale
+		}
+		res[group.Name] = m
+	}
+	return res
+}
+
+// SameCache tells if the cache configuration is the same in both configurations, so the cache can be kept on reload.
+// The prefetch is excluded as it only runs against the cache
+func (c ServerConf) SameCache(other ServerConf) bool {
+	a, b := c.Cache, other.Cache
+	a.Prefetch, b.Prefetch = prefetch{}, prefetch{}
+	return a == b && c.Metered == other.Metered && maps.Equal(c.MeteredGroups(), other.MeteredGroups()) &&
+		c.Noise.equal(other.Noise) && c.Resources().CacheSize == other.Resources().CacheSize &&
+		(c.OfflineAnswer == offline.Stale.String()) == (other.OfflineAnswer == offline.Stale.String())
+}
+
+// SameSources tells if the answers cached from both configurations come from the same sources: the external source,
+// the upstreams, the forwarders, the stub zones and the response groups. The entries of a kept cache are flushed
+// when they differ
+func (c ServerConf) SameSources(other ServerConf) bool {
+	return reflect.DeepEqual(c.External, other.External) && reflect.DeepEqual(c.Upstreams, other.Upstreams) &&
+		reflect.DeepEqual(c.Forwarders, other.Forwarders) && reflect.DeepEqual(c.StubZones, other.StubZones) &&
+		reflect.DeepEqual(c.ResponseGroups, other.ResponseGroups)
+}
+
+// Load reads the configuration file at path, in the format of its extension, overrides its settings by the variables
+// of the environment prefixed by EnvPrefix, then validates it
+func Load(path string) (ServerConf, error) {
+	var conf ServerConf
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+	format := FormatOf(path)
+	if data, err = toJSON(format, data); err != nil {
+		return conf, errors.New("cannot decode " + path + ": " + err.Error())
+	}
+	if err := decode(data, &conf, format == JSON); err != nil {
+		return conf, errors.New("cannot decode " + path + ": " + err.Error())
+	}
+	if err := applyEnv(&conf, os.Environ()); err != nil {
+		return conf, errors.New("invalid environment: " + err.Error())
+	}
+	if err := conf.Validate(); err != nil {
+		return conf, errors.New("invalid configuration: " + err.Error())
+	}
+	return conf, nil
+}
+
+// ListenAddresses returns the addresses of all the enabled endpoints of the server and of its tenants
+func (c ServerConf) ListenAddresses() []string {
+	res := c.listenAddresses()
+	for _, tenant := range c.Tenants {
+		res = append(res, tenant.listenAddresses()...)
+	}
+	return res
+}
+
+// listenAddresses returns the addresses of all the enabled endpoints
+func (c ServerConf) listenAddresses() []string {
+	res := make([]string, 0, 6+len(c.Endpoints))
+	for _, address := range []string{c.Endpoint.Address, c.Doh.Address, c.Grpc.Address, c.PublicStats.Address, c.Metrics.Address, c.Admin.Address, c.ACME.HTTPAddress} {
+		if address != "" {
+			res = append(res, address)
+		}
+	}
+	for _, e := range c.Endpoints {
+		res = append(res, e.Address)
+	}
+	return res
+}
+
+// dohEndpoints returns the doh endpoints of the configuration by address, with their path
+func (c ServerConf) dohEndpoints() map[string][]listenEndpoint {
+	res := make(map[string][]listenEndpoint)
+	all := c.Endpoints
+	if c.Doh.Address != "" {
+		all = append([]listenEndpoint{{Protocol: "doh", Address: c.Doh.Address, Path: c.Doh.Path, Cert: c.Doh.Cert, Key: c.Doh.Key, ACME: c.Doh.ACME}}, all...)
+	}
+	for _, e := range all {
+		if e.Protocol != "doh" {
+			continue
+		}
+		if e.Path == "" {
+			e.Path = dohendpoint.DefaultPath
+		}
+		res[e.Address] = append(res[e.Address], e)
+	}
+	return res
+}
+
+// canShareDoh tells whether the doh endpoint of a tenant can serve its path on the address of the others, the
+// server listening first presents its certificate for every path so they must have the same files, acme excluded
+func canShareDoh(others []listenEndpoint, e listenEndpoint) bool {
+	if len(others) == 0 {
+		return false
+	}
+	for _, other := range others {
+		if other.Path == e.Path || other.Cert != e.Cert || other.Key != e.Key || other.ACME || e.ACME {
+			return false
+		}
+	}
+	return true
+}
+
+// checkEndpoints checks the endpoints of the list, two of them cannot listen on the same address with the same network,
+// nor with the endpoint and the doh endpoint of the configuration
+func (c ServerConf) checkEndpoints() error {
+	listening := make(map[[2]string]bool)
+	if c.Endpoint.Address != "" {
+		listening[[2]string{"udp", c.Endpoint.Address}] = true
+		if c.Endpoint.TCP {
+			listening[[2]string{"tcp", c.Endpoint.Address}] = true
+		}
+	}
+	if c.Doh.Address != "" {
+		listening[[2]string{"tcp", c.Doh.Address}] = true
+	}
+	if c.ACME.HTTPAddress != "" {
+		listening[[2]string{"tcp", c.ACME.HTTPAddress}] = true
+	}
+	if c.Doh.ACME && (c.Doh.Cert != "" || !c.ACME.enabled()) {
+		return errors.New("the doh endpoint needs the acme domains and no certificate to use acme")
+	}
+	for _, e := range c.Endpoints {
+		if err := e.check(); err != nil {
+			return err
+		}
+		if e.ACME && !c.ACME.enabled() {
+			return errors.New("the " + e.Protocol + " endpoint " + e.Address + " needs the acme domains")
+		}
+		key := [2]string{e.network(), e.Address}
+		if listening[key] {
+			return errors.New("the " + e.Protocol + " endpoint listens on " + e.Address + " already used by another endpoint")
+		}
+		listening[key] = true
+	}
+	return nil
 }
 
 // Default generate the default configuration
@@ -39,22 +1552,43 @@ func Default() ServerConf {
 		BlockingLists: []string{
 			"https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts",
 		},
+		BlockingListsRefresh: 86400,
 		Custom: []custom{
 			{"cloudflare-dns.com", "104.16.249.249"},
 			{"cloudflare-dns.com", "2606:4700::6810:f8f"},
 		},
+		Profile: defaultProfile,
 		Cache: cache{
-			Size:         1000000,
 			Basettl:      600,
 			ForceBasettl: true,
+			AutoTune: autoTune{
+				Enabled: false,
+				MinSize: 100000,
+				MaxSize: 10000000,
+			},
+		},
+		NXDomainCache: nxdomainCache{
+			TTL: 60,
+		},
+		Metered: metered{
+			Enabled:    false,
+			MinTTL:     86400,
+			ServeStale: 604800,
 		},
-		External: externalSource{
+		Noise: noise{
+			TTL: 21600,
+		},
+		External: ExternalSource{
 			Type:     "DOH",
 			Endpoint: "https://cloudflare-dns.com/dns-query",
 		},
 		Endpoint: udpEndpoint{
 			Enabled: true,
 			Address: "127.0.0.1:53",
+			TCP:     true,
+		},
+		Admin: adminEndpoint{
+			Address: "127.0.0.1:5380",
 		},
 	}
 }
@@ -0,0 +1,1300 @@
+package configuration
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/chaos"
+	blockparser "github.com/bluguard/dnshield/internal/dns/util/blockParser"
+)
+
+func TestServerConf_Validate(t *testing.T) {
+	tenant := func(name, address string) Tenant {
+		conf := Default()
+		conf.Endpoint.Address = address
+		conf.Admin.Address = ""
+		return Tenant{Name: name, ServerConf: conf}
+	}
+	dohTenant := func(name, port, path, cert string) Tenant {
+		res := tenant(name, "127.0.0.1:"+port)
+		res.Doh = dohEndpoint{Address: "0.0.0.0:8443", Path: path, Cert: cert}
+		if cert != "" {
+			res.Doh.Key = cert + ".key"
+		}
+		return res
+	}
+
+	tests := []struct {
+		name    string
+		tenants []Tenant
+		wantErr bool
+	}{
+		{
+			name:    "no tenant",
+			wantErr: false,
+		},
+		{
+			name:    "distinct addresses",
+			tenants: []Tenant{tenant("a", "127.0.0.1:1053"), tenant("b", "127.0.0.1:2053")},
+			wantErr: false,
+		},
+		{
+			name:    "address used by main",
+			tenants: []Tenant{tenant("a", Default().Endpoint.Address)},
+			wantErr: true,
+		},
+		{
+			name:    "address used by another tenant",
+			tenants: []Tenant{tenant("a", "127.0.0.1:1053"), tenant("b", "127.0.0.1:1053")},
+			wantErr: true,
+		},
+		{
+			name:    "doh address shared with their own path",
+			tenants: []Tenant{dohTenant("a", "1053", "/a", "cert.pem"), dohTenant("b", "2053", "/b", "cert.pem")},
+			wantErr: false,
+		},
+		{
+			name:    "doh address shared with the same path",
+			tenants: []Tenant{dohTenant("a", "1053", "", ""), dohTenant("b", "2053", "/dns-query", "")},
+			wantErr: true,
+		},
+		{
+			name:    "doh address shared with another certificate",
+			tenants: []Tenant{dohTenant("a", "1053", "/a", "a.pem"), dohTenant("b", "2053", "/b", "b.pem")},
+			wantErr: true,
+		},
+		{
+			name:    "tenant without name",
+			tenants: []Tenant{tenant("", "127.0.0.1:1053")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Tenants = tt.tenants
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ServerConf.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf")
+	conf := Default()
+	conf.Tenants = []Tenant{{Name: "a", ServerConf: Default()}}
+	data, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Errorf("expecting an error loading a tenant listening on the addresses of the main server")
+	}
+
+	conf.Tenants = nil
+	conf.Custom = append(conf.Custom, custom{"printer.lan", "192.168.1.20"})
+	data, _ = json.Marshal(conf)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, conf) {
+		t.Errorf("expecting %v, got %v", conf, loaded)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("expecting a not exist error, got %v", err)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unknown key", "{\n  \"allow_external\": true,\n  \"blocking_lists\": []\n}", `line 3, column 3: json: unknown field "blocking_lists"`},
+		{"unknown nested key", "{\n  \"custom\": [{\"name\": \"ttl\", \"address\": \"192.168.1.2\"}],\n  \"cache\": {\"size\": 10, \"ttl\": 60}\n}", `line 3, column 25: json: unknown field "ttl"`},
+		{"known key before the unknown one", "{\n  \"cache\": {\"basettl\": 60},\n  \"blocking\": {\"basettl\": 60}\n}", `line 3, column 16: json: unknown field "basettl"`},
+		{"type", "{\n  \"cache\": {\"basettl\": \"600\"}\n}", "line 2, column 28: the value of cache.basettl is a string, expecting a uint32"},
+		{"syntax", "{\n  \"allow_external\": true,\n}", "line 3, column 1: invalid character '}' looking for beginning of object key string"},
+		{"ttl", `{"blocking": {"ttl": 4294967295}}`, "invalid configuration: blocking.ttl is 4294967295, a ttl cannot exceed 2147483647 seconds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "conf")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_Deprecated(t *testing.T) {
+	deprecatedKeys["cache.max_size"] = "cache.size"
+	defer delete(deprecatedKeys, "cache.max_size")
+	path := filepath.Join(t.TempDir(), "conf")
+	if err := os.WriteFile(path, []byte(`{"cache": {"max_size": 10, "size": 20}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := Load(path)
+	if err != nil || conf.Cache.Size != 20 {
+		t.Errorf("expecting the deprecated key to be ignored, got %v, %v", conf.Cache, err)
+	}
+	if err := os.WriteFile(path, []byte(`{"max_size": 10}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Errorf("expecting an error for a deprecated key out of its object")
+	}
+}
+
+func TestLoad_Formats(t *testing.T) {
+	conf := Default()
+	conf.Custom = append(conf.Custom, custom{"printer.lan", "192.168.1.20"})
+	conf.Audit.Rate = 0.25
+	conf.Cache.AutoTune.MaxSize = 10000000
+	for _, format := range []Format{JSON, YAML, TOML} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := Marshal(conf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "dnshield."+string(format))
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() error = %v of\n%s", err, data)
+			}
+			if !reflect.DeepEqual(loaded, conf) {
+				t.Errorf("expecting %v, got %v", conf, loaded)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "dnshield.yml")
+	if err := os.WriteFile(path, []byte("allow_external: true\ncache:\n  base_ttl: 600\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), `unknown field "base_ttl"`) {
+		t.Errorf("expecting an error for the unknown key of the yaml file, got %v", err)
+	}
+}
+
+func TestLoad_Env(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf")
+	data, _ := json.Marshal(Default())
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DNSHIELD_EXTERNAL_ENDPOINT", "https://dns.google/dns-query")
+	t.Setenv("DNSHIELD_BLOCKING_LIST", "https://a.org/hosts, https://b.org/hosts")
+	t.Setenv("DNSHIELD_CACHE_BASETTL", "300")
+	t.Setenv("DNSHIELD_ENDPOINT_ENABLED", "false")
+	t.Setenv("DNSHIELD_UPSTREAMS_SOURCES", `[{"type": "UDP", "endpoint": "1.1.1.1:53"}]`)
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.External.Endpoint != "https://dns.google/dns-query" || conf.Cache.Basettl != 300 || conf.Endpoint.Enabled {
+		t.Errorf("expecting the settings of the environment, got %+v", conf)
+	}
+	if !reflect.DeepEqual(conf.BlockingLists, []string{"https://a.org/hosts", "https://b.org/hosts"}) {
+		t.Errorf("unexpected blocking lists %v", conf.BlockingLists)
+	}
+	if !reflect.DeepEqual(conf.Upstreams.Sources, []ExternalSource{{Type: "UDP", Endpoint: "1.1.1.1:53"}}) {
+		t.Errorf("unexpected upstreams %v", conf.Upstreams.Sources)
+	}
+
+	t.Setenv("DNSHIELD_CACHE_BASETTL", "ten")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "DNSHIELD_CACHE_BASETTL") {
+		t.Errorf("expecting an error for the invalid value, got %v", err)
+	}
+	t.Setenv("DNSHIELD_CACHE_BASETTL", "300")
+	t.Setenv("DNSHIELD_PORT", "tcp://10.0.0.1:53")
+	if _, err := Load(path); err != nil {
+		t.Errorf("expecting the variables which are not a setting to be skipped, got %v", err)
+	}
+}
+
+func TestServerConf_ValidateAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *ServerConf)
+		wantErr bool
+	}{
+		{"default", func(c *ServerConf) {}, false},
+		{"admin without port", func(c *ServerConf) { c.Admin.Address = "127.0.0.1" }, true},
+		{"port out of range", func(c *ServerConf) { c.Endpoint.Address = "127.0.0.1:65536" }, true},
+		{"ipv6 endpoint", func(c *ServerConf) { c.Endpoint.Address = "[::]:53" }, false},
+		{"ipv6 endpoint without brackets", func(c *ServerConf) { c.Endpoint.Address = "::1:53" }, true},
+		{"udp upstream without port", func(c *ServerConf) { c.External = ExternalSource{Type: "UDP", Endpoint: "1.1.1.1"} }, true},
+		{"doh upstream without scheme", func(c *ServerConf) { c.External.Endpoint = "cloudflare-dns.com/dns-query" }, true},
+		{"preset", func(c *ServerConf) { c.External = ExternalSource{Type: "quad9"} }, false},
+		{"forwarder", func(c *ServerConf) {
+			c.Forwarders = []forwarder{{Zone: "corp.lan", Upstream: ExternalSource{Type: "DOT", Endpoint: "10.0.0.53"}}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			tt.change(&conf)
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_SameEndpoints(t *testing.T) {
+	conf := Default()
+	other := Default()
+	other.BlockingLists = nil
+	other.External.Type = "UDP"
+	if !conf.SameEndpoints(other) {
+		t.Errorf("the endpoints do not depend on the chain")
+	}
+	other.Admin.Address = "127.0.0.1:6380"
+	if conf.SameEndpoints(other) {
+		t.Errorf("the admin endpoint has changed")
+	}
+	other = Default()
+	other.Endpoints = []listenEndpoint{{Protocol: "doh", Address: "127.0.0.1:8053"}}
+	if conf.SameEndpoints(other) {
+		t.Errorf("an endpoint has been listed")
+	}
+}
+
+func TestServerConf_SameCache(t *testing.T) {
+	conf := Default()
+	other := Default()
+	other.BlockingLists = nil
+	other.External.Type = "UDP"
+	other.Cache.Prefetch.Threshold = 5
+	if !conf.SameCache(other) {
+		t.Errorf("the cache does not depend on the chain nor on the prefetch")
+	}
+	other.Cache.Basettl++
+	if conf.SameCache(other) {
+		t.Errorf("the base ttl of the cache has changed")
+	}
+	other = Default()
+	other.Metered.Enabled = true
+	if conf.SameCache(other) {
+		t.Errorf("the metered mode changes the cache")
+	}
+	conf.ClientGroups = []clientGroup{{Name: "iot", Metered: metered{Enabled: true}}}
+	other = Default()
+	other.ClientGroups = []clientGroup{{Name: "iot", Metered: metered{Enabled: true, MinTTL: conf.Metered.MinTTL}}}
+	if !conf.SameCache(other) {
+		t.Errorf("the metered groups take the min ttl of the metered section")
+	}
+	other.ClientGroups[0].Metered.ServeStale = 60
+	if conf.SameCache(other) {
+		t.Errorf("the stale window of a metered group changes the cache")
+	}
+}
+
+func TestServerConf_MeteredGroups(t *testing.T) {
+	conf := Default()
+	conf.ClientGroups = []clientGroup{
+		{Name: "iot", Metered: metered{Enabled: true, ServeStale: 60}},
+		{Name: "kids", Metered: metered{MinTTL: 600}},
+	}
+	want := map[string]metered{"iot": {Enabled: true, MinTTL: conf.Metered.MinTTL, ServeStale: 60}}
+	if got := conf.MeteredGroups(); !reflect.DeepEqual(got, want) {
+		t.Errorf("MeteredGroups() = %v, want %v", got, want)
+	}
+}
+
+func TestServerConf_SameSources(t *testing.T) {
+	conf := Default()
+	other := Default()
+	other.BlockingLists = nil
+	if !conf.SameSources(other) {
+		t.Errorf("the sources do not depend on the blocking lists")
+	}
+	changes := map[string]func(*ServerConf){
+		"external":        func(c *ServerConf) { c.External.Endpoint = "9.9.9.9:53" },
+		"upstreams":       func(c *ServerConf) { c.Upstreams.Sources = []ExternalSource{{Type: "quad9"}} },
+		"forwarders":      func(c *ServerConf) { c.Forwarders = []forwarder{{Zone: "corp.lan"}} },
+		"stub zones":      func(c *ServerConf) { c.StubZones = []stubZone{{Zone: "corp.lan"}} },
+		"response groups": func(c *ServerConf) { c.ResponseGroups = []responseGroup{{Name: "tv"}} },
+	}
+	for name, change := range changes {
+		other := Default()
+		change(&other)
+		if conf.SameSources(other) {
+			t.Errorf("expecting the change of the %s to change the sources", name)
+		}
+	}
+}
+
+func TestServerConf_ValidateEDNS(t *testing.T) {
+	for _, handling := range []string{"", "strip", "pass"} {
+		conf := Default()
+		conf.EDNS.UnknownOptions = handling
+		if err := conf.Validate(); err != nil {
+			t.Errorf("unexpected error for %q: %v", handling, err)
+		}
+	}
+	conf := Default()
+	conf.EDNS.UnknownOptions = "forward"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown handling")
+	}
+}
+
+func TestServerConf_ValidateBlockRules(t *testing.T) {
+	conf := Default()
+	conf.BlockRules = []string{"ads.com", "*.doubleclick.net", `/^ad[0-9]+\./`}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, rule := range []string{"*", "/(/"} {
+		conf.BlockRules = []string{rule}
+		if err := conf.Validate(); err == nil {
+			t.Errorf("expecting an error for the rule %q", rule)
+		}
+	}
+}
+
+func TestServerConf_ValidateServices(t *testing.T) {
+	conf := Default()
+	conf.Services = []service{{Instance: "Office Printer", Type: "_ipp._tcp", Domain: "home.lan", Host: "printer.home.lan", Port: 631}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Services[0].Type = "_ipp"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a type without protocol")
+	}
+}
+
+func TestServerConf_ValidateResponseGroups(t *testing.T) {
+	conf := Default()
+	conf.ResponseGroups = []responseGroup{{Name: "lan", Domains: []string{"nas.example.com"}, Addresses: []string{"192.168.1.10"}}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.ResponseGroups[0].Addresses = []string{"nas"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an invalid address")
+	}
+}
+
+func TestServerConf_ValidateTypeFilters(t *testing.T) {
+	conf := Default()
+	conf.TypeFilters = []typeFilter{{Types: []string{"ANY"}}, {Types: []string{"https", "TYPE64"}, Answer: "empty"}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, filters := range [][]typeFilter{
+		{{Types: []string{"AXFR2"}}},
+		{{Types: []string{"ANY"}, Answer: "drop"}},
+		{{Types: []string{"ANY"}}, {Types: []string{"255"}, Answer: "empty"}},
+	} {
+		conf.TypeFilters = filters
+		if err := conf.Validate(); err == nil {
+			t.Errorf("expecting an error for %v", filters)
+		}
+	}
+}
+
+func TestServerConf_ValidateUpstreams(t *testing.T) {
+	conf := Default()
+	conf.Upstreams = upstreams{Strategy: "race", Sources: []ExternalSource{{Type: "UDP", Endpoint: "1.1.1.1:53"}, {Type: "DOQ", Endpoint: "94.140.14.14:853", ServerName: "dns.adguard-dns.com"}}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Upstreams.Strategy = "random"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown strategy")
+	}
+
+	retries, tooMany, none := uint32(2), uint32(10), uint32(0)
+	conf.Upstreams = upstreams{Sources: []ExternalSource{{Type: "UDP", Endpoint: "1.1.1.1:53", Timeout: 500, Retries: &retries}, {Type: "DOH", Endpoint: "https://dns.google/resolve", Timeout: 1500}}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := conf.Upstreams.Sources[0].RetryCount(1); got != 2 {
+		t.Errorf("expecting 2 retries, got %d", got)
+	}
+	if got := conf.Upstreams.Sources[1].RetryCount(1); got != 1 {
+		t.Errorf("expecting the default retries without retries, got %d", got)
+	}
+	conf.Upstreams.Sources[0].Retries = &none
+	if err := conf.Validate(); err != nil || conf.Upstreams.Sources[0].RetryCount(1) != 0 {
+		t.Errorf("expecting an upstream without retry, got %d, %v", conf.Upstreams.Sources[0].RetryCount(1), err)
+	}
+	conf.Upstreams.Sources[0].Retries = &tooMany
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for too many retries")
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "DOT", Endpoint: "1.1.1.1:853", Timeout: 500}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a timeout of a DOT upstream")
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "DOH", Endpoint: "https://dns.google/resolve", Connections: 8, IdleTimeout: 300, Prewarm: true}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "UDP", Endpoint: "1.1.1.1:53", Prewarm: true}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for the connections of an UDP upstream")
+	}
+
+	conf.Upstreams.Sources = []ExternalSource{{Type: "quad9", DNSSEC: "trust"}, {Type: "RECURSIVE", DNSSEC: "validate"}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if sources := conf.UpstreamSources(); sources[0].DNSSEC != "trust" || sources[1].DNSSEC != "trust" {
+		t.Errorf("expecting the DNSSEC policy of the preset to apply to its upstreams, got %v", sources)
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "RECURSIVE", DNSSEC: "trust"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a trusted recursion")
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "UDP", Endpoint: "1.1.1.1:53", DNSSEC: "trust"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an upstream trusted over plain UDP")
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "UDP", Endpoint: "1.1.1.1:53", DNSSEC: "strict"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown DNSSEC policy")
+	}
+
+	conf.Upstreams.Sources = []ExternalSource{{Type: "DOH", Endpoint: "https://dns.google/dns-query", Family: "prefer-ipv6"}, {Type: "UDP", Endpoint: "[2620:fe::fe]:53", Family: "ipv6"}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "UDP", Endpoint: "1.1.1.1:53", Family: "inet6"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown address family")
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "RECURSIVE", Family: "ipv4"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for the address family of the recursion")
+	}
+	for _, source := range []ExternalSource{
+		{Type: "UDP", Endpoint: "1.1.1.1:53", Family: "ipv6"},
+		{Type: "DOT", Endpoint: "[2620:fe::fe]:853", Family: "ipv4"},
+		{Type: "DOH", Endpoint: "https://[2620:fe::fe]/dns-query", Family: "ipv4"},
+	} {
+		conf.Upstreams.Sources = []ExternalSource{source}
+		if err := conf.Validate(); err == nil {
+			t.Errorf("expecting an error for the upstream %s reached over %s only", source.Endpoint, source.Family)
+		}
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "UDP", Endpoint: "1.1.1.1:53", Family: "prefer-ipv6"}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error for an IPv4 upstream preferring IPv6: %v", err)
+	}
+}
+
+func TestServerConf_UpstreamSources(t *testing.T) {
+	conf := Default()
+	if sources := conf.UpstreamSources(); len(sources) != 1 || sources[0] != conf.External {
+		t.Errorf("UpstreamSources() = %v, want the external source", sources)
+	}
+	conf.External = ExternalSource{Type: "Quad9"}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []ExternalSource{
+		{Type: "DOT", Endpoint: "9.9.9.9:853", ServerName: "dns.quad9.net"},
+		{Type: "DOT", Endpoint: "149.112.112.112:853", ServerName: "dns.quad9.net"},
+	}
+	if sources := conf.UpstreamSources(); !reflect.DeepEqual(sources, want) {
+		t.Errorf("UpstreamSources() = %v, want %v", sources, want)
+	}
+	conf.External.Family = "prefer-ipv6"
+	if sources := conf.UpstreamSources(); len(sources) != 4 || sources[0].Endpoint != "[2620:fe::fe]:853" || sources[3].Endpoint != "149.112.112.112:853" {
+		t.Errorf("UpstreamSources() = %v, want the IPv6 addresses of the preset first", sources)
+	}
+	conf.External.Family = "ipv6"
+	if sources := conf.UpstreamSources(); len(sources) != 2 || sources[1].Endpoint != "[2620:fe::9]:853" {
+		t.Errorf("UpstreamSources() = %v, want the IPv6 addresses of the preset", sources)
+	}
+	conf.Upstreams.Sources = []ExternalSource{{Type: "mullvad"}, {Type: "UDP", Endpoint: "192.168.1.1:53"}}
+	if sources := conf.UpstreamSources(); len(sources) != 2 || sources[0].Endpoint != "194.242.2.2:853" {
+		t.Errorf("UpstreamSources() = %v, want mullvad then the local resolver", sources)
+	}
+	conf.External = ExternalSource{Type: "opendns"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown preset")
+	}
+}
+
+func TestServerConf_ValidateForwarders(t *testing.T) {
+	conf := Default()
+	conf.Forwarders = []forwarder{
+		{Zone: "corp.internal", Upstream: ExternalSource{Type: "UDP", Endpoint: "10.0.0.53:53"}},
+		{Zone: "example.org", Upstream: ExternalSource{Type: "quad9"}},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Forwarders = []forwarder{{Zone: "corp.internal", Upstream: ExternalSource{Type: "UDP"}}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a forwarder without endpoint")
+	}
+}
+
+func TestServerConf_ValidateChaos(t *testing.T) {
+	conf := Default()
+	conf.Chaos = chaosMode{Enabled: true, Rules: []chaos.Rule{
+		{Pattern: "*", Upstream: "9.9.9.9:853", Loss: 1},
+		{Pattern: "*.example.com", Latency: 500, ServFail: 0.2},
+	}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Chaos.Rules = []chaos.Rule{{Pattern: "example.com", ServFail: 2}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a probability above 1")
+	}
+}
+
+func TestServerConf_ValidateRateLimit(t *testing.T) {
+	conf := Default()
+	conf.Endpoint.RateLimit = rateLimit{QPS: 20, Burst: 40, Slip: -1}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Endpoint.RateLimit = rateLimit{QPS: -1}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a negative rate")
+	}
+}
+
+func TestServerConf_ValidateEndpoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints []listenEndpoint
+		wantErr   bool
+	}{
+		{"udp, tcp and doh", []listenEndpoint{
+			{Protocol: "udp", Address: "0.0.0.0:53"},
+			{Protocol: "tcp", Address: "0.0.0.0:53"},
+			{Protocol: "doh", Address: "127.0.0.1:8053", Path: "/q"},
+		}, false},
+		{"tls doh", []listenEndpoint{{Protocol: "doh", Address: ":443", Cert: "cert.pem", Key: "key.pem"}}, false},
+		{"dot", []listenEndpoint{{Protocol: "dot", Address: ":853", Cert: "cert.pem", Key: "key.pem", Reload: true}}, false},
+		{"dot without certificate", []listenEndpoint{{Protocol: "dot", Address: ":853"}}, true},
+		{"reload of doh", []listenEndpoint{{Protocol: "doh", Address: ":443", Cert: "cert.pem", Key: "key.pem", Reload: true}}, true},
+		{"dot with the address of tcp", []listenEndpoint{{Protocol: "tcp", Address: ":853"}, {Protocol: "dot", Address: ":853", Cert: "cert.pem", Key: "key.pem"}}, true},
+		{"unknown protocol", []listenEndpoint{{Protocol: "quic", Address: ":853"}}, true},
+		{"missing address", []listenEndpoint{{Protocol: "udp"}}, true},
+		{"invalid address", []listenEndpoint{{Protocol: "udp", Address: "localhost"}}, true},
+		{"certificate without key", []listenEndpoint{{Protocol: "doh", Address: ":443", Cert: "cert.pem"}}, true},
+		{"certificate of udp", []listenEndpoint{{Protocol: "udp", Address: ":53", Cert: "cert.pem", Key: "key.pem"}}, true},
+		{"same address", []listenEndpoint{{Protocol: "tcp", Address: ":53"}, {Protocol: "doh", Address: ":53"}}, true},
+		{"address of the endpoint", []listenEndpoint{{Protocol: "udp", Address: "127.0.0.1:53"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Endpoint.Address = "127.0.0.1:53"
+			conf.Endpoints = tt.endpoints
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateACME(t *testing.T) {
+	provisioned := acme{Domains: []string{"dns.example.org"}, Email: "admin@example.org", CacheDir: "/var/lib/dnshield/acme", HTTPAddress: ":80"}
+	tests := []struct {
+		name      string
+		acme      acme
+		doh       dohEndpoint
+		endpoints []listenEndpoint
+		wantErr   bool
+	}{
+		{"doh and dot", provisioned, dohEndpoint{Address: ":443", ACME: true}, []listenEndpoint{{Protocol: "dot", Address: ":853", ACME: true}}, false},
+		{"without domains", acme{}, dohEndpoint{}, []listenEndpoint{{Protocol: "dot", Address: ":853", ACME: true}}, true},
+		{"doh without domains", acme{}, dohEndpoint{Address: ":443", ACME: true}, nil, true},
+		{"without cache", acme{Domains: []string{"dns.example.org"}}, dohEndpoint{}, nil, true},
+		{"invalid domain", acme{Domains: []string{"https://dns.example.org"}, CacheDir: "acme"}, dohEndpoint{}, nil, true},
+		{"plain directory", acme{Domains: []string{"dns.example.org"}, CacheDir: "acme", Directory: "http://ca.lan/directory"}, dohEndpoint{}, nil, true},
+		{"certificate and acme", provisioned, dohEndpoint{}, []listenEndpoint{{Protocol: "dot", Address: ":853", ACME: true, Cert: "cert.pem", Key: "key.pem"}}, true},
+		{"acme of udp", provisioned, dohEndpoint{}, []listenEndpoint{{Protocol: "udp", Address: ":5353", ACME: true}}, true},
+		{"challenges on a dns endpoint", provisioned, dohEndpoint{}, []listenEndpoint{{Protocol: "tcp", Address: ":80"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.ACME, conf.Doh, conf.Endpoints = tt.acme, tt.doh, tt.endpoints
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateAlerts(t *testing.T) {
+	conf := Default()
+	conf.Alerts = alerts{ServFailPerMinute: 50, Webhook: "https://alerts.lan/dnshield"}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Alerts = alerts{ServFailPerMinute: -1}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a negative threshold")
+	}
+	conf.Alerts = alerts{ServFailPerMinute: 50, Webhook: "alerts.lan"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a webhook without scheme")
+	}
+}
+
+func TestServerConf_ValidateAnswerOrder(t *testing.T) {
+	conf := Default()
+	conf.AnswerOrder = answerOrder{Mode: "rfc6724", Preferred: []string{"192.168.1.0/24", "fd00::/8"}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.AnswerOrder = answerOrder{Preferred: []string{"192.168.1.1"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a subnet without prefix length")
+	}
+	conf.AnswerOrder = answerOrder{Mode: "random"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown mode")
+	}
+}
+
+func TestServerConf_ValidateACL(t *testing.T) {
+	conf := Default()
+	conf.ACL = accessControl{Allowed: []string{"192.168.1.0/24", "fd00::/8"}, Action: "drop"}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.ACL = accessControl{Allowed: []string{"192.168.1.0/24"}, Action: "ignore"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown action")
+	}
+	conf.ACL = accessControl{Allowed: []string{"lan"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an invalid subnet")
+	}
+}
+
+func TestServerConf_ValidateLog(t *testing.T) {
+	conf := Default()
+	conf.Log = logConf{Level: "warn", Format: "json", Components: map[string]string{"memorycache": "error"}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Log.Components["resolver"] = "verbose"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown level")
+	}
+}
+
+func TestServerConf_ValidateOffline(t *testing.T) {
+	conf := Default()
+	conf.AllowExternal = false
+	conf.OfflineAnswer = "nxdomain"
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.OfflineAnswer = "drop"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown answer")
+	}
+	conf.OfflineAnswer = ""
+	conf.Forwarders = []forwarder{{Zone: "corp.lan", Upstream: ExternalSource{Endpoint: "10.0.0.53:53", Type: "UDP"}}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for forwarders without external resolution")
+	}
+}
+
+func TestServerConf_ValidateSearchSuffix(t *testing.T) {
+	tests := []struct {
+		suffix  string
+		wantErr bool
+	}{
+		{"home.lan", false},
+		{".home.lan.", false},
+		{"lan", false},
+		{".", true},
+		{"home..lan", true},
+		{"*.lan", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.suffix, func(t *testing.T) {
+			conf := Default()
+			conf.SearchSuffix = tt.suffix
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ServerConf.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	conf := Default()
+	conf.SearchFallback = true
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a search fallback without suffix")
+	}
+}
+
+func TestServerConf_ValidateReverse(t *testing.T) {
+	conf := Default()
+	conf.Reverse = []reverse{{Address: "192.168.1.1", Name: "router.lan"}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Reverse = []reverse{{Address: "router", Name: "router.lan"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an invalid address")
+	}
+}
+
+func TestServerConf_ValidateBlocking(t *testing.T) {
+	conf := Default()
+	conf.Blocking = blocking{Mode: "sinkhole", SinkholeV4: "10.0.0.80"}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Blocking = blocking{Mode: "sinkhole"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a sinkhole without address")
+	}
+}
+
+func TestServerConf_ValidateListFormats(t *testing.T) {
+	conf := Default()
+	conf.BlockingListFormats = map[string]string{"https://big.oisd.nl": "adblock"}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if format := conf.ListFormat("https://big.oisd.nl"); format != blockparser.Adblock {
+		t.Errorf("expecting the adblock format, got %q", format)
+	}
+	if format := conf.ListFormat(conf.BlockingLists[0]); format != blockparser.Auto {
+		t.Errorf("expecting the format of an unlisted list to be detected, got %q", format)
+	}
+	conf.BlockingListFormats["https://big.oisd.nl"] = "rpz"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown format")
+	}
+}
+
+func TestServerConf_Following(t *testing.T) {
+	primary := Default()
+	primary.BlockingLists = []string{"https://lists.lan/ads"}
+	primary.Blocking.Mode = "nxdomain"
+	primary.Admin.Address = "192.168.1.2:5380"
+	primary.Cache.Snapshot, primary.Hosts.Path = "/var/lib/dnshield/primary.cache", "/etc/dnshield/primary.hosts"
+
+	local := Default()
+	local.Endpoint.Address = "192.168.1.3:53"
+	local.Follow = follow{Primary: "192.168.1.2:5380", Interval: 60}
+	local.QueryLog.Path = "/var/log/dnshield.log"
+	local.Snapshots.Directory = "/var/lib/dnshield/snapshots"
+	local.DrainTimeout = 10
+	local.RunAs.User = "dnshield"
+	local.Cache.Snapshot = "/var/lib/dnshield/standby.cache"
+
+	got := local.Following(primary)
+	if !reflect.DeepEqual(got.BlockingLists, primary.BlockingLists) || !reflect.DeepEqual(got.Blocking, primary.Blocking) {
+		t.Errorf("the policy of the primary should be applied, got %v", got)
+	}
+	if !got.SameEndpoints(local) || got.Follow != local.Follow || got.QueryLog != local.QueryLog || got.Snapshots != local.Snapshots || got.DrainTimeout != local.DrainTimeout || got.RunAs != local.RunAs {
+		t.Errorf("the endpoints and the logs should stay local, got %v", got)
+	}
+	if got.Cache.Snapshot != local.Cache.Snapshot || got.Hosts != local.Hosts {
+		t.Errorf("the files of the primary should not be used, got %q and %q", got.Cache.Snapshot, got.Hosts.Path)
+	}
+	if err := got.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	local.Follow.Primary = local.Admin.Address
+	if err := local.Validate(); err == nil {
+		t.Errorf("expecting an error for a server following itself")
+	}
+}
+
+func TestServerConf_ValidateAdmin(t *testing.T) {
+	conf := Default()
+	conf.Admin = adminEndpoint{Address: "127.0.0.1:8080", AuditLog: "admin-audit.log", MutationsPerMinute: 10}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	conf.Admin.MutationsPerMinute = -1
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a negative limit")
+	}
+	conf.Admin.MutationsPerMinute = 0
+	conf.Admin.Canary = "canary.example.org."
+	if got := conf.Admin.CanaryName(); got != "canary.example.org" {
+		t.Errorf("CanaryName() = %s, want canary.example.org", got)
+	}
+	conf.Admin.Canary = "http://example.org"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an invalid canary")
+	}
+}
+
+func TestServerConf_ValidateClientGroups(t *testing.T) {
+	conf := Default()
+	conf.Devices = []device{{Name: "tablet", Addresses: []string{"192.168.1.60", "fd00::60", "fe80::60%wlan0"}}}
+	conf.ClientGroups = []clientGroup{{
+		Name:       "kids",
+		Clients:    []string{"192.168.1.0/28", "192.168.1.50", "fc00::/7", "fe80::%eth0/64", "tablet"},
+		BlockRules: []string{"*.adult.com"},
+		Custom:     []custom{{"api.example.com", "10.0.0.10"}},
+	}}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	networks, _ := conf.ClientGroups[0].Networks(conf.Devices)
+	var got []string
+	for _, network := range networks {
+		got = append(got, network.Subnet.String()+"%"+network.Zone)
+	}
+	want := []string{"192.168.1.0/28%", "192.168.1.50/32%", "fc00::/7%", "fe80::/64%eth0", "192.168.1.60/32%", "fd00::60/128%", "fe80::60/128%wlan0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Networks() = %v, want %v", got, want)
+	}
+	for _, groups := range [][]clientGroup{
+		{{Name: "kids", Clients: []string{"phone"}}},
+		{{Name: "kids", Clients: []string{"192.168.1.1%eth0"}}},
+		{{Name: "kids"}, {Name: "kids"}},
+		{{Name: "kids", BlockRules: []string{"/(/"}}},
+		{{Name: "kids", Custom: []custom{{"api.example.com", "staging"}}}},
+		{{Name: "kids", Metered: metered{Enabled: true, MinTTL: maxTTL + 1}}},
+	} {
+		conf.ClientGroups = groups
+		if err := conf.Validate(); err == nil {
+			t.Errorf("expecting an error for %v", groups)
+		}
+	}
+	conf.ClientGroups = nil
+	conf.Devices = []device{{Name: "phone", Addresses: []string{"phone.lan"}}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an invalid device address")
+	}
+}
+
+func TestServerConf_ValidateHealthChecks(t *testing.T) {
+	conf := Default()
+	conf.HealthChecks = []healthCheck{{Name: "dnshield.check"}, {Name: "v6.dnshield.check", Address: "::1", Text: "ok"}}
+	checks, err := conf.Checks()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !checks[0].Address.Equal(net.ParseIP("127.0.0.1")) || !strings.HasPrefix(checks[0].Text, "ok ") {
+		t.Errorf("expecting the defaults of the check, got %v", checks[0])
+	}
+	for _, invalid := range [][]healthCheck{
+		{{Name: ""}},
+		{{Name: "dnshield.check"}, {Name: "DNShield.check."}},
+		{{Name: "dnshield.check", Address: "localhost"}},
+		{{Name: "dnshield.check", Text: strings.Repeat("x", 256)}},
+	} {
+		conf.HealthChecks = invalid
+		if err := conf.Validate(); err == nil {
+			t.Errorf("expecting an error for the health checks %v", invalid)
+		}
+	}
+}
+
+func TestServerConf_ValidateCacheEviction(t *testing.T) {
+	for _, eviction := range []string{"", "ttl", "lru", "lfu"} {
+		conf := Default()
+		conf.Cache.Eviction = eviction
+		if err := conf.Validate(); err != nil {
+			t.Errorf("unexpected error for %q: %v", eviction, err)
+		}
+	}
+	conf := Default()
+	conf.Cache.Eviction = "random"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown eviction")
+	}
+}
+
+func TestServerConf_Resources(t *testing.T) {
+	conf := Default()
+	conf.Profile = "router"
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res := conf.Resources(); res != profiles["tiny"] {
+		t.Errorf("expecting the resources of the tiny profile, got %+v", res)
+	}
+	conf.Cache.Size, conf.Endpoint.Workers, conf.Endpoint.Handlers, conf.Log.Level = 5000, 4, 8, "debug"
+	res := conf.Resources()
+	if res.CacheSize != 5000 || res.UDPWorkers != 4 || res.UDPHandlers != 8 || res.LogLevel != "debug" || res.NXDomainCacheSize != profiles["tiny"].NXDomainCacheSize {
+		t.Errorf("expecting the explicit settings to override the profile, got %+v", res)
+	}
+	conf.Profile = ""
+	if res := conf.Resources(); res.NXDomainCacheSize != profiles[defaultProfile].NXDomainCacheSize {
+		t.Errorf("expecting the default profile, got %+v", res)
+	}
+	conf.Profile = "huge"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown profile")
+	}
+	conf.Profile, conf.Endpoint.Workers = "large", -1
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for negative workers")
+	}
+	conf.Endpoint.Workers, conf.Endpoint.Handlers = 0, -1
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for negative handlers")
+	}
+	conf.Endpoint.Handlers = 0
+	if res := conf.Resources(); res.BlockerStructure != blocker.StructureMap {
+		t.Errorf("expecting the map structure of the large profile, got %s", res.BlockerStructure)
+	}
+	conf.BlockingListsStructure = "compact"
+	if res := conf.Resources(); res.BlockerStructure != blocker.StructureCompact {
+		t.Errorf("expecting the structure of the configuration, got %s", res.BlockerStructure)
+	}
+	conf.Cache.Size, conf.NXDomainCache.Size = -1, -1
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error disabling the caches: %v", err)
+	}
+	if res := conf.Resources(); res.CacheSize != 0 || res.NXDomainCacheSize != 0 {
+		t.Errorf("expecting the caches to be disabled, got %+v", res)
+	}
+	conf.Cache.AutoTune.Enabled = true
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for the auto tuning of a disabled cache")
+	}
+	conf.Cache.AutoTune.Enabled, conf.Cache.Size = false, -2
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for a negative cache size")
+	}
+	conf.Cache.Size, conf.BlockingListsStructure = 0, "trie"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for an unknown blocking list structure")
+	}
+}
+
+func TestServerConf_ValidateDnstap(t *testing.T) {
+	tests := []struct {
+		name    string
+		dnstap  dnstap
+		wantErr bool
+	}{
+		{"disabled", dnstap{}, false},
+		{"socket", dnstap{Socket: "/run/dnstap.sock", Upstream: true}, false},
+		{"file", dnstap{File: "/var/log/dnshield.dnstap", Identity: "resolver-1"}, false},
+		{"socket and file", dnstap{Socket: "/run/dnstap.sock", File: "/var/log/dnshield.dnstap"}, true},
+		{"upstream without output", dnstap{Upstream: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Dnstap = tt.dnstap
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateTracing(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracing tracing
+		wantErr bool
+	}{
+		{"disabled", tracing{}, false},
+		{"collector", tracing{Endpoint: "http://localhost:4318", SampleRate: 0.1, ServiceName: "resolver-1"}, false},
+		{"not an url", tracing{Endpoint: "localhost:4318"}, true},
+		{"grpc collector", tracing{Endpoint: "grpc://localhost:4317"}, true},
+		{"rate above 1", tracing{Endpoint: "http://localhost:4318", SampleRate: 1.5}, true},
+		{"negative rate", tracing{SampleRate: -0.1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Tracing = tt.tracing
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateMDNS(t *testing.T) {
+	tests := []struct {
+		name    string
+		mdns    mdns
+		wantErr bool
+	}{
+		{"disabled", mdns{Bridge: "not an address"}, false},
+		{"default route", mdns{Enabled: true, Suffixes: []string{"lan", "home.arpa."}}, false},
+		{"interface", mdns{Enabled: true, Interface: "eth0", Timeout: 500}, false},
+		{"bridge", mdns{Enabled: true, Bridge: "127.0.0.1:5354"}, false},
+		{"interface and bridge", mdns{Enabled: true, Interface: "eth0", Bridge: "127.0.0.1:5354"}, true},
+		{"bridge without port", mdns{Enabled: true, Bridge: "127.0.0.1"}, true},
+		{"empty suffix", mdns{Enabled: true, Suffixes: []string{"."}}, true},
+		{"wildcard suffix", mdns{Enabled: true, Suffixes: []string{"*.lan"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.MDNS = tt.mdns
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidatePrivacy(t *testing.T) {
+	tests := []struct {
+		name    string
+		privacy privacy
+		wantErr bool
+	}{
+		{"none", privacy{}, false},
+		{"hashed", privacy{Clients: "hash", Salt: "household", MinCount: 5, RetentionDays: 7}, false},
+		{"truncated", privacy{Clients: "truncate", PrefixV4: 16, PrefixV6: 56}, false},
+		{"unknown mode", privacy{Clients: "drop"}, true},
+		{"IPv4 prefix too long", privacy{Clients: "truncate", PrefixV4: 33}, true},
+		{"negative IPv6 prefix", privacy{Clients: "truncate", PrefixV6: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Privacy = tt.privacy
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	conf := Default()
+	conf.Privacy = privacy{Clients: "hash"}
+	conf.Dnstap.Socket = "/run/dnstap.sock"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expecting an error for dnstap with the privacy")
+	}
+	conf.Privacy = privacy{RetentionDays: 7}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error for dnstap with a retention only: %v", err)
+	}
+}
+
+func TestServerConf_ValidateLeases(t *testing.T) {
+	tests := []struct {
+		name    string
+		leases  dhcpLeases
+		wantErr bool
+	}{
+		{"none", dhcpLeases{}, false},
+		{"guessed format", dhcpLeases{Sources: []string{"/var/lib/misc/dnsmasq.leases"}, Domain: "lan"}, false},
+		{"kea and api", dhcpLeases{Sources: []string{"/var/lib/kea/kea-leases4.csv", "http://127.0.0.1:8000/leases"}, Format: "kea"}, false},
+		{"unknown format", dhcpLeases{Sources: []string{"/var/lib/dhcp/dhcpd.leases"}, Format: "udhcpd"}, true},
+		{"empty source", dhcpLeases{Sources: []string{""}}, true},
+		{"url without host", dhcpLeases{Sources: []string{"http:///leases"}}, true},
+		{"wildcard domain", dhcpLeases{Domain: "*.lan"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Leases = tt.leases
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateGitSync(t *testing.T) {
+	tests := []struct {
+		name    string
+		gitSync gitSync
+		admin   string
+		wantErr bool
+	}{
+		{"disabled", gitSync{}, "", false},
+		{"default file", gitSync{Repository: "https://git.example.com/dns.git", Dir: "/var/lib/dnshield/policy"}, "", false},
+		{"nested file", gitSync{Repository: "git@git.example.com:dns.git", Dir: "/var/lib/dnshield/policy", File: "home/policy.json", Push: true}, "", false},
+		{"no directory", gitSync{Repository: "https://git.example.com/dns.git"}, "", true},
+		{"absolute file", gitSync{Repository: "https://git.example.com/dns.git", Dir: "/var/lib/dnshield/policy", File: "/etc/policy.json"}, "", true},
+		{"file out of the repository", gitSync{Repository: "https://git.example.com/dns.git", Dir: "/var/lib/dnshield/policy", File: "../policy.json"}, "", true},
+		{"push from a local api", gitSync{Repository: "https://git.example.com/dns.git", Dir: "/var/lib/dnshield/policy", Push: true}, "[::1]:5380", false},
+		{"push from a public api", gitSync{Repository: "https://git.example.com/dns.git", Dir: "/var/lib/dnshield/policy", Push: true}, ":5380", true},
+		{"pull with a public api", gitSync{Repository: "https://git.example.com/dns.git", Dir: "/var/lib/dnshield/policy"}, "0.0.0.0:5380", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.GitSync = tt.gitSync
+			if tt.admin != "" {
+				conf.Admin.Address = tt.admin
+			}
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseNetworkPolicy(t *testing.T) {
+	policy, err := ParseNetworkPolicy([]byte(`{"allow_domains": ["cdn.example.com"], "block_rules": ["*.ads.example.com"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := Default().WithNetworkPolicy(policy)
+	if !reflect.DeepEqual(conf.NetworkPolicy(), policy) {
+		t.Errorf("expecting the policy %+v, got %+v", policy, conf.NetworkPolicy())
+	}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := ParseNetworkPolicy([]byte(`{"upstreams": []}`)); err == nil {
+		t.Errorf("expecting an error for a field out of the network policy")
+	}
+}
+
+func TestServerConf_ValidateNoise(t *testing.T) {
+	tests := []struct {
+		name    string
+		noise   noise
+		wantErr bool
+	}{
+		{"disabled", noise{}, false},
+		{"well-known domains", noise{Enabled: true, TTL: 3600}, false},
+		{"more domains", noise{Enabled: true, Domains: []string{"*.telemetry.example.com", "check.example.com"}}, false},
+		{"invalid domain", noise{Enabled: true, Domains: []string{"telemetry.*.example.com"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Noise = tt.noise
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateSnapshots(t *testing.T) {
+	tests := []struct {
+		name      string
+		snapshots snapshots
+		wantErr   bool
+	}{
+		{"in memory", snapshots{}, false},
+		{"written", snapshots{Directory: "/var/lib/dnshield/snapshots", Keep: 50}, false},
+		{"negative keep", snapshots{Keep: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Snapshots = tt.snapshots
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateSchedules(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule schedule
+		wantErr  bool
+	}{
+		{"every client", schedule{Name: "games", BlockRules: []string{"games.com"}, From: "00:00", To: "00:00"}, false},
+		{"group at night", schedule{Name: "night", Groups: []string{"kids"}, BlockRules: []string{"*.tiktok.com"}, Days: []string{"weekdays"}, From: "21:00", To: "07:00"}, false},
+		{"no rule", schedule{Name: "night", From: "21:00", To: "07:00"}, true},
+		{"invalid rule", schedule{Name: "night", BlockRules: []string{"/(/"}, From: "21:00", To: "07:00"}, true},
+		{"invalid time", schedule{Name: "night", BlockRules: []string{"games.com"}, From: "9pm", To: "07:00"}, true},
+		{"invalid day", schedule{Name: "night", BlockRules: []string{"games.com"}, Days: []string{"someday"}, From: "21:00", To: "07:00"}, true},
+		{"unknown group", schedule{Name: "night", Groups: []string{"guests"}, BlockRules: []string{"games.com"}, From: "21:00", To: "07:00"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.ClientGroups = []clientGroup{{Name: "kids", Clients: []string{"192.168.1.0/28"}}}
+			conf.Blocking.Schedules = []schedule{tt.schedule}
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateRewrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewrites []rewrite
+		wantErr  bool
+	}{
+		{"none", nil, false},
+		{"addresses and alias", []rewrite{{Domain: "*.lab.home", Answer: "192.168.1.50"}, {Domain: "*.lab.home", Answer: "fd00::50"}, {Domain: "media.home", Answer: "jellyfin.lab.home", TTL: 60}}, false},
+		{"invalid domain", []rewrite{{Domain: "lab.*.home", Answer: "192.168.1.50"}}, true},
+		{"invalid answer", []rewrite{{Domain: "nas.home", Answer: "*.lab.home"}}, true},
+		{"alias with addresses", []rewrite{{Domain: "nas.home", Answer: "192.168.1.2"}, {Domain: "nas.home", Answer: "storage.home"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Default()
+			conf.Rewrites = tt.rewrites
+			if err := conf.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerConf_ValidateChain(t *testing.T) {
+	conf := Default()
+	conf.Chain = []string{"cache", "Block", "Custom", "External"}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, chain := range [][]string{
+		{"Block", "Upstream"},
+		{"Cache", "Block", "cache"},
+	} {
+		conf.Chain = chain
+		if err := conf.Validate(); err == nil {
+			t.Errorf("expecting an error for the chain %v", chain)
+		}
+	}
+}
+
+func TestServerConf_StatePaths(t *testing.T) {
+	conf := ServerConf{BlockingListsCache: "/var/cache/dnshield/lists"}
+	conf.Snapshots.Directory = "/var/lib/dnshield/snapshots"
+	conf.GitSync.Dir = "/var/lib/dnshield/policy"
+	tenant := Tenant{Name: "guests"}
+	tenant.Cache.Snapshot = "/var/lib/dnshield/guests.snapshot"
+	conf.Tenants = []Tenant{tenant}
+	want := []string{"/var/lib/dnshield/snapshots", "/var/cache/dnshield/lists", "/var/lib/dnshield/policy", "/var/lib/dnshield/guests.snapshot"}
+	if got := conf.StatePaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ServerConf.StatePaths() = %v, want %v", got, want)
+	}
+}
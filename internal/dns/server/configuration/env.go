@@ -0,0 +1,106 @@
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("configuration")
+
+// EnvPrefix prefixes the environment variables overriding a setting of the configuration file, followed by the path
+// of its key in upper case, like DNSHIELD_EXTERNAL_ENDPOINT for external.endpoint
+const EnvPrefix = "DNSHIELD_"
+
+// applyEnv overrides the settings of conf by the variables of the environment, a list of key=value. The strings are
+// taken as they are, the lists of strings are separated by commas, the other values are written in json.
+// The variables of the prefix which are not a setting are skipped with a warning, the platforms like Kubernetes
+// define variables of their own named after the services, like DNSHIELD_PORT
+func applyEnv(conf *ServerConf, environ []string) error {
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		field, ok := lookupField(reflect.ValueOf(conf).Elem(), strings.Split(strings.ToLower(strings.TrimPrefix(name, EnvPrefix)), "_"))
+		if !ok {
+			logger.Warn("skipping the variable of the environment which is not a setting", "variable", name)
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return errors.New("invalid " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// lookupField returns the field of the struct v at the path, the parts of its keys being separated like the keys.
+// The longest key matching the path is tried first
+func lookupField(v reflect.Value, parts []string) (reflect.Value, bool) {
+	for i := len(parts); i > 0; i-- {
+		field, ok := fieldByKey(v, strings.Join(parts[:i], "_"))
+		switch {
+		case !ok:
+			continue
+		case i == len(parts):
+			return field, true
+		case field.Kind() == reflect.Struct:
+			if res, ok := lookupField(field, parts[i:]); ok {
+				return res, true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// fieldByKey returns the field of the struct v decoded from the json key, the fields of the embedded structs included
+func fieldByKey(v reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			if res, ok := fieldByKey(v.Field(i), key); ok {
+				return res, true
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if name != "-" && strings.EqualFold(name, key) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// setField sets the field to the value of the variable
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(value, "["):
+		res := reflect.MakeSlice(field.Type(), 0, strings.Count(value, ",")+1)
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				res = reflect.Append(res, reflect.ValueOf(s).Convert(field.Type().Elem()))
+			}
+		}
+		field.Set(res)
+		return nil
+	}
+	// the value replaces the field entirely, like the one of the file
+	res := reflect.New(field.Type())
+	if err := json.Unmarshal([]byte(value), res.Interface()); err != nil {
+		return err
+	}
+	field.Set(res.Elem())
+	return nil
+}
@@ -0,0 +1,106 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/util/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the syntax of a configuration file, given by its extension
+type Format string
+
+const (
+	// JSON is the format of the files without yaml or toml extension
+	JSON Format = "json"
+	// YAML is the format of the .yaml and .yml files
+	YAML Format = "yaml"
+	// TOML is the format of the .toml files
+	TOML Format = "toml"
+)
+
+// FormatOf returns the format of the configuration file at path
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML
+	case ".toml":
+		return TOML
+	}
+	return JSON
+}
+
+// toJSON converts the document in data to json, the keys are left untouched so they are checked by the decoding
+func toJSON(format Format, data []byte) ([]byte, error) {
+	var document any
+	var err error
+	switch format {
+	case YAML:
+		err = yaml.Unmarshal(data, &document)
+	case TOML:
+		document, err = toml.Decode(data)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if document == nil {
+		// an empty document is an empty configuration
+		document = map[string]any{}
+	}
+	return json.Marshal(document)
+}
+
+// Marshal returns the configuration written in the format
+func Marshal(conf ServerConf, format Format) ([]byte, error) {
+	data, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil || format == JSON {
+		return append(data, '\n'), err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// the integers are kept as written, toml would write the large ones as floats
+	decoder.UseNumber()
+	var document map[string]any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	switch format {
+	case YAML:
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(numbers(document)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), encoder.Close()
+	case TOML:
+		return toml.Encode(document)
+	}
+	return nil, errors.New("unknown configuration format " + string(format))
+}
+
+// numbers replaces the json numbers of the document by yaml numbers, yaml would quote them as strings
+func numbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = numbers(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = numbers(value)
+		}
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(v.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}
+	}
+	return v
+}
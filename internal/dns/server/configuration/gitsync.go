@@ -0,0 +1,84 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+)
+
+// defaultPolicyFile is the path of the network policy in the repository when none is configured
+const defaultPolicyFile = "policy.json"
+
+// gitSync applies the network policy of a git repository, pulled every interval, over the local configuration
+type gitSync struct {
+	// Repository is the url of the repository, cloned with the git command so its credentials are the ones of git
+	Repository string `json:"repository,omitempty"`
+	// Branch is the branch of the policy, the default branch of the repository when empty
+	Branch string `json:"branch,omitempty"`
+	// File is the path of the policy in the repository, policy.json when empty
+	File string `json:"file,omitempty"`
+	// Dir is the directory of the local clone
+	Dir string `json:"dir,omitempty"`
+	// Interval is the duration in seconds between two pulls, the interval of the follow mode by default
+	Interval uint32 `json:"interval,omitempty"`
+	// Push commits the rules edited through the admin api to the repository. The admin api has no authentication,
+	// so it must only listen on a loopback address for anyone reaching it not to rewrite the policy of every server
+	Push bool `json:"push,omitempty"`
+}
+
+func (g gitSync) check(admin adminEndpoint) error {
+	if g.Repository == "" {
+		return nil
+	}
+	if g.Dir == "" {
+		return errors.New("the git synchronization needs a directory for its clone")
+	}
+	if g.Push && !admin.loopback() {
+		return errors.New("pushing the rules edited through the unauthenticated admin api needs the api on a loopback address, not " + admin.Address)
+	}
+	if file := g.File; file != "" && (path.IsAbs(file) || strings.HasPrefix(path.Clean(file), "..")) {
+		return errors.New("invalid policy file " + file + ", expecting a path in the repository")
+	}
+	return nil
+}
+
+// PolicyFile returns the path of the network policy in the repository
+func (g gitSync) PolicyFile() string {
+	if g.File == "" {
+		return defaultPolicyFile
+	}
+	return path.Clean(g.File)
+}
+
+// NetworkPolicy is the part of the configuration shared by several servers through a git repository:
+// the names allowed and blocked for all the clients, the client groups and their devices
+type NetworkPolicy struct {
+	AllowDomains []string      `json:"allow_domains,omitempty"`
+	BlockRules   []string      `json:"block_rules,omitempty"`
+	ClientGroups []clientGroup `json:"client_groups,omitempty"`
+	Devices      []device      `json:"devices,omitempty"`
+}
+
+// ParseNetworkPolicy decodes a network policy, its unknown fields are errors
+func ParseNetworkPolicy(data []byte) (NetworkPolicy, error) {
+	var res NetworkPolicy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&res); err != nil {
+		return res, errors.New("invalid network policy: " + err.Error())
+	}
+	return res, nil
+}
+
+// NetworkPolicy returns the network policy of the configuration
+func (c ServerConf) NetworkPolicy() NetworkPolicy {
+	return NetworkPolicy{AllowDomains: c.AllowDomains, BlockRules: c.BlockRules, ClientGroups: c.ClientGroups, Devices: c.Devices}
+}
+
+// WithNetworkPolicy returns the configuration with the network policy replacing its own
+func (c ServerConf) WithNetworkPolicy(p NetworkPolicy) ServerConf {
+	c.AllowDomains, c.BlockRules, c.ClientGroups, c.Devices = p.AllowDomains, p.BlockRules, p.ClientGroups, p.Devices
+	return c
+}
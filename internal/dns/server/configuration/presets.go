@@ -0,0 +1,186 @@
+package configuration
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
+	"github.com/bluguard/dnshield/internal/dns/client/family"
+)
+
+// presets are the upstream providers selectable by name as the type of an external source, they expand
+// to DoT on the addresses of the provider, so reaching the upstream needs no resolution
+var presets = map[string][]ExternalSource{
+	"cloudflare": {
+		{Type: "DOT", Endpoint: "1.1.1.1:853", ServerName: "cloudflare-dns.com"},
+		{Type: "DOT", Endpoint: "1.0.0.1:853", ServerName: "cloudflare-dns.com"},
+	},
+	"cloudflare-malware": {
+		{Type: "DOT", Endpoint: "1.1.1.2:853", ServerName: "security.cloudflare-dns.com"},
+		{Type: "DOT", Endpoint: "1.0.0.2:853", ServerName: "security.cloudflare-dns.com"},
+	},
+	"quad9": {
+		{Type: "DOT", Endpoint: "9.9.9.9:853", ServerName: "dns.quad9.net"},
+		{Type: "DOT", Endpoint: "149.112.112.112:853", ServerName: "dns.quad9.net"},
+	},
+	"google": {
+		{Type: "DOT", Endpoint: "8.8.8.8:853", ServerName: "dns.google"},
+		{Type: "DOT", Endpoint: "8.8.4.4:853", ServerName: "dns.google"},
+	},
+	"adguard": {
+		{Type: "DOT", Endpoint: "94.140.14.14:853", ServerName: "dns.adguard-dns.com"},
+		{Type: "DOT", Endpoint: "94.140.15.15:853", ServerName: "dns.adguard-dns.com"},
+	},
+	"mullvad": {
+		{Type: "DOT", Endpoint: "194.242.2.2:853", ServerName: "dns.mullvad.net"},
+	},
+}
+
+// presetsV6 are the IPv6 addresses of the presets, they are used when the family of the source prefers or requires IPv6
+var presetsV6 = map[string][]ExternalSource{
+	"cloudflare": {
+		{Type: "DOT", Endpoint: "[2606:4700:4700::1111]:853", ServerName: "cloudflare-dns.com"},
+		{Type: "DOT", Endpoint: "[2606:4700:4700::1001]:853", ServerName: "cloudflare-dns.com"},
+	},
+	"cloudflare-malware": {
+		{Type: "DOT", Endpoint: "[2606:4700:4700::1112]:853", ServerName: "security.cloudflare-dns.com"},
+		{Type: "DOT", Endpoint: "[2606:4700:4700::1002]:853", ServerName: "security.cloudflare-dns.com"},
+	},
+	"quad9": {
+		{Type: "DOT", Endpoint: "[2620:fe::fe]:853", ServerName: "dns.quad9.net"},
+		{Type: "DOT", Endpoint: "[2620:fe::9]:853", ServerName: "dns.quad9.net"},
+	},
+	"google": {
+		{Type: "DOT", Endpoint: "[2001:4860:4860::8888]:853", ServerName: "dns.google"},
+		{Type: "DOT", Endpoint: "[2001:4860:4860::8844]:853", ServerName: "dns.google"},
+	},
+	"adguard": {
+		{Type: "DOT", Endpoint: "[2a10:50c0::ad1:ff]:853", ServerName: "dns.adguard-dns.com"},
+		{Type: "DOT", Endpoint: "[2a10:50c0::ad2:ff]:853", ServerName: "dns.adguard-dns.com"},
+	},
+	"mullvad": {
+		{Type: "DOT", Endpoint: "[2a07:e340::2]:853", ServerName: "dns.mullvad.net"},
+	},
+}
+
+// Presets returns the names of the upstream presets, sorted
+func Presets() []string {
+	res := make([]string, 0, len(presets))
+	for name := range presets {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// expand returns the sources of the preset named by the type of s, s itself when it is not a preset.
+// The addresses of the preset are the ones of the family of s, in the order of its preference, IPv4 by default
+func (s ExternalSource) expand() []ExternalSource {
+	if sources, ok := presets[strings.ToLower(s.Type)]; ok {
+		v6 := presetsV6[strings.ToLower(s.Type)]
+		switch family.Family(s.Family) {
+		case family.IPv6:
+			sources = v6
+		case family.PreferIPv6:
+			sources = append(v6[:len(v6):len(v6)], sources...)
+		case family.PreferIPv4:
+			sources = append(sources[:len(sources):len(sources)], v6...)
+		}
+		res := make([]ExternalSource, 0, len(sources))
+		for _, source := range sources {
+			source.DNSSEC = s.DNSSEC // the policy of the preset applies to its upstreams
+			res = append(res, source)
+		}
+		return res
+	}
+	return []ExternalSource{s}
+}
+
+// needsEndpoint tells if the source is reached through its endpoint, unlike a recursion or a preset
+func (s ExternalSource) needsEndpoint() bool {
+	_, isPreset := presets[strings.ToLower(s.Type)]
+	return !isPreset && s.Type != "RECURSIVE"
+}
+
+// maxRetries bounds the retries of an upstream, the query would end before them
+const maxRetries = 5
+
+// RetryCount returns the retries of the upstream, def when they are not set
+func (s ExternalSource) RetryCount(def int) int {
+	if s.Retries == nil {
+		return def
+	}
+	return int(*s.Retries)
+}
+
+// check returns an error when the type of s is neither a protocol nor a preset, or when its timeout does not apply to it
+func (s ExternalSource) check() error {
+	if (s.Timeout > 0 || s.Retries != nil) && s.Type != "" && s.Type != "UDP" && s.Type != "DOH" {
+		return errors.New("the timeout and the retries of the upstream " + s.Type + " " + s.Endpoint + " only apply to UDP and DOH")
+	}
+	if (s.Connections > 0 || s.IdleTimeout > 0 || s.Prewarm) && s.Type != "DOH" {
+		return errors.New("the connections of the upstream " + s.Type + " " + s.Endpoint + " only apply to DOH")
+	}
+	if s.RetryCount(0) > maxRetries {
+		return errors.New("the upstream " + s.Endpoint + " has more than " + strconv.Itoa(maxRetries) + " retries")
+	}
+	policy, err := dnssec.ParsePolicy(s.DNSSEC)
+	if err != nil {
+		return err
+	}
+	if policy == dnssec.Trust && s.Type == "RECURSIVE" {
+		return errors.New("the recursion cannot be trusted to validate, its DNSSEC policy must be ignore or validate")
+	}
+	if policy == dnssec.Trust && (s.Type == "" || s.Type == "UDP") {
+		// anyone on the path can set the AD bit of a plain UDP answer
+		return errors.New("the AD bit of the upstream " + s.Endpoint + " cannot be trusted over plain UDP, use DOH, DOT or DOQ")
+	}
+	fam, err := family.Parse(s.Family)
+	if err != nil {
+		return err
+	}
+	if s.Family != "" && s.Type == "RECURSIVE" {
+		return errors.New("the address family does not apply to the recursion, it asks the servers of the zones over the family of their addresses")
+	}
+	if ip, ok := s.endpointIP(); ok && ((fam == family.IPv4 && !ip.Unmap().Is4()) || (fam == family.IPv6 && ip.Unmap().Is4())) {
+		return errors.New("the upstream " + s.Endpoint + " cannot be reached over " + s.Family + " only")
+	}
+	switch s.Type {
+	case "", "DOH", "DOT", "DOQ", "UDP", "RECURSIVE":
+		return nil
+	}
+	if _, ok := presets[strings.ToLower(s.Type)]; ok {
+		return nil
+	}
+	return errors.New("unknown upstream type " + s.Type + ", expecting DOH, DOT, DOQ, UDP, RECURSIVE or one of the presets " + strings.Join(Presets(), ", "))
+}
+
+// endpointIP returns the address of the endpoint when its host is an address rather than a name
+func (s ExternalSource) endpointIP() (netip.Addr, bool) {
+	host := s.Endpoint
+	if u, err := url.Parse(s.Endpoint); err == nil && s.Type == "DOH" {
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(s.Endpoint); err == nil {
+		host = h
+	}
+	ip, err := netip.ParseAddr(host)
+	return ip, err == nil
+}
+
+// UpstreamSources returns the sources of Upstreams, or External when there are none, with their presets expanded
+func (c ServerConf) UpstreamSources() []ExternalSource {
+	sources := c.Upstreams.Sources
+	if len(sources) == 0 {
+		sources = []ExternalSource{c.External}
+	}
+	res := make([]ExternalSource, 0, len(sources))
+	for _, source := range sources {
+		res = append(res, source.expand()...)
+	}
+	return res
+}
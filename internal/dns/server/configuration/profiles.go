@@ -0,0 +1,135 @@
+package configuration
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+)
+
+// disabled is the size disabling a cache instead of taking the one of the profile
+const disabled = -1
+
+// Profile are the resources given to the server, sized for the device it runs on
+type Profile struct {
+	// CacheSize is the memory of the cache in bytes, NXDomainCacheSize the number of names of the negative cache
+	CacheSize         int64
+	NXDomainCacheSize int
+	// UDPWorkers is the number of sockets of the udp endpoints, UDPHandlers the number of queries they resolve at once
+	UDPWorkers  int
+	UDPHandlers int
+	// BlockerSize is the number of names the blocker is allocated for before loading the lists, it grows beyond.
+	// BlockerStructure holds the names, compact for the devices short of memory
+	BlockerSize      int
+	BlockerStructure blocker.Structure
+	// LogLevel is the level of the logs when the configuration sets none
+	LogLevel string
+}
+
+// defaultProfile is the profile of the configurations selecting none
+const defaultProfile = "default"
+
+// profiles are the built-in profiles selectable by name, router is an alias of tiny
+var profiles = map[string]Profile{
+	"tiny": {
+		CacheSize:         200000,
+		NXDomainCacheSize: 1000,
+		UDPWorkers:        2,
+		UDPHandlers:       16,
+		BlockerSize:       1000,
+		BlockerStructure:  blocker.StructureCompact,
+		LogLevel:          "warn",
+	},
+	"router": {
+		CacheSize:         200000,
+		NXDomainCacheSize: 1000,
+		UDPWorkers:        2,
+		UDPHandlers:       16,
+		BlockerSize:       1000,
+		BlockerStructure:  blocker.StructureCompact,
+		LogLevel:          "warn",
+	},
+	defaultProfile: {
+		CacheSize:         1000000,
+		NXDomainCacheSize: 10000,
+		UDPWorkers:        10,
+		UDPHandlers:       64,
+		BlockerSize:       10000,
+		BlockerStructure:  blocker.StructureCompact,
+		LogLevel:          "info",
+	},
+	"large": {
+		CacheSize:         50000000,
+		NXDomainCacheSize: 100000,
+		UDPWorkers:        32,
+		UDPHandlers:       256,
+		BlockerSize:       500000,
+		BlockerStructure:  blocker.StructureMap,
+		LogLevel:          "info",
+	},
+}
+
+// Profiles returns the names of the built-in profiles, sorted
+func Profiles() []string {
+	res := make([]string, 0, len(profiles))
+	for name := range profiles {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (c ServerConf) checkProfile() error {
+	if c.Cache.Size < disabled || c.NXDomainCache.Size < disabled {
+		return errors.New("invalid cache size, expecting a positive size, 0 for the one of the profile or -1 to disable the cache")
+	}
+	if c.Cache.Size == disabled && c.Cache.AutoTune.Enabled {
+		return errors.New("the auto tuning cannot size a disabled cache")
+	}
+	if _, err := blocker.ParseStructure(c.BlockingListsStructure); err != nil {
+		return err
+	}
+	if c.Profile == "" {
+		return nil
+	}
+	if _, ok := profiles[strings.ToLower(c.Profile)]; !ok {
+		return errors.New("unknown profile " + c.Profile + ", expecting one of " + strings.Join(Profiles(), ", "))
+	}
+	return nil
+}
+
+// Resources returns the resources of the profile of the configuration, the default one when none is selected,
+// the sizes, the structure of the blocking lists and the log level set explicitly by the configuration take precedence
+// over the ones of the profile. A cache disabled by the configuration has a size of 0
+func (c ServerConf) Resources() Profile {
+	res, ok := profiles[strings.ToLower(c.Profile)]
+	if !ok {
+		res = profiles[defaultProfile]
+	}
+	switch {
+	case c.Cache.Size == disabled:
+		res.CacheSize = 0
+	case c.Cache.Size != 0:
+		res.CacheSize = c.Cache.Size
+	}
+	switch {
+	case c.NXDomainCache.Size == disabled:
+		res.NXDomainCacheSize = 0
+	case c.NXDomainCache.Size != 0:
+		res.NXDomainCacheSize = c.NXDomainCache.Size
+	}
+	if c.BlockingListsStructure != "" {
+		res.BlockerStructure = blocker.Structure(c.BlockingListsStructure)
+	}
+	if c.Endpoint.Workers != 0 {
+		res.UDPWorkers = c.Endpoint.Workers
+	}
+	if c.Endpoint.Handlers != 0 {
+		res.UDPHandlers = c.Endpoint.Handlers
+	}
+	if c.Log.Level != "" {
+		res.LogLevel = c.Log.Level
+	}
+	return res
+}
@@ -0,0 +1,48 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Provisioner obtains and renews the certificates of its domains from an acme certificate authority, Let's Encrypt by default.
+// The domains are validated with the tls-alpn-01 challenge, answered by the tls endpoints listening on port 443, or with
+// the http-01 challenge once its handler is served on port 80. The account key and the certificates are kept in a cache
+// directory, so they survive the restarts
+type Provisioner struct {
+	manager *autocert.Manager
+}
+
+// NewProvisioner returns the provisioner of the certificates of the domains, directory is the url of the acme directory,
+// the one of Let's Encrypt when empty. The email is given to the authority to warn about the certificates expiring
+func NewProvisioner(domains []string, email, cacheDir, directory string) *Provisioner {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	if directory != "" {
+		manager.Client = &acme.Client{DirectoryURL: directory}
+	}
+	return &Provisioner{manager: manager}
+}
+
+// TLSConfig returns the configuration of the tls servers presenting the certificates of the domains to the clients
+// negotiating one of the protocols, like dot or h2, and answering the tls-alpn-01 challenges
+func (p *Provisioner) TLSConfig(protocols ...string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: p.manager.GetCertificate,
+		NextProtos:     append(protocols, acme.ALPNProto),
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// ChallengeHandler returns the handler answering the http-01 challenges, the other requests are redirected to https.
+// The http-01 challenge is only used once the handler is requested
+func (p *Provisioner) ChallengeHandler() http.Handler {
+	return p.manager.HTTPHandler(nil)
+}
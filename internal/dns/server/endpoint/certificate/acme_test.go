@@ -0,0 +1,36 @@
+package certificate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProvisioner(t *testing.T) {
+	p := NewProvisioner([]string{"dns.example.org"}, "admin@example.org", t.TempDir(), "")
+	config := p.TLSConfig("dot")
+	if !reflect.DeepEqual(config.NextProtos, []string{"dot", "acme-tls/1"}) {
+		t.Errorf("expecting the dot protocol and the tls-alpn challenges, got %v", config.NextProtos)
+	}
+
+	handler := p.ChallengeHandler()
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"unknown token", "http://dns.example.org/.well-known/acme-challenge/token", http.StatusNotFound},
+		{"unknown domain", "http://other.example.org/.well-known/acme-challenge/token", http.StatusForbidden},
+		{"not a challenge", "http://dns.example.org/dns-query", http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if recorder.Code != tt.status {
+				t.Errorf("expecting status %d, got %d", tt.status, recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Package certificate holds the certificate of the tls endpoints. It can be reloaded when its files change, so a renewed
+// certificate is served without restarting the endpoints
+package certificate
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("certificate")
+
+// Reloader serves the certificate of its files, it is safe for concurrent use
+type Reloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	current  *tls.Certificate
+	// modified is the latest modification time of the files of the current certificate
+	modified time.Time
+}
+
+// Load returns the reloader serving the certificate of the files
+func Load(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the configuration of the tls servers presenting the current certificate
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: r.GetCertificate, MinVersion: tls.VersionTLS12}
+}
+
+// GetCertificate returns the current certificate, see tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.current, nil
+}
+
+// Watch checks the files every interval until ctx is done, the certificate is reloaded when they are modified.
+// A certificate which cannot be loaded, like one being written, is retried at the next check while the previous one is served
+func (r *Reloader) Watch(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reloaded, err := r.reload()
+				if err != nil {
+					logger.Warn("cannot reload the certificate, keeping the previous one", "cert", r.certFile, "err", err)
+				} else if reloaded {
+					logger.Info("certificate reloaded", "cert", r.certFile)
+				}
+			}
+		}
+	}()
+}
+
+// reload loads the certificate when its files have been modified since the current one, it tells if it was loaded
+func (r *Reloader) reload() (bool, error) {
+	modified, err := lastModification(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.lock.RLock()
+	unchanged := r.current != nil && !modified.After(r.modified)
+	r.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, errors.New("cannot load the certificate " + r.certFile + ": " + err.Error())
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.current, r.modified = &certificate, modified
+	return true, nil
+}
+
+func lastModification(paths ...string) (time.Time, error) {
+	var res time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(res) {
+			res = info.ModTime()
+		}
+	}
+	return res, nil
+}
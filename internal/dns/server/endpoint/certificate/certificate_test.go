@@ -0,0 +1,111 @@
+package certificate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned writes a certificate for name and its key in dir, modified at the given time
+func writeSelfSigned(t *testing.T, dir, name string, modified time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	current, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(current.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	certFile, keyFile := writeSelfSigned(t, dir, "old.test", start)
+	r, err := Load(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := commonName(t, r); name != "old.test" {
+		t.Fatalf("expecting the certificate of old.test, got %s", name)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	r.Watch(ctx, &wg, 10*time.Millisecond)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// a certificate being written is not loaded, the previous one is kept
+	if err := os.WriteFile(keyFile, []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if name := commonName(t, r); name != "old.test" {
+		t.Fatalf("expecting the previous certificate to be kept, got %s", name)
+	}
+
+	writeSelfSigned(t, dir, "new.test", time.Now())
+	deadline := time.Now().Add(2 * time.Second)
+	for commonName(t, r) != "new.test" {
+		if time.Now().After(deadline) {
+			t.Fatal("the renewed certificate has not been reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoad_missing(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Errorf("expecting an error for missing files")
+	}
+}
@@ -0,0 +1,250 @@
+package dohendpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/metrics"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("dohendpoint")
+
+const (
+	DefaultPath = "/dns-query"
+	contentType = "application/dns-message"
+	// readHeaderTimeout is the time a client has to send the headers of its request
+	readHeaderTimeout = 5 * time.Second
+	// maximum size of a dns message
+	maxMessageSize = 65535
+)
+
+var _ endpoint.Endpoint = &DOHEndpoint{}
+var _ endpoint.Instrumented = &DOHEndpoint{}
+var _ endpoint.Guarded = &DOHEndpoint{}
+var _ endpoint.Drained = &DOHEndpoint{}
+
+// errDenied rejects the request of a client dropped by the acl
+var errDenied = errors.New("client not allowed")
+
+// NewDOHEndpoint create a new DNS over HTTPS endpoint with the given chain, it serves plain http
+// when no certificate is given, to run behind a tls terminating proxy
+func NewDOHEndpoint(address, path, certFile, keyFile string, chain *resolver.ResolverChain) *DOHEndpoint {
+	if path == "" {
+		path = DefaultPath
+	}
+	return &DOHEndpoint{
+		laddr:    address,
+		path:     path,
+		certFile: certFile,
+		keyFile:  keyFile,
+		chain:    chain,
+		drain:    endpoint.DefaultDrainTimeout,
+	}
+}
+
+// DOHEndpoint endpoint serving dns over https (rfc8484)
+type DOHEndpoint struct {
+	laddr    string
+	path     string
+	certFile string
+	keyFile  string
+	chain    *resolver.ResolverChain
+	lock     sync.RWMutex
+	started  atomic.Bool
+	metrics  *metrics.Metrics
+	// acl is nil when every client is allowed
+	acl *acl.ACL
+	// tlsConfig presents the certificates instead of the files when not nil
+	tlsConfig *tls.Config
+	// drain is the time the requests in progress are answered for once stopped
+	drain time.Duration
+}
+
+// SetChain implements endpoint.Endpoint
+func (e *DOHEndpoint) SetChain(chain *resolver.ResolverChain) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.chain = chain
+}
+
+// SetMetrics implements endpoint.Instrumented
+func (e *DOHEndpoint) SetMetrics(m *metrics.Metrics) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.metrics = m
+}
+
+// SetACL implements endpoint.Guarded
+func (e *DOHEndpoint) SetACL(a *acl.ACL) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.acl = a
+}
+
+// SetDrainTimeout implements endpoint.Drained
+func (e *DOHEndpoint) SetDrainTimeout(timeout time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.drain = timeout
+}
+
+// SetTLSConfig serves https with the certificates of the configuration, like the ones provisioned with acme, instead of
+// the files. It must be called before starting the endpoint
+func (e *DOHEndpoint) SetTLSConfig(config *tls.Config) {
+	e.tlsConfig = config
+}
+
+// Start implements endpoint.Endpoint, the endpoints of the tenants listening on the same address share its server
+// with their own path
+func (e *DOHEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if !e.started.CompareAndSwap(false, true) {
+		panic("endpoint is already started")
+	}
+	logger.Info("starting doh endpoint", "address", e.laddr, "path", e.path)
+	tlsConfig := e.tlsConfig
+	if tlsConfig == nil && e.certFile != "" {
+		// the certificate is loaded before listening, a missing one fails the start rather than the first client
+		certificate, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+		if err != nil {
+			return errors.New("cannot load the certificate of the doh endpoint: " + err.Error())
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
+	key, err := routes.join(ctx, e.laddr, e.path, e.Handler(), tlsConfig)
+	if err != nil {
+		return err
+	}
+	wg.Add(1)
+	go e.run(ctx, wg, key)
+	return nil
+}
+
+// run serves the path until ctx is done, the server of the route is shut down once no endpoint serves it
+func (e *DOHEndpoint) run(ctx context.Context, wg *sync.WaitGroup, key string) {
+	defer wg.Done()
+	<-ctx.Done()
+	server := routes.leave(key, e.path)
+	if server == nil {
+		logger.Info("doh endpoint stopped", "address", e.laddr, "path", e.path)
+		return
+	}
+	e.lock.RLock()
+	drain := e.drain
+	e.lock.RUnlock()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	// the requests in progress are answered, the ones still running once the drain is over are cut
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		_ = server.Close()
+	}
+}
+
+// Handler returns the http handler of the endpoint
+func (e *DOHEndpoint) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(e.path, e.serveDNS)
+	return mux
+}
+
+func (e *DOHEndpoint) serveDNS(w http.ResponseWriter, r *http.Request) {
+	var query []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		query, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != contentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		query, err = io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+		if len(query) > maxMessageSize {
+			http.Error(w, "dns query too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil || len(query) == 0 {
+		http.Error(w, "invalid dns query", http.StatusBadRequest)
+		return
+	}
+
+	client, zone := request.SplitClient(r.RemoteAddr)
+	req := request.Request{Client: client, Zone: zone, Transport: request.DOH, Endpoint: e.laddr, TraceID: request.NewTraceID()}
+	ctx, cancel := context.WithTimeout(r.Context(), endpoint.QueryTimeout)
+	defer cancel()
+	response, err := e.handleRequest(ctx, req, query)
+	if errors.Is(err, errDenied) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payload := dto.SerializeMessage(response)
+	e.lock.RLock()
+	e.metrics.Request("doh", len(query), len(payload))
+	e.lock.RUnlock()
+	// every dns response is a success, whatever its rcode (rfc8484 section 4.2.1)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl(response))
+	_, _ = w.Write(payload)
+}
+
+func (e *DOHEndpoint) handleRequest(ctx context.Context, req request.Request, buffer []byte) (dto.Message, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	access := e.acl.Check(req.Client)
+	if access == acl.Drop {
+		e.metrics.Denied("doh", access.String())
+		return dto.Message{}, errDenied
+	}
+	ctx, span := e.chain.Trace(ctx, req)
+	defer span.End(nil)
+	message, err := endpoint.ParseQuery(ctx, buffer)
+	if err != nil {
+		e.metrics.Error("doh", err)
+		return dto.Message{}, err
+	}
+	if access == acl.Refuse {
+		e.metrics.Denied("doh", access.String())
+		return dto.EmptyResponse(*message, dto.REFUSED), nil
+	}
+	return e.chain.ResolveRequest(ctx, req, *message), nil
+}
+
+// cacheControl returns the freshness of the response for the http caches: the smallest ttl of its answers,
+// the negative ttl of its zone without answer (rfc8484 section 5.1). The failures and the refusals, which depend
+// on the state of the server or on the client, are not stored. The answers depend on the client too, with its
+// group, its view or its blocking rules, so only its own cache may keep them, never a shared proxy
+func cacheControl(response dto.Message) string {
+	switch response.Header & dto.RCODE_MASK {
+	case dto.FORMAT_ERROR, dto.SERVER_FAILURE, dto.REFUSED:
+		return "no-store"
+	}
+	if len(response.Response) == 0 {
+		return "private, max-age=" + strconv.FormatUint(uint64(dto.NegativeTTL(&response)), 10)
+	}
+	ttl := response.Response[0].TTL
+	for _, record := range response.Response[1:] {
+		ttl = min(ttl, record.TTL)
+	}
+	return "private, max-age=" + strconv.FormatUint(uint64(ttl), 10)
+}
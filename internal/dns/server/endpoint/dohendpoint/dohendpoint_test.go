@@ -0,0 +1,310 @@
+package dohendpoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+)
+
+func newHandler() http.Handler {
+	return newEndpoint().Handler()
+}
+
+func newEndpoint() *DOHEndpoint {
+	memoryClient := inmemoryclient.InMemoryClient{}
+	memoryClient.Add("localhost", "127.0.0.1")
+	memoryClient.Add("localhost", "::1")
+
+	chain := resolver.NewResolverChain([]resolver.Resolver{
+		resolver.NewClientresolver(&memoryClient, "inMemory"),
+	})
+	return NewDOHEndpoint("127.0.0.1:0", "", "", "", chain)
+}
+
+func query(t dto.Type) []byte {
+	return dto.SerializeMessage(dto.Message{
+		ID:            0, // rfc8484 section 4.1
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "localhost", Type: t, Class: dto.IN}},
+	})
+}
+
+func TestDOHEndpoint(t *testing.T) {
+	handler := newHandler()
+
+	tests := []struct {
+		name       string
+		request    *http.Request
+		wantStatus int
+		wantData   string
+	}{
+		{
+			name:       "GET",
+			request:    httptest.NewRequest(http.MethodGet, DefaultPath+"?dns="+base64.RawURLEncoding.EncodeToString(query(dto.A)), nil),
+			wantStatus: http.StatusOK,
+			wantData:   "127.0.0.1",
+		},
+		{
+			name: "POST",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, DefaultPath, bytes.NewReader(query(dto.AAAA)))
+				r.Header.Set("Content-Type", contentType)
+				return r
+			}(),
+			wantStatus: http.StatusOK,
+			wantData:   "::1",
+		},
+		{
+			name:       "POST without content type",
+			request:    httptest.NewRequest(http.MethodPost, DefaultPath, bytes.NewReader(query(dto.A))),
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "GET without query",
+			request:    httptest.NewRequest(http.MethodGet, DefaultPath, nil),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "POST too large",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, DefaultPath, bytes.NewReader(make([]byte, maxMessageSize+1)))
+				r.Header.Set("Content-Type", contentType)
+				return r
+			}(),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "malformed query",
+			request:    httptest.NewRequest(http.MethodGet, DefaultPath+"?dns=AAAA", nil),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, tt.request)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if recorder.Header().Get("Content-Type") != contentType {
+				t.Errorf("content type = %s", recorder.Header().Get("Content-Type"))
+			}
+			if recorder.Header().Get("Cache-Control") != "private, max-age=200" {
+				t.Errorf("cache control = %s", recorder.Header().Get("Cache-Control"))
+			}
+			body, _ := io.ReadAll(recorder.Body)
+			message, err := dto.ParseMessage(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(message.Response) != 1 || message.Response[0].Data.String() != tt.wantData {
+				t.Errorf("unexpected response %v", message)
+			}
+		})
+	}
+}
+
+func TestDOHEndpoint_acl(t *testing.T) {
+	// the requests of httptest come from 192.0.2.1
+	tests := []struct {
+		name       string
+		allowed    string
+		denial     acl.Action
+		wantStatus int
+		wantRcode  uint16
+	}{
+		{name: "allowed", allowed: "192.0.2.0/24", denial: acl.Drop, wantStatus: http.StatusOK},
+		{name: "refused", allowed: "10.0.0.0/8", denial: acl.Refuse, wantStatus: http.StatusOK, wantRcode: dto.REFUSED},
+		{name: "dropped", allowed: "10.0.0.0/8", denial: acl.Drop, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, subnet, _ := net.ParseCIDR(tt.allowed)
+			e := newEndpoint()
+			e.SetACL(acl.NewACL([]*net.IPNet{subnet}, tt.denial))
+			recorder := httptest.NewRecorder()
+			e.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, DefaultPath+"?dns="+base64.RawURLEncoding.EncodeToString(query(dto.A)), nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			message, err := dto.ParseMessage(recorder.Body.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.Header&dto.RCODE_MASK != tt.wantRcode {
+				t.Errorf("rcode = %d, want %d", message.Header&dto.RCODE_MASK, tt.wantRcode)
+			}
+		})
+	}
+}
+
+func TestDOHEndpoint_sharedAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	// two tenants serve their own path on the same address
+	chain := newEndpoint().chain
+	first, second := NewDOHEndpoint(address, "/first", "", "", chain), NewDOHEndpoint(address, "/second", "", "", chain)
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	if err := first.Start(firstCtx, wg); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Start(secondCtx, wg); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDOHEndpoint(address, "/first", "", "", chain).Start(context.Background(), wg); err == nil {
+		t.Errorf("expecting an error for a path already served")
+	}
+
+	status := func(path string) int {
+		resp, err := http.Get("http://" + address + path + "?dns=" + base64.RawURLEncoding.EncodeToString(query(dto.A)))
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status("/first") != http.StatusOK || status("/second") != http.StatusOK {
+		t.Fatalf("both paths should be served, got %d and %d", status("/first"), status("/second"))
+	}
+
+	stopFirst()
+	time.Sleep(50 * time.Millisecond)
+	if got := status("/first"); got != http.StatusNotFound {
+		t.Errorf("the path of the stopped endpoint should not be served, got %d", got)
+	}
+	if got := status("/second"); got != http.StatusOK {
+		t.Errorf("the other path should still be served, got %d", got)
+	}
+
+	stopSecond()
+	wg.Wait()
+	if got := status("/second"); got != 0 {
+		t.Errorf("the address should no longer be listened on, got %d", got)
+	}
+}
+
+// selfSigned generates a certificate for name
+func selfSigned(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestDOHEndpoint_sharedTLS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	chain := newEndpoint().chain
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	for _, name := range []string{"first", "second"} {
+		e := NewDOHEndpoint(address, "/"+name, "", "", chain)
+		e.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{selfSigned(t, name+".test")}})
+		if err := e.Start(ctx, wg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := NewDOHEndpoint(address, "/plain", "", "", chain).Start(ctx, wg); err == nil {
+		t.Errorf("expecting an error for plain http on an https address")
+	}
+
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+	if name := state.PeerCertificates[0].Subject.CommonName; name != "second.test" {
+		t.Errorf("expecting the certificate of the endpoint joining last, got %s", name)
+	}
+	if state.NegotiatedProtocol != "h2" {
+		t.Errorf("expecting http/2 to be negotiated, got %q", state.NegotiatedProtocol)
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestCacheControl(t *testing.T) {
+	soa, err := dto.ParseRData(dto.SOA, "ns.lan. hostmaster.lan. 1 3600 600 86400 60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		response dto.Message
+		want     string
+	}{
+		{
+			name: "smallest ttl",
+			response: dto.Message{Header: dto.STANDARD_RESPONSE, Response: []dto.Record{
+				{Name: "a.lan", Type: dto.CNAME, TTL: 300}, {Name: "b.lan", Type: dto.A, TTL: 30},
+			}},
+			want: "private, max-age=30",
+		},
+		{
+			name:     "negative answer",
+			response: dto.Message{Header: dto.STANDARD_RESPONSE | dto.NAME_ERROR, Authority: []dto.Record{{Name: "lan", Type: dto.SOA, TTL: 3600, Raw: soa}}},
+			want:     "private, max-age=60",
+		},
+		{name: "server failure", response: dto.Message{Header: dto.STANDARD_RESPONSE | dto.SERVER_FAILURE}, want: "no-store"},
+		{name: "refused", response: dto.Message{Header: dto.STANDARD_RESPONSE | dto.REFUSED}, want: "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cacheControl(tt.response); got != tt.want {
+				t.Errorf("cacheControl() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package dohendpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+)
+
+// routes are the http servers of the doh endpoints by address, the endpoints of the tenants sharing an address
+// are served by the same server on their own path
+var routes = &router{servers: make(map[string]*route)}
+
+type router struct {
+	lock    sync.Mutex
+	servers map[string]*route
+}
+
+// route is the http server listening on an address, it dispatches the requests to the endpoints by path
+type route struct {
+	server   *http.Server
+	handlers map[string]http.Handler
+	mux      atomic.Pointer[http.ServeMux]
+	// tls is the tls configuration presented to the clients, nil for plain http
+	tls atomic.Pointer[tls.Config]
+}
+
+// config implements tls.Config.GetConfigForClient, the handshakes take the tls configuration of the route at the time
+func (r *route) config(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.tls.Load(), nil
+}
+
+// ServeHTTP implements http.Handler
+func (r *route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.Load().ServeHTTP(w, req)
+}
+
+// update rebuilds the mux of the handlers, the lock of the router must be held
+func (r *route) update() {
+	mux := http.NewServeMux()
+	for path, handler := range r.handlers {
+		mux.Handle(path, handler)
+	}
+	r.mux.Store(mux)
+}
+
+// join serves the handler on the path of the address, it listens on the address when no endpoint does yet.
+// The tls configuration of the endpoint joining last is presented to the clients of every path, the endpoints
+// of an address are all served with https or all with plain http.
+// It returns the key of the route to leave, the address actually listened on for a port chosen by the system
+func (r *router) join(ctx context.Context, address, path string, handler http.Handler, tlsConfig *tls.Config) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if existing, ok := r.servers[address]; ok && !ephemeral(address) {
+		if _, ok := existing.handlers[path]; ok {
+			return "", errors.New("the doh path " + path + " is already served on " + address)
+		}
+		if (existing.tls.Load() == nil) != (tlsConfig == nil) {
+			return "", errors.New("the doh path " + path + " cannot mix https and plain http on " + address)
+		}
+		existing.handlers[path] = handler
+		existing.update()
+		if tlsConfig != nil {
+			existing.tls.Store(withProtocols(tlsConfig))
+		}
+		return address, nil
+	}
+
+	listener, err := handoff.Listen(ctx, net.ListenConfig{}, "tcp", address)
+	if err != nil {
+		return "", errors.New("cannot listen on tcp " + address + ": " + err.Error())
+	}
+	key := address
+	if ephemeral(address) {
+		key = listener.Addr().String()
+	}
+	res := &route{handlers: map[string]http.Handler{path: handler}}
+	res.update()
+	res.server = &http.Server{Addr: address, Handler: res, ReadHeaderTimeout: readHeaderTimeout}
+	if tlsConfig != nil {
+		res.tls.Store(withProtocols(tlsConfig))
+		res.server.TLSConfig = &tls.Config{GetConfigForClient: res.config}
+	}
+	r.servers[key] = res
+	go serve(res.server, listener)
+	return key, nil
+}
+
+// leave stops serving the path of the route, it returns the server to shut down once no path is served on it
+func (r *router) leave(key, path string) *http.Server {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	existing, ok := r.servers[key]
+	if !ok {
+		return nil
+	}
+	delete(existing.handlers, path)
+	if len(existing.handlers) > 0 {
+		existing.update()
+		return nil
+	}
+	delete(r.servers, key)
+	return existing.server
+}
+
+// withProtocols returns a copy of the tls configuration negotiating http/2 and http/1.1 when it negotiates no protocol,
+// the protocols of the server are not applied to the configurations returned for the clients
+func withProtocols(config *tls.Config) *tls.Config {
+	res := config.Clone()
+	if len(res.NextProtos) == 0 {
+		res.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return res
+}
+
+// ephemeral tells whether the port of the address is chosen by the system, each endpoint then listens on its own
+func ephemeral(address string) bool {
+	_, port, err := net.SplitHostPort(address)
+	return err == nil && port == "0"
+}
+
+func serve(server *http.Server, listener net.Listener) {
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("doh endpoint failed", "address", server.Addr, "err", err)
+	}
+	logger.Info("doh endpoint stopped", "address", server.Addr)
+}
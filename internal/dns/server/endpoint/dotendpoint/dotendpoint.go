@@ -0,0 +1,70 @@
+package dotendpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint/certificate"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint/tcpendpoint"
+)
+
+var _ endpoint.Endpoint = &DOTEndpoint{}
+var _ endpoint.Instrumented = &DOTEndpoint{}
+var _ endpoint.Guarded = &DOTEndpoint{}
+
+// NewDOTEndpoint create a dns over tls endpoint presenting the certificate of the files
+func NewDOTEndpoint(address, certFile, keyFile string, chain *resolver.ResolverChain) *DOTEndpoint {
+	return &DOTEndpoint{
+		TCPEndpoint: tcpendpoint.NewTCPEndpoint(address, chain),
+		certFile:    certFile,
+		keyFile:     keyFile,
+	}
+}
+
+// DOTEndpoint serves dns over tls (rfc7858), the queries of the tcp endpoint over a tls connection.
+// The clients like the private dns of android only speak dns over tls
+type DOTEndpoint struct {
+	*tcpendpoint.TCPEndpoint
+	certFile string
+	keyFile  string
+	// reload is the interval the certificate files are checked at, zero when the certificate is never reloaded
+	reload time.Duration
+	// tlsConfig presents the certificates instead of the files when not nil
+	tlsConfig *tls.Config
+}
+
+// SetReload reloads the certificate when its files change, they are checked every interval.
+// It must be called before starting the endpoint
+func (e *DOTEndpoint) SetReload(interval time.Duration) {
+	e.reload = interval
+}
+
+// SetTLSConfig presents the certificates of the configuration, like the ones provisioned with acme, instead of the files.
+// It must be called before starting the endpoint
+func (e *DOTEndpoint) SetTLSConfig(config *tls.Config) {
+	e.tlsConfig = config
+}
+
+// Start implements endpoint.Endpoint, the certificate is loaded before listening
+func (e *DOTEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if e.tlsConfig != nil {
+		e.SetTLS(e.tlsConfig)
+		return e.TCPEndpoint.Start(ctx, wg)
+	}
+	certificates, err := certificate.Load(e.certFile, e.keyFile)
+	if err != nil {
+		return err
+	}
+	e.SetTLS(certificates.TLSConfig())
+	if err := e.TCPEndpoint.Start(ctx, wg); err != nil {
+		return err
+	}
+	if e.reload > 0 {
+		certificates.Watch(ctx, wg, e.reload)
+	}
+	return nil
+}
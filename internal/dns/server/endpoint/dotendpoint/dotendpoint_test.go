@@ -0,0 +1,122 @@
+package dotendpoint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
+)
+
+const (
+	addr       = "127.0.0.1:12353"
+	serverName = "dns.test"
+)
+
+// writeSelfSigned writes a certificate for serverName and its key in dir, it returns the pool trusting it
+func writeSelfSigned(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestDotEndpoint(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSigned(t, t.TempDir())
+	memoryClient := inmemoryclient.InMemoryClient{}
+	memoryClient.Add("localhost", "127.0.0.1")
+	chain := resolver.NewResolverChain([]resolver.Resolver{
+		resolver.NewClientresolver(&memoryClient, "inMemory"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	endpoint := NewDOTEndpoint(addr, certFile, keyFile, chain)
+	endpoint.SetReload(time.Minute)
+	if err := endpoint.Start(ctx, &wg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, ServerName: serverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	query := dto.Message{
+		ID:            7,
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "localhost", Type: dto.A, Class: dto.IN}},
+	}
+	if err := framing.Write(conn, dto.SerializeMessage(query)); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := framing.Read(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := dto.ParseMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != query.ID || len(res.Response) != 1 || res.Response[0].Data.String() != "127.0.0.1" {
+		t.Fatalf("unexpected response %v", res)
+	}
+}
+
+func TestDotEndpoint_MissingCertificate(t *testing.T) {
+	dir := t.TempDir()
+	endpoint := NewDOTEndpoint(addr, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), resolver.NewResolverChain(nil))
+	wg := sync.WaitGroup{}
+	if err := endpoint.Start(context.Background(), &wg); err == nil {
+		t.Fatal("expecting an error without certificate")
+	}
+	wg.Wait()
+}
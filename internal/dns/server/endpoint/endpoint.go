@@ -3,12 +3,82 @@ package endpoint
 import (
 	"context"
 	"sync"
+	"time"
 
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/metrics"
 	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/tracing"
+)
+
+// QueryTimeout is the time a query is resolved for, the stub resolvers give up and ask again after a few seconds.
+// The context of a query is not derived from the one of its endpoint, so the queries in progress are answered during a reload
+const QueryTimeout = 5 * time.Second
+
+// DefaultDrainTimeout is the time a stopped endpoint answers the queries it already received when SetDrainTimeout is not called
+const DefaultDrainTimeout = 5 * time.Second
+
+// MinRetryDelay and MaxRetryDelay bound the wait of an endpoint before it listens or accepts again after a failure
+// of its socket
+const (
+	MinRetryDelay = 50 * time.Millisecond
+	MaxRetryDelay = 10 * time.Second
 )
 
 // Endpoint represents a server endpoint to serve dns
 type Endpoint interface {
-	Start(context.Context, *sync.WaitGroup)
+	// Start listens and serves in background until ctx is done, the endpoint is added to wg while it runs.
+	// An endpoint unable to listen returns the error and runs nothing
+	Start(ctx context.Context, wg *sync.WaitGroup) error
 	SetChain(chain *resolver.ResolverChain)
 }
+
+// Guarded is an endpoint answering only the clients allowed by an acl
+type Guarded interface {
+	SetACL(a *acl.ACL)
+}
+
+// Drained is an endpoint which stops accepting queries once stopped but answers the ones it already received,
+// for up to the drain timeout, so the clients do not time out during a restart
+type Drained interface {
+	SetDrainTimeout(timeout time.Duration)
+}
+
+// Instrumented is an endpoint reporting its traffic in the metrics
+type Instrumented interface {
+	SetMetrics(m *metrics.Metrics)
+}
+
+// ParseQuery parses the query received by an endpoint, the parse is a span of the query when ctx carries its trace
+func ParseQuery(ctx context.Context, buffer []byte) (*dto.Message, error) {
+	_, span := tracing.Start(ctx, "parse")
+	message, err := dto.ParseMessage(buffer)
+	span.SetInt("dns.query.size", int64(len(buffer)))
+	span.End(err)
+	return message, err
+}
+
+// Backoff is the wait of an endpoint between its attempts to recover from the failures of its socket,
+// doubled after every failed attempt up to MaxRetryDelay. The zero value starts at MinRetryDelay
+type Backoff struct {
+	delay time.Duration
+}
+
+// Wait waits for the delay of the next attempt, it returns false when ctx is done first
+func (b *Backoff) Wait(ctx context.Context) bool {
+	b.delay = min(max(2*b.delay, MinRetryDelay), MaxRetryDelay)
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Reset starts the delays over once the socket works again
+func (b *Backoff) Reset() {
+	b.delay = 0
+}
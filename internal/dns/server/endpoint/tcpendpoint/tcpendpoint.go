@@ -0,0 +1,256 @@
+package tcpendpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/metrics"
+	"github.com/bluguard/dnshield/internal/dns/ratelimit"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("tcpendpoint")
+
+const (
+	// idle time after which a client connection is closed, see rfc7766 section 6.2.3
+	idleTimeout  = 10 * time.Second
+	writeTimeout = 2 * time.Second
+)
+
+var _ endpoint.Endpoint = &TCPEndpoint{}
+var _ endpoint.Instrumented = &TCPEndpoint{}
+var _ endpoint.Guarded = &TCPEndpoint{}
+var _ endpoint.Drained = &TCPEndpoint{}
+
+// errDenied closes the connection of a client dropped by the acl
+var errDenied = errors.New("client not allowed")
+
+// NewTCPEndpoint create a new tcp endpoint with the given chain
+func NewTCPEndpoint(address string, chain *resolver.ResolverChain) *TCPEndpoint {
+	return &TCPEndpoint{
+		laddr:     address,
+		chain:     chain,
+		lock:      sync.RWMutex{},
+		started:   atomic.Bool{},
+		transport: request.TCP,
+		drain:     endpoint.DefaultDrainTimeout,
+	}
+}
+
+// TCPEndpoint endpoint based on tcp protocol, messages are prefixed by their length on two bytes
+type TCPEndpoint struct {
+	laddr   string
+	chain   *resolver.ResolverChain
+	lock    sync.RWMutex
+	started atomic.Bool
+	metrics *metrics.Metrics
+	// limiter is nil when the queries are not limited
+	limiter *ratelimit.Limiter
+	// acl is nil when every client is allowed
+	acl *acl.ACL
+	// tlsConfig is nil when the connections are not encrypted
+	tlsConfig *tls.Config
+	transport request.Transport
+	// drain is the time the query in progress on a connection is answered for once stopped
+	drain time.Duration
+}
+
+// SetChain implements endpoint.Endpoint
+func (e *TCPEndpoint) SetChain(chain *resolver.ResolverChain) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.chain = chain
+}
+
+// SetMetrics implements endpoint.Instrumented
+func (e *TCPEndpoint) SetMetrics(m *metrics.Metrics) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.metrics = m
+}
+
+// SetACL implements endpoint.Guarded
+func (e *TCPEndpoint) SetACL(a *acl.ACL) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.acl = a
+}
+
+// SetDrainTimeout implements endpoint.Drained
+func (e *TCPEndpoint) SetDrainTimeout(timeout time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.drain = timeout
+}
+
+// SetRateLimiter limits the queries of every client, it must be called before starting the endpoint
+func (e *TCPEndpoint) SetRateLimiter(l *ratelimit.Limiter) {
+	e.limiter = l
+}
+
+// SetTLS serves dns over tls (rfc7858) with the configuration, it must be called before starting the endpoint
+func (e *TCPEndpoint) SetTLS(config *tls.Config) {
+	e.tlsConfig = config
+	e.transport = request.DOT
+}
+
+// Start implements endpoint.Endpoint
+func (e *TCPEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if !e.started.CompareAndSwap(false, true) {
+		panic("endpoint is already started")
+	}
+	logger.Info("starting "+string(e.transport)+" endpoint", "address", e.laddr)
+	listener, err := handoff.Listen(ctx, net.ListenConfig{}, "tcp", e.laddr)
+	if err != nil {
+		return errors.New("cannot listen on tcp " + e.laddr + ": " + err.Error())
+	}
+	if e.tlsConfig != nil {
+		// the socket handed over on upgrade is the tcp one, the tls sessions are not
+		listener = tls.NewListener(listener, e.tlsConfig)
+	}
+	wg.Add(1)
+	go e.run(ctx, wg, listener)
+	return nil
+}
+
+func (e *TCPEndpoint) run(ctx context.Context, ewg *sync.WaitGroup, listener net.Listener) {
+	defer ewg.Done()
+
+	iwg := &sync.WaitGroup{}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	// the failures to accept, like running out of descriptors, last a while, the endpoint waits before accepting again
+	backoff := endpoint.Backoff{}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			logger.Warn("cannot accept the connection", "address", e.laddr, "err", err)
+			if !backoff.Wait(ctx) {
+				break
+			}
+			continue
+		}
+		backoff.Reset()
+		iwg.Add(1)
+		go e.serve(ctx, conn, iwg)
+	}
+
+	iwg.Wait()
+	logger.Info(string(e.transport)+" endpoint stopped", "address", e.laddr)
+}
+
+// serve answers the queries of a client until it closes the connection or stays idle.
+// Once ctx is done no other query is read, the one in progress is answered unless the drain timeout is over first
+func (e *TCPEndpoint) serve(ctx context.Context, conn net.Conn, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		// the read of an idle connection returns at once, the connection is closed once the drain is over
+		_ = conn.SetReadDeadline(time.Now())
+		e.lock.RLock()
+		drain := time.NewTimer(e.drain)
+		e.lock.RUnlock()
+		defer drain.Stop()
+		select {
+		case <-drain.C:
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	client, zone := request.SplitClient(conn.RemoteAddr().String())
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		// checked after the deadline is set, so a stop in between still interrupts the read
+		if ctx.Err() != nil {
+			return
+		}
+		buffer, err := framing.Read(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !isTimeout(err) {
+				logger.Warn("cannot read the query", "client", client, "err", err)
+			}
+			return
+		}
+		req := request.Request{Client: client, Zone: zone, Transport: e.transport, Endpoint: e.laddr, TraceID: request.NewTraceID()}
+		payload, err := e.handleRequest(req, buffer)
+		if errors.Is(err, errDenied) {
+			return
+		}
+		if err != nil {
+			logger.Warn("cannot handle the query", "client", client, "err", err)
+			return
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := framing.Write(conn, payload); err != nil {
+			logger.Warn("cannot write the response", "client", client, "err", err)
+			return
+		}
+	}
+}
+
+func (e *TCPEndpoint) handleRequest(req request.Request, buffer []byte) ([]byte, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	access := e.acl.Check(req.Client)
+	if access == acl.Drop {
+		e.metrics.Denied(string(e.transport), access.String())
+		return nil, errDenied
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), endpoint.QueryTimeout)
+	defer cancel()
+	ctx, span := e.chain.Trace(ctx, req)
+	defer span.End(nil)
+	message, err := endpoint.ParseQuery(ctx, buffer)
+	if err != nil {
+		e.metrics.Error(string(e.transport), err)
+		if response, ok := dto.FormatErrorResponse(buffer); ok {
+			return dto.SerializeMessage(response), nil
+		}
+		return nil, err
+	}
+	if access == acl.Refuse {
+		e.metrics.Denied(string(e.transport), access.String())
+		return dto.SerializeMessage(dto.EmptyResponse(*message, dto.REFUSED)), nil
+	}
+	if e.limiter.Allow(req.Client) != ratelimit.Allow {
+		// the address of a tcp client cannot be spoofed, it is told to slow down instead of being ignored
+		e.metrics.RateLimited(string(e.transport), "refuse")
+		return dto.SerializeMessage(dto.EmptyResponse(*message, dto.REFUSED)), nil
+	}
+	payload := dto.SerializeMessage(e.chain.ResolveRequest(ctx, req, *message))
+	e.metrics.Request(string(e.transport), len(buffer), len(payload))
+	return payload, nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,91 @@
+package tcpendpoint
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/util/framing"
+)
+
+const addr = "127.0.0.1:12351"
+
+func TestMain(m *testing.M) {
+
+	memoryClient := inmemoryclient.InMemoryClient{}
+	memoryClient.Add("localhost", "127.0.0.1")
+	memoryClient.Add("localhost", "::1")
+
+	chain := resolver.NewResolverChain([]resolver.Resolver{
+		resolver.NewClientresolver(&memoryClient, "inMemory"),
+	})
+
+	endpoint := NewTCPEndpoint(addr, chain)
+
+	//start endpoint
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	if err := endpoint.Start(ctx, &wg); err != nil {
+		panic(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	res := m.Run()
+	cancel()
+	wg.Wait()
+	os.Exit(res)
+}
+
+func TestTcpEndpoint(t *testing.T) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// several queries on the same connection
+	for i, question := range []dto.Question{
+		{Name: "localhost", Type: dto.A, Class: dto.IN},
+		{Name: "localhost", Type: dto.AAAA, Class: dto.IN},
+	} {
+		query := dto.Message{
+			ID:            uint16(i),
+			Header:        dto.STANDARD_QUERY,
+			QuestionCount: 1,
+			Question:      []dto.Question{question},
+		}
+		if err := framing.Write(conn, dto.SerializeMessage(query)); err != nil {
+			t.Fatal(err)
+		}
+		payload, err := framing.Read(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := dto.ParseMessage(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ID != query.ID || len(res.Response) != 1 || res.Response[0].Type != question.Type {
+			t.Fatalf("unexpected response %v for %v", res, question)
+		}
+	}
+}
+
+func TestTcpEndpoint_AddressInUse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := sync.WaitGroup{}
+	endpoint := NewTCPEndpoint(addr, resolver.NewResolverChain(nil))
+	if err := endpoint.Start(ctx, &wg); err == nil {
+		t.Fatal("expecting an error listening on the address of the running endpoint")
+	}
+	// nothing runs after a failed start
+	wg.Wait()
+}
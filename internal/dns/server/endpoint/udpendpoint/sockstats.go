@@ -0,0 +1,46 @@
+package udpendpoint
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SocketStats implements metrics.SocketCounters with the receive queues of the sockets of the workers
+func (e *UDPEndpoint) SocketStats() (uint64, uint64, error) {
+	e.lock.RLock()
+	inodes := e.inodes
+	e.lock.RUnlock()
+	return readSocketStats(inodes)
+}
+
+// parseSocketStats reads the table of the sockets of the kernel, in the format of /proc/net/udp, and returns
+// the bytes in the receive queues and the datagrams dropped of the sockets with the inodes, with their number
+func parseSocketStats(r io.Reader, inodes map[uint64]bool) (queued, drops uint64, found int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ref pointer drops
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || !inodes[inode] {
+			continue
+		}
+		_, rx, _ := strings.Cut(fields[4], ":")
+		size, err := strconv.ParseUint(rx, 16, 64)
+		if err != nil {
+			return 0, 0, 0, errors.New("invalid receive queue " + fields[4])
+		}
+		dropped, err := strconv.ParseUint(fields[12], 10, 64)
+		if err != nil {
+			return 0, 0, 0, errors.New("invalid drops " + fields[12])
+		}
+		queued, drops, found = queued+size, drops+dropped, found+1
+	}
+	return queued, drops, found, scanner.Err()
+}
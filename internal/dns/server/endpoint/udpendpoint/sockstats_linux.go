@@ -0,0 +1,54 @@
+//go:build linux
+
+package udpendpoint
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// socketTables are the tables of the udp sockets of the kernel, for ipv4 then ipv6
+var socketTables = []string{"/proc/net/udp", "/proc/net/udp6"}
+
+// socketInode returns the inode of the socket, identifying it in the tables of the kernel
+func socketInode(conn *net.UDPConn) (uint64, bool) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var stat syscall.Stat_t
+	statErr := errors.New("no descriptor")
+	if err := raw.Control(func(descriptor uintptr) {
+		statErr = syscall.Fstat(int(descriptor), &stat)
+	}); err != nil || statErr != nil {
+		return 0, false
+	}
+	return uint64(stat.Ino), true
+}
+
+// readSocketStats returns the bytes in the receive queues and the datagrams dropped by the kernel of the sockets
+func readSocketStats(inodes map[uint64]bool) (uint64, uint64, error) {
+	var queued, drops uint64
+	count := 0
+	for _, path := range socketTables {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // no ipv6
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		q, d, found, err := parseSocketStats(file, inodes)
+		_ = file.Close()
+		if err != nil {
+			return 0, 0, errors.New("cannot read " + path + ": " + err.Error())
+		}
+		queued, drops, count = queued+q, drops+d, count+found
+	}
+	if count == 0 {
+		return 0, 0, errors.New("the sockets are not in the tables of the kernel")
+	}
+	return queued, drops, nil
+}
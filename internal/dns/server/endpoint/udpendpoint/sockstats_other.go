@@ -0,0 +1,18 @@
+//go:build !linux
+
+package udpendpoint
+
+import (
+	"errors"
+	"net"
+)
+
+// socketInode is only known on linux
+func socketInode(_ *net.UDPConn) (uint64, bool) {
+	return 0, false
+}
+
+// readSocketStats is only supported on linux
+func readSocketStats(_ map[uint64]bool) (uint64, uint64, error) {
+	return 0, 0, errors.New("the socket statistics are only read on linux")
+}
@@ -0,0 +1,44 @@
+package udpendpoint
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseSocketStats(t *testing.T) {
+	table := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  133: 00000000:0035 00000000:0000 07 00000000:00000200 00:00000000 00000000     0        0 21843 2 0000000000000000 12
+  134: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 21844 2 0000000000000000 3
+  290: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 17012 2 0000000000000000 40
+`
+	queued, drops, found, err := parseSocketStats(strings.NewReader(table), map[uint64]bool{21843: true, 21844: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued != 512 || drops != 15 || found != 2 {
+		t.Errorf("expecting 512 bytes queued and 15 drops on 2 sockets, got %d, %d on %d", queued, drops, found)
+	}
+	if _, _, found, _ := parseSocketStats(strings.NewReader(table), map[uint64]bool{1: true}); found != 0 {
+		t.Errorf("expecting no socket, got %d", found)
+	}
+}
+
+func TestSocketInode(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the socket statistics are only read on linux")
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	inode, ok := socketInode(conn)
+	if !ok {
+		t.Fatal("expecting the inode of the socket")
+	}
+	if _, drops, err := readSocketStats(map[uint64]bool{inode: true}); err != nil || drops != 0 {
+		t.Errorf("expecting no drop, got %d, %v", drops, err)
+	}
+}
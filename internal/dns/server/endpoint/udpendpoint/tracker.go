@@ -0,0 +1,106 @@
+package udpendpoint
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+)
+
+// responseWindow is the time a query can be answered after its arrival: it is resolved until endpoint.QueryTimeout
+// after its arrival, its response, a failure at worst, is then serialized and sent within the margin
+const responseWindow = endpoint.QueryTimeout + time.Second
+
+// verdicts of the tracker, used as reason by the metrics
+const (
+	expected = ""
+	unknown  = "unknown"
+	expired  = "expired"
+)
+
+// flow is the 4-tuple and the id of a query
+type flow struct {
+	client netip.AddrPort
+	local  netip.AddrPort
+	id     uint16
+}
+
+func newFlow(client *net.UDPAddr, conn *net.UDPConn, id uint16) flow {
+	res := flow{client: client.AddrPort(), id: id}
+	if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		res.local = local.AddrPort()
+	}
+	return res
+}
+
+type pending struct {
+	arrival time.Time
+	count   int
+}
+
+// tracker remembers the queries waiting for their response, so the responses only go back to the address
+// and port a query came from, through the socket that received it, while the query is recent.
+// The queries and the sockets are decoupled by the inbox of the endpoint, the tracker checks
+// nothing got mixed up before a response leaves
+type tracker struct {
+	lock    sync.Mutex
+	window  time.Duration
+	pending map[flow]*pending
+}
+
+func newTracker(window time.Duration) *tracker {
+	return &tracker{window: window, pending: make(map[flow]*pending)}
+}
+
+// track records the arrival of a query
+func (t *tracker) track(f flow, arrival time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	p, ok := t.pending[f]
+	if !ok {
+		if len(t.pending) >= maxPending {
+			t.purge(arrival)
+		}
+		t.pending[f] = &pending{arrival: arrival, count: 1}
+		return
+	}
+	// a retransmission keeps the window of the first arrival
+	p.count++
+}
+
+// release forgets a query and returns expected when its response can be sent,
+// unknown when no such query is waiting, expired when it arrived too long ago
+func (t *tracker) release(f flow, now time.Time) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	p, ok := t.pending[f]
+	if !ok {
+		return unknown
+	}
+	p.count--
+	if p.count == 0 {
+		delete(t.pending, f)
+	}
+	if now.Sub(p.arrival) > t.window {
+		return expired
+	}
+	return expected
+}
+
+// purge forgets the expired queries, whose response was never released
+func (t *tracker) purge(now time.Time) {
+	for f, p := range t.pending {
+		if now.Sub(p.arrival) > t.window {
+			delete(t.pending, f)
+		}
+	}
+}
+
+// len returns the number of queries waiting for their response
+func (t *tracker) len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.pending)
+}
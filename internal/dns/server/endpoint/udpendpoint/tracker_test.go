@@ -0,0 +1,59 @@
+package udpendpoint
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+)
+
+func TestTracker_release(t *testing.T) {
+	now := time.Now()
+	local := netip.MustParseAddrPort("127.0.0.1:53")
+	query := flow{client: netip.MustParseAddrPort("192.168.1.20:40000"), local: local, id: 42}
+
+	tests := []struct {
+		name     string
+		response flow
+		at       time.Time
+		want     string
+	}{
+		{name: "same flow", response: query, at: now.Add(time.Second), want: expected},
+		{name: "other port", response: flow{client: netip.MustParseAddrPort("192.168.1.20:40001"), local: local, id: 42}, at: now, want: unknown},
+		{name: "other address", response: flow{client: netip.MustParseAddrPort("203.0.113.7:40000"), local: local, id: 42}, at: now, want: unknown},
+		{name: "other socket", response: flow{client: query.client, local: netip.MustParseAddrPort("127.0.0.1:5353"), id: 42}, at: now, want: unknown},
+		{name: "other id", response: flow{client: query.client, local: local, id: 43}, at: now, want: unknown},
+		// a query failing at its timeout is still answered
+		{name: "at the query deadline", response: query, at: now.Add(endpoint.QueryTimeout + 10*time.Millisecond), want: expected},
+		{name: "too late", response: query, at: now.Add(responseWindow + time.Second), want: expired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTracker(responseWindow)
+			tr.track(query, now)
+			if got := tr.release(tt.response, tt.at); got != tt.want {
+				t.Errorf("tracker.release() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracker_retransmission(t *testing.T) {
+	now := time.Now()
+	query := flow{client: netip.MustParseAddrPort("192.168.1.20:40000"), local: netip.MustParseAddrPort("127.0.0.1:53"), id: 42}
+	tr := newTracker(responseWindow)
+	tr.track(query, now)
+	tr.track(query, now.Add(time.Second))
+	for i := 0; i < 2; i++ {
+		if got := tr.release(query, now.Add(2*time.Second)); got != expected {
+			t.Fatalf("expecting both responses to be sent, got %q", got)
+		}
+	}
+	if got := tr.release(query, now.Add(2*time.Second)); got != unknown {
+		t.Errorf("expecting a third response to be withheld, got %q", got)
+	}
+	if tr.len() != 0 {
+		t.Errorf("expecting no pending query, got %d", tr.len())
+	}
+}
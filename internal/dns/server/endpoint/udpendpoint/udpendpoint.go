@@ -2,30 +2,51 @@ package udpendpoint
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
-	"log"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/bluguard/dnshield/internal/dns/acl"
 	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/metrics"
+	"github.com/bluguard/dnshield/internal/dns/ratelimit"
+	"github.com/bluguard/dnshield/internal/dns/request"
 	"github.com/bluguard/dnshield/internal/dns/resolver"
 	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
 )
 
+var logger = logging.Component("udpendpoint")
+
 const (
 	udpTimeout = 200 * time.Millisecond
-	workers    = 10
-	maxPending = 1000
+	// defaultWorkers is the number of sockets when SetWorkers is not called
+	defaultWorkers = 10
+	maxPending     = 1000
+	// defaultHandlers is the number of queries resolved at once when SetHandlers is not called
+	defaultHandlers = 64
+	// headerLength is the length of the header of a dns message, starting with the id
+	headerLength = 12
 )
 
 var _ endpoint.Endpoint = &UDPEndpoint{}
+var _ endpoint.Instrumented = &UDPEndpoint{}
+var _ endpoint.Guarded = &UDPEndpoint{}
+var _ endpoint.Drained = &UDPEndpoint{}
+var _ metrics.SocketCounters = &UDPEndpoint{}
 
 type question struct {
+	// buffer is the buffer of the pool holding the message, returned to the pool once the query is answered
+	buffer      *[]byte
 	message     []byte
 	destination net.UDPAddr
 	arrival     time.Time
+	// conn is the socket which received the query, the response leaves through it
+	conn *net.UDPConn
 }
 
 // NewUDPEndpoint create a new udp enpoint with the given chain
@@ -35,8 +56,12 @@ func NewUDPEndpoint(address string, chain *resolver.ResolverChain) *UDPEndpoint
 		chain:      chain,
 		lock:       sync.RWMutex{},
 		started:    atomic.Bool{},
+		workers:    defaultWorkers,
+		handlers:   defaultHandlers,
+		drain:      endpoint.DefaultDrainTimeout,
 		inbox:      make(chan question, maxPending),
-		bufferPool: sync.Pool{New: func() any { return make([]byte, dto.BufferMaxLength) }},
+		tracker:    newTracker(responseWindow),
+		bufferPool: sync.Pool{New: newBuffer},
 	}
 }
 
@@ -46,8 +71,20 @@ type UDPEndpoint struct {
 	chain      *resolver.ResolverChain
 	lock       sync.RWMutex
 	started    atomic.Bool
+	workers    int
+	handlers   int
 	inbox      chan question
+	tracker    *tracker
 	bufferPool sync.Pool
+	metrics    *metrics.Metrics
+	// limiter is nil when the queries are not limited
+	limiter *ratelimit.Limiter
+	// acl is nil when every client is allowed
+	acl *acl.ACL
+	// inodes identify the sockets of the workers in the tables of the kernel
+	inodes map[uint64]bool
+	// drain is the time the queries received before the stop are answered for, the later ones are dropped
+	drain time.Duration
 }
 
 // SetChain implements server.Endpoint
@@ -57,137 +94,332 @@ func (e *UDPEndpoint) SetChain(chain *resolver.ResolverChain) {
 	e.chain = chain
 }
 
+// SetMetrics implements endpoint.Instrumented
+func (e *UDPEndpoint) SetMetrics(m *metrics.Metrics) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.metrics = m
+}
+
+// SetACL implements endpoint.Guarded
+func (e *UDPEndpoint) SetACL(a *acl.ACL) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.acl = a
+}
+
+// SetDrainTimeout implements endpoint.Drained
+func (e *UDPEndpoint) SetDrainTimeout(timeout time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.drain = timeout
+}
+
+// SetRateLimiter limits the queries of every client, it must be called before starting the endpoint
+func (e *UDPEndpoint) SetRateLimiter(l *ratelimit.Limiter) {
+	e.limiter = l
+}
+
+// SetWorkers sets the number of sockets reading the queries, it must be called before starting the endpoint
+func (e *UDPEndpoint) SetWorkers(n int) {
+	if n > 0 {
+		e.workers = n
+	}
+}
+
+// SetHandlers sets the number of queries resolved at once, the other ones wait in the inbox.
+// It must be called before starting the endpoint
+func (e *UDPEndpoint) SetHandlers(n int) {
+	if n > 0 {
+		e.handlers = n
+	}
+}
+
 // Start implements server.Endpoint
-func (e *UDPEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) {
+func (e *UDPEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
 	if !e.started.CompareAndSwap(false, true) {
 		panic("endpoint is already started")
 	}
-	log.Println("starting udp endpoint on", e.laddr)
-	go e.run(ctx, wg)
+	logger.Info("starting udp endpoint", "address", e.laddr)
+	conns, err := e.populateConn(ctx, e.workers)
+	if err != nil {
+		return errors.New("cannot listen on udp " + e.laddr + ": " + err.Error())
+	}
+	inodes := make(map[uint64]bool, len(conns))
+	for _, conn := range conns {
+		if inode, ok := socketInode(conn); ok {
+			inodes[inode] = true
+		}
+	}
+	e.lock.Lock()
+	e.inodes = inodes
+	e.metrics.SetSocket("udp", e.laddr, e)
+	e.lock.Unlock()
+	wg.Add(1)
+	go e.run(ctx, wg, conns)
+	return nil
 }
 
-func (e *UDPEndpoint) run(ctx context.Context, ewg *sync.WaitGroup) {
+// run receives the queries until ctx is done, the queries already received are then answered until the inbox is empty
+// or the drain timeout is over, the sockets are closed once the last response is sent
+func (e *UDPEndpoint) run(ctx context.Context, ewg *sync.WaitGroup, conns []*net.UDPConn) {
 	defer ewg.Done()
-
-	iwg := &sync.WaitGroup{}
-
-	conns := e.populateConn(ctx, workers)
 	defer closeAll(conns)
 
-	// start the receiving loop
-	// tart the workers
-	iwg.Add(workers * 2)
-	for i := 0; i < workers; i++ {
-		go e.receivingLoop(ctx, conns[i], iwg)
-		go e.handler(ctx, conns[i], iwg)
+	expired := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		e.lock.RLock()
+		drain := e.drain
+		e.lock.RUnlock()
+		logger.Info("draining udp endpoint", "address", e.laddr, "pending", len(e.inbox))
+		time.AfterFunc(drain, func() { close(expired) })
+	}()
+
+	rwg, hwg := &sync.WaitGroup{}, &sync.WaitGroup{}
+	rwg.Add(len(conns))
+	for i := range conns {
+		go e.receivingLoop(ctx, conns, i, rwg)
+	}
+	// a slow resolution holds a handler only, the queries of the other clients are answered by the other handlers
+	hwg.Add(e.handlers)
+	for i := 0; i < e.handlers; i++ {
+		go e.handler(expired, hwg)
 	}
 
-	iwg.Wait()
-	log.Println("udp endpoint on", e.laddr, "stopped")
+	// no query is queued once the receiving loops are over, the handlers stop at the end of the inbox
+	rwg.Wait()
+	close(e.inbox)
+	hwg.Wait()
+	logger.Info("udp endpoint stopped", "address", e.laddr)
 }
 
-func (e *UDPEndpoint) receivingLoop(ctx context.Context, udpConn *net.UDPConn, wg *sync.WaitGroup) {
-	// Main loop
+// receivingLoop receives the queries of the socket i of conns until ctx is done. A failed socket is closed and replaced
+// in conns by a new one listening on the address, retried with backoff, so the other sockets keep serving meanwhile
+func (e *UDPEndpoint) receivingLoop(ctx context.Context, conns []*net.UDPConn, i int, wg *sync.WaitGroup) {
 	defer wg.Done()
-	defer udpConn.Close()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			e.receive(udpConn)
+	backoff := endpoint.Backoff{}
+	for ctx.Err() == nil {
+		err := e.receive(conns[i])
+		if err == nil {
+			continue
 		}
+		logger.Error("udp socket failed, listening again", "address", e.laddr, "err", err)
+		failed, _ := socketInode(conns[i])
+		_ = conns[i].Close()
+		for {
+			if !backoff.Wait(ctx) {
+				return
+			}
+			conn, err := e.listen(ctx, len(conns))
+			if err == nil {
+				e.replaceSocket(failed, conn)
+				conns[i] = conn
+				break
+			}
+			logger.Warn("cannot listen on udp again", "address", e.laddr, "err", err)
+		}
+		backoff.Reset()
+		logger.Info("udp socket listening again", "address", e.laddr)
 	}
 }
 
-func (e *UDPEndpoint) receive(udpConn *net.UDPConn) {
-	buff := e.getBuffer()
+// receive queues the next query of the socket, the error is the failure of the socket, nil when no query came in time
+func (e *UDPEndpoint) receive(udpConn *net.UDPConn) error {
+	buffer := e.getBuffer()
+	buff := *buffer
 	_ = udpConn.SetReadDeadline(time.Now().Add(udpTimeout))
 	n, addr, err := udpConn.ReadFromUDP(buff)
 	if err != nil {
-		if err, ok := err.(net.Error); ok && (err.Timeout() || errors.Is(err, net.ErrClosed)) {
-			return
+		// the buffer goes back to the pool, an idle endpoint does not allocate one every timeout
+		e.recycle(buffer)
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return nil
 		}
-		panic(err)
+		return err
+	}
+	// a query shorter than a header cannot be answered, nor can a client without source port
+	if n < headerLength || addr.Port == 0 {
+		e.recycle(buffer)
+		return nil
 	}
-	e.inbox <- question{message: buff[0:n], destination: *addr, arrival: time.Now()}
+	q := question{buffer: buffer, message: buff[0:n], destination: *addr, arrival: time.Now(), conn: udpConn}
+	e.tracker.track(newFlow(addr, udpConn, binary.BigEndian.Uint16(buff)), q.arrival)
+	e.inbox <- q
+	return nil
 }
 
-func (e *UDPEndpoint) handler(ctx context.Context, udpConn *net.UDPConn, wg *sync.WaitGroup) {
+// replaceSocket counts the queues of the new socket of a worker instead of the ones of its failed socket
+func (e *UDPEndpoint) replaceSocket(failed uint64, conn *net.UDPConn) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	inodes := make(map[uint64]bool, len(e.inodes))
+	for inode := range e.inodes {
+		inodes[inode] = true
+	}
+	delete(inodes, failed)
+	if inode, ok := socketInode(conn); ok {
+		inodes[inode] = true
+	}
+	e.inodes = inodes
+}
+
+// handler answers the queries of the inbox until it is closed, the ones left once the drain is expired are dropped
+func (e *UDPEndpoint) handler(expired <-chan struct{}, wg *sync.WaitGroup) {
 	defer wg.Done()
-	for {
+	for msg := range e.inbox {
 		select {
-		case <-ctx.Done():
-			return
-		case msg := <-e.inbox:
-			e.handleRequest(msg.message, &msg.destination, udpConn)
-			e.recycle(msg.message)
+		case <-expired:
+			e.reply(msg, nil)
+		default:
+			e.reply(msg, e.handleRequest(msg.message, &msg.destination, msg.arrival))
 		}
+		e.recycle(msg.buffer)
 	}
 }
 
-func (e *UDPEndpoint) handleRequest(buffer []byte, dest *net.UDPAddr, udpConn *net.UDPConn) {
+// handleRequest returns the response of the query, nil when the query is dropped. The query is resolved until
+// endpoint.QueryTimeout after its arrival, the time it waited in the inbox included, so it is answered within the
+// window of the tracker
+func (e *UDPEndpoint) handleRequest(buffer []byte, dest *net.UDPAddr, arrival time.Time) []byte {
 	e.lock.RLock()
 	defer e.lock.RUnlock()
-	message, err := dto.ParseMessage(buffer)
+	access := e.acl.Check(dest.IP)
+	if access == acl.Drop {
+		e.metrics.Denied("udp", access.String())
+		return nil
+	}
+	action := e.limiter.Allow(dest.IP)
+	if action == ratelimit.Drop {
+		e.metrics.RateLimited("udp", "drop")
+		return nil
+	}
+	req := request.Request{Client: dest.IP, Zone: dest.Zone, Transport: request.UDP, Endpoint: e.laddr, TraceID: request.NewTraceID()}
+	ctx, cancel := context.WithDeadline(context.Background(), arrival.Add(endpoint.QueryTimeout))
+	defer cancel()
+	ctx, span := e.chain.Trace(ctx, req)
+	defer span.End(nil)
+	message, err := endpoint.ParseQuery(ctx, buffer)
 	if err != nil {
-		log.Println(err)
+		logger.Debug("cannot parse the query", "client", dest, "err", err)
+		e.metrics.Error("udp", err)
+		if response, ok := dto.FormatErrorResponse(buffer); ok && action == ratelimit.Allow && access == acl.Allow {
+			return dto.SerializeMessage(response)
+		}
+		return nil
+	}
+	if action == ratelimit.Slip {
+		e.metrics.RateLimited("udp", "slip")
+		// the TC bit tells the client to retry over tcp
+		return dto.SerializeMessage(dto.EmptyResponse(*message, dto.TRUNCATED))
+	}
+	if access == acl.Refuse {
+		e.metrics.Denied("udp", access.String())
+		return dto.SerializeMessage(dto.EmptyResponse(*message, dto.REFUSED))
+	}
+	payload := serialize(e.chain.ResolveRequest(ctx, req, *message), dto.PayloadSize(*message))
+	e.metrics.Request("udp", len(buffer), len(payload))
+	return payload
+}
+
+// reply sends the response through the socket of the query when its destination recently sent the matching query to it,
+// the response is withheld and counted otherwise
+func (e *UDPEndpoint) reply(q question, payload []byte) {
+	dest, udpConn := &q.destination, q.conn
+	if payload == nil {
+		// the query is dropped, forget it
+		e.tracker.release(newFlow(dest, udpConn, binary.BigEndian.Uint16(q.message)), time.Now())
+		return
+	}
+	verdict := e.tracker.release(newFlow(dest, udpConn, binary.BigEndian.Uint16(payload)), time.Now())
+	if verdict != expected {
+		e.lock.RLock()
+		e.metrics.UnexpectedDestination("udp", verdict)
+		e.lock.RUnlock()
+		logger.Warn("withholding a response to an unexpected destination", "client", dest, "reason", verdict)
 		return
 	}
-	res := e.chain.Resolve(*message)
-	send(res, dest, udpConn)
+	send(payload, dest, udpConn)
 }
 
-func send(message dto.Message, dest *net.UDPAddr, udpConn *net.UDPConn) bool {
-	payload := dto.SerializeMessage(message)
+func send(payload []byte, dest *net.UDPAddr, udpConn *net.UDPConn) bool {
 	_, err := udpConn.WriteToUDP(payload, dest)
 	if err != nil {
 		if terr, ok := err.(net.Error); !(ok && terr.Timeout()) {
-			log.Println(err)
+			logger.Warn("cannot send the response", "client", dest, "err", err)
 			return true
 		}
 	}
 	return false
 }
 
-func (e *UDPEndpoint) getBuffer() []byte {
-	return e.bufferPool.Get().([]byte)
+// serialize serializes the message, when it does not fit in the payload size of the client the answers are dropped
+// and the TC bit is set so the client retries over tcp
+func serialize(message dto.Message, size int) []byte {
+	payload := dto.SerializeMessage(message)
+	if len(payload) <= size {
+		return payload
+	}
+	message.Header |= dto.TRUNCATED
+	message.ResponseCount = 0
+	message.Response = nil
+	return dto.SerializeMessage(message)
 }
 
-func (e *UDPEndpoint) recycle(buff []byte) {
-	e.bufferPool.Put(buff[0:dto.BufferMaxLength])
+// newBuffer allocates a buffer of the pool, the pool holds pointers so a buffer is put back without allocating
+func newBuffer() any {
+	buffer := make([]byte, dto.BufferMaxLength)
+	return &buffer
 }
 
-func (e *UDPEndpoint) populateConn(ctx context.Context, n int) []*net.UDPConn {
-	res := make([]*net.UDPConn, n)
+func (e *UDPEndpoint) getBuffer() *[]byte {
+	return e.bufferPool.Get().(*[]byte)
+}
 
-	for i := 0; i < n; i++ {
+func (e *UDPEndpoint) recycle(buffer *[]byte) {
+	e.bufferPool.Put(buffer)
+}
 
-		conf := net.ListenConfig{
-			Control: reusePort,
-		}
+// populateConn opens the sockets of the workers, the ones already opened are closed when one fails
+func (e *UDPEndpoint) populateConn(ctx context.Context, n int) ([]*net.UDPConn, error) {
+	res := make([]*net.UDPConn, 0, n)
 
-		conn, err := conf.ListenPacket(ctx, "udp", e.laddr)
-		if err != nil {
-			panic(err)
-		}
-		udpConn, ok := conn.(*net.UDPConn)
-		if !ok {
-			panic("connection is not an udp connection")
-		}
-		err = udpConn.SetReadBuffer(dto.BufferMaxLength * workers * 2)
-		if err != nil {
-			panic(err)
-		}
-		err = udpConn.SetWriteBuffer(dto.BufferMaxLength)
+	for i := 0; i < n; i++ {
+		udpConn, err := e.listen(ctx, n)
 		if err != nil {
-			panic(err)
+			closeAll(res)
+			return nil, err
 		}
+		res = append(res, udpConn)
+	}
+	return res, nil
+}
+
+// listen returns a socket of one of the n workers, sharing the address with the sockets of the other ones
+func (e *UDPEndpoint) listen(ctx context.Context, n int) (*net.UDPConn, error) {
+	conf := net.ListenConfig{
+		Control: reusePort,
+	}
 
-		res[i] = udpConn
+	conn, err := handoff.ListenPacket(ctx, conf, "udp", e.laddr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, errors.New("connection is not an udp connection")
+	}
+	err = udpConn.SetReadBuffer(dto.BufferMaxLength * n * 2)
+	if err == nil {
+		err = udpConn.SetWriteBuffer(dto.BufferMaxLength)
+	}
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
 	}
-	return res
+	return udpConn, nil
 }
 
 func closeAll(r []*net.UDPConn) {
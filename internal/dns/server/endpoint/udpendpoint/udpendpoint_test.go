@@ -2,13 +2,16 @@ package udpendpoint
 
 import (
 	"context"
+	"net"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
 	"github.com/bluguard/dnshield/internal/dns/client/udp"
+	"github.com/bluguard/dnshield/internal/dns/dto"
 	"github.com/bluguard/dnshield/internal/dns/resolver"
 )
 
@@ -33,8 +36,9 @@ func TestMain(m *testing.M) {
 	//start endpoint
 	ctx, cancel := context.WithCancel(context.Background())
 	wg := sync.WaitGroup{}
-	wg.Add(1)
-	endpoint.Start(ctx, &wg)
+	if err := endpoint.Start(ctx, &wg); err != nil {
+		panic(err)
+	}
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -61,3 +65,199 @@ func TestUdpEndpoint(t *testing.T) {
 		t.Fatalf("Expecting localhost -> ::1, got %v", res)
 	}
 }
+
+func TestSerializeTruncated(t *testing.T) {
+	message := dto.Message{
+		ID:            1,
+		Header:        dto.STANDARD_RESPONSE,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: "large.lan", Type: dto.A, Class: dto.IN}},
+	}
+	for i := 0; i < 40; i++ {
+		message.Response = append(message.Response, dto.Record{Name: "large.lan", Type: dto.A, Class: dto.IN, TTL: 60, Data: net.IPv4(10, 0, 0, byte(i)).To4()})
+	}
+	message.ResponseCount = uint16(len(message.Response))
+
+	payload := serialize(message, dto.UDPMaxLength)
+	if len(payload) > dto.UDPMaxLength {
+		t.Fatalf("payload of %d bytes is too large for udp", len(payload))
+	}
+	res, err := dto.ParseMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header&dto.TRUNCATED == 0 || res.ResponseCount != 0 {
+		t.Fatalf("expecting a truncated message without answers, got %v", res)
+	}
+
+	// a client advertising a larger payload with EDNS gets all the answers
+	res, err = dto.ParseMessage(serialize(message, dto.EDNSPayloadSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header&dto.TRUNCATED != 0 || res.ResponseCount != 40 {
+		t.Fatalf("expecting a complete message with the edns payload size, got %v", res)
+	}
+
+	message.Response = message.Response[:1]
+	message.ResponseCount = 1
+	res, err = dto.ParseMessage(serialize(message, dto.UDPMaxLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header&dto.TRUNCATED != 0 || res.ResponseCount != 1 {
+		t.Fatalf("expecting a complete message, got %v", res)
+	}
+}
+
+func TestUdpEndpoint_AddressInUse(t *testing.T) {
+	// the socket does not reuse its port, the sockets of the endpoint cannot share it
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := sync.WaitGroup{}
+	endpoint := NewUDPEndpoint(conn.LocalAddr().String(), resolver.NewResolverChain(nil))
+	if err := endpoint.Start(ctx, &wg); err == nil {
+		t.Fatal("expecting an error listening on an address in use")
+	}
+	wg.Wait()
+}
+
+// slowResolver answers the questions about the names starting with slow after a delay, the other ones at once
+type slowResolver struct {
+	delay time.Duration
+}
+
+func (r slowResolver) Resolve(q dto.Question) (dto.Record, bool) {
+	if strings.HasPrefix(q.Name, "slow") {
+		time.Sleep(r.delay)
+	}
+	return dto.Record{Name: q.Name, Type: dto.A, Class: dto.IN, TTL: 60, Data: net.IPv4(192, 0, 2, 1).To4()}, true
+}
+
+func (slowResolver) Name() string {
+	return "slow"
+}
+
+func TestUdpEndpoint_Drain(t *testing.T) {
+	chain := resolver.NewResolverChain([]resolver.Resolver{slowResolver{delay: 300 * time.Millisecond}})
+	endpoint := NewUDPEndpoint("127.0.0.1:12350", chain)
+	endpoint.SetWorkers(1)
+	endpoint.SetDrainTimeout(2 * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	if err := endpoint.Start(ctx, &wg); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", "127.0.0.1:12350")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sendQuery(t, conn, 7, "slow.lan")
+	// the endpoint stops while the query is resolved, it is still answered
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if res, err := receiveResponse(conn, 2*time.Second); err != nil || res.ID != 7 || res.ResponseCount != 1 {
+		t.Errorf("expecting the response of the query received before the stop, got %v %v", res, err)
+	}
+	wg.Wait()
+}
+
+func TestUdpEndpoint_Handlers(t *testing.T) {
+	chain := resolver.NewResolverChain([]resolver.Resolver{slowResolver{delay: time.Second}})
+	endpoint := NewUDPEndpoint("127.0.0.1:12351", chain)
+	endpoint.SetWorkers(1)
+	endpoint.SetHandlers(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := sync.WaitGroup{}
+	if err := endpoint.Start(ctx, &wg); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", "127.0.0.1:12351")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the slow query holds a handler, the other one answers the next query of the same socket
+	sendQuery(t, conn, 1, "slow.lan")
+	time.Sleep(50 * time.Millisecond)
+	sendQuery(t, conn, 2, "fast.lan")
+	if res, err := receiveResponse(conn, 500*time.Millisecond); err != nil || res.ID != 2 {
+		t.Errorf("expecting the fast query to be answered first, got %v %v", res, err)
+	}
+	if res, err := receiveResponse(conn, 2*time.Second); err != nil || res.ID != 1 {
+		t.Errorf("expecting the slow query to be answered, got %v %v", res, err)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestUdpEndpoint_Rebind(t *testing.T) {
+	endpoint := NewUDPEndpoint("127.0.0.1:12352", resolver.NewResolverChain([]resolver.Resolver{slowResolver{}}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conns, err := endpoint.populateConn(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the socket fails under its worker, which listens again
+	_ = conns[0].Close()
+	rwg, hwg := &sync.WaitGroup{}, &sync.WaitGroup{}
+	rwg.Add(1)
+	go endpoint.receivingLoop(ctx, conns, 0, rwg)
+	hwg.Add(1)
+	go endpoint.handler(make(chan struct{}), hwg)
+
+	conn, err := net.Dial("udp", "127.0.0.1:12352")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the queries sent before are refused by the closed port
+	var res *dto.Message
+	for attempt := 0; attempt < 20 && res == nil; attempt++ {
+		sendQuery(t, conn, uint16(attempt), "fast.lan")
+		if res, err = receiveResponse(conn, 200*time.Millisecond); err != nil {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if res == nil || res.ResponseCount != 1 {
+		t.Errorf("expecting the query to be answered once the socket listens again, got %v", res)
+	}
+
+	cancel()
+	rwg.Wait()
+	close(endpoint.inbox)
+	hwg.Wait()
+	closeAll(conns)
+}
+
+// sendQuery sends the query of an A record of name
+func sendQuery(t *testing.T, conn net.Conn, id uint16, name string) {
+	t.Helper()
+	query := dto.SerializeMessage(dto.Message{ID: id, Header: dto.STANDARD_QUERY, QuestionCount: 1, Question: []dto.Question{{Name: name, Type: dto.A, Class: dto.IN}}})
+	if _, err := conn.Write(query); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveResponse reads the next response for up to timeout
+func receiveResponse(conn net.Conn, timeout time.Duration) (*dto.Message, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, dto.BufferMaxLength)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return dto.ParseMessage(buffer[:n])
+}
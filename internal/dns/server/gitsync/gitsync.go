@@ -0,0 +1,87 @@
+// Package gitsync keeps a shallow clone of a branch of a git repository, to read the files shared by several servers
+// and commit their changes. It runs the git command, so the repository is reached with the credentials and the
+// transports configured for git
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Repository is the clone in Dir of the Branch of the repository at URL, of its default branch when Branch is empty
+type Repository struct {
+	URL    string
+	Branch string
+	Dir    string
+}
+
+// Pull clones the repository the first time, then replaces the clone with the last commit of the branch,
+// the local commits which were not pushed are dropped
+func (r Repository) Pull(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Join(r.Dir, ".git")); err != nil {
+		args := []string{"clone", "--depth", "1"}
+		if r.Branch != "" {
+			args = append(args, "--branch", r.Branch)
+		}
+		return run(ctx, "", append(args, "--", r.URL, r.Dir)...)
+	}
+	ref := r.Branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if err := run(ctx, r.Dir, "fetch", "--depth", "1", "origin", ref); err != nil {
+		return err
+	}
+	return run(ctx, r.Dir, "reset", "--hard", "FETCH_HEAD")
+}
+
+// ReadFile returns the content of the file at name, a slash separated path in the clone
+func (r Repository) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(r.Dir, filepath.FromSlash(name)))
+}
+
+// Push commits the new content of the file at name with the message and pushes it to the branch,
+// nothing is committed when the content is unchanged
+func (r Repository) Push(ctx context.Context, name string, data []byte, message string) error {
+	path := filepath.Join(r.Dir, filepath.FromSlash(name))
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	if err := run(ctx, r.Dir, "add", "--", name); err != nil {
+		return err
+	}
+	if err := run(ctx, r.Dir, "commit", "--quiet", "-m", message); err != nil {
+		return err
+	}
+	ref := "HEAD"
+	if r.Branch != "" {
+		ref = "HEAD:refs/heads/" + r.Branch
+	}
+	return run(ctx, r.Dir, "push", "--quiet", "origin", ref)
+}
+
+// run runs the git command in dir, without prompting for credentials. The commits are authored by dnshield
+func run(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME=dnshield", "GIT_AUTHOR_EMAIL=dnshield@localhost",
+		"GIT_COMMITTER_NAME=dnshield", "GIT_COMMITTER_EMAIL=dnshield@localhost",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.New("git " + args[0] + ": " + err.Error() + ": " + strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package gitsync
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// newOrigin returns the path of a bare repository with the file policy.json committed on its main branch
+func newOrigin(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	origin := filepath.Join(dir, "origin.git")
+	work := filepath.Join(dir, "work")
+	ctx := context.Background()
+	for _, args := range [][]string{
+		{"init", "--quiet", "--bare", "--initial-branch", "main", origin},
+		{"clone", "--quiet", origin, work},
+	} {
+		if err := run(ctx, "", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(work, "policy.json"), []byte(`{"block_rules":["ads.com"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"add", "policy.json"},
+		{"commit", "--quiet", "-m", "policy"},
+		{"push", "--quiet", "origin", "HEAD:refs/heads/main"},
+	} {
+		if err := run(ctx, work, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return origin
+}
+
+func TestRepository(t *testing.T) {
+	origin := newOrigin(t)
+	ctx := context.Background()
+	first := Repository{URL: origin, Branch: "main", Dir: filepath.Join(t.TempDir(), "first")}
+	second := Repository{URL: origin, Branch: "main", Dir: filepath.Join(t.TempDir(), "second")}
+	for _, r := range []Repository{first, second} {
+		if err := r.Pull(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := first.ReadFile("policy.json"); err != nil || string(data) != `{"block_rules":["ads.com"]}` {
+		t.Fatalf("ReadFile() = %s, %v", data, err)
+	}
+
+	updated := []byte(`{"block_rules":["ads.com","tracker.com"]}`)
+	if err := first.Push(ctx, "policy.json", updated, "block tracker.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Push(ctx, "policy.json", updated, "nothing to commit"); err != nil {
+		t.Fatalf("an unchanged file should not be committed: %v", err)
+	}
+	if err := second.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := second.ReadFile("policy.json"); string(data) != string(updated) {
+		t.Errorf("expecting the pushed policy, got %s", data)
+	}
+}
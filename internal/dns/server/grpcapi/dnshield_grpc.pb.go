@@ -0,0 +1,1026 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: dnshield.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Dnshield_Resolve_FullMethodName         = "/dnshield.Dnshield/Resolve"
+	Dnshield_FlushCache_FullMethodName      = "/dnshield.Dnshield/FlushCache"
+	Dnshield_GetStats_FullMethodName        = "/dnshield.Dnshield/GetStats"
+	Dnshield_WatchStats_FullMethodName      = "/dnshield.Dnshield/WatchStats"
+	Dnshield_TestDomain_FullMethodName      = "/dnshield.Dnshield/TestDomain"
+	Dnshield_BlockingLists_FullMethodName   = "/dnshield.Dnshield/BlockingLists"
+	Dnshield_BlockedNames_FullMethodName    = "/dnshield.Dnshield/BlockedNames"
+	Dnshield_AllowedPatterns_FullMethodName = "/dnshield.Dnshield/AllowedPatterns"
+	Dnshield_GetRules_FullMethodName        = "/dnshield.Dnshield/GetRules"
+	Dnshield_AddRule_FullMethodName         = "/dnshield.Dnshield/AddRule"
+	Dnshield_RemoveRule_FullMethodName      = "/dnshield.Dnshield/RemoveRule"
+	Dnshield_GetPauses_FullMethodName       = "/dnshield.Dnshield/GetPauses"
+	Dnshield_PauseBlocking_FullMethodName   = "/dnshield.Dnshield/PauseBlocking"
+	Dnshield_ResumeBlocking_FullMethodName  = "/dnshield.Dnshield/ResumeBlocking"
+	Dnshield_GetMaintenance_FullMethodName  = "/dnshield.Dnshield/GetMaintenance"
+	Dnshield_SetMaintenance_FullMethodName  = "/dnshield.Dnshield/SetMaintenance"
+	Dnshield_GetOffline_FullMethodName      = "/dnshield.Dnshield/GetOffline"
+	Dnshield_SetOffline_FullMethodName      = "/dnshield.Dnshield/SetOffline"
+	Dnshield_ListSnapshots_FullMethodName   = "/dnshield.Dnshield/ListSnapshots"
+	Dnshield_DiffSnapshots_FullMethodName   = "/dnshield.Dnshield/DiffSnapshots"
+	Dnshield_Rollback_FullMethodName        = "/dnshield.Dnshield/Rollback"
+	Dnshield_PurgeClient_FullMethodName     = "/dnshield.Dnshield/PurgeClient"
+	Dnshield_ExportQueryLog_FullMethodName  = "/dnshield.Dnshield/ExportQueryLog"
+)
+
+// DnshieldClient is the client API for Dnshield service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DnshieldClient interface {
+	// Resolve resolves a question through the resolver chain of the server
+	Resolve(ctx context.Context, in *ResolveRequest, opts ...grpc.CallOption) (*ResolveResponse, error)
+	// FlushCache removes all the entries of the cache
+	FlushCache(ctx context.Context, in *FlushCacheRequest, opts ...grpc.CallOption) (*FlushCacheResponse, error)
+	// GetStats returns the current counters of the server
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error)
+	// WatchStats streams the counters of the server at the given interval, 100 milliseconds at least
+	WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (Dnshield_WatchStatsClient, error)
+	// TestDomain tells whether the name would be blocked, without resolving it
+	TestDomain(ctx context.Context, in *TestDomainRequest, opts ...grpc.CallOption) (*Verdict, error)
+	// BlockingLists returns the blocking lists and the rules, with the number of names they block and of questions they blocked
+	BlockingLists(ctx context.Context, in *BlockingListsRequest, opts ...grpc.CallOption) (*BlockingListsResponse, error)
+	// BlockedNames returns the names blocked by a list of a client group, of every client when the group is empty
+	BlockedNames(ctx context.Context, in *BlockedNamesRequest, opts ...grpc.CallOption) (*NamesResponse, error)
+	// AllowedPatterns returns the patterns of the names never blocked for a client group, for every client when the group is empty
+	AllowedPatterns(ctx context.Context, in *AllowedPatternsRequest, opts ...grpc.CallOption) (*NamesResponse, error)
+	// GetRules returns the allowed domains and the block rules of the configuration, with the ones edited through the api
+	GetRules(ctx context.Context, in *GetRulesRequest, opts ...grpc.CallOption) (*Rules, error)
+	// AddRule allows or blocks the names matching the pattern until the server stops
+	AddRule(ctx context.Context, in *RuleEdit, opts ...grpc.CallOption) (*Rules, error)
+	// RemoveRule removes an allowed domain or a block rule until the server stops
+	RemoveRule(ctx context.Context, in *RuleEdit, opts ...grpc.CallOption) (*Rules, error)
+	// GetPauses returns the pauses of the blocking in progress
+	GetPauses(ctx context.Context, in *GetPausesRequest, opts ...grpc.CallOption) (*Pauses, error)
+	// PauseBlocking suspends the blocking of a client for some minutes, of every client when the client is empty
+	PauseBlocking(ctx context.Context, in *PauseBlockingRequest, opts ...grpc.CallOption) (*Pauses, error)
+	// ResumeBlocking ends the pause of the blocking of a client, of every client when the client is empty
+	ResumeBlocking(ctx context.Context, in *ResumeBlockingRequest, opts ...grpc.CallOption) (*Pauses, error)
+	// GetMaintenance returns the state of the maintenance
+	GetMaintenance(ctx context.Context, in *GetMaintenanceRequest, opts ...grpc.CallOption) (*Maintenance, error)
+	// SetMaintenance starts or ends the maintenance, during which the answers only come from the cache
+	SetMaintenance(ctx context.Context, in *SetMaintenanceRequest, opts ...grpc.CallOption) (*Maintenance, error)
+	// GetOffline returns whether the external resolution is off
+	GetOffline(ctx context.Context, in *GetOfflineRequest, opts ...grpc.CallOption) (*Offline, error)
+	// SetOffline switches the external resolution off or back on
+	SetOffline(ctx context.Context, in *SetOfflineRequest, opts ...grpc.CallOption) (*Offline, error)
+	// ListSnapshots returns the snapshots of the configurations run by the server, oldest first
+	ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (*Snapshots, error)
+	// DiffSnapshots returns the settings changed from a snapshot to another, the running configuration when to is unset
+	DiffSnapshots(ctx context.Context, in *DiffSnapshotsRequest, opts ...grpc.CallOption) (*SnapshotDiff, error)
+	// Rollback runs the configuration of a snapshot again until the next reload
+	Rollback(ctx context.Context, in *RollbackRequest, opts ...grpc.CallOption) (*Snapshot, error)
+	// PurgeClient removes the entries of a client from the query log
+	PurgeClient(ctx context.Context, in *PurgeClientRequest, opts ...grpc.CallOption) (*PurgeClientResponse, error)
+	// ExportQueryLog streams the entries of the query log as csv or parquet, with the columns, the anonymization and the
+	// format of the export-log command
+	ExportQueryLog(ctx context.Context, in *ExportQueryLogRequest, opts ...grpc.CallOption) (Dnshield_ExportQueryLogClient, error)
+}
+
+type dnshieldClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDnshieldClient(cc grpc.ClientConnInterface) DnshieldClient {
+	return &dnshieldClient{cc}
+}
+
+func (c *dnshieldClient) Resolve(ctx context.Context, in *ResolveRequest, opts ...grpc.CallOption) (*ResolveResponse, error) {
+	out := new(ResolveResponse)
+	err := c.cc.Invoke(ctx, Dnshield_Resolve_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) FlushCache(ctx context.Context, in *FlushCacheRequest, opts ...grpc.CallOption) (*FlushCacheResponse, error) {
+	out := new(FlushCacheResponse)
+	err := c.cc.Invoke(ctx, Dnshield_FlushCache_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Dnshield_GetStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (Dnshield_WatchStatsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Dnshield_ServiceDesc.Streams[0], Dnshield_WatchStats_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dnshieldWatchStatsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Dnshield_WatchStatsClient interface {
+	Recv() (*Stats, error)
+	grpc.ClientStream
+}
+
+type dnshieldWatchStatsClient struct {
+	grpc.ClientStream
+}
+
+func (x *dnshieldWatchStatsClient) Recv() (*Stats, error) {
+	m := new(Stats)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *dnshieldClient) TestDomain(ctx context.Context, in *TestDomainRequest, opts ...grpc.CallOption) (*Verdict, error) {
+	out := new(Verdict)
+	err := c.cc.Invoke(ctx, Dnshield_TestDomain_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) BlockingLists(ctx context.Context, in *BlockingListsRequest, opts ...grpc.CallOption) (*BlockingListsResponse, error) {
+	out := new(BlockingListsResponse)
+	err := c.cc.Invoke(ctx, Dnshield_BlockingLists_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) BlockedNames(ctx context.Context, in *BlockedNamesRequest, opts ...grpc.CallOption) (*NamesResponse, error) {
+	out := new(NamesResponse)
+	err := c.cc.Invoke(ctx, Dnshield_BlockedNames_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) AllowedPatterns(ctx context.Context, in *AllowedPatternsRequest, opts ...grpc.CallOption) (*NamesResponse, error) {
+	out := new(NamesResponse)
+	err := c.cc.Invoke(ctx, Dnshield_AllowedPatterns_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) GetRules(ctx context.Context, in *GetRulesRequest, opts ...grpc.CallOption) (*Rules, error) {
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, Dnshield_GetRules_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) AddRule(ctx context.Context, in *RuleEdit, opts ...grpc.CallOption) (*Rules, error) {
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, Dnshield_AddRule_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) RemoveRule(ctx context.Context, in *RuleEdit, opts ...grpc.CallOption) (*Rules, error) {
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, Dnshield_RemoveRule_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) GetPauses(ctx context.Context, in *GetPausesRequest, opts ...grpc.CallOption) (*Pauses, error) {
+	out := new(Pauses)
+	err := c.cc.Invoke(ctx, Dnshield_GetPauses_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) PauseBlocking(ctx context.Context, in *PauseBlockingRequest, opts ...grpc.CallOption) (*Pauses, error) {
+	out := new(Pauses)
+	err := c.cc.Invoke(ctx, Dnshield_PauseBlocking_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) ResumeBlocking(ctx context.Context, in *ResumeBlockingRequest, opts ...grpc.CallOption) (*Pauses, error) {
+	out := new(Pauses)
+	err := c.cc.Invoke(ctx, Dnshield_ResumeBlocking_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) GetMaintenance(ctx context.Context, in *GetMaintenanceRequest, opts ...grpc.CallOption) (*Maintenance, error) {
+	out := new(Maintenance)
+	err := c.cc.Invoke(ctx, Dnshield_GetMaintenance_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) SetMaintenance(ctx context.Context, in *SetMaintenanceRequest, opts ...grpc.CallOption) (*Maintenance, error) {
+	out := new(Maintenance)
+	err := c.cc.Invoke(ctx, Dnshield_SetMaintenance_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) GetOffline(ctx context.Context, in *GetOfflineRequest, opts ...grpc.CallOption) (*Offline, error) {
+	out := new(Offline)
+	err := c.cc.Invoke(ctx, Dnshield_GetOffline_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) SetOffline(ctx context.Context, in *SetOfflineRequest, opts ...grpc.CallOption) (*Offline, error) {
+	out := new(Offline)
+	err := c.cc.Invoke(ctx, Dnshield_SetOffline_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (*Snapshots, error) {
+	out := new(Snapshots)
+	err := c.cc.Invoke(ctx, Dnshield_ListSnapshots_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) DiffSnapshots(ctx context.Context, in *DiffSnapshotsRequest, opts ...grpc.CallOption) (*SnapshotDiff, error) {
+	out := new(SnapshotDiff)
+	err := c.cc.Invoke(ctx, Dnshield_DiffSnapshots_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) Rollback(ctx context.Context, in *RollbackRequest, opts ...grpc.CallOption) (*Snapshot, error) {
+	out := new(Snapshot)
+	err := c.cc.Invoke(ctx, Dnshield_Rollback_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) PurgeClient(ctx context.Context, in *PurgeClientRequest, opts ...grpc.CallOption) (*PurgeClientResponse, error) {
+	out := new(PurgeClientResponse)
+	err := c.cc.Invoke(ctx, Dnshield_PurgeClient_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dnshieldClient) ExportQueryLog(ctx context.Context, in *ExportQueryLogRequest, opts ...grpc.CallOption) (Dnshield_ExportQueryLogClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Dnshield_ServiceDesc.Streams[1], Dnshield_ExportQueryLog_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dnshieldExportQueryLogClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Dnshield_ExportQueryLogClient interface {
+	Recv() (*QueryLogChunk, error)
+	grpc.ClientStream
+}
+
+type dnshieldExportQueryLogClient struct {
+	grpc.ClientStream
+}
+
+func (x *dnshieldExportQueryLogClient) Recv() (*QueryLogChunk, error) {
+	m := new(QueryLogChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DnshieldServer is the server API for Dnshield service.
+// All implementations must embed UnimplementedDnshieldServer
+// for forward compatibility
+type DnshieldServer interface {
+	// Resolve resolves a question through the resolver chain of the server
+	Resolve(context.Context, *ResolveRequest) (*ResolveResponse, error)
+	// FlushCache removes all the entries of the cache
+	FlushCache(context.Context, *FlushCacheRequest) (*FlushCacheResponse, error)
+	// GetStats returns the current counters of the server
+	GetStats(context.Context, *GetStatsRequest) (*Stats, error)
+	// WatchStats streams the counters of the server at the given interval, 100 milliseconds at least
+	WatchStats(*WatchStatsRequest, Dnshield_WatchStatsServer) error
+	// TestDomain tells whether the name would be blocked, without resolving it
+	TestDomain(context.Context, *TestDomainRequest) (*Verdict, error)
+	// BlockingLists returns the blocking lists and the rules, with the number of names they block and of questions they blocked
+	BlockingLists(context.Context, *BlockingListsRequest) (*BlockingListsResponse, error)
+	// BlockedNames returns the names blocked by a list of a client group, of every client when the group is empty
+	BlockedNames(context.Context, *BlockedNamesRequest) (*NamesResponse, error)
+	// AllowedPatterns returns the patterns of the names never blocked for a client group, for every client when the group is empty
+	AllowedPatterns(context.Context, *AllowedPatternsRequest) (*NamesResponse, error)
+	// GetRules returns the allowed domains and the block rules of the configuration, with the ones edited through the api
+	GetRules(context.Context, *GetRulesRequest) (*Rules, error)
+	// AddRule allows or blocks the names matching the pattern until the server stops
+	AddRule(context.Context, *RuleEdit) (*Rules, error)
+	// RemoveRule removes an allowed domain or a block rule until the server stops
+	RemoveRule(context.Context, *RuleEdit) (*Rules, error)
+	// GetPauses returns the pauses of the blocking in progress
+	GetPauses(context.Context, *GetPausesRequest) (*Pauses, error)
+	// PauseBlocking suspends the blocking of a client for some minutes, of every client when the client is empty
+	PauseBlocking(context.Context, *PauseBlockingRequest) (*Pauses, error)
+	// ResumeBlocking ends the pause of the blocking of a client, of every client when the client is empty
+	ResumeBlocking(context.Context, *ResumeBlockingRequest) (*Pauses, error)
+	// GetMaintenance returns the state of the maintenance
+	GetMaintenance(context.Context, *GetMaintenanceRequest) (*Maintenance, error)
+	// SetMaintenance starts or ends the maintenance, during which the answers only come from the cache
+	SetMaintenance(context.Context, *SetMaintenanceRequest) (*Maintenance, error)
+	// GetOffline returns whether the external resolution is off
+	GetOffline(context.Context, *GetOfflineRequest) (*Offline, error)
+	// SetOffline switches the external resolution off or back on
+	SetOffline(context.Context, *SetOfflineRequest) (*Offline, error)
+	// ListSnapshots returns the snapshots of the configurations run by the server, oldest first
+	ListSnapshots(context.Context, *ListSnapshotsRequest) (*Snapshots, error)
+	// DiffSnapshots returns the settings changed from a snapshot to another, the running configuration when to is unset
+	DiffSnapshots(context.Context, *DiffSnapshotsRequest) (*SnapshotDiff, error)
+	// Rollback runs the configuration of a snapshot again until the next reload
+	Rollback(context.Context, *RollbackRequest) (*Snapshot, error)
+	// PurgeClient removes the entries of a client from the query log
+	PurgeClient(context.Context, *PurgeClientRequest) (*PurgeClientResponse, error)
+	// ExportQueryLog streams the entries of the query log as csv or parquet, with the columns, the anonymization and the
+	// format of the export-log command
+	ExportQueryLog(*ExportQueryLogRequest, Dnshield_ExportQueryLogServer) error
+	mustEmbedUnimplementedDnshieldServer()
+}
+
+// UnimplementedDnshieldServer must be embedded to have forward compatible implementations.
+type UnimplementedDnshieldServer struct {
+}
+
+func (UnimplementedDnshieldServer) Resolve(context.Context, *ResolveRequest) (*ResolveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resolve not implemented")
+}
+func (UnimplementedDnshieldServer) FlushCache(context.Context, *FlushCacheRequest) (*FlushCacheResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FlushCache not implemented")
+}
+func (UnimplementedDnshieldServer) GetStats(context.Context, *GetStatsRequest) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedDnshieldServer) WatchStats(*WatchStatsRequest, Dnshield_WatchStatsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStats not implemented")
+}
+func (UnimplementedDnshieldServer) TestDomain(context.Context, *TestDomainRequest) (*Verdict, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TestDomain not implemented")
+}
+func (UnimplementedDnshieldServer) BlockingLists(context.Context, *BlockingListsRequest) (*BlockingListsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BlockingLists not implemented")
+}
+func (UnimplementedDnshieldServer) BlockedNames(context.Context, *BlockedNamesRequest) (*NamesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BlockedNames not implemented")
+}
+func (UnimplementedDnshieldServer) AllowedPatterns(context.Context, *AllowedPatternsRequest) (*NamesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AllowedPatterns not implemented")
+}
+func (UnimplementedDnshieldServer) GetRules(context.Context, *GetRulesRequest) (*Rules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRules not implemented")
+}
+func (UnimplementedDnshieldServer) AddRule(context.Context, *RuleEdit) (*Rules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddRule not implemented")
+}
+func (UnimplementedDnshieldServer) RemoveRule(context.Context, *RuleEdit) (*Rules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveRule not implemented")
+}
+func (UnimplementedDnshieldServer) GetPauses(context.Context, *GetPausesRequest) (*Pauses, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPauses not implemented")
+}
+func (UnimplementedDnshieldServer) PauseBlocking(context.Context, *PauseBlockingRequest) (*Pauses, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseBlocking not implemented")
+}
+func (UnimplementedDnshieldServer) ResumeBlocking(context.Context, *ResumeBlockingRequest) (*Pauses, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeBlocking not implemented")
+}
+func (UnimplementedDnshieldServer) GetMaintenance(context.Context, *GetMaintenanceRequest) (*Maintenance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMaintenance not implemented")
+}
+func (UnimplementedDnshieldServer) SetMaintenance(context.Context, *SetMaintenanceRequest) (*Maintenance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMaintenance not implemented")
+}
+func (UnimplementedDnshieldServer) GetOffline(context.Context, *GetOfflineRequest) (*Offline, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOffline not implemented")
+}
+func (UnimplementedDnshieldServer) SetOffline(context.Context, *SetOfflineRequest) (*Offline, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetOffline not implemented")
+}
+func (UnimplementedDnshieldServer) ListSnapshots(context.Context, *ListSnapshotsRequest) (*Snapshots, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSnapshots not implemented")
+}
+func (UnimplementedDnshieldServer) DiffSnapshots(context.Context, *DiffSnapshotsRequest) (*SnapshotDiff, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DiffSnapshots not implemented")
+}
+func (UnimplementedDnshieldServer) Rollback(context.Context, *RollbackRequest) (*Snapshot, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rollback not implemented")
+}
+func (UnimplementedDnshieldServer) PurgeClient(context.Context, *PurgeClientRequest) (*PurgeClientResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeClient not implemented")
+}
+func (UnimplementedDnshieldServer) ExportQueryLog(*ExportQueryLogRequest, Dnshield_ExportQueryLogServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportQueryLog not implemented")
+}
+func (UnimplementedDnshieldServer) mustEmbedUnimplementedDnshieldServer() {}
+
+// UnsafeDnshieldServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DnshieldServer will
+// result in compilation errors.
+type UnsafeDnshieldServer interface {
+	mustEmbedUnimplementedDnshieldServer()
+}
+
+func RegisterDnshieldServer(s grpc.ServiceRegistrar, srv DnshieldServer) {
+	s.RegisterService(&Dnshield_ServiceDesc, srv)
+}
+
+func _Dnshield_Resolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).Resolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_Resolve_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).Resolve(ctx, req.(*ResolveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_FlushCache_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FlushCacheRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).FlushCache(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_FlushCache_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).FlushCache(ctx, req.(*FlushCacheRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_WatchStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DnshieldServer).WatchStats(m, &dnshieldWatchStatsServer{stream})
+}
+
+type Dnshield_WatchStatsServer interface {
+	Send(*Stats) error
+	grpc.ServerStream
+}
+
+type dnshieldWatchStatsServer struct {
+	grpc.ServerStream
+}
+
+func (x *dnshieldWatchStatsServer) Send(m *Stats) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Dnshield_TestDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TestDomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).TestDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_TestDomain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).TestDomain(ctx, req.(*TestDomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_BlockingLists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockingListsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).BlockingLists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_BlockingLists_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).BlockingLists(ctx, req.(*BlockingListsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_BlockedNames_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockedNamesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).BlockedNames(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_BlockedNames_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).BlockedNames(ctx, req.(*BlockedNamesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_AllowedPatterns_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllowedPatternsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).AllowedPatterns(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_AllowedPatterns_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).AllowedPatterns(ctx, req.(*AllowedPatternsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_GetRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).GetRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_GetRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).GetRules(ctx, req.(*GetRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_AddRule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RuleEdit)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).AddRule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_AddRule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).AddRule(ctx, req.(*RuleEdit))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_RemoveRule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RuleEdit)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).RemoveRule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_RemoveRule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).RemoveRule(ctx, req.(*RuleEdit))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_GetPauses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPausesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).GetPauses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_GetPauses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).GetPauses(ctx, req.(*GetPausesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_PauseBlocking_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseBlockingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).PauseBlocking(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_PauseBlocking_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).PauseBlocking(ctx, req.(*PauseBlockingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_ResumeBlocking_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeBlockingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).ResumeBlocking(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_ResumeBlocking_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).ResumeBlocking(ctx, req.(*ResumeBlockingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_GetMaintenance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMaintenanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).GetMaintenance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_GetMaintenance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).GetMaintenance(ctx, req.(*GetMaintenanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_SetMaintenance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetMaintenanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).SetMaintenance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_SetMaintenance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).SetMaintenance(ctx, req.(*SetMaintenanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_GetOffline_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOfflineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).GetOffline(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_GetOffline_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).GetOffline(ctx, req.(*GetOfflineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_SetOffline_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetOfflineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).SetOffline(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_SetOffline_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).SetOffline(ctx, req.(*SetOfflineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_ListSnapshots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSnapshotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).ListSnapshots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_ListSnapshots_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).ListSnapshots(ctx, req.(*ListSnapshotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_DiffSnapshots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DiffSnapshotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).DiffSnapshots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_DiffSnapshots_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).DiffSnapshots(ctx, req.(*DiffSnapshotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_Rollback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RollbackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).Rollback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_Rollback_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).Rollback(ctx, req.(*RollbackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_PurgeClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeClientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DnshieldServer).PurgeClient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Dnshield_PurgeClient_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DnshieldServer).PurgeClient(ctx, req.(*PurgeClientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Dnshield_ExportQueryLog_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportQueryLogRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DnshieldServer).ExportQueryLog(m, &dnshieldExportQueryLogServer{stream})
+}
+
+type Dnshield_ExportQueryLogServer interface {
+	Send(*QueryLogChunk) error
+	grpc.ServerStream
+}
+
+type dnshieldExportQueryLogServer struct {
+	grpc.ServerStream
+}
+
+func (x *dnshieldExportQueryLogServer) Send(m *QueryLogChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Dnshield_ServiceDesc is the grpc.ServiceDesc for Dnshield service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Dnshield_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dnshield.Dnshield",
+	HandlerType: (*DnshieldServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Resolve",
+			Handler:    _Dnshield_Resolve_Handler,
+		},
+		{
+			MethodName: "FlushCache",
+			Handler:    _Dnshield_FlushCache_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Dnshield_GetStats_Handler,
+		},
+		{
+			MethodName: "TestDomain",
+			Handler:    _Dnshield_TestDomain_Handler,
+		},
+		{
+			MethodName: "BlockingLists",
+			Handler:    _Dnshield_BlockingLists_Handler,
+		},
+		{
+			MethodName: "BlockedNames",
+			Handler:    _Dnshield_BlockedNames_Handler,
+		},
+		{
+			MethodName: "AllowedPatterns",
+			Handler:    _Dnshield_AllowedPatterns_Handler,
+		},
+		{
+			MethodName: "GetRules",
+			Handler:    _Dnshield_GetRules_Handler,
+		},
+		{
+			MethodName: "AddRule",
+			Handler:    _Dnshield_AddRule_Handler,
+		},
+		{
+			MethodName: "RemoveRule",
+			Handler:    _Dnshield_RemoveRule_Handler,
+		},
+		{
+			MethodName: "GetPauses",
+			Handler:    _Dnshield_GetPauses_Handler,
+		},
+		{
+			MethodName: "PauseBlocking",
+			Handler:    _Dnshield_PauseBlocking_Handler,
+		},
+		{
+			MethodName: "ResumeBlocking",
+			Handler:    _Dnshield_ResumeBlocking_Handler,
+		},
+		{
+			MethodName: "GetMaintenance",
+			Handler:    _Dnshield_GetMaintenance_Handler,
+		},
+		{
+			MethodName: "SetMaintenance",
+			Handler:    _Dnshield_SetMaintenance_Handler,
+		},
+		{
+			MethodName: "GetOffline",
+			Handler:    _Dnshield_GetOffline_Handler,
+		},
+		{
+			MethodName: "SetOffline",
+			Handler:    _Dnshield_SetOffline_Handler,
+		},
+		{
+			MethodName: "ListSnapshots",
+			Handler:    _Dnshield_ListSnapshots_Handler,
+		},
+		{
+			MethodName: "DiffSnapshots",
+			Handler:    _Dnshield_DiffSnapshots_Handler,
+		},
+		{
+			MethodName: "Rollback",
+			Handler:    _Dnshield_Rollback_Handler,
+		},
+		{
+			MethodName: "PurgeClient",
+			Handler:    _Dnshield_PurgeClient_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStats",
+			Handler:       _Dnshield_WatchStats_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportQueryLog",
+			Handler:       _Dnshield_ExportQueryLog_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "dnshield.proto",
+}
@@ -0,0 +1,227 @@
+package grpcapi
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	"github.com/bluguard/dnshield/internal/dns/cache"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("grpcapi")
+
+const defaultWatchInterval = 1 * time.Second
+
+// minWatchInterval bounds the rate of the stats streamed to a client
+const minWatchInterval = 100 * time.Millisecond
+
+// errDenied rejects the call of a client not allowed by the acl
+var errDenied = status.Error(codes.PermissionDenied, "client not allowed")
+
+var _ endpoint.Endpoint = &GrpcEndpoint{}
+var _ endpoint.Guarded = &GrpcEndpoint{}
+var _ DnshieldServer = &GrpcEndpoint{}
+
+// GrpcEndpoint serves the Dnshield grpc service
+type GrpcEndpoint struct {
+	UnimplementedDnshieldServer
+	laddr string
+	chain *resolver.ResolverChain
+	cache cache.Cache
+	stats *stats.Stats
+	// api serves the management calls, like the admin endpoint
+	api admin.API
+	// acl is nil when every client is allowed
+	acl     *acl.ACL
+	lock    sync.RWMutex
+	started atomic.Bool
+}
+
+// NewGrpcEndpoint create a new grpc endpoint resolving with the given chain and managing the server through the api
+func NewGrpcEndpoint(address string, chain *resolver.ResolverChain, c cache.Cache, s *stats.Stats, api admin.API) *GrpcEndpoint {
+	return &GrpcEndpoint{
+		laddr: address,
+		chain: chain,
+		cache: c,
+		stats: s,
+		api:   api,
+	}
+}
+
+// SetChain implements endpoint.Endpoint
+func (e *GrpcEndpoint) SetChain(chain *resolver.ResolverChain) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.chain = chain
+}
+
+// SetCache replaces the cache flushed by the endpoint
+func (e *GrpcEndpoint) SetCache(c cache.Cache) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.cache = c
+}
+
+// SetACL implements endpoint.Guarded, the calls of the clients not allowed are denied whatever the action of the acl
+func (e *GrpcEndpoint) SetACL(a *acl.ACL) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.acl = a
+}
+
+// Start implements endpoint.Endpoint
+func (e *GrpcEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if !e.started.CompareAndSwap(false, true) {
+		panic("endpoint is already started")
+	}
+	logger.Info("starting grpc endpoint", "address", e.laddr)
+	listener, err := handoff.Listen(ctx, net.ListenConfig{}, "tcp", e.laddr)
+	if err != nil {
+		return errors.New("cannot listen on tcp " + e.laddr + ": " + err.Error())
+	}
+	wg.Add(1)
+	go e.run(ctx, wg, listener)
+	return nil
+}
+
+func (e *GrpcEndpoint) run(ctx context.Context, wg *sync.WaitGroup, listener net.Listener) {
+	defer wg.Done()
+
+	server := grpc.NewServer()
+	RegisterDnshieldServer(server, e)
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	if err := server.Serve(listener); err != nil {
+		logger.Error("grpc endpoint failed", "address", e.laddr, "err", err)
+	}
+	logger.Info("grpc endpoint stopped", "address", e.laddr)
+}
+
+// Resolve implements DnshieldServer
+func (e *GrpcEndpoint) Resolve(ctx context.Context, request *ResolveRequest) (*ResolveResponse, error) {
+	if request.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	t := dto.Type(request.GetType())
+	if t == 0 {
+		t = dto.A
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, endpoint.QueryTimeout)
+	defer cancel()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	req := e.metadata(ctx)
+	if e.acl.Check(req.Client) != acl.Allow {
+		return nil, errDenied
+	}
+	ctx, span := e.chain.Trace(ctx, req)
+	defer span.End(nil)
+	message := e.chain.ResolveRequest(ctx, req, dto.Message{
+		Header:        dto.STANDARD_QUERY,
+		QuestionCount: 1,
+		Question:      []dto.Question{{Name: request.GetName(), Type: t, Class: dto.IN}},
+	})
+
+	res := &ResolveResponse{Answers: make([]*Record, 0, len(message.Response)), Rcode: uint32(message.Header & dto.RCODE_MASK)}
+	for _, record := range message.Response {
+		res.Answers = append(res.Answers, &Record{
+			Name: record.Name,
+			Type: uint32(record.Type),
+			Ttl:  record.TTL,
+			Data: record.Value(),
+		})
+	}
+	return res, nil
+}
+
+// metadata returns the metadata of the request of the grpc call
+func (e *GrpcEndpoint) metadata(ctx context.Context) request.Request {
+	res := request.Request{Transport: request.GRPC, Endpoint: e.laddr, TraceID: request.NewTraceID()}
+	if p, ok := peer.FromContext(ctx); ok {
+		res.Client, res.Zone = request.SplitClient(p.Addr.String())
+	}
+	return res
+}
+
+// allowed tells whether the acl allows the client of the grpc call
+func (e *GrpcEndpoint) allowed(ctx context.Context) bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.acl.Check(e.metadata(ctx).Client) == acl.Allow
+}
+
+// FlushCache implements DnshieldServer
+func (e *GrpcEndpoint) FlushCache(ctx context.Context, _ *FlushCacheRequest) (*FlushCacheResponse, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.acl.Check(e.metadata(ctx).Client) != acl.Allow {
+		return nil, errDenied
+	}
+	e.cache.Clear()
+	return &FlushCacheResponse{}, nil
+}
+
+// GetStats implements DnshieldServer
+func (e *GrpcEndpoint) GetStats(ctx context.Context, _ *GetStatsRequest) (*Stats, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	return toStats(e.stats.Snapshot()), nil
+}
+
+// WatchStats implements DnshieldServer
+func (e *GrpcEndpoint) WatchStats(request *WatchStatsRequest, stream Dnshield_WatchStatsServer) error {
+	if !e.allowed(stream.Context()) {
+		return errDenied
+	}
+	interval := time.Duration(request.GetIntervalMs()) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	interval = max(interval, minWatchInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := stream.Send(toStats(e.stats.Snapshot())); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func toStats(snapshot stats.Snapshot) *Stats {
+	return &Stats{
+		UptimeSeconds: int64(snapshot.Uptime.Seconds()),
+		Queries:       snapshot.Queries,
+		Failures:      snapshot.Failures,
+		Resolvers:     snapshot.Resolvers,
+	}
+}
@@ -0,0 +1,310 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	"github.com/bluguard/dnshield/internal/dns/cache/memorycache"
+	dnsclient "github.com/bluguard/dnshield/internal/dns/client"
+	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/snapshots"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+)
+
+const addr = "127.0.0.1:12350"
+
+var client DnshieldClient
+
+// brokenResolver fails to resolve broken.lan, the other names are left to the next resolvers
+type brokenResolver struct{}
+
+func (brokenResolver) Name() string {
+	return "broken"
+}
+
+func (brokenResolver) Resolve(dto.Question) (dto.Record, bool) {
+	return dto.Record{}, false
+}
+
+func (brokenResolver) ResolveWithError(question dto.Question) (dto.Record, error) {
+	if question.Name == "broken.lan" {
+		return dto.Record{}, errors.New("connection refused")
+	}
+	return dto.Record{}, dnsclient.NotFound(question.Name + " is not broken")
+}
+
+func TestMain(m *testing.M) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+
+	memoryClient := inmemoryclient.InMemoryClient{}
+	memoryClient.Add("localhost", "127.0.0.1")
+	memoryClient.Add("localhost", "::1")
+	mx, _ := dto.ParseRData(dto.MX, "10 mail.lan")
+	_ = memoryClient.AddRecord(dto.Record{Name: "lan", Type: dto.MX, Raw: mx})
+
+	chain := resolver.NewResolverChain([]resolver.Resolver{
+		resolver.NewClientresolver(&memoryClient, "inMemory"),
+		brokenResolver{},
+	})
+	s := stats.NewStats()
+	chain.SetStats(s)
+
+	cache := memorycache.NewMemoryCache(ctx, &wg, 1000, 0, false, time.Minute)
+
+	if err := NewGrpcEndpoint(addr, chain, cache, s, &managedAPI{}).Start(ctx, &wg); err != nil {
+		panic(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		panic(err)
+	}
+	client = NewDnshieldClient(conn)
+
+	res := m.Run()
+	_ = conn.Close()
+	cancel()
+	wg.Wait()
+	os.Exit(res)
+}
+
+func TestGrpcEndpoint_Resolve(t *testing.T) {
+	res, err := client.Resolve(context.Background(), &ResolveRequest{Name: "localhost"})
+	if err != nil {
+		t.Fatalf("error resolving localhost %v", err)
+	}
+	if len(res.Answers) != 1 || res.Answers[0].Data != "127.0.0.1" {
+		t.Fatalf("Expecting localhost -> 127.0.0.1, got %v", res.Answers)
+	}
+
+	res, err = client.Resolve(context.Background(), &ResolveRequest{Name: "localhost", Type: uint32(dto.AAAA)})
+	if err != nil {
+		t.Fatalf("error resolving localhost in v6 %v", err)
+	}
+	if len(res.Answers) != 1 || res.Answers[0].Data != "::1" {
+		t.Fatalf("Expecting localhost -> ::1, got %v", res.Answers)
+	}
+
+	res, err = client.Resolve(context.Background(), &ResolveRequest{Name: "lan", Type: uint32(dto.MX)})
+	if err != nil {
+		t.Fatalf("error resolving the mx of lan %v", err)
+	}
+	if len(res.Answers) != 1 || res.Answers[0].Data != "10 mail.lan" {
+		t.Fatalf("Expecting lan -> 10 mail.lan, got %v", res.Answers)
+	}
+
+	res, err = client.Resolve(context.Background(), &ResolveRequest{Name: "broken.lan"})
+	if err != nil {
+		t.Fatalf("error resolving broken.lan %v", err)
+	}
+	if len(res.Answers) != 0 || res.Rcode != uint32(dto.SERVER_FAILURE) {
+		t.Fatalf("Expecting broken.lan to fail, got rcode %d and %v", res.Rcode, res.Answers)
+	}
+
+	if _, err = client.Resolve(context.Background(), &ResolveRequest{}); err == nil {
+		t.Fatal("Expecting an error for an empty name")
+	}
+}
+
+func TestGrpcEndpoint_acl(t *testing.T) {
+	e := NewGrpcEndpoint("127.0.0.1:0", resolver.NewResolverChain(nil), nil, stats.NewStats(), nil)
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/24")
+	e.SetACL(acl.NewACL([]*net.IPNet{subnet}, acl.Refuse))
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242}})
+	if _, err := e.Resolve(ctx, &ResolveRequest{Name: "localhost"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting the resolution of a client outside of the acl to be denied, got %v", err)
+	}
+	if _, err := e.FlushCache(ctx, &FlushCacheRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting the flush of a client outside of the acl to be denied, got %v", err)
+	}
+	if _, err := e.GetStats(ctx, &GetStatsRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting the stats of a client outside of the acl to be denied, got %v", err)
+	}
+	if err := e.WatchStats(&WatchStatsRequest{}, statsStream{ctx: ctx}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting the stats stream of a client outside of the acl to be denied, got %v", err)
+	}
+	if _, err := e.AddRule(ctx, &RuleEdit{Kind: RuleKind_BLOCK, Pattern: "ads.lan"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting the rule of a client outside of the acl to be denied, got %v", err)
+	}
+}
+
+// statsStream is the server side of a stats stream, it fails on send
+type statsStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s statsStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s statsStream) Send(*Stats) error {
+	return errors.New("stream closed")
+}
+
+func TestGrpcEndpoint_WatchStats(t *testing.T) {
+	// a name no resolver knows is not a failure, one of them failing is
+	_, _ = client.Resolve(context.Background(), &ResolveRequest{Name: "unknown"})
+	_, _ = client.Resolve(context.Background(), &ResolveRequest{Name: "broken.lan"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchStats(ctx, &WatchStatsRequest{IntervalMs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		s, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Queries == 0 || s.Failures == 0 {
+			t.Fatalf("Expecting queries and failures to be counted, got %v", s)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < minWatchInterval {
+		t.Fatalf("Expecting the stats to be streamed every %v at most, got two in %v", minWatchInterval, elapsed)
+	}
+}
+
+// managedAPI is the state changed through the management calls
+type managedAPI struct {
+	admin.API
+	lock  sync.Mutex
+	rules admin.Rules
+	since time.Time
+}
+
+func (a *managedAPI) Rules() admin.Rules {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.rules
+}
+
+func (a *managedAPI) AddRule(edit admin.RuleEdit) (admin.Rules, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if edit.Pattern == "" {
+		return admin.Rules{}, errors.New("pattern is required")
+	}
+	if edit.Kind == admin.AllowRule {
+		a.rules.Allow = append(a.rules.Allow, edit.Pattern)
+	} else {
+		a.rules.Block = append(a.rules.Block, edit.Pattern)
+	}
+	return a.rules, nil
+}
+
+func (a *managedAPI) SetMaintenance(enabled bool, reason string) maintenance.Status {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.since = time.Now()
+	return maintenance.Status{Enabled: enabled, Reason: reason, Since: a.since}
+}
+
+func (a *managedAPI) Rollback(id int) (snapshots.Summary, error) {
+	return snapshots.Summary{}, snapshots.ErrNotFound
+}
+
+func (a *managedAPI) ExportQueryLog(w io.Writer, opts querylog.ExportOptions) (int, error) {
+	if !opts.Anonymize {
+		return 0, querylog.ErrDisabled
+	}
+	_, err := io.WriteString(w, strings.Join(opts.Fields, ",")+"\n1.2.3.0,ads.lan\n")
+	return 1, err
+}
+
+func TestGrpcEndpoint_management(t *testing.T) {
+	ctx := context.Background()
+	rules, err := client.AddRule(ctx, &RuleEdit{Kind: RuleKind_BLOCK, Pattern: "ads.lan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.Block) != 1 || rules.Block[0] != "ads.lan" {
+		t.Errorf("Expecting ads.lan to be blocked, got %v", rules)
+	}
+	if _, err = client.AddRule(ctx, &RuleEdit{Pattern: "ads.lan"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expecting a rule without kind to be invalid, got %v", err)
+	}
+	if _, err = client.AddRule(ctx, &RuleEdit{Kind: RuleKind_ALLOW}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expecting the error of the api to be returned, got %v", err)
+	}
+	if rules, err = client.GetRules(ctx, &GetRulesRequest{}); err != nil || len(rules.Block) != 1 {
+		t.Errorf("Expecting the added rule, got %v %v", rules, err)
+	}
+
+	state, err := client.SetMaintenance(ctx, &SetMaintenanceRequest{Enabled: true, Reason: "upgrade"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Enabled || state.Reason != "upgrade" || state.Since == nil {
+		t.Errorf("Expecting the maintenance to be started, got %v", state)
+	}
+
+	if _, err = client.Rollback(ctx, &RollbackRequest{Id: 3}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expecting an unknown snapshot to be not found, got %v", err)
+	}
+	if _, err = client.Rollback(ctx, &RollbackRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expecting a rollback without snapshot to be invalid, got %v", err)
+	}
+}
+
+func TestGrpcEndpoint_ExportQueryLog(t *testing.T) {
+	read := func(request *ExportQueryLogRequest) (string, error) {
+		stream, err := client.ExportQueryLog(context.Background(), request)
+		if err != nil {
+			return "", err
+		}
+		var res []byte
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				return string(res), nil
+			}
+			if err != nil {
+				return string(res), err
+			}
+			res = append(res, chunk.Data...)
+		}
+	}
+
+	csv, err := read(&ExportQueryLogRequest{Fields: []string{"client", "name"}, Anonymize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if csv != "client,name\n1.2.3.0,ads.lan\n" {
+		t.Errorf("Expecting the csv of the export, got %q", csv)
+	}
+	if _, err = read(&ExportQueryLogRequest{Format: "xlsx", Anonymize: true}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expecting an unknown format to be invalid, got %v", err)
+	}
+	if _, err = read(&ExportQueryLogRequest{Fields: []string{"unknown"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expecting an unknown field to be invalid, got %v", err)
+	}
+	if _, err = read(&ExportQueryLogRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expecting the export without query log to fail, got %v", err)
+	}
+}
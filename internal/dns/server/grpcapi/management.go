@@ -0,0 +1,344 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/bluguard/dnshield/internal/dns/client/pause"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/snapshots"
+)
+
+// TestDomain implements DnshieldServer
+func (e *GrpcEndpoint) TestDomain(ctx context.Context, request *TestDomainRequest) (*Verdict, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	if request.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	verdict := e.api.TestDomain(request.GetName())
+	return &Verdict{Name: verdict.Name, Blocked: verdict.Blocked, Rule: verdict.Rule, Answers: verdict.Answers}, nil
+}
+
+// BlockingLists implements DnshieldServer
+func (e *GrpcEndpoint) BlockingLists(ctx context.Context, _ *BlockingListsRequest) (*BlockingListsResponse, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	lists := e.api.BlockingLists()
+	res := &BlockingListsResponse{Lists: make([]*BlockingList, 0, len(lists))}
+	for _, list := range lists {
+		res.Lists = append(res.Lists, &BlockingList{Source: list.Source, Names: int64(list.Names), Hits: list.Hits})
+	}
+	return res, nil
+}
+
+// BlockedNames implements DnshieldServer
+func (e *GrpcEndpoint) BlockedNames(ctx context.Context, request *BlockedNamesRequest) (*NamesResponse, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	if request.GetSource() == "" {
+		return nil, status.Error(codes.InvalidArgument, "source is required")
+	}
+	return &NamesResponse{Names: e.api.BlockedNames(request.GetGroup(), request.GetSource())}, nil
+}
+
+// AllowedPatterns implements DnshieldServer
+func (e *GrpcEndpoint) AllowedPatterns(ctx context.Context, request *AllowedPatternsRequest) (*NamesResponse, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	return &NamesResponse{Names: e.api.AllowedPatterns(request.GetGroup())}, nil
+}
+
+// GetRules implements DnshieldServer
+func (e *GrpcEndpoint) GetRules(ctx context.Context, _ *GetRulesRequest) (*Rules, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	return toRules(e.api.Rules()), nil
+}
+
+// AddRule implements DnshieldServer
+func (e *GrpcEndpoint) AddRule(ctx context.Context, request *RuleEdit) (*Rules, error) {
+	return e.editRule(ctx, request, true)
+}
+
+// RemoveRule implements DnshieldServer
+func (e *GrpcEndpoint) RemoveRule(ctx context.Context, request *RuleEdit) (*Rules, error) {
+	return e.editRule(ctx, request, false)
+}
+
+// editRule adds the rule of the request, or removes it
+func (e *GrpcEndpoint) editRule(ctx context.Context, request *RuleEdit, added bool) (*Rules, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	var kind admin.RuleKind
+	switch request.GetKind() {
+	case RuleKind_ALLOW:
+		kind = admin.AllowRule
+	case RuleKind_BLOCK:
+		kind = admin.BlockRule
+	default:
+		return nil, status.Error(codes.InvalidArgument, "kind is required")
+	}
+	edit := admin.RuleEdit{Kind: kind, Pattern: request.GetPattern()}
+	var rules admin.Rules
+	var err error
+	if added {
+		rules, err = e.api.AddRule(edit)
+	} else {
+		rules, err = e.api.RemoveRule(edit)
+	}
+	e.changed(ctx, err)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return toRules(rules), nil
+}
+
+// GetPauses implements DnshieldServer
+func (e *GrpcEndpoint) GetPauses(ctx context.Context, _ *GetPausesRequest) (*Pauses, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	return toPauses(e.api.Pauses()), nil
+}
+
+// PauseBlocking implements DnshieldServer
+func (e *GrpcEndpoint) PauseBlocking(ctx context.Context, request *PauseBlockingRequest) (*Pauses, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	pauses, err := e.api.PauseBlocking(request.GetClient(), time.Duration(request.GetMinutes())*time.Minute)
+	e.changed(ctx, err)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return toPauses(pauses), nil
+}
+
+// ResumeBlocking implements DnshieldServer
+func (e *GrpcEndpoint) ResumeBlocking(ctx context.Context, request *ResumeBlockingRequest) (*Pauses, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	pauses, err := e.api.ResumeBlocking(request.GetClient())
+	e.changed(ctx, err)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return toPauses(pauses), nil
+}
+
+// GetMaintenance implements DnshieldServer
+func (e *GrpcEndpoint) GetMaintenance(ctx context.Context, _ *GetMaintenanceRequest) (*Maintenance, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	return toMaintenance(e.api.Maintenance()), nil
+}
+
+// SetMaintenance implements DnshieldServer
+func (e *GrpcEndpoint) SetMaintenance(ctx context.Context, request *SetMaintenanceRequest) (*Maintenance, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	res := toMaintenance(e.api.SetMaintenance(request.GetEnabled(), request.GetReason()))
+	e.changed(ctx, nil)
+	return res, nil
+}
+
+// GetOffline implements DnshieldServer
+func (e *GrpcEndpoint) GetOffline(ctx context.Context, _ *GetOfflineRequest) (*Offline, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	offline := e.api.Offline()
+	return &Offline{Enabled: offline.Enabled, Answer: offline.Answer}, nil
+}
+
+// SetOffline implements DnshieldServer
+func (e *GrpcEndpoint) SetOffline(ctx context.Context, request *SetOfflineRequest) (*Offline, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	offline := e.api.SetOffline(request.GetEnabled())
+	e.changed(ctx, nil)
+	return &Offline{Enabled: offline.Enabled, Answer: offline.Answer}, nil
+}
+
+// ListSnapshots implements DnshieldServer
+func (e *GrpcEndpoint) ListSnapshots(ctx context.Context, _ *ListSnapshotsRequest) (*Snapshots, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	summaries := e.api.Snapshots()
+	res := &Snapshots{Snapshots: make([]*Snapshot, 0, len(summaries))}
+	for _, summary := range summaries {
+		res.Snapshots = append(res.Snapshots, toSnapshot(summary))
+	}
+	return res, nil
+}
+
+// DiffSnapshots implements DnshieldServer
+func (e *GrpcEndpoint) DiffSnapshots(ctx context.Context, request *DiffSnapshotsRequest) (*SnapshotDiff, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	if request.GetFrom() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "from is required")
+	}
+	changes, err := e.api.DiffSnapshots(int(request.GetFrom()), int(request.GetTo()))
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	res := &SnapshotDiff{Changes: make([]*Change, 0, len(changes))}
+	for _, change := range changes {
+		res.Changes = append(res.Changes, &Change{Path: change.Path, FromJson: toJSON(change.From), ToJson: toJSON(change.To)})
+	}
+	return res, nil
+}
+
+// Rollback implements DnshieldServer
+func (e *GrpcEndpoint) Rollback(ctx context.Context, request *RollbackRequest) (*Snapshot, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	if request.GetId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	summary, err := e.api.Rollback(int(request.GetId()))
+	e.changed(ctx, err)
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	return toSnapshot(summary), nil
+}
+
+// PurgeClient implements DnshieldServer
+func (e *GrpcEndpoint) PurgeClient(ctx context.Context, request *PurgeClientRequest) (*PurgeClientResponse, error) {
+	if !e.allowed(ctx) {
+		return nil, errDenied
+	}
+	client := net.ParseIP(request.GetClient())
+	if client == nil {
+		return nil, status.Error(codes.InvalidArgument, "a client ip is required")
+	}
+	removed, err := e.api.PurgeClient(client.String())
+	e.changed(ctx, err)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &PurgeClientResponse{Removed: int64(removed)}, nil
+}
+
+// ExportQueryLog implements DnshieldServer
+func (e *GrpcEndpoint) ExportQueryLog(request *ExportQueryLogRequest, stream Dnshield_ExportQueryLogServer) error {
+	if !e.allowed(stream.Context()) {
+		return errDenied
+	}
+	opts := querylog.ExportOptions{Anonymize: request.GetAnonymize()}
+	if request.GetFrom() != nil {
+		opts.From = request.GetFrom().AsTime()
+	}
+	if request.GetTo() != nil {
+		opts.To = request.GetTo().AsTime()
+	}
+	var err error
+	if opts.Fields, err = querylog.ParseFields(strings.Join(request.GetFields(), ",")); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if opts.Format, err = querylog.ParseFormat(request.GetFormat()); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	_, err = e.api.ExportQueryLog(chunkWriter{stream: stream}, opts)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, querylog.ErrDisabled):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// chunkWriter sends the file written by the export in chunks
+type chunkWriter struct {
+	stream Dnshield_ExportQueryLogServer
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&QueryLogChunk{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// changed logs a change requested through the api with its outcome
+func (e *GrpcEndpoint) changed(ctx context.Context, err error) {
+	method, _ := grpc.Method(ctx)
+	logger.Info("grpc change", "client", e.metadata(ctx).Client, "method", method, "failed", err != nil)
+}
+
+// snapshotError converts the error of an operation on the snapshots to the status of the call
+func snapshotError(err error) error {
+	if errors.Is(err, snapshots.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toRules(rules admin.Rules) *Rules {
+	return &Rules{Allow: rules.Allow, Block: rules.Block}
+}
+
+func toPauses(pauses []pause.Pause) *Pauses {
+	res := &Pauses{Pauses: make([]*Pause, 0, len(pauses))}
+	for _, p := range pauses {
+		res.Pauses = append(res.Pauses, &Pause{Client: p.Client, Until: timestamp(p.Until)})
+	}
+	return res
+}
+
+func toMaintenance(s maintenance.Status) *Maintenance {
+	return &Maintenance{Enabled: s.Enabled, Reason: s.Reason, Since: timestamp(s.Since)}
+}
+
+func toSnapshot(summary snapshots.Summary) *Snapshot {
+	return &Snapshot{Id: uint32(summary.ID), Time: timestamp(summary.Time), Reason: summary.Reason}
+}
+
+// timestamp converts the time, nil for the zero time
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// toJSON encodes the value of a setting, empty when it is not set
+func toJSON(v any) string {
+	if v == nil {
+		return ""
+	}
+	res, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(res)
+}
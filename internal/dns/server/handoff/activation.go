@@ -0,0 +1,76 @@
+//go:build !windows
+
+package handoff
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFdsStart is the first descriptor passed by systemd (sd_listen_fds)
+const listenFdsStart = 3
+
+// Activate takes the listening sockets passed by systemd to a service started by a socket unit, the endpoints
+// listen on the socket of their address instead of binding it, so the process needs no privilege to serve the port 53.
+// It returns the number of sockets passed, 0 when the process has not been started by a socket unit
+func Activate() (int, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return 0, errors.New("invalid LISTEN_FDS " + os.Getenv("LISTEN_FDS"))
+	}
+	// the children of the process do not own the sockets
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), "systemd")
+		key, err := describe(file)
+		if err != nil {
+			logger.Warn("ignoring the socket passed by systemd", "fd", fd, "err", err)
+			_ = file.Close()
+			continue
+		}
+		sockets.activate(key, file)
+	}
+	return count, nil
+}
+
+// describe returns the network and the address of a listening socket
+func describe(file *os.File) (Socket, error) {
+	if listener, err := net.FileListener(file); err == nil {
+		defer listener.Close()
+		return Socket{Network: listener.Addr().Network(), Address: listener.Addr().String()}, nil
+	}
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		return Socket{}, err
+	}
+	defer conn.Close()
+	return Socket{Network: conn.LocalAddr().Network(), Address: conn.LocalAddr().String()}, nil
+}
+
+// duplicate returns a new descriptor of the socket of the file, without File.Fd which would switch it to blocking mode
+func duplicate(file *os.File) (*os.File, error) {
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var fd int
+	var dupErr error
+	if err := raw.Control(func(f uintptr) { fd, dupErr = syscall.Dup(int(f)) }); err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, dupErr
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), file.Name()), nil
+}
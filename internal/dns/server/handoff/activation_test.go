@@ -0,0 +1,77 @@
+//go:build !windows
+
+package handoff
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestActivate(t *testing.T) {
+	bound, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := bound.(*net.UDPConn).File()
+	bound.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := describe(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Network != "udp" || key.Address != bound.LocalAddr().String() {
+		t.Fatalf("describe() = %v, want udp %s", key, bound.LocalAddr())
+	}
+	sockets.activate(key, file)
+
+	ctx := context.Background()
+	first, err := ListenPacket(ctx, net.ListenConfig{}, "udp", key.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an endpoint restarted by a reload listens on the socket again
+	first.Close()
+	second, err := ListenPacket(ctx, net.ListenConfig{}, "udp", key.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if second.LocalAddr().String() != key.Address {
+		t.Fatalf("expecting the socket passed by systemd on %s, got %s", key.Address, second.LocalAddr())
+	}
+	client, err := net.Dial("udp", key.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("query")); err != nil {
+		t.Fatal(err)
+	}
+	_ = second.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 16)
+	if n, _, err := second.ReadFrom(buffer); err != nil || string(buffer[:n]) != "query" {
+		t.Errorf("expecting the query on the socket passed by systemd, got %q, %v", buffer[:n], err)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{":53", ":53"},
+		{"0.0.0.0:53", ":53"},
+		{"[::]:53", ":53"},
+		{"192.168.1.1:53", "192.168.1.1:53"},
+		{"localhost:53", "localhost:53"},
+	}
+	for _, tt := range tests {
+		if got := canonical(tt.address); got != tt.want {
+			t.Errorf("canonical(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
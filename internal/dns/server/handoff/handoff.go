@@ -0,0 +1,188 @@
+// Package handoff upgrades the server without interrupting the resolution: a new process connects to the unix socket
+// of the running one, inherits its listening sockets and the snapshots of its caches, then tells it to stop once its
+// endpoints are serving. Both processes answer on the same sockets meanwhile, so no query is refused
+package handoff
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("handoff")
+
+const (
+	dialTimeout = time.Second
+	// transferTimeout bounds the transfer of the sockets and the caches
+	transferTimeout = 30 * time.Second
+	// readyTimeout is the time the next process has to start its endpoints, the running process keeps serving past it
+	readyTimeout = 2 * time.Minute
+	// listenTimeout is the time the endpoints have to listen on the inherited sockets once started
+	listenTimeout = 5 * time.Second
+
+	// readyByte is sent by the next process once its endpoints are started, stoppedByte by the previous one once it
+	// no longer listens on the unix socket
+	readyByte   = 'r'
+	stoppedByte = 's'
+)
+
+// state is sent after the sockets, Sockets identifies them in the order they have been sent
+type state struct {
+	Sockets []Socket
+	// Caches are the snapshots of the caches of the servers, by tenant, the main server has no name
+	Caches map[string][]byte
+}
+
+// Transfer is the state received from the previous process, a nil Transfer has no state
+type Transfer struct {
+	conn   *net.UnixConn
+	caches map[string][]byte
+}
+
+// Receive takes the sockets and the state over from the process serving the hand-off on path,
+// it returns nil without error when no process serves it, the endpoints then listen on their own sockets
+func Receive(path string) (*Transfer, error) {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		logger.Debug("no process to take over", "path", path, "err", err)
+		return nil, nil
+	}
+	unixConn := conn.(*net.UnixConn)
+	_ = unixConn.SetDeadline(time.Now().Add(transferTimeout))
+	files, err := receiveFiles(unixConn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.New("cannot receive the sockets: " + err.Error())
+	}
+	var s state
+	if err := gob.NewDecoder(unixConn).Decode(&s); err != nil || len(s.Sockets) != len(files) {
+		closeFiles(files)
+		_ = conn.Close()
+		if err == nil {
+			err = errors.New("the sockets do not match their description")
+		}
+		return nil, errors.New("cannot receive the state: " + err.Error())
+	}
+	sockets.inherit(s.Sockets, files)
+	logger.Info("took the sockets over", "path", path, "sockets", len(files))
+	return &Transfer{conn: unixConn, caches: s.Caches}, nil
+}
+
+// Cache returns the snapshot of the cache of the tenant, "" for the main server, nil when there is none
+func (t *Transfer) Cache(tenant string) []byte {
+	if t == nil {
+		return nil
+	}
+	return t.caches[tenant]
+}
+
+// Ready tells the previous process to stop once the endpoints listen on the inherited sockets, it must be called
+// once they are started. It returns once the previous process no longer listens on the unix socket,
+// the inherited sockets not used by the endpoints are closed
+func (t *Transfer) Ready() error {
+	if t == nil {
+		return nil
+	}
+	defer t.conn.Close()
+	defer sockets.closeUnused()
+	sockets.waitTaken(listenTimeout)
+	_ = t.conn.SetDeadline(time.Now().Add(transferTimeout))
+	if _, err := t.conn.Write([]byte{readyByte}); err != nil {
+		return err
+	}
+	var reply [1]byte
+	if _, err := io.ReadFull(t.conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != stoppedByte {
+		return errors.New("unexpected reply of the previous process")
+	}
+	return nil
+}
+
+// Serve hands the sockets of the endpoints and the snapshots returned by save over to the next process connecting
+// to path, then calls stop. It returns once the sockets are handed over, or when path cannot be listened on.
+// A failed hand-off keeps the endpoints serving and waits for the next one
+func Serve(path string, save func() map[string][]byte, stop func()) error {
+	if err := removeSocket(path); err != nil {
+		return err
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	// the next process listens on path once this one is stopped, its socket must not be removed
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	logger.Info("ready to hand the sockets over", "path", path)
+	for {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		if err := checkPeer(conn); err != nil {
+			logger.Warn("refusing the hand-off", "err", err)
+			_ = conn.Close()
+			continue
+		}
+		if err := handOver(conn, save); err != nil {
+			logger.Error("the hand-off failed, keeping on serving", "err", err)
+			_ = conn.Close()
+			continue
+		}
+		_ = listener.Close()
+		_, _ = conn.Write([]byte{stoppedByte})
+		_ = conn.Close()
+		logger.Info("the sockets have been handed over, stopping")
+		stop()
+		return nil
+	}
+}
+
+// removeSocket removes the socket left at path by a process which did not stop, any other file is kept
+func removeSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return errors.New(path + " exists and is not a socket")
+	}
+	return os.Remove(path)
+}
+
+// handOver sends the sockets and the state, then waits for the next process to start its endpoints
+func handOver(conn *net.UnixConn, save func() map[string][]byte) error {
+	_ = conn.SetDeadline(time.Now().Add(transferTimeout))
+	keys, files := sockets.files()
+	defer closeFiles(files)
+	if err := sendFiles(conn, files); err != nil {
+		return errors.New("cannot send the sockets: " + err.Error())
+	}
+	if err := gob.NewEncoder(conn).Encode(state{Sockets: keys, Caches: save()}); err != nil {
+		return errors.New("cannot send the state: " + err.Error())
+	}
+	logger.Info("handed the sockets over, waiting for the next process", "sockets", len(files))
+
+	_ = conn.SetDeadline(time.Now().Add(readyTimeout))
+	var ready [1]byte
+	if _, err := io.ReadFull(conn, ready[:]); err != nil {
+		return errors.New("the next process did not start: " + err.Error())
+	}
+	if ready[0] != readyByte {
+		return errors.New("unexpected message of the next process")
+	}
+	return nil
+}
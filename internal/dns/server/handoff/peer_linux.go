@@ -0,0 +1,33 @@
+package handoff
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// checkPeer accepts the processes of the user and the group of this one, and root which starts the next process
+// before dropping its privileges, from their credentials given by the kernel
+func checkPeer(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+	if cred.Uid == 0 || (int(cred.Uid) == os.Getuid() && int(cred.Gid) == os.Getgid()) {
+		return nil
+	}
+	return errors.New("the process " + strconv.Itoa(int(cred.Pid)) + " of the user " + strconv.Itoa(int(cred.Uid)) +
+		" and the group " + strconv.Itoa(int(cred.Gid)) + " is not allowed to take the sockets over")
+}
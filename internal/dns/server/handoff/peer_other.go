@@ -0,0 +1,11 @@
+//go:build !linux && !windows
+
+package handoff
+
+import "net"
+
+// checkPeer accepts any process, the credentials of the peer are not available. The socket is only writable by
+// the user of this process
+func checkPeer(_ *net.UnixConn) error {
+	return nil
+}
@@ -0,0 +1,306 @@
+package handoff
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Socket identifies a listening socket by the network and the address of the configuration
+type Socket struct {
+	Network string
+	Address string
+}
+
+// socket is a listening socket of the process which can be handed over, like a TCPListener or an UDPConn
+type socket interface {
+	SyscallConn() (syscall.RawConn, error)
+	File() (*os.File, error)
+}
+
+// listening is a socket the endpoints of the process are serving on
+type listening struct {
+	Socket
+	conn socket
+}
+
+// registry holds the sockets inherited from the previous process and the ones the endpoints listen on
+type registry struct {
+	lock      sync.Mutex
+	inherited map[Socket][]*os.File
+	// taken is closed once the endpoints listen on every inherited socket
+	taken  chan struct{}
+	active []listening
+	// activated are the sockets passed by systemd by canonical address, kept open so the endpoints restarted
+	// by a reload listen on them again
+	activated map[Socket]*os.File
+	// split are the ports the unspecified addresses of both families are listened on, apart
+	split map[string]bool
+}
+
+// sockets are the sockets of the process, shared by the servers of every tenant
+var sockets = &registry{inherited: make(map[Socket][]*os.File), activated: make(map[Socket]*os.File)}
+
+// Listen returns a listener on the address, the socket inherited from the previous process when there is one
+func Listen(ctx context.Context, conf net.ListenConfig, network, address string) (net.Listener, error) {
+	key := Socket{Network: network, Address: address}
+	if file, ok := sockets.take(key); ok {
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err == nil {
+			logger.Info("listening on the inherited socket", "network", network, "address", address)
+			sockets.add(key, listener)
+			return listener, nil
+		}
+		logger.Warn("cannot use the inherited socket", "network", network, "address", address, "err", err)
+	}
+	listener, err := conf.Listen(ctx, sockets.listenNetwork(network, address), address)
+	if err != nil {
+		return nil, err
+	}
+	sockets.add(key, listener)
+	return listener, nil
+}
+
+// ListenPacket returns a packet connection on the address, a socket inherited from the previous process when there is one.
+// Several sockets may listen on the same address, they are handed over to the next process in the same order
+func ListenPacket(ctx context.Context, conf net.ListenConfig, network, address string) (net.PacketConn, error) {
+	key := Socket{Network: network, Address: address}
+	if file, ok := sockets.take(key); ok {
+		conn, err := net.FilePacketConn(file)
+		_ = file.Close()
+		if err == nil {
+			logger.Debug("listening on the inherited socket", "network", network, "address", address)
+			sockets.add(key, conn)
+			return conn, nil
+		}
+		logger.Warn("cannot use the inherited socket", "network", network, "address", address, "err", err)
+	}
+	conn, err := conf.ListenPacket(ctx, sockets.listenNetwork(network, address), address)
+	if err != nil {
+		return nil, err
+	}
+	sockets.add(key, conn)
+	return conn, nil
+}
+
+// SetListenAddresses gives the addresses of all the endpoints of the process, it must be called before they listen.
+// [::]:53 binds both families (dual stack) like :53, unless 0.0.0.0:53 is listened on too: each one then binds its
+// own family only
+func SetListenAddresses(addresses []string) {
+	v4, v6 := make(map[string]bool), make(map[string]bool)
+	for _, address := range addresses {
+		host, port, err := net.SplitHostPort(address)
+		if ip, parseErr := netip.ParseAddr(host); err == nil && parseErr == nil && ip.IsUnspecified() {
+			v4[port] = v4[port] || ip.Is4()
+			v6[port] = v6[port] || ip.Is6()
+		}
+	}
+	split := make(map[string]bool)
+	for port := range v6 {
+		if v4[port] {
+			split[port] = true
+			logger.Info("listening on the unspecified addresses of each family apart", "port", port)
+		}
+	}
+	sockets.lock.Lock()
+	defer sockets.lock.Unlock()
+	sockets.split = split
+}
+
+// listenNetwork returns the network binding the address: an IPv4 or an IPv6 host binds its own family only, except
+// the unspecified IPv6 address binding both families unless its port is split. An empty host binds both families.
+// The sockets are still known by the network of the configuration
+func (r *registry) listenNetwork(network, address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return network
+	}
+	ip, err := netip.ParseAddr(host)
+	switch {
+	case err != nil:
+		return network
+	case ip.Unmap().Is4():
+		return network + "4"
+	case ip.IsUnspecified() && !r.splitPort(port):
+		return network
+	default:
+		return network + "6"
+	}
+}
+
+func (r *registry) splitPort(port string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.split[port]
+}
+
+// take returns a socket inherited for the key, the sockets are taken in the order they have been handed over,
+// or a copy of the socket passed by systemd for its address
+func (r *registry) take(key Socket) (*os.File, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	files := r.inherited[key]
+	if len(files) == 0 {
+		return r.takeActivated(key)
+	}
+	r.inherited[key] = files[1:]
+	if r.pending() == 0 && r.taken != nil {
+		close(r.taken)
+		r.taken = nil
+	}
+	return files[0], true
+}
+
+// takeActivated returns a copy of the socket passed by systemd for the address of the key, the endpoints listening
+// on several sockets share it
+func (r *registry) takeActivated(key Socket) (*os.File, bool) {
+	file, ok := r.activated[Socket{Network: key.Network, Address: canonical(key.Address)}]
+	if !ok {
+		return nil, false
+	}
+	res, err := duplicate(file)
+	if err != nil {
+		logger.Warn("cannot use the socket passed by systemd", "network", key.Network, "address", key.Address, "err", err)
+		return nil, false
+	}
+	return res, true
+}
+
+// activate keeps a socket passed by systemd until the process stops
+func (r *registry) activate(key Socket, file *os.File) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key.Address = canonical(key.Address)
+	if _, ok := r.activated[key]; ok {
+		logger.Warn("ignoring the socket passed twice by systemd", "network", key.Network, "address", key.Address)
+		_ = file.Close()
+		return
+	}
+	logger.Info("socket passed by systemd", "network", key.Network, "address", key.Address)
+	r.activated[key] = file
+}
+
+// canonical returns the address without its host when it is unspecified, so :53, 0.0.0.0:53 and [::]:53 are the same
+func canonical(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = ""
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// inherit keeps the sockets received from the previous process until the endpoints listen on their address
+func (r *registry) inherit(keys []Socket, files []*os.File) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, key := range keys {
+		r.inherited[key] = append(r.inherited[key], files[i])
+	}
+	if r.pending() > 0 && r.taken == nil {
+		r.taken = make(chan struct{})
+	}
+}
+
+// waitTaken waits for the endpoints to listen on the inherited sockets, the ones whose address is no longer
+// in the configuration are never taken
+func (r *registry) waitTaken(timeout time.Duration) {
+	r.lock.Lock()
+	taken := r.taken
+	r.lock.Unlock()
+	if taken == nil {
+		return
+	}
+	select {
+	case <-taken:
+	case <-time.After(timeout):
+	}
+}
+
+func (r *registry) pending() int {
+	res := 0
+	for _, files := range r.inherited {
+		res += len(files)
+	}
+	return res
+}
+
+// add records the socket the endpoints listen on, the closed sockets of the stopped endpoints are forgotten
+func (r *registry) add(key Socket, conn any) {
+	s, ok := conn.(socket)
+	if !ok {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.prune()
+	r.active = append(r.active, listening{Socket: key, conn: s})
+}
+
+// files returns a copy of the sockets the endpoints listen on, the caller closes them
+func (r *registry) files() ([]Socket, []*os.File) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.prune()
+	keys := make([]Socket, 0, len(r.active))
+	files := make([]*os.File, 0, len(r.active))
+	for _, l := range r.active {
+		file, err := l.conn.File()
+		if err != nil {
+			logger.Warn("cannot hand the socket over", "network", l.Network, "address", l.Address, "err", err)
+			continue
+		}
+		keys = append(keys, l.Socket)
+		files = append(files, file)
+	}
+	return keys, files
+}
+
+// closeUnused closes the inherited sockets no endpoint listens on, their address is no longer in the configuration
+func (r *registry) closeUnused() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, files := range r.inherited {
+		if len(files) > 0 {
+			logger.Info("closing the inherited sockets no longer used", "network", key.Network, "address", key.Address, "count", len(files))
+		}
+		closeFiles(files)
+	}
+	r.inherited = make(map[Socket][]*os.File)
+	if r.taken != nil {
+		close(r.taken)
+		r.taken = nil
+	}
+}
+
+func (r *registry) prune() {
+	active := r.active[:0]
+	for _, l := range r.active {
+		if !closed(l.conn) {
+			active = append(active, l)
+		}
+	}
+	r.active = active
+}
+
+// closed tells if the socket has been closed by its endpoint
+func closed(s socket) bool {
+	raw, err := s.SyscallConn()
+	if err != nil {
+		return true
+	}
+	return raw.Control(func(uintptr) {}) != nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		_ = file.Close()
+	}
+}
@@ -0,0 +1,48 @@
+package handoff
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestListenNetwork(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{":53", "udp"},
+		{"0.0.0.0:53", "udp4"},
+		{"192.168.1.1:53", "udp4"},
+		{"[::]:53", "udp"},
+		{"[::1]:53", "udp6"},
+		{"[fe80::1%eth0]:53", "udp6"},
+		{"localhost:53", "udp"},
+	}
+	for _, tt := range tests {
+		if got := sockets.listenNetwork("udp", tt.address); got != tt.want {
+			t.Errorf("listenNetwork(%s) = %s, want %s", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestListen_DualStack(t *testing.T) {
+	ctx := context.Background()
+	v4, err := Listen(ctx, net.ListenConfig{}, "tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v4.Close()
+	_, port, _ := net.SplitHostPort(v4.Addr().String())
+	SetListenAddresses([]string{"0.0.0.0:" + port, "[::]:" + port})
+	defer SetListenAddresses(nil)
+	// the unspecified IPv6 address is bound apart from the IPv4 one on the same port
+	v6, err := Listen(ctx, net.ListenConfig{}, "tcp", net.JoinHostPort("::", port))
+	if err != nil {
+		t.Skip("no IPv6 support: " + err.Error())
+	}
+	defer v6.Close()
+	if v6.Addr().(*net.TCPAddr).IP.To4() != nil {
+		t.Errorf("expecting an IPv6 listener, got %s", v6.Addr())
+	}
+}
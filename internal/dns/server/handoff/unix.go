@@ -0,0 +1,93 @@
+//go:build !windows
+
+package handoff
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// maxFilesPerMessage is the number of sockets sent per message, linux refuses more than 253 descriptors
+const maxFilesPerMessage = 200
+
+// sendFiles sends the descriptors of the files, the first message starts with their count
+func sendFiles(conn *net.UnixConn, files []*os.File) error {
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(files)))
+	for start := 0; start == 0 || start < len(files); start += maxFilesPerMessage {
+		end := min(start+maxFilesPerMessage, len(files))
+		fds, err := descriptors(files[start:end])
+		if err != nil {
+			return err
+		}
+		if _, _, err := conn.WriteMsgUnix(header, syscall.UnixRights(fds...), nil); err != nil {
+			return err
+		}
+		header = []byte{0}
+	}
+	return nil
+}
+
+// descriptors returns the descriptors of the files, without File.Fd which would switch the sockets
+// shared with the endpoints to blocking mode
+func descriptors(files []*os.File) ([]int, error) {
+	res := make([]int, 0, len(files))
+	for _, file := range files {
+		raw, err := file.SyscallConn()
+		if err != nil {
+			return nil, err
+		}
+		if err := raw.Control(func(fd uintptr) { res = append(res, int(fd)) }); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// receiveFiles receives the descriptors sent by sendFiles
+func receiveFiles(conn *net.UnixConn) ([]*os.File, error) {
+	header := make([]byte, 4)
+	oob := make([]byte, syscall.CmsgSpace(maxFilesPerMessage*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(header, oob)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(header) {
+		return nil, errors.New("truncated message")
+	}
+	count := int(binary.BigEndian.Uint32(header))
+	files, err := parseRights(oob[:oobn])
+	for err == nil && len(files) < count {
+		var more []*os.File
+		if _, oobn, _, _, err = conn.ReadMsgUnix(header[:1], oob); err == nil {
+			more, err = parseRights(oob[:oobn])
+			files = append(files, more...)
+		}
+	}
+	if err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+	return files, nil
+}
+
+func parseRights(oob []byte) ([]*os.File, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var res []*os.File
+	for _, message := range messages {
+		fds, err := syscall.ParseUnixRights(&message)
+		if err != nil {
+			closeFiles(res)
+			return nil, err
+		}
+		for _, fd := range fds {
+			res = append(res, os.NewFile(uintptr(fd), "handoff"))
+		}
+	}
+	return res, nil
+}
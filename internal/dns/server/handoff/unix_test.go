@@ -0,0 +1,80 @@
+//go:build !windows
+
+package handoff
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServe_keepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "handoff.sock")
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Serve(path, nil, nil); err == nil {
+		t.Errorf("expecting an error for a regular file on the path of the socket")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("expecting the regular file to be kept, got %q, %v", data, err)
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	ctx := context.Background()
+	previous, err := Listen(ctx, net.ListenConfig{}, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer previous.Close()
+
+	path := filepath.Join(t.TempDir(), "handoff.sock")
+	stopped := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(path, func() map[string][]byte {
+			return map[string][]byte{"": []byte("entries")}
+		}, func() { close(stopped) })
+	}()
+
+	var transfer *Transfer
+	for transfer == nil {
+		// wait for the previous process to listen on path
+		if transfer, err = Receive(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := string(transfer.Cache("")); got != "entries" {
+		t.Errorf("Transfer.Cache() = %q, want the cache of the previous process", got)
+	}
+	next, err := Listen(ctx, net.ListenConfig{}, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+	if next.Addr().String() != previous.Addr().String() {
+		t.Fatalf("expecting the inherited socket on %s, got %s", previous.Addr(), next.Addr())
+	}
+	if err := transfer.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+	if err := <-served; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+
+	previous.Close()
+	go func() {
+		if conn, err := net.Dial("tcp", next.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := next.Accept()
+	if err != nil {
+		t.Fatalf("expecting the inherited socket to accept the connections once the previous one is closed, got %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package handoff
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// errNotSupported is returned on windows, where the sockets cannot be passed to another process over a unix socket
+var errNotSupported = errors.New("the hand-off of the sockets is not supported on windows")
+
+func sendFiles(_ *net.UnixConn, _ []*os.File) error {
+	return errNotSupported
+}
+
+func receiveFiles(_ *net.UnixConn) ([]*os.File, error) {
+	return nil, errNotSupported
+}
+
+func checkPeer(_ *net.UnixConn) error {
+	return errNotSupported
+}
+
+// Activate takes no socket on windows, where systemd does not run
+func Activate() (int, error) {
+	return 0, nil
+}
+
+func duplicate(_ *os.File) (*os.File, error) {
+	return nil, errNotSupported
+}
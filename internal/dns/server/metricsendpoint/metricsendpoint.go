@@ -0,0 +1,79 @@
+package metricsendpoint
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/metrics"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("metricsendpoint")
+
+const (
+	metricsPath     = "/metrics"
+	shutdownTimeout = 5 * time.Second
+)
+
+var _ endpoint.Endpoint = &MetricsEndpoint{}
+
+// MetricsEndpoint serves the metrics of the server over http, in the prometheus format
+type MetricsEndpoint struct {
+	laddr   string
+	metrics *metrics.Metrics
+	started atomic.Bool
+}
+
+// NewMetricsEndpoint create a new endpoint serving the given metrics
+func NewMetricsEndpoint(address string, m *metrics.Metrics) *MetricsEndpoint {
+	return &MetricsEndpoint{
+		laddr:   address,
+		metrics: m,
+	}
+}
+
+// SetChain implements endpoint.Endpoint, the metrics do not depend on the chain
+func (e *MetricsEndpoint) SetChain(*resolver.ResolverChain) {}
+
+// Start implements endpoint.Endpoint
+func (e *MetricsEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if !e.started.CompareAndSwap(false, true) {
+		panic("endpoint is already started")
+	}
+	logger.Info("starting metrics endpoint", "address", e.laddr)
+	listener, err := handoff.Listen(ctx, net.ListenConfig{}, "tcp", e.laddr)
+	if err != nil {
+		return errors.New("cannot listen on tcp " + e.laddr + ": " + err.Error())
+	}
+	wg.Add(1)
+	go e.run(ctx, wg, listener)
+	return nil
+}
+
+func (e *MetricsEndpoint) run(ctx context.Context, wg *sync.WaitGroup, listener net.Listener) {
+	defer wg.Done()
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, e.metrics.Handler())
+	server := &http.Server{Addr: e.laddr, Handler: mux, ReadHeaderTimeout: shutdownTimeout}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("metrics endpoint failed", "address", e.laddr, "err", err)
+	}
+	logger.Info("metrics endpoint stopped", "address", e.laddr)
+}
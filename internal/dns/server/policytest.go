@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	"github.com/bluguard/dnshield/internal/dns/client/offline"
+	"github.com/bluguard/dnshield/internal/dns/client/pause"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/policytest"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+)
+
+// PolicyTest replays the scenarios against the access list and the resolver chain of the configuration, without starting
+// the server. The chain is the one of the server with the stages asking the network stubbed: the questions are never
+// asked to the upstream, the forwarders or the devices of the local network, the ones reaching them are forwarded
+func PolicyTest(conf configuration.ServerConf, scenarios []policytest.Scenario) ([]policytest.Result, error) {
+	access, err := conf.AccessList()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	block, initBlocker := buildBlocker(conf, nil)
+	blockClient, matcher, initGroups := buildGroups(ctx, wg, conf, block)
+	if err := initBlocker(ctx); err != nil {
+		return nil, err
+	}
+	if err := initGroups(ctx); err != nil {
+		return nil, err
+	}
+	s := &Server{maintenance: maintenance.NewSwitch(), offline: offline.NewSwitch(false), pause: pause.NewSwitch(clock.Real{})}
+	chain := s.buildChain(conf, chainParts{
+		block: block, blockClient: blockClient, matcher: matcher, policy: buildPolicy(ctx, conf),
+		custom: buildCustom(conf), hosts: buildHosts(ctx, wg, conf), leases: buildLeases(ctx, wg, conf), cache: buildCache(ctx, wg, conf),
+		remote: func(stage string) resolver.Resolver {
+			return unasked(stage)
+		},
+	})
+
+	res := make([]policytest.Result, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		question, err := scenario.Question()
+		if err != nil {
+			return nil, err
+		}
+		result := policytest.Result{Scenario: scenario}
+		client := scenario.ClientIP()
+		switch access.Check(client) {
+		case acl.Refuse:
+			result.Outcome = policytest.Refused
+		case acl.Drop:
+			result.Outcome = policytest.Dropped
+		default:
+			records, answeredBy, _ := chain.Explain(ctx, request.Request{Client: client}, question)
+			switch answeredBy {
+			case "":
+				result.Outcome = policytest.Forwarded
+			case "Block", "Policy", resolver.CloakingName:
+				result.Outcome = policytest.Blocked
+			default:
+				result.Outcome = policytest.Local
+			}
+			for _, record := range records {
+				result.Answers = append(result.Answers, record.Value())
+			}
+		}
+		res = append(res, result)
+	}
+	return res, nil
+}
+
+// unasked is a stage asking the network in the policy test, it never answers so its questions are forwarded
+type unasked string
+
+// Name implements resolver.Resolver
+func (u unasked) Name() string {
+	return string(u)
+}
+
+// Resolve implements resolver.Resolver
+func (u unasked) Resolve(dto.Question) (dto.Record, bool) {
+	return dto.Record{}, false
+}
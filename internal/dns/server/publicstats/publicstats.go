@@ -0,0 +1,106 @@
+package publicstats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/handoff"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("publicstats")
+
+const (
+	statsPath       = "/stats"
+	shutdownTimeout = 5 * time.Second
+)
+
+var _ endpoint.Endpoint = &PublicStatsEndpoint{}
+var _ http.Handler = &PublicStatsEndpoint{}
+
+// publicStats is the sanitized view of the stats, it must never contain client addresses nor domains
+type publicStats struct {
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	Queries       uint64            `json:"queries"`
+	Failures      uint64            `json:"failures"`
+	AnsweredBy    map[string]uint64 `json:"answered_by"`
+}
+
+// PublicStatsEndpoint serves read only aggregated stats over http, without authentication
+type PublicStatsEndpoint struct {
+	laddr   string
+	stats   *stats.Stats
+	started atomic.Bool
+}
+
+// NewPublicStatsEndpoint create a new endpoint serving the given stats
+func NewPublicStatsEndpoint(address string, s *stats.Stats) *PublicStatsEndpoint {
+	return &PublicStatsEndpoint{
+		laddr: address,
+		stats: s,
+	}
+}
+
+// SetChain implements endpoint.Endpoint, the stats do not depend on the chain
+func (e *PublicStatsEndpoint) SetChain(*resolver.ResolverChain) {}
+
+// Start implements endpoint.Endpoint
+func (e *PublicStatsEndpoint) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	if !e.started.CompareAndSwap(false, true) {
+		panic("endpoint is already started")
+	}
+	logger.Info("starting public stats endpoint", "address", e.laddr)
+	listener, err := handoff.Listen(ctx, net.ListenConfig{}, "tcp", e.laddr)
+	if err != nil {
+		return errors.New("cannot listen on tcp " + e.laddr + ": " + err.Error())
+	}
+	wg.Add(1)
+	go e.run(ctx, wg, listener)
+	return nil
+}
+
+func (e *PublicStatsEndpoint) run(ctx context.Context, wg *sync.WaitGroup, listener net.Listener) {
+	defer wg.Done()
+
+	mux := http.NewServeMux()
+	mux.Handle(statsPath, e)
+	server := &http.Server{Addr: e.laddr, Handler: mux, ReadHeaderTimeout: shutdownTimeout}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("public stats endpoint failed", "address", e.laddr, "err", err)
+	}
+	logger.Info("public stats endpoint stopped", "address", e.laddr)
+}
+
+// ServeHTTP implements http.Handler
+func (e *PublicStatsEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	snapshot := e.stats.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_ = json.NewEncoder(w).Encode(publicStats{
+		UptimeSeconds: int64(snapshot.Uptime.Seconds()),
+		Queries:       snapshot.Queries,
+		Failures:      snapshot.Failures,
+		AnsweredBy:    snapshot.Resolvers,
+	})
+}
@@ -0,0 +1,39 @@
+package publicstats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/stats"
+)
+
+func TestPublicStatsEndpoint_ServeHTTP(t *testing.T) {
+	s := stats.NewStats()
+	s.Query()
+	s.Answer("Block")
+	s.Query()
+	s.Failure()
+
+	e := NewPublicStatsEndpoint("127.0.0.1:0", s)
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, statsPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expecting status 200, got %d", recorder.Code)
+	}
+	var got publicStats
+	if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Queries != 2 || got.Failures != 1 || got.AnsweredBy["Block"] != 1 {
+		t.Fatalf("unexpected stats %v", got)
+	}
+
+	recorder = httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, statsPath, nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expecting status 405, got %d", recorder.Code)
+	}
+}
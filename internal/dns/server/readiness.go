@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/offline"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+)
+
+// upstreamProbe is an upstream asked directly to check the readiness, out of the cache, the chaos rules and the counters
+type upstreamProbe struct {
+	name   string
+	client client.Client
+}
+
+// buildProbes returns the probes of the upstreams of the configuration, none without external resolution
+func buildProbes(conf configuration.ServerConf) []upstreamProbe {
+	if !conf.AllowExternal {
+		return nil
+	}
+	sources := conf.UpstreamSources()
+	res := make([]upstreamProbe, 0, len(sources))
+	for _, source := range sources {
+		// the probes do not open connections ahead of their questions
+		source.Prewarm = false
+		res = append(res, upstreamProbe{name: upstreamName(source), client: buildSource(source, "upstream", nil)})
+	}
+	return res
+}
+
+// Readiness implements admin.API, the server is ready when the canary name is answered through the chain and at least
+// one upstream answers, unless the external resolution is off
+func (s *Server) Readiness(ctx context.Context) admin.Readiness {
+	s.lock.RLock()
+	chain, probes, canary := s.chain, s.probes, s.conf.Admin.CanaryName()
+	s.lock.RUnlock()
+
+	res := admin.Readiness{Upstreams: make([]admin.Probe, len(probes))}
+	wg := sync.WaitGroup{}
+	for i, probe := range probes {
+		wg.Add(1)
+		go func(i int, probe upstreamProbe) {
+			defer wg.Done()
+			start := time.Now()
+			_, err := client.ResolveSet(ctx, probe.client, request.Request{}, canary, dto.A)
+			res.Upstreams[i] = newProbe(probe.name, start, err)
+		}(i, probe)
+	}
+	start := time.Now()
+	_, _, err := chain.Explain(ctx, request.Request{}, dto.Question{Name: canary, Type: dto.A, Class: dto.IN})
+	res.Chain = newProbe(canary, start, err)
+	wg.Wait()
+
+	upstream := len(probes) == 0 || s.offline.Enabled()
+	for _, probe := range res.Upstreams {
+		upstream = upstream || probe.OK
+	}
+	res.Ready = res.Chain.OK && upstream
+	return res
+}
+
+// newProbe returns the outcome of the question asked since start, a negative answer tells the resolver answers
+func newProbe(name string, start time.Time, err error) admin.Probe {
+	res := admin.Probe{Name: name, OK: answered(err), Duration: time.Since(start)}
+	if err != nil && !res.OK {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// answered tells whether the error is an answer of the resolver rather than a failure to get one, the names out of
+// the cache are not answered on purpose during the maintenance or while the external resolution is off
+func answered(err error) bool {
+	var name *client.NameError
+	var nodata *client.NoDataError
+	var refused *client.RefusedError
+	return err == nil || errors.As(err, &name) || errors.As(err, &nodata) || errors.As(err, &refused) ||
+		errors.Is(err, client.ErrNotFound) || errors.Is(err, client.ErrBlocked) || errors.Is(err, maintenance.ErrMaintenance) || errors.Is(err, offline.ErrOffline)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+)
+
+// ruleEdits are the allowed domains and the block rules added or removed through the admin api.
+// They are applied over the rules of every configuration, so they survive the reloads until the server stops
+type ruleEdits struct {
+	lock sync.Mutex
+	// allow and block map a pattern to true when it was added, false when it was removed
+	allow map[string]bool
+	block map[string]bool
+}
+
+func newRuleEdits() *ruleEdits {
+	return &ruleEdits{allow: make(map[string]bool), block: make(map[string]bool)}
+}
+
+// set records the addition or the removal of a rule
+func (e *ruleEdits) set(kind admin.RuleKind, pattern string, added bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if kind == admin.AllowRule {
+		e.allow[pattern] = added
+	} else {
+		e.block[pattern] = added
+	}
+}
+
+// forget forgets the edits the rules already keep: the added rules they contain and the removed ones they miss
+func (e *ruleEdits) forget(rules admin.Rules) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for pattern, added := range e.allow {
+		if added == slices.Contains(rules.Allow, pattern) {
+			delete(e.allow, pattern)
+		}
+	}
+	for pattern, added := range e.block {
+		if added == slices.Contains(rules.Block, pattern) {
+			delete(e.block, pattern)
+		}
+	}
+}
+
+// apply returns the configuration with the edited rules, conf is not modified
+func (e *ruleEdits) apply(conf configuration.ServerConf) configuration.ServerConf {
+	if e == nil {
+		return conf
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	conf.AllowDomains = edit(conf.AllowDomains, e.allow)
+	conf.BlockRules = edit(conf.BlockRules, e.block)
+	return conf
+}
+
+// edit returns the rules without the removed ones, followed by the added ones in order
+func edit(rules []string, edits map[string]bool) []string {
+	if len(edits) == 0 {
+		return rules
+	}
+	res := make([]string, 0, len(rules)+len(edits))
+	known := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if added, ok := edits[rule]; (!ok || added) && !known[rule] {
+			res = append(res, rule)
+			known[rule] = true
+		}
+	}
+	added := make([]string, 0, len(edits))
+	for rule, ok := range edits {
+		if ok && !known[rule] {
+			added = append(added, rule)
+		}
+	}
+	sort.Strings(added)
+	return append(res, added...)
+}
+
+// checkRule returns the rule trimmed, or an error when the blocker cannot use it
+func checkRule(edit admin.RuleEdit) (string, error) {
+	pattern := strings.TrimSpace(edit.Pattern)
+	switch edit.Kind {
+	case admin.AllowRule:
+		if pattern == "" || pattern == "*" || pattern == "*." {
+			return "", errors.New("invalid allowed domain " + edit.Pattern)
+		}
+	case admin.BlockRule:
+		if err := blocker.CheckRule(pattern); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("unknown kind of rule " + string(edit.Kind) + ", expecting allow or block")
+	}
+	return pattern, nil
+}
+
+// Rules implements admin.API
+func (s *Server) Rules() admin.Rules {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return admin.Rules{Allow: append([]string{}, s.conf.AllowDomains...), Block: append([]string{}, s.conf.BlockRules...)}
+}
+
+// AddRule implements admin.API
+func (s *Server) AddRule(edit admin.RuleEdit) (admin.Rules, error) {
+	return s.editRule(edit, true)
+}
+
+// RemoveRule implements admin.API
+func (s *Server) RemoveRule(edit admin.RuleEdit) (admin.Rules, error) {
+	return s.editRule(edit, false)
+}
+
+// editRule changes the rule in the running blocker and in the configuration, the reloads are waited for
+// so a blocker being built does not miss the change
+func (s *Server) editRule(edit admin.RuleEdit, added bool) (admin.Rules, error) {
+	pattern, err := checkRule(edit)
+	if err != nil {
+		return admin.Rules{}, err
+	}
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	if s.rules == nil {
+		s.rules = newRuleEdits()
+	}
+	s.rules.set(edit.Kind, pattern, added)
+
+	s.lock.Lock()
+	s.conf = s.rules.apply(s.conf)
+	conf, b := s.conf, s.blocker
+	s.lock.Unlock()
+	if added {
+		s.snapshot(conf, string(edit.Kind)+" rule "+pattern+" added")
+	} else {
+		s.snapshot(conf, string(edit.Kind)+" rule "+pattern+" removed")
+	}
+	switch {
+	case edit.Kind == admin.AllowRule && added:
+		b.Allow(pattern)
+	case edit.Kind == admin.AllowRule:
+		b.Disallow(pattern)
+	case added:
+		err = b.AddRule(pattern)
+	default:
+		b.RemoveRule(pattern)
+	}
+	logger.Info("rule edited until the server stops", "kind", edit.Kind, "pattern", pattern, "added", added)
+	return s.Rules(), err
+}
+
+// ForgetRuleEdits forgets the rules edited through the api which are part of the rules of the configuration,
+// like the ones pushed to the repository of the network policy, so a later configuration can change them again
+func (s *Server) ForgetRuleEdits(rules admin.Rules) {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	if s.rules != nil {
+		s.rules.forget(rules)
+	}
+}
+
+// Stats implements admin.API
+func (s *Server) Stats() stats.Snapshot {
+	s.lock.RLock()
+	b, c := s.blocker, s.cache
+	s.lock.RUnlock()
+	res := s.stats.Snapshot()
+	if b != nil {
+		res.BlockedDomains, res.BlockerMemory = b.Len(), b.Memory()
+	}
+	if c != nil {
+		res.CacheEntries = c.Len()
+		res.CacheMemory, res.CacheCapacity = c.Usage()
+		_, _, res.CacheEvictions = c.Counters()
+	}
+	return res
+}
+
+// Top implements admin.API
+func (s *Server) Top(n int) stats.Top {
+	return s.stats.Top(n)
+}
+
+// Window implements admin.API
+func (s *Server) Window(period stats.Period, n int) (stats.Activity, stats.Top) {
+	return s.stats.Window(period, n)
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/recursive"
+	"github.com/bluguard/dnshield/internal/dns/client/udp"
+	"github.com/bluguard/dnshield/internal/dns/selftest"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+)
+
+// SelfTest measures the defenses against the spoofed answers of the upstream client of the configuration,
+// by asking questions to a local fake upstream. The first upstream is tested when there are several
+func SelfTest(conf configuration.ServerConf, questions int) (selftest.Report, error) {
+	source := conf.UpstreamSources()[0]
+	switch source.Type {
+	case "DOH", "DOT", "DOQ":
+		return selftest.Report{}, errors.New("the " + source.Type + " upstream is encrypted, its answers cannot be spoofed off path")
+	case "RECURSIVE":
+		return selftest.Run(func(address string) client.Client {
+			return recursive.NewStubClient(selftest.Zone, []string{address})
+		}, questions)
+	default:
+		return selftest.Run(func(address string) client.Client {
+			return udp.NewUDPClient(address)
+		}, questions)
+	}
+}
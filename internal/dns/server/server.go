@@ -1,41 +1,189 @@
 package server
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"errors"
+	"io"
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/bluguard/dnshield/internal/dns/acl"
+	"github.com/bluguard/dnshield/internal/dns/alert"
+	"github.com/bluguard/dnshield/internal/dns/cache"
 	"github.com/bluguard/dnshield/internal/dns/cache/memorycache"
 	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/client/audit"
 	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/client/chaos"
+	"github.com/bluguard/dnshield/internal/dns/client/coalesce"
+	"github.com/bluguard/dnshield/internal/dns/client/dnssec"
 	"github.com/bluguard/dnshield/internal/dns/client/doh"
+	"github.com/bluguard/dnshield/internal/dns/client/doq"
+	"github.com/bluguard/dnshield/internal/dns/client/dot"
+	"github.com/bluguard/dnshield/internal/dns/client/family"
+	"github.com/bluguard/dnshield/internal/dns/client/groups"
+	"github.com/bluguard/dnshield/internal/dns/client/healthcheck"
+	"github.com/bluguard/dnshield/internal/dns/client/hosts"
 	inmemoryclient "github.com/bluguard/dnshield/internal/dns/client/inMemoryClient"
+	"github.com/bluguard/dnshield/internal/dns/client/leases"
+	"github.com/bluguard/dnshield/internal/dns/client/mdns"
+	"github.com/bluguard/dnshield/internal/dns/client/multiclient"
+	"github.com/bluguard/dnshield/internal/dns/client/noise"
+	"github.com/bluguard/dnshield/internal/dns/client/nxcache"
+	"github.com/bluguard/dnshield/internal/dns/client/offline"
+	"github.com/bluguard/dnshield/internal/dns/client/override"
+	"github.com/bluguard/dnshield/internal/dns/client/pause"
+	"github.com/bluguard/dnshield/internal/dns/client/recursive"
+	"github.com/bluguard/dnshield/internal/dns/client/router"
+	"github.com/bluguard/dnshield/internal/dns/client/schedule"
 	"github.com/bluguard/dnshield/internal/dns/client/udp"
+	"github.com/bluguard/dnshield/internal/dns/dnstap"
+	"github.com/bluguard/dnshield/internal/dns/fingerprint"
+	"github.com/bluguard/dnshield/internal/dns/maintenance"
+	"github.com/bluguard/dnshield/internal/dns/metrics"
+	"github.com/bluguard/dnshield/internal/dns/policy"
+	"github.com/bluguard/dnshield/internal/dns/privacy"
+	"github.com/bluguard/dnshield/internal/dns/querylog"
+	"github.com/bluguard/dnshield/internal/dns/ratelimit"
 	"github.com/bluguard/dnshield/internal/dns/resolver"
+	"github.com/bluguard/dnshield/internal/dns/server/acmeendpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
 	"github.com/bluguard/dnshield/internal/dns/server/configuration"
 	"github.com/bluguard/dnshield/internal/dns/server/endpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint/certificate"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint/dohendpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint/dotendpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/endpoint/tcpendpoint"
 	"github.com/bluguard/dnshield/internal/dns/server/endpoint/udpendpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/grpcapi"
+	"github.com/bluguard/dnshield/internal/dns/server/metricsendpoint"
+	"github.com/bluguard/dnshield/internal/dns/server/publicstats"
+	"github.com/bluguard/dnshield/internal/dns/server/snapshots"
+	"github.com/bluguard/dnshield/internal/dns/sortlist"
+	"github.com/bluguard/dnshield/internal/dns/stats"
+	"github.com/bluguard/dnshield/internal/dns/tracing"
 	blockparser "github.com/bluguard/dnshield/internal/dns/util/blockParser"
+	"github.com/bluguard/dnshield/internal/dns/util/clock"
+	"github.com/bluguard/dnshield/internal/dns/util/lifecycle"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+)
+
+var logger = logging.Component("server")
+
+// ErrNoEndpoints is the failure of a reload leaving the server without endpoints, neither the ones of the configuration
+// nor the previous ones could start
+var ErrNoEndpoints = errors.New("the server runs without endpoints")
+
+// defaultSnapshotInterval is the interval between two snapshots of the cache when none is configured
+const defaultSnapshotInterval = 5 * time.Minute
+
+// defaultPrefetchLead is the duration before their expiry the popular entries are prefetched when none is configured
+const defaultPrefetchLead = 10 * time.Second
+
+// offlineStaleWindow is the time the cache keeps the expired records to answer them offline, unless metered
+const offlineStaleWindow = 7 * 24 * time.Hour
+
+// defaultNoiseTTL is the minimum time the answers to the noise domains are kept in the cache when none is configured
+const defaultNoiseTTL = 6 * 60 * 60
+
+// defaultHostsInterval is the interval between two checks of the hosts file when the configuration does not set it
+const defaultHostsInterval = 10 * time.Second
+
+// stopTimeout is the time each component of a stopped server is waited for
+const (
+	stopTimeout = 10 * time.Second
+	// alertRefresh is the interval the failures are checked at, so their alert is cleared once they stop
+	alertRefresh = 10 * time.Second
+	// certificateCheck is the interval the certificates of the endpoints are checked at when they are reloaded
+	certificateCheck = time.Minute
 )
 
 type Server struct {
-	chain     resolver.ResolverChain
+	chain     *resolver.ResolverChain
 	endpoints []endpoint.Endpoint
 	started   bool
-	//http controller
-	cancelFunc context.CancelFunc
+	stats     *stats.Stats
+	metrics   *metrics.Metrics
+	// provisioner obtains the acme certificates of the endpoints, nil when disabled
+	provisioner *certificate.Provisioner
+	// failures counts the questions answered with SERVFAIL across the reloads, alerting over the threshold of the configuration
+	failures *alert.Monitor
+	// maintenance is kept across the reloads, only the api changes it
+	maintenance *maintenance.Switch
+	// offline turns the external resolution off across the reloads, only the api changes it
+	offline *offline.Switch
+	// pause suspends the blocking across the reloads until the end of the pauses started through the api
+	pause *pause.Switch
+	// rules are the rules edited through the api, applied over every configuration
+	rules *ruleEdits
+	// history keeps the snapshots of the configurations run with their edited rules, across the reloads
+	history *snapshots.History
+	// lock guards the components replaced by Reconfigure and read by the apis
+	lock    sync.RWMutex
+	conf    configuration.ServerConf
+	blocker *blocker.Blocker
+	// groups holds the blockers of the client groups, nil without group
+	groups *groups.Groups
+	policy policy.Policy
+	cache  *memorycache.MemoryCache
+	// custom holds the local names of the configuration
+	custom *inmemoryclient.InMemoryClient
+	// hosts holds the local names of the hosts file, nil without file
+	hosts *hosts.Hosts
+	// leases holds the hostnames of the DHCP leases, nil without lease source
+	leases *leases.Leases
+	// chaos is nil unless the chaos mode is enabled
+	chaos *chaos.Injector
+	// hints are kept across the reloads while enabled, nil when disabled
+	hints *fingerprint.Fingerprints
+	// queryLog is nil when disabled
+	queryLog *querylog.Logger
+	// anonymizer anonymizes the clients recorded by the query log, nil to keep their addresses
+	anonymizer *privacy.Anonymizer
+	// probes are the upstreams asked to check the readiness, empty without external resolution
+	probes []upstreamProbe
+	// warm is the snapshot of the cache handed over by the previous process, loaded by the first cache built
+	warm []byte
+	// listsLoaded is closed once the blocking lists of the running configuration are loaded
+	listsLoaded chan struct{}
+	// reloading serializes the reconfigurations
+	reloading sync.Mutex
+	// components runs the background tasks of the server, wg is done once they are stopped
+	components *lifecycle.Manager
+	wg         *sync.WaitGroup
+	// chainTasks runs the background tasks of the current chain
+	chainTasks *lifecycle.Component
+	// cacheTasks runs the collection and the snapshots of the cache, which outlives the chains while its configuration is the same
+	cacheTasks *lifecycle.Component
+	// endpointsTasks runs the current endpoints, the ones of endpointsConf
+	endpointsTasks *lifecycle.Component
+	endpointsConf  configuration.ServerConf
+	// tapTasks emits the dnstap messages of tap, the stream outlives the chains while its configuration is the same
+	tap      *dnstap.Logger
+	tapTasks *lifecycle.Component
+	// tracerTasks exports the spans of tracer, which outlives the chains while its configuration is the same
+	tracer      *tracing.Tracer
+	tracerTasks *lifecycle.Component
+}
+
+// cacheHolder is an endpoint using the cache of the server
+type cacheHolder interface {
+	SetCache(c cache.Cache)
 }
 
-func (s *Server) Start(conf configuration.ServerConf) *sync.WaitGroup {
+// Start starts the server with the configuration, it returns the wait group of the server which is done once it is stopped.
+// The error tells the server does not serve as configured, like an endpoint unable to listen on its address
+func (s *Server) Start(conf configuration.ServerConf) (*sync.WaitGroup, error) {
 	if s.started {
-		log.Println("server already started")
+		logger.Warn("server already started")
 	}
-	log.Println("starting server ...")
+	logger.Info("starting server")
 	s.started = true
 
 	ch := make(chan os.Signal, 1)
@@ -48,94 +196,1308 @@ func (s *Server) Start(conf configuration.ServerConf) *sync.WaitGroup {
 			memDump(conf.Memdump)
 		}
 
-		if s.cancelFunc != nil {
-			s.cancelFunc()
-		}
+		s.Stop()
 	}()
 
-	wg := s.Reconfigure(conf)
-	log.Println("server started")
-	return wg
+	s.reloading.Lock()
+	wg, err := s.reconfigure(conf, "start")
+	s.reloading.Unlock()
+	if err != nil {
+		return wg, err
+	}
+	logger.Info("server started")
+	return wg, nil
+
+}
+
+// WarmCache loads the snapshot in the cache when the server starts, it must be called before Start
+func (s *Server) WarmCache(snapshot []byte) {
+	s.warm = snapshot
+}
 
+// SaveCache writes the entries of the cache to w, to be loaded by WarmCache
+func (s *Server) SaveCache(w io.Writer) (int, error) {
+	s.lock.RLock()
+	cache := s.cache
+	s.lock.RUnlock()
+	if cache == nil {
+		return 0, nil
+	}
+	return cache.Save(w)
 }
 
+// Stop stops the endpoints, then the background tasks of the chain and the cache, it returns once they are over.
+// The endpoints no longer accept queries but answer the ones they already received until their drain timeout,
+// the chain serves them until then
 func (s *Server) Stop() {
-	if s.cancelFunc != nil {
-		s.cancelFunc()
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	if s.components == nil {
+		return
+	}
+	// an endpoint draining its queries is waited for the drain and the resolution of its last query
+	timeout := max(stopTimeout, drainTimeout(s.conf)+endpoint.QueryTimeout)
+	if err := s.components.Stop(timeout); err != nil {
+		logger.Error("server stopped with components still running", "err", err)
+	}
+}
+
+// Reconfigure applies the configuration to the server, it returns the wait group of the server which is done once it is stopped.
+// A new chain is built and handed over to the running endpoints, the queries in progress are answered by the previous one.
+// The endpoints are only restarted when their configuration changes, the cache and its entries are kept when its configuration is the same.
+// When the endpoints cannot start the rest of the configuration is applied and the error returned, the next reconfiguration starts them again
+func (s *Server) Reconfigure(conf configuration.ServerConf) (*sync.WaitGroup, error) {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	return s.reconfigure(conf, "reload")
+}
+
+// reconfigure applies the configuration and records its snapshot with the reason of the change, the reloads must be locked
+func (s *Server) reconfigure(conf configuration.ServerConf, reason string) (*sync.WaitGroup, error) {
+	if s.isStopped() {
+		s.components = lifecycle.NewManager()
+		s.wg = &sync.WaitGroup{}
+		s.wg.Add(1)
+		go func(components *lifecycle.Manager, wg *sync.WaitGroup) {
+			<-components.Done()
+			wg.Done()
+		}(s.components, s.wg)
+		s.endpoints, s.endpointsTasks, s.chainTasks = nil, nil, nil
+		// the cache of the stopped server is no longer collected nor saved
+		s.cache, s.cacheTasks = nil, nil
+		s.tap, s.tapTasks = nil, nil
+		s.tracer, s.tracerTasks = nil, nil
+	}
+
+	if s.stats == nil {
+		s.stats = stats.NewStats()
+		s.stats.SetCaching("Cache")
+	}
+	if s.metrics == nil {
+		s.metrics = metrics.NewMetrics()
+	}
+	if s.failures == nil {
+		s.failures = alert.NewMonitor(clock.Real{})
+		s.metrics.SetFailureRate(s.failures)
+	}
+	s.failures.SetThreshold(uint64(conf.Alerts.ServFailPerMinute), buildAlertHook(conf))
+	if s.maintenance == nil {
+		s.maintenance = maintenance.NewSwitch()
+	}
+	if s.offline == nil {
+		s.offline = offline.NewSwitch(false)
+	}
+	if s.pause == nil {
+		s.pause = pause.NewSwitch(clock.Real{})
+	}
+	if s.rules == nil {
+		s.rules = newRuleEdits()
+	}
+	conf = s.rules.apply(conf)
+	history := openHistory(s.history, conf)
+	s.lock.Lock()
+	s.history = history
+	s.lock.Unlock()
+
+	cache, cacheTasks := s.cache, s.cacheTasks
+	if cache != nil && s.conf.SameCache(conf) && !s.conf.SameSources(conf) {
+		// the cache is kept, its answers are not the ones of the new sources
+		logger.Info("flushing the cache, its sources have changed", "entries", cache.Len())
+		cache.Clear()
+	} else if cache != nil && s.conf.SameCache(conf) {
+		logger.Info("keeping the entries of the cache", "entries", cache.Len())
+	} else {
+		var err error
+		cacheTasks, err = s.components.Start("cache", lifecycle.Storage, func(ctx context.Context, wg *sync.WaitGroup) error {
+			cache = buildCache(ctx, wg, conf)
+			return nil
+		})
+		if err != nil {
+			return s.wg, err
+		}
+		if s.warm != nil {
+			loadWarmCache(cache, s.warm)
+			s.warm = nil
+		}
+	}
+
+	tap, tapTasks := s.tap, s.tapTasks
+	if tapTasks == nil || s.conf.Dnstap != conf.Dnstap {
+		var err error
+		tapTasks, err = s.components.Start("dnstap", lifecycle.Storage, func(ctx context.Context, wg *sync.WaitGroup) error {
+			tap = openDnstap(ctx, wg, conf)
+			return nil
+		})
+		if err != nil {
+			return s.wg, err
+		}
+	}
+	tracer, tracerTasks := s.tracer, s.tracerTasks
+	if tracerTasks == nil || s.conf.Tracing != conf.Tracing {
+		var err error
+		tracerTasks, err = s.components.Start("tracing", lifecycle.Storage, func(ctx context.Context, wg *sync.WaitGroup) error {
+			tracer = startTracer(ctx, wg, conf)
+			return nil
+		})
+		if err != nil {
+			return s.wg, err
+		}
+	}
+
+	var (
+		chain     *resolver.ResolverChain
+		block     *blocker.Blocker
+		groupSet  *groups.Groups
+		policy    policy.Policy
+		custom    *inmemoryclient.InMemoryClient
+		hostsFile *hosts.Hosts
+		dhcp      *leases.Leases
+		injector  *chaos.Injector
+		queryLog  *querylog.Logger
+		anonymize *privacy.Anonymizer
+		hints     *fingerprint.Fingerprints
+		probes    []upstreamProbe
+	)
+	chainTasks, err := s.components.Start("chain", lifecycle.Processing, func(ctx context.Context, wg *sync.WaitGroup) error {
+		var initBlocker func(context.Context) error
+		block, initBlocker = buildBlocker(conf, s.loadedBlocker())
+		if conf.BlockingListsRefresh > 0 && len(conf.BlockingLists) > 0 {
+			live := block
+			blocker.StartRefresh(ctx, wg, live, time.Duration(conf.BlockingListsRefresh)*time.Second, clock.Real{}, func(ctx context.Context, next *blocker.Blocker) {
+				// the rules edited since the reload are kept
+				_ = loadBlockingLists(ctx, s.rules.apply(conf), "", next, live)
+			})
+		}
+		blockClient, matcher, initGroups := buildGroups(ctx, wg, conf, block)
+		groupSet, _ = matcher.(*groups.Groups)
+		policy = buildPolicy(ctx, conf)
+		custom = buildCustom(conf)
+		hostsFile = buildHosts(ctx, wg, conf)
+		dhcp = buildLeases(ctx, wg, conf)
+		injector = buildChaos(conf)
+		chain = s.buildChain(conf, chainParts{
+			block: block, blockClient: blockClient, matcher: matcher, policy: policy,
+			custom: custom, hosts: hostsFile, leases: dhcp, cache: cache,
+			remote: func(stage string) resolver.Resolver {
+				switch stage {
+				case "MDNS":
+					return buildMDNS(conf, cache)
+				case "Forward":
+					return buildForwarders(conf, cache, injector, s.maintenance, s.offline, s.metrics)
+				default:
+					return s.buildUpstream(ctx, wg, conf, cache, injector, tap)
+				}
+			},
+		})
+		chain.SetStats(s.stats)
+		chain.SetMetrics(s.metrics)
+		chain.SetFailureMonitor(s.failures)
+		s.failures.Watch(ctx, wg, alertRefresh)
+		chain.SetPassUnknownOptions(conf.EDNS.UnknownOptions == "pass")
+		chain.SetSorter(buildSorter(conf))
+		queryLog = openQueryLog(ctx, wg, conf)
+		chain.SetQueryLog(queryLog)
+		anonymize = s.buildAnonymizer(conf)
+		chain.SetAnonymizer(anonymize)
+		tracer.SetAnonymizer(anonymize)
+		s.stats.SetMinCount(uint64(conf.Privacy.MinCount))
+		chain.SetDnstap(tap)
+		chain.SetTracer(tracer)
+		hints = s.clientHints(conf)
+		chain.SetFingerprints(hints)
+		probes = buildProbes(conf)
+
+		s.loadLists(ctx, wg, initBlocker, initGroups)
+		return nil
+	})
+	if err != nil {
+		return s.wg, err
+	}
+	s.metrics.SetCache(cache)
+	s.metrics.SetBlocker(block)
+
+	s.lock.Lock()
+	previousCache := s.cache
+	s.cache, s.blocker, s.groups, s.policy, s.queryLog, s.chaos, s.hints = cache, block, groupSet, policy, queryLog, injector, hints
+	s.custom, s.hosts, s.leases, s.chain, s.anonymizer, s.probes = custom, hostsFile, dhcp, chain, anonymize, probes
+	s.lock.Unlock()
+
+	access := buildACL(conf)
+	var endpointsErr error
+	if s.endpoints != nil && s.endpointsConf.SameEndpoints(conf) {
+		logger.Info("reloading the chain of the running endpoints")
+		for _, e := range s.endpoints {
+			// the endpoints wait for the queries in progress before switching
+			e.SetChain(chain)
+			if holder, ok := e.(cacheHolder); ok {
+				holder.SetCache(cache)
+			}
+			if guarded, ok := e.(endpoint.Guarded); ok {
+				guarded.SetACL(access)
+			}
+			if drained, ok := e.(endpoint.Drained); ok {
+				drained.SetDrainTimeout(drainTimeout(conf))
+			}
+		}
+	} else {
+		endpointsErr = s.restartEndpoints(conf, chain, cache, access)
+	}
+	s.lock.Lock()
+	s.conf = conf
+	s.lock.Unlock()
+	s.snapshot(conf, reason)
+
+	if s.chainTasks != nil {
+		s.chainTasks.Stop()
+	}
+	s.chainTasks = chainTasks
+	if s.cacheTasks != nil && cache != previousCache {
+		s.cacheTasks.Stop()
+	}
+	s.cacheTasks = cacheTasks
+	if s.tapTasks != nil && tapTasks != s.tapTasks {
+		s.tapTasks.Stop()
+	}
+	s.tap, s.tapTasks = tap, tapTasks
+	if s.tracerTasks != nil && tracerTasks != s.tracerTasks {
+		s.tracerTasks.Stop()
+	}
+	s.tracer, s.tracerTasks = tracer, tracerTasks
+	return s.wg, endpointsErr
+}
+
+// isStopped tells if the server has to start its components, before its first start and once stopped
+func (s *Server) isStopped() bool {
+	if s.components == nil {
+		return true
+	}
+	select {
+	case <-s.components.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// ListsLoaded returns a channel closed once the blocking lists of the running configuration are loaded,
+// the lists of a replaced configuration are abandoned and their channel is never closed
+func (s *Server) ListsLoaded() <-chan struct{} {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.listsLoaded
+}
+
+// loadedBlocker returns the blocker of the running configuration once its lists are loaded, nil before
+func (s *Server) loadedBlocker() *blocker.Blocker {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.blocker == nil || s.listsLoaded == nil {
+		return nil
+	}
+	select {
+	case <-s.listsLoaded:
+		return s.blocker
+	default:
+		return nil
+	}
+}
+
+// loadLists runs the loaders of the blocking lists until ctx is done, they are added to wg
+func (s *Server) loadLists(ctx context.Context, wg *sync.WaitGroup, loaders ...func(context.Context) error) {
+	loaded := make(chan struct{})
+	s.lock.Lock()
+	s.listsLoaded = loaded
+	s.lock.Unlock()
+
+	loading := &sync.WaitGroup{}
+	wg.Add(len(loaders) + 1)
+	for _, load := range loaders {
+		loading.Add(1)
+		go func(load func(context.Context) error) {
+			defer wg.Done()
+			defer loading.Done()
+			if err := load(ctx); err != nil && ctx.Err() != nil {
+				logger.Info("the loading of the blocking lists has been abandoned", "err", err)
+			}
+		}(load)
+	}
+	go func() {
+		defer wg.Done()
+		loading.Wait()
+		if ctx.Err() == nil {
+			close(loaded)
+			logger.Info("blocking lists loaded")
+		}
+	}()
+}
+
+// buildCache instantiates the cache of the configuration, its collection and its snapshots run until ctx is done
+func buildCache(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf) *memorycache.MemoryCache {
+	baseTTL, forceBaseTTL := conf.Cache.Basettl, conf.Cache.ForceBasettl
+	if conf.Metered.Enabled {
+		baseTTL, forceBaseTTL = max(baseTTL, conf.Metered.MinTTL), true
+	}
+	cache := memorycache.NewMemoryCache(ctx, wg, conf.Resources().CacheSize, baseTTL, forceBaseTTL, 1*time.Minute)
+	eviction, err := memorycache.ParseEviction(conf.Cache.Eviction)
+	if err != nil {
+		logger.Error("error setting the cache eviction, falling back to ttl", "err", err)
+		eviction = memorycache.EvictTTL
+	}
+	cache.SetEviction(eviction)
+	if conf.Cache.AutoTune.Enabled {
+		cache.SetAutoTune(ctx, wg, conf.Cache.AutoTune.MinSize, conf.Cache.AutoTune.MaxSize, 1*time.Minute)
+	}
+	if conf.Metered.Enabled {
+		cache.SetServeStale(time.Duration(conf.Metered.ServeStale) * time.Second)
+	} else if conf.OfflineAnswer == offline.Stale.String() {
+		cache.SetServeStale(offlineStaleWindow)
+	}
+	if groups := conf.MeteredGroups(); len(groups) > 0 {
+		metered := make(map[string]memorycache.Metered, len(groups))
+		for name, group := range groups {
+			metered[name] = memorycache.Metered{
+				MinTTL:     time.Duration(group.MinTTL) * time.Second,
+				ServeStale: time.Duration(group.ServeStale) * time.Second,
+			}
+		}
+		cache.SetMetered(metered)
+	}
+	if conf.Noise.Enabled {
+		lifetime := conf.Noise.TTL
+		if lifetime == 0 {
+			lifetime = defaultNoiseTTL
+		}
+		cache.SetMinLifetime(noise.NewMatcher(conf.Noise.NoiseDomains()).Match, lifetime)
 	}
+	if conf.Cache.Snapshot != "" {
+		loadSnapshot(ctx, wg, cache, conf)
+	}
+	return cache
 }
 
-func (s *Server) Reconfigure(conf configuration.ServerConf) *sync.WaitGroup {
-	if s.cancelFunc != nil {
-		s.cancelFunc()
+// clientHints returns the fingerprints of the clients when enabled, the ones of the running chain are kept
+func (s *Server) clientHints(conf configuration.ServerConf) *fingerprint.Fingerprints {
+	if !conf.ClientHints.Enabled {
+		return nil
+	}
+	if s.hints != nil && s.conf.ClientHints == conf.ClientHints {
+		return s.hints
 	}
+	return fingerprint.NewFingerprints(conf.ClientHints.MaxClients)
+}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	s.cancelFunc = cancelFunc
+func loadWarmCache(c *memorycache.MemoryCache, snapshot []byte) {
+	count, err := c.Load(bytes.NewReader(snapshot))
+	if err != nil {
+		logger.Error("cannot load the cache of the previous process", "err", err)
+		return
+	}
+	logger.Info("loaded the cache of the previous process", "entries", count)
+}
 
-	wg := sync.WaitGroup{}
+// loadSnapshot loads the cache saved by the previous run and saves it periodically
+func loadSnapshot(ctx context.Context, wg *sync.WaitGroup, c *memorycache.MemoryCache, conf configuration.ServerConf) {
+	count, err := c.LoadFile(conf.Cache.Snapshot)
+	if err != nil {
+		logger.Error("cannot load the cache", "path", conf.Cache.Snapshot, "err", err)
+	} else {
+		logger.Info("loaded the cache", "path", conf.Cache.Snapshot, "entries", count)
+	}
+	interval := time.Duration(conf.Cache.SnapshotInterval) * time.Second
+	if interval == 0 {
+		interval = defaultSnapshotInterval
+	}
+	memorycache.StartSnapshots(ctx, wg, c, conf.Cache.Snapshot, interval, clock.Real{})
+}
 
-	cache := memorycache.NewMemoryCache(ctx, &wg, conf.Cache.Size, conf.Cache.Basettl, conf.Cache.ForceBasettl, 1*time.Minute)
+// startPrefetch refreshes the popular entries of the cache answered by the external source with it, unless the upstream
+// traffic is metered. The entries of the forwarders and of the local network are left to expire
+func startPrefetch(ctx context.Context, wg *sync.WaitGroup, c *memorycache.MemoryCache, external client.Client, conf configuration.ServerConf) {
+	if conf.Metered.Enabled {
+		logger.Info("cache prefetch disabled in metered mode")
+		return
+	}
+	lead := time.Duration(conf.Cache.Prefetch.Lead) * time.Second
+	if lead == 0 {
+		lead = defaultPrefetchLead
+	}
+	memorycache.StartPrefetch(ctx, wg, c, external, "External", conf.Cache.Prefetch.Threshold, lead, clock.Real{})
+}
+
+// buildAnonymizer returns the anonymizer of the privacy of the configuration, nil when the questions are recorded as they are.
+// The one of the running chain is kept while the privacy is the same, with the counts of the names
+func (s *Server) buildAnonymizer(conf configuration.ServerConf) *privacy.Anonymizer {
+	if conf.Privacy == (configuration.ServerConf{}).Privacy {
+		return nil
+	}
+	if s.anonymizer != nil && s.conf.Privacy == conf.Privacy {
+		return s.anonymizer
+	}
+	// the mode has been checked with the configuration
+	mode, _ := privacy.ParseMode(conf.Privacy.Clients)
+	return privacy.NewAnonymizer(mode, conf.Privacy.Salt, conf.Privacy.PrefixV4, conf.Privacy.PrefixV6, uint64(conf.Privacy.MinCount))
+}
+
+// openQueryLog opens the query log of the configuration, closed once ctx is done
+func openQueryLog(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf) *querylog.Logger {
+	if conf.QueryLog.Path == "" {
+		return nil
+	}
+	l, err := querylog.Open(conf.QueryLog.Path)
+	if err != nil {
+		logger.Error("cannot open the query log", "path", conf.QueryLog.Path, "err", err)
+		return nil
+	}
+	l.SetRotation(int64(conf.QueryLog.MaxSize)<<20, conf.QueryLog.Backups)
+	retention := time.Duration(conf.QueryLog.Retention) * time.Second
+	if days := time.Duration(conf.Privacy.RetentionDays) * 24 * time.Hour; days > 0 && (retention == 0 || days < retention) {
+		retention = days
+	}
+	if retention > 0 {
+		querylog.StartRetention(ctx, wg, l, retention, clock.Real{})
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	return l
+}
 
-	blocker, initBlocker := buildBlocker(conf)
+// openDnstap returns the logger emitting the dnstap messages until ctx is done, nil when disabled
+func openDnstap(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf) *dnstap.Logger {
+	var tap *dnstap.Logger
+	switch {
+	case conf.Dnstap.Socket != "":
+		tap = dnstap.NewSocketLogger(conf.Dnstap.Socket, conf.Dnstap.Identity)
+	case conf.Dnstap.File != "":
+		var err error
+		if tap, err = dnstap.OpenFile(conf.Dnstap.File, conf.Dnstap.Identity); err != nil {
+			logger.Error("cannot open the dnstap file", "path", conf.Dnstap.File, "err", err)
+			return nil
+		}
+	default:
+		return nil
+	}
+	tap.Start(ctx, wg)
+	return tap
+}
 
-	s.chain = *resolver.NewResolverChain([]resolver.Resolver{
-		resolver.NewClientresolver(blocker, "Block"),
-		resolver.NewClientresolver(buildCustom(conf), "Custom"),
-		resolver.NewClientresolver(cache, "Cache"),
-		resolver.NewCacheFeeder(resolver.NewClientresolver(buildExternal(conf), "External"), cache),
+// startTracer returns the tracer exporting the spans of the queries until ctx is done, nil when disabled
+func startTracer(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf) *tracing.Tracer {
+	if conf.Tracing.Endpoint == "" {
+		return nil
+	}
+	tracer, err := tracing.NewTracer(conf.Tracing.Endpoint, conf.Tracing.ServiceName, conf.Tracing.Rate())
+	if err != nil {
+		logger.Error("cannot create the tracer", "err", err)
+		return nil
+	}
+	logger.Info("tracing the queries", "collector", conf.Tracing.Endpoint, "rate", conf.Tracing.Rate())
+	tracer.Start(ctx, wg)
+	return tracer
+}
+
+// drainTimeout returns the time the stopped endpoints answer the queries they already received
+func drainTimeout(conf configuration.ServerConf) time.Duration {
+	if conf.DrainTimeout == 0 {
+		return endpoint.DefaultDrainTimeout
+	}
+	return time.Duration(conf.DrainTimeout) * time.Second
+}
+
+// restartEndpoints stops the running endpoints and starts the ones of the configuration.
+// When one of them cannot start the others are stopped and the previous endpoints are started again, the error is
+// ErrNoEndpoints when they cannot start either
+func (s *Server) restartEndpoints(conf configuration.ServerConf, chain *resolver.ResolverChain, c cache.Cache, access *acl.ACL) error {
+	previous, restart := s.endpointsConf, s.endpointsTasks != nil
+	if restart {
+		// the addresses are released before the new endpoints listen on them
+		s.endpointsTasks.Stop()
+		s.endpointsTasks.Wait()
+	}
+	err := s.startEndpoints(conf, chain, c, access)
+	if err == nil || !restart {
+		return err
+	}
+	logger.Error("cannot start the endpoints of the configuration, starting the previous ones again", "err", err)
+	if restoreErr := s.startEndpoints(previous, chain, c, buildACL(previous)); restoreErr != nil {
+		return errors.Join(ErrNoEndpoints, err, restoreErr)
+	}
+	return err
+}
+
+// startEndpoints starts the endpoints of the configuration, none runs when one of them cannot start
+func (s *Server) startEndpoints(conf configuration.ServerConf, chain *resolver.ResolverChain, c cache.Cache, access *acl.ACL) error {
+	s.endpoints = s.createEndpoints(conf, chain, c)
+	tasks, err := s.components.Start("endpoints", lifecycle.Serving, func(ctx context.Context, wg *sync.WaitGroup) error {
+		for _, e := range s.endpoints {
+			if instrumented, ok := e.(endpoint.Instrumented); ok {
+				instrumented.SetMetrics(s.metrics)
+			}
+			if guarded, ok := e.(endpoint.Guarded); ok {
+				guarded.SetACL(access)
+			}
+			if drained, ok := e.(endpoint.Drained); ok {
+				drained.SetDrainTimeout(drainTimeout(conf))
+			}
+			if err := e.Start(ctx, wg); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		s.endpoints, s.endpointsTasks = nil, nil
+		return err
+	}
+	s.endpointsTasks, s.endpointsConf = tasks, conf
+	return nil
+}
+
+func (s *Server) createEndpoints(conf configuration.ServerConf, chain *resolver.ResolverChain, c cache.Cache) []endpoint.Endpoint {
+	var endpoints []endpoint.Endpoint
+	provisioner := s.buildProvisioner(conf)
+	// the endpoint of the configuration is optional once the endpoints are listed
+	if conf.Endpoint.Address != "" || len(conf.Endpoints) == 0 {
+		udpEndpoint := udpendpoint.NewUDPEndpoint(conf.Endpoint.Address, chain)
+		udpEndpoint.SetRateLimiter(buildRateLimiter(conf))
+		udpEndpoint.SetWorkers(conf.Resources().UDPWorkers)
+		udpEndpoint.SetHandlers(conf.Resources().UDPHandlers)
+		endpoints = append(endpoints, udpEndpoint)
+		if conf.Endpoint.TCP {
+			// tcp has its own buckets, so the clients told to retry over tcp by a slip are not refused
+			tcpEndpoint := tcpendpoint.NewTCPEndpoint(conf.Endpoint.Address, chain)
+			tcpEndpoint.SetRateLimiter(buildRateLimiter(conf))
+			endpoints = append(endpoints, tcpEndpoint)
+		}
+	}
+	if conf.Doh.Address != "" {
+		dohEndpoint := dohendpoint.NewDOHEndpoint(conf.Doh.Address, conf.Doh.Path, conf.Doh.Cert, conf.Doh.Key, chain)
+		if conf.Doh.ACME {
+			dohEndpoint.SetTLSConfig(provisioner.TLSConfig("h2", "http/1.1"))
+		}
+		endpoints = append(endpoints, dohEndpoint)
+	}
+	for _, e := range conf.Endpoints {
+		switch e.Protocol {
+		case "udp":
+			udpEndpoint := udpendpoint.NewUDPEndpoint(e.Address, chain)
+			udpEndpoint.SetRateLimiter(buildRateLimiter(conf))
+			udpEndpoint.SetWorkers(conf.Resources().UDPWorkers)
+			udpEndpoint.SetHandlers(conf.Resources().UDPHandlers)
+			endpoints = append(endpoints, udpEndpoint)
+		case "tcp":
+			tcpEndpoint := tcpendpoint.NewTCPEndpoint(e.Address, chain)
+			tcpEndpoint.SetRateLimiter(buildRateLimiter(conf))
+			endpoints = append(endpoints, tcpEndpoint)
+		case "doh":
+			dohEndpoint := dohendpoint.NewDOHEndpoint(e.Address, e.Path, e.Cert, e.Key, chain)
+			if e.ACME {
+				dohEndpoint.SetTLSConfig(provisioner.TLSConfig("h2", "http/1.1"))
+			}
+			endpoints = append(endpoints, dohEndpoint)
+		case "dot":
+			dotEndpoint := dotendpoint.NewDOTEndpoint(e.Address, e.Cert, e.Key, chain)
+			dotEndpoint.SetRateLimiter(buildRateLimiter(conf))
+			if e.Reload {
+				dotEndpoint.SetReload(certificateCheck)
+			}
+			if e.ACME {
+				dotEndpoint.SetTLSConfig(provisioner.TLSConfig("dot"))
+			}
+			endpoints = append(endpoints, dotEndpoint)
+		}
+	}
+	if provisioner != nil && conf.ACME.HTTPAddress != "" {
+		endpoints = append(endpoints, acmeendpoint.NewACMEEndpoint(conf.ACME.HTTPAddress, provisioner))
+	}
+	if conf.Grpc.Address != "" {
+		endpoints = append(endpoints, grpcapi.NewGrpcEndpoint(conf.Grpc.Address, chain, c, s.stats, s))
+	}
+	if conf.PublicStats.Address != "" {
+		endpoints = append(endpoints, publicstats.NewPublicStatsEndpoint(conf.PublicStats.Address, s.stats))
+	}
+	if conf.Metrics.Address != "" {
+		endpoints = append(endpoints, metricsendpoint.NewMetricsEndpoint(conf.Metrics.Address, s.metrics))
+	}
+	if conf.Admin.Address != "" {
+		adminEndpoint := admin.NewAdminEndpoint(conf.Admin.Address, s)
+		adminEndpoint.SetMutationLimit(conf.Admin.MutationsPerMinute)
+		if conf.Admin.AuditLog != "" {
+			audit, err := admin.OpenAuditLog(conf.Admin.AuditLog)
+			if err != nil {
+				logger.Error("error opening the audit log, the admin changes are only logged", "path", conf.Admin.AuditLog, "err", err)
+			}
+			adminEndpoint.SetAuditLog(audit)
+		}
+		endpoints = append(endpoints, adminEndpoint)
+	}
+	return endpoints
+}
+
+// buildProvisioner returns the provisioner of the acme certificates of the endpoints, nil when disabled.
+// It is kept while the acme configuration is the same, so the certificates are not requested again on reload
+func (s *Server) buildProvisioner(conf configuration.ServerConf) *certificate.Provisioner {
+	if len(conf.ACME.Domains) == 0 {
+		s.provisioner = nil
+		return nil
+	}
+	if s.provisioner == nil || !s.conf.SameACME(conf) {
+		s.provisioner = certificate.NewProvisioner(conf.ACME.Domains, conf.ACME.Email, conf.ACME.CacheDir, conf.ACME.Directory)
+	}
+	return s.provisioner
+}
 
-	s.endpoints = createEndpoints(conf, &s.chain)
+// buildAlertHook returns the hook posting the alerts to the webhook of the configuration, nil when they are only logged
+func buildAlertHook(conf configuration.ServerConf) alert.Hook {
+	if conf.Alerts.Webhook == "" {
+		return nil
+	}
+	return alert.Webhook(conf.Alerts.Webhook)
+}
 
-	for _, endpoint := range s.endpoints {
-		wg.Add(1)
-		endpoint.Start(ctx, &wg)
+// buildRateLimiter returns the limiter of the queries of the clients, nil when they are not limited
+func buildRateLimiter(conf configuration.ServerConf) *ratelimit.Limiter {
+	limit := conf.Endpoint.RateLimit
+	if limit.QPS <= 0 {
+		return nil
 	}
-	initBlocker()
-	return &wg
+	slip := limit.Slip
+	if slip == 0 {
+		slip = 2
+	}
+	return ratelimit.NewLimiter(limit.QPS, limit.Burst, slip, clock.Real{})
+}
+
+// chainParts are the components the resolver chain is built with, the server keeps them to manage them
+type chainParts struct {
+	block       *blocker.Blocker
+	blockClient client.Client
+	matcher     blocker.Matcher
+	policy      policy.Policy
+	custom      *inmemoryclient.InMemoryClient
+	hosts       *hosts.Hosts
+	leases      *leases.Leases
+	cache       *memorycache.MemoryCache
+	// remote returns the stage asking the network, MDNS, Forward or External
+	remote func(stage string) resolver.Resolver
 }
 
-func createEndpoints(conf configuration.ServerConf, chain *resolver.ResolverChain) []endpoint.Endpoint {
-	return []endpoint.Endpoint{
-		udpendpoint.NewUDPEndpoint(conf.Endpoint.Address, chain),
+// buildChain returns the resolver chain of the configuration, its stages in the configured order. The server and the
+// policy test share it so the scenarios go through the stages of the running server
+func (s *Server) buildChain(conf configuration.ServerConf, parts chainParts) *resolver.ResolverChain {
+	resolvers := make([]resolver.Resolver, 0, 5)
+	if filter := buildTypeFilter(conf, s.metrics); filter != nil {
+		resolvers = append(resolvers, filter)
+	}
+	if checks := buildHealthChecks(conf); checks != nil {
+		resolvers = append(resolvers, resolver.NewClientresolver(checks, "Health"))
+	}
+	if parts.policy != nil {
+		policyResolver := resolver.NewPolicyResolver(parts.policy, "Policy")
+		policyResolver.SetResponse(parts.block.Response())
+		resolvers = append(resolvers, policyResolver)
+	}
+	if conf.Maintenance.StatusDomain != "" {
+		resolvers = append(resolvers, resolver.NewClientresolver(maintenance.NewStatusClient(s.maintenance, conf.Maintenance.StatusDomain, conf.Maintenance.Hint), "Status"))
+	}
+	resolvers = append(resolvers,
+		resolver.NewClientresolver(pause.NewClient(parts.blockClient, s.pause), "Block"),
+	)
+	// the names are blocked as asked, the following resolvers answer their SafeSearch variant
+	if safeSearch := buildSafeSearch(conf); safeSearch != nil {
+		resolvers = append(resolvers, safeSearch)
+	}
+	resolvers = append(resolvers, resolver.NewClientresolver(buildOverrides(conf), "Override"))
+	if rewriter := buildRewriter(conf); rewriter != nil {
+		resolvers = append(resolvers, rewriter)
+	}
+	local := []resolver.Resolver{resolver.NewClientresolver(parts.custom, "Custom")}
+	if parts.hosts != nil {
+		local = append(local, resolver.NewClientresolver(parts.hosts, "Hosts"))
+	}
+	if parts.leases != nil {
+		local = append(local, resolver.NewClientresolver(parts.leases, "Leases"))
+	}
+	resolvers = append(resolvers, local...)
+	if conf.SearchSuffix != "" {
+		resolvers = append(resolvers, resolver.NewSuffixResolver(conf.SearchSuffix, local, "Suffix"))
 	}
+	if fallback := buildSearchFallback(conf, local); fallback != nil {
+		resolvers = append(resolvers, fallback)
+	}
+	resolvers = append(resolvers, resolver.NewClientresolver(parts.cache, "Cache"))
+	if conf.MDNS.Enabled {
+		resolvers = append(resolvers, parts.remote("MDNS"))
+	}
+	if len(conf.Forwarders) > 0 {
+		resolvers = append(resolvers, parts.remote("Forward"))
+	}
+	chain := resolver.NewResolverChain(orderChain(append(resolvers, parts.remote("External")), conf.Chain))
+	chain.SetBlocking("Block", resolver.CloakingName)
+	if g, ok := parts.matcher.(*groups.Groups); ok {
+		chain.SetGroups(g)
+	}
+	if conf.Blocking.CNAMECloaking {
+		cloaking := blocker.NewCloaking(parts.matcher)
+		cloaking.SetPaused(s.pause.Paused)
+		chain.SetCloaker(cloaking)
+	}
+	return chain
 }
 
-func buildExternal(conf configuration.ServerConf) client.Client {
+// buildUpstream returns the last resolver of the chain: the external sources feeding the cache,
+// or the answer of the offline mode when the external resolution is not allowed, or switched off through the api
+func (s *Server) buildUpstream(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf, cache *memorycache.MemoryCache, injector *chaos.Injector, tap *dnstap.Logger) resolver.Resolver {
+	answer, err := offline.ParseAnswer(conf.OfflineAnswer)
+	if err != nil {
+		logger.Error("error creating the offline answer, refusing", "err", err)
+	}
 	if !conf.AllowExternal {
-		panic("unexpected")
+		logger.Info("external resolution disabled, only the local names are answered", "answer", answer.String())
+		// the delegate is never asked, the cache answers its stale records when it keeps them
+		upstream := resolver.NewCacheFeeder(resolver.NewClientresolver(offline.NewOfflineClient(answer), "Offline"), cache)
+		upstream.SetOffline(offline.NewSwitch(true), answer)
+		return upstream
+	}
+	external := buildExternal(ctx, wg, conf, s.stats, s.metrics, injector, tap)
+	// the clients asking the same question on a cache miss share the answer of the upstream
+	external = coalesce.NewClient(external)
+	if conf.Cache.Prefetch.Threshold > 0 {
+		startPrefetch(ctx, wg, cache, offline.NewSwitchedClient(external, s.offline), conf)
+	}
+	upstream := resolver.NewCacheFeeder(resolver.NewClientresolver(external, "External"), cache)
+	upstream.SetMaintenance(s.maintenance)
+	upstream.SetOffline(s.offline, answer)
+	return upstream
+}
+
+func buildExternal(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf, s *stats.Stats, counters *metrics.Metrics, injector *chaos.Injector, tap *dnstap.Logger) client.Client {
+	external := buildSources(conf, injector, counters)
+	if conf.Dnstap.Upstream {
+		external = tap.Wrap(external)
+	}
+	if conf.Audit.Rate > 0 && conf.Audit.Reference.Endpoint != "" {
+		external = audit.NewAuditClient(external, buildUpstream(conf.Audit.Reference, "audit", counters), conf.Audit.Rate, s)
+	}
+	if len(conf.StubZones) > 0 {
+		zones := router.NewRouter(external)
+		for _, stub := range conf.StubZones {
+			zones.Route(stub.Zone, recursive.NewStubClient(stub.Zone, stub.Servers))
+		}
+		external = zones
+	}
+	if conf.NXDomainCache.TTL > 0 && conf.Resources().NXDomainCacheSize > 0 {
+		external = nxcache.NewNXCache(ctx, wg, external, time.Duration(conf.NXDomainCache.TTL)*time.Second, conf.Resources().NXDomainCacheSize, 1*time.Minute)
+	}
+	return external
+}
+
+// buildSources returns the client of the external source, or of the upstreams when there are several
+func buildSources(conf configuration.ServerConf, injector *chaos.Injector, counters *metrics.Metrics) client.Client {
+	strategy, err := multiclient.ParseStrategy(conf.Upstreams.Strategy)
+	if err != nil {
+		logger.Error("error creating the upstreams, falling back to failover", "err", err)
+		strategy = multiclient.Failover
+	}
+	return buildMultiClient(conf.UpstreamSources(), "upstream", strategy, injector, counters)
+}
+
+// buildMultiClient returns the client of the source, or a multiclient when there are several.
+// Every upstream fails according to the rules of the injector, when not nil, and reports its connections in the counters under the role,
+// its questions are spans of the traced queries
+func buildMultiClient(sources []configuration.ExternalSource, role string, strategy multiclient.Strategy, injector *chaos.Injector, counters *metrics.Metrics) client.Client {
+	if len(sources) == 1 {
+		return tracing.Wrap(upstreamName(sources[0]), injector.Wrap(upstreamName(sources[0]), buildSource(sources[0], role, counters)))
+	}
+	res := multiclient.NewMultiClient(strategy)
+	for _, source := range sources {
+		res.Add(source.Type+" "+source.Endpoint, tracing.Wrap(upstreamName(source), injector.Wrap(upstreamName(source), buildSource(source, role, counters))))
+	}
+	return res
+}
+
+// upstreamName returns the name of the source matched by the chaos rules, its endpoint or its type without endpoint
+func upstreamName(source configuration.ExternalSource) string {
+	if source.Endpoint == "" {
+		return source.Type
+	}
+	return source.Endpoint
+}
+
+// buildChaos returns the injector of the failures of the chaos mode, nil when disabled
+func buildChaos(conf configuration.ServerConf) *chaos.Injector {
+	if !conf.Chaos.Enabled {
+		return nil
+	}
+	logger.Warn("chaos mode enabled, injecting failures in front of the upstreams", "rules", len(conf.Chaos.Rules))
+	return chaos.NewInjector(conf.Chaos.Rules)
+}
+
+// buildForwarders returns the resolver of the zones forwarded to their own upstream, their answers are cached
+func buildForwarders(conf configuration.ServerConf, cache *memorycache.MemoryCache, injector *chaos.Injector, m *maintenance.Switch, off *offline.Switch, counters *metrics.Metrics) resolver.Resolver {
+	res := resolver.NewForwardResolver("Forward")
+	answer, _ := offline.ParseAnswer(conf.OfflineAnswer)
+	for _, f := range conf.Forwarders {
+		forward := resolver.NewCacheFeeder(resolver.NewClientresolver(buildMultiClient(f.Sources(), "forward", multiclient.Failover, injector, counters), "Forward"), cache)
+		forward.SetMaintenance(m)
+		forward.SetOffline(off, answer)
+		res.Forward(f.Zone, forward)
 	}
-	switch conf.External.Type {
+	return res
+}
+
+// buildMDNS returns the resolver of the names of the local network asked with multicast DNS, their answers are cached.
+// When the multicast queries cannot be sent, the names of the zones are answered as not existing instead of leaking to the upstream
+func buildMDNS(conf configuration.ServerConf, cache *memorycache.MemoryCache) resolver.Resolver {
+	var lan *mdns.Client
+	if conf.MDNS.Bridge != "" {
+		lan = mdns.NewBridgeClient(conf.MDNS.Bridge, conf.MDNS.Suffixes)
+	} else {
+		var err error
+		if lan, err = mdns.NewClient(conf.MDNS.Interface, conf.MDNS.Suffixes); err != nil {
+			logger.Error("cannot send the mdns queries, the names of the local network do not exist", "interface", conf.MDNS.Interface, "err", err)
+			lan = mdns.NewUnavailableClient(conf.MDNS.Suffixes)
+		}
+	}
+	if conf.MDNS.Timeout > 0 {
+		lan.SetTimeout(time.Duration(conf.MDNS.Timeout) * time.Millisecond)
+	}
+	res := resolver.NewForwardResolver("MDNS")
+	cached := resolver.NewCacheFeeder(resolver.NewClientresolver(lan, "MDNS"), cache)
+	for _, zone := range conf.MDNS.Zones() {
+		res.Forward(zone, cached)
+	}
+	return res
+}
+
+// buildSource returns the client of the source applying its DNSSEC policy
+func buildSource(source configuration.ExternalSource, role string, counters *metrics.Metrics) client.Client {
+	policy, err := dnssec.ParsePolicy(source.DNSSEC)
+	if err != nil {
+		logger.Error("error creating the DNSSEC policy, ignoring the AD bit", "upstream", upstreamName(source), "err", err)
+	}
+	return dnssec.Wrap(policy, buildUpstream(source, role, counters))
+}
+
+// buildUpstream returns the client of the protocol of the source, the connections of a DOH upstream are reported in the counters under the role
+func buildUpstream(source configuration.ExternalSource, role string, counters *metrics.Metrics) client.Client {
+	// the family has been validated with the configuration
+	fam, _ := family.Parse(source.Family)
+	switch source.Type {
 	case "DOH":
-		return doh.NewDOHClient(conf.External.Endpoint)
+		res := doh.NewDOHClient(source.Endpoint)
+		res.SetFamily(fam)
+		res.SetTimeout(upstreamTimeout(source, doh.DefaultTimeout), source.RetryCount(doh.DefaultRetries))
+		res.SetPool(int(source.Connections), time.Duration(source.IdleTimeout)*time.Second)
+		counters.SetPool(role, source.Endpoint, res)
+		if source.Prewarm {
+			// the handshakes are over before the first queries, the prewarm ends at the timeout of the client
+			go func() {
+				if err := res.Prewarm(); err != nil {
+					logger.Warn("cannot open a connection to the upstream", "upstream", source.Endpoint, "err", err)
+				}
+			}()
+		}
+		return res
+	case "DOT":
+		res := dot.NewDOTClient(source.Endpoint, source.ServerName)
+		res.SetFamily(fam)
+		return res
+	case "DOQ":
+		res := doq.NewDOQClient(source.Endpoint, source.ServerName)
+		res.SetFamily(fam)
+		return res
+	case "RECURSIVE":
+		return recursive.NewRecursiveClient()
 	default:
-		return udp.NewUDPClient(conf.External.Endpoint)
+		res := udp.NewUDPClient(source.Endpoint)
+		res.SetTimeout(upstreamTimeout(source, udp.DefaultTimeout), source.RetryCount(udp.DefaultRetries))
+		res.SetRandomCase(!source.KeepCase)
+		res.SetFamily(fam)
+		return res
+	}
+}
+
+// upstreamTimeout returns the timeout of the source, def when it has none
+func upstreamTimeout(source configuration.ExternalSource, def time.Duration) time.Duration {
+	if source.Timeout == 0 {
+		return def
 	}
+	return time.Duration(source.Timeout) * time.Millisecond
 }
 
-func buildCustom(conf configuration.ServerConf) client.Client {
+func buildCustom(conf configuration.ServerConf) *inmemoryclient.InMemoryClient {
 	res := inmemoryclient.InMemoryClient{}
+	for _, r := range conf.Reverse {
+		if err := res.AddReverse(r.Address, r.Name); err != nil {
+			logger.Error("error creating the reverse record", "address", r.Address, "err", err)
+		}
+	}
 	for _, v := range conf.Custom {
 		err := res.Add(v.Name, v.Address)
 		if err != nil {
-			log.Println("error creating inmemory source ", err)
+			logger.Error("error creating the custom name", "name", v.Name, "err", err)
+		}
+	}
+	for _, s := range conf.LocalServices() {
+		if err := res.AddService(s); err != nil {
+			logger.Error("error publishing the service", "service", s.Name(), "err", err)
+		}
+	}
+	for _, group := range conf.ClientGroups {
+		if len(group.Custom) == 0 {
+			continue
+		}
+		view := res.AddView(group.Name)
+		for _, v := range group.Custom {
+			if err := view.Add(v.Name, v.Address); err != nil {
+				logger.Error("error creating the custom name of the group", "group", group.Name, "name", v.Name, "err", err)
+			}
 		}
 	}
 
 	return &res
 }
 
-func buildBlocker(conf configuration.ServerConf) (client.Client, func()) {
-	res := make(blocker.Blocker, 10000)
-	return &res, func() {
-		go func() {
-			for _, url := range conf.BlockingLists {
-				parser := blockparser.BlockParser{Url: url}
-				res.Init(parser.Feed)
+// orderChain returns the stages in the order of the configured chain, all of them in their default order when the chain
+// is empty. The stages left out of the chain are not asked, the listed ones which are not enabled are skipped
+func orderChain(stages []resolver.Resolver, chain []string) []resolver.Resolver {
+	if len(chain) == 0 {
+		return stages
+	}
+	res := make([]resolver.Resolver, 0, len(chain))
+	for _, name := range chain {
+		for _, stage := range stages {
+			if strings.EqualFold(stageName(stage), name) {
+				res = append(res, stage)
+			}
+		}
+	}
+	return res
+}
+
+// stageName returns the name of the stage of the resolver in the configured chain
+func stageName(r resolver.Resolver) string {
+	if r.Name() == "Offline" {
+		// the upstreams are replaced by the offline answer without external resolution
+		return "External"
+	}
+	return r.Name()
+}
+
+// buildSearchFallback returns the resolver asking the names of the search suffix without the suffix to the local resolvers,
+// nil when disabled
+func buildSearchFallback(conf configuration.ServerConf, local []resolver.Resolver) *resolver.SuffixFallbackResolver {
+	if !conf.SearchFallback || conf.SearchSuffix == "" {
+		return nil
+	}
+	return resolver.NewSuffixFallbackResolver(conf.SearchSuffix, local, "Search")
+}
+
+// buildHealthChecks returns the client of the health checks, nil without check
+func buildHealthChecks(conf configuration.ServerConf) client.Client {
+	checks, err := conf.Checks()
+	if err != nil {
+		logger.Error("error creating the health checks", "err", err)
+		return nil
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	return healthcheck.NewHealthClient(checks)
+}
+
+// buildSafeSearch returns the resolver enforcing SafeSearch for every client or for the clients of the groups asking for it,
+// nil when no client gets it
+func buildSafeSearch(conf configuration.ServerConf) *resolver.SafeSearchResolver {
+	var names []string
+	for _, group := range conf.ClientGroups {
+		if group.SafeSearch {
+			names = append(names, group.Name)
+		}
+	}
+	if !conf.SafeSearch && len(names) == 0 {
+		return nil
+	}
+	return resolver.NewSafeSearchResolver(conf.SafeSearch, names, "SafeSearch")
+}
+
+// buildHosts loads the hosts file of the configuration and checks it for changes until ctx is done, nil without file
+func buildHosts(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf) *hosts.Hosts {
+	if conf.Hosts.Path == "" {
+		return nil
+	}
+	res, err := hosts.NewHosts(conf.Hosts.Path)
+	if err != nil {
+		logger.Error("error loading the hosts file", "path", conf.Hosts.Path, "err", err)
+	}
+	interval := time.Duration(conf.Hosts.Interval) * time.Second
+	if interval == 0 {
+		interval = defaultHostsInterval
+	}
+	hosts.StartReload(ctx, wg, res, interval, clock.Real{})
+	return res
+}
+
+// buildLeases reads the DHCP leases of the configuration and checks their sources for changes until ctx is done,
+// nil without source
+func buildLeases(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf) *leases.Leases {
+	if len(conf.Leases.Sources) == 0 {
+		return nil
+	}
+	// the format has been checked with the configuration
+	format, _ := leases.ParseFormat(conf.Leases.Format)
+	res, err := leases.NewLeases(conf.Leases.Sources, format, conf.Leases.Domain, clock.Real{})
+	if err != nil {
+		logger.Error("error reading the leases", "sources", conf.Leases.Sources, "err", err)
+	}
+	interval := time.Duration(conf.Leases.Interval) * time.Second
+	if interval == 0 {
+		interval = defaultHostsInterval
+	}
+	leases.StartReload(ctx, wg, res, interval)
+	return res
+}
+
+// buildACL returns the acl of the clients of the dns endpoints, nil when every client is allowed
+func buildACL(conf configuration.ServerConf) *acl.ACL {
+	res, err := conf.AccessList()
+	if err != nil {
+		logger.Error("error creating the acl, allowing every client", "err", err)
+	}
+	return res
+}
+
+func buildSorter(conf configuration.ServerConf) *sortlist.Sorter {
+	res, err := conf.AnswerSorter()
+	if err != nil {
+		logger.Error("error creating the answer order", "err", err)
+	}
+	return res
+}
+
+func buildOverrides(conf configuration.ServerConf) client.Client {
+	res, err := conf.Overrides()
+	if err != nil {
+		logger.Error("error creating the response groups", "err", err)
+		return override.NewOverrides()
+	}
+	return res
+}
+
+// buildRewriter returns the resolver of the rewrites, nil without rewrite
+func buildRewriter(conf configuration.ServerConf) *resolver.RewriteResolver {
+	res, err := conf.Rewriter("Rewrite")
+	if err != nil {
+		logger.Error("error creating the rewrites", "err", err)
+		return nil
+	}
+	return res
+}
+
+// buildTypeFilter returns the resolver answering the filtered types, counting them in the metrics, nil without filter
+func buildTypeFilter(conf configuration.ServerConf, m *metrics.Metrics) *resolver.TypeFilterResolver {
+	res, err := conf.TypeFilter("TypeFilter")
+	if err != nil {
+		logger.Error("error creating the type filters", "err", err)
+		return nil
+	}
+	if res != nil {
+		res.SetMetrics(m)
+	}
+	return res
+}
+
+func buildPolicy(ctx context.Context, conf configuration.ServerConf) policy.Policy {
+	if conf.Policy.Wasm == "" {
+		return nil
+	}
+	p, err := policy.LoadWasmPolicy(ctx, conf.Policy.Wasm)
+	if err != nil {
+		logger.Error("error loading the policy", "path", conf.Policy.Wasm, "err", err)
+		return nil
+	}
+	return p
+}
+
+// buildBlocker returns the blocker of the configuration and the function loading its lists. It blocks the names of
+// the lists of previous until its own lists are loaded, nothing before the first load
+func buildBlocker(conf configuration.ServerConf, previous *blocker.Blocker) (*blocker.Blocker, func(context.Context) error) {
+	res := blocker.NewBlockerWithStructure(conf.Resources().BlockerSize, conf.Resources().BlockerStructure)
+	response, err := conf.BlockingResponse()
+	if err != nil {
+		logger.Error("error creating the blocking response", "err", err)
+	}
+	res.SetResponse(response)
+	addRules(conf, res)
+	if previous == nil {
+		return res, func(ctx context.Context) error {
+			return loadBlockingLists(ctx, conf, "", res, nil)
+		}
+	}
+	// the names of the previous lists are blocked until the lists of the configuration replace them at once
+	res.ShareLists(previous)
+	return res, func(ctx context.Context) error {
+		next := blocker.NewBlockerWithStructure(max(previous.Len(), conf.Resources().BlockerSize), conf.Resources().BlockerStructure)
+		if err := loadBlockingLists(ctx, conf, "", next, previous); err != nil {
+			return err
+		}
+		res.Replace(next)
+		return nil
+	}
+}
+
+// buildGroups returns the client blocking the names of the client groups on top of the base blocker, base itself without
+// group, the names blocked for each client, and the function loading the lists of the groups. The lists of the groups
+// are refreshed like the ones of base
+func buildGroups(ctx context.Context, wg *sync.WaitGroup, conf configuration.ServerConf, base *blocker.Blocker) (client.Client, blocker.Matcher, func(context.Context) error) {
+	if len(conf.ClientGroups) == 0 {
+		return buildSchedules(conf, base, nil), base, func(context.Context) error { return nil }
+	}
+	list := make([]groups.Group, 0, len(conf.ClientGroups))
+	confs := make([]configuration.ServerConf, 0, len(conf.ClientGroups))
+	for _, group := range conf.ClientGroups {
+		networks, err := group.Networks(conf.Devices)
+		if err != nil {
+			logger.Error("error reading the clients of the group", "group", group.Name, "err", err)
+			continue
+		}
+		// a standby reads the lists of the groups from the primary too, their files are on the primary
+		groupConf := configuration.ServerConf{
+			BlockingLists:       group.BlockingLists,
+			BlockingListFormats: conf.BlockingListFormats,
+			BlockingListsCache:  conf.BlockingListsCache,
+			AllowLists:          group.AllowLists,
+			AllowDomains:        group.AllowDomains,
+			BlockRules:          group.BlockRules,
+			Follow:              conf.Follow,
+		}
+		name := group.Name
+		b := blocker.NewBlockerWithStructure(conf.Resources().BlockerSize, conf.Resources().BlockerStructure)
+		b.SetResponse(base.Response())
+		addRules(groupConf, b)
+		if conf.BlockingListsRefresh > 0 && len(group.BlockingLists) > 0 {
+			blocker.StartRefresh(ctx, wg, b, time.Duration(conf.BlockingListsRefresh)*time.Second, clock.Real{}, func(ctx context.Context, next *blocker.Blocker) {
+				_ = loadBlockingLists(ctx, groupConf, name, next, b)
+			})
+		}
+		list = append(list, groups.Group{Name: group.Name, Networks: networks, Blocker: b})
+		confs = append(confs, groupConf)
+	}
+	g := groups.NewGroups(base, list)
+	return buildSchedules(conf, g, g), g, func(ctx context.Context) error {
+		for i, group := range list {
+			if err := loadBlockingLists(ctx, confs[i], group.Name, group.Blocker, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// buildSchedules returns the client blocking the names of the open schedules in front of delegate, delegate itself without schedule
+func buildSchedules(conf configuration.ServerConf, delegate client.Client, g *groups.Groups) client.Client {
+	if len(conf.Blocking.Schedules) == 0 {
+		return delegate
+	}
+	list := make([]schedule.Schedule, 0, len(conf.Blocking.Schedules))
+	response, _ := conf.BlockingResponse()
+	for _, s := range conf.Blocking.Schedules {
+		window, err := s.Window()
+		if err != nil {
+			logger.Error("error reading the schedule", "schedule", s.Name, "err", err)
+			continue
+		}
+		b := blocker.NewBlocker(len(s.BlockRules))
+		b.SetResponse(response)
+		for _, rule := range s.BlockRules {
+			if err := b.AddRule(rule); err != nil {
+				logger.Error("error adding the rule of the schedule", "schedule", s.Name, "rule", rule, "err", err)
+			}
+		}
+		list = append(list, schedule.Schedule{Name: s.Name, Groups: s.Groups, Window: window, Blocker: b})
+	}
+	return schedule.NewClient(delegate, g, list, clock.Real{})
+}
+
+// addRules applies the allowed domains and the block rules of the configuration, available without download
+func addRules(conf configuration.ServerConf, b *blocker.Blocker) {
+	for _, domain := range conf.AllowDomains {
+		b.Allow(domain)
+	}
+	for _, rule := range conf.BlockRules {
+		if err := b.AddRule(rule); err != nil {
+			logger.Error("error adding the blocking rule", "rule", rule, "err", err)
+		}
+	}
+}
+
+// loadBlockingLists downloads the allow and blocking lists of the configuration into the blocker until ctx is done.
+// A list read partially is kept, along with the names of the list in previous when it replaces previous, nil for the
+// first load. group is the client group of the lists, empty for the lists of every client. The error of ctx is returned once done
+func loadBlockingLists(ctx context.Context, conf configuration.ServerConf, group string, b, previous *blocker.Blocker) error {
+	addRules(conf, b)
+	if conf.Follow.Primary != "" {
+		return loadPrimaryLists(ctx, conf, group, b, previous)
+	}
+	for _, url := range conf.AllowLists {
+		parser := blockparser.AllowParser{Url: url, CacheDir: conf.BlockingListsCache}
+		if err := parser.Feed(ctx, b.Allow); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("cannot read the allow list entirely", "url", url, "err", err)
+			keepAllowed(b, previous)
+		}
+	}
+	for _, url := range conf.BlockingLists {
+		parser := blockparser.BlockParser{Url: url, Format: conf.ListFormat(url), CacheDir: conf.BlockingListsCache}
+		if err := b.InitOrKeep(ctx, url, parser.Feed, previous); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warn("cannot read the blocking list entirely", "url", url, "err", err)
+		}
+	}
+	return nil
+}
+
+// keepAllowed allows the patterns allowed by previous again, an allow list which cannot be read does not block its names
+// until the next refresh. previous is nil for the first load
+func keepAllowed(b, previous *blocker.Blocker) {
+	if previous == nil {
+		return
+	}
+	for _, pattern := range previous.AllowedPatterns() {
+		b.Allow(pattern)
+	}
+}
+
+// loadPrimaryLists loads the allow and blocking lists of the group from the primary followed by the server,
+// so both block the same names even when the lists have changed since the primary downloaded them
+func loadPrimaryLists(ctx context.Context, conf configuration.ServerConf, group string, b, previous *blocker.Blocker) error {
+	allow := blockparser.AllowParser{Url: admin.AllowlistURL(conf.Follow.Primary, group), CacheDir: conf.BlockingListsCache}
+	if err := allow.Feed(ctx, b.Allow); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Warn("cannot read the allow list of the primary entirely", "primary", conf.Follow.Primary, "err", err)
+		keepAllowed(b, previous)
+	}
+	for _, source := range conf.BlockingLists {
+		// the primary serves the names it blocks as a hosts file, whatever the format of the list
+		parser := blockparser.BlockParser{Url: admin.BlocklistURL(conf.Follow.Primary, group, source), Format: blockparser.Hosts, CacheDir: conf.BlockingListsCache}
+		if err := b.InitOrKeep(ctx, source, parser.Feed, previous); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-		}()
+			logger.Warn("cannot read the blocking list of the primary entirely", "primary", conf.Follow.Primary, "list", source, "err", err)
+		}
 	}
+	return nil
 }
 
 //The optimal chain is
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+	"github.com/bluguard/dnshield/internal/dns/server/snapshots"
+)
+
+// openHistory returns the history of the snapshots of the configuration, the current one while its settings are unchanged.
+// The snapshots are kept in memory when their directory cannot be opened
+func openHistory(current *snapshots.History, conf configuration.ServerConf) *snapshots.History {
+	keep := conf.Snapshots.Keep
+	if keep <= 0 {
+		keep = snapshots.DefaultKeep
+	}
+	if current != nil && current.Dir() == conf.Snapshots.Directory && current.Keep() == keep {
+		return current
+	}
+	if conf.Snapshots.Directory == "" {
+		return snapshots.NewHistory(keep)
+	}
+	res, err := snapshots.Open(conf.Snapshots.Directory, keep)
+	if err != nil {
+		logger.Error("error opening the snapshots, keeping them in memory", "directory", conf.Snapshots.Directory, "err", err)
+		return snapshots.NewHistory(keep)
+	}
+	return res
+}
+
+// snapshot records the running configuration in the history, unless it did not change, the reloads must be locked
+func (s *Server) snapshot(conf configuration.ServerConf, reason string) {
+	if s.history == nil {
+		return
+	}
+	taken, err := s.history.Record(conf, reason, time.Now())
+	if err != nil {
+		logger.Error("error writing the snapshot of the configuration", "reason", reason, "err", err)
+	}
+	if taken {
+		logger.Debug("configuration snapshot taken", "reason", reason)
+	}
+}
+
+// Snapshots implements admin.API
+func (s *Server) Snapshots() []snapshots.Summary {
+	s.lock.RLock()
+	history := s.history
+	s.lock.RUnlock()
+	if history == nil {
+		return []snapshots.Summary{}
+	}
+	return history.List()
+}
+
+// DiffSnapshots implements admin.API
+func (s *Server) DiffSnapshots(from, to int) ([]snapshots.Change, error) {
+	before, err := s.snapshotConfiguration(from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.snapshotConfiguration(to)
+	if err != nil {
+		return nil, err
+	}
+	return snapshots.Diff(before, after)
+}
+
+// snapshotConfiguration returns the configuration of the snapshot, the running one for 0
+func (s *Server) snapshotConfiguration(id int) (configuration.ServerConf, error) {
+	if id == 0 {
+		return s.Configuration(), nil
+	}
+	s.lock.RLock()
+	history := s.history
+	s.lock.RUnlock()
+	if history == nil {
+		return configuration.ServerConf{}, snapshots.ErrNotFound
+	}
+	snapshot, err := history.Get(id)
+	return snapshot.Configuration, err
+}
+
+// Rollback implements admin.API, the edits of the rules are dropped as the snapshot holds the rules edited when it was taken
+func (s *Server) Rollback(id int) (snapshots.Summary, error) {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	if s.isStopped() || s.history == nil {
+		return snapshots.Summary{}, errors.New("the server is not running")
+	}
+	snapshot, err := s.history.Get(id)
+	if err != nil {
+		return snapshots.Summary{}, err
+	}
+	logger.Warn("rolling back the configuration", "snapshot", id, "time", snapshot.Time)
+	s.rules = newRuleEdits()
+	_, err = s.reconfigure(snapshot.Configuration, "rollback to snapshot "+strconv.Itoa(id))
+	return snapshot.Summary, err
+}
@@ -0,0 +1,92 @@
+package snapshots
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+)
+
+// Change is a setting which differs between two configurations, From is missing for an added setting
+// and To for a removed one
+type Change struct {
+	// Path is the path of the setting in the json configuration, like blocking.response or block_rules[2]
+	Path string `json:"path"`
+	From any    `json:"from,omitempty"`
+	To   any    `json:"to,omitempty"`
+}
+
+// Diff returns the settings changed from a configuration to another, in the order of their paths
+func Diff(from, to configuration.ServerConf) ([]Change, error) {
+	before, err := tree(from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := tree(to)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Change, 0)
+	compare("", before, after, &res)
+	return res, nil
+}
+
+// tree returns the configuration decoded from json as maps, slices and values
+func tree(conf configuration.ServerConf) (any, error) {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var res any
+	err = json.Unmarshal(data, &res)
+	return res, err
+}
+
+// compare appends the changes from a value to another, the objects are compared by key and the arrays by index
+func compare(path string, from, to any, changes *[]Change) {
+	switch before := from.(type) {
+	case map[string]any:
+		if after, ok := to.(map[string]any); ok {
+			keys := make([]string, 0, len(before)+len(after))
+			for key := range before {
+				keys = append(keys, key)
+			}
+			for key := range after {
+				if _, ok := before[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				compare(join(path, key), before[key], after[key], changes)
+			}
+			return
+		}
+	case []any:
+		if after, ok := to.([]any); ok {
+			for i := 0; i < max(len(before), len(after)); i++ {
+				var value, other any
+				if i < len(before) {
+					value = before[i]
+				}
+				if i < len(after) {
+					other = after[i]
+				}
+				compare(path+"["+strconv.Itoa(i)+"]", value, other, changes)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(from, to) {
+		*changes = append(*changes, Change{Path: path, From: from, To: to})
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,189 @@
+// Package snapshots keeps the history of the configurations a server ran, with the rules edited through the api,
+// so a bad change can be compared with the previous configurations and rolled back
+package snapshots
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+)
+
+// DefaultKeep is the number of snapshots kept when the configuration does not set it
+const DefaultKeep = 20
+
+// ErrNotFound is returned for a snapshot which is not in the history, never taken or already pruned
+var ErrNotFound = errors.New("snapshot not found")
+
+// Summary describes a snapshot without its configuration
+type Summary struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	// Reason is the change which led to the snapshot, like a reload or a rule edited through the api
+	Reason string `json:"reason"`
+}
+
+// Snapshot is a configuration run by the server
+type Snapshot struct {
+	Summary
+	Configuration configuration.ServerConf `json:"configuration"`
+}
+
+// History is the list of the last snapshots, oldest first, it is safe for concurrent use.
+// The snapshots are written to a directory when it is set, so they survive the restarts
+type History struct {
+	lock      sync.Mutex
+	dir       string
+	keep      int
+	snapshots []Snapshot
+	// last is the encoding of the configuration of the latest snapshot
+	last []byte
+}
+
+// NewHistory returns an empty history kept in memory, of the keep last snapshots
+func NewHistory(keep int) *History {
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	return &History{keep: keep}
+}
+
+// Open returns the history of the snapshots written to dir, the directory is created when missing
+func Open(dir string, keep int) (*History, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	res := NewHistory(keep)
+	res.dir = dir
+	for _, entry := range entries {
+		if _, ok := snapshotID(entry.Name()); !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, errors.New("invalid snapshot " + entry.Name() + ": " + err.Error())
+		}
+		res.snapshots = append(res.snapshots, snapshot)
+	}
+	sort.Slice(res.snapshots, func(i, j int) bool { return res.snapshots[i].ID < res.snapshots[j].ID })
+	if len(res.snapshots) > 0 {
+		res.last, _ = json.Marshal(res.snapshots[len(res.snapshots)-1].Configuration)
+	}
+	res.prune()
+	return res, nil
+}
+
+// Dir returns the directory of the snapshots, empty when they are only kept in memory
+func (h *History) Dir() string {
+	return h.dir
+}
+
+// Keep returns the number of snapshots kept
+func (h *History) Keep() int {
+	return h.keep
+}
+
+// Record takes a snapshot of the configuration, unless it is the one of the latest snapshot.
+// It returns whether a snapshot was taken, the error tells it could not be written
+func (h *History) Record(conf configuration.ServerConf, reason string, now time.Time) (bool, error) {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return false, err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if bytes.Equal(data, h.last) {
+		return false, nil
+	}
+	snapshot := Snapshot{Summary: Summary{ID: 1, Time: now, Reason: reason}, Configuration: conf}
+	if len(h.snapshots) > 0 {
+		snapshot.ID = h.snapshots[len(h.snapshots)-1].ID + 1
+	}
+	h.snapshots = append(h.snapshots, snapshot)
+	h.last = data
+	err = h.write(snapshot)
+	h.prune()
+	return true, err
+}
+
+// List returns the snapshots of the history, oldest first
+func (h *History) List() []Summary {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	res := make([]Summary, len(h.snapshots))
+	for i, snapshot := range h.snapshots {
+		res[i] = snapshot.Summary
+	}
+	return res
+}
+
+// Get returns the snapshot, ErrNotFound when it is not in the history
+func (h *History) Get(id int) (Snapshot, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	i := sort.Search(len(h.snapshots), func(i int) bool { return h.snapshots[i].ID >= id })
+	if i == len(h.snapshots) || h.snapshots[i].ID != id {
+		return Snapshot{}, ErrNotFound
+	}
+	return h.snapshots[i], nil
+}
+
+// write writes the snapshot to the directory, through a temporary file so a crash never leaves a truncated snapshot
+func (h *History) write(snapshot Snapshot) error {
+	if h.dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(h.dir, fileName(snapshot.ID))
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// prune removes the oldest snapshots over the number kept
+func (h *History) prune() {
+	for len(h.snapshots) > h.keep {
+		if h.dir != "" {
+			_ = os.Remove(filepath.Join(h.dir, fileName(h.snapshots[0].ID)))
+		}
+		h.snapshots = h.snapshots[1:]
+	}
+}
+
+func fileName(id int) string {
+	return "snapshot-" + strconv.Itoa(id) + ".json"
+}
+
+// snapshotID returns the id of the snapshot written to the file
+func snapshotID(name string) (int, bool) {
+	value, found := strings.CutPrefix(name, "snapshot-")
+	if !found {
+		return 0, false
+	}
+	value, found = strings.CutSuffix(value, ".json")
+	if !found {
+		return 0, false
+	}
+	id, err := strconv.Atoi(value)
+	return id, err == nil && id > 0
+}
@@ -0,0 +1,81 @@
+package snapshots
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+)
+
+func TestHistory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	h, err := Open(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	conf := configuration.Default()
+	if taken, err := h.Record(conf, "start", now); !taken || err != nil {
+		t.Fatalf("expecting a first snapshot, got %v %v", taken, err)
+	}
+	if taken, _ := h.Record(conf, "reload", now); taken {
+		t.Errorf("an unchanged configuration should not be recorded again")
+	}
+	conf.BlockRules = []string{"ads.com"}
+	_, _ = h.Record(conf, "block rule ads.com added", now)
+	conf.SafeSearch = true
+	_, _ = h.Record(conf, "reload", now)
+
+	want := []Summary{{ID: 2, Time: now, Reason: "block rule ads.com added"}, {ID: 3, Time: now, Reason: "reload"}}
+	if got := h.List(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("List() = %v, want %v", got, want)
+	}
+	if _, err := h.Get(1); err != ErrNotFound {
+		t.Errorf("expecting the oldest snapshot to be pruned, got %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Errorf("expecting the files of the 2 snapshots kept, got %d", len(files))
+	}
+
+	reopened, err := Open(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.List(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("List() after a restart = %v, want %v", got, want)
+	}
+	if taken, _ := reopened.Record(conf, "start", now); taken {
+		t.Errorf("the configuration of the last snapshot should not be recorded after a restart")
+	}
+	snapshot, err := reopened.Get(2)
+	if err != nil || !reflect.DeepEqual(snapshot.Configuration.BlockRules, []string{"ads.com"}) {
+		t.Errorf("Get(2) = %v, %v", snapshot.Configuration.BlockRules, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from := configuration.Default()
+	to := configuration.Default()
+	to.BlockRules = []string{"ads.com"}
+	to.BlockingLists = append(to.BlockingLists, "https://lists.lan/ads")
+	to.Cache.Basettl = 300
+
+	changes, err := Diff(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Path: "block_rules", To: []any{"ads.com"}},
+		{Path: "blocking_list[1]", To: "https://lists.lan/ads"},
+		{Path: "cache.basettl", From: float64(600), To: float64(300)},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Diff() = %v, want %v", changes, want)
+	}
+	if changes, _ := Diff(from, from); len(changes) != 0 {
+		t.Errorf("expecting no change, got %v", changes)
+	}
+}
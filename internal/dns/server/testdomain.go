@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/policy"
+	"github.com/bluguard/dnshield/internal/dns/server/admin"
+	"github.com/bluguard/dnshield/internal/dns/server/configuration"
+)
+
+const policyRule = "policy"
+
+// TestDomain implements admin.API
+func (s *Server) TestDomain(name string) admin.Verdict {
+	s.lock.RLock()
+	b, p := s.blocker, s.policy
+	s.lock.RUnlock()
+	return testDomain(name, b, p)
+}
+
+// TestDomain loads the blocking lists and the policy of the configuration
+// and tells whether the name would be blocked, without resolving it
+func TestDomain(conf configuration.ServerConf, name string) admin.Verdict {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, initBlocker := buildBlocker(conf, nil)
+	_ = initBlocker(ctx)
+	return testDomain(name, b, buildPolicy(ctx, conf))
+}
+
+func testDomain(name string, b *blocker.Blocker, p policy.Policy) admin.Verdict {
+	res := admin.Verdict{Name: name}
+	for _, t := range []dto.Type{dto.A, dto.AAAA} {
+		rule, blocked := match(name, t, b, p)
+		if !blocked {
+			continue
+		}
+		res.Blocked = true
+		res.Rule = rule
+		res.Answers = append(res.Answers, b.Response().Describe(name, t))
+	}
+	return res
+}
+
+// match returns the rule blocking the question
+func match(name string, t dto.Type, b *blocker.Blocker, p policy.Policy) (string, bool) {
+	if p != nil {
+		if action, _ := p.Evaluate(dto.Question{Name: name, Type: t, Class: dto.IN}); action == policy.Block {
+			return policyRule, true
+		}
+	}
+	return b.Match(name)
+}
@@ -0,0 +1,158 @@
+// Package sortlist orders the addresses of the answers, by preference of the destinations (rfc6724 section 6),
+// randomly or in turn to spread the clients over the addresses, and with the preferred subnets first,
+// when the upstreams return a poor order for the multi-homed services
+package sortlist
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+// Mode is the ordering of the addresses
+type Mode string
+
+const (
+	// Keep keeps the order of the upstream
+	Keep Mode = "keep"
+	// Preference sorts the addresses by the precedence of the default policy table of rfc6724
+	Preference Mode = "rfc6724"
+	// Shuffle orders the addresses randomly to balance the load
+	Shuffle Mode = "shuffle"
+	// Rotate starts every answer of a name with the address following the first one of its previous answer
+	Rotate Mode = "rotate"
+)
+
+// rotations is the number of counters of the rotate mode, the names sharing a counter rotate a bit faster
+const rotations = 256
+
+// ParseMode returns the mode of its name, keep when empty
+func ParseMode(name string) (Mode, error) {
+	switch Mode(name) {
+	case "", Keep:
+		return Keep, nil
+	case Preference, Shuffle, Rotate:
+		return Mode(name), nil
+	}
+	return "", errors.New("unknown answer order " + name + ", expecting keep, rfc6724, shuffle or rotate")
+}
+
+// policy is a row of the default policy table of rfc6724 section 2.1
+type policy struct {
+	prefix     *net.IPNet
+	precedence int
+}
+
+var policies = []policy{
+	{mustParseCIDR("::1/128"), 50},
+	{mustParseCIDR("::/0"), 40},
+	{mustParseCIDR("::ffff:0:0/96"), 35},
+	{mustParseCIDR("2002::/16"), 30},
+	{mustParseCIDR("2001::/32"), 5},
+	{mustParseCIDR("fc00::/7"), 3},
+	{mustParseCIDR("::/96"), 1},
+	{mustParseCIDR("fec0::/10"), 1},
+	{mustParseCIDR("3ffe::/16"), 1},
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, res, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// precedence returns the precedence of the longest prefix of the policy table matching the address,
+// the ipv4 addresses are matched as ipv4-mapped ipv6 addresses
+func precedence(ip net.IP) int {
+	ip = ip.To16()
+	res, longest := 0, -1
+	for _, p := range policies {
+		if size, _ := p.prefix.Mask.Size(); size > longest && p.prefix.Contains(ip) {
+			res, longest = p.precedence, size
+		}
+	}
+	return res
+}
+
+// Sorter orders the addresses of the answers, it is safe for concurrent use. The methods of a nil Sorter do nothing
+type Sorter struct {
+	mode      Mode
+	preferred []*net.IPNet
+	shuffle   func(n int, swap func(i, j int))
+	// turns counts the answers of the names in the rotate mode, a name uses the counter of its hash
+	turns *[rotations]atomic.Uint32
+}
+
+// NewSorter instantiates a sorter ordering the addresses according to the mode, then the ones of the preferred
+// subnets first, in the order of the subnets
+func NewSorter(mode Mode, preferred []*net.IPNet) *Sorter {
+	return &Sorter{mode: mode, preferred: preferred, shuffle: rand.Shuffle, turns: &[rotations]atomic.Uint32{}}
+}
+
+// Sort reorders in place the A and AAAA records of the answer, the other records keep their position
+func (s *Sorter) Sort(records []dto.Record) {
+	if s == nil {
+		return
+	}
+	positions := make([]int, 0, len(records))
+	for i, record := range records {
+		if record.Type == dto.A || record.Type == dto.AAAA {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) < 2 {
+		return
+	}
+	addresses := make([]dto.Record, len(positions))
+	for i, position := range positions {
+		addresses[i] = records[position]
+	}
+
+	switch s.mode {
+	case Shuffle:
+		s.shuffle(len(addresses), func(i, j int) { addresses[i], addresses[j] = addresses[j], addresses[i] })
+	case Rotate:
+		s.rotate(addresses)
+	case Preference:
+		sort.SliceStable(addresses, func(i, j int) bool {
+			return precedence(addresses[i].Data) > precedence(addresses[j].Data)
+		})
+	}
+	if len(s.preferred) > 0 {
+		sort.SliceStable(addresses, func(i, j int) bool {
+			return s.rank(addresses[i].Data) < s.rank(addresses[j].Data)
+		})
+	}
+
+	for i, position := range positions {
+		records[position] = addresses[i]
+	}
+}
+
+// rotate shifts the addresses by the number of previous answers of the name of the first one
+func (s *Sorter) rotate(addresses []dto.Record) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.ToLower(addresses[0].Name)))
+	turn := s.turns[h.Sum32()%rotations].Add(1) - 1
+	shift := int(turn % uint32(len(addresses)))
+	rotated := append(append(make([]dto.Record, 0, len(addresses)), addresses[shift:]...), addresses[:shift]...)
+	copy(addresses, rotated)
+}
+
+// rank returns the index of the first preferred subnet containing the address, the number of subnets when none does
+func (s *Sorter) rank(ip net.IP) int {
+	for i, subnet := range s.preferred {
+		if subnet.Contains(ip) {
+			return i
+		}
+	}
+	return len(s.preferred)
+}
@@ -0,0 +1,106 @@
+package sortlist
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func address(ip string) dto.Record {
+	res := dto.Record{Name: "service.com", Type: dto.AAAA, Class: dto.IN, TTL: 60, Data: net.ParseIP(ip)}
+	if v4 := res.Data.To4(); v4 != nil {
+		res.Type, res.Data = dto.A, v4
+	}
+	return res
+}
+
+func values(records []dto.Record) []string {
+	res := make([]string, 0, len(records))
+	for _, record := range records {
+		res = append(res, record.Value())
+	}
+	return res
+}
+
+func TestSorter_Sort(t *testing.T) {
+	cname, err := dto.ParseRData(dto.CNAME, "service.cdn.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := []dto.Record{
+		{Name: "service.com", Type: dto.CNAME, Class: dto.IN, TTL: 60, Raw: cname},
+		address("fd00::1"),
+		address("203.0.113.1"),
+		address("2001:db8:1::1"),
+		address("192.168.1.10"),
+	}
+	tests := []struct {
+		name      string
+		mode      Mode
+		preferred []string
+		want      []string
+	}{
+		{name: "keep", mode: Keep, want: []string{"service.cdn.com", "fd00::1", "203.0.113.1", "2001:db8:1::1", "192.168.1.10"}},
+		{name: "rfc6724", mode: Preference, want: []string{"service.cdn.com", "2001:db8:1::1", "203.0.113.1", "192.168.1.10", "fd00::1"}},
+		{name: "reversed by shuffle", mode: Shuffle, want: []string{"service.cdn.com", "192.168.1.10", "2001:db8:1::1", "203.0.113.1", "fd00::1"}},
+		{
+			name:      "preferred subnets",
+			mode:      Preference,
+			preferred: []string{"192.168.1.0/24", "fd00::/8"},
+			want:      []string{"service.cdn.com", "192.168.1.10", "fd00::1", "2001:db8:1::1", "203.0.113.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var preferred []*net.IPNet
+			for _, cidr := range tt.preferred {
+				preferred = append(preferred, mustParseCIDR(cidr))
+			}
+			s := NewSorter(tt.mode, preferred)
+			s.shuffle = func(n int, swap func(i, j int)) {
+				for i := 0; i < n/2; i++ {
+					swap(i, n-1-i)
+				}
+			}
+			records := append([]dto.Record{}, answer...)
+			s.Sort(records)
+			if got := values(records); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sorter.Sort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	(*Sorter)(nil).Sort(answer)
+}
+
+func TestSorter_SortRotate(t *testing.T) {
+	s := NewSorter(Rotate, nil)
+	other := address("10.0.0.9")
+	other.Name = "other.com"
+	for _, want := range [][]string{
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+		{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+		{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+	} {
+		records := []dto.Record{address("10.0.0.1"), address("10.0.0.2"), address("10.0.0.3")}
+		s.Sort(records)
+		if got := values(records); !reflect.DeepEqual(got, want) {
+			t.Errorf("Sorter.Sort() = %v, want %v", got, want)
+		}
+		// the answers of another name do not take the turn of service.com
+		s.Sort([]dto.Record{other, other})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, name := range []string{"", "keep", "rfc6724", "shuffle", "rotate"} {
+		if _, err := ParseMode(name); err != nil {
+			t.Errorf("ParseMode(%q) error = %v", name, err)
+		}
+	}
+	if _, err := ParseMode("random"); err == nil {
+		t.Errorf("expecting an error for an unknown mode")
+	}
+}
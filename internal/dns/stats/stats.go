@@ -0,0 +1,212 @@
+package stats
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the counters of a server, it is safe for concurrent use.
+// The counting methods of a nil Stats do nothing
+type Stats struct {
+	start     time.Time
+	queries   atomic.Uint64
+	failures  atomic.Uint64
+	audits    atomic.Uint64
+	divergent atomic.Uint64
+	resolvers sync.Map // resolver name -> *atomic.Uint64
+	// domains, blocked, clients and clientsBlocked count the most frequent names and clients
+	domains, blocked        *topCounter
+	clients, clientsBlocked *topCounter
+	// hour and day are the activity of the last hour and of the last day
+	hour, day *window
+	// caching is the name of the resolver of the cache, its answers are counted as cache hits
+	caching string
+	// minCount is the number of questions a name needs to appear in the tops
+	minCount atomic.Uint64
+}
+
+// Snapshot is a copy of the counters at a given time
+type Snapshot struct {
+	Uptime    time.Duration     `json:"uptime"`
+	Queries   uint64            `json:"queries"`
+	Failures  uint64            `json:"failures"`
+	Resolvers map[string]uint64 `json:"resolvers"`
+	// Audits is the number of answers compared with a reference upstream, Divergent how many of them differed
+	Audits    uint64 `json:"audits"`
+	Divergent uint64 `json:"divergent"`
+	// BlockedDomains is the number of domains of the blocking lists, BlockerMemory the bytes allocated for them
+	BlockedDomains int `json:"blocked_domains"`
+	BlockerMemory  int `json:"blocker_memory"`
+	// CacheEntries is the number of entries of the cache, CacheMemory the bytes they take out of the CacheCapacity,
+	// CacheEvictions the number of entries removed from the full cache
+	CacheEntries   int    `json:"cache_entries"`
+	CacheMemory    int64  `json:"cache_memory"`
+	CacheCapacity  int64  `json:"cache_capacity"`
+	CacheEvictions uint64 `json:"cache_evictions"`
+}
+
+// DivergenceRate returns the ratio of the audited answers that differed from the reference upstream
+func (s Snapshot) DivergenceRate() float64 {
+	if s.Audits == 0 {
+		return 0
+	}
+	return float64(s.Divergent) / float64(s.Audits)
+}
+
+// NewStats instantiate new empty counters
+func NewStats() *Stats {
+	return &Stats{
+		start:          time.Now(),
+		domains:        newTopCounter(topDomains),
+		blocked:        newTopCounter(topDomains),
+		clients:        newTopCounter(topClients),
+		clientsBlocked: newTopCounter(topClients),
+		hour:           newWindow(Hour, 5*time.Minute, 12),
+		day:            newWindow(Day, time.Hour, 24),
+	}
+}
+
+// SetCaching set the name of the resolver of the cache, before the stats are used
+func (s *Stats) SetCaching(resolver string) {
+	s.caching = resolver
+}
+
+// SetMinCount leaves the names asked fewer than n times out of the tops, so the rare names do not tell who asked them
+func (s *Stats) SetMinCount(n uint64) {
+	s.minCount.Store(n)
+}
+
+// Query counts a question received by the server
+func (s *Stats) Query() {
+	if s == nil {
+		return
+	}
+	s.queries.Add(1)
+}
+
+// Answer counts a question answered by the resolver with the given name
+func (s *Stats) Answer(resolver string) {
+	if s == nil {
+		return
+	}
+	counter, ok := s.resolvers.Load(resolver)
+	if !ok {
+		counter, _ = s.resolvers.LoadOrStore(resolver, &atomic.Uint64{})
+	}
+	counter.(*atomic.Uint64).Add(1)
+	if resolver == s.caching && s.hour != nil {
+		now := time.Now()
+		s.hour.cache(now)
+		s.day.cache(now)
+	}
+}
+
+// Failure counts a question no resolver was able to answer
+func (s *Stats) Failure() {
+	if s == nil {
+		return
+	}
+	s.failures.Add(1)
+}
+
+// Audit counts an answer compared with a reference upstream
+func (s *Stats) Audit(divergent bool) {
+	if s == nil {
+		return
+	}
+	s.audits.Add(1)
+	if divergent {
+		s.divergent.Add(1)
+	}
+}
+
+// Snapshot returns a copy of the current counters
+func (s *Stats) Snapshot() Snapshot {
+	res := Snapshot{
+		Uptime:    time.Since(s.start),
+		Queries:   s.queries.Load(),
+		Failures:  s.failures.Load(),
+		Resolvers: make(map[string]uint64),
+		Audits:    s.audits.Load(),
+		Divergent: s.divergent.Load(),
+	}
+	s.resolvers.Range(func(key, value any) bool {
+		res.Resolvers[key.(string)] = value.(*atomic.Uint64).Load()
+		return true
+	})
+	return res
+}
+
+// Observe counts the name asked by the client for the top of the names and the clients, client is empty when unknown
+func (s *Stats) Observe(client, name string, blocked bool) {
+	if s == nil || s.domains == nil {
+		return
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	s.domains.add(name)
+	if client != "" {
+		s.clients.add(client)
+	}
+	if blocked {
+		s.blocked.add(name)
+		if client != "" {
+			s.clientsBlocked.add(client)
+		}
+	}
+	now := time.Now()
+	s.hour.observe(now, client, name, blocked)
+	s.day.observe(now, client, name, blocked)
+}
+
+// ForgetClient removes the client from the tops of the clients, the ones of the windows included. The queries of
+// the client are still counted in the activity and the tops of the names, they do not tell who asked
+func (s *Stats) ForgetClient(client string) {
+	if s == nil || s.clients == nil {
+		return
+	}
+	s.clients.remove(client)
+	s.clientsBlocked.remove(client)
+	s.hour.forget(client)
+	s.day.forget(client)
+}
+
+// Window returns the activity of the last hour or day with its n most asked names, the n most blocked ones
+// and the n most active clients, their counts are estimated
+func (s *Stats) Window(period Period, n int) (Activity, Top) {
+	if s == nil || s.hour == nil {
+		return Activity{Period: period}, Top{Domains: []Count{}, Blocked: []Count{}, Clients: []ClientActivity{}}
+	}
+	w := s.hour
+	if period == Day {
+		w = s.day
+	}
+	activity, top := w.top(time.Now(), n)
+	top.Domains, top.Blocked = s.popular(top.Domains), s.popular(top.Blocked)
+	return activity, top
+}
+
+// Top returns the n most asked names, the n most blocked ones and the n most active clients
+func (s *Stats) Top(n int) Top {
+	res := Top{Domains: []Count{}, Blocked: []Count{}, Clients: []ClientActivity{}}
+	if s == nil || s.domains == nil {
+		return res
+	}
+	res.Domains, res.Blocked = s.popular(s.domains.top(n)), s.popular(s.blocked.top(n))
+	for _, client := range s.clients.top(n) {
+		res.Clients = append(res.Clients, ClientActivity{Client: client.Key, Queries: client.Count, Blocked: s.clientsBlocked.get(client.Key)})
+	}
+	return res
+}
+
+// popular returns the counts of the names asked at least the minimum count of times, the counts being sorted
+func (s *Stats) popular(counts []Count) []Count {
+	minCount := s.minCount.Load()
+	for i, count := range counts {
+		if count.Count < minCount {
+			return counts[:i]
+		}
+	}
+	return counts
+}
@@ -0,0 +1,136 @@
+package stats
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// capacities of the top counters, the counts of the keys beyond them are estimated
+const (
+	topDomains = 1000
+	topClients = 256
+)
+
+// Count is the number of questions of a name or a client
+type Count struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// ClientActivity is the number of questions of a client and how many of them were blocked
+type ClientActivity struct {
+	Client  string `json:"client"`
+	Queries uint64 `json:"queries"`
+	Blocked uint64 `json:"blocked"`
+}
+
+// Top is the most asked names, the most blocked ones and the most active clients since the start
+type Top struct {
+	Domains []Count          `json:"domains"`
+	Blocked []Count          `json:"blocked"`
+	Clients []ClientActivity `json:"clients"`
+}
+
+// topCounter counts the most frequent keys in a bounded memory with the space saving algorithm:
+// once full, a new key replaces the least frequent one and inherits its count, so the counts of the
+// frequent keys are exact or slightly overestimated. It is safe for concurrent use
+type topCounter struct {
+	lock     sync.Mutex
+	capacity int
+	entries  map[string]*topEntry
+	heap     topHeap // least frequent first
+}
+
+type topEntry struct {
+	key   string
+	count uint64
+	index int
+}
+
+func newTopCounter(capacity int) *topCounter {
+	return &topCounter{capacity: capacity, entries: make(map[string]*topEntry, capacity)}
+}
+
+// add counts one occurrence of the key
+func (t *topCounter) add(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if entry, ok := t.entries[key]; ok {
+		entry.count++
+		heap.Fix(&t.heap, entry.index)
+		return
+	}
+	if len(t.heap) < t.capacity {
+		entry := &topEntry{key: key, count: 1}
+		t.entries[key] = entry
+		heap.Push(&t.heap, entry)
+		return
+	}
+	evicted := t.heap[0]
+	delete(t.entries, evicted.key)
+	evicted.key = key
+	evicted.count++
+	t.entries[key] = evicted
+	heap.Fix(&t.heap, 0)
+}
+
+// remove forgets the key, its count is lost
+func (t *topCounter) remove(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if entry, ok := t.entries[key]; ok {
+		heap.Remove(&t.heap, entry.index)
+		delete(t.entries, key)
+	}
+}
+
+// get returns the count of the key, 0 when it is not counted
+func (t *topCounter) get(key string) uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if entry, ok := t.entries[key]; ok {
+		return entry.count
+	}
+	return 0
+}
+
+// top returns the n most frequent keys, the most frequent first
+func (t *topCounter) top(n int) []Count {
+	t.lock.Lock()
+	res := make([]Count, 0, len(t.heap))
+	for _, entry := range t.heap {
+		res = append(res, Count{Key: entry.key, Count: entry.count})
+	}
+	t.lock.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res[:min(n, len(res))]
+}
+
+// topHeap implements heap.Interface on the counts
+type topHeap []*topEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *topHeap) Push(x any) {
+	entry := x.(*topEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *topHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
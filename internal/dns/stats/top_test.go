@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTopCounter(t *testing.T) {
+	c := newTopCounter(3)
+	for i := 0; i < 10; i++ {
+		c.add("a.com")
+	}
+	for i := 0; i < 5; i++ {
+		c.add("b.com")
+	}
+	c.add("c.com")
+	// the rare names replace each other in the last slot
+	for i := 0; i < 4; i++ {
+		c.add("rare" + strconv.Itoa(i) + ".com")
+	}
+
+	got := c.top(2)
+	want := []Count{{Key: "a.com", Count: 10}, {Key: "b.com", Count: 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("top() = %v, want %v", got, want)
+	}
+	if last := c.top(3)[2]; last.Key != "rare3.com" || last.Count != 5 {
+		t.Errorf("expecting the last name to inherit the count of the evicted one, got %v", last)
+	}
+	if c.get("c.com") != 0 {
+		t.Errorf("expecting c.com to be evicted")
+	}
+}
+
+func TestStats_Top(t *testing.T) {
+	s := NewStats()
+	client := net.ParseIP("192.168.1.10").String()
+	s.Observe(client, "Ads.com.", true)
+	s.Observe(client, "ads.com", true)
+	s.Observe(client, "a.com", false)
+	s.Observe("", "a.com", false)
+
+	got := s.Top(10)
+	want := Top{
+		Domains: []Count{{Key: "a.com", Count: 2}, {Key: "ads.com", Count: 2}},
+		Blocked: []Count{{Key: "ads.com", Count: 2}},
+		Clients: []ClientActivity{{Client: client, Queries: 3, Blocked: 2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Top() = %+v, want %+v", got, want)
+	}
+	s.Observe(client, "rare.com", false)
+	s.SetMinCount(2)
+	if top := s.Top(10); !reflect.DeepEqual(top.Domains, want.Domains) {
+		t.Errorf("expecting the names asked once to be left out, got %v", top.Domains)
+	}
+	if _, top := s.Window(Hour, 10); !reflect.DeepEqual(top.Domains, want.Domains) {
+		t.Errorf("expecting the names asked once to be left out of the window, got %v", top.Domains)
+	}
+	s.ForgetClient(client)
+	if top := s.Top(10); len(top.Clients) != 0 {
+		t.Errorf("expecting the forgotten client to be removed, got %v", top.Clients)
+	}
+	if _, top := s.Window(Day, 10); len(top.Clients) != 0 {
+		t.Errorf("expecting the forgotten client to be removed from the window, got %v", top.Clients)
+	}
+	var nilStats *Stats
+	nilStats.Observe(client, "a.com", false)
+	nilStats.ForgetClient(client)
+	if top := nilStats.Top(10); len(top.Domains) != 0 {
+		t.Errorf("expecting an empty top, got %v", top)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := newWindow(Hour, 5*time.Minute, 12)
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	client := "192.168.1.10"
+	for i := 0; i < 20; i++ {
+		w.observe(start, client, "old.com", false)
+	}
+	later := start.Add(30 * time.Minute)
+	for i := 0; i < 5; i++ {
+		w.observe(later, client, "a.com", false)
+		w.observe(later, "192.168.1.11", "ads.com", true)
+	}
+	w.cache(later)
+	// the names of the other buckets do not evict the frequent ones
+	for i := 0; i < 3*bucketCandidates; i++ {
+		w.observe(later.Add(10*time.Minute), client, "rare"+strconv.Itoa(i)+".com", false)
+	}
+
+	activity, top := w.top(later.Add(10*time.Minute), 2)
+	if activity.Queries != 20+10+3*bucketCandidates || activity.Blocked != 5 || activity.Cached != 1 {
+		t.Errorf("unexpected activity %+v", activity)
+	}
+	if len(top.Domains) != 2 || top.Domains[0] != (Count{Key: "old.com", Count: 20}) || top.Domains[1].Count < 5 {
+		t.Errorf("unexpected top domains %v", top.Domains)
+	}
+	if len(top.Blocked) != 1 || top.Blocked[0].Key != "ads.com" || top.Clients[0].Client != client {
+		t.Errorf("unexpected top %+v", top)
+	}
+
+	// the first bucket is over the hour
+	activity, top = w.top(start.Add(time.Hour), 10)
+	if activity.Queries != 10+3*bucketCandidates || top.Domains[0].Key == "old.com" {
+		t.Errorf("expecting the first bucket to be out of the window, got %+v %v", activity, top.Domains)
+	}
+	if _, err := ParsePeriod("week"); err == nil {
+		t.Errorf("expecting an error for an unknown period")
+	}
+}
@@ -0,0 +1,241 @@
+package stats
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Period is the duration of a rolling window of the activity
+type Period string
+
+const (
+	Hour Period = "hour"
+	Day  Period = "day"
+)
+
+// ParsePeriod parses a period, hour or day
+func ParsePeriod(s string) (Period, error) {
+	switch Period(s) {
+	case Hour, Day:
+		return Period(s), nil
+	}
+	return "", errors.New("unknown period " + s + ", expecting hour or day")
+}
+
+// sizes of the windows: the count of a key is estimated by a count-min sketch by bucket, the keys reported are
+// the most frequent ones of every bucket
+const (
+	sketchDepth      = 4
+	sketchWidth      = 512
+	bucketCandidates = 64
+)
+
+// Activity is the number of questions of the last hour or day, how many of them were blocked or answered by the cache
+type Activity struct {
+	Period        Period  `json:"period"`
+	Queries       uint64  `json:"queries"`
+	Blocked       uint64  `json:"blocked"`
+	Cached        uint64  `json:"cached"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+}
+
+// sketch is a count-min sketch, it overestimates the counts of the keys in a fixed memory
+type sketch [sketchDepth][sketchWidth]uint32
+
+// cells returns the cell of the key on every row, with the double hashing of a single fnv hash
+func cells(key string) [sketchDepth]uint32 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	var res [sketchDepth]uint32
+	for i := range res {
+		res[i] = (h1 + uint32(i)*h2) % sketchWidth
+	}
+	return res
+}
+
+func (s *sketch) add(key string) {
+	for row, cell := range cells(key) {
+		s[row][cell]++
+	}
+}
+
+func (s *sketch) estimate(key string) uint64 {
+	res := uint32(0)
+	for row, cell := range cells(key) {
+		if count := s[row][cell]; row == 0 || count < res {
+			res = count
+		}
+	}
+	return uint64(res)
+}
+
+// keyCounts counts the keys of a bucket: their estimated count and the most frequent ones
+type keyCounts struct {
+	sketch     sketch
+	candidates *topCounter
+}
+
+func (c *keyCounts) add(key string) {
+	c.sketch.add(key)
+	c.candidates.add(key)
+}
+
+func (c *keyCounts) reset() {
+	c.sketch = sketch{}
+	c.candidates = newTopCounter(bucketCandidates)
+}
+
+// bucket is the activity of a slice of a window
+type bucket struct {
+	// epoch is the index of the slice since the unix epoch, the bucket is reused once the slice is over the window
+	epoch                    int64
+	queries, blocked, cached uint64
+	domains, blockedDomains  *keyCounts
+	clients, clientsBlocked  *keyCounts
+}
+
+func (b *bucket) reset(epoch int64) {
+	b.epoch, b.queries, b.blocked, b.cached = epoch, 0, 0, 0
+	if b.domains == nil {
+		b.domains, b.blockedDomains, b.clients, b.clientsBlocked = &keyCounts{}, &keyCounts{}, &keyCounts{}, &keyCounts{}
+	}
+	for _, c := range []*keyCounts{b.domains, b.blockedDomains, b.clients, b.clientsBlocked} {
+		c.reset()
+	}
+}
+
+// window is the activity of the last buckets of span, in a bounded memory. It is safe for concurrent use
+type window struct {
+	lock    sync.Mutex
+	period  Period
+	span    time.Duration
+	buckets []bucket
+}
+
+func newWindow(period Period, span time.Duration, n int) *window {
+	return &window{period: period, span: span, buckets: make([]bucket, n)}
+}
+
+// current returns the bucket of now, reset when it held an older slice
+func (w *window) current(now time.Time) *bucket {
+	epoch := now.UnixNano() / int64(w.span)
+	b := &w.buckets[epoch%int64(len(w.buckets))]
+	if b.epoch != epoch || b.domains == nil {
+		b.reset(epoch)
+	}
+	return b
+}
+
+// live returns the buckets of the window ending now
+func (w *window) live(now time.Time) []*bucket {
+	epoch := now.UnixNano() / int64(w.span)
+	res := make([]*bucket, 0, len(w.buckets))
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		if b.domains != nil && b.epoch <= epoch && b.epoch > epoch-int64(len(w.buckets)) {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+func (w *window) observe(now time.Time, client, name string, blocked bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	b := w.current(now)
+	b.queries++
+	b.domains.add(name)
+	if client != "" {
+		b.clients.add(client)
+	}
+	if blocked {
+		b.blocked++
+		b.blockedDomains.add(name)
+		if client != "" {
+			b.clientsBlocked.add(client)
+		}
+	}
+}
+
+// forget removes the client from the candidates of the tops of the buckets, its estimates are no longer listed
+func (w *window) forget(client string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for i := range w.buckets {
+		if b := &w.buckets[i]; b.clients != nil {
+			b.clients.candidates.remove(client)
+			b.clientsBlocked.candidates.remove(client)
+		}
+	}
+}
+
+func (w *window) cache(now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.current(now).cached++
+}
+
+// top returns the activity of the window ending now with its n most asked names, the n most blocked ones
+// and the n most active clients
+func (w *window) top(now time.Time, n int) (Activity, Top) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	buckets := w.live(now)
+	activity := Activity{Period: w.period}
+	for _, b := range buckets {
+		activity.Queries += b.queries
+		activity.Blocked += b.blocked
+		activity.Cached += b.cached
+	}
+	if activity.Queries > 0 {
+		activity.CacheHitRatio = float64(activity.Cached) / float64(activity.Queries)
+	}
+	res := Top{
+		Domains: topOf(buckets, func(b *bucket) *keyCounts { return b.domains }, n),
+		Blocked: topOf(buckets, func(b *bucket) *keyCounts { return b.blockedDomains }, n),
+		Clients: []ClientActivity{},
+	}
+	for _, client := range topOf(buckets, func(b *bucket) *keyCounts { return b.clients }, n) {
+		res.Clients = append(res.Clients, ClientActivity{
+			Client:  client.Key,
+			Queries: client.Count,
+			Blocked: estimate(buckets, func(b *bucket) *keyCounts { return b.clientsBlocked }, client.Key),
+		})
+	}
+	return activity, res
+}
+
+// topOf returns the n keys of the buckets with the highest estimated count, among the most frequent ones of every bucket
+func topOf(buckets []*bucket, counts func(*bucket) *keyCounts, n int) []Count {
+	keys := make(map[string]bool)
+	for _, b := range buckets {
+		for _, c := range counts(b).candidates.top(bucketCandidates) {
+			keys[c.Key] = true
+		}
+	}
+	res := make([]Count, 0, len(keys))
+	for key := range keys {
+		res = append(res, Count{Key: key, Count: estimate(buckets, counts, key)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res[:min(n, len(res))]
+}
+
+// estimate returns the count of the key in the buckets
+func estimate(buckets []*bucket, counts func(*bucket) *keyCounts, key string) uint64 {
+	res := uint64(0)
+	for _, b := range buckets {
+		res += counts(b).sketch.estimate(key)
+	}
+	return res
+}
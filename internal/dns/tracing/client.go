@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/request"
+)
+
+var _ client.SetClient = &UpstreamClient{}
+
+// Wrap returns the client tracing the questions asked to the upstream c as client spans, the questions of the queries
+// which are not traced are asked as they are
+func Wrap(upstream string, c client.Client) client.Client {
+	return &UpstreamClient{upstream: upstream, delegate: c}
+}
+
+// UpstreamClient is an upstream client whose questions are client spans of the traced queries
+type UpstreamClient struct {
+	upstream string
+	delegate client.Client
+}
+
+// ResolveV4 implements client.Client
+func (c *UpstreamClient) ResolveV4(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.A)
+}
+
+// ResolveV6 implements client.Client
+func (c *UpstreamClient) ResolveV6(name string) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, dto.AAAA)
+}
+
+// Resolve implements client.TypedClient
+func (c *UpstreamClient) Resolve(name string, t dto.Type) (dto.Record, error) {
+	return c.ResolveRequest(context.Background(), request.Request{}, name, t)
+}
+
+// ResolveRequest implements client.RequestClient
+func (c *UpstreamClient) ResolveRequest(ctx context.Context, req request.Request, name string, t dto.Type) (dto.Record, error) {
+	return client.First(c.ResolveSet(ctx, req, name, t))
+}
+
+// ResolveSet implements client.SetClient
+func (c *UpstreamClient) ResolveSet(ctx context.Context, req request.Request, name string, t dto.Type) ([]dto.Record, error) {
+	ctx, span := StartKind(ctx, "upstream", Client)
+	span.SetString("server.address", c.upstream)
+	span.SetQuestionName(name)
+	span.SetString("dns.question.type", t.String())
+	records, err := client.ResolveSet(ctx, c.delegate, req, name, t)
+	span.SetInt("dns.answers", int64(len(records)))
+	span.End(err)
+	return records, err
+}
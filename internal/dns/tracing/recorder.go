@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Step is a span kept by a Recorder, to show how a query was resolved
+type Step struct {
+	Name string `json:"name"`
+	// Depth is the number of parents of the span, the steps of the resolvers of the chain have a depth of 1
+	Depth int `json:"depth"`
+	// Offset is the time from the start of the query to the start of the step
+	Offset     time.Duration     `json:"offset"`
+	Duration   time.Duration     `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Error is the reason the step did not answer, a failure or the question left to the next resolvers
+	Error string `json:"error,omitempty"`
+}
+
+// Recorder keeps the spans of a query in memory, whatever the sampling rate of the tracer, it is safe for concurrent use
+type Recorder struct {
+	lock  sync.Mutex
+	start time.Time
+	steps []Step
+}
+
+// Record starts the root span of a query kept in memory by the returned recorder, the spans of the query are recorded
+// once they end. The spans are not exported
+func Record(ctx context.Context, name string) (context.Context, *Span, *Recorder) {
+	recorder := &Recorder{start: time.Now()}
+	span := &Span{name: name, kind: Server, start: recorder.start, recorder: recorder}
+	return context.WithValue(ctx, spanKey{}, span), span, recorder
+}
+
+// Steps returns the spans recorded so far in the order they started, the parents before their children
+func (r *Recorder) Steps() []Step {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res := append([]Step(nil), r.steps...)
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Offset != res[j].Offset {
+			return res[i].Offset < res[j].Offset
+		}
+		return res[i].Depth < res[j].Depth
+	})
+	return res
+}
+
+// end records the span ended at end
+func (r *Recorder) end(s *Span, end time.Time, err error) {
+	step := Step{Name: s.name, Depth: s.depth, Offset: s.start.Sub(r.start), Duration: end.Sub(s.start), Attributes: s.values}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.steps = append(r.steps, step)
+}
@@ -0,0 +1,211 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Kind is the kind of a span (SpanKind of OTLP)
+type Kind uint64
+
+const (
+	// Internal is the kind of the steps of the resolution
+	Internal Kind = 1
+	// Server is the kind of the root span of a query
+	Server Kind = 2
+	// Client is the kind of the calls to the upstreams
+	Client Kind = 3
+)
+
+// status code of a failed span
+const statusError = 2
+
+type spanKey struct{}
+
+// Span is an operation of a traced query, its attributes are encoded as they are set. A Span is used by one goroutine,
+// a nil Span records nothing
+type Span struct {
+	tracer     *Tracer
+	traceID    [16]byte
+	id         [8]byte
+	parent     [8]byte
+	name       string
+	kind       Kind
+	start      time.Time
+	attributes []byte
+	// recorder keeps the span in memory instead of exporting it, its attributes are then kept as text in values
+	recorder *Recorder
+	depth    int
+	values   map[string]string
+}
+
+// FromContext returns the span of ctx, nil when the query is not traced
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start starts an internal span child of the span of ctx, nil when ctx has no span. The returned context carries the
+// new span to its own children
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, Internal)
+}
+
+// StartKind starts a span of the kind child of the span of ctx, nil when ctx has no span
+func StartKind(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: parent.tracer, traceID: parent.traceID, parent: parent.id, name: name, kind: kind, start: time.Now(),
+		recorder: parent.recorder, depth: parent.depth + 1}
+	binary.BigEndian.PutUint64(span.id[:], rand.Uint64())
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// TraceID returns the hex trace id of the span, empty for a nil span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// SetString sets the attribute key to value
+func (s *Span) SetString(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.recorder != nil {
+		s.record(key, value)
+		return
+	}
+	s.attributes = appendString(s.attributes, 9, key, value)
+}
+
+// SetQuestionName sets the name of the question, left out when the anonymizer of the tracer does not record it yet.
+// The recorded spans keep it, they are not exported
+func (s *Span) SetQuestionName(name string) {
+	if s == nil {
+		return
+	}
+	if s.tracer != nil {
+		if name = s.tracer.anonymizer.Load().Recorded(name); name == "" {
+			return
+		}
+	}
+	s.SetString("dns.question.name", name)
+}
+
+// SetInt sets the attribute key to value
+func (s *Span) SetInt(key string, value int64) {
+	if s == nil {
+		return
+	}
+	if s.recorder != nil {
+		s.record(key, strconv.FormatInt(value, 10))
+		return
+	}
+	v := protowire.AppendTag(nil, 3, protowire.VarintType)
+	s.attributes = appendAttribute(s.attributes, 9, key, protowire.AppendVarint(v, uint64(value)))
+}
+
+// SetBool sets the attribute key to value
+func (s *Span) SetBool(key string, value bool) {
+	if s == nil {
+		return
+	}
+	if s.recorder != nil {
+		s.record(key, strconv.FormatBool(value))
+		return
+	}
+	v := protowire.AppendTag(nil, 2, protowire.VarintType)
+	s.attributes = appendAttribute(s.attributes, 9, key, protowire.AppendVarint(v, protowire.EncodeBool(value)))
+}
+
+// End ends the span and queues it for the export. The span fails with err when it is a failure: the negative answers
+// and the questions left to the following resolvers are not
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	if s.recorder != nil {
+		s.recorder.end(s, time.Now(), err)
+		return
+	}
+	s.tracer.end(s.encode(time.Now(), failure(err)))
+}
+
+// record keeps the attribute key of a recorded span
+func (s *Span) record(key, value string) {
+	if s.values == nil {
+		s.values = make(map[string]string)
+	}
+	s.values[key] = value
+}
+
+// encode returns the Span message of OTLP
+func (s *Span) encode(end time.Time, err error) []byte {
+	m := make([]byte, 0, 64+len(s.name)+len(s.attributes))
+	m = protowire.AppendTag(m, 1, protowire.BytesType)
+	m = protowire.AppendBytes(m, s.traceID[:])
+	m = protowire.AppendTag(m, 2, protowire.BytesType)
+	m = protowire.AppendBytes(m, s.id[:])
+	if s.parent != [8]byte{} {
+		m = protowire.AppendTag(m, 4, protowire.BytesType)
+		m = protowire.AppendBytes(m, s.parent[:])
+	}
+	m = protowire.AppendTag(m, 5, protowire.BytesType)
+	m = protowire.AppendString(m, s.name)
+	m = protowire.AppendTag(m, 6, protowire.VarintType)
+	m = protowire.AppendVarint(m, uint64(s.kind))
+	m = protowire.AppendTag(m, 7, protowire.Fixed64Type)
+	m = protowire.AppendFixed64(m, uint64(s.start.UnixNano()))
+	m = protowire.AppendTag(m, 8, protowire.Fixed64Type)
+	m = protowire.AppendFixed64(m, uint64(end.UnixNano()))
+	m = append(m, s.attributes...)
+	if err != nil {
+		status := protowire.AppendTag(nil, 2, protowire.BytesType)
+		status = protowire.AppendString(status, err.Error())
+		status = protowire.AppendTag(status, 3, protowire.VarintType)
+		status = protowire.AppendVarint(status, statusError)
+		m = protowire.AppendTag(m, 15, protowire.BytesType)
+		m = protowire.AppendBytes(m, status)
+	}
+	return m
+}
+
+// failure returns err when it is a failure of the resolution, nil for an answer
+func failure(err error) error {
+	var nameError *client.NameError
+	var noDataError *client.NoDataError
+	var refusedError *client.RefusedError
+	if err == nil || errors.As(err, &nameError) || errors.As(err, &noDataError) || errors.As(err, &refusedError) || errors.Is(err, client.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// appendString appends the string attribute key as the field number of m
+func appendString(m []byte, number protowire.Number, key, value string) []byte {
+	v := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return appendAttribute(m, number, key, protowire.AppendString(v, value))
+}
+
+// appendAttribute appends the KeyValue of key and of the encoded AnyValue as the field number of m
+func appendAttribute(m []byte, number protowire.Number, key string, value []byte) []byte {
+	kv := protowire.AppendTag(nil, 1, protowire.BytesType)
+	kv = protowire.AppendString(kv, key)
+	kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+	kv = protowire.AppendBytes(kv, value)
+	m = protowire.AppendTag(m, number, protowire.BytesType)
+	return protowire.AppendBytes(m, kv)
+}
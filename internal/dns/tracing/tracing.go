@@ -0,0 +1,191 @@
+// Package tracing traces the queries with OpenTelemetry spans: the parse of the query, each resolver of the chain,
+// the cache lookups and the calls to the upstreams. The spans are exported in protobuf to an OTLP/HTTP collector
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/privacy"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var logger = logging.Component("tracing")
+
+const (
+	// queueLength is the number of spans waiting for the export, the following ones are dropped
+	queueLength = 4096
+	// batchSize is the number of spans exported in one request at most
+	batchSize = 512
+	// exportInterval is the time the ended spans wait at most before their export
+	exportInterval = 5 * time.Second
+	// exportTimeout is the time a collector has to accept a batch
+	exportTimeout = 10 * time.Second
+	// tracesPath is the path of the traces on a collector whose endpoint has no path (OTLP/HTTP specification)
+	tracesPath = "/v1/traces"
+)
+
+// Tracer exports the spans of the sampled queries, it never blocks the queries: the spans are dropped while the
+// collector lags behind. A nil Tracer traces nothing
+type Tracer struct {
+	url  string
+	rate float64
+	// resource is the encoded resource of the spans, the name of the service
+	resource []byte
+	spans    chan []byte
+	client   *http.Client
+	dropped  atomic.Uint64
+	// anonymizer leaves the rare names out of the spans, nil to keep them
+	anonymizer atomic.Pointer[privacy.Anonymizer]
+}
+
+// NewTracer returns the tracer exporting to the collector at endpoint, like http://localhost:4318, the spans of
+// the queries sampled at rate, from 0 to 1
+func NewTracer(endpoint, service string, rate float64) (*Tracer, error) {
+	target, err := url.Parse(endpoint)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, errors.New("invalid OTLP endpoint " + endpoint + ", expecting an http or https url")
+	}
+	if target.Path == "" || target.Path == "/" {
+		target.Path = tracesPath
+	}
+	if service == "" {
+		service = "dnshield"
+	}
+	resource := appendString(nil, 1, "service.name", service)
+	return &Tracer{
+		url:      target.String(),
+		rate:     rate,
+		resource: resource,
+		spans:    make(chan []byte, queueLength),
+		client:   &http.Client{Timeout: exportTimeout},
+	}, nil
+}
+
+// SetAnonymizer leaves the names the anonymizer does not record out of the exported spans, nil keeps them.
+// It can be called while exporting, the tracer outlives the chains
+func (t *Tracer) SetAnonymizer(a *privacy.Anonymizer) {
+	if t != nil {
+		t.anonymizer.Store(a)
+	}
+}
+
+// Dropped returns the number of spans dropped since the start
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.dropped.Load()
+}
+
+// Trace starts the root span of a query, sampled at the rate of the tracer. The trace id is the id of the query in the
+// logs, 16 hex digits, padded with zeros, so its traces are found from its logs. The span is nil when the query is
+// not traced, ctx is then returned as it is
+func (t *Tracer) Trace(ctx context.Context, id string, name string) (context.Context, *Span) {
+	if t == nil || (t.rate < 1 && rand.Float64() >= t.rate) {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: Server, start: time.Now()}
+	if decoded, err := hex.DecodeString(id); err == nil && len(decoded) == 8 {
+		copy(span.traceID[8:], decoded)
+	} else {
+		binary.BigEndian.PutUint64(span.traceID[8:], rand.Uint64())
+	}
+	binary.BigEndian.PutUint64(span.id[:], rand.Uint64())
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start exports the spans until ctx is done, the spans already ended are then exported
+func (t *Tracer) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go t.run(ctx, wg)
+}
+
+func (t *Tracer) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([][]byte, 0, batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			for len(t.spans) > 0 && len(batch) < batchSize {
+				batch = append(batch, <-t.spans)
+			}
+			// the context of the server is done, the last batch has its own deadline
+			final, cancel := context.WithTimeout(context.Background(), exportTimeout)
+			t.export(final, batch)
+			cancel()
+			return
+		case span := <-t.spans:
+			if batch = append(batch, span); len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		t.export(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+// end queues the encoded span, it is dropped when the queue is full
+func (t *Tracer) end(span []byte) {
+	select {
+	case t.spans <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// export sends the spans to the collector, they are dropped when it fails
+func (t *Tracer) export(ctx context.Context, spans [][]byte) {
+	if len(spans) == 0 {
+		return
+	}
+	body := t.request(spans)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		logger.Warn("cannot export the spans", "err", err)
+		t.dropped.Add(uint64(len(spans)))
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	res, err := t.client.Do(req)
+	if err != nil {
+		logger.Warn("cannot export the spans", "collector", t.url, "err", err)
+		t.dropped.Add(uint64(len(spans)))
+		return
+	}
+	_ = res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		logger.Warn("the collector rejected the spans", "collector", t.url, "status", strconv.Itoa(res.StatusCode))
+		t.dropped.Add(uint64(len(spans)))
+	}
+}
+
+// request returns the ExportTraceServiceRequest of the spans, in one ResourceSpans of the service
+func (t *Tracer) request(spans [][]byte) []byte {
+	scope := protowire.AppendTag(nil, 1, protowire.BytesType)
+	scope = protowire.AppendBytes(scope, protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "dnshield"))
+	for _, span := range spans {
+		scope = protowire.AppendTag(scope, 2, protowire.BytesType)
+		scope = protowire.AppendBytes(scope, span)
+	}
+	resourceSpans := protowire.AppendTag(nil, 1, protowire.BytesType)
+	resourceSpans = protowire.AppendBytes(resourceSpans, t.resource)
+	resourceSpans = protowire.AppendTag(resourceSpans, 2, protowire.BytesType)
+	resourceSpans = protowire.AppendBytes(resourceSpans, scope)
+	res := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(res, resourceSpans)
+}
@@ -0,0 +1,270 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/client"
+	"github.com/bluguard/dnshield/internal/dns/dto"
+	"github.com/bluguard/dnshield/internal/dns/privacy"
+	"github.com/bluguard/dnshield/internal/dns/request"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var _ client.Client = failingClient{}
+
+type failingClient struct{}
+
+// ResolveV4 implements client.Client
+func (failingClient) ResolveV4(string) (dto.Record, error) {
+	return dto.Record{}, errors.New("timeout")
+}
+
+// ResolveV6 implements client.Client
+func (failingClient) ResolveV6(string) (dto.Record, error) {
+	return dto.Record{}, errors.New("timeout")
+}
+
+// exported is a span decoded from an export request
+type exported struct {
+	traceID, id, parent string
+	name                string
+	kind                uint64
+	attributes          map[string]string
+	failed              bool
+}
+
+// collector decodes the spans of the export requests
+type collector struct {
+	lock     sync.Mutex
+	spans    []exported
+	services []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if r.URL.Path != tracesPath || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, resourceSpans := range fields(body, 1) {
+		for _, resource := range fields(resourceSpans, 1) {
+			for _, kv := range fields(resource, 1) {
+				c.services = append(c.services, attributes(kv)["service.name"])
+			}
+		}
+		for _, scope := range fields(resourceSpans, 2) {
+			for _, span := range fields(scope, 2) {
+				c.spans = append(c.spans, decodeSpan(span))
+			}
+		}
+	}
+}
+
+func (c *collector) byName(name string) (exported, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, span := range c.spans {
+		if span.name == name {
+			return span, true
+		}
+	}
+	return exported{}, false
+}
+
+// fields returns the values of the bytes fields number of m
+func fields(m []byte, number protowire.Number) [][]byte {
+	var res [][]byte
+	for len(m) > 0 {
+		num, typ, n := protowire.ConsumeTag(m)
+		m = m[n:]
+		n = protowire.ConsumeFieldValue(num, typ, m)
+		if num == number && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(m)
+			res = append(res, v)
+		}
+		m = m[n:]
+	}
+	return res
+}
+
+func decodeSpan(m []byte) exported {
+	res := exported{attributes: make(map[string]string)}
+	for len(m) > 0 {
+		num, typ, n := protowire.ConsumeTag(m)
+		m = m[n:]
+		switch {
+		case typ == protowire.VarintType && num == 6:
+			res.kind, _ = protowire.ConsumeVarint(m)
+		case typ == protowire.BytesType:
+			v, _ := protowire.ConsumeBytes(m)
+			switch num {
+			case 1:
+				res.traceID = hex.EncodeToString(v)
+			case 2:
+				res.id = hex.EncodeToString(v)
+			case 4:
+				res.parent = hex.EncodeToString(v)
+			case 5:
+				res.name = string(v)
+			case 9:
+				for key, value := range attributes(v) {
+					res.attributes[key] = value
+				}
+			case 15:
+				res.failed = true
+			}
+		}
+		m = m[protowire.ConsumeFieldValue(num, typ, m):]
+	}
+	return res
+}
+
+// attributes returns the KeyValue m, its value written as a string
+func attributes(m []byte) map[string]string {
+	key := string(fields(m, 1)[0])
+	value := fields(m, 2)[0]
+	num, typ, n := protowire.ConsumeTag(value)
+	switch {
+	case typ == protowire.BytesType:
+		v, _ := protowire.ConsumeBytes(value[n:])
+		return map[string]string{key: string(v)}
+	case num == 2:
+		v, _ := protowire.ConsumeVarint(value[n:])
+		return map[string]string{key: strconv.FormatBool(protowire.DecodeBool(v))}
+	default:
+		v, _ := protowire.ConsumeVarint(value[n:])
+		return map[string]string{key: strconv.FormatInt(int64(v), 10)}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+	tracer, err := NewTracer(server.URL, "resolver-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymizer := privacy.NewAnonymizer(privacy.Plain, "", 0, 0, 2)
+	anonymizer.Name("popular.com")
+	anonymizer.Name("popular.com")
+	tracer.SetAnonymizer(anonymizer)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	tracer.Start(ctx, wg)
+
+	queryCtx, query := tracer.Trace(context.Background(), "0123456789abcdef", "query")
+	query.SetString("network.transport", "udp")
+	query.SetQuestionName("popular.com")
+	upstream := Wrap("upstream-1", failingClient{})
+	resolverCtx, resolver := Start(queryCtx, "resolver External")
+	_, err = client.ResolveSet(resolverCtx, upstream, request.Request{}, "a.com", dto.A)
+	resolver.End(&client.NameError{Name: "a.com"})
+	query.End(nil)
+	cancel()
+	wg.Wait()
+
+	root, ok := c.byName("query")
+	if !ok || root.traceID != "00000000000000000123456789abcdef" || root.parent != "" || root.kind != uint64(Server) {
+		t.Fatalf("expecting the root span of the query, got %+v", root)
+	}
+	if root.attributes["network.transport"] != "udp" || root.attributes["dns.question.name"] != "popular.com" || len(c.services) != 1 || c.services[0] != "resolver-1" {
+		t.Errorf("expecting the attributes and the service name, got %+v and %v", root.attributes, c.services)
+	}
+	child, ok := c.byName("resolver External")
+	if !ok || child.parent != root.id || child.traceID != root.traceID || child.failed {
+		t.Errorf("expecting the resolver span child of the query without failure, got %+v", child)
+	}
+	call, ok := c.byName("upstream")
+	if !ok || call.parent != child.id || call.kind != uint64(Client) || !call.failed || call.attributes["server.address"] != "upstream-1" {
+		t.Errorf("expecting the failed upstream span child of the resolver, got %+v", call)
+	}
+	if name, ok := call.attributes["dns.question.name"]; ok {
+		t.Errorf("expecting the rare name to be left out of the span, got %s", name)
+	}
+	if err == nil {
+		t.Error("expecting the error of the upstream")
+	}
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Trace(context.Background(), request.NewTraceID(), "query")
+	if span != nil || FromContext(ctx) != nil {
+		t.Fatal("expecting no span from a nil tracer")
+	}
+	// the spans of a query which is not traced are nil, and record nothing
+	_, child := Start(ctx, "parse")
+	child.SetString("a", "b")
+	child.End(nil)
+
+	tracer, err := NewTracer("http://localhost:4318", "", 0.000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traced := 0
+	for i := 0; i < 1000; i++ {
+		if _, span := tracer.Trace(context.Background(), "", "query"); span != nil {
+			traced++
+		}
+	}
+	if traced > 1 {
+		t.Errorf("expecting almost no query traced, got %d", traced)
+	}
+}
+
+func TestNewTracer(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{"http://localhost:4318", "http://localhost:4318/v1/traces", false},
+		{"https://collector.lan/", "https://collector.lan/v1/traces", false},
+		{"https://collector.lan/otlp/traces", "https://collector.lan/otlp/traces", false},
+		{"localhost:4318", "", true},
+		{"grpc://localhost:4317", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			tracer, err := NewTracer(tt.endpoint, "", 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTracer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tracer.url != tt.want {
+				t.Errorf("NewTracer() url = %s, want %s", tracer.url, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	ctx, query, recorder := Record(context.Background(), "query")
+	upstream := Wrap("upstream-1", failingClient{})
+	resolverCtx, resolver := Start(ctx, "resolver External")
+	resolver.SetBool("dns.cache.hit", false)
+	_, _ = client.ResolveSet(resolverCtx, upstream, request.Request{}, "a.com", dto.A)
+	resolver.End(nil)
+	query.End(nil)
+
+	steps := recorder.Steps()
+	if len(steps) != 3 || steps[0].Name != "query" || steps[1].Name != "resolver External" || steps[2].Name != "upstream" {
+		t.Fatalf("expecting the spans in the order they started, got %+v", steps)
+	}
+	if steps[0].Depth != 0 || steps[1].Depth != 1 || steps[2].Depth != 2 || steps[1].Attributes["dns.cache.hit"] != "false" {
+		t.Errorf("expecting the depths and the attributes of the spans, got %+v", steps)
+	}
+	if call := steps[2]; call.Attributes["server.address"] != "upstream-1" || call.Attributes["dns.answers"] != "0" || call.Error != "timeout" {
+		t.Errorf("expecting the failed upstream span, got %+v", call)
+	}
+}
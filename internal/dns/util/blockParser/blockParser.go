@@ -2,42 +2,246 @@ package blockparser
 
 import (
 	"bufio"
-	"log"
-	"net/http"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/bluguard/dnshield/internal/dns/util/logging"
 )
 
+var logger = logging.Component("blockparser")
+
+const (
+	// retryDelay is the delay between two attempts to download a list
+	retryDelay   = 5 * time.Second
+	commentStart = "#"
+)
+
+// Format is the syntax of a blocking list
+type Format string
+
 const (
-	valideLineStart = "0.0.0.0"
-	commentStart    = "#"
-	valueSeparator  = " "
+	// Auto detects the format of every line, so the lists mixing the formats are read entirely
+	Auto Format = ""
+	// Hosts lists the names after an address, like /etc/hosts, the address is ignored
+	Hosts Format = "hosts"
+	// Domains lists one name per line, *.name blocks the subdomains of name
+	Domains Format = "domains"
+	// Adblock lists ||name^ rules blocking the name and its subdomains, the subset of the AdBlock and uBlock syntax
+	// matching the domains. The rules of pages, elements or with other options than important are ignored, like the exceptions
+	Adblock Format = "adblock"
 )
 
+// ParseFormat returns the format of its name, auto when empty
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case Auto, "auto":
+		return Auto, nil
+	case Hosts, Domains, Adblock:
+		return f, nil
+	}
+	return Auto, errors.New("unknown blocking list format " + name + ", expecting auto, hosts, domains or adblock")
+}
+
+// hostsNames are the names of the hosts files which are not blocked, they name the host itself
+var hostsNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// adblockOptions are the options of an adblock rule which still block the whole domain
+var adblockOptions = map[string]bool{"important": true, "all": true, "document": true, "doc": true}
+
+// BlockParser reads a blocking list in its format, Url is an http or https url, or the path of a local file or of
+// a directory of lists
 type BlockParser struct {
-	Url string
+	Url    string
+	Format Format
+	// CacheDir keeps a copy of the downloaded list, the list is not cached when empty
+	CacheDir string
 }
 
 var _ blocker.Initializer = (&BlockParser{}).Feed
 
-func (p *BlockParser) Feed(add func(name string)) {
-	var resp *http.Response
-	var err error
-	for resp, err = http.Get(p.Url); err != nil; resp, err = http.Get(p.Url) {
-		log.Println(err)
+// Feed adds the names blocked by the list, *.name for the subdomains of name
+func (p *BlockParser) Feed(ctx context.Context, add func(name string)) error {
+	return read(ctx, p.Url, p.CacheDir, func(text string) {
+		parseLine(p.Format, text, add)
+	})
+}
+
+// parseLine adds the names blocked by the line of a list in the format
+func parseLine(format Format, text string, add func(name string)) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.HasPrefix(text, commentStart) || strings.HasPrefix(text, "!") || strings.HasPrefix(text, "[") {
+		return // comments of the hosts and the adblock lists, and headers like [Adblock Plus 2.0]
 	}
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		text := scanner.Text()
-		if !strings.HasPrefix(text, valideLineStart) {
-			continue
+	if format == Auto {
+		format = detect(text)
+	}
+	switch format {
+	case Hosts:
+		fields := strings.Fields(strings.Split(text, commentStart)[0])
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			return
+		}
+		for _, name := range fields[1:] {
+			if !hostsNames[strings.ToLower(name)] {
+				add(name)
+			}
+		}
+	case Domains:
+		fields := strings.Fields(strings.Split(text, commentStart)[0])
+		if len(fields) == 1 && isDomain(strings.TrimPrefix(fields[0], "*.")) {
+			add(strings.TrimSuffix(fields[0], "."))
+		}
+	case Adblock:
+		if name, ok := adblockDomain(text); ok {
+			add(name)
+			add("*." + name)
+		}
+	}
+}
+
+// detect returns the format of a line which is not a comment
+func detect(text string) Format {
+	if strings.HasPrefix(text, "||") || strings.HasPrefix(text, "@@") || strings.Contains(text, "^") {
+		return Adblock
+	}
+	if fields := strings.Fields(text); len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+		return Hosts
+	}
+	return Domains
+}
+
+// adblockDomain returns the domain of a ||name^ rule, the rule is not a domain rule otherwise
+func adblockDomain(rule string) (string, bool) {
+	rule, options, _ := strings.Cut(rule, "$")
+	if options != "" {
+		for _, option := range strings.Split(options, ",") {
+			if !adblockOptions[option] {
+				return "", false
+			}
+		}
+	}
+	name, found := strings.CutPrefix(rule, "||")
+	if !found {
+		return "", false
+	}
+	name, found = strings.CutSuffix(strings.TrimSuffix(name, "|"), "^")
+	if !found || !isDomain(name) {
+		return "", false
+	}
+	return strings.TrimSuffix(name, "."), true
+}
+
+// isDomain tells if the text is a domain name and not an address, a pattern or a path
+func isDomain(text string) bool {
+	return strings.Contains(text, ".") && !strings.ContainsAny(text, "/*:^|$@ ") && net.ParseIP(text) == nil
+}
+
+// AllowParser reads a list of names never blocked, one per line, in the hosts format or alone. Like the blocking lists,
+// Url is an url or a local path
+type AllowParser struct {
+	Url string
+	// CacheDir keeps a copy of the downloaded list, the list is not cached when empty
+	CacheDir string
+}
+
+var _ blocker.Initializer = (&AllowParser{}).Feed
+
+// Feed adds the names of the list, including the wildcard patterns
+func (p *AllowParser) Feed(ctx context.Context, add func(name string)) error {
+	return read(ctx, p.Url, p.CacheDir, func(text string) {
+		fields := strings.Fields(strings.Split(text, commentStart)[0])
+		switch len(fields) {
+		case 1:
+			add(fields[0])
+		case 2:
+			add(fields[1])
 		}
-		text = strings.Split(text, commentStart)[0]
-		if !strings.Contains(text, " ") {
+	})
+}
+
+// read calls parse with every line of the list at source, an http or https url, or a local path with or without
+// the file:// scheme. The downloaded lists are kept in cacheDir when not empty
+func read(ctx context.Context, source, cacheDir string, parse func(line string)) error {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return download(ctx, source, cacheDir, parse)
+	}
+	return readLocal(ctx, strings.TrimPrefix(source, "file://"), parse)
+}
+
+// Check returns an error when the list at source cannot be read, a missing local path or an url not answering,
+// without reading the list
+func Check(ctx context.Context, source string) error {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return reachable(ctx, source)
+	}
+	_, err := os.Stat(strings.TrimPrefix(source, "file://"))
+	return err
+}
+
+// readLocal calls parse with every line of the file at path, or of the files of the directory at path in the order
+// of their names, its hidden files and its subdirectories being ignored. A missing file is not waited for, it is read
+// again by the next refresh of the lists
+func readLocal(ctx context.Context, path string, parse func(line string)) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return readFile(path, parse)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
 			continue
 		}
-		text = strings.Split(text, valueSeparator)[1]
-		add(text)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := readFile(filepath.Join(path, entry.Name()), parse); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func readFile(path string, parse func(line string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return scan(file, parse)
+}
+
+// scan calls parse with every line of r
+func scan(r io.Reader, parse func(line string)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parse(scanner.Text())
 	}
+	return scanner.Err()
 }
@@ -0,0 +1,247 @@
+package blockparser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluguard/dnshield/internal/dns/client/blocker"
+	"github.com/klauspost/compress/zstd"
+)
+
+func serve(t *testing.T, body string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestParsers(t *testing.T) {
+	tests := []struct {
+		name string
+		feed func(url string) blocker.Initializer
+		body string
+		want []string
+	}{
+		{
+			name: "block list",
+			feed: func(url string) blocker.Initializer { return (&BlockParser{Url: url}).Feed },
+			body: "# hosts\n0.0.0.0 ads.com\n127.0.0.1 localhost\n0.0.0.0 tracker.com #comment\n",
+			want: []string{"ads.com", "tracker.com"},
+		},
+		{
+			name: "hosts",
+			feed: func(url string) blocker.Initializer { return (&BlockParser{Url: url, Format: Hosts}).Feed },
+			body: "127.0.0.1 localhost\n::1 ip6-localhost ip6-loopback\n0.0.0.0 0.0.0.0\n127.0.0.1 ads.com  tracker.com\nplain.com\n",
+			want: []string{"ads.com", "tracker.com"},
+		},
+		{
+			name: "domains",
+			feed: func(url string) blocker.Initializer { return (&BlockParser{Url: url, Format: Domains}).Feed },
+			body: "# oisd\nads.com\n*.tracker.com # wildcard\n0.0.0.0 hosts.com\n||adblock.com^\nlocalhost\n",
+			want: []string{"ads.com", "*.tracker.com"},
+		},
+		{
+			name: "adblock",
+			feed: func(url string) blocker.Initializer { return (&BlockParser{Url: url, Format: Adblock}).Feed },
+			body: "[Adblock Plus 2.0]\n! Title: oisd\n||ads.com^\n||tracker.com^$important\n@@||allowed.com^\n||images.com^$third-party\n||cdn.com/banner.js\nexample.com##.ad\n",
+			want: []string{"ads.com", "*.ads.com", "tracker.com", "*.tracker.com"},
+		},
+		{
+			name: "auto",
+			feed: func(url string) blocker.Initializer { return (&BlockParser{Url: url}).Feed },
+			body: "0.0.0.0 hosts.com\nplain.com\n||adblock.com^\n",
+			want: []string{"hosts.com", "plain.com", "adblock.com", "*.adblock.com"},
+		},
+		{
+			name: "allow list",
+			feed: func(url string) blocker.Initializer { return (&AllowParser{Url: url}).Feed },
+			body: "# allowed\nexample.com\n*.cdn.example.com # images\n0.0.0.0 s.youtube.com\n\n",
+			want: []string{"example.com", "*.cdn.example.com", "s.youtube.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0, len(tt.want))
+			err := tt.feed(serve(t, tt.body))(context.Background(), func(name string) {
+				got = append(got, name)
+			})
+			if err != nil {
+				t.Fatalf("Feed() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Feed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsers_Local(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"ads.txt":      "0.0.0.0 ads.com\n",
+		"trackers.txt": "tracker.com\n||analytics.com^\n",
+		".swap":        "hidden.com\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		source  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "file", source: filepath.Join(dir, "ads.txt"), want: []string{"ads.com"}},
+		{name: "file url", source: "file://" + filepath.Join(dir, "ads.txt"), want: []string{"ads.com"}},
+		{name: "directory", source: dir, want: []string{"ads.com", "tracker.com", "analytics.com", "*.analytics.com"}},
+		{name: "missing", source: filepath.Join(dir, "missing.txt"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			parser := BlockParser{Url: tt.source}
+			err := parser.Feed(context.Background(), func(name string) {
+				got = append(got, name)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Feed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Feed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsers_Compressed(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, _ = gz.Write([]byte("0.0.0.0 ads.com\n"))
+	_ = gz.Close()
+	encoder, _ := zstd.NewWriter(nil)
+	zstded := encoder.EncodeAll([]byte("0.0.0.0 tracker.com\n"), nil)
+	_ = encoder.Close()
+
+	for encoding, body := range map[string][]byte{"gzip": gzipped.Bytes(), "zstd": zstded} {
+		t.Run(encoding, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.Contains(r.Header.Get("Accept-Encoding"), encoding) {
+					t.Errorf("the %s encoding is not accepted", encoding)
+				}
+				w.Header().Set("Content-Encoding", encoding)
+				_, _ = w.Write(body)
+			}))
+			defer server.Close()
+			var got []string
+			parser := BlockParser{Url: server.URL}
+			if err := parser.Feed(context.Background(), func(name string) { got = append(got, name) }); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Errorf("Feed() = %v, want one name", got)
+			}
+		})
+	}
+}
+
+func TestParsers_Cache(t *testing.T) {
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("0.0.0.0 ads.com\n"))
+	}))
+	parser := BlockParser{Url: server.URL, CacheDir: filepath.Join(t.TempDir(), "lists")}
+	feed := func() []string {
+		var got []string
+		if err := parser.Feed(context.Background(), func(name string) { got = append(got, name) }); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	for _, step := range []string{"download", "not modified", "unreachable"} {
+		if step == "unreachable" {
+			server.Close()
+		}
+		if got := feed(); !reflect.DeepEqual(got, []string{"ads.com"}) {
+			t.Errorf("%s: Feed() = %v, want [ads.com]", step, got)
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("expecting the list to be downloaded once, got %d downloads", downloads)
+	}
+}
+
+func TestParsers_Status(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	parser := BlockParser{Url: server.URL}
+	if err := parser.Feed(context.Background(), func(name string) { t.Errorf("unexpected name %s", name) }); err == nil {
+		t.Errorf("expecting an error for a missing list")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	for source, valid := range map[string]bool{
+		serve(t, "ads.com"): true,
+		missing.URL:         false,
+		t.TempDir():         true,
+		"file://" + filepath.Join(t.TempDir(), "no"): false,
+	} {
+		if err := Check(context.Background(), source); (err == nil) != valid {
+			t.Errorf("Check(%s) = %v, want valid %v", source, err, valid)
+		}
+	}
+}
+
+func TestParsers_Cancel(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close() // the list is unreachable, its download is retried until cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		parser := BlockParser{Url: server.URL}
+		done <- parser.Feed(ctx, func(string) {})
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Feed() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(retryDelay):
+		t.Fatalf("the download should stop once cancelled")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"": Auto, "auto": Auto, "Hosts": Hosts, "domains": Domains, "adblock": Adblock} {
+		if got, err := ParseFormat(name); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("rpz"); err == nil {
+		t.Errorf("expecting an error for an unknown format")
+	}
+}
@@ -0,0 +1,202 @@
+package blockparser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// download calls parse with every line of the list at url. With a cache directory, the list is only downloaded again
+// when it changed since its cached copy, which is read when the source is unreachable. Without cached copy,
+// the download is retried until the list is reachable or ctx is done
+func download(ctx context.Context, url, cacheDir string, parse func(line string)) error {
+	cache := listCache{dir: cacheDir, url: url}
+	version, cached := cache.version()
+	resp, err := get(ctx, url, version)
+	for err != nil {
+		if cached {
+			logger.Warn("cannot download the blocking list, reading its cached copy", "url", url, "err", err)
+			return cache.read(parse)
+		}
+		logger.Warn("cannot download the blocking list, retrying", "url", url, "err", err, "delay", retryDelay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryDelay):
+		}
+		resp, err = get(ctx, url, version)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		return cache.read(parse)
+	case resp.StatusCode != http.StatusOK && cached:
+		logger.Warn("cannot download the blocking list, reading its cached copy", "url", url, "status", resp.Status)
+		return cache.read(parse)
+	case resp.StatusCode != http.StatusOK:
+		return errors.New("cannot download the blocking list " + url + ": " + resp.Status)
+	}
+	body, err := decompress(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if cacheDir == "" {
+		// the download is interrupted once ctx is done
+		return scan(body, parse)
+	}
+	return cache.store(body, listVersion{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, parse)
+}
+
+// reachable returns an error when the list at url cannot be downloaded, its body is not read
+func reachable(ctx context.Context, url string) error {
+	resp, err := get(ctx, url, listVersion{})
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("cannot download the blocking list " + url + ": " + resp.Status)
+	}
+	return nil
+}
+
+// get requests the list, compressed with gzip or zstd when the server supports them, and only when it changed since
+// the version when known
+func get(ctx context.Context, url string, version listVersion) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the transport no longer decompresses the body once the encodings are set, decompress does
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+	if version.ETag != "" {
+		req.Header.Set("If-None-Match", version.ETag)
+	}
+	if version.LastModified != "" {
+		req.Header.Set("If-Modified-Since", version.LastModified)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// decompress returns the text of a list compressed with gzip or zstd, by the server or as a .gz or .zst file,
+// the list itself otherwise
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
+
+// listVersion identifies the version of a downloaded list, for the conditional requests
+type listVersion struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// listCache is the copy of a downloaded list in the cache directory, decompressed, with its version
+type listCache struct {
+	dir string
+	url string
+}
+
+// path returns the path of the copy, named after the url
+func (c listCache) path() string {
+	hash := sha256.Sum256([]byte(c.url))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:12])+".list")
+}
+
+// version returns the version of the copy, false when the list has no copy
+func (c listCache) version() (listVersion, bool) {
+	var version listVersion
+	if c.dir == "" {
+		return version, false
+	}
+	if _, err := os.Stat(c.path()); err != nil {
+		return version, false
+	}
+	if data, err := os.ReadFile(c.path() + ".json"); err == nil {
+		_ = json.Unmarshal(data, &version)
+	}
+	return version, true
+}
+
+// read calls parse with every line of the copy
+func (c listCache) read(parse func(line string)) error {
+	return readFile(c.path(), parse)
+}
+
+// store calls parse with every line of body while copying it, the copy replaces the previous one once body is read
+// entirely. The list is still read when it cannot be copied
+func (c listCache) store(body io.Reader, version listVersion, parse func(line string)) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		logger.Warn("cannot create the cache of the blocking lists", "dir", c.dir, "err", err)
+		return scan(body, parse)
+	}
+	tmp, err := os.CreateTemp(c.dir, "*.tmp")
+	if err != nil {
+		logger.Warn("cannot cache the blocking list", "url", c.url, "err", err)
+		return scan(body, parse)
+	}
+	defer os.Remove(tmp.Name()) // nothing to remove once renamed
+	copied := &copyWriter{w: tmp}
+	if err := scan(io.TeeReader(body, copied), parse); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = copied.err
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path())
+	}
+	if err != nil {
+		logger.Warn("cannot cache the blocking list", "url", c.url, "err", err)
+		return nil
+	}
+	data, _ := json.Marshal(version)
+	if err := os.WriteFile(c.path()+".json", data, 0o644); err != nil {
+		logger.Warn("cannot cache the version of the blocking list", "url", c.url, "err", err)
+	}
+	return nil
+}
+
+// copyWriter writes to w until it fails, without failing the reading of the list
+type copyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (c *copyWriter) Write(p []byte) (int, error) {
+	if c.err == nil {
+		_, c.err = c.w.Write(p)
+	}
+	return len(p), nil
+}
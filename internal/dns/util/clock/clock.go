@@ -0,0 +1,148 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ Clock = Real{}
+var _ Clock = &Fake{}
+
+// Clock gives the time to the components depending on it, so they can be tested without waiting
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers the ticks of a clock, see time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the system
+type Real struct{}
+
+// Now implements Clock
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker implements Clock
+func (Real) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// Fake is a clock which only moves when told to, it is safe for concurrent use
+type Fake struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFake instantiate a fake clock starting at now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now implements Clock
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// NewTicker implements Clock, the ticker fires when the clock is advanced past its period
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	t := &fakeTicker{c: make(chan time.Time), period: d, next: f.now.Add(d), stop: make(chan struct{})}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, one step per tick due in between.
+// Each tick is delivered before Advance returns, unless its ticker is stopped, so a single
+// goroutine reading a ticker has handled all the previous ticks once Advance delivers a new one
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	end := f.now.Add(d)
+	f.lock.Unlock()
+	for {
+		f.lock.Lock()
+		t := f.nextTicker(end)
+		if t == nil {
+			f.now = end
+			f.lock.Unlock()
+			return
+		}
+		f.now = t.next
+		t.next = t.next.Add(t.period)
+		now := f.now
+		f.lock.Unlock()
+
+		select {
+		case t.c <- now:
+		case <-t.stop:
+		}
+	}
+}
+
+// nextTicker returns the running ticker firing first before end, or nil
+func (f *Fake) nextTicker(end time.Time) *fakeTicker {
+	running := f.tickers[:0]
+	for _, t := range f.tickers {
+		if !t.stopped() {
+			running = append(running, t)
+		}
+	}
+	f.tickers = running
+	sort.SliceStable(f.tickers, func(i, j int) bool {
+		return f.tickers[i].next.Before(f.tickers[j].next)
+	})
+	if len(f.tickers) == 0 || f.tickers[0].next.After(end) {
+		return nil
+	}
+	return f.tickers[0]
+}
+
+type fakeTicker struct {
+	c        chan time.Time
+	period   time.Duration
+	next     time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+func (t *fakeTicker) stopped() bool {
+	select {
+	case <-t.stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,42 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Advance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Minute)
+
+	ticks := make(chan time.Time, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for tick := range ticker.C() {
+			ticks <- tick
+			if len(ticks) == 3 {
+				return
+			}
+		}
+	}()
+
+	f.Advance(30 * time.Second)
+	if len(ticks) != 0 {
+		t.Fatalf("no tick expected before the period")
+	}
+	f.Advance(150 * time.Second)
+	<-done
+	ticker.Stop()
+	f.Advance(time.Hour) // must not block on the stopped ticker
+
+	if got := f.Now(); !got.Equal(start.Add(time.Hour + 3*time.Minute)) {
+		t.Errorf("unexpected time %v", got)
+	}
+	for i := 1; i <= 3; i++ {
+		if tick := <-ticks; !tick.Equal(start.Add(time.Duration(i) * time.Minute)) {
+			t.Errorf("unexpected tick %d at %v", i, tick)
+		}
+	}
+}
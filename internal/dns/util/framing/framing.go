@@ -0,0 +1,29 @@
+package framing
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const lengthSize = 2
+
+// Read reads one dns message prefixed by its length on two bytes, as sent over tcp (rfc1035 section 4.2.2)
+func Read(r io.Reader) ([]byte, error) {
+	length := make([]byte, lengthSize)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(r, buffer); err != nil {
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// Write writes one dns message prefixed by its length on two bytes, in a single write
+func Write(w io.Writer, payload []byte) error {
+	buffer := make([]byte, lengthSize, lengthSize+len(payload))
+	binary.BigEndian.PutUint16(buffer, uint16(len(payload)))
+	_, err := w.Write(append(buffer, payload...))
+	return err
+}
@@ -0,0 +1,147 @@
+// Package lifecycle runs the background components of the server, like its endpoints, the collection of its cache
+// or the refresh of its blocking lists, and stops them in order: the components serving the clients first, the ones
+// holding their data last. A component adds its goroutines to the wait group it is started with, so the function
+// starting a goroutine is always the one adding it
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stage orders the shutdown, the components of the later stages are stopped first
+type Stage int
+
+const (
+	// Storage components hold the data used by the other ones, like the cache
+	Storage Stage = iota
+	// Processing components run the background tasks of the resolution, like the refresh of the blocking lists
+	Processing
+	// Serving components answer the clients, like the endpoints
+	Serving
+)
+
+// StartFunc starts a component running until ctx is done, it adds its goroutines to wg before starting them.
+// A component failing to start returns an error, the goroutines it already started must stop once ctx is done
+type StartFunc func(ctx context.Context, wg *sync.WaitGroup) error
+
+// ErrStopped is returned when a component is started by a stopped manager
+var ErrStopped = errors.New("lifecycle manager is stopped")
+
+// Component is a component started by a manager
+type Component struct {
+	name   string
+	stage  Stage
+	order  int
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+	// done is closed once the component is stopped and its goroutines are over
+	done chan struct{}
+}
+
+// Name returns the name of the component
+func (c *Component) Name() string {
+	return c.name
+}
+
+// Stop cancels the context of the component without waiting for its goroutines, see Wait
+func (c *Component) Stop() {
+	c.cancel()
+}
+
+// Wait waits for the goroutines of the stopped component
+func (c *Component) Wait() {
+	<-c.done
+}
+
+// Manager starts the components and stops them by stage, it is safe for concurrent use
+type Manager struct {
+	lock       sync.Mutex
+	components map[*Component]struct{}
+	started    int
+	stopped    bool
+	// done is closed once the manager is stopped
+	done chan struct{}
+}
+
+// NewManager instantiate a manager without component
+func NewManager() *Manager {
+	return &Manager{components: make(map[*Component]struct{}), done: make(chan struct{})}
+}
+
+// Start starts a component in the stage, it runs until it is stopped, by itself or by the manager.
+// The component failing to start is stopped and its error returned once its goroutines are over, so the resources it
+// holds, as the addresses it listens on, are released
+func (m *Manager) Start(name string, stage Stage, start StartFunc) (*Component, error) {
+	m.lock.Lock()
+	if m.stopped {
+		m.lock.Unlock()
+		return nil, ErrStopped
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Component{name: name, stage: stage, order: m.started, cancel: cancel, wg: &sync.WaitGroup{}, done: make(chan struct{})}
+	m.started++
+	m.components[c] = struct{}{}
+	m.lock.Unlock()
+
+	go m.watch(ctx, c)
+	if err := start(ctx, c.wg); err != nil {
+		c.Stop()
+		c.Wait()
+		return nil, errors.New("cannot start " + name + ": " + err.Error())
+	}
+	return c, nil
+}
+
+// watch forgets the component once it is stopped and its goroutines are over
+func (m *Manager) watch(ctx context.Context, c *Component) {
+	<-ctx.Done()
+	c.wg.Wait()
+	m.lock.Lock()
+	delete(m.components, c)
+	m.lock.Unlock()
+	close(c.done)
+}
+
+// Stop stops the components, the ones of the later stages first and the ones of a stage in the reverse order of their
+// start. Each component is waited for up to timeout, the error names the components still running after it.
+// The manager no longer starts components once stopped
+func (m *Manager) Stop(timeout time.Duration) error {
+	m.lock.Lock()
+	if m.stopped {
+		m.lock.Unlock()
+		return nil
+	}
+	m.stopped = true
+	components := make([]*Component, 0, len(m.components))
+	for c := range m.components {
+		components = append(components, c)
+	}
+	m.lock.Unlock()
+	defer close(m.done)
+
+	sort.Slice(components, func(i, j int) bool {
+		if components[i].stage != components[j].stage {
+			return components[i].stage > components[j].stage
+		}
+		return components[i].order > components[j].order
+	})
+	var errs []error
+	for _, c := range components {
+		c.Stop()
+		select {
+		case <-c.done:
+		case <-time.After(timeout):
+			errs = append(errs, errors.New(c.name+" still running after "+timeout.String()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Done returns a channel closed once the manager is stopped
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
@@ -0,0 +1,128 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder starts components appending their name to the stopped ones once their context is done
+type recorder struct {
+	lock    sync.Mutex
+	stopped []string
+}
+
+func (r *recorder) start(name string) StartFunc {
+	return func(ctx context.Context, wg *sync.WaitGroup) error {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ctx.Done()
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			r.stopped = append(r.stopped, name)
+		}()
+		return nil
+	}
+}
+
+func TestManager_Stop(t *testing.T) {
+	r := &recorder{}
+	m := NewManager()
+	for _, c := range []struct {
+		name  string
+		stage Stage
+	}{
+		{"cache", Storage},
+		{"chain", Processing},
+		{"endpoints", Serving},
+		{"reloaded chain", Processing},
+		{"admin", Serving},
+	} {
+		if _, err := m.Start(c.name, c.stage, r.start(c.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Stop(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"admin", "endpoints", "reloaded chain", "chain", "cache"}
+	if !reflect.DeepEqual(r.stopped, want) {
+		t.Errorf("stopped %v, want %v", r.stopped, want)
+	}
+	select {
+	case <-m.Done():
+	default:
+		t.Errorf("the manager should be done once stopped")
+	}
+	if _, err := m.Start("late", Serving, r.start("late")); !errors.Is(err, ErrStopped) {
+		t.Errorf("expecting ErrStopped, got %v", err)
+	}
+}
+
+func TestManager_StopComponent(t *testing.T) {
+	r := &recorder{}
+	m := NewManager()
+	old, err := m.Start("chain", Processing, r.start("chain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Stop()
+	old.Wait()
+	if !reflect.DeepEqual(r.stopped, []string{"chain"}) {
+		t.Errorf("the component should be stopped alone, got %v", r.stopped)
+	}
+	if err := m.Stop(time.Second); err != nil || len(r.stopped) != 1 {
+		t.Errorf("expecting nothing left to stop, got %v, %v", r.stopped, err)
+	}
+}
+
+func TestManager_StartError(t *testing.T) {
+	m := NewManager()
+	started, released := make(chan struct{}), make(chan struct{})
+	_, err := m.Start("endpoint", Serving, func(ctx context.Context, wg *sync.WaitGroup) error {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			close(started)
+			<-ctx.Done()
+			close(released)
+		}()
+		return errors.New("address already in use")
+	})
+	if err == nil {
+		t.Fatal("expecting the error of the component")
+	}
+	<-started
+	select {
+	case <-released:
+	default:
+		t.Errorf("the goroutines of the failed component should be over once Start returns")
+	}
+	if err := m.Stop(time.Second); err != nil {
+		t.Errorf("the failed component should have been stopped: %v", err)
+	}
+}
+
+func TestManager_StopTimeout(t *testing.T) {
+	m := NewManager()
+	release := make(chan struct{})
+	defer close(release)
+	_, err := m.Start("stuck", Processing, func(ctx context.Context, wg *sync.WaitGroup) error {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-release
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(10 * time.Millisecond); err == nil {
+		t.Errorf("expecting an error naming the stuck component")
+	}
+}
@@ -0,0 +1,132 @@
+// Package logging provides the leveled, structured loggers of the components, built on log/slog.
+// The loggers can be created before the configuration is read: the output, the format and the levels
+// set by Configure apply to all of them, including the ones already in use
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// setup is the output and the levels shared by the loggers
+type setup struct {
+	output slog.Handler
+	level  slog.Level
+	// components are the levels of the components differing from level
+	components map[string]slog.Level
+}
+
+func (s *setup) levelOf(component string) slog.Level {
+	if level, ok := s.components[component]; ok {
+		return level
+	}
+	return s.level
+}
+
+var current atomic.Pointer[setup]
+
+func init() {
+	current.Store(&setup{output: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}), level: slog.LevelInfo})
+}
+
+// ParseLevel returns the level of its name: debug, info, warn or error, info when empty
+func ParseLevel(name string) (slog.Level, error) {
+	var res slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := res.UnmarshalText([]byte(name)); err != nil {
+		return res, errors.New("unknown log level " + name + ", expecting debug, info, warn or error")
+	}
+	return res, nil
+}
+
+// Check returns an error when the format or one of the levels is unknown
+func Check(format, level string, components map[string]string) error {
+	_, err := newSetup(io.Discard, format, level, components)
+	return err
+}
+
+// Configure replaces the output of all the loggers: w with the format, text or json (text when empty),
+// the records under level are discarded, or under the level of their component in components.
+// The standard log package writes to the same output at the info level
+func Configure(w io.Writer, format, level string, components map[string]string) error {
+	res, err := newSetup(w, format, level, components)
+	if err != nil {
+		return err
+	}
+	current.Store(res)
+	slog.SetDefault(slog.New(&handler{with: identity}))
+	return nil
+}
+
+func newSetup(w io.Writer, format, level string, components map[string]string) (*setup, error) {
+	res := &setup{components: make(map[string]slog.Level, len(components))}
+	var err error
+	if res.level, err = ParseLevel(level); err != nil {
+		return nil, err
+	}
+	for component, name := range components {
+		if res.components[component], err = ParseLevel(name); err != nil {
+			return nil, err
+		}
+	}
+	// the levels are checked by the loggers, the output writes everything it receives
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch strings.ToLower(format) {
+	case "", "text":
+		res.output = slog.NewTextHandler(w, opts)
+	case "json":
+		res.output = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, errors.New("unknown log format " + format + ", expecting text or json")
+	}
+	return res, nil
+}
+
+// Component returns the logger of a component, its records carry its name
+func Component(name string) *slog.Logger {
+	return slog.New(&handler{component: name, with: func(h slog.Handler) slog.Handler {
+		return h.WithAttrs([]slog.Attr{slog.String("component", name)})
+	}})
+}
+
+func identity(h slog.Handler) slog.Handler {
+	return h
+}
+
+// handler sends the records of a component to the current output when its level is enabled
+type handler struct {
+	component string
+	// with adds the attributes and the groups of the logger to the output
+	with func(slog.Handler) slog.Handler
+}
+
+// Enabled implements slog.Handler
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= current.Load().levelOf(h.component)
+}
+
+// Handle implements slog.Handler
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.with(current.Load().output).Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{component: h.component, with: func(output slog.Handler) slog.Handler {
+		return h.with(output).WithAttrs(attrs)
+	}}
+}
+
+// WithGroup implements slog.Handler
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{component: h.component, with: func(output slog.Handler) slog.Handler {
+		return h.with(output).WithGroup(name)
+	}}
+}
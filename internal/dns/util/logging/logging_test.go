@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestConfigure(t *testing.T) {
+	// the loggers are usually created before the configuration is read
+	cache := Component("memorycache")
+	endpoint := Component("udpendpoint").With("address", "127.0.0.1:53")
+
+	var out bytes.Buffer
+	if err := Configure(&out, "json", "info", map[string]string{"memorycache": "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	cache.Info("GC cleared the expired entries")
+	cache.Error("cache is full")
+	endpoint.Debug("message received")
+	endpoint.Info("starting udp endpoint")
+	log.Println("standard log")
+
+	var got []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid json %s: %v", line, err)
+		}
+		got = append(got, record)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expecting 3 records, got %s", out.String())
+	}
+	if got[0]["msg"] != "cache is full" || got[0]["level"] != "ERROR" || got[0]["component"] != "memorycache" {
+		t.Errorf("unexpected cache record %v", got[0])
+	}
+	if got[1]["msg"] != "starting udp endpoint" || got[1]["component"] != "udpendpoint" || got[1]["address"] != "127.0.0.1:53" {
+		t.Errorf("unexpected endpoint record %v", got[1])
+	}
+	if got[2]["msg"] != "standard log" {
+		t.Errorf("expecting the standard log to use the same output, got %v", got[2])
+	}
+}
+
+func TestConfigure_invalid(t *testing.T) {
+	var out bytes.Buffer
+	if err := Configure(&out, "xml", "info", nil); err == nil {
+		t.Errorf("expecting an error for an unknown format")
+	}
+	if err := Configure(&out, "text", "verbose", nil); err == nil {
+		t.Errorf("expecting an error for an unknown level")
+	}
+	if err := Configure(&out, "text", "", map[string]string{"resolver": "trace"}); err == nil {
+		t.Errorf("expecting an error for an unknown component level")
+	}
+}
@@ -0,0 +1,171 @@
+package toml
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encode writes the tables as a document, the values are the ones of a JSON document decoded into any,
+// preferably with json.Number so the integers are not written as floats. The keys are sorted
+func Encode(tables map[string]any) ([]byte, error) {
+	var b strings.Builder
+	if err := encodeTable(&b, nil, tables); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// encodeTable writes the values of the table at path, then its tables and its arrays of tables
+func encodeTable(b *strings.Builder, path []string, m map[string]any) error {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	var tables []string
+	for _, key := range keys {
+		if isTable(m[key]) || isTableArray(m[key]) {
+			tables = append(tables, key)
+			continue
+		}
+		b.WriteString(quoteKey(key) + " = ")
+		if err := encodeValue(b, m[key]); err != nil {
+			return errors.New(strings.Join(append(path, key), ".") + ": " + err.Error())
+		}
+		b.WriteByte('\n')
+	}
+	for _, key := range tables {
+		sub := append(slices.Clip(path), key)
+		if table, ok := m[key].(map[string]any); ok {
+			b.WriteString("\n[" + joinKeys(sub) + "]\n")
+			if err := encodeTable(b, sub, table); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, element := range m[key].([]any) {
+			b.WriteString("\n[[" + joinKeys(sub) + "]]\n")
+			if err := encodeTable(b, sub, element.(map[string]any)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isTable(v any) bool {
+	_, ok := v.(map[string]any)
+	return ok
+}
+
+// isTableArray tells if v is a non empty array of tables, written as an array of tables
+func isTableArray(v any) bool {
+	array, ok := v.([]any)
+	if !ok || len(array) == 0 {
+		return false
+	}
+	for _, element := range array {
+		if !isTable(element) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeValue writes a value on one line, the tables as inline tables
+func encodeValue(b *strings.Builder, v any) error {
+	switch v := v.(type) {
+	case string:
+		b.WriteString(quote(v))
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		b.WriteString(v.String())
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case []any:
+		b.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if err := encodeValue(b, element); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		b.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(quoteKey(key) + " = ")
+			if err := encodeValue(b, v[key]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	case nil:
+		return errors.New("TOML has no null value")
+	default:
+		return errors.New("unsupported value")
+	}
+	return nil
+}
+
+func joinKeys(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = quoteKey(key)
+	}
+	return strings.Join(quoted, ".")
+}
+
+// quoteKey returns the key bare when possible
+func quoteKey(key string) string {
+	for i := 0; i < len(key); i++ {
+		if !isBare(key[i]) {
+			return quote(key)
+		}
+	}
+	if key == "" {
+		return quote(key)
+	}
+	return key
+}
+
+// quote returns the basic string of s, its control characters escaped
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			code := strconv.FormatInt(int64(r), 16)
+			b.WriteString(`\u` + strings.Repeat("0", 4-len(code)) + code)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
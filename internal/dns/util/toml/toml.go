@@ -0,0 +1,425 @@
+// Package toml reads and writes the TOML documents whose values have a JSON equivalent: the tables, the arrays,
+// the strings, the numbers and the booleans. The dates are read as strings
+package toml
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Decode returns the tables of the document as maps, its integers as int64 and its floats as float64
+func Decode(data []byte) (map[string]any, error) {
+	p := &parser{data: string(data), line: 1}
+	root := make(map[string]any)
+	current := root
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return root, nil
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(p.rest(), "[["):
+			p.pos += 2
+			current, err = p.header(root, "]]", true)
+		case p.peek() == '[':
+			p.pos++
+			current, err = p.header(root, "]", false)
+		default:
+			err = p.keyValue(current)
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, errors.New("line " + strconv.Itoa(p.line) + ": " + err.Error())
+		}
+	}
+}
+
+// parser reads a document from pos, line is the line of pos
+type parser struct {
+	data string
+	pos  int
+	line int
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *parser) rest() string {
+	return p.data[p.pos:]
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+// skipBlank skips the spaces and the comments, and the new lines when multiline
+func (p *parser) skipBlank(multiline bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && multiline:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine checks nothing but a comment follows a header or a key value pair on its line
+func (p *parser) endOfLine() error {
+	p.skipBlank(false)
+	if !p.eof() && p.peek() != '\n' {
+		return errors.New("unexpected " + strconv.Quote(p.token()) + " at the end of the line")
+	}
+	return nil
+}
+
+// token returns the text from pos up to the next blank, for the errors
+func (p *parser) token() string {
+	end := strings.IndexAny(p.rest(), " \t\r\n")
+	if end < 0 {
+		return p.rest()
+	}
+	return p.rest()[:end]
+}
+
+// expect skips the blanks before s, which must follow
+func (p *parser) expect(s string) error {
+	p.skipBlank(false)
+	if !strings.HasPrefix(p.rest(), s) {
+		return errors.New("expecting " + strconv.Quote(s) + ", got " + strconv.Quote(p.token()))
+	}
+	p.pos += len(s)
+	return nil
+}
+
+// header reads the key of a table, or of an array of tables whose new table is returned
+func (p *parser) header(root map[string]any, end string, array bool) (map[string]any, error) {
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(end); err != nil {
+		return nil, err
+	}
+	parent, err := table(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	if !array {
+		return table(parent, []string{last})
+	}
+	tables, ok := parent[last].([]any)
+	if _, exists := parent[last]; exists && !ok {
+		return nil, errors.New(last + " is not an array of tables")
+	}
+	res := make(map[string]any)
+	parent[last] = append(tables, res)
+	return res, nil
+}
+
+// table returns the table at the keys from m, created when missing. The last table of an array of tables is the one
+// of its key
+func table(m map[string]any, keys []string) (map[string]any, error) {
+	for _, key := range keys {
+		switch v := m[key].(type) {
+		case nil:
+			next := make(map[string]any)
+			m[key] = next
+			m = next
+		case map[string]any:
+			m = v
+		case []any:
+			last, ok := v[len(v)-1].(map[string]any)
+			if !ok {
+				return nil, errors.New(key + " is not a table")
+			}
+			m = last
+		default:
+			return nil, errors.New(key + " is not a table")
+		}
+	}
+	return m, nil
+}
+
+// keyValue reads a key value pair into m
+func (p *parser) keyValue(m map[string]any) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := table(m, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, ok := parent[last]; ok {
+		return errors.New(strings.Join(keys, ".") + " is defined twice")
+	}
+	parent[last] = value
+	return nil
+}
+
+// key reads a key, its dotted parts being bare or quoted
+func (p *parser) key() ([]string, error) {
+	var res []string
+	for {
+		p.skipBlank(false)
+		var part string
+		switch p.peek() {
+		case '"', '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for !p.eof() && isBare(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, errors.New("expecting a key, got " + strconv.Quote(p.token()))
+			}
+			part = p.data[start:p.pos]
+		}
+		res = append(res, part)
+		p.skipBlank(false)
+		if p.peek() != '.' {
+			return res, nil
+		}
+		p.pos++
+	}
+}
+
+func isBare(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// value reads a value after the blanks
+func (p *parser) value() (any, error) {
+	p.skipBlank(false)
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case strings.HasPrefix(p.rest(), "true"):
+		p.pos += 4
+		return true, nil
+	case strings.HasPrefix(p.rest(), "false"):
+		p.pos += 5
+		return false, nil
+	}
+	return p.number()
+}
+
+// array reads the values between brackets, on one or several lines
+func (p *parser) array() ([]any, error) {
+	p.pos++
+	res := make([]any, 0)
+	for {
+		p.skipBlank(true)
+		if p.peek() == ']' {
+			p.pos++
+			return res, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, value)
+		p.skipBlank(true)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, errors.New("expecting , or ] in the array, got " + strconv.Quote(p.token()))
+		}
+	}
+}
+
+// inlineTable reads the key value pairs between braces, on one line
+func (p *parser) inlineTable() (map[string]any, error) {
+	p.pos++
+	res := make(map[string]any)
+	p.skipBlank(false)
+	if p.peek() == '}' {
+		p.pos++
+		return res, nil
+	}
+	for {
+		if err := p.keyValue(res); err != nil {
+			return nil, err
+		}
+		p.skipBlank(false)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return res, nil
+		default:
+			return nil, errors.New("expecting , or } in the inline table, got " + strconv.Quote(p.token()))
+		}
+	}
+}
+
+// str reads a basic or a literal string, on one or several lines
+func (p *parser) str() (string, error) {
+	quote := p.data[p.pos : p.pos+1]
+	if strings.HasPrefix(p.rest(), quote+quote+quote) {
+		p.pos += 3
+		// a new line following the opening delimiter is trimmed
+		if strings.HasPrefix(p.rest(), "\r\n") {
+			p.pos += 2
+			p.line++
+		} else if p.peek() == '\n' {
+			p.pos++
+			p.line++
+		}
+		return p.until(quote+quote+quote, quote == `"`, true)
+	}
+	p.pos++
+	return p.until(quote, quote == `"`, false)
+}
+
+// until reads the characters before the delimiter, decoding the escapes of the basic strings
+func (p *parser) until(delimiter string, escapes, multiline bool) (string, error) {
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", errors.New("unterminated string")
+		}
+		if strings.HasPrefix(p.rest(), delimiter) {
+			p.pos += len(delimiter)
+			return b.String(), nil
+		}
+		c := p.peek()
+		switch {
+		case c == '\n' && !multiline:
+			return "", errors.New("unterminated string")
+		case c == '\n':
+			p.line++
+		case c == '\\' && escapes:
+			if err := p.escape(&b, multiline); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+}
+
+// escape decodes the escape sequence at pos
+func (p *parser) escape(b *strings.Builder, multiline bool) error {
+	p.pos++
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if len(p.rest()) < size {
+			return errors.New("truncated unicode escape")
+		}
+		code, err := strconv.ParseUint(p.rest()[:size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return errors.New("invalid unicode escape \\" + string(c) + p.rest()[:size])
+		}
+		b.WriteRune(rune(code))
+		p.pos += size
+	case ' ', '\t', '\r', '\n':
+		if !multiline {
+			return errors.New("invalid escape")
+		}
+		// a backslash ending a line trims the blanks up to the next character
+		for p.pos--; !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0; p.pos++ {
+			if p.peek() == '\n' {
+				p.line++
+			}
+		}
+	default:
+		return errors.New("invalid escape \\" + string(c))
+	}
+	return nil
+}
+
+// number reads an integer, a float, or a date kept as a string
+func (p *parser) number() (any, error) {
+	start := p.pos
+	for !p.eof() && strings.IndexByte("0123456789abcdefABCDEFoxinTZ_+-.:", p.peek()) >= 0 {
+		p.pos++
+	}
+	token := p.data[start:p.pos]
+	if token == "" {
+		return nil, errors.New("expecting a value, got " + strconv.Quote(p.token()))
+	}
+	switch strings.TrimLeft(token, "+-") {
+	case "inf":
+		if token[0] == '-' {
+			return math.Inf(-1), nil
+		}
+		return math.Inf(1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	if strings.ContainsAny(token, ":T") || strings.Count(token, "-") >= 2 && !strings.ContainsAny(token, "eE") {
+		return token, nil
+	}
+	digits := strings.ReplaceAll(token, "_", "")
+	if unsigned := strings.TrimLeft(digits, "+-"); len(unsigned) > 1 && unsigned[0] == '0' && unsigned[1] >= '0' && unsigned[1] <= '9' {
+		return nil, errors.New("invalid value " + strconv.Quote(token) + ", the numbers cannot have leading zeros")
+	}
+	if i, err := strconv.ParseInt(digits, 0, 64); err == nil {
+		return i, nil
+	}
+	if !strings.HasPrefix(digits, "0x") {
+		if f, err := strconv.ParseFloat(digits, 64); err == nil {
+			return f, nil
+		}
+	}
+	return nil, errors.New("invalid value " + strconv.Quote(token))
+}
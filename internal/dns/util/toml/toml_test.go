@@ -0,0 +1,128 @@
+package toml
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const document = `# configuration
+allow_external = true
+blocking_list = [
+  "https://example.org/hosts", # the main list
+  'C:\lists\local.txt',
+]
+blocking_list_refresh = 86_400
+
+[cache]
+basettl = 600
+auto_tune = { enabled = false, max_size = 1e7 }
+
+[external]
+type = "DOH"
+endpoint = "https://cloudflare-dns.com/dns-query"
+
+[[custom]]
+name = "printer.lan"
+address = "192.168.1.20"
+
+[[custom]]
+name = "nas.lan"
+address = "192.168.1.21"
+
+[custom.note]
+text = """
+first\tline
+second"""
+
+[log.components]
+"memory cache" = "debug"
+`
+
+func TestDecode(t *testing.T) {
+	got, err := Decode([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"allow_external":        true,
+		"blocking_list":         []any{"https://example.org/hosts", `C:\lists\local.txt`},
+		"blocking_list_refresh": int64(86400),
+		"cache": map[string]any{
+			"basettl":   int64(600),
+			"auto_tune": map[string]any{"enabled": false, "max_size": 1e7},
+		},
+		"external": map[string]any{"type": "DOH", "endpoint": "https://cloudflare-dns.com/dns-query"},
+		"custom": []any{
+			map[string]any{"name": "printer.lan", "address": "192.168.1.20"},
+			map[string]any{"name": "nas.lan", "address": "192.168.1.21", "note": map[string]any{"text": "first\tline\nsecond"}},
+		},
+		"log": map[string]any{"components": map[string]any{"memory cache": "debug"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %v, want %v", got, want)
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"duplicate", "a = 1\na = 2", "line 2: a is defined twice"},
+		{"unterminated", "a = \"b\nc = 1", "line 1: unterminated string"},
+		{"trailing", "a = 1 b", `line 1: unexpected "b" at the end of the line`},
+		{"bare value", "a = yes", `line 1: expecting a value, got "yes"`},
+		{"leading zero", "a = 012", "line 1: invalid value \"012\", the numbers cannot have leading zeros"},
+		{"table of a value", "a = 1\n[a.b]", "line 2: a is not a table"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode([]byte(tt.data)); err == nil || err.Error() != tt.want {
+				t.Errorf("Decode() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(`{"allow_external": true, "custom": [{"name": "a.lan", "address": "10.0.0.1"}],
+		"cache": {"size": 1000000, "auto_tune": {"enabled": false}}, "rewrites": [], "zone": {"a\"b": "x\ny"}}`))
+	decoder.UseNumber()
+	var tables map[string]any
+	if err := decoder.Decode(&tables); err != nil {
+		t.Fatal(err)
+	}
+	data, err := Encode(tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `allow_external = true
+rewrites = []
+
+[cache]
+size = 1000000
+
+[cache.auto_tune]
+enabled = false
+
+[[custom]]
+address = "10.0.0.1"
+name = "a.lan"
+
+[zone]
+"a\"b" = "x\ny"
+`
+	if string(data) != want {
+		t.Errorf("Encode() = %s, want %s", data, want)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded["cache"].(map[string]any)["size"] != int64(1000000) {
+		t.Errorf("expecting the encoded document to be decoded, got %v", decoded)
+	}
+}
@@ -0,0 +1,122 @@
+// Package zonefile writes records in the master file format of rfc1035 section 5, read by BIND, unbound or knot,
+// to replicate the local names into another server or keep an auditable copy of them
+package zonefile
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+const (
+	// nameServer is the authoritative server named in the SOA and NS records of the zone, outside of it so it needs no address
+	nameServer = "localhost."
+	// the refresh, retry and expire of the SOA record, for the secondaries transferring the zone
+	refresh = 3600
+	retry   = 900
+	expire  = 604800
+)
+
+// Write writes the records to w. When origin is not empty, the SOA and NS records making the zone loadable
+// by an authoritative server are written first, with the serial, and the records outside of the origin are skipped
+func Write(w io.Writer, origin string, serial uint32, records []dto.Record) error {
+	writer := bufio.NewWriter(w)
+	origin = strings.ToLower(strings.TrimSuffix(origin, "."))
+
+	ttl := minTTL(records)
+	writer.WriteString("$TTL " + strconv.FormatUint(uint64(ttl), 10) + "\n")
+	if origin != "" {
+		writer.WriteString("$ORIGIN " + escape(origin) + ".\n")
+		soa := []string{nameServer, "hostmaster." + escape(origin) + "."}
+		for _, field := range []uint32{serial, refresh, retry, expire, ttl} {
+			soa = append(soa, strconv.FormatUint(uint64(field), 10))
+		}
+		writer.WriteString("@\tIN\tSOA\t" + strings.Join(soa, " ") + "\n")
+		writer.WriteString("@\tIN\tNS\t" + nameServer + "\n")
+	}
+	for _, record := range records {
+		if origin != "" && !inZone(record.Name, origin) {
+			continue
+		}
+		writer.WriteString(Line(record) + "\n")
+	}
+	return writer.Flush()
+}
+
+// Line returns the record in the master file format, with an absolute name
+func Line(record dto.Record) string {
+	return fqdn(escape(record.Name)) + "\t" + strconv.FormatUint(uint64(record.TTL), 10) + "\tIN\t" +
+		record.Type.String() + "\t" + value(record)
+}
+
+// value returns the data of the record with its names made absolute
+func value(record dto.Record) string {
+	v := record.Value()
+	if strings.HasPrefix(v, `\#`) {
+		return v
+	}
+	switch record.Type {
+	case dto.CNAME, dto.NS, dto.PTR:
+		return fqdn(escape(v))
+	case dto.MX, dto.SRV:
+		// the target is the last field
+		i := strings.LastIndexByte(v, ' ')
+		return v[:i+1] + fqdn(escape(v[i+1:]))
+	case dto.SOA:
+		fields := strings.Fields(v)
+		fields[0], fields[1] = fqdn(escape(fields[0])), fqdn(escape(fields[1]))
+		return strings.Join(fields, " ")
+	}
+	return v
+}
+
+// minTTL returns the smallest ttl of the records, the default ttl of the zone
+func minTTL(records []dto.Record) uint32 {
+	if len(records) == 0 {
+		return 0
+	}
+	res := records[0].TTL
+	for _, record := range records[1:] {
+		res = min(res, record.TTL)
+	}
+	return res
+}
+
+// inZone tells if the name is the lower case origin or one of its subdomains
+func inZone(name, origin string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return name == origin || strings.HasSuffix(name, "."+origin)
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// escape escapes the characters of the labels of the name which are special in a master file,
+// like the spaces of the DNS-SD instance names (rfc1035 section 5.1)
+func escape(name string) string {
+	var res strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c <= ' ' || c >= 0x7F:
+			res.WriteString(`\` + leftPad(strconv.Itoa(int(c))))
+		case strings.IndexByte(`;()"\@$`, c) >= 0:
+			res.WriteByte('\\')
+			res.WriteByte(c)
+		default:
+			res.WriteByte(c)
+		}
+	}
+	return res.String()
+}
+
+// leftPad pads the decimal value of a byte to the 3 digits of a \DDD escape
+func leftPad(digits string) string {
+	return strings.Repeat("0", 3-len(digits)) + digits
+}
@@ -0,0 +1,86 @@
+package zonefile
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/bluguard/dnshield/internal/dns/dto"
+)
+
+func record(t *testing.T, name string, rtype dto.Type, value string) dto.Record {
+	raw, err := dto.ParseRData(rtype, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dto.Record{Name: name, Type: rtype, Class: dto.IN, TTL: 200, Raw: raw}
+}
+
+func TestWrite(t *testing.T) {
+	records := []dto.Record{
+		{Name: "nas.lan", Type: dto.A, Class: dto.IN, TTL: 200, Data: net.ParseIP("192.168.1.10").To4()},
+		{Name: "nas.lan", Type: dto.AAAA, Class: dto.IN, TTL: 300, Data: net.ParseIP("fd00::10")},
+		record(t, "_ipp._tcp.lan", dto.PTR, "printer._ipp._tcp.lan"),
+		record(t, "printer._ipp._tcp.lan", dto.SRV, "0 0 631 nas.lan"),
+		record(t, "printer._ipp._tcp.lan", dto.TXT, `"rp=ipp/print"`),
+		{Name: "router.home", Type: dto.A, Class: dto.IN, TTL: 200, Data: net.ParseIP("192.168.1.1").To4()},
+	}
+	tests := []struct {
+		name   string
+		origin string
+		want   []string
+	}{
+		{
+			name: "without origin",
+			want: []string{
+				"$TTL 200",
+				"nas.lan.\t200\tIN\tA\t192.168.1.10",
+				"nas.lan.\t300\tIN\tAAAA\tfd00::10",
+				"_ipp._tcp.lan.\t200\tIN\tPTR\tprinter._ipp._tcp.lan.",
+				"printer._ipp._tcp.lan.\t200\tIN\tSRV\t0 0 631 nas.lan.",
+				"printer._ipp._tcp.lan.\t200\tIN\tTXT\t\"rp=ipp/print\"",
+				"router.home.\t200\tIN\tA\t192.168.1.1",
+			},
+		},
+		{
+			name:   "zone of the origin",
+			origin: "LAN.",
+			want: []string{
+				"$TTL 200",
+				"$ORIGIN lan.",
+				"@\tIN\tSOA\tlocalhost. hostmaster.lan. 42 3600 900 604800 200",
+				"@\tIN\tNS\tlocalhost.",
+				"nas.lan.\t200\tIN\tA\t192.168.1.10",
+				"nas.lan.\t300\tIN\tAAAA\tfd00::10",
+				"_ipp._tcp.lan.\t200\tIN\tPTR\tprinter._ipp._tcp.lan.",
+				"printer._ipp._tcp.lan.\t200\tIN\tSRV\t0 0 631 nas.lan.",
+				"printer._ipp._tcp.lan.\t200\tIN\tTXT\t\"rp=ipp/print\"",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := Write(&out, tt.origin, 42, records); err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Join(tt.want, "\n") + "\n"; out.String() != want {
+				t.Errorf("Write() =\n%s\nwant\n%s", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestLine_escape(t *testing.T) {
+	// the instance names of DNS-SD may contain spaces and special characters
+	instance := append([]byte{14}, "Office (Floor)"...)
+	target, err := dto.ParseRData(dto.PTR, "_ipp._tcp.lan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := dto.Record{Name: "_ipp._tcp.lan", Type: dto.PTR, TTL: 200, Raw: append(instance, target...)}
+	want := "_ipp._tcp.lan.\t200\tIN\tPTR\tOffice\\032\\(Floor\\)._ipp._tcp.lan."
+	if got := Line(r); got != want {
+		t.Errorf("Line() = %q, want %q", got, want)
+	}
+}